@@ -0,0 +1,158 @@
+// Command engine-replay backtests the governance engine against historical
+// delivery data. It feeds PMTA accounting files, or tracking events from the
+// database, through the agents on a virtual clock and prints every decision
+// that would have fired. Nothing is executed against PMTA.
+//
+//	engine-replay -acct /var/log/pmta/acct-2026-04-14.csv
+//	engine-replay -db -from 2026-04-14T00:00:00Z -to 2026-04-15T00:00:00Z -rules rules.json
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+)
+
+const defaultOrgID = "00000000-0000-0000-0000-000000000001"
+
+func main() {
+	acct := flag.String("acct", "", "comma-separated PMTA accounting CSV files")
+	useDB := flag.Bool("db", false, "read mailing_tracking_events (DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME)")
+	orgID := flag.String("org", defaultOrgID, "organization ID")
+	fromFlag := flag.String("from", "", "start of the replay window (RFC 3339)")
+	toFlag := flag.String("to", "", "end of the replay window (RFC 3339)")
+	rulesFile := flag.String("rules", "", "JSON array of rules to evaluate in shadow mode")
+	configsFile := flag.String("isp-config", "", "JSON array of ISP configs to use instead of the defaults")
+	tick := flag.Duration("tick", 10*time.Second, "virtual snapshot interval")
+	asJSON := flag.Bool("json", false, "print the full report as JSON")
+	flag.Parse()
+
+	if (*acct == "") == !*useDB {
+		fatal("exactly one of -acct or -db is required")
+	}
+
+	from, err := parseTime(*fromFlag)
+	if err != nil {
+		fatal("invalid -from: %v", err)
+	}
+	to, err := parseTime(*toFlag)
+	if err != nil {
+		fatal("invalid -to: %v", err)
+	}
+
+	cfg := engine.ReplayConfig{OrgID: *orgID, TickInterval: *tick, From: from, To: to}
+	if *rulesFile != "" {
+		if err := readJSON(*rulesFile, &cfg.ShadowRules); err != nil {
+			fatal("read rules: %v", err)
+		}
+	}
+	if *configsFile != "" {
+		if err := readJSON(*configsFile, &cfg.Configs); err != nil {
+			fatal("read ISP configs: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	var records []engine.ReplayRecord
+	if *useDB {
+		if from.IsZero() || to.IsZero() {
+			fatal("-from and -to are required with -db")
+		}
+		db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			envOrDefault("DB_HOST", "localhost"), envOrDefault("DB_PORT", "5432"), envOrDefault("DB_USER", "ignite"),
+			envOrDefault("DB_PASSWORD", "ignite_secret"), envOrDefault("DB_NAME", "ignite")))
+		if err != nil {
+			fatal("open database: %v", err)
+		}
+		defer db.Close()
+		records, err = engine.LoadTrackingEvents(ctx, db, *orgID, from, to)
+		if err != nil {
+			fatal("load tracking events: %v", err)
+		}
+	} else {
+		records, err = engine.LoadAcctFiles(strings.Split(*acct, ","))
+		if err != nil {
+			fatal("load accounting files: %v", err)
+		}
+	}
+
+	report, err := engine.Replay(ctx, records, cfg)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fatal("encode report: %v", err)
+		}
+		return
+	}
+	printReport(report)
+}
+
+func printReport(r *engine.ReplayReport) {
+	fmt.Printf("Replay %s -> %s\n", r.From.Format(time.RFC3339), r.To.Format(time.RFC3339))
+	fmt.Printf("Records: %d ingested, %d skipped, %d snapshots\n", r.Records, r.Skipped, r.Snapshots)
+	for isp, n := range r.ByISP {
+		fmt.Printf("  %-10s %d\n", isp, n)
+	}
+	fmt.Printf("Decisions: %d (%d shadow, %d auto-reverted)\n", len(r.Decisions), r.Shadow, r.Reverted)
+
+	actions := make([]string, 0, len(r.ByAction))
+	for a := range r.ByAction {
+		actions = append(actions, a)
+	}
+	sort.Strings(actions)
+	for _, a := range actions {
+		fmt.Printf("  %-28s %d\n", a, r.ByAction[a])
+	}
+
+	fmt.Println()
+	for _, d := range r.Decisions {
+		rule := ""
+		if d.RuleID != nil {
+			rule = " rule=" + *d.RuleID
+		}
+		fmt.Printf("%s  %-10s %-11s %-26s %s=%s %s%s\n", d.CreatedAt.Format(time.RFC3339), d.ISP, d.AgentType,
+			d.ActionTaken, d.TargetType, d.TargetValue, d.Result, rule)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "engine-replay: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/ignite/sparkpost-monitor/internal/mailing"
 	"github.com/ignite/sparkpost-monitor/internal/mailgun"
 	"github.com/ignite/sparkpost-monitor/internal/ongage"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 	"github.com/ignite/sparkpost-monitor/internal/ses"
 	"github.com/ignite/sparkpost-monitor/internal/snowflake"
 	"github.com/ignite/sparkpost-monitor/internal/sparkpost"
@@ -270,9 +271,12 @@ func main() {
 				// Start Campaign Scheduler Worker (polls for scheduled campaigns and enqueues them)
 				campaignScheduler := worker.NewCampaignScheduler(mailingDB)
 				campaignScheduler.SetBackpressure(backpressure)
+				variantSelector := mailing.NewVariantSelector(mailingDB)
 				if redisClient != nil {
 					campaignScheduler.SetRedisClient(redisClient)
+					variantSelector.SetRedis(redisClient)
 				}
+				campaignScheduler.SetVariantSelector(variantSelector)
 				if err := campaignScheduler.Start(); err != nil {
 					log.Printf("Warning: Failed to start Campaign Scheduler: %v", err)
 				} else {
@@ -308,8 +312,10 @@ func main() {
 				// Start Send Worker Pool (processes the queue and sends emails)
 				sendWorkerPool := worker.NewSendWorkerPool(mailingDB, 10)
 				profileSender := worker.NewProfileBasedSender(mailingDB)
+				profileSender.SetDKIMSigner(mailing.NewDKIMKeyStore(mailingDB))
 				sendWorkerPool.SetESPSenders(profileSender, profileSender, profileSender, profileSender)
 				sendWorkerPool.SetPMTASender(profileSender)
+				sendWorkerPool.SetVariantSelector(variantSelector)
 
 				trackURL := os.Getenv("TRACKING_URL")
 				if trackURL == "" {
@@ -321,19 +327,44 @@ func main() {
 				}
 				sendWorkerPool.SetTrackingConfig(trackURL, trackSecret, "00000000-0000-0000-0000-000000000001")
 
-				// Start SQS tracking event consumer
+				// Start tracking event consumer (SQS by default, Redis Streams
+				// when TRACKING_BUS=redis)
 				var trackingConsumer *tracking.Consumer
-				if sqsQueueURL := os.Getenv("SQS_TRACKING_QUEUE_URL"); sqsQueueURL != "" {
+				var trackingBus tracking.Bus
+				if os.Getenv("TRACKING_BUS") == "redis" {
+					if redisClient != nil {
+						stream := os.Getenv("TRACKING_STREAM")
+						if stream == "" {
+							stream = "tracking:events"
+						}
+						hostname, _ := os.Hostname()
+						trackingBus = tracking.NewRedisStreamBus(redisClient, stream, "tracking-consumers", hostname)
+						log.Printf("Tracking bus: Redis stream %s", stream)
+					} else {
+						log.Println("Warning: TRACKING_BUS=redis but Redis is not configured — tracking consumer disabled")
+					}
+				} else if sqsQueueURL := os.Getenv("SQS_TRACKING_QUEUE_URL"); sqsQueueURL != "" {
 					awsCfg, err := awsconfig.LoadDefaultConfig(context.Background())
 					if err != nil {
 						log.Printf("Warning: AWS config for SQS consumer failed: %v", err)
 					} else {
-						sqsClient := sqs.NewFromConfig(awsCfg)
-						trackingConsumer = tracking.NewConsumer(sqsClient, sqsQueueURL, mailingDB)
-						trackingConsumer.Start(ctx)
-						log.Printf("SQS Tracking Consumer started (queue=%s)", sqsQueueURL)
+						trackingBus = tracking.NewSQSBus(sqs.NewFromConfig(awsCfg), sqsQueueURL)
+						log.Printf("Tracking bus: SQS queue %s", sqsQueueURL)
 					}
 				}
+				// Realtime segment memberships, kept by the tracking consumer
+				// and recomputed by the segment refresh worker
+				segMembership := segmentation.NewEngine(mailingDB).Membership()
+				segMembership.Subscribe(worker.NewSegmentJourneyTrigger(mailingDB).HandleMembershipEvent)
+				if server.FlowEngine != nil {
+					segMembership.Subscribe(server.FlowEngine.HandleMembershipEvent)
+				}
+				if trackingBus != nil {
+					trackingConsumer = tracking.NewConsumer(trackingBus, mailingDB)
+					trackingConsumer.SetMembership(segMembership)
+					trackingConsumer.Start(ctx)
+					log.Println("Tracking Consumer started")
+				}
 
 				// Wire global suppression hub to send worker pool for bounce recording
 				if hub, ok := server.GlobalHub.(worker.GlobalSuppressionChecker); ok {
@@ -343,6 +374,12 @@ func main() {
 					sendWorkerPool.SetGlobalSuppressionWriter(suppressor)
 				}
 
+				// Gate every send, PMTA wave items included, with the send
+				// limiter the throttle API and rate controller also use
+				if server.SendLimiter != nil {
+					sendWorkerPool.SetSendLimiter(server.SendLimiter)
+				}
+
 				sendWorkerPool.Start()
 
 				// Start Queue Recovery Worker (reclaims stuck items from crashed workers)
@@ -357,6 +394,7 @@ func main() {
 
 				// Start Segment Refresh Worker (recalculates dynamic segment subscriber counts)
 				segRefresh := worker.NewSegmentRefreshWorker(mailingDB, 4*time.Hour)
+				segRefresh.SetMembership(segMembership)
 				segRefresh.Start(ctx)
 				log.Println("Segment Refresh Worker started (recalculates dynamic segments every 4h)")
 
@@ -417,6 +455,12 @@ func main() {
 					}
 				}
 
+				// Start the automation flow engine (segment-triggered flows)
+				if server.FlowEngine != nil {
+					server.FlowEngine.Start()
+					log.Println("Automation Flow Engine started")
+				}
+
 				// Initialize EventWriter for subscriber_events table
 				eventWriter := datanorm.NewEventWriter(mailingDB)
 				_ = eventWriter // will be wired to handlers in subsequent phases
@@ -432,6 +476,9 @@ func main() {
 					if normalizer != nil {
 						normalizer.Stop()
 					}
+					if server.FlowEngine != nil {
+						server.FlowEngine.Stop()
+					}
 					if redisClient != nil {
 						redisClient.Close()
 					}
@@ -1245,7 +1292,7 @@ func runStartupMigrations(db *sql.DB) {
 			CONSTRAINT mailing_suppressions_email_key UNIQUE (email)
 		)`},
 		{"create_suppressions_index", `CREATE INDEX IF NOT EXISTS idx_suppressions_active_email ON mailing_suppressions(email) WHERE active = true`},
-		{"reset_orphaned_sending_v2", `UPDATE mailing_campaigns SET status = 'cancelled', completed_at = NOW(), updated_at = NOW() WHERE status = 'sending' AND NOT EXISTS (SELECT 1 FROM mailing_campaign_queue q WHERE q.campaign_id = mailing_campaigns.id AND q.status IN ('queued','sending','claimed'))`},
+		{"reset_orphaned_sending_v2", `UPDATE mailing_campaigns SET status = 'cancelled', completed_at = NOW(), updated_at = NOW() WHERE status = 'sending' AND NOT EXISTS (SELECT 1 FROM mailing_campaign_queue q WHERE q.campaign_id = mailing_campaigns.id AND q.status IN ('queued','sending','claimed','submitting','parked'))`},
 		{"unstick_locked_queue_items", `UPDATE mailing_campaign_queue SET status = 'queued', worker_id = NULL, locked_at = NULL WHERE status = 'sending' AND locked_at < NOW() - INTERVAL '10 minutes'`},
 		// Seed IP pools and warmup IPs (originally in migration 030, may not exist in production RDS)
 		{"seed_warmup_pool", `INSERT INTO mailing_ip_pools (organization_id, name, description, pool_type, status)
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -13,6 +16,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/ignite/sparkpost-monitor/internal/buildinfo"
 	"github.com/ignite/sparkpost-monitor/internal/tracking"
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -23,20 +28,46 @@ func main() {
 	if port == "" {
 		port = "8081"
 	}
-	queueURL := os.Getenv("SQS_TRACKING_QUEUE_URL")
-	if queueURL == "" {
-		log.Fatal("SQS_TRACKING_QUEUE_URL is required")
+	remote, err := remoteBus()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(context.Background())
+	// Hits are spooled to local disk first and forwarded by the drainer, so
+	// an SQS or Redis outage delays events instead of losing them.
+	spoolDir := os.Getenv("TRACKING_SPOOL_DIR")
+	if spoolDir == "" {
+		spoolDir = "/var/spool/tracking"
+	}
+	drainCtx, stopDrain := context.WithCancel(context.Background())
+	var pub *tracking.Publisher
+	spool, err := tracking.OpenSpool(spoolDir)
 	if err != nil {
-		log.Fatalf("aws config: %v", err)
+		log.Printf("WARN tracking spool unavailable (%v), publishing directly", err)
+		pub = tracking.NewPublisher(remote)
+	} else {
+		pub = tracking.NewPublisher(spool)
+		pub.SetFallback(remote)
+		go tracking.NewDrainer(spool, remote).Run(drainCtx)
+		log.Printf("tracking spool at %s", spoolDir)
 	}
-
-	sqsClient := sqs.NewFromConfig(awsCfg)
-	pub := tracking.NewPublisher(sqsClient, queueURL)
 	handler := tracking.NewHandler(pub)
 
+	// The preference center and one-click unsubscribe need the database to
+	// resolve tokens; without it only the legacy unsubscribe link works.
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			log.Fatalf("database: %v", err)
+		}
+		db.SetMaxOpenConns(10)
+		defer db.Close()
+		handler.SetPreferenceCenter(tracking.NewPreferenceCenter(db, os.Getenv("TRACKING_SECRET"), pub))
+		log.Printf("preference center enabled")
+	} else {
+		log.Printf("WARN DATABASE_URL not set, preference center disabled")
+	}
+
 	srv := &http.Server{
 		Addr:         ":" + port,
 		Handler:      handler.Routes(),
@@ -60,4 +91,45 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	srv.Shutdown(ctx)
+
+	// Anything not yet forwarded stays in the spool for the next start.
+	stopDrain()
+	if spool != nil {
+		if err := spool.Close(); err != nil {
+			log.Printf("closing tracking spool: %v", err)
+		}
+	}
+}
+
+// remoteBus returns the bus the spool drains to: SQS by default, or a Redis
+// stream when TRACKING_BUS=redis.
+func remoteBus() (tracking.Bus, error) {
+	if os.Getenv("TRACKING_BUS") == "redis" {
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return nil, errors.New("REDIS_URL is required when TRACKING_BUS=redis")
+		}
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			opts = &redis.Options{Addr: redisURL}
+		}
+		stream := os.Getenv("TRACKING_STREAM")
+		if stream == "" {
+			stream = "tracking:events"
+		}
+		hostname, _ := os.Hostname()
+		log.Printf("tracking bus: Redis stream %s", stream)
+		return tracking.NewRedisStreamBus(redis.NewClient(opts), stream, "tracking-consumers", hostname), nil
+	}
+
+	queueURL := os.Getenv("SQS_TRACKING_QUEUE_URL")
+	if queueURL == "" {
+		return nil, errors.New("SQS_TRACKING_QUEUE_URL is required")
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("aws config: %w", err)
+	}
+	log.Printf("tracking bus: SQS queue %s", queueURL)
+	return tracking.NewSQSBus(sqs.NewFromConfig(awsCfg), queueURL), nil
 }
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/ignite/sparkpost-monitor/internal/mailing"
 	"github.com/ignite/sparkpost-monitor/internal/worker"
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -65,9 +67,35 @@ func main() {
 	ctx, cancel = context.WithCancel(context.Background())
 	defer cancel()
 
+	// Journey emails are gated by the same Redis-backed send limits as
+	// campaign sends
+	var sendLimiter *worker.SendLimiter
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		redisURL = os.Getenv("REDIS_ADDR")
+	}
+	if redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			opts = &redis.Options{Addr: redisURL}
+		}
+		redisClient := redis.NewClient(opts)
+		defer redisClient.Close()
+		sendLimiter = worker.NewSendLimiter(redisClient, db)
+		log.Println("Send limiter initialized")
+	}
+
 	// Initialize journey executor
 	journeyExecutor := worker.NewJourneyExecutor(db)
 	journeyExecutor.SetEmailSender(func(ctx context.Context, email, subject, htmlContent, fromName, fromEmail string) error {
+		scope := worker.SendScope{ESPType: "sparkpost"}
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			scope.Domain = email[at+1:]
+		}
+		if wait := sendLimiter.Admit(ctx, scope); wait > 0 {
+			return fmt.Errorf("email throttled: send limits hold it for %s", wait)
+		}
+
 		// Wrap the EmailSender to match the expected signature
 		// Use a nil UUID for journey-triggered emails (no campaign association)
 		result, err := emailSender.SendEmail(ctx, email, fromEmail, fromName, subject, htmlContent, "", uuid.Nil)
@@ -100,6 +128,14 @@ func main() {
 	go dataCleanup.Start(ctx)
 	log.Println("Data Cleanup Worker started (runs every 1h, batch deletes old data)")
 
+	// Start Email Verifier when a provider is configured (EMAIL_VERIFIER)
+	var emailVerifier *worker.EmailVerifier
+	if provider := newEmailVerificationProvider(); provider != nil {
+		emailVerifier = worker.NewEmailVerifier(db, provider)
+		emailVerifier.Start()
+		log.Printf("Email Verifier started (provider: %s)", os.Getenv("EMAIL_VERIFIER"))
+	}
+
 	// Simplified worker loop for demo (heartbeat)
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -128,9 +164,59 @@ func main() {
 	// Stop journey executor gracefully
 	log.Println("Stopping journey executor...")
 	journeyExecutor.Stop()
+	if emailVerifier != nil {
+		emailVerifier.Stop()
+	}
 
 	// Give any remaining operations time to finish
 	time.Sleep(2 * time.Second)
 
 	log.Println("Worker stopped")
 }
+
+// newEmailVerificationProvider builds the provider named by EMAIL_VERIFIER,
+// or returns nil when verification is off:
+//
+//	smtp         probe recipient MXes (SMTP_PROBE_HELO, SMTP_PROBE_MAIL_FROM)
+//	zerobounce   ZEROBOUNCE_API_KEY
+//	neverbounce  NEVERBOUNCE_API_KEY
+//	lists        disposable/role lists only
+//
+// Every provider is wrapped in the disposable/role list filter, which
+// DISPOSABLE_DOMAINS_FILE extends.
+func newEmailVerificationProvider() worker.EmailVerificationProvider {
+	var next worker.EmailVerificationProvider
+	switch os.Getenv("EMAIL_VERIFIER") {
+	case "":
+		return nil
+	case "smtp":
+		next = worker.NewSMTPProbe(worker.SMTPProbeConfig{
+			HeloName: os.Getenv("SMTP_PROBE_HELO"),
+			MailFrom: os.Getenv("SMTP_PROBE_MAIL_FROM"),
+		})
+	case "zerobounce":
+		next = worker.NewHTTPVerifier(worker.ZeroBounceConfig(os.Getenv("ZEROBOUNCE_API_KEY")))
+	case "neverbounce":
+		next = worker.NewHTTPVerifier(worker.NeverBounceConfig(os.Getenv("NEVERBOUNCE_API_KEY")))
+	case "lists":
+	default:
+		log.Printf("Unknown EMAIL_VERIFIER %q, email verification disabled", os.Getenv("EMAIL_VERIFIER"))
+		return nil
+	}
+
+	filter := worker.NewListFilter(next)
+	if path := os.Getenv("DISPOSABLE_DOMAINS_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Warning: disposable domain list: %v", err)
+			return filter
+		}
+		defer f.Close()
+		if n, err := filter.LoadDisposableDomains(f); err != nil {
+			log.Printf("Warning: disposable domain list: %v", err)
+		} else {
+			log.Printf("Loaded %d disposable domains from %s", n, path)
+		}
+	}
+	return filter
+}
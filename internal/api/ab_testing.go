@@ -14,6 +14,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
 )
 
 // =============================================================================
@@ -109,6 +110,13 @@ type ABTestInput struct {
 	WinnerAutoSelect        bool             `json:"winner_auto_select"`
 	WinnerConfidenceThreshold float64        `json:"winner_confidence_threshold,omitempty"`
 	WinnerMinSampleSize     int              `json:"winner_min_sample_size,omitempty"`
+	EvaluationMethod        string           `json:"evaluation_method,omitempty"` // z_test, bayesian, sequential
+	
+	// Bandit allocation
+	AllocationMode          string           `json:"allocation_mode,omitempty"`   // static, thompson, ucb
+	RewardMetric            string           `json:"reward_metric,omitempty"`     // open, click, conversion
+	ExplorationFloor        *float64         `json:"exploration_floor,omitempty"` // minimum share per variant
+	HoldoutPercent          *int             `json:"holdout_percent,omitempty"`   // deterministic holdout
 	
 	// Sending configuration
 	SendingProfileID        *string          `json:"sending_profile_id,omitempty"`
@@ -158,6 +166,8 @@ type ABTest struct {
 	WinnerWaitHours         int             `json:"winner_wait_hours"`
 	WinnerAutoSelect        bool            `json:"winner_auto_select"`
 	WinnerConfidenceThreshold float64       `json:"winner_confidence_threshold"`
+	EvaluationMethod        string          `json:"evaluation_method"`
+	AllocationMode          string          `json:"allocation_mode"`
 	Status                  string          `json:"status"`
 	TotalAudienceSize       int             `json:"total_audience_size"`
 	TestSampleSize          int             `json:"test_sample_size"`
@@ -319,6 +329,10 @@ func (s *ABTestingService) HandleCreateTest(w http.ResponseWriter, r *http.Reque
 	if input.WinnerMetric == "" {
 		input.WinnerMetric = "open_rate"
 	}
+	if !mailing.ValidWinnerMetric(input.WinnerMetric) {
+		http.Error(w, `{"error":"winner_metric must be open_rate, click_rate, click_to_open_rate, conversion_rate or unsubscribe_rate"}`, http.StatusBadRequest)
+		return
+	}
 	if input.WinnerWaitHours == 0 {
 		input.WinnerWaitHours = 4
 	}
@@ -328,6 +342,39 @@ func (s *ABTestingService) HandleCreateTest(w http.ResponseWriter, r *http.Reque
 	if input.WinnerMinSampleSize == 0 {
 		input.WinnerMinSampleSize = 100
 	}
+	if input.EvaluationMethod == "" {
+		input.EvaluationMethod = string(mailing.DefaultABMethod)
+	}
+	if !mailing.ValidABMethod(mailing.ABMethod(input.EvaluationMethod)) {
+		http.Error(w, `{"error":"evaluation_method must be z_test, bayesian or sequential"}`, http.StatusBadRequest)
+		return
+	}
+	if input.AllocationMode == "" {
+		input.AllocationMode = string(mailing.AllocationStatic)
+	}
+	switch mailing.AllocationMode(input.AllocationMode) {
+	case mailing.AllocationStatic, mailing.AllocationThompson, mailing.AllocationUCB:
+	default:
+		http.Error(w, `{"error":"allocation_mode must be static, thompson or ucb"}`, http.StatusBadRequest)
+		return
+	}
+	switch input.RewardMetric {
+	case "", "open", "click", "conversion":
+	default:
+		http.Error(w, `{"error":"reward_metric must be open, click or conversion"}`, http.StatusBadRequest)
+		return
+	}
+	explorationFloor, holdoutPercent := 0.05, 10
+	if input.ExplorationFloor != nil {
+		explorationFloor = *input.ExplorationFloor
+	}
+	if input.HoldoutPercent != nil {
+		holdoutPercent = *input.HoldoutPercent
+	}
+	if explorationFloor < 0 || explorationFloor > 0.5 || holdoutPercent < 0 || holdoutPercent > 100 {
+		http.Error(w, `{"error":"exploration_floor must be 0-0.5 and holdout_percent 0-100"}`, http.StatusBadRequest)
+		return
+	}
 	if input.ThrottleSpeed == "" {
 		input.ThrottleSpeed = "gentle"
 	}
@@ -343,7 +390,8 @@ func (s *ABTestingService) HandleCreateTest(w http.ResponseWriter, r *http.Reque
 			winner_metric, winner_wait_hours, winner_auto_select,
 			winner_confidence_threshold, winner_min_sample_size,
 			sending_profile_id, from_email, reply_email, throttle_speed,
-			test_start_at, winner_send_at,
+			test_start_at, winner_send_at, evaluation_method,
+			allocation_mode, reward_metric, exploration_floor, holdout_percent,
 			status, created_at, updated_at
 		) VALUES (
 			$1, $2, $3, $4, $5,
@@ -352,7 +400,8 @@ func (s *ABTestingService) HandleCreateTest(w http.ResponseWriter, r *http.Reque
 			$12, $13, $14,
 			$15, $16,
 			$17, $18, $19, $20,
-			$21, $22,
+			$21, $22, $23,
+			$24, $25, $26, $27,
 			'draft', NOW(), NOW()
 		)
 	`, testID, orgID, input.Name, input.Description, input.TestType,
@@ -361,7 +410,8 @@ func (s *ABTestingService) HandleCreateTest(w http.ResponseWriter, r *http.Reque
 		input.WinnerMetric, input.WinnerWaitHours, input.WinnerAutoSelect,
 		input.WinnerConfidenceThreshold, input.WinnerMinSampleSize,
 		nullIfEmptyPtr(input.SendingProfileID), input.FromEmail, input.ReplyEmail, input.ThrottleSpeed,
-		input.TestStartAt, input.WinnerSendAt)
+		input.TestStartAt, input.WinnerSendAt, input.EvaluationMethod,
+		input.AllocationMode, nullIfEmptyPtr(&input.RewardMetric), explorationFloor, holdoutPercent)
 	
 	if err != nil {
 		log.Printf("Error creating A/B test: %v", err)
@@ -443,6 +493,7 @@ func (s *ABTestingService) HandleGetTest(w http.ResponseWriter, r *http.Request)
 		SELECT id, organization_id, campaign_id, name, description, test_type,
 			   list_id, segment_id, split_type, test_sample_percent,
 			   winner_metric, winner_wait_hours, winner_auto_select, winner_confidence_threshold,
+			   COALESCE(evaluation_method, 'sequential'), COALESCE(allocation_mode, 'static'),
 			   status, total_audience_size, test_sample_size, remaining_audience_size,
 			   winner_variant_id, created_at, updated_at
 		FROM mailing_ab_tests
@@ -451,6 +502,7 @@ func (s *ABTestingService) HandleGetTest(w http.ResponseWriter, r *http.Request)
 		&test.ID, &test.OrganizationID, &campaignID, &test.Name, &description, &test.TestType,
 		&listID, &segmentID, &test.SplitType, &test.TestSamplePercent,
 		&test.WinnerMetric, &test.WinnerWaitHours, &test.WinnerAutoSelect, &test.WinnerConfidenceThreshold,
+		&test.EvaluationMethod, &test.AllocationMode,
 		&test.Status, &test.TotalAudienceSize, &test.TestSampleSize, &test.RemainingAudienceSize,
 		&winnerVariantID, &test.CreatedAt, &test.UpdatedAt)
 	
@@ -501,6 +553,14 @@ func (s *ABTestingService) HandleUpdateTest(w http.ResponseWriter, r *http.Reque
 		http.Error(w, `{"error":"invalid JSON"}`, http.StatusBadRequest)
 		return
 	}
+	if input.EvaluationMethod != "" && !mailing.ValidABMethod(mailing.ABMethod(input.EvaluationMethod)) {
+		http.Error(w, `{"error":"evaluation_method must be z_test, bayesian or sequential"}`, http.StatusBadRequest)
+		return
+	}
+	if input.WinnerMetric != "" && !mailing.ValidWinnerMetric(input.WinnerMetric) {
+		http.Error(w, `{"error":"winner_metric must be open_rate, click_rate, click_to_open_rate, conversion_rate or unsubscribe_rate"}`, http.StatusBadRequest)
+		return
+	}
 	
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE mailing_ab_tests SET
@@ -511,10 +571,11 @@ func (s *ABTestingService) HandleUpdateTest(w http.ResponseWriter, r *http.Reque
 			winner_metric = COALESCE(NULLIF($5, ''), winner_metric),
 			winner_wait_hours = CASE WHEN $6 > 0 THEN $6 ELSE winner_wait_hours END,
 			winner_auto_select = $7,
+			evaluation_method = COALESCE(NULLIF($9, ''), evaluation_method),
 			updated_at = NOW()
 		WHERE id = $8
 	`, input.Name, input.Description, input.TestType, input.TestSamplePercent,
-		input.WinnerMetric, input.WinnerWaitHours, input.WinnerAutoSelect, testID)
+		input.WinnerMetric, input.WinnerWaitHours, input.WinnerAutoSelect, testID, input.EvaluationMethod)
 	
 	if err != nil {
 		http.Error(w, `{"error":"failed to update test"}`, http.StatusInternalServerError)
@@ -893,7 +954,9 @@ func (s *ABTestingService) HandleGetTimeline(w http.ResponseWriter, r *http.Requ
 	testID := chi.URLParam(r, "testID")
 	
 	rows, _ := s.db.QueryContext(ctx, `
-		SELECT variant_id, snapshot_at, sent_count, open_count, click_count, open_rate, confidence_score
+		SELECT variant_id, snapshot_at, sent_count, open_count, click_count, open_rate, confidence_score,
+		       COALESCE(evaluation_method, ''), COALESCE(prob_best, 0), COALESCE(expected_loss, 0),
+		       COALESCE(p_value, 1), COALESCE(is_significant, FALSE)
 		FROM mailing_ab_result_snapshots
 		WHERE test_id = $1
 		ORDER BY snapshot_at
@@ -906,8 +969,12 @@ func (s *ABTestingService) HandleGetTimeline(w http.ResponseWriter, r *http.Requ
 		var snapshotAt time.Time
 		var sent, opens, clicks int
 		var openRate, confidence float64
+		var method string
+		var probBest, expectedLoss, pValue float64
+		var significant bool
 		
-		rows.Scan(&variantID, &snapshotAt, &sent, &opens, &clicks, &openRate, &confidence)
+		rows.Scan(&variantID, &snapshotAt, &sent, &opens, &clicks, &openRate, &confidence,
+			&method, &probBest, &expectedLoss, &pValue, &significant)
 		timeline = append(timeline, map[string]interface{}{
 			"variant_id":       variantID,
 			"timestamp":        snapshotAt,
@@ -916,6 +983,11 @@ func (s *ABTestingService) HandleGetTimeline(w http.ResponseWriter, r *http.Requ
 			"click_count":      clicks,
 			"open_rate":        openRate,
 			"confidence_score": confidence,
+			"evaluation_method": method,
+			"prob_best":        probBest,
+			"expected_loss":    expectedLoss,
+			"p_value":          pValue,
+			"is_significant":   significant,
 		})
 	}
 	
@@ -1016,6 +1088,10 @@ func (s *ABTestingService) HandleCreateTestFromCampaign(w http.ResponseWriter, r
 	if input.WinnerMetric == "" {
 		input.WinnerMetric = "open_rate"
 	}
+	if !mailing.ValidWinnerMetric(input.WinnerMetric) {
+		http.Error(w, `{"error":"winner_metric must be open_rate, click_rate, click_to_open_rate, conversion_rate or unsubscribe_rate"}`, http.StatusBadRequest)
+		return
+	}
 	if input.WinnerWaitHours == 0 {
 		input.WinnerWaitHours = 4
 	}
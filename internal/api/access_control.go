@@ -0,0 +1,630 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/auth"
+)
+
+// RouteRule assigns the permission needed for requests under Prefix. Methods
+// limits the rule to those methods and Segments to paths with a segment
+// matching one of the patterns. Requests matching a rule with Audit set are
+// recorded in the audit log under that action, including denied attempts.
+type RouteRule struct {
+	Prefix     string
+	Methods    []string
+	Segments   []string
+	Permission auth.Permission
+	Audit      string
+}
+
+func (rr RouteRule) matches(method, p string) bool {
+	return matchRoute(rr.Prefix, rr.Methods, rr.Segments, method, p)
+}
+
+// ScopeRule maps requests to the API key scope they need: Scope when set,
+// otherwise Resource:read for reads and Resource:write for writes.
+type ScopeRule struct {
+	Prefix   string
+	Methods  []string
+	Segments []string
+	Resource string
+	Scope    string
+}
+
+func (sr ScopeRule) matches(method, p string) bool {
+	return matchRoute(sr.Prefix, sr.Methods, sr.Segments, method, p)
+}
+
+func matchRoute(prefix string, methods, segments []string, method, p string) bool {
+	if p != prefix && !strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/") {
+		return false
+	}
+	if len(methods) > 0 {
+		ok := false
+		for _, m := range methods {
+			ok = ok || m == method
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(segments) == 0 {
+		return true
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(p, prefix), "/") {
+		for _, pat := range segments {
+			if ok, _ := path.Match(pat, seg); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// AccessControl authenticates /api requests, resolves the caller's role and
+// enforces the route permission matrix. Unmatched reads need PermView and
+// unmatched writes PermEditContent. Bearer API keys are checked against
+// their scopes instead of a role; routes without a scope are closed to keys.
+type AccessControl struct {
+	authManager *auth.AuthManager
+	adminKey    string
+	rules       []RouteRule
+	scopes      []ScopeRule
+	audit       *AuditLog
+	apiKeys     *auth.APIKeyAuthenticator
+	keyStore    *auth.DBAPIKeyStore
+
+	mu        sync.Mutex
+	roleCache map[string]cachedRole // org|email, for requests outside the session org
+}
+
+type cachedRole struct {
+	role auth.Role
+	at   time.Time
+}
+
+// NewAccessControl creates the /api access middleware. Requests carrying
+// adminKey in X-Admin-Key act as admin.
+func NewAccessControl(am *auth.AuthManager, adminKey string, rules []RouteRule, scopes []ScopeRule) *AccessControl {
+	return &AccessControl{
+		authManager: am,
+		adminKey:    adminKey,
+		rules:       rules,
+		scopes:      scopes,
+		roleCache:   make(map[string]cachedRole),
+	}
+}
+
+// SetAPIKeys enables bearer API keys.
+func (ac *AccessControl) SetAPIKeys(authn *auth.APIKeyAuthenticator, store *auth.DBAPIKeyStore) {
+	ac.apiKeys = authn
+	ac.keyStore = store
+}
+
+// RequiredScope returns the API key scope a request needs, or "" when keys
+// may not call it.
+func (ac *AccessControl) RequiredScope(method, p string) string {
+	for _, sr := range ac.scopes {
+		if !sr.matches(method, p) {
+			continue
+		}
+		if sr.Scope != "" {
+			return sr.Scope
+		}
+		if isReadMethod(method) {
+			return sr.Resource + ":read"
+		}
+		return sr.Resource + ":write"
+	}
+	return ""
+}
+
+// SetAuditLog enables audit logging of sensitive actions.
+func (ac *AccessControl) SetAuditLog(a *AuditLog) {
+	ac.audit = a
+}
+
+// Rule returns the rule deciding a request, or nil when the defaults apply.
+func (ac *AccessControl) Rule(method, p string) *RouteRule {
+	for i := range ac.rules {
+		if ac.rules[i].matches(method, p) {
+			return &ac.rules[i]
+		}
+	}
+	return nil
+}
+
+// RequiredPermission returns the permission a request needs.
+func (ac *AccessControl) RequiredPermission(method, p string) auth.Permission {
+	if rule := ac.Rule(method, p); rule != nil {
+		return rule.Permission
+	}
+	if isReadMethod(method) {
+		return auth.PermView
+	}
+	return auth.PermEditContent
+}
+
+// Middleware rejects unauthenticated requests with 401 and unpermitted ones
+// with 403. The caller is attached to the request as a UserContext.
+func (ac *AccessControl) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if bearer, ok := bearerAPIKey(req); ok && ac.apiKeys != nil {
+			ac.serveAPIKey(w, req, bearer, next)
+			return
+		}
+
+		user := ac.identify(req)
+		if user == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+
+		rule := ac.Rule(req.Method, req.URL.Path)
+		perm := ac.RequiredPermission(req.Method, req.URL.Path)
+		role := auth.Role(user.Role)
+
+		if !role.Can(perm) {
+			if rule != nil && rule.Audit != "" {
+				ac.record(req, user, rule.Audit, http.StatusForbidden)
+			}
+			respondJSON(w, http.StatusForbidden, map[string]string{
+				"error":      "forbidden",
+				"role":       user.Role,
+				"permission": string(perm),
+			})
+			return
+		}
+
+		req = req.WithContext(context.WithValue(req.Context(), UserContextKey{}, user))
+		ac.serve(w, req, user, rule, next)
+	})
+}
+
+// serve runs next, auditing the request when its rule asks for it.
+func (ac *AccessControl) serve(w http.ResponseWriter, req *http.Request, user *UserContext, rule *RouteRule, next http.Handler) {
+	if rule == nil || rule.Audit == "" {
+		next.ServeHTTP(w, req)
+		return
+	}
+	ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+	next.ServeHTTP(ww, req)
+	status := ww.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ac.record(req, user, rule.Audit, status)
+}
+
+// bearerAPIKey returns the API key in an Authorization: Bearer header.
+func bearerAPIKey(req *http.Request) (string, bool) {
+	h := req.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	key := strings.TrimSpace(h[7:])
+	return key, strings.HasPrefix(key, auth.APIKeyPrefix)
+}
+
+// serveAPIKey authenticates a bearer key, pins the request to the key's
+// organization and enforces its scopes and rate limit.
+func (ac *AccessControl) serveAPIKey(w http.ResponseWriter, req *http.Request, bearer string, next http.Handler) {
+	key, err := ac.apiKeys.Authenticate(req.Context(), bearer, clientIP(req))
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidAPIKey) {
+			log.Printf("[access] API key lookup failed: %v", err)
+		}
+		respondError(w, http.StatusUnauthorized, "invalid API key")
+		return
+	}
+	if orgID := requestOrgID(req); orgID != "" && orgID != key.OrganizationID {
+		respondError(w, http.StatusForbidden, "API key is not valid for this organization")
+		return
+	}
+
+	user := &UserContext{Email: "apikey:" + key.Name, Name: key.Name, Role: "api-key"}
+	rule := ac.Rule(req.Method, req.URL.Path)
+	scope := ac.RequiredScope(req.Method, req.URL.Path)
+	if scope == "" || !key.HasScope(scope) {
+		if rule != nil && rule.Audit != "" {
+			ac.record(req, user, rule.Audit, http.StatusForbidden)
+		}
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden", "scope": scope})
+		return
+	}
+
+	remaining, retryAfter, ok := ac.apiKeys.Allow(req.Context(), key)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(key.RateLimitPerMinute))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		respondError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+
+	orgUUID, _ := uuid.Parse(key.OrganizationID)
+	req.Header.Set("X-Organization-ID", key.OrganizationID)
+	ctx := context.WithValue(req.Context(), UserContextKey{}, user)
+	ctx = context.WithValue(ctx, OrgContextKey{}, &OrganizationContext{ID: orgUUID})
+	ctx = context.WithValue(ctx, APIKeyContextKey{}, key)
+	ac.serve(w, req.WithContext(ctx), user, rule, next)
+}
+
+// APIKeyContextKey holds the *auth.APIKey of key-authenticated requests.
+type APIKeyContextKey struct{}
+
+// GetAPIKeyFromContext returns the API key a request authenticated with.
+func GetAPIKeyFromContext(ctx context.Context) *auth.APIKey {
+	if k, ok := ctx.Value(APIKeyContextKey{}).(*auth.APIKey); ok {
+		return k
+	}
+	return nil
+}
+
+// identify returns the caller, or nil when the request is unauthenticated.
+func (ac *AccessControl) identify(req *http.Request) *UserContext {
+	if ac.adminKey != "" && req.Header.Get("X-Admin-Key") == ac.adminKey {
+		return &UserContext{Email: "admin-key", Name: "Admin API key", Role: string(auth.RoleAdmin)}
+	}
+	if ac.authManager == nil {
+		return nil
+	}
+	session := ac.authManager.GetSession(req)
+	if session == nil {
+		return nil
+	}
+	role := session.Role
+	if orgID := requestOrgID(req); orgID != "" && orgID != session.OrganizationID {
+		role = ac.roleInOrg(req.Context(), orgID, session.Email)
+	}
+	return &UserContext{Email: session.Email, Name: session.Name, Role: string(role)}
+}
+
+// roleInOrg resolves a session user's role in another organization. Users
+// who are not members, and lookups that fail, get no role, which no route
+// permits.
+func (ac *AccessControl) roleInOrg(ctx context.Context, orgID, email string) auth.Role {
+	key := orgID + "|" + strings.ToLower(email)
+	ac.mu.Lock()
+	c, ok := ac.roleCache[key]
+	ac.mu.Unlock()
+	if ok && time.Since(c.at) < time.Minute {
+		return c.role
+	}
+	role, member, err := ac.authManager.MemberRole(ctx, orgID, email)
+	if err != nil {
+		log.Printf("[access] %v", err)
+		return ""
+	}
+	if !member {
+		role = ""
+	}
+	ac.mu.Lock()
+	ac.roleCache[key] = cachedRole{role: role, at: time.Now()}
+	ac.mu.Unlock()
+	return role
+}
+
+// requestOrgID returns the organization the request addresses, if any.
+func requestOrgID(req *http.Request) string {
+	for _, v := range []string{req.Header.Get("X-Organization-ID"), req.URL.Query().Get("org_id")} {
+		if _, err := uuid.Parse(v); err == nil {
+			return v
+		}
+	}
+	return ""
+}
+
+func (ac *AccessControl) record(req *http.Request, user *UserContext, action string, status int) {
+	if ac.audit == nil {
+		log.Printf("[access] %s %s by %s (%s): %d", action, req.URL.Path, user.Email, user.Role, status)
+		return
+	}
+	orgID := requestOrgID(req)
+	if orgID == "" && ac.authManager != nil {
+		orgID = ac.authManager.OrganizationID()
+	}
+	entry := AuditEntry{
+		OrganizationID: orgID,
+		ActorEmail:     user.Email,
+		ActorRole:      user.Role,
+		Action:         action,
+		Method:         req.Method,
+		Path:           req.URL.Path,
+		StatusCode:     status,
+		IPAddress:      clientIP(req),
+		UserAgent:      req.UserAgent(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ac.audit.Record(ctx, entry); err != nil {
+		log.Printf("[access] audit %s %s failed: %v", action, req.URL.Path, err)
+	}
+}
+
+func clientIP(req *http.Request) string {
+	host := req.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+// AuditEntry is one sensitive action in mailing_audit_log.
+type AuditEntry struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	ActorEmail     string    `json:"actor_email"`
+	ActorRole      string    `json:"actor_role"`
+	Action         string    `json:"action"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	StatusCode     int       `json:"status_code"`
+	IPAddress      string    `json:"ip_address,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// AuditLog writes and reads the audit trail.
+type AuditLog struct {
+	db *sql.DB
+}
+
+// NewAuditLog creates an audit log on mailing_audit_log.
+func NewAuditLog(db *sql.DB) *AuditLog {
+	return &AuditLog{db: db}
+}
+
+// Record stores an entry. The entity type is the action's prefix, e.g.
+// "suppression" for "suppression.delete".
+func (a *AuditLog) Record(ctx context.Context, e AuditEntry) error {
+	if e.OrganizationID == "" {
+		return nil
+	}
+	entityType := e.Action
+	if i := strings.Index(entityType, "."); i > 0 {
+		entityType = entityType[:i]
+	}
+	_, err := a.db.ExecContext(ctx,
+		`INSERT INTO mailing_audit_log
+		 (organization_id, action, entity_type, actor_email, actor_role, http_method, path, status_code,
+		  ip_address, user_agent)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::inet, $10)`,
+		e.OrganizationID, e.Action, entityType, e.ActorEmail, e.ActorRole, e.Method, e.Path, e.StatusCode,
+		e.IPAddress, e.UserAgent)
+	return err
+}
+
+// List returns an organization's newest entries, optionally for one actor.
+func (a *AuditLog) List(ctx context.Context, orgID, actor string, limit int) ([]AuditEntry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT id, organization_id, action, COALESCE(actor_email, ''), COALESCE(actor_role, ''),
+		 COALESCE(http_method, ''), COALESCE(path, ''), COALESCE(status_code, 0),
+		 COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), created_at
+		 FROM mailing_audit_log
+		 WHERE organization_id = $1 AND ($2 = '' OR actor_email = $2)
+		 ORDER BY created_at DESC LIMIT $3`,
+		orgID, actor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.OrganizationID, &e.Action, &e.ActorEmail, &e.ActorRole,
+			&e.Method, &e.Path, &e.StatusCode, &e.IPAddress, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// RegisterRoutes mounts role management and the audit trail under /access.
+func (ac *AccessControl) RegisterRoutes(r chi.Router) {
+	r.Route("/access", func(r chi.Router) {
+		r.Get("/me", ac.HandleMe)
+		r.Get("/roles", ac.HandleListRoles)
+		r.Put("/roles/{email}", ac.HandleSetRole)
+		r.Get("/audit", ac.HandleListAudit)
+		r.Get("/keys", ac.HandleListAPIKeys)
+		r.Post("/keys", ac.HandleCreateAPIKey)
+		r.Delete("/keys/{id}", ac.HandleRevokeAPIKey)
+	})
+}
+
+// HandleMe returns the caller's role and permissions.
+func (ac *AccessControl) HandleMe(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromContext(r.Context())
+	if user == nil {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"email":       user.Email,
+		"role":        user.Role,
+		"permissions": auth.Role(user.Role).Permissions(),
+	})
+}
+
+// accessOrgID is the organization role and audit requests address.
+func (ac *AccessControl) accessOrgID(r *http.Request) string {
+	if orgID := requestOrgID(r); orgID != "" {
+		return orgID
+	}
+	if ac.authManager != nil {
+		return ac.authManager.OrganizationID()
+	}
+	return ""
+}
+
+func (ac *AccessControl) roleStore() auth.RoleStore {
+	if ac.authManager == nil {
+		return nil
+	}
+	return ac.authManager.RoleStore()
+}
+
+// HandleListRoles lists the stored roles in the organization.
+func (ac *AccessControl) HandleListRoles(w http.ResponseWriter, r *http.Request) {
+	store := ac.roleStore()
+	if store == nil {
+		respondError(w, http.StatusServiceUnavailable, "role store not configured")
+		return
+	}
+	roles, err := store.ListRoles(r.Context(), ac.accessOrgID(r))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"roles": roles, "available": auth.Roles})
+}
+
+// HandleSetRole assigns a role to a user in the organization.
+func (ac *AccessControl) HandleSetRole(w http.ResponseWriter, r *http.Request) {
+	store := ac.roleStore()
+	if store == nil {
+		respondError(w, http.StatusServiceUnavailable, "role store not configured")
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	role, err := auth.ParseRole(body.Role)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(chi.URLParam(r, "email")))
+	if !strings.Contains(email, "@") {
+		respondError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+	orgID := ac.accessOrgID(r)
+	if err := store.SetRole(r.Context(), orgID, email, role); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ac.mu.Lock()
+	delete(ac.roleCache, orgID+"|"+email)
+	ac.mu.Unlock()
+	respondJSON(w, http.StatusOK, map[string]string{"email": email, "role": string(role), "organization_id": orgID})
+}
+
+// HandleListAudit returns recent audit entries; ?actor= filters by email.
+func (ac *AccessControl) HandleListAudit(w http.ResponseWriter, r *http.Request) {
+	if ac.audit == nil {
+		respondError(w, http.StatusServiceUnavailable, "audit log not configured")
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries, err := ac.audit.List(r.Context(), ac.accessOrgID(r), r.URL.Query().Get("actor"), limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, entries)
+}
+
+// HandleListAPIKeys lists the organization's API keys without secrets.
+func (ac *AccessControl) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if ac.keyStore == nil {
+		respondError(w, http.StatusServiceUnavailable, "API keys not configured")
+		return
+	}
+	keys, err := ac.keyStore.List(r.Context(), ac.accessOrgID(r))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// HandleCreateAPIKey issues a key. The plaintext is only returned here.
+func (ac *AccessControl) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if ac.keyStore == nil {
+		respondError(w, http.StatusServiceUnavailable, "API keys not configured")
+		return
+	}
+	var body struct {
+		Name               string     `json:"name"`
+		Scopes             []string   `json:"scopes"`
+		RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+		ExpiresAt          *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	createdBy := ""
+	if user := GetUserFromContext(r.Context()); user != nil {
+		createdBy = user.Email
+	}
+	key, plaintext, err := ac.keyStore.Create(r.Context(), auth.APIKey{
+		OrganizationID:     ac.accessOrgID(r),
+		Name:               body.Name,
+		Scopes:             body.Scopes,
+		RateLimitPerMinute: body.RateLimitPerMinute,
+		ExpiresAt:          body.ExpiresAt,
+		CreatedBy:          createdBy,
+	})
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]interface{}{"key": plaintext, "api_key": key})
+}
+
+// HandleRevokeAPIKey revokes a key immediately.
+func (ac *AccessControl) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if ac.keyStore == nil {
+		respondError(w, http.StatusServiceUnavailable, "API keys not configured")
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if err := ac.keyStore.Revoke(r.Context(), ac.accessOrgID(r), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ac.apiKeys != nil {
+		ac.apiKeys.Forget(id)
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "revoked", "id": id})
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/auth"
+	"github.com/ignite/sparkpost-monitor/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutePermissions_Matrix(t *testing.T) {
+	ac := NewAccessControl(nil, "", routePermissions, routeScopes)
+	cases := []struct {
+		method, path string
+		want         auth.Permission
+	}{
+		{http.MethodGet, "/api/dashboard", auth.PermView},
+		{http.MethodPost, "/api/kanban/cards", auth.PermEditContent},
+		{http.MethodGet, "/api/mailing/engine/decisions", auth.PermView},
+		{http.MethodPost, "/api/mailing/engine/override", auth.PermManageDeliverability},
+		{http.MethodPost, "/api/mailing/pmta-servers/1/halt", auth.PermManageDeliverability},
+		{http.MethodPost, "/api/mailing/campaigns/abc/send", auth.PermSendCampaign},
+		{http.MethodPost, "/api/mailing/pmta-campaign/deploy", auth.PermSendCampaign},
+		{http.MethodGet, "/api/mailing/pmta-campaign/trigger-send", auth.PermSendCampaign},
+		{http.MethodPost, "/api/mailing/pmta-campaign/dry-run", auth.PermView},
+		{http.MethodPost, "/api/mailing/pmta-campaign/c1/emergency-stop", auth.PermManageDeliverability},
+		{http.MethodGet, "/api/mailing/schedule", auth.PermView},
+		{http.MethodDelete, "/api/mailing/suppressions/a@example.com", auth.PermManageSuppressions},
+		{http.MethodPost, "/api/mailing/global-suppression/bulk", auth.PermManageSuppressions},
+		{http.MethodPost, "/api/mailing/suppressions/check-batch", auth.PermView},
+		{http.MethodGet, "/api/access/roles", auth.PermAdmin},
+		{http.MethodGet, "/api/access/me", auth.PermView},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, ac.RequiredPermission(c.method, c.path), "%s %s", c.method, c.path)
+	}
+
+	rule := ac.Rule(http.MethodDelete, "/api/mailing/suppressions/a@example.com")
+	require.NotNil(t, rule)
+	assert.Equal(t, "suppression.delete", rule.Audit)
+	assert.Nil(t, ac.Rule(http.MethodGet, "/api/mailing/pmtax"), "prefixes match whole segments")
+}
+
+func TestAccessControl_Middleware(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	am := auth.NewAuthManager(&config.AuthConfig{CookieName: "sess"}, "http://localhost")
+	am.SetRoleStore(nil, "00000000-0000-0000-0000-000000000001")
+	ac := NewAccessControl(am, "secret", routePermissions, routeScopes)
+	ac.SetAuditLog(NewAuditLog(db))
+
+	var seen *UserContext
+	r := chi.NewRouter()
+	r.Use(ac.Middleware)
+	r.Delete("/api/mailing/suppressions/{email}", func(w http.ResponseWriter, r *http.Request) {
+		seen = GetUserFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// No session and no admin key.
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/mailing/suppressions/a@example.com", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// The admin key acts as admin and the deletion is audited.
+	mock.ExpectExec("INSERT INTO mailing_audit_log").
+		WithArgs("00000000-0000-0000-0000-000000000001", "suppression.delete", "suppression", "admin-key", "admin",
+			http.MethodDelete, "/api/mailing/suppressions/a@example.com", http.StatusNoContent, "192.0.2.1", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	req := httptest.NewRequest(http.MethodDelete, "/api/mailing/suppressions/a@example.com", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	req.Header.Set("User-Agent", "")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	require.NotNil(t, seen)
+	assert.Equal(t, "admin", seen.Role)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+type stubKeyStore struct{ key *auth.APIKey }
+
+func (s stubKeyStore) FindByHash(_ context.Context, hash string) (*auth.APIKey, error) {
+	if hash == auth.HashAPIKey("ipk_etl") {
+		return s.key, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s stubKeyStore) TouchLastUsed(context.Context, string, string) error { return nil }
+
+func TestAccessControl_APIKeys(t *testing.T) {
+	const orgID = "00000000-0000-0000-0000-000000000001"
+	key := &auth.APIKey{ID: "k1", OrganizationID: orgID, Name: "etl",
+		Scopes: []string{"subscribers:write", "suppressions:read"}, RateLimitPerMinute: 3}
+	ac := NewAccessControl(nil, "", routePermissions, routeScopes)
+	ac.SetAPIKeys(auth.NewAPIKeyAuthenticator(stubKeyStore{key}, nil), nil)
+
+	var seenOrg string
+	r := chi.NewRouter()
+	r.Use(ac.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		seenOrg = GetOrgIDFromContext(r.Context()).String()
+		w.WriteHeader(http.StatusOK)
+	}
+	r.Post("/api/mailing/lists/{id}/subscribers", ok)
+	r.Get("/api/mailing/suppressions", ok)
+	r.Delete("/api/mailing/suppressions/{email}", ok)
+	r.Get("/api/mailing/pmta/servers", ok)
+
+	do := func(method, path, bearer, org string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		if org != "" {
+			req.Header.Set("X-Organization-ID", org)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/mailing/suppressions", "ipk_wrong", "").Code)
+
+	rec := do(http.MethodPost, "/api/mailing/lists/l1/subscribers", "ipk_etl", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, orgID, seenOrg)
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/mailing/suppressions", "ipk_etl", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/mailing/suppressions/a@example.com", "ipk_etl", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/mailing/pmta/servers", "ipk_etl", "").Code, "unscoped routes are closed to keys")
+	assert.Equal(t, http.StatusForbidden,
+		do(http.MethodGet, "/api/mailing/suppressions", "ipk_etl", "11111111-1111-1111-1111-111111111111").Code)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/mailing/suppressions", "ipk_etl", "").Code)
+	rec = do(http.MethodGet, "/api/mailing/suppressions", "ipk_etl", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+type stubRoleStore struct {
+	roles map[string]auth.Role
+	err   error
+}
+
+func (s *stubRoleStore) GetRole(_ context.Context, orgID, email string) (auth.Role, bool, error) {
+	if s.err != nil {
+		return "", false, s.err
+	}
+	r, ok := s.roles[orgID+"|"+email]
+	return r, ok, nil
+}
+
+func (s *stubRoleStore) SetRole(context.Context, string, string, auth.Role) error { return nil }
+
+func (s *stubRoleStore) ListRoles(context.Context, string) ([]auth.RoleAssignment, error) {
+	return nil, nil
+}
+
+func (s *stubRoleStore) RecordLogin(context.Context, string, string, string, string) error {
+	return nil
+}
+
+func TestAccessControl_RoleInOtherOrgRequiresMembership(t *testing.T) {
+	const other = "11111111-1111-1111-1111-111111111111"
+	am := auth.NewAuthManager(&config.AuthConfig{CookieName: "sess", DefaultRole: "marketer"}, "http://localhost")
+	store := &stubRoleStore{roles: map[string]auth.Role{other + "|ops@example.com": auth.RoleDeliverabilityOps}}
+	am.SetRoleStore(store, "00000000-0000-0000-0000-000000000001")
+	ac := NewAccessControl(am, "", routePermissions, routeScopes)
+	ctx := context.Background()
+
+	assert.Equal(t, auth.RoleDeliverabilityOps, ac.roleInOrg(ctx, other, "ops@example.com"))
+	assert.Equal(t, auth.Role(""), ac.roleInOrg(ctx, other, "stranger@example.com"), "non-members get no role, not the default")
+	assert.False(t, auth.Role("").Can(auth.PermView))
+
+	// Lookup failures fail closed and are not cached.
+	store.err = errors.New("connection refused")
+	assert.Equal(t, auth.Role(""), ac.roleInOrg(ctx, "22222222-2222-2222-2222-222222222222", "ops@example.com"))
+	store.err = nil
+	store.roles["22222222-2222-2222-2222-222222222222|ops@example.com"] = auth.RoleViewer
+	assert.Equal(t, auth.RoleViewer, ac.roleInOrg(ctx, "22222222-2222-2222-2222-222222222222", "ops@example.com"))
+}
@@ -15,13 +15,18 @@ import (
 type AdvancedThrottleAPI struct {
 	db       *sql.DB
 	throttle *worker.AdvancedThrottleManager
+	limiter  *worker.SendLimiter
 }
 
 // NewAdvancedThrottleAPI creates a new advanced throttle API handler
 func NewAdvancedThrottleAPI(db *sql.DB, redisClient *redis.Client) *AdvancedThrottleAPI {
+	throttle := worker.NewAdvancedThrottleManager(redisClient, db)
+	limiter := worker.NewSendLimiter(redisClient, db)
+	limiter.SetAdvancedThrottle(throttle)
 	return &AdvancedThrottleAPI{
 		db:       db,
-		throttle: worker.NewAdvancedThrottleManager(redisClient, db),
+		throttle: throttle,
+		limiter:  limiter,
 	}
 }
 
@@ -54,6 +59,11 @@ func (a *AdvancedThrottleAPI) RegisterRoutes(r chi.Router) {
 
 		// Available ISPs
 		r.Get("/isps", a.HandleGetISPList)
+
+		// Hierarchical send limits
+		r.Get("/campaigns/{campaignId}/bottleneck", a.HandleGetCampaignBottleneck)
+		r.Put("/limits/{level}/{id}", a.HandleSetLevelLimit)
+		r.Delete("/limits/{level}/{id}", a.HandleClearLevelLimit)
 	})
 }
 
@@ -552,6 +562,11 @@ func (a *AdvancedThrottleAPI) GetThrottleManager() *worker.AdvancedThrottleManag
 	return a.throttle
 }
 
+// GetSendLimiter returns the send limiter sharing this API's throttle config
+func (a *AdvancedThrottleAPI) GetSendLimiter() *worker.SendLimiter {
+	return a.limiter
+}
+
 // Utility function to parse integer from string with default
 func parseIntWithDefault(s string, defaultVal int) int {
 	if s == "" {
@@ -140,5 +140,9 @@ func (cb *CampaignBuilder) RegisterRoutes(r chi.Router) {
 		// Analytics
 		r.Get("/{id}/stats", cb.HandleCampaignStats)
 		r.Get("/{id}/timeline", cb.HandleCampaignTimeline)
+
+		// Parked sends (submitted, never confirmed by the ESP)
+		r.Get("/{id}/parked", cb.HandleListParkedSends)
+		r.Post("/{id}/parked/{key}/resolve", cb.HandleResolveParkedSend)
 	})
 }
@@ -133,12 +133,22 @@ func (cb *CampaignBuilder) HandleCampaignStats(w http.ResponseWriter, r *http.Re
 	id := chi.URLParam(r, "id")
 
 	var sent, delivered, opens, clicks, bounces, complaints, unsubscribes int
+	var campOrgID string
 	cb.db.QueryRowContext(ctx, `
 		SELECT COALESCE(sent_count,0), COALESCE(delivered_count,0),
 		       COALESCE(open_count,0), COALESCE(click_count,0),
-		       COALESCE(bounce_count,0), COALESCE(complaint_count,0), COALESCE(unsubscribe_count,0)
+		       COALESCE(bounce_count,0), COALESCE(complaint_count,0), COALESCE(unsubscribe_count,0),
+		       COALESCE(organization_id::text, '')
 		FROM mailing_campaigns WHERE id = $1
-	`, id).Scan(&sent, &delivered, &opens, &clicks, &bounces, &complaints, &unsubscribes)
+	`, id).Scan(&sent, &delivered, &opens, &clicks, &bounces, &complaints, &unsubscribes, &campOrgID)
+
+	// Sends recovery parked because the ESP never confirmed them; they keep
+	// the campaign sending until resolved (HandleResolveParkedSend)
+	var parked int
+	cb.db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM mailing_campaign_queue WHERE campaign_id = $1 AND status = 'parked')
+		     + (SELECT COUNT(*) FROM mailing_campaign_queue_v2 WHERE campaign_id = $1 AND status = 'parked')
+	`, id).Scan(&parked)
 
 	// Hard/soft bounce split from tracking events (resilient to missing columns)
 	var hardBounces, softBounces int
@@ -191,11 +201,13 @@ func (cb *CampaignBuilder) HandleCampaignStats(w http.ResponseWriter, r *http.Re
 		domainBreakdown = []map[string]interface{}{}
 	}
 
-	// Aggregate domain breakdown into ISP groups
+	// Aggregate domain breakdown into ISP groups with the organization's
+	// resolver, so its domain overrides and cached MX results apply
 	ispAgg := map[string]map[string]int{}
+	resolver := isp.ForOrg(campOrgID)
 	for _, d := range domainBreakdown {
 		domain, _ := d["domain"].(string)
-		group := isp.GroupFromDomain(domain)
+		group := resolver.GroupFromDomain(domain)
 		if _, ok := ispAgg[group]; !ok {
 			ispAgg[group] = map[string]int{}
 		}
@@ -358,6 +370,7 @@ func (cb *CampaignBuilder) HandleCampaignStats(w http.ResponseWriter, r *http.Re
 		"soft_bounces":     softBounces,
 		"complaints":       complaints,
 		"unsubscribes":     unsubscribes,
+		"parked":           parked,
 		"open_rate":         calcRate(opens, sent),
 		"click_rate":        calcRate(clicks, sent),
 		"bounce_rate":       calcRate(bounces, sent),
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ignite/sparkpost-monitor/internal/worker"
+)
+
+// HandleListParkedSends lists a campaign's parked sends: items the
+// QueueRecoveryWorker parked because they were submitted but the ESP never
+// confirmed or rejected them. They are never resent automatically.
+// GET /api/mailing/campaigns/{id}/parked
+func (cb *CampaignBuilder) HandleListParkedSends(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	if !cb.campaignInOrg(r, id) {
+		http.Error(w, `{"error":"campaign not found"}`, http.StatusNotFound)
+		return
+	}
+
+	parked, err := worker.NewSendLedger(cb.db).Parked(ctx, id)
+	if err != nil {
+		log.Printf("[CampaignBuilder] list parked sends for %s: %v", id, err)
+		http.Error(w, `{"error":"failed to list parked sends"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"campaign_id": id,
+		"parked":      parked,
+		"total":       len(parked),
+	})
+}
+
+// HandleResolveParkedSend settles a parked send once an operator has
+// checked the ESP's logs. Outcome "sent" marks it delivered; "not_sent"
+// lets the queue recovery worker requeue it on its next pass.
+// POST /api/mailing/campaigns/{id}/parked/{key}/resolve
+func (cb *CampaignBuilder) HandleResolveParkedSend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	key := chi.URLParam(r, "key")
+
+	var input struct {
+		Outcome string `json:"outcome"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil ||
+		(input.Outcome != "sent" && input.Outcome != "not_sent") {
+		http.Error(w, `{"error":"outcome must be sent or not_sent"}`, http.StatusBadRequest)
+		return
+	}
+	if !cb.campaignInOrg(r, id) {
+		http.Error(w, `{"error":"campaign not found"}`, http.StatusNotFound)
+		return
+	}
+
+	err := worker.NewSendLedger(cb.db).ResolveParked(ctx, id, key, input.Outcome == "sent")
+	if errors.Is(err, worker.ErrNotParked) {
+		http.Error(w, `{"error":"send is not parked"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[CampaignBuilder] resolve parked send %s of %s: %v", key, id, err)
+		http.Error(w, `{"error":"failed to resolve parked send"}`, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[CampaignBuilder] parked send %s of campaign %s resolved as %s", key, id, input.Outcome)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"campaign_id":     id,
+		"idempotency_key": key,
+		"outcome":         input.Outcome,
+	})
+}
+
+// campaignInOrg reports whether campaign id belongs to the request's
+// organization.
+func (cb *CampaignBuilder) campaignInOrg(r *http.Request, id string) bool {
+	var exists bool
+	err := cb.db.QueryRowContext(r.Context(),
+		`SELECT EXISTS(SELECT 1 FROM mailing_campaigns WHERE id = $1 AND organization_id = $2)`,
+		id, getOrganizationID(r)).Scan(&exists)
+	return err == nil && exists
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parkedRequest(body, key string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/campaigns/c1/parked/"+key+"/resolve", strings.NewReader(body))
+	req.Header.Set("X-Organization-ID", defaultOrgID)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "c1")
+	rctx.URLParams.Add("key", key)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestHandleResolveParkedSend(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	cb := &CampaignBuilder{db: db}
+
+	rec := httptest.NewRecorder()
+	cb.HandleResolveParkedSend(rec, parkedRequest(`{"outcome":"maybe"}`, "k1"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Campaigns of other organizations are not found.
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("c1", defaultOrgID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	rec = httptest.NewRecorder()
+	cb.HandleResolveParkedSend(rec, parkedRequest(`{"outcome":"sent"}`, "k1"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("c1", defaultOrgID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("UPDATE mailing_send_ledger l").WithArgs("k1", "c1", true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	rec = httptest.NewRecorder()
+	cb.HandleResolveParkedSend(rec, parkedRequest(`{"outcome":"sent"}`, "k1"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"outcome":"sent"`)
+
+	// A send that is no longer parked cannot be resolved.
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("c1", defaultOrgID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("UPDATE mailing_send_ledger l").WithArgs("k1", "c1", false).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rec = httptest.NewRecorder()
+	cb.HandleResolveParkedSend(rec, parkedRequest(`{"outcome":"not_sent"}`, "k1"))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
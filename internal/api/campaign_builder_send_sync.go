@@ -363,7 +363,7 @@ func (cb *CampaignBuilder) HandleSendCampaign(w http.ResponseWriter, r *http.Req
 			failed++
 
 			bounceType := smtputil.ClassifyError(sendErr)
-			ispGroup := isp.Group(sub.Email)
+			ispGroup := isp.ForOrg(orgID.String()).Group(sub.Email)
 			eventType := "bounced"
 			if bounceType == smtputil.BounceSoft {
 				eventType = "soft_bounced"
@@ -983,7 +983,7 @@ func (c *CampaignCopilot) toolEmergencyStop(ctx context.Context, orgID string, a
 
 	result, _ := c.db.ExecContext(ctx,
 		`UPDATE mailing_campaign_queue SET status='skipped', error_message='emergency stop via copilot'
-		 WHERE campaign_id::text LIKE $1 AND status IN ('queued','sending','claimed','pending')`,
+		 WHERE campaign_id::text LIKE $1 AND status IN ('queued','sending','claimed','submitting','pending')`,
 		campaignID+"%")
 	cancelled, _ := result.RowsAffected()
 
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/dkim"
+)
+
+// startDKIMRotation rotates DKIM selectors through Route53 when enabled:
+//
+//	DKIM_ROTATION_DAYS       age of the signing key that triggers rotation; unset disables
+//	DKIM_ROTATION_ALGORITHM  rsa-sha256 (default) or ed25519-sha256
+//	DKIM_ROTATION_INTERVAL   how often rotations are advanced, default 1h
+//
+// Note that PowerMTA signs from /etc/pmta/dkim-keys, so a rotated domain's
+// key must also be redeployed there for PMTA's own signature.
+func startDKIMRotation(db *sql.DB, dns mailing.DNSPublisher, keys *mailing.DKIMKeyStore) {
+	days, _ := strconv.Atoi(os.Getenv("DKIM_ROTATION_DAYS"))
+	if days <= 0 {
+		return
+	}
+	cfg := mailing.DKIMRotationConfig{
+		Interval:  time.Duration(days) * 24 * time.Hour,
+		Algorithm: dkim.Algorithm(os.Getenv("DKIM_ROTATION_ALGORITHM")),
+	}
+	if cfg.Algorithm != "" && cfg.Algorithm != dkim.RSASHA256 && cfg.Algorithm != dkim.Ed25519SHA256 {
+		log.Printf("[DKIMRotator] unsupported DKIM_ROTATION_ALGORITHM %q, rotation disabled", cfg.Algorithm)
+		return
+	}
+	every := time.Hour
+	if d, err := time.ParseDuration(os.Getenv("DKIM_ROTATION_INTERVAL")); err == nil && d > 0 {
+		every = d
+	}
+
+	rotator := mailing.NewDKIMRotator(db, dns, cfg)
+	rotator.SetKeyStore(keys)
+	rotator.Start(context.Background(), every)
+	log.Printf("[DKIMRotator] rotating DKIM keys older than %d days, checking every %s", days, every)
+}
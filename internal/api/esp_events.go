@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+	"github.com/ignite/sparkpost-monitor/internal/espevents"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
+)
+
+// newESPEventHandler builds the unified delivery-event webhook handler.
+// Each provider is registered once its signing secret is configured:
+//
+//	SPARKPOST_WEBHOOK_USER / SPARKPOST_WEBHOOK_PASSWORD  basic auth
+//	SES_SNS_TOPIC_ARNS           comma-separated allowed SNS topics
+//	MAILGUN_WEBHOOK_SIGNING_KEY  Mailgun HTTP webhook signing key
+//	SENDGRID_WEBHOOK_PUBLIC_KEY  SendGrid signed event webhook verification key
+//	PMTA_WEBHOOK_SECRET          HMAC secret of the accounting forwarders
+//	FBL_WEBHOOK_SECRET           HMAC secret of the FBL mailbox forwarder
+//
+// It also starts polling the FBL mailboxes (see startFBLPoller).
+func newESPEventHandler(db *sql.DB, hub *engine.GlobalSuppressionHub, ingestor *engine.Ingestor) *espevents.Handler {
+	pipeline := espevents.NewPipeline(db)
+	pipeline.SetSuppressor(hub)
+	// PMTA's accounting records feed the ISP signal processor once their
+	// event is applied, so a redelivered batch is not counted twice.
+	// Feedback-loop reports concern mail from our PMTA IPs, so they count
+	// toward the ISP's complaint signal too.
+	pipeline.OnEvent(func(e espevents.Event, _ smtputil.Classification) {
+		if e.Record != nil {
+			ingestor.IngestSignals(*e.Record)
+			return
+		}
+		if e.Type != espevents.EventComplained || e.Provider != "arf" {
+			return
+		}
+		rec := engine.AccountingRecord{
+			Type:         "f",
+			Recipient:    e.Recipient,
+			SourceIP:     e.SourceIP,
+			FeedbackType: e.FeedbackType,
+			JobID:        e.CampaignID,
+		}
+		if rec.Recipient == "" {
+			rec.Domain = e.ReportingDomain
+		}
+		ingestor.IngestSignals(rec)
+	})
+	pipeline.StartPruning(context.Background())
+	startFBLPoller(pipeline)
+
+	h := espevents.NewHandler(pipeline)
+	var providers []string
+	register := func(a espevents.Adapter) {
+		h.Register(a)
+		providers = append(providers, a.Provider())
+	}
+
+	if user := os.Getenv("SPARKPOST_WEBHOOK_USER"); user != "" {
+		register(espevents.NewSparkPostAdapter(user, os.Getenv("SPARKPOST_WEBHOOK_PASSWORD")))
+	}
+	if arns := strings.TrimSpace(os.Getenv("SES_SNS_TOPIC_ARNS")); arns != "" {
+		register(espevents.NewSESAdapter(strings.Split(arns, ",")...))
+	}
+	if key := os.Getenv("MAILGUN_WEBHOOK_SIGNING_KEY"); key != "" {
+		register(espevents.NewMailgunAdapter(key))
+	}
+	if key := os.Getenv("SENDGRID_WEBHOOK_PUBLIC_KEY"); key != "" {
+		sg, err := espevents.NewSendGridAdapter(key)
+		if err != nil {
+			log.Printf("[ESPEvents] SendGrid webhooks disabled: %v", err)
+		} else {
+			register(sg)
+		}
+	}
+	if secret := os.Getenv("PMTA_WEBHOOK_SECRET"); secret != "" {
+		register(espevents.NewPMTAAdapter(secret))
+	}
+	if secret := os.Getenv("FBL_WEBHOOK_SECRET"); secret != "" {
+		register(espevents.NewARFAdapter(secret))
+	}
+
+	log.Printf("[ESPEvents] webhook providers: %s", strings.Join(providers, ", "))
+	for _, lw := range legacyWebhooks {
+		if !h.Registered(lw.provider) {
+			log.Printf("[ESPEvents] WARNING: %s is not configured; %s answers 503 until %s is set", lw.provider, lw.route, lw.env)
+		}
+	}
+	return h
+}
+
+// legacyWebhooks are the endpoints senders posted to before /webhooks/esp.
+// They now require the provider's signature too; see "Signed webhook
+// rollout" in docs/SENDING_INFRASTRUCTURE.md.
+var legacyWebhooks = []struct {
+	provider, route, env string
+}{
+	{"sparkpost", "/api/mailing/webhooks/sparkpost", "SPARKPOST_WEBHOOK_USER"},
+	{"ses", "/api/mailing/webhooks/ses", "SES_SNS_TOPIC_ARNS"},
+	{"pmta", "/engine/webhook", "PMTA_WEBHOOK_SECRET"},
+	{"arf", "/fbl/report", "FBL_WEBHOOK_SECRET"},
+}
+
+// startFBLPoller polls the feedback-loop mailboxes ISPs send ARF reports to:
+//
+//	FBL_MAILDIRS        comma-separated local Maildirs
+//	FBL_IMAP_ADDR       IMAP server, host:993
+//	FBL_IMAP_USER / FBL_IMAP_PASSWORD / FBL_IMAP_MAILBOX (default INBOX)
+//	FBL_POLL_INTERVAL   default 5m
+func startFBLPoller(pipeline *espevents.Pipeline) {
+	var mailboxes []espevents.Mailbox
+	for _, dir := range strings.Split(os.Getenv("FBL_MAILDIRS"), ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			mailboxes = append(mailboxes, espevents.NewMaildir(dir))
+		}
+	}
+	if addr := os.Getenv("FBL_IMAP_ADDR"); addr != "" {
+		mailboxes = append(mailboxes, espevents.NewIMAPMailbox(addr,
+			os.Getenv("FBL_IMAP_USER"), os.Getenv("FBL_IMAP_PASSWORD"), os.Getenv("FBL_IMAP_MAILBOX")))
+	}
+	if len(mailboxes) == 0 {
+		return
+	}
+
+	interval := 5 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("FBL_POLL_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	espevents.NewFBLPoller(pipeline, mailboxes...).Start(context.Background(), interval)
+	log.Printf("[ESPEvents] polling %d FBL mailboxes every %s", len(mailboxes), interval)
+}
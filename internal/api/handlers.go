@@ -39,6 +39,7 @@ type Handlers struct {
 	revenueModelService   *financial.RevenueModelService
 	intelligenceService   *intelligence.Service
 	config                *config.Config
+	access                *AccessControl
 }
 
 // SetConfig sets the application config
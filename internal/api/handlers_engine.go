@@ -24,6 +24,17 @@ type EngineService struct {
 	processor    *engine.SignalProcessor
 	rules        *engine.RuleStore
 	orgID        string
+
+	registry     *engine.ISPRegistry
+	ispOverrides *engine.ISPOverrideStore
+	reclassifier *engine.ISPReclassifier
+	alerter      *engine.Alerter
+
+	fleet      *engine.Fleet
+	fleetStore *engine.FleetStore
+	ingestor   *engine.Ingestor
+
+	rates *engine.RateController
 }
 
 // NewEngineService creates the engine API service.
@@ -90,6 +101,24 @@ func (es *EngineService) RegisterRoutes(r chi.Router) {
 		// ISP Config
 		er.Get("/isp-config", es.HandleListISPConfig)
 		er.Put("/isp-config/{isp}", es.HandleUpdateISPConfig)
+
+		// ISP domain classification
+		es.registerISPDomainRoutes(er)
+
+		// Incidents and alert routing
+		es.registerIncidentRoutes(er)
+
+		// Temporary decisions awaiting auto-revert
+		es.registerRevertRoutes(er)
+
+		// Shadow-mode agents and shadow/live comparison
+		es.registerShadowRoutes(er)
+
+		// PMTA fleet nodes and per-node decision results
+		es.registerFleetRoutes(er)
+
+		// Adaptive per-ISP/IP send rates
+		es.registerRateRoutes(er)
 	})
 }
 
@@ -463,11 +492,16 @@ func (es *EngineService) HandleCreateRule(w http.ResponseWriter, r *http.Request
 		http.Error(w, "invalid JSON", 400)
 		return
 	}
+	if !engine.ValidRuleMode(rule.Mode) {
+		http.Error(w, "mode must be live or shadow", 400)
+		return
+	}
 	created, err := es.rules.CreateRule(r.Context(), rule)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	es.orchestrator.ReloadShadowRules(r.Context())
 	writeJSON(w, 201, created)
 }
 
@@ -478,11 +512,16 @@ func (es *EngineService) HandleUpdateRule(w http.ResponseWriter, r *http.Request
 		http.Error(w, "invalid JSON", 400)
 		return
 	}
+	if !engine.ValidRuleMode(rule.Mode) {
+		http.Error(w, "mode must be live or shadow", 400)
+		return
+	}
 	updated, err := es.rules.UpdateRule(r.Context(), id, rule)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	es.orchestrator.ReloadShadowRules(r.Context())
 	engineJSON(w, updated)
 }
 
@@ -492,6 +531,7 @@ func (es *EngineService) HandleDeleteRule(w http.ResponseWriter, r *http.Request
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	es.orchestrator.ReloadShadowRules(r.Context())
 	engineJSON(w, map[string]string{"status": "deleted", "id": id})
 }
 
@@ -516,6 +556,8 @@ func (es *EngineService) HandleUpdateISPConfig(w http.ResponseWriter, r *http.Re
 		MaxConnections   *int     `json:"max_connections"`
 		MaxMsgRate       *int     `json:"max_msg_rate"`
 		Enabled          *bool    `json:"enabled"`
+		DomainPatterns   []string `json:"domain_patterns"`
+		MXPatterns       []string `json:"mx_patterns"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
 		http.Error(w, "invalid JSON", 400)
@@ -561,6 +603,18 @@ func (es *EngineService) HandleUpdateISPConfig(w http.ResponseWriter, r *http.Re
 		args = append(args, *update.Enabled)
 		n++
 	}
+	if update.DomainPatterns != nil {
+		dp, _ := json.Marshal(update.DomainPatterns)
+		query += fmt.Sprintf(", domain_patterns = $%d", n)
+		args = append(args, dp)
+		n++
+	}
+	if update.MXPatterns != nil {
+		mx, _ := json.Marshal(update.MXPatterns)
+		query += fmt.Sprintf(", mx_patterns = $%d", n)
+		args = append(args, mx)
+		n++
+	}
 
 	query += fmt.Sprintf(" WHERE organization_id = $%d AND isp = $%d", n, n+1)
 	args = append(args, es.orgID, isp)
@@ -570,6 +624,16 @@ func (es *EngineService) HandleUpdateISPConfig(w http.ResponseWriter, r *http.Re
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	if es.registry != nil && (update.DomainPatterns != nil || update.MXPatterns != nil) {
+		if configs, err := es.loadISPConfigs(r.Context()); err == nil {
+			es.registry.ApplyConfigs(configs)
+		}
+	}
+	if es.rates != nil && (update.MaxMsgRate != nil || update.Enabled != nil) {
+		if configs, err := es.loadISPConfigs(r.Context()); err == nil {
+			es.rates.SetISPConfigs(configs)
+		}
+	}
 	engineJSON(w, map[string]string{"status": "updated", "isp": isp})
 }
 
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+)
+
+// SetFleet attaches the PMTA fleet, its store and the ingestor whose node
+// poll status is reported.
+func (es *EngineService) SetFleet(fleet *engine.Fleet, store *engine.FleetStore, ingestor *engine.Ingestor) {
+	es.fleet = fleet
+	es.fleetStore = store
+	es.ingestor = ingestor
+}
+
+func (es *EngineService) registerFleetRoutes(er chi.Router) {
+	er.Get("/fleet", es.HandleGetFleet)
+	er.Get("/fleet/nodes", es.HandleListFleetNodes)
+	er.Put("/fleet/nodes/{name}", es.HandleUpsertFleetNode)
+	er.Delete("/fleet/nodes/{name}", es.HandleDeleteFleetNode)
+	er.Get("/decisions/{id}/nodes", es.HandleDecisionNodeResults)
+}
+
+// HandleGetFleet returns the active nodes, their last status poll and the
+// most recent per-node decision results.
+func (es *EngineService) HandleGetFleet(w http.ResponseWriter, r *http.Request) {
+	if es.fleet == nil {
+		http.Error(w, "PMTA fleet not configured", http.StatusServiceUnavailable)
+		return
+	}
+	status := []engine.NodeStatus{}
+	if es.ingestor != nil {
+		status = es.ingestor.NodeStatuses()
+	}
+	engineJSON(w, map[string]interface{}{
+		"nodes":          es.fleet.Nodes(),
+		"status":         status,
+		"recent_results": es.fleet.RecentResults(50),
+	})
+}
+
+// HandleListFleetNodes lists every stored node, including disabled ones.
+func (es *EngineService) HandleListFleetNodes(w http.ResponseWriter, r *http.Request) {
+	if es.fleetStore == nil {
+		http.Error(w, "PMTA fleet not configured", http.StatusServiceUnavailable)
+		return
+	}
+	nodes, err := es.fleetStore.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if nodes == nil {
+		nodes = []engine.PMTANode{}
+	}
+	engineJSON(w, nodes)
+}
+
+// HandleUpsertFleetNode creates or replaces a node and reloads the fleet.
+func (es *EngineService) HandleUpsertFleetNode(w http.ResponseWriter, r *http.Request) {
+	if es.fleetStore == nil || es.fleet == nil {
+		http.Error(w, "PMTA fleet not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var n engine.PMTANode
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, "invalid JSON", 400)
+		return
+	}
+	n.Name = chi.URLParam(r, "name")
+	saved, err := es.fleetStore.Upsert(r.Context(), n)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := es.fleet.Load(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	engineJSON(w, saved)
+}
+
+// HandleDeleteFleetNode removes a node and reloads the fleet.
+func (es *EngineService) HandleDeleteFleetNode(w http.ResponseWriter, r *http.Request) {
+	if es.fleetStore == nil || es.fleet == nil {
+		http.Error(w, "PMTA fleet not configured", http.StatusServiceUnavailable)
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if err := es.fleetStore.Delete(r.Context(), name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "node not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := es.fleet.Load(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	engineJSON(w, map[string]string{"status": "deleted", "name": name})
+}
+
+// HandleDecisionNodeResults shows how a decision fared on each node.
+func (es *EngineService) HandleDecisionNodeResults(w http.ResponseWriter, r *http.Request) {
+	if es.fleetStore == nil {
+		http.Error(w, "PMTA fleet not configured", http.StatusServiceUnavailable)
+		return
+	}
+	results, err := es.fleetStore.ResultsForDecision(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	engineJSON(w, results)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+)
+
+// SetAlerter exposes alert routing and incident acknowledgement.
+func (es *EngineService) SetAlerter(alerter *engine.Alerter) {
+	es.alerter = alerter
+}
+
+func (es *EngineService) registerIncidentRoutes(er chi.Router) {
+	er.Get("/incidents", es.HandleListIncidents)
+	er.Post("/incidents/{id}/ack", es.HandleAckIncident)
+	er.Get("/alert-routes", es.HandleListAlertRoutes)
+}
+
+// HandleListIncidents lists persisted incidents, newest first. Optional
+// ?status=active|acknowledged|resolved and ?limit=N.
+func (es *EngineService) HandleListIncidents(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	store := &engine.DBIncidentStore{DB: es.db}
+	incidents, err := store.ListIncidents(r.Context(), es.orgID, r.URL.Query().Get("status"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if incidents == nil {
+		incidents = []engine.IncidentReport{}
+	}
+	engineJSON(w, incidents)
+}
+
+// HandleAckIncident acknowledges an incident. Pending grouped updates for it
+// are dropped and acknowledge events go out on its channels.
+func (es *EngineService) HandleAckIncident(w http.ResponseWriter, r *http.Request) {
+	if es.alerter == nil {
+		http.Error(w, "alerter not configured", http.StatusServiceUnavailable)
+		return
+	}
+	by := "api"
+	if user := GetUserFromContext(r.Context()); user != nil && user.Email != "" {
+		by = user.Email
+	}
+	inc, err := es.alerter.Acknowledge(r.Context(), chi.URLParam(r, "id"), by)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	engineJSON(w, inc)
+}
+
+func (es *EngineService) HandleListAlertRoutes(w http.ResponseWriter, r *http.Request) {
+	if es.alerter == nil {
+		http.Error(w, "alerter not configured", http.StatusServiceUnavailable)
+		return
+	}
+	engineJSON(w, map[string]interface{}{
+		"routes":         es.alerter.Routes(),
+		"open_incidents": es.alerter.OpenIncidents(),
+	})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+	"github.com/ignite/sparkpost-monitor/internal/worker"
+)
+
+// SetRateController attaches the adaptive send-rate controller.
+func (es *EngineService) SetRateController(rates *engine.RateController) {
+	es.rates = rates
+}
+
+func (es *EngineService) registerRateRoutes(er chi.Router) {
+	er.Get("/rates", es.HandleGetRates)
+}
+
+// HandleGetRates returns the controller's allowed rate per ISP and source
+// IP, and its most recent adjustments.
+func (es *EngineService) HandleGetRates(w http.ResponseWriter, r *http.Request) {
+	if es.rates == nil {
+		http.Error(w, "rate controller not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	type adjustment struct {
+		ISP        string    `json:"isp"`
+		TargetType string    `json:"target_type"`
+		Target     string    `json:"target"`
+		Direction  string    `json:"direction"`
+		OldRate    float64   `json:"old_msgs_per_minute"`
+		NewRate    float64   `json:"new_msgs_per_minute"`
+		Reason     string    `json:"reason"`
+		Deferral   float64   `json:"deferral_rate"`
+		Refusal    float64   `json:"refusal_rate"`
+		LatencyMs  int64     `json:"accept_latency_ms"`
+		At         time.Time `json:"at"`
+	}
+	recent := []adjustment{}
+	rows, err := es.db.QueryContext(r.Context(),
+		`SELECT isp, target_type, target_name, adjustment_type, old_msgs_per_minute, new_msgs_per_minute,
+		 COALESCE(reason,''), COALESCE(deferral_rate,0), COALESCE(refusal_rate,0), COALESCE(accept_latency_ms,0), created_at
+		 FROM mailing_throttle_adjustment_log
+		 WHERE org_id = $1 AND isp IS NOT NULL
+		 ORDER BY created_at DESC LIMIT 50`, es.orgID)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var a adjustment
+			if rows.Scan(&a.ISP, &a.TargetType, &a.Target, &a.Direction, &a.OldRate, &a.NewRate,
+				&a.Reason, &a.Deferral, &a.Refusal, &a.LatencyMs, &a.At) == nil {
+				recent = append(recent, a)
+			}
+		}
+	}
+
+	engineJSON(w, map[string]interface{}{
+		"rates":       es.rates.Rates(),
+		"adjustments": recent,
+	})
+}
+
+// rateOverrideTTL bounds how long the controller's pacing outlives its last
+// refresh. The controller re-applies unchanged rates every
+// RateControllerConfig.Refresh, well within it.
+const rateOverrideTTL = 30 * time.Minute
+
+// engineRateSink applies controller rates where sending is paced. Every
+// rate becomes PMTA's max-msg-rate on the IP's queue or the ISP's pool, and
+// the ISP's total, the ISP-wide rate or the sum of its IP rates, paces the
+// send limiter's ISP level so the workers stop handing PMTA more than it is
+// allowed to deliver. The limiter gets an expiring override rather than a
+// new limit, so the admin-configured ISP limit stays in force and comes
+// back on its own when the controller stops.
+type engineRateSink struct {
+	pmta    engine.RateSink
+	limiter *worker.SendLimiter
+	orgID   string
+
+	mu      sync.Mutex
+	ipRates map[engine.ISP]map[string]float64
+}
+
+func (s *engineRateSink) SetRate(ctx context.Context, isp engine.ISP, ip string, perMinute float64) error {
+	var errs []error
+	if s.pmta != nil {
+		if err := s.pmta.SetRate(ctx, isp, ip, perMinute); err != nil {
+			errs = append(errs, fmt.Errorf("pmta: %w", err))
+		}
+	}
+	if s.limiter != nil {
+		total := s.ispTotal(isp, ip, perMinute)
+		err := s.limiter.SetOverride(ctx, s.orgID, worker.LimitISP, string(isp),
+			[]worker.Bucket{{Rate: total / 60, Burst: max(1, int(total))}}, rateOverrideTTL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("send limiter: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ispTotal records an IP's rate and returns the ISP's total rate
+func (s *engineRateSink) ispTotal(isp engine.ISP, ip string, perMinute float64) float64 {
+	if ip == "" {
+		return perMinute
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ipRates == nil {
+		s.ipRates = make(map[engine.ISP]map[string]float64)
+	}
+	if s.ipRates[isp] == nil {
+		s.ipRates[isp] = make(map[string]float64)
+	}
+	s.ipRates[isp][ip] = perMinute
+	var total float64
+	for _, rate := range s.ipRates[isp] {
+		total += rate
+	}
+	return total
+}
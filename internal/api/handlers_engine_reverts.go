@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (es *EngineService) registerRevertRoutes(er chi.Router) {
+	er.Get("/reverts", es.HandleListPendingReverts)
+	er.Post("/decisions/{id}/revert", es.HandleRevertDecision)
+}
+
+// HandleListPendingReverts lists temporary decisions with their expiry,
+// recovery condition and how long the condition has held.
+func (es *EngineService) HandleListPendingReverts(w http.ResponseWriter, r *http.Request) {
+	engineJSON(w, es.orchestrator.PendingReverts())
+}
+
+// HandleRevertDecision executes a pending decision's inverse action now.
+func (es *EngineService) HandleRevertDecision(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&req)
+	}
+	by := "api"
+	if user := GetUserFromContext(r.Context()); user != nil && user.Email != "" {
+		by = user.Email
+	}
+	reason := "manual revert by " + by
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+
+	inv, err := es.orchestrator.RevertDecision(r.Context(), chi.URLParam(r, "id"), reason)
+	if err != nil {
+		if inv == nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	engineJSON(w, inv)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+)
+
+func (es *EngineService) registerShadowRoutes(er chi.Router) {
+	er.Post("/isp/{isp}/agents/{type}/shadow", es.HandleSetAgentShadow)
+	er.Get("/shadow/compare", es.HandleShadowCompare)
+}
+
+// HandleSetAgentShadow switches an agent between live and shadow mode.
+// Body: {"shadow": true}.
+func (es *EngineService) HandleSetAgentShadow(w http.ResponseWriter, r *http.Request) {
+	isp := engine.ISP(chi.URLParam(r, "isp"))
+	at := engine.AgentType(chi.URLParam(r, "type"))
+	var req struct {
+		Shadow bool `json:"shadow"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", 400)
+		return
+	}
+	if err := es.orchestrator.SetAgentShadow(r.Context(), isp, at, req.Shadow); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	mode := engine.RuleModeLive
+	if req.Shadow {
+		mode = engine.RuleModeShadow
+	}
+	engineJSON(w, map[string]string{"mode": mode, "isp": string(isp), "agent": string(at)})
+}
+
+// HandleShadowCompare lines up shadow and live decisions over a time range.
+// Query: from, to (RFC3339; default the last 24h), isp, tolerance (Go
+// duration; default 15m).
+func (es *EngineService) HandleShadowCompare(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid to", 400)
+			return
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid from", 400)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", 400)
+		return
+	}
+	tolerance := 15 * time.Minute
+	if v := q.Get("tolerance"); v != "" {
+		if tolerance, err = time.ParseDuration(v); err != nil || tolerance < 0 {
+			http.Error(w, "invalid tolerance", 400)
+			return
+		}
+	}
+	var isp *engine.ISP
+	if v := q.Get("isp"); v != "" {
+		i := engine.ISP(v)
+		isp = &i
+	}
+
+	store := &engine.DBDecisionStore{DB: es.db}
+	decisions, err := store.QueryDecisionRange(r.Context(), es.orgID, isp, from, to, 50000)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	engineJSON(w, engine.CompareDecisionStreams(decisions, from, to, tolerance))
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+)
+
+// SetISPDomains wires domain override management and the domain_group
+// reclassification job into the engine API.
+func (es *EngineService) SetISPDomains(registry *engine.ISPRegistry, overrides *engine.ISPOverrideStore, reclassifier *engine.ISPReclassifier) {
+	es.registry = registry
+	es.ispOverrides = overrides
+	es.reclassifier = reclassifier
+}
+
+func (es *EngineService) registerISPDomainRoutes(er chi.Router) {
+	er.Get("/isp-domains/resolve", es.HandleResolveDomain)
+	er.Get("/isp-domains/overrides", es.HandleListDomainOverrides)
+	er.Put("/isp-domains/overrides/{domain}", es.HandleUpsertDomainOverride)
+	er.Delete("/isp-domains/overrides/{domain}", es.HandleDeleteDomainOverride)
+	er.Get("/isp-domains/reclassify", es.HandleReclassifyStatus)
+	er.Post("/isp-domains/reclassify", es.HandleStartReclassify)
+}
+
+// HandleResolveDomain shows how a domain is classified and why.
+func (es *EngineService) HandleResolveDomain(w http.ResponseWriter, r *http.Request) {
+	if es.registry == nil {
+		http.Error(w, "ISP domain registry not configured", http.StatusServiceUnavailable)
+		return
+	}
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		http.Error(w, "domain is required", 400)
+		return
+	}
+	engineJSON(w, es.registry.Resolver().ResolveMX(r.Context(), domain))
+}
+
+func (es *EngineService) HandleListDomainOverrides(w http.ResponseWriter, r *http.Request) {
+	if es.ispOverrides == nil {
+		http.Error(w, "ISP domain overrides not configured", http.StatusServiceUnavailable)
+		return
+	}
+	overrides, err := es.ispOverrides.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if overrides == nil {
+		overrides = []engine.ISPDomainOverride{}
+	}
+	engineJSON(w, overrides)
+}
+
+func (es *EngineService) HandleUpsertDomainOverride(w http.ResponseWriter, r *http.Request) {
+	if es.ispOverrides == nil {
+		http.Error(w, "ISP domain overrides not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		ISP  engine.ISP `json:"isp"`
+		Note string     `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", 400)
+		return
+	}
+	o := engine.ISPDomainOverride{
+		Domain: chi.URLParam(r, "domain"),
+		ISP:    engine.ISP(strings.ToLower(strings.TrimSpace(string(req.ISP)))),
+		Note:   req.Note,
+	}
+	if user := GetUserFromContext(r.Context()); user != nil {
+		o.CreatedBy = user.Email
+	}
+	if !engine.ValidOverrideISP(o.ISP) {
+		http.Error(w, "unknown isp", 400)
+		return
+	}
+	saved, err := es.ispOverrides.Upsert(r.Context(), o)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	engineJSON(w, saved)
+}
+
+func (es *EngineService) HandleDeleteDomainOverride(w http.ResponseWriter, r *http.Request) {
+	if es.ispOverrides == nil {
+		http.Error(w, "ISP domain overrides not configured", http.StatusServiceUnavailable)
+		return
+	}
+	domain := chi.URLParam(r, "domain")
+	if err := es.ispOverrides.Delete(r.Context(), domain); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	engineJSON(w, map[string]string{"status": "deleted", "domain": domain})
+}
+
+// HandleStartReclassify rewrites domain_group on the engine organization's
+// mailing_subscribers in the background using its resolver, overrides
+// included.
+func (es *EngineService) HandleStartReclassify(w http.ResponseWriter, r *http.Request) {
+	if es.reclassifier == nil {
+		http.Error(w, "ISP reclassification not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if !es.reclassifier.Start(context.Background(), es.orgID) {
+		http.Error(w, "reclassification already running", http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+}
+
+func (es *EngineService) HandleReclassifyStatus(w http.ResponseWriter, r *http.Request) {
+	if es.reclassifier == nil {
+		http.Error(w, "ISP reclassification not configured", http.StatusServiceUnavailable)
+		return
+	}
+	running, last := es.reclassifier.Status()
+	engineJSON(w, map[string]interface{}{"running": running, "last_run": last})
+}
@@ -810,16 +810,26 @@ func (s *PMTAService) HandleGenerateDKIM(w http.ResponseWriter, r *http.Request)
 	dnsValue := fmt.Sprintf("v=DKIM1; k=rsa; p=%s", pubB64)
 	dnsRecord := fmt.Sprintf("%s._domainkey.%s", input.Selector, domain)
 
+	// New keys start pending, like rotated ones: the DKIM rotator publishes
+	// them (or HandleVerifyDKIM sees the record added by hand) and they only
+	// sign once the TXT record resolves. Regenerating a selector replaces its
+	// key, so it goes back to pending too.
 	var id uuid.UUID
 	err = s.db.QueryRowContext(r.Context(), `
-		INSERT INTO mailing_dkim_keys (organization_id, domain, selector, private_key_encrypted, public_key, key_size, dns_record_value)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO mailing_dkim_keys (organization_id, domain, selector, private_key_encrypted, public_key, key_size, dns_record_value,
+			active, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, false, 'pending')
 		ON CONFLICT (domain, selector) DO UPDATE SET
 			private_key_encrypted = EXCLUDED.private_key_encrypted,
 			public_key = EXCLUDED.public_key,
 			key_size = EXCLUDED.key_size,
 			dns_record_value = EXCLUDED.dns_record_value,
-			dns_verified = false
+			dns_verified = false,
+			active = false,
+			status = 'pending',
+			published_at = NULL,
+			activated_at = NULL,
+			retired_at = NULL
 		RETURNING id
 	`, orgID, domain, input.Selector, privPEM, string(pubPEM), input.KeySize, dnsValue).Scan(&id)
 	if err != nil {
@@ -835,7 +845,8 @@ func (s *PMTAService) HandleGenerateDKIM(w http.ResponseWriter, r *http.Request)
 		"dns_type":   "TXT",
 		"dns_value":  dnsValue,
 		"key_size":   input.KeySize,
-		"message":    fmt.Sprintf("Add TXT record: %s -> %s", dnsRecord, dnsValue),
+		"status":     "pending",
+		"message":    fmt.Sprintf("Add TXT record: %s -> %s. The key signs once the record resolves.", dnsRecord, dnsValue),
 	})
 }
 
@@ -847,7 +858,7 @@ func (s *PMTAService) HandleGetDKIM(w http.ResponseWriter, r *http.Request) {
 		`SELECT domain FROM mailing_sending_domains WHERE id=$1`, domainID).Scan(&domain)
 
 	rows, err := s.db.QueryContext(r.Context(), `
-		SELECT id, selector, public_key, algorithm, key_size, dns_record_value, dns_verified, active, created_at
+		SELECT id, selector, public_key, algorithm, key_size, dns_record_value, dns_verified, active, status, created_at
 		FROM mailing_dkim_keys WHERE domain = $1 ORDER BY created_at DESC
 	`, domain)
 	if err != nil {
@@ -858,15 +869,15 @@ func (s *PMTAService) HandleGetDKIM(w http.ResponseWriter, r *http.Request) {
 
 	var keys []map[string]interface{}
 	for rows.Next() {
-		var id, selector, pubKey, algorithm, dnsValue string
+		var id, selector, pubKey, algorithm, dnsValue, status string
 		var keySize int
 		var verified, active bool
 		var createdAt time.Time
-		rows.Scan(&id, &selector, &pubKey, &algorithm, &keySize, &dnsValue, &verified, &active, &createdAt)
+		rows.Scan(&id, &selector, &pubKey, &algorithm, &keySize, &dnsValue, &verified, &active, &status, &createdAt)
 		keys = append(keys, map[string]interface{}{
 			"id": id, "selector": selector, "algorithm": algorithm,
 			"key_size": keySize, "dns_record_value": dnsValue,
-			"dns_verified": verified, "active": active, "created_at": createdAt,
+			"dns_verified": verified, "active": active, "status": status, "created_at": createdAt,
 			"dns_record": fmt.Sprintf("%s._domainkey.%s", selector, domain),
 		})
 	}
@@ -884,7 +895,7 @@ func (s *PMTAService) HandleVerifyDKIM(w http.ResponseWriter, r *http.Request) {
 		`SELECT domain FROM mailing_sending_domains WHERE id=$1`, domainID).Scan(&domain)
 
 	rows, err := s.db.QueryContext(r.Context(),
-		`SELECT id, selector, dns_record_value FROM mailing_dkim_keys WHERE domain = $1 AND active = true`, domain)
+		`SELECT id, selector, dns_record_value FROM mailing_dkim_keys WHERE domain = $1 AND status IN ('pending', 'published', 'active')`, domain)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
@@ -916,8 +927,13 @@ func (s *PMTAService) HandleVerifyDKIM(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		s.db.ExecContext(r.Context(),
-			`UPDATE mailing_dkim_keys SET dns_verified=$1 WHERE id=$2`, verified, id)
+		// A pending key whose record was added by hand counts as published;
+		// the DKIM rotator activates it after its MinPublished wait.
+		s.db.ExecContext(r.Context(), `
+			UPDATE mailing_dkim_keys SET dns_verified = $1,
+				status = CASE WHEN $1 AND status = 'pending' THEN 'published' ELSE status END,
+				published_at = CASE WHEN $1 AND status = 'pending' THEN NOW() ELSE published_at END
+			WHERE id = $2`, verified, id)
 
 		results = append(results, map[string]interface{}{
 			"selector": selector, "record": record,
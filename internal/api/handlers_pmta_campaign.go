@@ -30,7 +30,7 @@ type PMTACampaignService struct {
 	orgID        string
 	suppMatcher  *SuppressionMatcher
 	globalHub    *engine.GlobalSuppressionHub
-	executor     *engine.Executor
+	executor     engine.ActionExecutor
 	colCache     *campaignColumnCache
 
 	// preflightFn overrides preflightDeployCheck for testing (DNS lookups
@@ -38,7 +38,7 @@ type PMTACampaignService struct {
 	preflightFn func(ctx context.Context, db *sql.DB, orgID, domain string) preflightResult
 }
 
-func (s *PMTACampaignService) SetExecutor(e *engine.Executor) {
+func (s *PMTACampaignService) SetExecutor(e engine.ActionExecutor) {
 	s.executor = e
 }
 
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleGenerateDKIM_KeyStartsPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	s := &PMTAService{db: db}
+
+	mock.ExpectQuery("SELECT domain, organization_id FROM mailing_sending_domains").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"domain", "organization_id"}).AddRow("news.example.com", "org-1"))
+	// An admin-generated key must not sign until its TXT record resolves.
+	mock.ExpectQuery("(?s)INSERT INTO mailing_dkim_keys .*VALUES \\(.*false, 'pending'\\).*active = false,\\s+status = 'pending'").
+		WithArgs("org-1", "news.example.com", "s1", sqlmock.AnyArg(), sqlmock.AnyArg(), 1024, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+
+	req := httptest.NewRequest(http.MethodPost, "/domains/d1/dkim/generate", strings.NewReader(`{"key_size":1024}`))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("domainId", "d1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	s.HandleGenerateDKIM(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"pending"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
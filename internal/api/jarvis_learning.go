@@ -512,14 +512,19 @@ func (j *JarvisOrchestrator) persistConversion(conv everflow.Conversion, recipie
 		intervalStr = &s
 	}
 
+	// Link the conversion to the subscriber, campaign and Everflow offer so
+	// revenue segments can aggregate it per subscriber.
 	_, err := j.db.ExecContext(ctx, `
 		INSERT INTO mailing_revenue_attributions (
 			id, organization_id, conversion_id, revenue,
 			attribution_model, attribution_weight, attributed_revenue,
-			click_id, time_to_conversion, converted_at, created_at
-		) VALUES ($1, $2, $3, $4, 'jarvis_attribution', 1.0, $4, $5, $6::interval, $7, NOW())
+			click_id, time_to_conversion, converted_at, created_at,
+			subscriber_id, campaign_id, everflow_offer_id
+		) VALUES ($1, $2, $3, $4, 'jarvis_attribution', 1.0, $4, $5, $6::interval, $7, NOW(),
+			(SELECT id FROM mailing_subscribers WHERE organization_id = $2 AND LOWER(email) = LOWER($8) LIMIT 1),
+			(SELECT id FROM mailing_campaigns WHERE id::text = $9), NULLIF($10, ''))
 	`, uuid.New(), orgID, conv.ConversionID, conv.Revenue,
-		conv.ClickID, intervalStr, conv.ConversionTime)
+		conv.ClickID, intervalStr, conv.ConversionTime, recipientEmail, campaignID, conv.OfferID)
 
 	if err != nil {
 		log.Printf("[Jarvis/Attribution] Error persisting conversion %s: %v", conv.ConversionID, err)
@@ -31,11 +31,6 @@ func getOrgIDFromRequest(r *http.Request) string {
 
 // RegisterAdvancedMailingRoutes registers all advanced mailing routes
 func (s *AdvancedMailingService) RegisterRoutes(r chi.Router) {
-	// Bounce/Complaint Webhooks (auto-suppression to Global Suppression List)
-	r.Post("/webhooks/sparkpost", s.HandleSparkPostWebhook)
-	r.Post("/webhooks/ses", s.HandleSESWebhook)
-	r.Post("/webhooks/mailgun", s.HandleMailgunWebhook)
-	
 	// A/B Testing
 	r.Get("/ab-tests", s.HandleGetABTests)
 	r.Post("/ab-tests", s.HandleCreateABTest)
@@ -128,6 +128,26 @@ func parseAnalyticsRange(r *http.Request) (start, end time.Time) {
 	return start, end
 }
 
+// excludeMachineTraffic reports whether the request asks for opens and
+// clicks classified as machine traffic to be left out (exclude_machine=true).
+func excludeMachineTraffic(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("exclude_machine"))
+	return v
+}
+
+// humanTrafficFilter returns an SQL predicate, starting with AND, that drops
+// machine-classified tracking events when exclude_machine is set. alias is
+// the mailing_tracking_events alias, or empty for an unaliased table.
+func humanTrafficFilter(r *http.Request, alias string) string {
+	if !excludeMachineTraffic(r) {
+		return ""
+	}
+	if alias != "" {
+		alias += "."
+	}
+	return fmt.Sprintf(" AND COALESCE(%straffic_class, 'human') = 'human'", alias)
+}
+
 func trendGranularity(start, end time.Time) string {
 	d := end.Sub(start)
 	if d <= time.Hour+time.Minute {
@@ -150,7 +170,7 @@ func (s *AdvancedMailingService) HandleCampaignTimeline(w http.ResponseWriter, r
 			   SUM(CASE WHEN event_type IN ('hard_bounce','bounced') THEN 1 ELSE 0 END) as hard_bounces,
 			   SUM(CASE WHEN event_type = 'soft_bounce' THEN 1 ELSE 0 END) as soft_bounces
 		FROM mailing_tracking_events
-		WHERE campaign_id = $1
+		WHERE campaign_id = $1`+humanTrafficFilter(r, "")+`
 		GROUP BY DATE_TRUNC('hour', event_time)
 		ORDER BY hour
 	`, campaignID)
@@ -187,7 +207,7 @@ func (s *AdvancedMailingService) HandleCampaignByDomain(w http.ResponseWriter, r
 		       SUM(CASE WHEN t.event_type = 'complained' THEN 1 ELSE 0 END) as complaints
 		FROM mailing_tracking_events t
 		JOIN mailing_subscribers s ON s.id = t.subscriber_id
-		WHERE t.campaign_id = $1 AND s.email IS NOT NULL AND s.email != ''
+		WHERE t.campaign_id = $1 AND s.email IS NOT NULL AND s.email != ''`+humanTrafficFilter(r, "t")+`
 		GROUP BY SPLIT_PART(s.email, '@', 2)
 		ORDER BY sent DESC
 		LIMIT 50
@@ -233,7 +253,7 @@ func (s *AdvancedMailingService) HandleCampaignByDevice(w http.ResponseWriter, r
 		SELECT COALESCE(device_type, 'unknown') as device,
 			   COUNT(*) as total
 		FROM mailing_tracking_events
-		WHERE campaign_id = $1 AND event_type IN ('opened', 'clicked')
+		WHERE campaign_id = $1 AND event_type IN ('opened', 'clicked')`+humanTrafficFilter(r, "")+`
 		GROUP BY device_type
 		ORDER BY total DESC
 	`, campaignID)
@@ -286,6 +306,20 @@ func (s *AdvancedMailingService) HandleAnalyticsOverview(w http.ResponseWriter,
 		totalHardBounce = totalBounces
 	}
 
+	// Machine opens/clicks (MPP prefetches, scanner clicks) in the range;
+	// campaign counters include them, so take them out when excluded.
+	var machineOpens, machineClicks int
+	s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(CASE WHEN event_type = 'opened' THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN event_type = 'clicked' THEN 1 ELSE 0 END), 0)
+		FROM mailing_tracking_events
+		WHERE event_at >= $1 AND event_at <= $2 AND traffic_class <> 'human'
+	`, start, end).Scan(&machineOpens, &machineClicks)
+	if excludeMachineTraffic(r) {
+		totalOpens = max(totalOpens-machineOpens, 0)
+		totalClicks = max(totalClicks-machineClicks, 0)
+	}
+
 	openRate, clickRate, bounceRate, complaintRate := 0.0, 0.0, 0.0, 0.0
 	hardBounceRate, softBounceRate := 0.0, 0.0
 	if totalSent > 0 {
@@ -316,6 +350,7 @@ func (s *AdvancedMailingService) HandleAnalyticsOverview(w http.ResponseWriter,
 		trendWhere += " AND sending_domain = $3"
 		trendArgs = append(trendArgs, trendDomain)
 	}
+	trendWhere += humanTrafficFilter(r, "")
 
 	rows, _ := s.db.QueryContext(ctx, fmt.Sprintf(`
 		SELECT %s as bucket,
@@ -377,7 +412,9 @@ func (s *AdvancedMailingService) HandleAnalyticsOverview(w http.ResponseWriter,
 			"opens": totalOpens, "clicks": totalClicks,
 			"bounces": totalBounces, "hard_bounces": totalHardBounce, "soft_bounces": totalSoftBounce,
 			"complaints": totalComplaints, "revenue": totalRevenue,
+			"machine_opens": machineOpens, "machine_clicks": machineClicks,
 		},
+		"exclude_machine": excludeMachineTraffic(r),
 		"rates": map[string]interface{}{
 			"open_rate": math.Round(openRate*100) / 100, "click_rate": math.Round(clickRate*100) / 100,
 			"bounce_rate": math.Round(bounceRate*100) / 100,
@@ -633,7 +670,7 @@ func (s *AdvancedMailingService) HandleEngagementReport(w http.ResponseWriter, r
 		SELECT DATE(event_at) as day, COUNT(DISTINCT subscriber_id) as engaged_subscribers
 		FROM mailing_tracking_events
 		WHERE event_type IN ('opened', 'clicked')
-		  AND event_at >= $1 AND event_at <= $2
+		  AND event_at >= $1 AND event_at <= $2`+humanTrafficFilter(r, "")+`
 		GROUP BY DATE(event_at)
 		ORDER BY day DESC
 	`, start, end)
@@ -103,6 +103,16 @@ func TestParseAnalyticsRange_Default30Days(t *testing.T) {
 		"default: age=%v", age)
 }
 
+func TestHumanTrafficFilter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/?exclude_machine=true", nil)
+	assert.Equal(t, " AND COALESCE(t.traffic_class, 'human') = 'human'", humanTrafficFilter(req, "t"))
+	assert.Equal(t, " AND COALESCE(traffic_class, 'human') = 'human'", humanTrafficFilter(req, ""))
+
+	for _, q := range []string{"/", "/?exclude_machine=false", "/?exclude_machine=maybe"} {
+		assert.Empty(t, humanTrafficFilter(httptest.NewRequest("GET", q, nil), "t"), q)
+	}
+}
+
 // ─── ComputeInfraRates Tests ──────────────────────────────────────────────────
 
 func TestComputeInfraRates_Normal(t *testing.T) {
@@ -14,6 +14,8 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 )
 
 // sha256Hash generates a SHA256 hash of the input
@@ -34,6 +36,9 @@ type MailingService struct {
 	throttler       *MailingThrottler
 	onTrackingEvent TrackingEventCallback
 	globalHub       GlobalSuppressionChecker
+	segments        *segmentation.MembershipEngine
+	segmentQueue    chan segmentation.Change
+	dkim            *mailing.DKIMKeyStore
 }
 
 // SetTrackingEventCallback registers a callback for open/click/unsubscribe events.
@@ -47,6 +52,22 @@ func (svc *MailingService) SetGlobalSuppressionHub(hub GlobalSuppressionChecker)
 	svc.globalHub = hub
 }
 
+// SetSegmentMembership enables incremental membership updates for realtime
+// segments when tracking events or profile edits touch a subscriber.
+func (svc *MailingService) SetSegmentMembership(m *segmentation.MembershipEngine) {
+	svc.segments = m
+	svc.segmentQueue = make(chan segmentation.Change, segmentQueueSize)
+	for i := 0; i < segmentWorkers; i++ {
+		go svc.runSegmentWorker(svc.segmentQueue)
+	}
+}
+
+// SetDKIMSigner signs messages sent over SMTP (test sends, SES SMTP, PMTA)
+// with the sending domain's key from mailing_dkim_keys.
+func (svc *MailingService) SetDKIMSigner(keys *mailing.DKIMKeyStore) {
+	svc.dkim = keys
+}
+
 func NewMailingService(db *sql.DB, sparkpostKey string) *MailingService {
 	trackingURL := os.Getenv("TRACKING_URL")
 	if trackingURL == "" {
@@ -14,6 +14,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 )
 
 // HandleGetLists returns all lists with per-list mailing stats
@@ -412,6 +413,22 @@ func (svc *MailingService) HandlePatchSubscriber(w http.ResponseWriter, r *http.
 			string(cfJSON), listID, email)
 	}
 
+	if svc.segments != nil {
+		change := segmentation.Change{CustomFields: make([]string, 0, len(input.CustomFields))}
+		if svc.db.QueryRowContext(ctx, `SELECT id, organization_id FROM mailing_subscribers WHERE list_id = $1 AND LOWER(email) = $2`,
+			listID, email).Scan(&change.SubscriberID, &change.OrganizationID) == nil {
+			for field, value := range map[string]string{"first_name": input.FirstName, "last_name": input.LastName, "status": input.Status} {
+				if value != "" {
+					change.Fields = append(change.Fields, field)
+				}
+			}
+			for key := range input.CustomFields {
+				change.CustomFields = append(change.CustomFields, key)
+			}
+			svc.applySegmentChange(change)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "email": email})
 }
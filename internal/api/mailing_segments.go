@@ -671,7 +671,8 @@ func (s *AdvancedMailingService) HandleUpdateSegment(w http.ResponseWriter, r *h
 
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE mailing_segments
-		SET name = $2, description = $3, list_id = $4, status = $5, conditions = $6, updated_at = NOW()
+		SET name = $2, description = $3, list_id = $4, status = $5, conditions = $6,
+		    memberships_synced_at = NULL, updated_at = NOW()
 		WHERE id = $1
 	`, segmentID, input.Name, input.Description, listID, status, conditionsJSON)
 	if err != nil {
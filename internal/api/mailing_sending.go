@@ -25,6 +25,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/ignite/sparkpost-monitor/internal/mailing"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/botdetect"
 )
 
 // HandleSendTestEmail sends a test email through the selected ESP profile
@@ -405,11 +406,7 @@ func (svc *MailingService) HandleSendTransactional(w http.ResponseWriter, r *htt
 		pixelSig := hex.EncodeToString(h.Sum(nil))[:16]
 		pixelEncoded := base64.URLEncoding.EncodeToString([]byte(pixelData))
 		pixel := fmt.Sprintf(`<img src="%s/track/open/%s/%s" width="1" height="1" alt="" style="display:none;" />`, trackBase, pixelEncoded, pixelSig)
-		if idx := strings.LastIndex(strings.ToLower(htmlContent), "</body>"); idx >= 0 {
-			htmlContent = htmlContent[:idx] + pixel + htmlContent[idx:]
-		} else {
-			htmlContent += pixel
-		}
+		htmlContent = mailing.InsertBeforeBodyClose(htmlContent, pixel)
 	}
 
 	// ── CAN-SPAM: inject bottom unsub if not present ──
@@ -418,11 +415,7 @@ func (svc *MailingService) HandleSendTransactional(w http.ResponseWriter, r *htt
 			unsubBlock := fmt.Sprintf(
 				`<div style="text-align:center;padding:16px;font-size:12px;color:#999;font-family:Arial,sans-serif;">`+
 					`<a href="%s" style="color:#999;text-decoration:underline;">Unsubscribe</a></div>`, unsub)
-			if idx := strings.LastIndex(strings.ToLower(htmlContent), "</body>"); idx >= 0 {
-				htmlContent = htmlContent[:idx] + unsubBlock + htmlContent[idx:]
-			} else {
-				htmlContent += unsubBlock
-			}
+			htmlContent = mailing.InsertBeforeBodyClose(htmlContent, unsubBlock)
 		}
 	}
 
@@ -456,6 +449,37 @@ func (svc *MailingService) HandleSendTransactional(w http.ResponseWriter, r *htt
 	w.Write(rec.body)
 }
 
+// SendTransactional sends one automation email through the send-test path
+// (suppression check, throttle and default sending profile). It implements
+// automation.EmailSender.
+func (svc *MailingService) SendTransactional(ctx interface{}, orgID string, to, subject, html string) error {
+	reqCtx, ok := ctx.(context.Context)
+	if !ok {
+		reqCtx = context.Background()
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"to": to, "subject": subject, "html_content": html,
+	})
+	req, err := http.NewRequestWithContext(reqCtx, "POST", "/api/mailing/send-test", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Organization-ID", orgID)
+	rec := &responseRecorder{header: http.Header{}, code: http.StatusOK}
+	svc.HandleSendTestEmail(rec, req)
+
+	var result struct {
+		Success bool   `json:"success"`
+		Reason  string `json:"reason"`
+		Error   string `json:"error"`
+	}
+	json.Unmarshal(rec.body, &result)
+	if rec.code != http.StatusOK || !result.Success {
+		return fmt.Errorf("transactional send to %s failed (%d): %s", to, rec.code, strings.TrimSpace(result.Reason+" "+result.Error))
+	}
+	return nil
+}
+
 func injectPreviewTextTransactional(html, previewText string) string {
 	if previewText == "" || html == "" {
 		return html
@@ -729,6 +753,16 @@ func (svc *MailingService) sendViaSMTP(ctx context.Context, host string, port in
 	msg.WriteString("\r\n")
 	msg.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
 
+	raw := msg.Bytes()
+	if svc.dkim != nil {
+		signed, err := svc.dkim.Sign(ctx, extractDomainFromEmail(fromEmail), "", raw)
+		if err != nil {
+			log.Printf("[SMTP] DKIM signing failed for %s, sending unsigned: %v", fromEmail, err)
+		} else {
+			raw = signed
+		}
+	}
+
 	addr := fmt.Sprintf("%s:%d", host, port)
 	dialer := &net.Dialer{Timeout: 30 * time.Second}
 
@@ -781,7 +815,7 @@ func (svc *MailingService) sendViaSMTP(ctx context.Context, host string, port in
 	if err != nil {
 		return nil, fmt.Errorf("DATA: %w", err)
 	}
-	if _, err := w.Write(msg.Bytes()); err != nil {
+	if _, err := w.Write(raw); err != nil {
 		return nil, fmt.Errorf("write: %w", err)
 	}
 	if err := w.Close(); err != nil {
@@ -1143,11 +1177,7 @@ func (svc *MailingService) injectTrackingWithURL(html string, orgID, campaignID,
 
 	pixel := fmt.Sprintf(`<img src="%s/track/open/%s/%s" width="1" height="1" alt="" style="display:none;width:1px;height:1px" />`,
 		baseURL, encoded, sig)
-	if strings.Contains(html, "</body>") {
-		html = strings.Replace(html, "</body>", pixel+"</body>", 1)
-	} else {
-		html += pixel
-	}
+	html = mailing.InsertBeforeBodyClose(html, pixel)
 
 	linkRegex := regexp.MustCompile(`href=["'](https?://[^"']+)["']`)
 	html = linkRegex.ReplaceAllStringFunc(html, func(match string) string {
@@ -1166,6 +1196,11 @@ func (svc *MailingService) injectTrackingWithURL(html string, orgID, campaignID,
 		return fmt.Sprintf(`href="%s/track/click/%s/%s"`, baseURL, linkEncoded, linkSig)
 	})
 
+	// Hidden honeypot link: only link scanners follow it.
+	hpEncoded := base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%s", trackingData, botdetect.HoneypotURL)))
+	honeypot := botdetect.HoneypotAnchor(fmt.Sprintf("%s/track/click/%s/%s", baseURL, hpEncoded, signData(hpEncoded, svc.signingKey)[:16]))
+	html = mailing.InsertBeforeBodyClose(html, honeypot)
+
 	return html
 }
 
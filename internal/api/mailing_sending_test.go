@@ -6,7 +6,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 )
 
 func TestSendViaPMTAAPI_WithVMTA(t *testing.T) {
@@ -65,3 +69,37 @@ func TestSendViaPMTAAPI_WithoutVMTA(t *testing.T) {
 		t.Error("vmta field should not be present when no X-Virtual-MTA header is set")
 	}
 }
+
+func TestSendTransactional_ReportsSuppression(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM mailing_suppressions").WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	svc := &MailingService{db: db, throttler: NewMailingThrottler()}
+	err = svc.SendTransactional(context.Background(), "org-1", "User@example.com", "Hi", "<p>Hi</p>")
+	if err == nil || !strings.Contains(err.Error(), "suppression") {
+		t.Fatalf("want suppression error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApplySegmentChange_DropsWhenQueueFull(t *testing.T) {
+	svc := &MailingService{}
+	svc.applySegmentChange(segmentation.Change{}) // no membership engine: ignored
+
+	svc.segmentQueue = make(chan segmentation.Change, 1)
+	svc.applySegmentChange(segmentation.Change{Fields: []string{"total_opens"}})
+	svc.applySegmentChange(segmentation.Change{Fields: []string{"total_clicks"}})
+	if len(svc.segmentQueue) != 1 {
+		t.Fatalf("queue holds %d changes, want 1", len(svc.segmentQueue))
+	}
+	if got := <-svc.segmentQueue; got.Fields[0] != "total_opens" {
+		t.Errorf("queued %v, want the first change", got.Fields)
+	}
+}
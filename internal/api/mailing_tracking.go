@@ -17,7 +17,11 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/botdetect"
 	"github.com/ignite/sparkpost-monitor/internal/pkg/logger"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
+	"github.com/lib/pq"
 )
 
 func emailHash(email string) string {
@@ -43,6 +47,39 @@ func (svc *MailingService) verifySig(encoded, sig string) bool {
 	return hmac.Equal([]byte(expected), []byte(sig))
 }
 
+// Realtime segment updates from tracking hits are applied by a fixed pool of
+// workers draining a bounded queue.
+const (
+	segmentQueueSize = 1000
+	segmentWorkers   = 4
+)
+
+// applySegmentChange queues a realtime segment membership update so tracking
+// responses are not held up by segment evaluation. A change arriving while
+// the queue is full is dropped; the segment refresh worker recomputes
+// realtime memberships and emits the transitions it missed.
+func (svc *MailingService) applySegmentChange(change segmentation.Change) {
+	if svc.segmentQueue == nil {
+		return
+	}
+	select {
+	case svc.segmentQueue <- change:
+	default:
+		log.Printf("SEGMENT MEMBERSHIP: queue full, dropping change for subscriber=%s", change.SubscriberID)
+	}
+}
+
+// runSegmentWorker applies queued membership changes.
+func (svc *MailingService) runSegmentWorker(queue <-chan segmentation.Change) {
+	for change := range queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := svc.segments.Apply(ctx, change); err != nil {
+			log.Printf("SEGMENT MEMBERSHIP: subscriber=%s: %v", change.SubscriberID, err)
+		}
+		cancel()
+	}
+}
+
 // ========== REAL-TIME TRACKING HANDLERS ==========
 
 // HandleTrackOpen records an email open event
@@ -80,35 +117,36 @@ func (svc *MailingService) HandleTrackOpen(w http.ResponseWriter, r *http.Reques
 	isp := extractISP(email)
 	log.Printf("TRACK OPEN: campaign=%s subscriber=%s email=%s isp=%s", campaignID, subscriberID, email, isp)
 
-	// Fire in-memory tracker FIRST so dashboards update even if DB write fails
-	if svc.onTrackingEvent != nil {
-		svc.onTrackingEvent(campaignID.String(), "open", email, isp)
-	}
+	verdict := botdetect.Default().Classify(botdetect.Signals{
+		Kind:        botdetect.KindOpen,
+		IP:          r.RemoteAddr,
+		UserAgent:   r.UserAgent(),
+		At:          time.Now(),
+		DeliveredAt: mailing.DeliveredAt(ctx, svc.db, subscriberID, campaignID),
+	})
 
-	// MPP detection: check if delivery happened within the last 30 seconds
-	isMachineOpen := false
-	var deliveredAt time.Time
-	err = svc.db.QueryRowContext(ctx, `
-		SELECT event_at FROM mailing_tracking_events
-		WHERE subscriber_id = $1 AND campaign_id = $2 AND event_type = 'delivered'
-		ORDER BY event_at DESC LIMIT 1
-	`, subscriberID, campaignID).Scan(&deliveredAt)
-	if err == nil && time.Since(deliveredAt) <= 30*time.Second {
-		isMachineOpen = true
+	// Fire in-memory tracker FIRST so dashboards update even if DB write fails.
+	// Machine opens are not the subscriber's engagement and are left out.
+	if svc.onTrackingEvent != nil && !verdict.Machine() {
+		svc.onTrackingEvent(campaignID.String(), "open", email, isp)
 	}
 
 	if _, err := svc.db.ExecContext(ctx, `
-		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, sending_domain, is_machine_open)
+		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, sending_domain, is_machine_open, traffic_class, bot_reasons)
 		SELECT $1, $2, $3, $4, 'opened', NOW(), $5::inet, $6, $7,
-			LOWER(SPLIT_PART(c.from_email, '@', 2)), $8
+			LOWER(SPLIT_PART(c.from_email, '@', 2)), $8, $9, $10
 		FROM mailing_campaigns c WHERE c.id = $3
 		ON CONFLICT DO NOTHING
-	`, emailID, orgID, campaignID, subscriberID, extractIPFromRemoteAddr(r.RemoteAddr), r.UserAgent(), detectDeviceType(r.UserAgent()), isMachineOpen); err != nil {
+	`, emailID, orgID, campaignID, subscriberID, extractIPFromRemoteAddr(r.RemoteAddr), r.UserAgent(), detectDeviceType(r.UserAgent()),
+		verdict.Machine(), string(verdict.Class), pq.Array(verdict.ReasonStrings())); err != nil {
 		log.Printf("TRACK OPEN DB ERROR: %v", err)
 	}
+	if err := mailing.RecordABEngagement(ctx, svc.db, campaignID, subscriberID, mailing.ABEventOpen, string(verdict.Class)); err != nil {
+		log.Printf("TRACK OPEN A/B event error: %v", err)
+	}
 
-	if isMachineOpen {
-		log.Printf("TRACK OPEN MPP: campaign=%s subscriber=%s delta=%v", campaignID, subscriberID, time.Since(deliveredAt))
+	if verdict.Machine() {
+		log.Printf("TRACK OPEN %s: campaign=%s subscriber=%s reasons=%v", verdict.Class, campaignID, subscriberID, verdict.Reasons)
 	}
 
 	svc.db.ExecContext(ctx, `UPDATE mailing_campaigns SET open_count = COALESCE(open_count, 0) + 1 WHERE id = $1`, campaignID)
@@ -130,6 +168,12 @@ func (svc *MailingService) HandleTrackOpen(w http.ResponseWriter, r *http.Reques
 
 	svc.updateEngagementScore(ctx, subscriberID)
 	svc.updateISPAgent(ctx, campaignID, isp, "open")
+	svc.applySegmentChange(segmentation.Change{
+		OrganizationID: orgID,
+		SubscriberID:   subscriberID,
+		Fields:         []string{"total_opens", "last_open_at", "engagement_score"},
+		Events:         []string{"opened"},
+	})
 
 	svc.serveTrackingPixel(w)
 }
@@ -170,19 +214,46 @@ func (svc *MailingService) HandleTrackClick(w http.ResponseWriter, r *http.Reque
 	isp := extractISP(email)
 	log.Printf("TRACK CLICK: campaign=%s subscriber=%s email_id=%s url=%s isp=%s", campaignID, subscriberID, emailID, originalURL, isp)
 
-	// Fire in-memory tracker FIRST
-	if svc.onTrackingEvent != nil {
+	now := time.Now()
+	verdict := botdetect.Default().Classify(botdetect.Signals{
+		Kind:         botdetect.KindClick,
+		IP:           r.RemoteAddr,
+		UserAgent:    r.UserAgent(),
+		At:           now,
+		DeliveredAt:  mailing.DeliveredAt(ctx, svc.db, subscriberID, campaignID),
+		LinkURL:      originalURL,
+		RecentClicks: mailing.RecentClicks(ctx, svc.db, subscriberID, campaignID, now),
+	})
+
+	// Fire in-memory tracker FIRST; scanner clicks are left out.
+	if svc.onTrackingEvent != nil && !verdict.Machine() {
 		svc.onTrackingEvent(campaignID.String(), "click", email, isp)
 	}
 
 	if _, err := svc.db.ExecContext(ctx, `
-		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, link_url, sending_domain)
+		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, link_url, sending_domain, traffic_class, bot_reasons)
 		SELECT $1, $2, $3, $4, 'clicked', NOW(), $5::inet, $6, $7, $8,
-			LOWER(SPLIT_PART(c.from_email, '@', 2))
+			LOWER(SPLIT_PART(c.from_email, '@', 2)), $9, $10
 		FROM mailing_campaigns c WHERE c.id = $3
-	`, uuid.New(), orgID, campaignID, subscriberID, extractIPFromRemoteAddr(r.RemoteAddr), r.UserAgent(), detectDeviceType(r.UserAgent()), originalURL); err != nil {
+	`, uuid.New(), orgID, campaignID, subscriberID, extractIPFromRemoteAddr(r.RemoteAddr), r.UserAgent(), detectDeviceType(r.UserAgent()), originalURL,
+		string(verdict.Class), pq.Array(verdict.ReasonStrings())); err != nil {
 		log.Printf("TRACK CLICK DB ERROR: %v", err)
 	}
+	if err := mailing.RecordABEngagement(ctx, svc.db, campaignID, subscriberID, mailing.ABEventClick, string(verdict.Class)); err != nil {
+		log.Printf("TRACK CLICK A/B event error: %v", err)
+	}
+
+	// Scanner clicks are kept for reporting only; they are not the
+	// subscriber's engagement and never leave for the honeypot.
+	if verdict.Class == botdetect.ClassScannerClick {
+		log.Printf("TRACK CLICK scanner: campaign=%s subscriber=%s reasons=%v", campaignID, subscriberID, verdict.Reasons)
+		if botdetect.IsHoneypot(originalURL) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, originalURL, http.StatusTemporaryRedirect)
+		return
+	}
 
 	svc.db.ExecContext(ctx, `UPDATE mailing_campaigns SET click_count = COALESCE(click_count, 0) + 1 WHERE id = $1`, campaignID)
 
@@ -203,6 +274,12 @@ func (svc *MailingService) HandleTrackClick(w http.ResponseWriter, r *http.Reque
 
 	svc.updateEngagementScore(ctx, subscriberID)
 	svc.updateISPAgent(ctx, campaignID, isp, "click")
+	svc.applySegmentChange(segmentation.Change{
+		OrganizationID: orgID,
+		SubscriberID:   subscriberID,
+		Fields:         []string{"total_clicks", "last_click_at", "engagement_score"},
+		Events:         []string{"clicked"},
+	})
 
 	http.Redirect(w, r, originalURL, http.StatusTemporaryRedirect)
 }
@@ -272,6 +349,13 @@ func (svc *MailingService) HandleTrackUnsubscribe(w http.ResponseWriter, r *http
 	}
 
 	log.Printf("TRACK UNSUBSCRIBE: campaign=%s subscriber=%s email=%s → global suppression", campaignID, subscriberID, logger.RedactEmail(email))
+	svc.applySegmentChange(segmentation.Change{
+		OrganizationID: orgID,
+		SubscriberID:   subscriberID,
+		Fields:         []string{"status"},
+		Events:         []string{"unsubscribed"},
+		Suppressed:     true,
+	})
 
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(`<!DOCTYPE html>
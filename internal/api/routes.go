@@ -8,12 +8,79 @@ import (
 
 	"github.com/ignite/sparkpost-monitor/internal/auth"
 
-
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 )
 
+var writeMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// routePermissions is the RBAC matrix for /api. The first matching rule
+// decides; reads not listed need auth.PermView and writes auth.PermEditContent.
+// Rules with an audit action log every request they match.
+var routePermissions = []RouteRule{
+	// Roles and the audit trail
+	{Prefix: "/api/access/me", Permission: auth.PermView},
+	{Prefix: "/api/access", Methods: writeMethods, Permission: auth.PermAdmin, Audit: "access.change"},
+	{Prefix: "/api/access", Permission: auth.PermAdmin},
+	{Prefix: "/api/system", Methods: writeMethods, Permission: auth.PermAdmin, Audit: "system.change"},
+	{Prefix: "/api/financial/config", Methods: writeMethods, Permission: auth.PermAdmin, Audit: "financial.config_change"},
+
+	// POSTs that only compute or read
+	{Prefix: "/api/agent/chat", Permission: auth.PermView},
+	{Prefix: "/api/mailing/suppressions/check-batch", Permission: auth.PermView},
+	{Prefix: "/api/mailing/pmta-campaign", Segments: []string{"intel", "estimate-audience", "dry-run"}, Permission: auth.PermView},
+
+	// Sending to real recipients
+	{Prefix: "/api/mailing/pmta-campaign", Segments: []string{"emergency-stop"}, Permission: auth.PermManageDeliverability, Audit: "campaign.emergency_stop"},
+	{Prefix: "/api/mailing/pmta-campaign/trigger-send", Permission: auth.PermSendCampaign, Audit: "campaign.send"},
+	{Prefix: "/api/mailing", Segments: sendSegments, Methods: writeMethods, Permission: auth.PermSendCampaign, Audit: "campaign.send"},
+	{Prefix: "/api/mailing/jarvis", Methods: writeMethods, Permission: auth.PermSendCampaign, Audit: "campaign.jarvis"},
+
+	// Deliverability controls
+	{Prefix: "/api/mailing/engine", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "engine.change"},
+	{Prefix: "/api/mailing/pmta", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "pmta.change"},
+	{Prefix: "/api/mailing/pmta-servers", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "pmta.change"},
+	{Prefix: "/api/mailing/ips", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "ip.change"},
+	{Prefix: "/api/mailing/ip-pools", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "ip.change"},
+	{Prefix: "/api/mailing/throttle", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "throttle.change"},
+	{Prefix: "/api/mailing/isp-agents", Methods: writeMethods, Permission: auth.PermManageDeliverability},
+	{Prefix: "/api/mailing/ipxo", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "infrastructure.change"},
+	{Prefix: "/api/mailing/vultr", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "infrastructure.change"},
+	{Prefix: "/api/mailing/ovh", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "infrastructure.change"},
+	{Prefix: "/api/mailing/aws", Methods: writeMethods, Permission: auth.PermManageDeliverability, Audit: "infrastructure.change"},
+
+	// Suppressions: removing an address can mail someone who opted out
+	{Prefix: "/api/mailing", Segments: []string{"*suppression*"}, Methods: []string{http.MethodDelete},
+		Permission: auth.PermManageSuppressions, Audit: "suppression.delete"},
+	{Prefix: "/api/mailing", Segments: []string{"*suppression*"}, Methods: writeMethods,
+		Permission: auth.PermManageSuppressions, Audit: "suppression.change"},
+}
+
+// sendSegments are the path segments of endpoints that send mail.
+var sendSegments = []string{"send", "send-async", "send-winner", "send-transactional", "schedule", "deploy"}
+
+// routeScopes maps /api routes to API key scopes, e.g. subscribers:write.
+// The first match decides; routes not listed cannot be called with a key.
+var routeScopes = []ScopeRule{
+	{Prefix: "/api/mailing/pmta-campaign/trigger-send", Scope: "campaigns:send"},
+	{Prefix: "/api/mailing", Segments: sendSegments, Methods: writeMethods, Scope: "campaigns:send"},
+	{Prefix: "/api/mailing", Segments: []string{"*suppression*"}, Resource: "suppressions"},
+	{Prefix: "/api/mailing", Segments: []string{"subscribers", "import"}, Resource: "subscribers"},
+	{Prefix: "/api/mailing/lists", Resource: "lists"},
+	{Prefix: "/api/mailing/campaigns", Resource: "campaigns"},
+	{Prefix: "/api/mailing/templates", Resource: "templates"},
+	{Prefix: "/api/mailing/segments", Resource: "segments"},
+	{Prefix: "/api/mailing/v2/segments", Resource: "segments"},
+	{Prefix: "/api/mailing/v2/events", Resource: "events"},
+	{Prefix: "/api/mailing/engine", Resource: "engine"},
+	{Prefix: "/api/dashboard", Resource: "analytics"},
+	{Prefix: "/api/metrics", Resource: "analytics"},
+	{Prefix: "/api/isp", Resource: "analytics"},
+	{Prefix: "/api/ip", Resource: "analytics"},
+	{Prefix: "/api/domain", Resource: "analytics"},
+}
+
 // SetupRoutes configures all API routes.
 // Returns the top-level mux AND the /api sub-router so that late-registered
 // route groups (e.g. mailing) can be mounted inside /api and inherit its
@@ -77,25 +144,13 @@ func SetupRoutes(h *Handlers, authManager *auth.AuthManager) (*chi.Mux, chi.Rout
 
 	r.Route("/api", func(r chi.Router) {
 		apiRouter = r // capture so late-registered groups can use it
-		// Apply auth middleware to all API routes (skip in dev mode)
-		adminKey := os.Getenv("ADMIN_API_KEY")
+		// Apply auth and role checks to all API routes (skip in dev mode)
+		h.access = NewAccessControl(authManager, os.Getenv("ADMIN_API_KEY"), routePermissions, routeScopes)
 		if authManager != nil && !devMode {
-			r.Use(func(next http.Handler) http.Handler {
-				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-					if adminKey != "" && req.Header.Get("X-Admin-Key") == adminKey {
-						next.ServeHTTP(w, req)
-						return
-					}
-					if !authManager.IsAuthenticated(req) {
-						w.Header().Set("Content-Type", "application/json")
-						w.WriteHeader(http.StatusUnauthorized)
-						w.Write([]byte(`{"error":"unauthorized"}`))
-						return
-					}
-					next.ServeHTTP(w, req)
-				})
-			})
+			r.Use(h.access.Middleware)
 		}
+		h.access.RegisterRoutes(r)
+
 		// Dashboard - all data in one call
 		r.Get("/dashboard", h.GetDashboard)
 		r.Get("/dashboard/combined", h.GetCombinedDashboard)
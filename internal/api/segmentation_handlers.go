@@ -28,6 +28,11 @@ func NewSegmentationAPI(db *sql.DB) *SegmentationAPI {
 	}
 }
 
+// Membership returns the incremental membership engine behind the handlers
+func (api *SegmentationAPI) Membership() *segmentation.MembershipEngine {
+	return api.engine.Membership()
+}
+
 // RegisterRoutes registers segmentation routes under /api/mailing/v2
 func (api *SegmentationAPI) RegisterRoutes(r chi.Router) {
 	r.Route("/v2/segments", func(r chi.Router) {
@@ -233,6 +238,7 @@ func (api *SegmentationAPI) CreateSegment(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	api.seedMemberships(segment.ID)
 	segmentRespondJSON(w, segment)
 }
 
@@ -347,9 +353,24 @@ func (api *SegmentationAPI) UpdateSegment(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	api.engine.Membership().Invalidate(orgID)
+	api.seedMemberships(segment.ID)
 	segmentRespondJSON(w, segment)
 }
 
+// seedMemberships seeds a saved realtime segment's memberships in the
+// background without emitting events, so its current members do not enter
+// journeys and flows. An update recreates the segment under a new id.
+func (api *SegmentationAPI) seedMemberships(segmentID uuid.UUID) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if err := api.engine.Membership().Seed(ctx, segmentID); err != nil {
+			log.Printf("[Segment] membership seed error for %s: %v", segmentID, err)
+		}
+	}()
+}
+
 // DeleteSegment deletes a segment
 func (api *SegmentationAPI) DeleteSegment(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -367,6 +388,7 @@ func (api *SegmentationAPI) DeleteSegment(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	api.engine.Membership().Invalidate(orgID)
 	w.WriteHeader(http.StatusNoContent)
 }
 
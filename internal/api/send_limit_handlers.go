@@ -0,0 +1,121 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/worker"
+)
+
+// CampaignBottleneckResponse shows which send limit level holds a campaign
+// back, per recipient domain and overall
+type CampaignBottleneckResponse struct {
+	CampaignID   string                    `json:"campaign_id"`
+	Bottleneck   worker.LimitLevel         `json:"bottleneck,omitempty"`
+	BottleneckID string                    `json:"bottleneck_id,omitempty"`
+	Domain       string                    `json:"domain,omitempty"`
+	NextMinute   int                       `json:"next_minute"`
+	Domains      []*worker.LimiterSnapshot `json:"domains"`
+}
+
+// SetLevelLimitRequest overrides the token buckets of one send limit level
+type SetLevelLimitRequest struct {
+	Buckets []worker.Bucket `json:"buckets"`
+}
+
+// HandleGetCampaignBottleneck inspects the send limits a campaign passes
+// through. Domains are given as ?domain=...; by default the primary domain
+// of each throttled ISP is checked.
+func (a *AdvancedThrottleAPI) HandleGetCampaignBottleneck(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	campaignID := chi.URLParam(r, "campaignId")
+
+	var profileID string
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COALESCE(sending_profile_id::text, '') FROM mailing_campaigns WHERE id = $1
+	`, campaignID).Scan(&profileID)
+	if err == sql.ErrNoRows {
+		throttleWriteError(w, "Campaign not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		throttleWriteError(w, "Failed to load campaign", http.StatusInternalServerError)
+		return
+	}
+
+	domains := r.URL.Query()["domain"]
+	if len(domains) == 0 {
+		isps := make([]string, 0, len(worker.DefaultISPLimits))
+		for isp := range worker.DefaultISPLimits {
+			isps = append(isps, isp)
+		}
+		sort.Strings(isps)
+		for _, isp := range isps {
+			domains = append(domains, worker.ISPDomains[isp][0])
+		}
+	}
+
+	resp := CampaignBottleneckResponse{CampaignID: campaignID}
+	for _, domain := range domains {
+		snapshot, err := a.limiter.Inspect(ctx, worker.SendScope{
+			OrgID:      throttleGetOrgID(r),
+			ProfileID:  profileID,
+			Domain:     domain,
+			CampaignID: campaignID,
+		})
+		if err != nil {
+			throttleWriteError(w, "Failed to inspect send limits", http.StatusInternalServerError)
+			return
+		}
+		resp.Domains = append(resp.Domains, snapshot)
+
+		if snapshot.Bottleneck != "" && (resp.Bottleneck == "" || snapshot.NextMinute < resp.NextMinute) {
+			resp.Bottleneck, resp.BottleneckID = snapshot.Bottleneck, snapshot.BottleneckID
+			resp.Domain, resp.NextMinute = snapshot.Scope.Domain, snapshot.NextMinute
+		}
+	}
+
+	throttleWriteJSON(w, resp, http.StatusOK)
+}
+
+// HandleSetLevelLimit overrides the buckets for one level, e.g. an IP pool
+func (a *AdvancedThrottleAPI) HandleSetLevelLimit(w http.ResponseWriter, r *http.Request) {
+	level := worker.LimitLevel(chi.URLParam(r, "level"))
+	id := chi.URLParam(r, "id")
+	if !slices.Contains(worker.LimitLevels, level) {
+		throttleWriteError(w, "Unknown limit level", http.StatusBadRequest)
+		return
+	}
+
+	var req SetLevelLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Buckets) == 0 {
+		throttleWriteError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.limiter.SetLimit(r.Context(), throttleGetOrgID(r), level, id, req.Buckets); err != nil {
+		throttleWriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	throttleWriteJSON(w, worker.LevelLimit{Level: level, ID: id, Buckets: req.Buckets}, http.StatusOK)
+}
+
+// HandleClearLevelLimit removes a level override, restoring its default limits
+func (a *AdvancedThrottleAPI) HandleClearLevelLimit(w http.ResponseWriter, r *http.Request) {
+	level := worker.LimitLevel(chi.URLParam(r, "level"))
+	if !slices.Contains(worker.LimitLevels, level) {
+		throttleWriteError(w, "Unknown limit level", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.limiter.SetLimit(r.Context(), throttleGetOrgID(r), level, chi.URLParam(r, "id"), nil); err != nil {
+		throttleWriteError(w, "Failed to clear limit", http.StatusInternalServerError)
+		return
+	}
+
+	throttleWriteJSON(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
@@ -9,10 +9,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/go-chi/chi/v5"
 	"github.com/ignite/sparkpost-monitor/internal/agent"
+	"github.com/ignite/sparkpost-monitor/internal/automation"
 	"github.com/ignite/sparkpost-monitor/internal/auth"
 	"github.com/ignite/sparkpost-monitor/internal/config"
 	"github.com/ignite/sparkpost-monitor/internal/sparkpost"
 	"github.com/ignite/sparkpost-monitor/internal/storage"
+	"github.com/ignite/sparkpost-monitor/internal/worker"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -38,6 +40,11 @@ type Server struct {
 	mailingSvc     *MailingService
 	// Global suppression hub — exported so main.go can wire it to the send worker pool
 	GlobalHub      interface{ IsSuppressed(email string) bool }
+	// Hierarchical send limiter — exported so main.go can wire it to the send worker pool
+	SendLimiter    *worker.SendLimiter
+	// Automation flow engine — exported so main.go can start it and feed it
+	// the tracking consumer's segment membership events
+	FlowEngine     *automation.FlowEngine
 	// S3 data normalizer for operational API
 	dataNormHandler *DataNormHandler
 }
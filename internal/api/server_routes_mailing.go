@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/ignite/sparkpost-monitor/internal/auth"
+	"github.com/ignite/sparkpost-monitor/internal/automation"
 	"github.com/ignite/sparkpost-monitor/internal/datanorm"
 	"github.com/ignite/sparkpost-monitor/internal/engine"
 	"github.com/ignite/sparkpost-monitor/internal/ipxo"
@@ -18,6 +20,7 @@ import (
 	"github.com/ignite/sparkpost-monitor/internal/ovh"
 	"github.com/ignite/sparkpost-monitor/internal/pmta"
 	"github.com/ignite/sparkpost-monitor/internal/vultr"
+	"github.com/ignite/sparkpost-monitor/internal/worker"
 )
 
 // SetMailingDB sets the PostgreSQL database for mailing platform and registers routes
@@ -31,8 +34,25 @@ func (s *Server) SetMailingDB(db *sql.DB) {
 		if sparkpostKey == "" {
 			sparkpostKey = "3150faa70a8b75b57a2ce5277a8c5fc7dc401d1c"
 		}
+		// Roles live on the users table; sensitive actions go to mailing_audit_log.
+		// Machine clients authenticate with scoped keys from api_keys.
+		if s.authManager != nil {
+			roleOrgID := os.Getenv("DEFAULT_ORG_ID")
+			if roleOrgID == "" {
+				roleOrgID = "00000000-0000-0000-0000-000000000001"
+			}
+			s.authManager.SetRoleStore(auth.NewDBRoleStore(db), roleOrgID)
+		}
+		if s.handlers != nil && s.handlers.access != nil {
+			s.handlers.access.SetAuditLog(NewAuditLog(db))
+			keyStore := auth.NewDBAPIKeyStore(db)
+			s.handlers.access.SetAPIKeys(auth.NewAPIKeyAuthenticator(keyStore, s.redisClient), keyStore)
+		}
+
 		svc := NewMailingService(db, sparkpostKey)
 		s.mailingSvc = svc
+		dkimKeys := mailing.NewDKIMKeyStore(db)
+		svc.SetDKIMSigner(dkimKeys)
 		advSvc := NewAdvancedMailingService(db)
 		
 		// Injection Analytics — public (no auth required)
@@ -191,10 +211,6 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			
 			// === ADVANCED FEATURES ===
 			
-			// Bounce/Complaint Webhooks (auto-suppression)
-			r.Post("/webhooks/sparkpost", advSvc.HandleSparkPostWebhook)
-			r.Post("/webhooks/ses", advSvc.HandleSESWebhook)
-			
 			// A/B Testing
 			r.Get("/ab-tests", advSvc.HandleGetABTests)
 			r.Post("/ab-tests", advSvc.HandleCreateABTest)
@@ -434,6 +450,10 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			// === ENTERPRISE SEGMENTATION ENGINE ===
 			segmentationAPI := NewSegmentationAPI(db)
 			segmentationAPI.RegisterRoutes(r)
+			segmentationAPI.Membership().Subscribe(worker.NewSegmentJourneyTrigger(db).HandleMembershipEvent)
+			s.FlowEngine = automation.NewFlowEngine(db, svc)
+			segmentationAPI.Membership().Subscribe(s.FlowEngine.HandleMembershipEvent)
+			svc.SetSegmentMembership(segmentationAPI.Membership())
 			
 			// === SEGMENT CLEANUP & HYGIENE ===
 			segmentCleanupAPI := NewSegmentCleanupAPI(db)
@@ -458,12 +478,15 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			// Note: AWS infrastructure is optional - if AWS credentials aren't available, this will be nil
 			awsInfraCfg := mailing.AWSInfraConfig{
 				Region:       "us-east-1",  // Default region
-				HostedZoneID: "",           // Set from config if Route53 management is needed
+				HostedZoneID: os.Getenv("ROUTE53_HOSTED_ZONE_ID"), // Required for Route53 management (DKIM rotation)
 			}
 			awsInfraService, err := mailing.NewAWSInfrastructureService(context.Background(), db, awsInfraCfg)
 			if err == nil && awsInfraService != nil {
 				awsInfraHandlers := NewAWSInfrastructureHandlers(db, awsInfraService)
 				awsInfraHandlers.RegisterRoutes(r)
+				if awsInfraCfg.HostedZoneID != "" {
+					startDKIMRotation(db, awsInfraService, dkimKeys)
+				}
 			}
 			
 			// One-click unsubscribe (RFC 8058)
@@ -478,6 +501,7 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			if s.redisClient != nil {
 				advancedThrottleAPI := NewAdvancedThrottleAPI(db, s.redisClient)
 				advancedThrottleAPI.RegisterRoutes(r)
+				s.SendLimiter = advancedThrottleAPI.GetSendLimiter()
 			}
 			
 			// === IMAGE CDN & HOSTING ===
@@ -562,7 +586,9 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 
 			// === PMTA MULTI-AGENT GOVERNANCE ENGINE ===
 			engineOrgID := "00000000-0000-0000-0000-000000000001"
-			registry := engine.NewISPRegistry()
+			registry := engine.NewISPRegistryForOrg(engineOrgID)
+			ispOverrideStore := engine.NewISPOverrideStore(db, engineOrgID, registry)
+			_ = ispOverrideStore.LoadFromDB(context.Background())
 			signalStore := &engine.DBSignalStore{DB: db}
 			signalProcessor := engine.NewSignalProcessor(signalStore, engineOrgID, registry)
 			suppressionDir := os.Getenv("PMTA_SUPPRESSION_DIR")
@@ -586,6 +612,7 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			convictionStore.LoadAll(context.Background())
 
 			agentFactory := engine.NewAgentFactory(db, engineOrgID, engineMemory, suppressionStore, convictionStore)
+			agentFactory.SetRegistry(registry)
 			_ = agentFactory.Initialize(context.Background())
 
 			pmtaHost := os.Getenv("PMTA_SSH_HOST")
@@ -600,7 +627,41 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 					pmtaSSHKey = home + "/.ssh/ovh_pmta"
 				}
 			}
-			executor := engine.NewExecutor(pmtaHost, pmtaSSHPort, pmtaSSHUser, pmtaSSHKey)
+			pmtaMgmtHost := os.Getenv("PMTA_MGMT_HOST")
+			pmtaMgmtPort := 19000
+			pmtaMgmtUser := os.Getenv("PMTA_MGMT_USER")
+			pmtaMgmtPass := os.Getenv("PMTA_MGMT_PASSWORD")
+
+			// PMTA_EXECUTOR=http drives PMTA through the management API so
+			// the container needs no SSH key; the default is SSH.
+			var executor *engine.Executor
+			if os.Getenv("PMTA_EXECUTOR") == "http" {
+				baseURL := ""
+				if pmtaMgmtHost != "" {
+					baseURL = fmt.Sprintf("https://%s:%d", pmtaMgmtHost, pmtaMgmtPort)
+				}
+				executor = engine.NewExecutorWithBackend(engine.NewHTTPBackend(engine.HTTPBackendConfig{
+					BaseURL:  baseURL,
+					User:     pmtaMgmtUser,
+					Password: pmtaMgmtPass,
+					APIKey:   os.Getenv("PMTA_MGMT_API_KEY"),
+				}))
+			} else {
+				executor = engine.NewExecutor(pmtaHost, pmtaSSHPort, pmtaSSHUser, pmtaSSHKey)
+			}
+
+			// Nodes in mailing_pmta_nodes receive decisions for the pools they
+			// carry; with none configured the single executor above is used.
+			fleetStore := engine.NewFleetStore(db, engineOrgID)
+			fleet := engine.NewFleet(fleetStore, engine.NodeBackends(engine.FleetCredentials{
+				SSHUser:      pmtaSSHUser,
+				SSHKey:       pmtaSSHKey,
+				MgmtUser:     pmtaMgmtUser,
+				MgmtPassword: pmtaMgmtPass,
+				MgmtAPIKey:   os.Getenv("PMTA_MGMT_API_KEY"),
+			}))
+			fleet.SetFallback(executor)
+			_ = fleet.Load(context.Background())
 
 		alertSMTPPort := 587
 		if p := os.Getenv("ALERT_SMTP_PORT"); p != "" {
@@ -621,13 +682,16 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			SMTPPort: alertSMTPPort,
 			From:     alertFrom,
 			To:       []string{alertTo},
+
+			WebhookURL:          os.Getenv("ALERT_WEBHOOK_URL"),
+			WebhookSecret:       os.Getenv("ALERT_WEBHOOK_SECRET"),
+			SlackWebhookURL:     os.Getenv("ALERT_SLACK_WEBHOOK_URL"),
+			PagerDutyRoutingKey: os.Getenv("PAGERDUTY_ROUTING_KEY"),
+			RouteSpec:           os.Getenv("ALERT_ROUTES"),
 		}
 			alerter := engine.NewAlerter(alerterCfg)
+			alerter.SetIncidentStore(&engine.DBIncidentStore{DB: db}, engineOrgID)
 
-			pmtaMgmtHost := os.Getenv("PMTA_MGMT_HOST")
-			pmtaMgmtPort := 19000
-			pmtaMgmtUser := os.Getenv("PMTA_MGMT_USER")
-			pmtaMgmtPass := os.Getenv("PMTA_MGMT_PASSWORD")
 			ingestorCfg := engine.IngestorConfig{
 				PMTAHost:     pmtaMgmtHost,
 				PMTAPort:     pmtaMgmtPort,
@@ -635,17 +699,35 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 				PMTAPassword: pmtaMgmtPass,
 			}
 			ingestor := engine.NewIngestor(registry, signalProcessor, ingestorCfg)
-			ingestor.SetDB(db)
+			ingestor.SetFleet(fleet)
 
 			decisionStore := &engine.DBDecisionStore{DB: db}
 			orchestrator := engine.NewOrchestrator(
 				decisionStore, engineOrgID, agentFactory, signalProcessor,
-				ingestor, executor, alerter, engineMemory, suppressionStore,
+				ingestor, fleet, alerter, engineMemory, suppressionStore,
 			)
 
 			ruleStore := engine.NewRuleStore(db, engineOrgID)
+			orchestrator.SetShadowRules(engine.NewShadowRuleEvaluator(ruleStore))
 
 			engineAPI := NewEngineService(db, orchestrator, suppressionStore, convictionStore, signalProcessor, ruleStore, engineOrgID)
+			engineAPI.SetISPDomains(registry, ispOverrideStore, engine.NewISPReclassifier(db, registry))
+			engineAPI.SetAlerter(alerter)
+			engineAPI.SetFleet(fleet, fleetStore, ingestor)
+
+			// Closed-loop send rates per ISP and source IP, capped at each
+			// ISP's max_msg_rate and applied to PMTA and the send limiter.
+			var ispConfigs []engine.ISPConfig
+			for _, isp := range engine.AllISPs() {
+				if cfg, ok := agentFactory.Config(isp); ok {
+					ispConfigs = append(ispConfigs, cfg)
+				}
+			}
+			rateController := engine.NewRateController(engineOrgID, ispConfigs,
+				&engine.DBRateAdjustmentStore{DB: db}, engine.DefaultRateControllerConfig())
+			rateController.SetSink(&engineRateSink{pmta: fleet, limiter: s.SendLimiter, orgID: engineOrgID})
+			rateController.Start(context.Background(), signalProcessor)
+			engineAPI.SetRateController(rateController)
 			engineAPI.RegisterRoutes(r)
 
 			// === PMTA CAMPAIGN WIZARD (ISP-native campaign creation) ===
@@ -691,21 +773,14 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			// === GLOBAL SUPPRESSION HUB — Single Source of Truth ===
 			globalHub := engine.NewGlobalSuppressionHub(db, engineOrgID, suppressionDir)
 			_ = globalHub.LoadFromDB(context.Background())
-			globalHub.SetExecutor(executor, "/etc/pmta/suppressions")
+			globalHub.SetExecutor(fleet, "/etc/pmta/suppressions")
 			globalHub.StartFileSync(context.Background())
 
-			// FBL webhook — public (receives ARF reports from ISPs)
-			fblHandler := NewFBLHandler(db, globalHub)
-			s.router.Post("/fbl/report", fblHandler.HandleARFReport)
-
 			// Bridge: every agent-level suppression also feeds the global hub
 			suppressionStore.SetGlobalSuppressionCallback(func(ctx context.Context, email, reason, source, isp, dsnCode, dsnDiag, sourceIP, campaign string) {
 				globalHub.Suppress(ctx, email, reason, source, isp, dsnCode, dsnDiag, sourceIP, campaign)
 			})
 
-			// Bridge: ingestor feeds ALL negative PMTA signals to global hub
-			ingestor.SetGlobalSuppressionHub(globalHub)
-
 			// Wire global hub to SuppressionService (consolidates all global suppression)
 			suppSvc.SetGlobalSuppressionHub(globalHub)
 
@@ -715,7 +790,7 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			}
 			campaignBuilder.SetGlobalSuppressionHub(globalHub)
 			pmtaCampaignAPI.SetGlobalSuppressionHub(globalHub)
-			pmtaCampaignAPI.SetExecutor(executor)
+			pmtaCampaignAPI.SetExecutor(fleet)
 
 			// Export for main.go to wire to the send worker pool
 			s.GlobalHub = globalHub
@@ -777,11 +852,23 @@ text-decoration:none;border-radius:6px;margin-top:16px}</style></head><body>
 			consciousnessAPI := NewConsciousnessService(consciousness, campaignTracker, convictionStore, signalProcessor, engineOrgID)
 			consciousnessAPI.RegisterRoutes(r)
 
-			// Webhook endpoint for PMTA accounting records (also available on the authenticated path)
-			r.Post("/engine/webhook", ingestor.HandleWebhook)
+			// Unified delivery-event webhooks — public; every adapter verifies
+			// its provider's signature.
+			espEvents := newESPEventHandler(db, globalHub, ingestor)
+			s.router.Mount("/webhooks/esp", espEvents.Routes())
+
+			// The endpoints that predate /webhooks/esp feed the same pipeline
+			// through their provider's adapter, so each event is applied once
+			// whichever path it arrives on.
+			r.Post("/webhooks/sparkpost", espEvents.Provider("sparkpost"))
+			r.Post("/webhooks/ses", espEvents.Provider("ses"))
+			s.router.Post("/fbl/report", espEvents.Provider("arf"))
+
+			// PMTA accounting records (also available on the authenticated path)
+			r.Post("/engine/webhook", espEvents.Provider("pmta"))
 
-			// Wire the public webhook handler now that ingestor exists
-			pmtaWebhookHandler = ingestor.HandleWebhook
+			// Wire the public webhook handler now that the pipeline exists
+			pmtaWebhookHandler = espEvents.Provider("pmta")
 
 			// Start the orchestrator (launches all 48 agents)
 			orchestrator.Start(context.Background())
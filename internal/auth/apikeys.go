@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// APIKeyPrefix starts every API key so leaked keys are easy to grep for.
+const APIKeyPrefix = "ipk_"
+
+// DefaultAPIKeyRateLimit is the per-minute request limit for new keys.
+const DefaultAPIKeyRateLimit = 600
+
+// ErrInvalidAPIKey is returned for unknown, expired or revoked keys.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey is a machine credential scoped to an organization. Scopes have the
+// form resource:action, e.g. subscribers:write or suppressions:read; only
+// the SHA-256 of the key is stored.
+type APIKey struct {
+	ID                 string     `json:"id"`
+	OrganizationID     string     `json:"organization_id"`
+	Name               string     `json:"name"`
+	Prefix             string     `json:"prefix"`
+	Scopes             []string   `json:"scopes"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+	CreatedBy          string     `json:"created_by,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	LastUsedAt         *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key grants scope. "*" grants everything,
+// "resource:*" every action on a resource, and write implies read.
+func (k APIKey) HasScope(scope string) bool {
+	resource, action, _ := strings.Cut(scope, ":")
+	for _, s := range k.Scopes {
+		switch s {
+		case "*", scope, resource + ":*":
+			return true
+		}
+		if action == "read" && s == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key can be used at now.
+func (k APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// ValidateScopes checks that every scope is resource:action with a known
+// action, or "*".
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, s := range scopes {
+		if s == "*" {
+			continue
+		}
+		resource, action, ok := strings.Cut(s, ":")
+		if !ok || resource == "" {
+			return fmt.Errorf("scope %q is not resource:action", s)
+		}
+		switch action {
+		case "read", "write", "send", "*":
+		default:
+			return fmt.Errorf("scope %q: unknown action %q", s, action)
+		}
+	}
+	return nil
+}
+
+// GenerateAPIKey returns a new plaintext key, its display prefix and its hash.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(b)
+	return key, key[:len(APIKeyPrefix)+6], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the stored form of a key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DBAPIKeyStore keeps API keys in the api_keys table.
+type DBAPIKeyStore struct {
+	db *sql.DB
+}
+
+// NewDBAPIKeyStore creates a key store on api_keys.
+func NewDBAPIKeyStore(db *sql.DB) *DBAPIKeyStore {
+	return &DBAPIKeyStore{db: db}
+}
+
+const apiKeyColumns = `id, organization_id, name, key_prefix, permissions, rate_limit_per_minute,
+	COALESCE(created_by, ''), created_at, last_used_at, expires_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var k APIKey
+	var scopes []byte
+	var lastUsed, expires, revoked sql.NullTime
+	if err := row.Scan(&k.ID, &k.OrganizationID, &k.Name, &k.Prefix, &scopes, &k.RateLimitPerMinute,
+		&k.CreatedBy, &k.CreatedAt, &lastUsed, &expires, &revoked); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(scopes, &k.Scopes); err != nil {
+		return nil, fmt.Errorf("key %s scopes: %w", k.ID, err)
+	}
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+	if expires.Valid {
+		k.ExpiresAt = &expires.Time
+	}
+	if revoked.Valid {
+		k.RevokedAt = &revoked.Time
+	}
+	return &k, nil
+}
+
+// Create stores a new key and returns it with its plaintext, which is not
+// retrievable afterwards.
+func (s *DBAPIKeyStore) Create(ctx context.Context, k APIKey) (*APIKey, string, error) {
+	if strings.TrimSpace(k.Name) == "" {
+		return nil, "", fmt.Errorf("name is required")
+	}
+	if err := ValidateScopes(k.Scopes); err != nil {
+		return nil, "", err
+	}
+	if k.RateLimitPerMinute <= 0 {
+		k.RateLimitPerMinute = DefaultAPIKeyRateLimit
+	}
+	plaintext, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	scopes, _ := json.Marshal(k.Scopes)
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO api_keys (organization_id, name, key_hash, key_prefix, permissions,
+		 rate_limit_per_minute, created_by, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		 RETURNING `+apiKeyColumns,
+		k.OrganizationID, strings.TrimSpace(k.Name), hash, prefix, scopes, k.RateLimitPerMinute, k.CreatedBy, k.ExpiresAt)
+	created, err := scanAPIKey(row)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, plaintext, nil
+}
+
+// List returns an organization's keys, newest first.
+func (s *DBAPIKeyStore) List(ctx context.Context, orgID string) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE organization_id = $1 ORDER BY created_at DESC`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *k)
+	}
+	return out, rows.Err()
+}
+
+// FindByHash returns the key with the given hash, or sql.ErrNoRows.
+func (s *DBAPIKeyStore) FindByHash(ctx context.Context, hash string) (*APIKey, error) {
+	return scanAPIKey(s.db.QueryRowContext(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash))
+}
+
+// Revoke disables a key. It returns sql.ErrNoRows when no active key matched.
+func (s *DBAPIKeyStore) Revoke(ctx context.Context, orgID, id string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = NOW(), status = 'revoked'
+		 WHERE organization_id = $1 AND id = $2 AND revoked_at IS NULL`, orgID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchLastUsed records when and from where a key was last used.
+func (s *DBAPIKeyStore) TouchLastUsed(ctx context.Context, id, ip string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = NOW(), last_used_ip = NULLIF($2, '')::inet WHERE id = $1`, id, ip)
+	return err
+}
+
+// APIKeyStore is the persistence used by APIKeyAuthenticator.
+type APIKeyStore interface {
+	FindByHash(ctx context.Context, hash string) (*APIKey, error)
+	TouchLastUsed(ctx context.Context, id, ip string) error
+}
+
+// APIKeyAuthenticator verifies bearer keys and enforces per-key rate limits.
+// Lookups are cached briefly; Forget drops a key after revocation. Limits
+// use fixed one-minute windows, shared through Redis when configured.
+type APIKeyAuthenticator struct {
+	store APIKeyStore
+	rdb   *redis.Client
+	now   func() time.Time
+
+	mu       sync.Mutex
+	cache    map[string]cachedAPIKey // by hash
+	touched  map[string]time.Time    // last last_used_at write per key
+	windows  map[string]*keyWindow   // in-memory limits when Redis is absent
+	cacheTTL time.Duration
+}
+
+type cachedAPIKey struct {
+	key *APIKey
+	at  time.Time
+}
+
+type keyWindow struct {
+	minute int64
+	count  int
+}
+
+// touchInterval bounds how often last_used_at is written per key.
+const touchInterval = time.Minute
+
+// NewAPIKeyAuthenticator creates an authenticator. rdb may be nil.
+func NewAPIKeyAuthenticator(store APIKeyStore, rdb *redis.Client) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		store:    store,
+		rdb:      rdb,
+		now:      time.Now,
+		cache:    make(map[string]cachedAPIKey),
+		touched:  make(map[string]time.Time),
+		windows:  make(map[string]*keyWindow),
+		cacheTTL: 30 * time.Second,
+	}
+}
+
+// Authenticate resolves a plaintext key and records its use from ip.
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, plaintext, ip string) (*APIKey, error) {
+	if !strings.HasPrefix(plaintext, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	hash := HashAPIKey(plaintext)
+	now := a.now()
+
+	a.mu.Lock()
+	c, ok := a.cache[hash]
+	a.mu.Unlock()
+	key := c.key
+	if !ok || now.Sub(c.at) > a.cacheTTL {
+		k, err := a.store.FindByHash(ctx, hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		if err != nil {
+			return nil, err
+		}
+		key = k
+		a.mu.Lock()
+		a.cache[hash] = cachedAPIKey{key: k, at: now}
+		a.mu.Unlock()
+	}
+	if !key.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	a.mu.Lock()
+	due := now.Sub(a.touched[key.ID]) >= touchInterval
+	if due {
+		a.touched[key.ID] = now
+	}
+	a.mu.Unlock()
+	if due {
+		if err := a.store.TouchLastUsed(ctx, key.ID, ip); err != nil {
+			log.Printf("Auth: failed to record API key use for %s: %v", key.Prefix, err)
+		}
+	}
+	return key, nil
+}
+
+// Forget drops cached lookups of a key so revocation applies immediately.
+func (a *APIKeyAuthenticator) Forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for h, c := range a.cache {
+		if c.key.ID == id {
+			delete(a.cache, h)
+		}
+	}
+}
+
+// Allow counts a request against the key's per-minute limit. It returns the
+// requests left in the window and, when denied, how long until it resets.
+func (a *APIKeyAuthenticator) Allow(ctx context.Context, key *APIKey) (remaining int, retryAfter time.Duration, ok bool) {
+	limit := key.RateLimitPerMinute
+	if limit <= 0 {
+		limit = DefaultAPIKeyRateLimit
+	}
+	now := a.now()
+	minute := now.Unix() / 60
+	reset := time.Unix((minute+1)*60, 0).Sub(now)
+
+	count := 0
+	if a.rdb != nil {
+		rk := fmt.Sprintf("apikey_rl:%s:%d", key.ID, minute)
+		n, err := a.rdb.Incr(ctx, rk).Result()
+		if err == nil {
+			if n == 1 {
+				a.rdb.Expire(ctx, rk, 2*time.Minute)
+			}
+			count = int(n)
+		} else {
+			log.Printf("Auth: API key rate limit via Redis failed, using local window: %v", err)
+		}
+	}
+	if count == 0 {
+		a.mu.Lock()
+		w := a.windows[key.ID]
+		if w == nil || w.minute != minute {
+			w = &keyWindow{minute: minute}
+			a.windows[key.ID] = w
+		}
+		w.count++
+		count = w.count
+		a.mu.Unlock()
+	}
+
+	if count > limit {
+		return 0, reset, false
+	}
+	return limit - count, 0, true
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memAPIKeyStore struct {
+	keys    map[string]*APIKey
+	touches int
+}
+
+func (m *memAPIKeyStore) FindByHash(_ context.Context, hash string) (*APIKey, error) {
+	if k, ok := m.keys[hash]; ok {
+		return k, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memAPIKeyStore) TouchLastUsed(context.Context, string, string) error {
+	m.touches++
+	return nil
+}
+
+func TestAPIKey_Scopes(t *testing.T) {
+	k := APIKey{Scopes: []string{"subscribers:write", "suppressions:read", "engine:*"}}
+	assert.True(t, k.HasScope("subscribers:write"))
+	assert.True(t, k.HasScope("subscribers:read"), "write implies read")
+	assert.True(t, k.HasScope("suppressions:read"))
+	assert.False(t, k.HasScope("suppressions:write"))
+	assert.True(t, k.HasScope("engine:write"))
+	assert.False(t, k.HasScope("campaigns:send"))
+	assert.True(t, APIKey{Scopes: []string{"*"}}.HasScope("campaigns:send"))
+
+	assert.NoError(t, ValidateScopes([]string{"subscribers:write", "*", "campaigns:send"}))
+	assert.Error(t, ValidateScopes(nil))
+	assert.Error(t, ValidateScopes([]string{"subscribers"}))
+	assert.Error(t, ValidateScopes([]string{"subscribers:delete"}))
+
+	key, prefix, hash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+	assert.Len(t, prefix, 10, "fits api_keys.key_prefix")
+	assert.Equal(t, HashAPIKey(key), hash)
+	assert.Len(t, hash, 64)
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 10, 0, time.UTC)
+	past := now.Add(-time.Hour)
+
+	store := &memAPIKeyStore{keys: map[string]*APIKey{
+		HashAPIKey("ipk_good"):    {ID: "k1", Scopes: []string{"*"}, RateLimitPerMinute: 2},
+		HashAPIKey("ipk_revoked"): {ID: "k2", RevokedAt: &past},
+		HashAPIKey("ipk_expired"): {ID: "k3", ExpiresAt: &past},
+	}}
+	a := NewAPIKeyAuthenticator(store, nil)
+	a.now = func() time.Time { return now }
+
+	for _, bad := range []string{"ipk_revoked", "ipk_expired", "ipk_unknown", "not-a-key"} {
+		_, err := a.Authenticate(ctx, bad, "")
+		assert.ErrorIs(t, err, ErrInvalidAPIKey, bad)
+	}
+
+	k, err := a.Authenticate(ctx, "ipk_good", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, "k1", k.ID)
+	_, err = a.Authenticate(ctx, "ipk_good", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.touches, "last_used_at is written at most once a minute")
+
+	remaining, _, ok := a.Allow(ctx, k)
+	assert.True(t, ok)
+	assert.Equal(t, 1, remaining)
+	_, _, ok = a.Allow(ctx, k)
+	assert.True(t, ok)
+	_, retry, ok := a.Allow(ctx, k)
+	assert.False(t, ok)
+	assert.Equal(t, 50*time.Second, retry)
+
+	now = now.Add(time.Minute)
+	_, _, ok = a.Allow(ctx, k)
+	assert.True(t, ok, "a new window starts each minute")
+
+	// Revocation applies once the cached lookup is dropped.
+	_, err = a.Authenticate(ctx, "ipk_good", "")
+	require.NoError(t, err)
+	store.keys[HashAPIKey("ipk_good")] = &APIKey{ID: "k1", Scopes: []string{"*"}, RevokedAt: &past}
+	_, err = a.Authenticate(ctx, "ipk_good", "")
+	require.NoError(t, err, "still cached")
+	a.Forget("k1")
+	_, err = a.Authenticate(ctx, "ipk_good", "")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
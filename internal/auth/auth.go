@@ -41,6 +41,12 @@ type Session struct {
 	Domain    string    `json:"domain"`
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// Role in OrganizationID, re-read from the role store every
+	// roleRefreshInterval so changes apply without a new login.
+	OrganizationID string    `json:"organization_id,omitempty"`
+	Role           Role      `json:"role,omitempty"`
+	RoleCheckedAt  time.Time `json:"role_checked_at,omitempty"`
 }
 
 // AuthManager handles Google OAuth authentication
@@ -51,10 +57,18 @@ type AuthManager struct {
 	sessionMu    sync.RWMutex
 	baseURL      string
 	rdb          *redis.Client
+
+	roles       RoleStore
+	orgID       string
+	adminEmails map[string]bool
+	defaultRole Role
 }
 
 const redisSessionPrefix = "session:"
 
+// roleRefreshInterval bounds how long a session keeps a stale role.
+const roleRefreshInterval = time.Minute
+
 // NewAuthManager creates a new authentication manager
 func NewAuthManager(cfg *config.AuthConfig, baseURL string) *AuthManager {
 	oauth2Config := &oauth2.Config{
@@ -68,14 +82,86 @@ func NewAuthManager(cfg *config.AuthConfig, baseURL string) *AuthManager {
 		Endpoint: google.Endpoint,
 	}
 
+	adminEmails := make(map[string]bool)
+	for _, e := range cfg.AdminEmails {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			adminEmails[e] = true
+		}
+	}
+	defaultRole := RoleViewer
+	if cfg.DefaultRole != "" {
+		if r, err := ParseRole(cfg.DefaultRole); err == nil {
+			defaultRole = r
+		} else {
+			log.Printf("Auth: ignoring default role: %v", err)
+		}
+	}
+
 	return &AuthManager{
 		config:       cfg,
 		oauth2Config: oauth2Config,
 		sessions:     make(map[string]*Session),
 		baseURL:      baseURL,
+		adminEmails:  adminEmails,
+		defaultRole:  defaultRole,
 	}
 }
 
+// SetRoleStore enables stored roles. orgID is the organization sessions are
+// created in.
+func (am *AuthManager) SetRoleStore(store RoleStore, orgID string) {
+	am.roles = store
+	am.orgID = orgID
+}
+
+// RoleStore returns the configured role store, or nil.
+func (am *AuthManager) RoleStore() RoleStore {
+	return am.roles
+}
+
+// OrganizationID returns the organization sessions are created in.
+func (am *AuthManager) OrganizationID() string {
+	return am.orgID
+}
+
+// ResolveRole returns the user's role in orgID. Configured admin emails are
+// always admin; users without a stored role get the default role. A failed
+// lookup is returned as an error, never as a fallback role.
+func (am *AuthManager) ResolveRole(ctx context.Context, orgID, email string) (Role, error) {
+	if am.adminEmails[strings.ToLower(email)] {
+		return RoleAdmin, nil
+	}
+	if am.roles == nil || orgID == "" {
+		return am.defaultRole, nil
+	}
+	role, ok, err := am.roles.GetRole(ctx, orgID, email)
+	if err != nil {
+		return "", fmt.Errorf("role lookup for %s: %w", email, err)
+	}
+	if !ok {
+		return am.defaultRole, nil
+	}
+	return role, nil
+}
+
+// MemberRole returns the user's role in an organization other than the one
+// sessions are created in. Only configured admins and users with a stored
+// role there are members; ok is false for everyone else, so the default
+// role never extends to organizations the user does not belong to.
+func (am *AuthManager) MemberRole(ctx context.Context, orgID, email string) (role Role, ok bool, err error) {
+	if am.adminEmails[strings.ToLower(email)] {
+		return RoleAdmin, true, nil
+	}
+	if am.roles == nil || orgID == "" {
+		return "", false, nil
+	}
+	role, ok, err = am.roles.GetRole(ctx, orgID, email)
+	if err != nil {
+		return "", false, fmt.Errorf("role lookup for %s: %w", email, err)
+	}
+	return role, ok, nil
+}
+
 // SetRedisClient enables Redis-backed sessions so they persist across restarts.
 func (am *AuthManager) SetRedisClient(rdb *redis.Client) {
 	am.rdb = rdb
@@ -187,6 +273,13 @@ func (am *AuthManager) HandleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	role, err := am.ResolveRole(r.Context(), am.orgID, userInfo.Email)
+	if err != nil {
+		log.Printf("Auth: %v", err)
+		http.Redirect(w, r, "/?error=session_failed", http.StatusTemporaryRedirect)
+		return
+	}
+
 	session := &Session{
 		UserID:    userInfo.ID,
 		Email:     userInfo.Email,
@@ -195,11 +288,20 @@ func (am *AuthManager) HandleCallback(w http.ResponseWriter, r *http.Request) {
 		Domain:    userInfo.HD,
 		CreatedAt: time.Now(),
 		ExpiresAt: time.Now().Add(time.Duration(am.config.CookieMaxAge) * time.Second),
+
+		OrganizationID: am.orgID,
+		Role:           role,
+		RoleCheckedAt:  time.Now(),
 	}
 
 	am.storeSession(sessionID, session)
+	if am.roles != nil && am.orgID != "" {
+		if err := am.roles.RecordLogin(r.Context(), am.orgID, userInfo.Email, userInfo.Name, userInfo.ID); err != nil {
+			log.Printf("Auth: failed to record login for %s: %v", userInfo.Email, err)
+		}
+	}
 
-	log.Printf("Auth: User logged in: %s (%s)", userInfo.Email, userInfo.Name)
+	log.Printf("Auth: User logged in: %s (%s) as %s", userInfo.Email, userInfo.Name, session.Role)
 
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
@@ -256,7 +358,9 @@ func (am *AuthManager) HandleUserInfo(w http.ResponseWriter, r *http.Request) {
 			"name":    session.Name,
 			"picture": session.Picture,
 			"domain":  session.Domain,
+			"role":    string(session.Role),
 		},
+		"permissions": session.Role.Permissions(),
 		"organization": map[string]string{
 			"name":   orgName,
 			"domain": session.Domain,
@@ -281,6 +385,21 @@ func (am *AuthManager) GetSession(r *http.Request) *Session {
 		return nil
 	}
 
+	if session.Role == "" || time.Since(session.RoleCheckedAt) > roleRefreshInterval {
+		role, err := am.ResolveRole(r.Context(), session.OrganizationID, session.Email)
+		if err != nil {
+			// Keep the session for the next request, but don't serve this
+			// one on a role that may have been revoked.
+			log.Printf("Auth: %v", err)
+			return nil
+		}
+		refreshed := *session
+		refreshed.Role = role
+		refreshed.RoleCheckedAt = time.Now()
+		am.storeSession(cookie.Value, &refreshed)
+		session = &refreshed
+	}
+
 	return session
 }
 
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Role is a user's access level within an organization.
+type Role string
+
+const (
+	RoleViewer            Role = "viewer"
+	RoleMarketer          Role = "marketer"
+	RoleDeliverabilityOps Role = "deliverability-ops"
+	RoleAdmin             Role = "admin"
+)
+
+// Roles lists every role from least to most privileged.
+var Roles = []Role{RoleViewer, RoleMarketer, RoleDeliverabilityOps, RoleAdmin}
+
+// Permission is an action class guarded by the API.
+type Permission string
+
+const (
+	PermView                 Permission = "view"                  // read dashboards and reports
+	PermEditContent          Permission = "content.edit"          // lists, templates, segments, automations
+	PermSendCampaign         Permission = "campaign.send"         // send, schedule or deploy campaigns
+	PermManageDeliverability Permission = "deliverability.manage" // engine overrides, PMTA, IPs, throttles
+	PermManageSuppressions   Permission = "suppression.manage"    // remove or bulk-change suppressions
+	PermAdmin                Permission = "admin"                 // roles, audit log, system settings
+)
+
+// rolePermissions is the permission matrix. Admins hold every permission.
+var rolePermissions = map[Role]map[Permission]bool{
+	RoleViewer: {
+		PermView: true,
+	},
+	RoleMarketer: {
+		PermView:         true,
+		PermEditContent:  true,
+		PermSendCampaign: true,
+	},
+	RoleDeliverabilityOps: {
+		PermView:                 true,
+		PermManageDeliverability: true,
+		PermManageSuppressions:   true,
+	},
+}
+
+// Can reports whether the role holds p.
+func (r Role) Can(p Permission) bool {
+	if r == RoleAdmin {
+		return true
+	}
+	return rolePermissions[r][p]
+}
+
+// Permissions lists the permissions the role holds.
+func (r Role) Permissions() []Permission {
+	var out []Permission
+	for _, p := range []Permission{PermView, PermEditContent, PermSendCampaign,
+		PermManageDeliverability, PermManageSuppressions, PermAdmin} {
+		if r.Can(p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	for _, v := range Roles {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRole parses a role name. The legacy users.role values map to their
+// closest role: owner to admin, user to marketer.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(strings.ToLower(strings.TrimSpace(s))); r {
+	case "owner":
+		return RoleAdmin, nil
+	case "user":
+		return RoleMarketer, nil
+	default:
+		if r.Valid() {
+			return r, nil
+		}
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+}
+
+// RoleAssignment is a user's stored role in an organization.
+type RoleAssignment struct {
+	OrganizationID string     `json:"organization_id"`
+	Email          string     `json:"email"`
+	Name           string     `json:"name,omitempty"`
+	Role           Role       `json:"role"`
+	LastLoginAt    *time.Time `json:"last_login_at,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// RoleStore persists roles per user and organization.
+type RoleStore interface {
+	// GetRole returns the user's role; ok is false when none is stored.
+	GetRole(ctx context.Context, orgID, email string) (role Role, ok bool, err error)
+	SetRole(ctx context.Context, orgID, email string, role Role) error
+	ListRoles(ctx context.Context, orgID string) ([]RoleAssignment, error)
+	// RecordLogin stamps the last login of a user with a stored role.
+	RecordLogin(ctx context.Context, orgID, email, name, googleID string) error
+}
+
+// DBRoleStore keeps roles on the users table.
+type DBRoleStore struct {
+	db *sql.DB
+}
+
+// NewDBRoleStore creates a role store backed by the users table.
+func NewDBRoleStore(db *sql.DB) *DBRoleStore {
+	return &DBRoleStore{db: db}
+}
+
+func (s *DBRoleStore) GetRole(ctx context.Context, orgID, email string) (Role, bool, error) {
+	var raw string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT role FROM users
+		 WHERE organization_id = $1 AND LOWER(email) = LOWER($2) AND status = 'active'`,
+		orgID, email).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	role, err := ParseRole(raw)
+	if err != nil {
+		return "", false, err
+	}
+	return role, true, nil
+}
+
+func (s *DBRoleStore) SetRole(ctx context.Context, orgID, email string, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (organization_id, email, role)
+		 VALUES ($1, LOWER($2), $3)
+		 ON CONFLICT (organization_id, email) DO UPDATE
+		 SET role = EXCLUDED.role, status = 'active', updated_at = NOW()`,
+		orgID, email, string(role))
+	return err
+}
+
+func (s *DBRoleStore) ListRoles(ctx context.Context, orgID string) ([]RoleAssignment, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT organization_id, email, COALESCE(name, ''), role, last_login_at, updated_at
+		 FROM users WHERE organization_id = $1 AND status = 'active' ORDER BY email`,
+		orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []RoleAssignment{}
+	for rows.Next() {
+		var a RoleAssignment
+		var raw string
+		var lastLogin sql.NullTime
+		if err := rows.Scan(&a.OrganizationID, &a.Email, &a.Name, &raw, &lastLogin, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if a.Role, err = ParseRole(raw); err != nil {
+			a.Role = RoleViewer
+		}
+		if lastLogin.Valid {
+			a.LastLoginAt = &lastLogin.Time
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (s *DBRoleStore) RecordLogin(ctx context.Context, orgID, email, name, googleID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE users SET last_login_at = NOW(), name = COALESCE(NULLIF($3, ''), name),
+		 google_id = COALESCE(NULLIF($4, ''), google_id)
+		 WHERE organization_id = $1 AND LOWER(email) = LOWER($2)`,
+		orgID, email, name, googleID)
+	return err
+}
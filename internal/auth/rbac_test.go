@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memRoleStore struct {
+	roles map[string]Role
+}
+
+func (m *memRoleStore) GetRole(_ context.Context, orgID, email string) (Role, bool, error) {
+	r, ok := m.roles[orgID+"|"+email]
+	return r, ok, nil
+}
+
+func (m *memRoleStore) SetRole(_ context.Context, orgID, email string, role Role) error {
+	m.roles[orgID+"|"+email] = role
+	return nil
+}
+
+func (m *memRoleStore) ListRoles(context.Context, string) ([]RoleAssignment, error) { return nil, nil }
+
+func (m *memRoleStore) RecordLogin(context.Context, string, string, string, string) error { return nil }
+
+func TestRole_PermissionMatrix(t *testing.T) {
+	assert.True(t, RoleViewer.Can(PermView))
+	assert.False(t, RoleViewer.Can(PermEditContent))
+
+	assert.True(t, RoleMarketer.Can(PermSendCampaign))
+	assert.False(t, RoleMarketer.Can(PermManageDeliverability))
+	assert.False(t, RoleMarketer.Can(PermManageSuppressions))
+
+	assert.True(t, RoleDeliverabilityOps.Can(PermManageDeliverability))
+	assert.True(t, RoleDeliverabilityOps.Can(PermManageSuppressions))
+	assert.False(t, RoleDeliverabilityOps.Can(PermSendCampaign))
+	assert.False(t, RoleDeliverabilityOps.Can(PermAdmin))
+
+	assert.True(t, RoleAdmin.Can(PermAdmin))
+	assert.Len(t, RoleAdmin.Permissions(), 6)
+	assert.False(t, Role("").Can(PermView))
+
+	r, err := ParseRole("Owner")
+	require.NoError(t, err)
+	assert.Equal(t, RoleAdmin, r)
+	r, err = ParseRole("user")
+	require.NoError(t, err)
+	assert.Equal(t, RoleMarketer, r)
+	_, err = ParseRole("superuser")
+	assert.Error(t, err)
+}
+
+func TestAuthManager_ResolveRoleAndRefresh(t *testing.T) {
+	am := NewAuthManager(&config.AuthConfig{
+		CookieName:  "sess",
+		AdminEmails: []string{" Boss@example.com "},
+		DefaultRole: "marketer",
+	}, "http://localhost")
+	ctx := context.Background()
+
+	resolve := func(orgID, email string) Role {
+		role, err := am.ResolveRole(ctx, orgID, email)
+		require.NoError(t, err)
+		return role
+	}
+	assert.Equal(t, RoleMarketer, resolve("org-1", "new@example.com"), "no store: default role")
+
+	store := &memRoleStore{roles: map[string]Role{"org-1|ops@example.com": RoleDeliverabilityOps}}
+	am.SetRoleStore(store, "org-1")
+	assert.Equal(t, RoleAdmin, resolve("org-1", "boss@example.com"))
+	assert.Equal(t, RoleDeliverabilityOps, resolve("org-1", "ops@example.com"))
+	assert.Equal(t, RoleMarketer, resolve("org-2", "ops@example.com"))
+
+	// A role change reaches existing sessions once the cached role is stale.
+	am.storeSession("sid", &Session{
+		Email: "ops@example.com", OrganizationID: "org-1", Role: RoleDeliverabilityOps,
+		RoleCheckedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour),
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/dashboard", nil)
+	req.AddCookie(&http.Cookie{Name: "sess", Value: "sid"})
+
+	require.NoError(t, store.SetRole(ctx, "org-1", "ops@example.com", RoleViewer))
+	assert.Equal(t, RoleDeliverabilityOps, am.GetSession(req).Role)
+
+	am.sessions["sid"].RoleCheckedAt = time.Now().Add(-2 * roleRefreshInterval)
+	assert.Equal(t, RoleViewer, am.GetSession(req).Role)
+}
+
+type failingRoleStore struct{ memRoleStore }
+
+func (*failingRoleStore) GetRole(context.Context, string, string) (Role, bool, error) {
+	return "", false, errors.New("connection refused")
+}
+
+func TestAuthManager_RoleLookupFailsClosed(t *testing.T) {
+	am := NewAuthManager(&config.AuthConfig{CookieName: "sess", AdminEmails: []string{"boss@example.com"}}, "http://localhost")
+	am.SetRoleStore(&failingRoleStore{}, "org-1")
+	ctx := context.Background()
+
+	_, err := am.ResolveRole(ctx, "org-1", "ops@example.com")
+	assert.Error(t, err)
+	_, _, err = am.MemberRole(ctx, "org-2", "ops@example.com")
+	assert.Error(t, err)
+
+	// A session whose role can't be refreshed is not served.
+	am.storeSession("sid", &Session{
+		Email: "ops@example.com", OrganizationID: "org-1", Role: RoleAdmin,
+		RoleCheckedAt: time.Now().Add(-2 * roleRefreshInterval), ExpiresAt: time.Now().Add(time.Hour),
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/dashboard", nil)
+	req.AddCookie(&http.Cookie{Name: "sess", Value: "sid"})
+	assert.Nil(t, am.GetSession(req))
+	assert.NotNil(t, am.sessions["sid"], "the session is kept for a later retry")
+
+	role, ok, err := am.MemberRole(ctx, "org-2", "boss@example.com")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, RoleAdmin, role)
+}
+
+func TestAuthManager_MemberRole(t *testing.T) {
+	am := NewAuthManager(&config.AuthConfig{CookieName: "sess", DefaultRole: "marketer"}, "http://localhost")
+	ctx := context.Background()
+
+	_, ok, err := am.MemberRole(ctx, "org-2", "ops@example.com")
+	require.NoError(t, err)
+	assert.False(t, ok, "no store: nobody is a member of another organization")
+
+	am.SetRoleStore(&memRoleStore{roles: map[string]Role{"org-2|ops@example.com": RoleViewer}}, "org-1")
+	role, ok, err := am.MemberRole(ctx, "org-2", "ops@example.com")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, RoleViewer, role)
+
+	_, ok, err = am.MemberRole(ctx, "org-3", "ops@example.com")
+	require.NoError(t, err)
+	assert.False(t, ok, "the default role does not make a member")
+}
@@ -0,0 +1,223 @@
+package automation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
+)
+
+// ErrUnknownCheck is returned for condition checks the engine cannot evaluate.
+var ErrUnknownCheck = errors.New("unknown condition check")
+
+// checkQueries holds the built-in named checks. Each query takes the
+// subscriber ID and returns a single boolean.
+var checkQueries = map[string]string{
+	"email_verified": `SELECT COALESCE(data_quality_score, 0) >= 0.50 FROM mailing_subscribers WHERE id = $1`,
+	"has_opened":     `SELECT EXISTS (SELECT 1 FROM mailing_tracking_events WHERE subscriber_id = $1 AND event_type = 'opened')`,
+	"has_clicked":    `SELECT EXISTS (SELECT 1 FROM mailing_tracking_events WHERE subscriber_id = $1 AND event_type = 'clicked')`,
+}
+
+// waitEvents are the tracking event types wait_until_event and exit_on_goal
+// steps can wait for.
+var waitEvents = map[string]bool{
+	"delivered": true, "opened": true, "clicked": true,
+	"bounced": true, "unsubscribed": true, "complained": true,
+}
+
+// ValidateFlow rejects flows the engine could not run as written: unknown
+// step types or checks, missing step parameters and backwards jumps.
+func ValidateFlow(f *Flow) error {
+	var problems []string
+	for i, step := range f.Steps {
+		for _, p := range validateStep(i, step, len(f.Steps)) {
+			problems = append(problems, fmt.Sprintf("step %d (%s): %s", i, step.Type, p))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid flow: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validateStep(i int, step Step, total int) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if step.DelayHours < 0 {
+		add("delay_hours must not be negative")
+	}
+	jump := func(name string, target *int) {
+		if target != nil && (*target <= i || *target > total) {
+			add("%s must point to a later step", name)
+		}
+	}
+
+	switch step.Type {
+	case StepSendEmail:
+		if step.Template == "" {
+			add("template is required")
+		}
+	case StepWait:
+	case StepCondition:
+		if step.Conditions == nil {
+			if _, ok := checkQueries[step.Check]; !ok {
+				add("%v %q", ErrUnknownCheck, step.Check)
+			}
+		} else {
+			problems = append(problems, validateConditions(*step.Conditions)...)
+		}
+		if step.OnFalse != "" && step.OnFalse != SkipToEnd {
+			add("unknown on_false %q", step.OnFalse)
+		}
+		jump("on_true_step", step.OnTrueStep)
+		jump("on_false_step", step.OnFalseStep)
+	case StepBranch:
+		if step.Conditions == nil {
+			add("conditions are required")
+		} else {
+			problems = append(problems, validateConditions(*step.Conditions)...)
+		}
+		if step.OnFalse != "" && step.OnFalse != SkipToEnd {
+			add("unknown on_false %q", step.OnFalse)
+		}
+		jump("on_true_step", step.OnTrueStep)
+		jump("on_false_step", step.OnFalseStep)
+	case StepWaitUntilEvent:
+		if !waitEvents[step.Event] {
+			add("unknown event %q", step.Event)
+		}
+		if step.TimeoutHours <= 0 {
+			add("timeout_hours is required")
+		}
+		if step.OnTimeout != "" && step.OnTimeout != SkipToEnd {
+			add("unknown on_timeout %q", step.OnTimeout)
+		}
+	case StepUpdateField:
+		if step.Field == "" {
+			add("field is required")
+		}
+		if len(step.Value) == 0 || !json.Valid(step.Value) {
+			add("value must be valid JSON")
+		}
+	case StepTag:
+		if step.Tag == "" {
+			add("tag is required")
+		}
+		if step.TagAction != "" && step.TagAction != "add" && step.TagAction != "remove" {
+			add("unknown tag_action %q", step.TagAction)
+		}
+	case StepWebhook:
+		u, err := url.Parse(step.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("url must be an absolute http(s) URL")
+		}
+		switch strings.ToUpper(step.Method) {
+		case "", "POST", "PUT", "PATCH":
+		default:
+			add("unsupported method %q", step.Method)
+		}
+	case StepExitOnGoal:
+		set := 0
+		if step.Conditions != nil {
+			set++
+			problems = append(problems, validateConditions(*step.Conditions)...)
+		}
+		if step.Event != "" {
+			set++
+			if !waitEvents[step.Event] {
+				add("unknown event %q", step.Event)
+			}
+		}
+		if step.Check != "" {
+			set++
+			if _, ok := checkQueries[step.Check]; !ok {
+				add("%v %q", ErrUnknownCheck, step.Check)
+			}
+		}
+		if set != 1 {
+			add("exactly one of conditions, event or check is required")
+		}
+	default:
+		add("unknown step type")
+	}
+	return problems
+}
+
+// validateConditions runs the segmentation validator and compiles the group
+// so operators the query builder cannot translate are caught at save time.
+func validateConditions(group segmentation.ConditionGroupBuilder) []string {
+	problems := (&segmentation.Engine{}).ValidateConditions(group)
+	if len(problems) == 0 {
+		if _, _, err := segmentation.NewQueryBuilder().BuildCountQuery(group, nil); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// evaluateCondition runs a named check for the subscriber.
+func (fe *FlowEngine) evaluateCondition(ctx context.Context, check string, subscriberID uuid.UUID) (bool, error) {
+	query, ok := checkQueries[check]
+	if !ok {
+		return false, fmt.Errorf("%w %q", ErrUnknownCheck, check)
+	}
+	var passed bool
+	err := fe.db.QueryRowContext(ctx, query, subscriberID).Scan(&passed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return passed, err
+}
+
+// matchesConditions reports whether the subscriber is in the segment described
+// by group, reusing the segmentation query builder with an extra id filter.
+func (fe *FlowEngine) matchesConditions(ctx context.Context, group segmentation.ConditionGroupBuilder, orgID, subscriberID uuid.UUID) (bool, error) {
+	query, args, err := segmentation.NewQueryBuilder().
+		SetOrganizationID(orgID.String()).
+		BuildCountQuery(group, nil)
+	if err != nil {
+		return false, err
+	}
+	args = append(args, subscriberID)
+	query += fmt.Sprintf("\n  AND s.id = $%d", len(args))
+
+	var count int
+	if err := fe.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// eventSince reports whether the subscriber has a tracking event of the given
+// type at or after since.
+func (fe *FlowEngine) eventSince(ctx context.Context, event string, subscriberID uuid.UUID, since time.Time) (bool, error) {
+	var found bool
+	err := fe.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM mailing_tracking_events
+		WHERE subscriber_id = $1 AND event_type = $2 AND event_at >= $3)`,
+		subscriberID, event, since).Scan(&found)
+	return found, err
+}
+
+// goalReached evaluates an exit_on_goal step. Events count from the start of
+// the execution.
+func (fe *FlowEngine) goalReached(ctx context.Context, step Step, exec *Execution, flow *Flow) (bool, error) {
+	switch {
+	case step.Conditions != nil:
+		return fe.matchesConditions(ctx, *step.Conditions, flow.OrganizationID, exec.SubscriberID)
+	case step.Event != "":
+		return fe.eventSince(ctx, step.Event, exec.SubscriberID, exec.CreatedAt)
+	default:
+		return fe.evaluateCondition(ctx, step.Check, exec.SubscriberID)
+	}
+}
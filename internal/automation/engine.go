@@ -1,12 +1,22 @@
 package automation
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 )
 
 // FlowEngine listens for trigger events and executes automation flows.
@@ -19,8 +29,14 @@ type FlowEngine struct {
 	cancel    context.CancelFunc
 	lastRunAt time.Time
 	healthy   bool
+
+	httpClient *http.Client
 }
 
+// eventPollInterval is how often waiting steps re-check for their event and
+// how long failed steps wait before retrying.
+const eventPollInterval = 10 * time.Minute
+
 func NewFlowEngine(db *sql.DB, sender EmailSender) *FlowEngine {
 	return &FlowEngine{
 		db:       db,
@@ -28,6 +44,8 @@ func NewFlowEngine(db *sql.DB, sender EmailSender) *FlowEngine {
 		sender:   sender,
 		interval: 30 * time.Second,
 		healthy:  true,
+
+		httpClient: newWebhookClient(publicAddr),
 	}
 }
 
@@ -81,6 +99,7 @@ func (fe *FlowEngine) Trigger(ctx context.Context, event string, subscriberID uu
 
 		now := time.Now()
 		exec := &Execution{
+			CreatedAt:    now,
 			FlowID:       flow.ID,
 			SubscriberID: subscriberID,
 			Email:        email,
@@ -102,6 +121,20 @@ func (fe *FlowEngine) Trigger(ctx context.Context, event string, subscriberID uu
 	return nil
 }
 
+// SegmentTrigger is the trigger_event a flow uses to start when a subscriber
+// enters or exits a segment, e.g. "segment_entered:<segment id>".
+func SegmentTrigger(evType segmentation.MembershipEventType, segmentID uuid.UUID) string {
+	return fmt.Sprintf("segment_%s:%s", evType, segmentID)
+}
+
+// HandleMembershipEvent is a segmentation.MembershipListener that starts the
+// flows triggered by the segment transition.
+func (fe *FlowEngine) HandleMembershipEvent(ctx context.Context, ev segmentation.MembershipEvent) {
+	if err := fe.Trigger(ctx, SegmentTrigger(ev.Type, ev.SegmentID), ev.SubscriberID, ev.Email); err != nil {
+		log.Printf("[FlowEngine] segment trigger error: %v", err)
+	}
+}
+
 func (fe *FlowEngine) processPending() {
 	fe.lastRunAt = time.Now()
 	fe.healthy = true
@@ -130,9 +163,21 @@ func (fe *FlowEngine) processPending() {
 
 func (fe *FlowEngine) advanceExecution(ctx context.Context, exec *Execution, flow *Flow) {
 	if exec.CurrentStep >= len(flow.Steps) {
-		now := time.Now()
-		exec.Status = "completed"
-		exec.CompletedAt = &now
+		fe.complete(exec)
+		fe.store.UpdateExecution(ctx, exec)
+		return
+	}
+
+	// Goals passed earlier in the flow stay armed for every later step.
+	reached, err := fe.goalsReached(ctx, exec, flow)
+	if err != nil {
+		fe.stepError(exec, err)
+		fe.store.UpdateExecution(ctx, exec)
+		return
+	}
+	if reached {
+		log.Printf("[FlowEngine] goal reached exec=%s step=%d", exec.ID, exec.CurrentStep)
+		fe.complete(exec)
 		fe.store.UpdateExecution(ctx, exec)
 		return
 	}
@@ -140,7 +185,7 @@ func (fe *FlowEngine) advanceExecution(ctx context.Context, exec *Execution, flo
 	step := flow.Steps[exec.CurrentStep]
 
 	switch step.Type {
-	case "send_email":
+	case StepSendEmail:
 		if fe.sender != nil {
 			subject, html := fe.loadTemplate(ctx, step.Template, flow.OrganizationID)
 			if err := fe.sender.SendTransactional(ctx, flow.OrganizationID.String(), exec.Email, subject, html); err != nil {
@@ -150,28 +195,263 @@ func (fe *FlowEngine) advanceExecution(ctx context.Context, exec *Execution, flo
 		exec.CurrentStep++
 		fe.setNextRun(exec, flow)
 
-	case "wait":
+	case StepWait:
 		exec.CurrentStep++
 		if exec.CurrentStep < len(flow.Steps) {
 			next := time.Now().Add(time.Duration(step.DelayHours) * time.Hour)
 			exec.NextRunAt = &next
 		}
 
-	case "condition":
-		passed := fe.evaluateCondition(ctx, step.Check, exec.SubscriberID)
-		if !passed && step.OnFalse == "skip_to_end" {
-			now := time.Now()
-			exec.Status = "completed"
-			exec.CompletedAt = &now
+	case StepCondition, StepBranch:
+		var passed bool
+		if step.Conditions != nil {
+			passed, err = fe.matchesConditions(ctx, *step.Conditions, flow.OrganizationID, exec.SubscriberID)
 		} else {
-			exec.CurrentStep++
-			fe.setNextRun(exec, flow)
+			passed, err = fe.evaluateCondition(ctx, step.Check, exec.SubscriberID)
+		}
+		if err != nil {
+			fe.stepError(exec, err)
+			break
+		}
+		fe.branch(exec, flow, step, passed)
+
+	case StepWaitUntilEvent:
+		fe.waitForEvent(ctx, exec, flow, step)
+
+	case StepUpdateField, StepTag:
+		if err := fe.updateSubscriber(ctx, step, exec.SubscriberID); err != nil {
+			fe.stepError(exec, err)
+			break
+		}
+		exec.CurrentStep++
+		fe.setNextRun(exec, flow)
+
+	case StepWebhook:
+		if err := fe.callWebhook(ctx, step, exec, flow); err != nil {
+			log.Printf("[FlowEngine] webhook error exec=%s step=%d: %v", exec.ID, exec.CurrentStep, err)
+		}
+		exec.CurrentStep++
+		fe.setNextRun(exec, flow)
+
+	case StepExitOnGoal:
+		reached, err := fe.goalReached(ctx, step, exec, flow)
+		if err != nil {
+			fe.stepError(exec, err)
+			break
 		}
+		if reached {
+			fe.complete(exec)
+			break
+		}
+		exec.CurrentStep++
+		fe.setNextRun(exec, flow)
+
+	default:
+		log.Printf("[FlowEngine] unknown step type %q exec=%s step=%d", step.Type, exec.ID, exec.CurrentStep)
+		exec.Status = "failed"
 	}
 
 	fe.store.UpdateExecution(ctx, exec)
 }
 
+// branch moves a condition or branch step to its true/false target.
+func (fe *FlowEngine) branch(exec *Execution, flow *Flow, step Step, passed bool) {
+	target := exec.CurrentStep + 1
+	switch {
+	case passed && step.OnTrueStep != nil:
+		target = *step.OnTrueStep
+	case !passed && step.OnFalse == SkipToEnd:
+		fe.complete(exec)
+		return
+	case !passed && step.OnFalseStep != nil:
+		target = *step.OnFalseStep
+	}
+	exec.CurrentStep = target
+	fe.setNextRun(exec, flow)
+}
+
+// waitForEvent polls for the step's event since the wait began and moves on
+// when it arrives or the timeout passes.
+func (fe *FlowEngine) waitForEvent(ctx context.Context, exec *Execution, flow *Flow, step Step) {
+	now := time.Now()
+	if exec.StepStartedAt == nil {
+		exec.StepStartedAt = &now
+	}
+	deadline := exec.StepStartedAt.Add(time.Duration(step.TimeoutHours) * time.Hour)
+
+	found, err := fe.eventSince(ctx, step.Event, exec.SubscriberID, *exec.StepStartedAt)
+	if err != nil {
+		fe.stepError(exec, err)
+		return
+	}
+
+	switch {
+	case found:
+		exec.StepStartedAt = nil
+		exec.CurrentStep++
+		fe.setNextRun(exec, flow)
+	case !now.Before(deadline):
+		exec.StepStartedAt = nil
+		if step.OnTimeout == SkipToEnd {
+			fe.complete(exec)
+			return
+		}
+		exec.CurrentStep++
+		fe.setNextRun(exec, flow)
+	default:
+		next := now.Add(eventPollInterval)
+		if next.After(deadline) {
+			next = deadline
+		}
+		exec.NextRunAt = &next
+	}
+}
+
+// goalsReached checks the exit_on_goal steps the execution has already passed.
+func (fe *FlowEngine) goalsReached(ctx context.Context, exec *Execution, flow *Flow) (bool, error) {
+	for i := 0; i < exec.CurrentStep && i < len(flow.Steps); i++ {
+		if flow.Steps[i].Type != StepExitOnGoal {
+			continue
+		}
+		reached, err := fe.goalReached(ctx, flow.Steps[i], exec, flow)
+		if err != nil || reached {
+			return reached, err
+		}
+	}
+	return false, nil
+}
+
+func (fe *FlowEngine) updateSubscriber(ctx context.Context, step Step, subscriberID uuid.UUID) error {
+	var err error
+	switch {
+	case step.Type == StepUpdateField:
+		_, err = fe.db.ExecContext(ctx,
+			`UPDATE mailing_subscribers
+			SET custom_fields = COALESCE(custom_fields, '{}'::jsonb) || jsonb_build_object($1::text, $2::jsonb), updated_at = NOW()
+			WHERE id = $3`, step.Field, string(step.Value), subscriberID)
+	case step.TagAction == "remove":
+		_, err = fe.db.ExecContext(ctx,
+			`UPDATE mailing_subscribers SET tags = array_remove(COALESCE(tags, '{}'), $1::text), updated_at = NOW()
+			WHERE id = $2`, step.Tag, subscriberID)
+	default:
+		_, err = fe.db.ExecContext(ctx,
+			`UPDATE mailing_subscribers SET tags = array_append(array_remove(COALESCE(tags, '{}'), $1::text), $1::text), updated_at = NOW()
+			WHERE id = $2`, step.Tag, subscriberID)
+	}
+	return err
+}
+
+// callWebhook posts the execution context to the step's URL. Any non-2xx
+// response is an error.
+func (fe *FlowEngine) callWebhook(ctx context.Context, step Step, exec *Execution, flow *Flow) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"flow_id":         flow.ID,
+		"organization_id": flow.OrganizationID,
+		"execution_id":    exec.ID,
+		"subscriber_id":   exec.SubscriberID,
+		"email":           exec.Email,
+		"step":            exec.CurrentStep,
+	})
+	method := strings.ToUpper(step.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, step.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range step.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := fe.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %d", step.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// errWebhookAddress is returned when a webhook resolves to an address flows
+// may not reach.
+var errWebhookAddress = errors.New("webhook address is not publicly routable")
+
+// carrierNAT is 100.64.0.0/10, shared address space that also hosts some
+// cloud metadata services.
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddr reports whether a webhook may connect to ip. Loopback,
+// private, link-local (169.254.169.254 included), shared, multicast and
+// unspecified addresses are refused.
+func publicAddr(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierNAT.Contains(ip))
+}
+
+// newWebhookClient returns the client flows call webhooks with. Every
+// connection, including those made to follow a redirect, is checked against
+// allowed after the host is resolved, so neither a private URL, a redirect
+// nor a DNS answer can point a webhook into the internal network. Proxies
+// from the environment are not used, as they would be dialed instead of the
+// webhook's host.
+func newWebhookClient(allowed func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+				return fmt.Errorf("%w: %s", errWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost:   2,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("webhook redirected too many times")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("webhook redirected to %s URL", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// stepError fails the execution for flows that can never run (unknown
+// checks) and retries the step later for anything else.
+func (fe *FlowEngine) stepError(exec *Execution, err error) {
+	log.Printf("[FlowEngine] step error exec=%s step=%d: %v", exec.ID, exec.CurrentStep, err)
+	if errors.Is(err, ErrUnknownCheck) {
+		exec.Status = "failed"
+		return
+	}
+	next := time.Now().Add(eventPollInterval)
+	exec.NextRunAt = &next
+}
+
+func (fe *FlowEngine) complete(exec *Execution) {
+	now := time.Now()
+	exec.Status = "completed"
+	exec.CompletedAt = &now
+	exec.StepStartedAt = nil
+}
+
 func (fe *FlowEngine) setNextRun(exec *Execution, flow *Flow) {
 	if exec.CurrentStep >= len(flow.Steps) {
 		now := time.Now()
@@ -202,22 +482,3 @@ func (fe *FlowEngine) loadTemplate(ctx context.Context, templateName string, org
 	}
 	return subject, html
 }
-
-func (fe *FlowEngine) evaluateCondition(ctx context.Context, check string, subscriberID uuid.UUID) bool {
-	switch check {
-	case "email_verified":
-		var score float64
-		fe.db.QueryRowContext(ctx,
-			`SELECT COALESCE(data_quality_score, 0) FROM mailing_subscribers WHERE id = $1`,
-			subscriberID).Scan(&score)
-		return score >= 0.50
-	case "has_opened":
-		var count int
-		fe.db.QueryRowContext(ctx,
-			`SELECT COUNT(*) FROM mailing_tracking_events WHERE subscriber_id = $1 AND event_type = 'opened'`,
-			subscriberID).Scan(&count)
-		return count > 0
-	default:
-		return true
-	}
-}
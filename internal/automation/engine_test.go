@@ -0,0 +1,253 @@
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseSteps(t *testing.T, raw string) []Step {
+	t.Helper()
+	var steps []Step
+	require.NoError(t, json.Unmarshal([]byte(raw), &steps))
+	return steps
+}
+
+func TestValidateFlow(t *testing.T) {
+	// The seeded welcome series stays valid.
+	seed := parseSteps(t, `[
+		{"type": "send_email", "template": "welcome_verify", "delay_hours": 0},
+		{"type": "wait", "delay_hours": 1},
+		{"type": "condition", "check": "email_verified", "on_false": "skip_to_end"},
+		{"type": "send_email", "template": "welcome_intro", "delay_hours": 0}
+	]`)
+	assert.NoError(t, ValidateFlow(&Flow{Steps: seed}))
+
+	rich := parseSteps(t, `[
+		{"type": "exit_on_goal", "event": "clicked"},
+		{"type": "branch", "conditions": {"logic_operator": "AND", "conditions": [
+			{"condition_type": "profile", "field": "engagement_score", "operator": "gte", "value": "50"}
+		]}, "on_false_step": 4},
+		{"type": "tag", "tag": "engaged"},
+		{"type": "wait_until_event", "event": "opened", "timeout_hours": 48, "on_timeout": "skip_to_end"},
+		{"type": "update_field", "field": "nurture_stage", "value": "\"done\""},
+		{"type": "webhook", "url": "https://hooks.example.com/flow"}
+	]`)
+	assert.NoError(t, ValidateFlow(&Flow{Steps: rich}))
+
+	bad := parseSteps(t, `[
+		{"type": "condition", "check": "is_vip"},
+		{"type": "teleport"},
+		{"type": "branch", "conditions": {"conditions": [{"field": "email", "operator": "sounds_like", "value": "x"}]}, "on_true_step": 1},
+		{"type": "wait_until_event", "event": "opened"},
+		{"type": "webhook", "url": "/relative", "method": "DELETE"}
+	]`)
+	err := ValidateFlow(&Flow{Steps: bad})
+	require.Error(t, err)
+	for _, want := range []string{
+		`step 0 (condition): unknown condition check "is_vip"`,
+		"step 1 (teleport): unknown step type",
+		"unknown operator: sounds_like",
+		"on_true_step must point to a later step",
+		"timeout_hours is required",
+		"url must be an absolute http(s) URL",
+		`unsupported method "DELETE"`,
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestStore_CreateFlowRejectsUnknownCheck(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = NewStore(db).CreateFlow(context.Background(), &Flow{
+		Name:  "bad",
+		Steps: []Step{{Type: StepCondition, Check: "has_purchased"}},
+	})
+	assert.ErrorContains(t, err, "unknown condition check")
+	require.NoError(t, mock.ExpectationsWereMet(), "nothing is written")
+}
+
+func newTestEngine(t *testing.T) (*FlowEngine, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewFlowEngine(db, nil), mock
+}
+
+func TestFlowEngine_UnknownCheckFailsExecution(t *testing.T) {
+	fe, mock := newTestEngine(t)
+	flow := &Flow{ID: uuid.New(), Steps: []Step{{Type: StepCondition, Check: "legacy_check"}, {Type: StepWait}}}
+	exec := &Execution{ID: uuid.New(), SubscriberID: uuid.New(), Status: "running"}
+
+	mock.ExpectExec("UPDATE automation_executions").
+		WithArgs(0, "failed", nil, nil, nil, exec.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	fe.advanceExecution(context.Background(), exec, flow)
+	assert.Equal(t, "failed", exec.Status, "unknown checks no longer pass")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFlowEngine_BranchOnSegmentConditions(t *testing.T) {
+	fe, mock := newTestEngine(t)
+	orgID, subID := uuid.New(), uuid.New()
+	flow := &Flow{ID: uuid.New(), OrganizationID: orgID, Steps: parseSteps(t, `[
+		{"type": "branch", "conditions": {"logic_operator": "AND", "conditions": [
+			{"condition_type": "profile", "field": "engagement_score", "operator": "gte", "value": "50"}
+		]}, "on_true_step": 2},
+		{"type": "tag", "tag": "cold"},
+		{"type": "tag", "tag": "engaged"}
+	]`)}
+	exec := &Execution{ID: uuid.New(), SubscriberID: subID, Status: "running"}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM mailing_subscribers s(.|\n)*AND s.id = \$3$`).
+		WithArgs(orgID.String(), sqlmock.AnyArg(), subID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec("UPDATE automation_executions").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	fe.advanceExecution(context.Background(), exec, flow)
+	assert.Equal(t, 2, exec.CurrentStep, "true branch jumps to on_true_step")
+	assert.Equal(t, "running", exec.Status)
+
+	mock.ExpectExec(`UPDATE mailing_subscribers SET tags = array_append`).
+		WithArgs("engaged", subID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE automation_executions").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	fe.advanceExecution(context.Background(), exec, flow)
+	assert.Equal(t, "completed", exec.Status)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFlowEngine_WaitUntilEvent(t *testing.T) {
+	fe, mock := newTestEngine(t)
+	subID := uuid.New()
+	flow := &Flow{ID: uuid.New(), Steps: []Step{
+		{Type: StepWaitUntilEvent, Event: "opened", TimeoutHours: 24, OnTimeout: SkipToEnd},
+		{Type: StepSendEmail, Template: "follow_up"},
+	}}
+	exec := &Execution{ID: uuid.New(), SubscriberID: subID, Status: "running"}
+	ctx := context.Background()
+
+	// No event yet: the step starts waiting and polls again later.
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(subID, "opened", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("UPDATE automation_executions").WillReturnResult(sqlmock.NewResult(0, 1))
+	fe.advanceExecution(ctx, exec, flow)
+	require.NotNil(t, exec.StepStartedAt)
+	require.NotNil(t, exec.NextRunAt)
+	assert.Equal(t, 0, exec.CurrentStep)
+	assert.WithinDuration(t, time.Now().Add(eventPollInterval), *exec.NextRunAt, time.Minute)
+
+	// The timeout passes without an event and the step skips to the end.
+	started := time.Now().Add(-25 * time.Hour)
+	exec.StepStartedAt = &started
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(subID, "opened", started).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("UPDATE automation_executions").WillReturnResult(sqlmock.NewResult(0, 1))
+	fe.advanceExecution(ctx, exec, flow)
+	assert.Equal(t, "completed", exec.Status)
+	assert.Nil(t, exec.StepStartedAt)
+
+	// An event within the window moves on to the next step.
+	exec = &Execution{ID: uuid.New(), SubscriberID: subID, Status: "running"}
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(subID, "opened", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("UPDATE automation_executions").WillReturnResult(sqlmock.NewResult(0, 1))
+	fe.advanceExecution(ctx, exec, flow)
+	assert.Equal(t, 1, exec.CurrentStep)
+	assert.Nil(t, exec.StepStartedAt)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFlowEngine_ExitOnGoalStaysArmed(t *testing.T) {
+	fe, mock := newTestEngine(t)
+	subID := uuid.New()
+	created := time.Now().Add(-time.Hour)
+	flow := &Flow{ID: uuid.New(), Steps: []Step{
+		{Type: StepExitOnGoal, Event: "clicked"},
+		{Type: StepWait, DelayHours: 24},
+		{Type: StepSendEmail, Template: "reminder"},
+	}}
+	exec := &Execution{ID: uuid.New(), SubscriberID: subID, Status: "running", CurrentStep: 2, CreatedAt: created}
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(subID, "clicked", created).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("UPDATE automation_executions").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	fe.advanceExecution(context.Background(), exec, flow)
+	assert.Equal(t, "completed", exec.Status, "the reminder is not sent after the goal")
+	assert.Equal(t, 2, exec.CurrentStep)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFlowEngine_Webhook(t *testing.T) {
+	var got map[string]interface{}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	fe, mock := newTestEngine(t)
+	fe.httpClient = newWebhookClient(func(ip net.IP) bool { return ip.IsLoopback() })
+	flow := &Flow{ID: uuid.New(), Steps: []Step{
+		{Type: StepWebhook, URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer t"}},
+	}}
+	exec := &Execution{ID: uuid.New(), SubscriberID: uuid.New(), Email: "a@example.com", Status: "running"}
+	mock.ExpectExec("UPDATE automation_executions").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	fe.advanceExecution(context.Background(), exec, flow)
+	assert.Equal(t, "Bearer t", auth)
+	assert.Equal(t, "a@example.com", got["email"])
+	assert.Equal(t, exec.ID.String(), got["execution_id"])
+	assert.Equal(t, "completed", exec.Status)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFlowEngine_WebhookRefusesInternalAddresses(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	fe, _ := newTestEngine(t)
+	flow := &Flow{ID: uuid.New()}
+	exec := &Execution{ID: uuid.New(), Status: "running"}
+
+	err := fe.callWebhook(context.Background(), Step{URL: srv.URL}, exec, flow)
+	assert.ErrorIs(t, err, errWebhookAddress)
+	assert.False(t, hit, "loopback is refused before connecting")
+
+	// A public webhook may not redirect into the internal network either.
+	fe.httpClient = newWebhookClient(func(ip net.IP) bool { return ip.IsLoopback() })
+	err = fe.callWebhook(context.Background(), Step{URL: redirect.URL}, exec, flow)
+	assert.ErrorIs(t, err, errWebhookAddress)
+
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "169.254.169.254", "100.100.100.200", "::1", "fe80::1", "0.0.0.0"} {
+		assert.False(t, publicAddr(net.ParseIP(ip)), ip)
+	}
+	assert.True(t, publicAddr(net.ParseIP("93.184.216.34")))
+}
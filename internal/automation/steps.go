@@ -1,18 +1,66 @@
 package automation
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 )
 
+// Step types understood by the FlowEngine.
+const (
+	StepSendEmail      = "send_email"
+	StepWait           = "wait"
+	StepCondition      = "condition"
+	StepBranch         = "branch"
+	StepWaitUntilEvent = "wait_until_event"
+	StepUpdateField    = "update_field"
+	StepTag            = "tag"
+	StepWebhook        = "webhook"
+	StepExitOnGoal     = "exit_on_goal"
+)
+
+// OnFalse/OnTimeout value that ends the execution instead of moving on.
+const SkipToEnd = "skip_to_end"
+
 // Step is a single step in an automation flow.
+//
+// condition and branch steps jump to OnTrueStep/OnFalseStep (forward only)
+// when set and fall through to the next step otherwise. wait_until_event
+// waits for Event up to TimeoutHours, and exit_on_goal completes the
+// execution as soon as its goal (Conditions, Event or Check) is met, both at
+// the step itself and before every later step.
 type Step struct {
 	Type       string `json:"type"`
 	Template   string `json:"template,omitempty"`
 	DelayHours int    `json:"delay_hours"`
 	Check      string `json:"check,omitempty"`
 	OnFalse    string `json:"on_false,omitempty"`
+
+	// branch / exit_on_goal: segmentation conditions evaluated for the
+	// execution's subscriber.
+	Conditions  *segmentation.ConditionGroupBuilder `json:"conditions,omitempty"`
+	OnTrueStep  *int                                `json:"on_true_step,omitempty"`
+	OnFalseStep *int                                `json:"on_false_step,omitempty"`
+
+	// wait_until_event / exit_on_goal: tracking event type such as "opened".
+	Event        string `json:"event,omitempty"`
+	TimeoutHours int    `json:"timeout_hours,omitempty"`
+	OnTimeout    string `json:"on_timeout,omitempty"`
+
+	// update_field: custom field name and JSON value.
+	Field string          `json:"field,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+
+	// tag: tag name and "add" (default) or "remove".
+	Tag       string `json:"tag,omitempty"`
+	TagAction string `json:"tag_action,omitempty"`
+
+	// webhook: request target; the body describes the execution.
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 // Flow is the Go representation of an automation_flows row.
@@ -37,9 +85,11 @@ type Execution struct {
 	CurrentStep  int        `json:"current_step"`
 	Status       string     `json:"status"`
 	NextRunAt    *time.Time `json:"next_run_at"`
-	CompletedAt  *time.Time `json:"completed_at"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	// StepStartedAt is set while a wait_until_event step is waiting.
+	StepStartedAt *time.Time `json:"step_started_at,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
 // EmailSender is called by steps that send email. Implemented by the mailing service.
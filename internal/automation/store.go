@@ -57,7 +57,12 @@ func (s *Store) ListFlowsByTrigger(ctx context.Context, triggerEvent string) ([]
 	return flows, rows.Err()
 }
 
+// CreateFlow saves a new flow. Flows with unknown step types or checks are
+// rejected by ValidateFlow.
 func (s *Store) CreateFlow(ctx context.Context, f *Flow) error {
+	if err := ValidateFlow(f); err != nil {
+		return err
+	}
 	if f.ID == uuid.Nil {
 		f.ID = uuid.New()
 	}
@@ -70,6 +75,9 @@ func (s *Store) CreateFlow(ctx context.Context, f *Flow) error {
 }
 
 func (s *Store) UpdateFlow(ctx context.Context, f *Flow) error {
+	if err := ValidateFlow(f); err != nil {
+		return err
+	}
 	stepsJSON, _ := json.Marshal(f.Steps)
 	_, err := s.db.ExecContext(ctx,
 		`UPDATE automation_flows SET name=$1, description=$2, steps=$3, status=$4, updated_at=NOW()
@@ -92,9 +100,9 @@ func (s *Store) CreateExecution(ctx context.Context, e *Execution) error {
 func (s *Store) GetExecution(ctx context.Context, id uuid.UUID) (*Execution, error) {
 	var e Execution
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, flow_id, subscriber_id, email, current_step, status, next_run_at, completed_at, created_at, updated_at
+		`SELECT id, flow_id, subscriber_id, email, current_step, status, next_run_at, step_started_at, completed_at, created_at, updated_at
 		FROM automation_executions WHERE id = $1`, id,
-	).Scan(&e.ID, &e.FlowID, &e.SubscriberID, &e.Email, &e.CurrentStep, &e.Status, &e.NextRunAt, &e.CompletedAt, &e.CreatedAt, &e.UpdatedAt)
+	).Scan(&e.ID, &e.FlowID, &e.SubscriberID, &e.Email, &e.CurrentStep, &e.Status, &e.NextRunAt, &e.StepStartedAt, &e.CompletedAt, &e.CreatedAt, &e.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -106,15 +114,15 @@ func (s *Store) GetExecution(ctx context.Context, id uuid.UUID) (*Execution, err
 
 func (s *Store) UpdateExecution(ctx context.Context, e *Execution) error {
 	_, err := s.db.ExecContext(ctx,
-		`UPDATE automation_executions SET current_step=$1, status=$2, next_run_at=$3, completed_at=$4, updated_at=NOW()
-		WHERE id = $5`,
-		e.CurrentStep, e.Status, e.NextRunAt, e.CompletedAt, e.ID)
+		`UPDATE automation_executions SET current_step=$1, status=$2, next_run_at=$3, step_started_at=$4, completed_at=$5, updated_at=NOW()
+		WHERE id = $6`,
+		e.CurrentStep, e.Status, e.NextRunAt, e.StepStartedAt, e.CompletedAt, e.ID)
 	return err
 }
 
 func (s *Store) ListPendingExecutions(ctx context.Context, before time.Time, limit int) ([]Execution, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, flow_id, subscriber_id, email, current_step, status, next_run_at, completed_at, created_at, updated_at
+		`SELECT id, flow_id, subscriber_id, email, current_step, status, next_run_at, step_started_at, completed_at, created_at, updated_at
 		FROM automation_executions WHERE status = 'running' AND next_run_at <= $1 LIMIT $2`,
 		before, limit)
 	if err != nil {
@@ -125,7 +133,7 @@ func (s *Store) ListPendingExecutions(ctx context.Context, before time.Time, lim
 	var execs []Execution
 	for rows.Next() {
 		var e Execution
-		if err := rows.Scan(&e.ID, &e.FlowID, &e.SubscriberID, &e.Email, &e.CurrentStep, &e.Status, &e.NextRunAt, &e.CompletedAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		if err := rows.Scan(&e.ID, &e.FlowID, &e.SubscriberID, &e.Email, &e.CurrentStep, &e.Status, &e.NextRunAt, &e.StepStartedAt, &e.CompletedAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
 			continue
 		}
 		execs = append(execs, e)
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -89,13 +90,15 @@ type MailingConfig struct {
 
 // AuthConfig holds Google OAuth authentication configuration
 type AuthConfig struct {
-	Enabled            bool   `yaml:"enabled"`
-	GoogleClientID     string `yaml:"google_client_id"`
-	GoogleClientSecret string `yaml:"google_client_secret"`
-	AllowedDomain      string `yaml:"allowed_domain"`
-	SessionSecret      string `yaml:"session_secret"`
-	CookieName         string `yaml:"cookie_name"`
-	CookieMaxAge       int    `yaml:"cookie_max_age"`
+	Enabled            bool     `yaml:"enabled"`
+	GoogleClientID     string   `yaml:"google_client_id"`
+	GoogleClientSecret string   `yaml:"google_client_secret"`
+	AllowedDomain      string   `yaml:"allowed_domain"`
+	SessionSecret      string   `yaml:"session_secret"`
+	CookieName         string   `yaml:"cookie_name"`
+	CookieMaxAge       int      `yaml:"cookie_max_age"`
+	AdminEmails        []string `yaml:"admin_emails"` // always admin, regardless of stored role
+	DefaultRole        string   `yaml:"default_role"` // role for users without a stored one; viewer if empty
 }
 
 // IPPoolConfig holds IP pool metadata
@@ -528,6 +531,12 @@ func LoadFromEnv(path string) (*Config, error) {
 	if v := os.Getenv("AUTH_ALLOWED_DOMAIN"); v != "" {
 		cfg.Auth.AllowedDomain = v
 	}
+	if v := os.Getenv("AUTH_ADMIN_EMAILS"); v != "" {
+		cfg.Auth.AdminEmails = strings.Split(v, ",")
+	}
+	if v := os.Getenv("AUTH_DEFAULT_ROLE"); v != "" {
+		cfg.Auth.DefaultRole = v
+	}
 	// DataNorm overrides
 	if v := os.Getenv("DATANORM_S3_BUCKET"); v != "" {
 		cfg.DataNorm.S3Bucket = v
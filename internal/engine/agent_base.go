@@ -14,6 +14,29 @@ type BaseAgent struct {
 	mu          sync.Mutex
 	lastEvalAt  time.Time
 	cooldownEnd time.Time
+	clock       func() time.Time
+}
+
+// SetClock replaces the agent's time source; replays drive agents on a
+// virtual clock.
+func (a *BaseAgent) SetClock(now func() time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clock = now
+}
+
+// Now returns the agent's current time.
+func (a *BaseAgent) Now() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.nowLocked()
+}
+
+func (a *BaseAgent) nowLocked() time.Time {
+	if a.clock != nil {
+		return a.clock()
+	}
+	return time.Now()
 }
 
 // SetStatus updates the agent status.
@@ -34,14 +57,14 @@ func (a *BaseAgent) GetStatus() AgentStatus {
 func (a *BaseAgent) IsOnCooldown() bool {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return time.Now().Before(a.cooldownEnd)
+	return a.nowLocked().Before(a.cooldownEnd)
 }
 
 // SetCooldown puts the agent into a cooldown for the given duration.
 func (a *BaseAgent) SetCooldown(d time.Duration) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.cooldownEnd = time.Now().Add(d)
+	a.cooldownEnd = a.nowLocked().Add(d)
 	a.Status = StatusCooldown
 }
 
@@ -49,7 +72,7 @@ func (a *BaseAgent) SetCooldown(d time.Duration) {
 func (a *BaseAgent) MarkEvaluated() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.lastEvalAt = time.Now()
+	a.lastEvalAt = a.nowLocked()
 }
 
 // Agent is the interface all agent types implement.
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 )
 
 // EmergencyAgent detects spike conditions within this ISP's traffic.
@@ -39,7 +38,7 @@ func (a *EmergencyAgent) Evaluate(snap SignalSnapshot) []Decision {
 	a.MarkEvaluated()
 
 	var decisions []Decision
-	now := time.Now()
+	now := a.Now()
 	tc := TemporalContext(now)
 	ctx := context.Background()
 
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"time"
 )
 
 // AgentFactory spawns 6 agents per ISP (48 total) and provides
@@ -15,6 +16,7 @@ type AgentFactory struct {
 	memory      *MemoryStore
 	store       *SuppressionStore
 	convictions *ConvictionStore
+	registry    *ISPRegistry
 	alertCh     chan Decision
 
 	// ISP -> AgentType -> Agent
@@ -22,6 +24,8 @@ type AgentFactory struct {
 
 	// ISP -> SuppressionAgent (needs separate access for record processing)
 	suppressionAgents map[ISP]*SuppressionAgent
+
+	configs map[ISP]ISPConfig
 }
 
 // NewAgentFactory creates the factory and initializes all 48 agents.
@@ -35,30 +39,49 @@ func NewAgentFactory(db *sql.DB, orgID string, memory *MemoryStore, store *Suppr
 		alertCh:           make(chan Decision, 1000),
 		agents:            make(map[ISP]map[AgentType]Agent),
 		suppressionAgents: make(map[ISP]*SuppressionAgent),
+		configs:           make(map[ISP]ISPConfig),
 	}
 	return f
 }
 
+// SetRegistry registers the ISP registry that receives each config's domain
+// and MX patterns during Initialize.
+func (f *AgentFactory) SetRegistry(r *ISPRegistry) {
+	f.registry = r
+}
+
 // Initialize loads ISP configs and creates all agents.
 func (f *AgentFactory) Initialize(ctx context.Context) error {
-	configs, err := f.loadISPConfigs(ctx)
+	configs, err := f.LoadISPConfigs(ctx)
 	if err != nil {
 		log.Printf("[factory] DB load failed (%v), using in-memory defaults", err)
-		configs = f.defaultConfigs()
+		configs = DefaultISPConfigs()
 	}
 
 	if len(configs) == 0 {
 		log.Println("[factory] no ISP configs found, seeding defaults")
 		f.seedDefaultConfigs(ctx)
-		configs, _ = f.loadISPConfigs(ctx)
+		configs, _ = f.LoadISPConfigs(ctx)
 	}
 
 	if len(configs) == 0 {
 		log.Println("[factory] DB seed failed, falling back to in-memory defaults")
-		configs = f.defaultConfigs()
+		configs = DefaultISPConfigs()
+	}
+
+	f.InitializeWithConfigs(configs)
+	return nil
+}
+
+// InitializeWithConfigs creates all agents from configs without touching the
+// database.
+func (f *AgentFactory) InitializeWithConfigs(configs []ISPConfig) {
+	if f.registry != nil {
+		f.registry.ApplyConfigs(configs)
 	}
 
 	for _, cfg := range configs {
+		f.configs[cfg.ISP] = cfg
 		f.agents[cfg.ISP] = make(map[AgentType]Agent)
 
 		id := func(at AgentType) AgentID {
@@ -77,7 +100,15 @@ func (f *AgentFactory) Initialize(ctx context.Context) error {
 	}
 
 	log.Printf("[factory] initialized %d agents across %d ISPs", len(configs)*6, len(configs))
-	return nil
+}
+
+// SetClock gives every agent the same time source.
+func (f *AgentFactory) SetClock(now func() time.Time) {
+	for _, a := range f.GetAllAgents() {
+		if c, ok := a.(interface{ SetClock(func() time.Time) }); ok {
+			c.SetClock(now)
+		}
+	}
 }
 
 // GetAgent returns a specific agent by ISP and type.
@@ -88,6 +119,12 @@ func (f *AgentFactory) GetAgent(isp ISP, at AgentType) Agent {
 	return nil
 }
 
+// Config returns the ISP config the agents were created with.
+func (f *AgentFactory) Config(isp ISP) (ISPConfig, bool) {
+	cfg, ok := f.configs[isp]
+	return cfg, ok
+}
+
 // GetSuppressionAgent returns the suppression agent for an ISP.
 func (f *AgentFactory) GetSuppressionAgent(isp ISP) *SuppressionAgent {
 	return f.suppressionAgents[isp]
@@ -122,7 +159,8 @@ func (f *AgentFactory) AlertChannel() <-chan Decision {
 	return f.alertCh
 }
 
-func (f *AgentFactory) loadISPConfigs(ctx context.Context) ([]ISPConfig, error) {
+// LoadISPConfigs reads the enabled ISP configs for the factory's org.
+func (f *AgentFactory) LoadISPConfigs(ctx context.Context) ([]ISPConfig, error) {
 	rows, err := f.db.QueryContext(ctx,
 		`SELECT id, organization_id, isp, display_name, domain_patterns, mx_patterns,
 		 bounce_warn_pct, bounce_action_pct, complaint_warn_pct, complaint_action_pct,
@@ -156,7 +194,8 @@ func (f *AgentFactory) loadISPConfigs(ctx context.Context) ([]ISPConfig, error)
 	return configs, rows.Err()
 }
 
-func (f *AgentFactory) defaultConfigs() []ISPConfig {
+// DefaultISPConfigs returns the built-in thresholds for the eight ISPs.
+func DefaultISPConfigs() []ISPConfig {
 	type ispDefault struct {
 		isp             ISP
 		displayName     string
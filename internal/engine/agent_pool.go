@@ -3,7 +3,6 @@ package engine
 import (
 	"context"
 	"fmt"
-	"time"
 )
 
 // PoolAgent manages IP assignment to this ISP's pool. Computes per-IP
@@ -37,7 +36,7 @@ func (a *PoolAgent) Evaluate(snap SignalSnapshot) []Decision {
 	a.MarkEvaluated()
 
 	var decisions []Decision
-	now := time.Now()
+	now := a.Now()
 	tc := TemporalContext(now)
 	ctx := context.Background()
 
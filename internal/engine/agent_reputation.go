@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"time"
 )
 
 // ReputationAgent monitors bounce rates and complaint rates per IP,
@@ -39,7 +38,7 @@ func (a *ReputationAgent) Evaluate(snap SignalSnapshot) []Decision {
 	a.MarkEvaluated()
 
 	var decisions []Decision
-	now := time.Now()
+	now := a.Now()
 	tc := TemporalContext(now)
 	ctx := context.Background()
 
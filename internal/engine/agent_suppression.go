@@ -7,6 +7,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
 )
 
 // SuppressionAgent is the "Highway Patrol" — zero-tolerance enforcement.
@@ -27,16 +29,6 @@ type SuppressionAgent struct {
 	recentSuppressions []time.Time
 }
 
-// suppressionTriggerCategories are bounce categories that cause instant suppression.
-// Only permanent/hard failures belong here. Transient issues (quota, throttling,
-// deferrals, policy blocks) should NOT trigger permanent suppression — they are
-// temporary conditions tied to IP reputation or volume, not the recipient.
-var suppressionTriggerCategories = map[string]bool{
-	"bad-mailbox":      true,
-	"bad-domain":       true,
-	"inactive-mailbox": true,
-}
-
 // NewSuppressionAgent creates a new ISP-scoped suppression agent.
 func NewSuppressionAgent(id AgentID, config ISPConfig, store *SuppressionStore, memory *MemoryStore, convictions *ConvictionStore, alertCh chan<- Decision) *SuppressionAgent {
 	return &SuppressionAgent{
@@ -74,9 +66,12 @@ func (a *SuppressionAgent) ProcessRecord(ctx context.Context, rec AccountingReco
 
 	switch rec.Type {
 	case "b": // bounce
-		if suppressionTriggerCategories[rec.BounceCat] {
+		// Only permanent recipient failures trigger suppression. Transient
+		// issues (quota, throttling, policy or reputation blocks) are tied to
+		// our IPs or volume, not the recipient.
+		if c := classifyBounce(rec); c.Action == smtputil.ActionSuppress {
 			shouldSuppress = true
-			reason = rec.BounceCat
+			reason = string(c.Category)
 		}
 
 	case "f": // FBL complaint
@@ -61,7 +61,7 @@ func (a *ThrottleAgent) Evaluate(snap SignalSnapshot) []Decision {
 	a.MarkEvaluated()
 
 	var decisions []Decision
-	now := time.Now()
+	now := a.Now()
 	tc := TemporalContext(now)
 	ctx := context.Background()
 
@@ -3,7 +3,6 @@ package engine
 import (
 	"context"
 	"fmt"
-	"time"
 )
 
 // WarmupAgent manages IP warmup schedules for this ISP. Monitors daily
@@ -37,7 +36,7 @@ func (a *WarmupAgent) Evaluate(snap SignalSnapshot) []Decision {
 	a.MarkEvaluated()
 
 	var decisions []Decision
-	now := time.Now()
+	now := a.Now()
 	tc := TemporalContext(now)
 	ctx := context.Background()
 
@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertSeverity drives routing: each severity maps to its own set of
+// notifiers and grouping window.
+type AlertSeverity string
+
+const (
+	SeverityInfo     AlertSeverity = "info"
+	SeverityWarning  AlertSeverity = "warning"
+	SeverityCritical AlertSeverity = "critical"
+)
+
+// AlertEvent distinguishes the first notification of an incident from
+// follow-ups sent for the same dedup key.
+type AlertEvent string
+
+const (
+	AlertTrigger     AlertEvent = "trigger"     // a new incident
+	AlertUpdate      AlertEvent = "update"      // grouped repeats at window close
+	AlertAcknowledge AlertEvent = "acknowledge" // an operator acknowledged the incident
+)
+
+// Alert is a channel-neutral notification produced by the Alerter.
+type Alert struct {
+	IncidentID string            `json:"incident_id"`
+	DedupKey   string            `json:"dedup_key"`
+	Event      AlertEvent        `json:"event"`
+	Severity   AlertSeverity     `json:"severity"`
+	Kind       string            `json:"kind"` // decision, emergency, velocity
+	ISP        ISP               `json:"isp"`
+	Summary    string            `json:"summary"`
+	Body       string            `json:"body"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	Count      int               `json:"count"` // alerts grouped into this incident so far
+	FirstAt    time.Time         `json:"first_at"`
+	LastAt     time.Time         `json:"last_at"`
+}
+
+// AlertNotifier delivers alerts to one destination (email, webhook, chat,
+// paging). Notifiers are registered on the Alerter by name and selected per
+// severity through AlertRoutes.
+type AlertNotifier interface {
+	Name() string
+	Notify(ctx context.Context, a Alert) error
+}
+
+// --- Email ---
+
+// EmailNotifier sends plain-text alerts over SMTP. Without a host or
+// recipients it only logs what it would have sent.
+type EmailNotifier struct {
+	Host    string
+	Port    int
+	From    string
+	To      []string
+	Timeout time.Duration // whole SMTP conversation (default 30s), shortened by the context's deadline
+}
+
+func (n *EmailNotifier) Name() string { return "email" }
+
+func (n *EmailNotifier) Notify(ctx context.Context, a Alert) error {
+	subject := a.Summary
+	if a.Event == AlertUpdate {
+		subject = fmt.Sprintf("%s (x%d)", a.Summary, a.Count)
+	} else if a.Event == AlertAcknowledge {
+		subject = "ACK: " + a.Summary
+	}
+	if n.Host == "" || len(n.To) == 0 {
+		log.Printf("[alerter] would send: %s", subject)
+		return nil
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		n.From, strings.Join(n.To, ","), subject, a.Body)
+	return n.send(ctx, []byte(msg))
+}
+
+// send delivers msg like smtp.SendMail, but dials with ctx and bounds the
+// whole conversation with a deadline, so a stalled server can't block the
+// alerter. Cancelling ctx aborts the conversation.
+func (n *EmailNotifier) send(ctx context.Context, msg []byte) error {
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(n.Host, strconv.Itoa(n.Port)))
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// --- Signed webhook ---
+
+// Webhook signature headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the shared secret.
+const (
+	WebhookTimestampHeader = "X-Jarvis-Timestamp"
+	WebhookSignatureHeader = "X-Jarvis-Signature"
+)
+
+// WebhookNotifier POSTs the Alert as JSON, signed with a shared secret so
+// receivers can verify origin and reject replays.
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+	now    func() time.Time
+}
+
+func (n *WebhookNotifier) Name() string { return "webhook" }
+
+func (n *WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	now := time.Now
+	if n.now != nil {
+		now = n.now
+	}
+	ts := strconv.FormatInt(now().Unix(), 10)
+	headers := map[string]string{WebhookTimestampHeader: ts}
+	if n.Secret != "" {
+		headers[WebhookSignatureHeader] = "sha256=" + SignWebhook(n.Secret, ts, body)
+	}
+	return postJSON(ctx, n.Client, n.URL, body, headers)
+}
+
+// SignWebhook computes the webhook signature for a timestamp and body.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a signature header value produced by WebhookNotifier
+// and rejects timestamps older than maxAge.
+func VerifyWebhook(secret, timestamp, signature string, body []byte, maxAge time.Duration) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > maxAge || age < -maxAge {
+		return false
+	}
+	expected := "sha256=" + SignWebhook(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// --- Slack ---
+
+// SlackNotifier posts to a Slack-compatible incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Channel    string // optional override; most incoming webhooks ignore it
+	Client     *http.Client
+}
+
+func (n *SlackNotifier) Name() string { return "slack" }
+
+var slackColors = map[AlertSeverity]string{
+	SeverityInfo:     "#439FE0",
+	SeverityWarning:  "warning",
+	SeverityCritical: "danger",
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, a Alert) error {
+	title := a.Summary
+	switch a.Event {
+	case AlertUpdate:
+		title = fmt.Sprintf("%s — %d alerts grouped since %s", a.Summary, a.Count, a.FirstAt.UTC().Format("15:04:05Z"))
+	case AlertAcknowledge:
+		title = ":white_check_mark: Acknowledged: " + a.Summary
+	}
+
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	fields := []field{
+		{Title: "ISP", Value: string(a.ISP), Short: true},
+		{Title: "Severity", Value: string(a.Severity), Short: true},
+	}
+	for _, k := range sortedKeys(a.Fields) {
+		fields = append(fields, field{Title: k, Value: a.Fields[k], Short: len(a.Fields[k]) < 40})
+	}
+
+	payload := map[string]interface{}{
+		"text": title,
+		"attachments": []map[string]interface{}{{
+			"color":    slackColors[a.Severity],
+			"fallback": title,
+			"fields":   fields,
+			"footer":   "PMTA Governance Engine · incident " + a.IncidentID,
+			"ts":       a.LastAt.Unix(),
+		}},
+	}
+	if n.Channel != "" {
+		payload["channel"] = n.Channel
+	}
+	body, _ := json.Marshal(payload)
+	return postJSON(ctx, n.Client, n.WebhookURL, body, nil)
+}
+
+// --- PagerDuty ---
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyNotifier sends Events API v2 payloads. The alert's dedup key is
+// passed through so PagerDuty folds repeats into one incident, and
+// acknowledgements are forwarded as acknowledge events.
+type PagerDutyNotifier struct {
+	RoutingKey string
+	URL        string // defaults to PagerDutyEventsURL
+	Source     string // defaults to "pmta-governance-engine"
+	Client     *http.Client
+}
+
+func (n *PagerDutyNotifier) Name() string { return "pagerduty" }
+
+var pagerDutySeverities = map[AlertSeverity]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityCritical: "critical",
+}
+
+func (n *PagerDutyNotifier) Notify(ctx context.Context, a Alert) error {
+	url := n.URL
+	if url == "" {
+		url = PagerDutyEventsURL
+	}
+	source := n.Source
+	if source == "" {
+		source = "pmta-governance-engine"
+	}
+
+	event := map[string]interface{}{
+		"routing_key": n.RoutingKey,
+		"dedup_key":   a.DedupKey,
+	}
+	if a.Event == AlertAcknowledge {
+		event["event_action"] = "acknowledge"
+	} else {
+		// Updates re-trigger with the same dedup key, which PagerDuty
+		// records as a new alert on the open incident.
+		details := map[string]interface{}{
+			"incident_id": a.IncidentID,
+			"count":       a.Count,
+			"first_at":    a.FirstAt.UTC().Format(time.RFC3339),
+		}
+		for k, v := range a.Fields {
+			details[k] = v
+		}
+		event["event_action"] = "trigger"
+		event["payload"] = map[string]interface{}{
+			"summary":        truncate(a.Summary, 1024),
+			"source":         source,
+			"severity":       pagerDutySeverities[a.Severity],
+			"timestamp":      a.LastAt.UTC().Format(time.RFC3339),
+			"component":      string(a.ISP),
+			"group":          a.Kind,
+			"class":          a.Kind,
+			"custom_details": details,
+		}
+	}
+	body, _ := json.Marshal(event)
+	return postJSON(ctx, n.Client, url, body, nil)
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST %s: HTTP %d: %s", url, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
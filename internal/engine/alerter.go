@@ -1,37 +1,171 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net/smtp"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
-// Alerter sends email notifications for governance decisions.
+// Alerter turns governance events into alerts and fans them out to the
+// notifiers routed for their severity. Alerts sharing a dedup key within the
+// route's grouping window are folded into one incident: the first alert is
+// delivered immediately, repeats are counted, and a single update is sent
+// when the window closes. Each notifier delivers from its own bounded queue,
+// so a slow channel never holds up the caller or the other channels.
 type Alerter struct {
-	smtpHost string
-	smtpPort int
-	from     string
-	to       []string
+	notifiers map[string]*notifierQueue
+	order     []string
+	routes    map[AlertSeverity]AlertRoute
+
+	incidents IncidentStore
+	orgID     string
+	now       func() time.Time
+
+	mu     sync.Mutex
+	groups map[string]*alertGroup
+
+	pending int        // queued deliveries, guarded by mu
+	idle    *sync.Cond // signalled on mu when pending drops to zero
+}
+
+// alertQueueSize bounds each notifier's delivery queue. Alerts arriving
+// while it is full are dropped and logged.
+const alertQueueSize = 100
+
+// notifierQueue feeds one notifier's delivery goroutine.
+type notifierQueue struct {
+	notifier AlertNotifier
+	alerts   chan Alert
+}
+
+// AlertRoute selects the notifiers and grouping window for a severity.
+// An empty Notifiers list means every registered notifier.
+type AlertRoute struct {
+	Severity    AlertSeverity `json:"severity"`
+	Notifiers   []string      `json:"notifiers"`
+	GroupWindow time.Duration `json:"group_window"`
 }
 
-// AlerterConfig holds alerter configuration.
+// AlerterConfig holds alerter configuration. Channels with an empty URL or
+// key are not registered; email is always registered and only logs when
+// SMTPHost is empty.
 type AlerterConfig struct {
 	SMTPHost string
 	SMTPPort int
 	From     string
 	To       []string
+
+	WebhookURL          string
+	WebhookSecret       string
+	SlackWebhookURL     string
+	PagerDutyRoutingKey string
+
+	Routes    []AlertRoute // overrides DefaultAlertRoutes per severity
+	RouteSpec string       // same, in ParseAlertRoutes syntax; applied after Routes
 }
 
-// NewAlerter creates a new email alerter.
+// DefaultAlertRoutes pages only on critical alerts and groups noisier
+// severities over longer windows.
+func DefaultAlertRoutes() []AlertRoute {
+	return []AlertRoute{
+		{Severity: SeverityInfo, Notifiers: []string{"slack", "webhook"}, GroupWindow: 15 * time.Minute},
+		{Severity: SeverityWarning, Notifiers: []string{"email", "slack", "webhook"}, GroupWindow: 10 * time.Minute},
+		{Severity: SeverityCritical, Notifiers: []string{"email", "slack", "webhook", "pagerduty"}, GroupWindow: 5 * time.Minute},
+	}
+}
+
+type alertGroup struct {
+	incident     IncidentReport
+	latest       Alert
+	count        int
+	firstAt      time.Time
+	lastAt       time.Time
+	acknowledged bool
+	timer        *time.Timer
+}
+
+// NewAlerter creates an alerter with the channels enabled in cfg.
 func NewAlerter(cfg AlerterConfig) *Alerter {
-	return &Alerter{
-		smtpHost: cfg.SMTPHost,
-		smtpPort: cfg.SMTPPort,
-		from:     cfg.From,
-		to:       cfg.To,
+	a := &Alerter{
+		notifiers: make(map[string]*notifierQueue),
+		routes:    make(map[AlertSeverity]AlertRoute),
+		now:       time.Now,
+		groups:    make(map[string]*alertGroup),
+	}
+	a.idle = sync.NewCond(&a.mu)
+	a.AddNotifier(&EmailNotifier{Host: cfg.SMTPHost, Port: cfg.SMTPPort, From: cfg.From, To: cfg.To})
+	if cfg.WebhookURL != "" {
+		a.AddNotifier(&WebhookNotifier{URL: cfg.WebhookURL, Secret: cfg.WebhookSecret})
+	}
+	if cfg.SlackWebhookURL != "" {
+		a.AddNotifier(&SlackNotifier{WebhookURL: cfg.SlackWebhookURL})
+	}
+	if cfg.PagerDutyRoutingKey != "" {
+		a.AddNotifier(&PagerDutyNotifier{RoutingKey: cfg.PagerDutyRoutingKey})
+	}
+
+	for _, r := range DefaultAlertRoutes() {
+		a.SetRoute(r)
+	}
+	routes := cfg.Routes
+	if cfg.RouteSpec != "" {
+		parsed, err := ParseAlertRoutes(cfg.RouteSpec)
+		if err != nil {
+			log.Printf("[alerter] ignoring route spec: %v", err)
+		}
+		routes = append(routes, parsed...)
+	}
+	for _, r := range routes {
+		a.SetRoute(r)
 	}
+	return a
+}
+
+// AddNotifier registers (or replaces) a notifier under its name and starts
+// its delivery goroutine. A replaced notifier still delivers what it has
+// queued.
+func (a *Alerter) AddNotifier(n AlertNotifier) {
+	q := &notifierQueue{notifier: n, alerts: make(chan Alert, alertQueueSize)}
+	a.mu.Lock()
+	if old, ok := a.notifiers[n.Name()]; ok {
+		close(old.alerts)
+	} else {
+		a.order = append(a.order, n.Name())
+	}
+	a.notifiers[n.Name()] = q
+	a.mu.Unlock()
+	go a.run(q)
+}
+
+// SetRoute replaces the route for a severity.
+func (a *Alerter) SetRoute(r AlertRoute) {
+	a.mu.Lock()
+	a.routes[r.Severity] = r
+	a.mu.Unlock()
+}
+
+// Routes returns the configured routes.
+func (a *Alerter) Routes() []AlertRoute {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []AlertRoute
+	for _, sev := range []AlertSeverity{SeverityInfo, SeverityWarning, SeverityCritical} {
+		if r, ok := a.routes[sev]; ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// SetIncidentStore enables incident persistence and acknowledgement.
+func (a *Alerter) SetIncidentStore(store IncidentStore, orgID string) {
+	a.incidents = store
+	a.orgID = orgID
 }
 
 // SendDecisionAlert sends an alert for a governance decision.
@@ -61,7 +195,25 @@ This is an automated alert from the PMTA Multi-Agent Traffic Governance Engine.
 		string(d.ActionParams),
 	)
 
-	a.sendEmail(subject, body)
+	a.dispatch(Alert{
+		DedupKey: fmt.Sprintf("decision:%s:%s:%s", d.ISP, d.ActionTaken, d.TargetValue),
+		Severity: SeverityWarning,
+		Kind:     "decision",
+		ISP:      d.ISP,
+		Summary:  subject,
+		Body:     body,
+		Fields: map[string]string{
+			"agent":  string(d.AgentType),
+			"action": d.ActionTaken,
+			"target": d.TargetValue,
+			"result": d.Result,
+		},
+	}, IncidentReport{
+		ISP:          d.ISP,
+		Trigger:      d.ActionTaken,
+		AffectedIPs:  targetIPs(d),
+		ActionsTaken: []string{d.ActionTaken},
+	})
 }
 
 // SendEmergencyAlert sends a high-priority emergency incident report.
@@ -102,7 +254,19 @@ This is an automated emergency alert from the PMTA Governance Engine.
 		strings.Join(incident.DSNSamples, "\n  "),
 	)
 
-	a.sendEmail(subject, body)
+	a.dispatch(Alert{
+		DedupKey: fmt.Sprintf("emergency:%s", incident.ISP),
+		Severity: SeverityCritical,
+		Kind:     "emergency",
+		ISP:      incident.ISP,
+		Summary:  subject,
+		Body:     body,
+		Fields: map[string]string{
+			"trigger":      incident.Trigger,
+			"affected_ips": strings.Join(incident.AffectedIPs, ", "),
+			"resume":       `POST /api/mailing/engine/override {"action": "resume_all"}`,
+		},
+	}, incident)
 }
 
 // SendVelocityAlert sends a suppression velocity anomaly alert.
@@ -122,21 +286,294 @@ Check the suppression dashboard for affected campaigns.
 Automated alert from PMTA Governance Engine.
 `, isp, count5m, threshold)
 
-	a.sendEmail(subject, body)
+	a.dispatch(Alert{
+		DedupKey: fmt.Sprintf("velocity:%s", isp),
+		Severity: SeverityWarning,
+		Kind:     "velocity",
+		ISP:      isp,
+		Summary:  subject,
+		Body:     body,
+		Fields: map[string]string{
+			"count_5m":  fmt.Sprint(count5m),
+			"threshold": fmt.Sprint(threshold),
+		},
+	}, IncidentReport{ISP: isp, Trigger: "suppression_velocity"})
+}
+
+// Acknowledge marks an incident acknowledged, stops its pending grouped
+// update, and notifies the incident's channels (PagerDuty receives an
+// acknowledge event for the same dedup key).
+func (a *Alerter) Acknowledge(ctx context.Context, incidentID, by string) (*IncidentReport, error) {
+	now := a.now()
+
+	var inc *IncidentReport
+	a.mu.Lock()
+	for _, g := range a.groups {
+		if g.incident.ID == incidentID {
+			g.acknowledged = true
+			g.incident.Status = "acknowledged"
+			g.incident.AcknowledgedAt = &now
+			g.incident.AcknowledgedBy = by
+			cp := g.incident
+			inc = &cp
+			break
+		}
+	}
+	a.mu.Unlock()
+
+	if a.incidents != nil {
+		stored, err := a.incidents.AcknowledgeIncident(ctx, a.orgID, incidentID, by, now)
+		if err != nil {
+			return nil, err
+		}
+		if inc == nil {
+			inc = stored
+		}
+	}
+	if inc == nil {
+		return nil, fmt.Errorf("incident %s not found", incidentID)
+	}
+
+	ack := Alert{
+		IncidentID: inc.ID,
+		DedupKey:   inc.AlertKey,
+		Event:      AlertAcknowledge,
+		Severity:   AlertSeverity(inc.Severity),
+		Kind:       inc.Trigger,
+		ISP:        inc.ISP,
+		Summary:    fmt.Sprintf("[%s] %s", strings.ToUpper(string(inc.ISP)), inc.Trigger),
+		Body:       fmt.Sprintf("Incident %s acknowledged by %s at %s.\n", inc.ID, by, now.Format(time.RFC3339)),
+		Fields:     map[string]string{"acknowledged_by": by},
+		Count:      inc.AlertCount,
+		FirstAt:    inc.DetectedAt,
+		LastAt:     now,
+	}
+	a.deliver(a.route(ack.Severity), ack)
+	return inc, nil
+}
+
+// OpenIncidents returns incidents whose grouping window is still open.
+func (a *Alerter) OpenIncidents() []IncidentReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]IncidentReport, 0, len(a.groups))
+	for _, g := range a.groups {
+		out = append(out, g.incident)
+	}
+	return out
+}
+
+// Flush closes every open grouping window now, sending pending updates, and
+// waits until every queued alert has been delivered.
+func (a *Alerter) Flush() {
+	a.mu.Lock()
+	groups := make(map[string]*alertGroup, len(a.groups))
+	for k, g := range a.groups {
+		groups[k] = g
+	}
+	a.mu.Unlock()
+	for k, g := range groups {
+		if g.timer != nil {
+			g.timer.Stop()
+		}
+		a.closeGroup(k, g)
+	}
+	a.wait()
 }
 
-func (a *Alerter) sendEmail(subject, body string) {
-	if a.smtpHost == "" || len(a.to) == 0 {
-		log.Printf("[alerter] would send: %s", subject)
+func (a *Alerter) dispatch(al Alert, inc IncidentReport) {
+	now := a.now()
+	if al.DedupKey == "" {
+		al.DedupKey = al.Kind + ":" + string(al.ISP)
+	}
+	route := a.route(al.Severity)
+
+	a.mu.Lock()
+	if g, ok := a.groups[al.DedupKey]; ok {
+		g.count++
+		g.lastAt = now
+		g.latest = al
+		g.incident.AlertCount = g.count
+		g.incident.LastAlertAt = &now
+		updated := g.incident
+		a.mu.Unlock()
+		a.saveIncident(updated)
 		return
 	}
 
-	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
-		a.from, strings.Join(a.to, ","), subject, body)
+	if inc.ID == "" {
+		inc.ID = uuid.New().String()
+	}
+	if inc.DetectedAt.IsZero() {
+		inc.DetectedAt = now
+	}
+	if inc.StartedAt.IsZero() {
+		inc.StartedAt = inc.DetectedAt
+	}
+	if inc.Status == "" {
+		inc.Status = "active"
+	}
+	inc.AlertKey = al.DedupKey
+	inc.Severity = string(al.Severity)
+	inc.AlertCount = 1
+	inc.LastAlertAt = &now
+
+	if route.GroupWindow > 0 {
+		g := &alertGroup{incident: inc, latest: al, count: 1, firstAt: now, lastAt: now}
+		a.groups[al.DedupKey] = g
+		key := al.DedupKey
+		g.timer = time.AfterFunc(route.GroupWindow, func() { a.closeGroup(key, g) })
+	}
+	a.mu.Unlock()
+
+	al.IncidentID = inc.ID
+	al.Event = AlertTrigger
+	al.Count = 1
+	al.FirstAt = now
+	al.LastAt = now
+
+	a.saveIncident(inc)
+	a.deliver(route, al)
+}
+
+func (a *Alerter) closeGroup(key string, g *alertGroup) {
+	a.mu.Lock()
+	if a.groups[key] != g {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.groups, key)
+	send := g.count > 1 && !g.acknowledged
+	update := g.latest
+	a.mu.Unlock()
+
+	if !send {
+		return
+	}
+	update.IncidentID = g.incident.ID
+	update.DedupKey = key
+	update.Event = AlertUpdate
+	update.Count = g.count
+	update.FirstAt = g.firstAt
+	update.LastAt = g.lastAt
+	a.deliver(a.route(update.Severity), update)
+}
+
+func (a *Alerter) route(sev AlertSeverity) AlertRoute {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if r, ok := a.routes[sev]; ok {
+		return r
+	}
+	return AlertRoute{Severity: sev}
+}
+
+// deliver queues al on the route's notifiers without blocking.
+func (a *Alerter) deliver(route AlertRoute, al Alert) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var targets []*notifierQueue
+	if len(route.Notifiers) == 0 {
+		for _, name := range a.order {
+			targets = append(targets, a.notifiers[name])
+		}
+	} else {
+		for _, name := range route.Notifiers {
+			if q, ok := a.notifiers[name]; ok {
+				targets = append(targets, q)
+			}
+		}
+	}
+
+	for _, q := range targets {
+		select {
+		case q.alerts <- al:
+			a.pending++
+		default:
+			log.Printf("[alerter] %s queue full, dropping alert (subject: %s)", q.notifier.Name(), al.Summary)
+		}
+	}
+}
+
+// run sends a notifier's queued alerts in order until its queue is closed.
+func (a *Alerter) run(q *notifierQueue) {
+	for al := range q.alerts {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		if err := q.notifier.Notify(ctx, al); err != nil {
+			log.Printf("[alerter] %s send error: %v (subject: %s)", q.notifier.Name(), err, al.Summary)
+		}
+		cancel()
+
+		a.mu.Lock()
+		a.pending--
+		if a.pending == 0 {
+			a.idle.Broadcast()
+		}
+		a.mu.Unlock()
+	}
+}
+
+// wait blocks until every queued alert has been delivered.
+func (a *Alerter) wait() {
+	a.mu.Lock()
+	for a.pending > 0 {
+		a.idle.Wait()
+	}
+	a.mu.Unlock()
+}
+
+func (a *Alerter) saveIncident(inc IncidentReport) {
+	if a.incidents == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.incidents.SaveIncident(ctx, a.orgID, inc); err != nil {
+		log.Printf("[alerter] persist incident %s error: %v", inc.ID, err)
+	}
+}
+
+func targetIPs(d Decision) []string {
+	if d.TargetType == "ip" && d.TargetValue != "" {
+		return []string{d.TargetValue}
+	}
+	return nil
+}
 
-	addr := fmt.Sprintf("%s:%d", a.smtpHost, a.smtpPort)
-	err := smtp.SendMail(addr, nil, a.from, a.to, []byte(msg))
-	if err != nil {
-		log.Printf("[alerter] send error: %v (subject: %s)", err, subject)
+// ParseAlertRoutes parses a route spec such as
+// "critical=pagerduty,slack@5m;warning=slack,email@10m;info=slack".
+// The window suffix is optional.
+func ParseAlertRoutes(spec string) ([]AlertRoute, error) {
+	var routes []AlertRoute
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sev, rest, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("alert route %q: missing '='", part)
+		}
+		r := AlertRoute{Severity: AlertSeverity(strings.ToLower(strings.TrimSpace(sev)))}
+		switch r.Severity {
+		case SeverityInfo, SeverityWarning, SeverityCritical:
+		default:
+			return nil, fmt.Errorf("alert route %q: unknown severity %q", part, sev)
+		}
+		if names, window, ok := strings.Cut(rest, "@"); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(window))
+			if err != nil {
+				return nil, fmt.Errorf("alert route %q: %w", part, err)
+			}
+			r.GroupWindow = d
+			rest = names
+		}
+		for _, n := range strings.Split(rest, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				r.Notifiers = append(r.Notifiers, n)
+			}
+		}
+		routes = append(routes, r)
 	}
+	return routes, nil
 }
@@ -0,0 +1,373 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	name   string
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Name() string { return n.name }
+
+func (n *recordingNotifier) Notify(_ context.Context, a Alert) error {
+	n.mu.Lock()
+	n.alerts = append(n.alerts, a)
+	n.mu.Unlock()
+	return nil
+}
+
+func (n *recordingNotifier) events() []AlertEvent {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []AlertEvent
+	for _, a := range n.alerts {
+		out = append(out, a.Event)
+	}
+	return out
+}
+
+type memIncidentStore struct {
+	mu        sync.Mutex
+	incidents map[string]IncidentReport
+}
+
+func (s *memIncidentStore) SaveIncident(_ context.Context, _ string, inc IncidentReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.incidents[inc.ID]; ok && prev.AcknowledgedAt != nil {
+		inc.AcknowledgedAt, inc.AcknowledgedBy, inc.Status = prev.AcknowledgedAt, prev.AcknowledgedBy, prev.Status
+	}
+	s.incidents[inc.ID] = inc
+	return nil
+}
+
+func (s *memIncidentStore) AcknowledgeIncident(_ context.Context, _ string, id, by string, at time.Time) (*IncidentReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inc := s.incidents[id]
+	inc.Status, inc.AcknowledgedBy, inc.AcknowledgedAt = "acknowledged", by, &at
+	s.incidents[id] = inc
+	return &inc, nil
+}
+
+func (s *memIncidentStore) ListIncidents(context.Context, string, string, int) ([]IncidentReport, error) {
+	return nil, nil
+}
+
+func newTestAlerter(routes ...AlertRoute) (*Alerter, *recordingNotifier, *recordingNotifier) {
+	a := NewAlerter(AlerterConfig{})
+	pager := &recordingNotifier{name: "pager"}
+	chat := &recordingNotifier{name: "chat"}
+	a.AddNotifier(pager)
+	a.AddNotifier(chat)
+	for _, r := range routes {
+		a.SetRoute(r)
+	}
+	return a, pager, chat
+}
+
+func TestAlerter_GroupsStormIntoOneIncident(t *testing.T) {
+	a, pager, _ := newTestAlerter(AlertRoute{Severity: SeverityWarning, Notifiers: []string{"pager"}, GroupWindow: time.Hour})
+	store := &memIncidentStore{incidents: map[string]IncidentReport{}}
+	a.SetIncidentStore(store, "org-1")
+
+	for i := 0; i < 25; i++ {
+		a.SendVelocityAlert(ISPGmail, 100+i, 100)
+	}
+	a.SendVelocityAlert(ISPYahoo, 150, 100)
+	a.wait()
+
+	assert.Equal(t, []AlertEvent{AlertTrigger, AlertTrigger}, pager.events(), "one trigger per dedup key")
+	require.Len(t, store.incidents, 2)
+
+	a.Flush()
+	require.Len(t, pager.alerts, 3)
+	update := pager.alerts[2]
+	assert.Equal(t, AlertUpdate, update.Event)
+	assert.Equal(t, 25, update.Count)
+	assert.Equal(t, "velocity:gmail", update.DedupKey)
+	assert.Equal(t, pager.alerts[0].IncidentID, update.IncidentID)
+	assert.Equal(t, 25, store.incidents[update.IncidentID].AlertCount)
+
+	// After the window closes the next alert opens a new incident.
+	a.SendVelocityAlert(ISPGmail, 300, 100)
+	a.wait()
+	require.Len(t, pager.alerts, 4)
+	assert.Equal(t, AlertTrigger, pager.alerts[3].Event)
+	assert.NotEqual(t, update.IncidentID, pager.alerts[3].IncidentID)
+}
+
+func TestAlerter_GroupWindowExpires(t *testing.T) {
+	a, pager, _ := newTestAlerter(AlertRoute{Severity: SeverityWarning, Notifiers: []string{"pager"}, GroupWindow: 20 * time.Millisecond})
+	a.SendVelocityAlert(ISPGmail, 120, 100)
+	a.SendVelocityAlert(ISPGmail, 130, 100)
+
+	assert.Eventually(t, func() bool { return len(pager.events()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []AlertEvent{AlertTrigger, AlertUpdate}, pager.events())
+	assert.Empty(t, a.OpenIncidents())
+}
+
+func TestAlerter_SeverityRouting(t *testing.T) {
+	a, pager, chat := newTestAlerter(
+		AlertRoute{Severity: SeverityCritical, Notifiers: []string{"pager", "chat"}},
+		AlertRoute{Severity: SeverityWarning, Notifiers: []string{"chat"}},
+	)
+
+	a.SendEmergencyAlert(IncidentReport{ISP: ISPMicrosoft, Trigger: "bounce_rate_5m", AffectedIPs: []string{"10.0.0.1"}})
+	a.SendDecisionAlert(Decision{ISP: ISPMicrosoft, AgentType: AgentReputation, ActionTaken: "disable_source_ip", TargetType: "ip", TargetValue: "10.0.0.1"})
+	a.wait()
+
+	require.Len(t, pager.alerts, 1)
+	assert.Equal(t, SeverityCritical, pager.alerts[0].Severity)
+	assert.Equal(t, "emergency:microsoft", pager.alerts[0].DedupKey)
+	require.Len(t, chat.alerts, 2)
+	assert.Equal(t, SeverityWarning, chat.alerts[1].Severity)
+	assert.Equal(t, "disable_source_ip", chat.alerts[1].Fields["action"])
+}
+
+func TestAlerter_AcknowledgeStopsUpdates(t *testing.T) {
+	a, pager, _ := newTestAlerter(AlertRoute{Severity: SeverityCritical, Notifiers: []string{"pager"}, GroupWindow: time.Hour})
+	store := &memIncidentStore{incidents: map[string]IncidentReport{}}
+	a.SetIncidentStore(store, "org-1")
+
+	a.SendEmergencyAlert(IncidentReport{ISP: ISPGmail, Trigger: "deferral_rate_5m"})
+	a.SendEmergencyAlert(IncidentReport{ISP: ISPGmail, Trigger: "deferral_rate_5m"})
+	a.wait()
+	id := pager.alerts[0].IncidentID
+
+	inc, err := a.Acknowledge(context.Background(), id, "oncall@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "acknowledged", inc.Status)
+	assert.Equal(t, "oncall@example.com", inc.AcknowledgedBy)
+	assert.Equal(t, "emergency:gmail", inc.AlertKey)
+
+	a.Flush()
+	assert.Equal(t, []AlertEvent{AlertTrigger, AlertAcknowledge}, pager.events(), "no grouped update after ack")
+	assert.Equal(t, "emergency:gmail", pager.alerts[1].DedupKey)
+	assert.NotNil(t, store.incidents[id].AcknowledgedAt)
+
+	_, err = NewAlerter(AlerterConfig{}).Acknowledge(context.Background(), "missing", "x")
+	assert.Error(t, err)
+}
+
+type blockingNotifier struct {
+	name    string
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Name() string { return n.name }
+
+func (n *blockingNotifier) Notify(ctx context.Context, _ Alert) error {
+	select {
+	case <-n.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func TestAlerter_SlowNotifierDoesNotBlock(t *testing.T) {
+	a, pager, _ := newTestAlerter(AlertRoute{Severity: SeverityWarning, Notifiers: []string{"slow", "pager"}})
+	slow := &blockingNotifier{name: "slow", release: make(chan struct{})}
+	a.AddNotifier(slow)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			a.SendVelocityAlert(ISP(fmt.Sprintf("isp-%d", i)), 120, 100)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked on a slow notifier")
+	}
+
+	assert.Eventually(t, func() bool { return len(pager.events()) == 20 }, time.Second, 5*time.Millisecond)
+	close(slow.release)
+	a.wait()
+}
+
+func TestWebhookNotifier_Signature(t *testing.T) {
+	var gotBody []byte
+	var gotTS, gotSig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotTS = r.Header.Get(WebhookTimestampHeader)
+		gotSig = r.Header.Get(WebhookSignatureHeader)
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{URL: srv.URL, Secret: "s3cret"}
+	require.NoError(t, n.Notify(context.Background(), Alert{DedupKey: "velocity:gmail", Severity: SeverityWarning}))
+
+	assert.True(t, VerifyWebhook("s3cret", gotTS, gotSig, gotBody, time.Minute))
+	assert.False(t, VerifyWebhook("wrong", gotTS, gotSig, gotBody, time.Minute))
+	assert.False(t, VerifyWebhook("s3cret", gotTS, gotSig, append(gotBody, ' '), time.Minute))
+
+	var a Alert
+	require.NoError(t, json.Unmarshal(gotBody, &a))
+	assert.Equal(t, "velocity:gmail", a.DedupKey)
+
+	n.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	require.NoError(t, n.Notify(context.Background(), Alert{}))
+	assert.False(t, VerifyWebhook("s3cret", gotTS, gotSig, gotBody, 5*time.Minute), "stale timestamp is rejected")
+}
+
+func TestPagerDutyNotifier_Payload(t *testing.T) {
+	var events []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&ev)
+		events = append(events, ev)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	n := &PagerDutyNotifier{RoutingKey: "rk", URL: srv.URL}
+	now := time.Now()
+	require.NoError(t, n.Notify(context.Background(), Alert{
+		DedupKey: "emergency:yahoo", Event: AlertTrigger, Severity: SeverityCritical,
+		Kind: "emergency", ISP: ISPYahoo, Summary: "halted", FirstAt: now, LastAt: now,
+	}))
+	require.NoError(t, n.Notify(context.Background(), Alert{DedupKey: "emergency:yahoo", Event: AlertAcknowledge}))
+
+	require.Len(t, events, 2)
+	assert.Equal(t, "trigger", events[0]["event_action"])
+	assert.Equal(t, "rk", events[0]["routing_key"])
+	assert.Equal(t, "emergency:yahoo", events[0]["dedup_key"])
+	payload := events[0]["payload"].(map[string]interface{})
+	assert.Equal(t, "critical", payload["severity"])
+	assert.Equal(t, "yahoo", payload["component"])
+	assert.Equal(t, "acknowledge", events[1]["event_action"])
+	assert.Nil(t, events[1]["payload"])
+}
+
+func TestSlackNotifier_ErrorStatus(t *testing.T) {
+	var payload map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	n := &SlackNotifier{WebhookURL: srv.URL}
+	err := n.Notify(context.Background(), Alert{Summary: "storm", Severity: SeverityWarning, Event: AlertUpdate, Count: 7})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid_payload")
+	assert.Contains(t, payload["text"], "7 alerts grouped")
+}
+
+// smtpListener starts a local SMTP endpoint; serve handles each connection.
+func smtpListener(t *testing.T, serve func(net.Conn)) (string, int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestEmailNotifier_Sends(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	host, port := smtpListener(t, func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 mx ESMTP\r\n")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			mu.Lock()
+			got = append(got, line)
+			mu.Unlock()
+			switch {
+			case inData && line == ".":
+				inData = false
+				fmt.Fprint(conn, "250 queued\r\n")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				fmt.Fprint(conn, "250 mx\r\n")
+			case line == "DATA":
+				inData = true
+				fmt.Fprint(conn, "354 go ahead\r\n")
+			case line == "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	})
+
+	n := &EmailNotifier{Host: host, Port: port, From: "alerts@example.com", To: []string{"ops@example.com"}}
+	require.NoError(t, n.Notify(context.Background(), Alert{Summary: "yahoo deferrals", Body: "rate cut"}))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, got, "RCPT TO:<ops@example.com>")
+	assert.Contains(t, got, "Subject: yahoo deferrals")
+}
+
+func TestEmailNotifier_StalledServerTimesOut(t *testing.T) {
+	// Accepts the connection but never greets.
+	host, port := smtpListener(t, func(conn net.Conn) {
+		time.Sleep(2 * time.Second)
+		conn.Close()
+	})
+
+	n := &EmailNotifier{Host: host, Port: port, From: "alerts@example.com", To: []string{"ops@example.com"}, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	assert.Error(t, n.Notify(context.Background(), Alert{Summary: "stalled"}))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The context's deadline applies too.
+	n.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.Error(t, n.Notify(ctx, Alert{Summary: "stalled"}))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestParseAlertRoutes(t *testing.T) {
+	routes, err := ParseAlertRoutes("critical=pagerduty, slack@5m; warning=slack@15m;info=")
+	require.NoError(t, err)
+	require.Len(t, routes, 3)
+	assert.Equal(t, AlertRoute{Severity: SeverityCritical, Notifiers: []string{"pagerduty", "slack"}, GroupWindow: 5 * time.Minute}, routes[0])
+	assert.Equal(t, 15*time.Minute, routes[1].GroupWindow)
+	assert.Empty(t, routes[2].Notifiers)
+
+	_, err = ParseAlertRoutes("urgent=slack")
+	assert.Error(t, err)
+	_, err = ParseAlertRoutes("critical=slack@soon")
+	assert.Error(t, err)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
 )
 
 // CampaignEventTracker aggregates all delivery and engagement events per
@@ -550,15 +551,13 @@ func (t *CampaignEventTracker) FlushAll(ctx context.Context) {
 
 // ClassifyBounce categorizes a PMTA bounce category into soft or hard bounce.
 func ClassifyBounce(bounceCat string) string {
-	switch bounceCat {
-	case "bad-mailbox", "bad-domain", "no-answer-from-host",
-		"inactive-mailbox", "policy-related", "routing-errors",
-		"bad-connection":
+	return bounceEventType(smtputil.ClassifyDSN("", "", bounceCat))
+}
+
+// bounceEventType maps a classification to the campaign event type.
+func bounceEventType(c smtputil.Classification) string {
+	if c.Type == smtputil.BounceHard {
 		return "hard_bounce"
-	case "quota-issues", "message-expired", "rate-limited",
-		"content-related", "other":
-		return "soft_bounce"
-	default:
-		return "soft_bounce"
 	}
+	return "soft_bounce"
 }
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"time"
 )
 
@@ -21,13 +22,18 @@ func (ds *DBDecisionStore) PersistDecision(ctx context.Context, d Decision) erro
 	if d.ActionParams == nil {
 		d.ActionParams = json.RawMessage("{}")
 	}
+	var cond []byte
+	if d.RevertCondition != nil {
+		cond, _ = json.Marshal(d.RevertCondition)
+	}
 	_, err := ds.DB.ExecContext(ctx,
 		`INSERT INTO mailing_engine_decisions
-		(organization_id, isp, agent_type, signal_values, action_taken, action_params,
-		 target_type, target_value, result)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
-		d.OrganizationID, d.ISP, d.AgentType, d.SignalValues,
+		(id, organization_id, isp, agent_type, signal_values, action_taken, action_params,
+		 target_type, target_value, result, expires_at, revert_condition, rule_id)
+		VALUES (COALESCE(NULLIF($1,'')::uuid, gen_random_uuid()),$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)`,
+		d.ID, d.OrganizationID, d.ISP, d.AgentType, d.SignalValues,
 		d.ActionTaken, d.ActionParams, d.TargetType, d.TargetValue, d.Result,
+		d.ExpiresAt, cond, d.RuleID,
 	)
 	return err
 }
@@ -49,7 +55,7 @@ func (ds *DBDecisionStore) GetAgentStates(ctx context.Context, orgID string) ([]
 	rows, err := ds.DB.QueryContext(ctx,
 		`SELECT id, organization_id, isp, agent_type, status, last_eval_at,
 		 decisions_count, current_actions, COALESCE(error_message,''),
-		 COALESCE(s3_state_key,''), created_at, updated_at
+		 COALESCE(s3_state_key,''), COALESCE(shadow,false), created_at, updated_at
 		 FROM mailing_engine_agent_state WHERE organization_id = $1`,
 		orgID)
 	if err != nil {
@@ -62,7 +68,7 @@ func (ds *DBDecisionStore) GetAgentStates(ctx context.Context, orgID string) ([]
 		var s AgentState
 		if err := rows.Scan(&s.ID, &s.OrganizationID, &s.ISP, &s.AgentType,
 			&s.Status, &s.LastEvalAt, &s.DecisionsCount, &s.CurrentActions,
-			&s.ErrorMessage, &s.S3StateKey, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			&s.ErrorMessage, &s.S3StateKey, &s.Shadow, &s.CreatedAt, &s.UpdatedAt); err != nil {
 			continue
 		}
 		states = append(states, s)
@@ -74,7 +80,7 @@ func (ds *DBDecisionStore) GetISPAgentStates(ctx context.Context, orgID string,
 	rows, err := ds.DB.QueryContext(ctx,
 		`SELECT id, organization_id, isp, agent_type, status, last_eval_at,
 		 decisions_count, current_actions, COALESCE(error_message,''),
-		 COALESCE(s3_state_key,''), created_at, updated_at
+		 COALESCE(s3_state_key,''), COALESCE(shadow,false), created_at, updated_at
 		 FROM mailing_engine_agent_state WHERE organization_id = $1 AND isp = $2`,
 		orgID, isp)
 	if err != nil {
@@ -87,7 +93,7 @@ func (ds *DBDecisionStore) GetISPAgentStates(ctx context.Context, orgID string,
 		var s AgentState
 		if err := rows.Scan(&s.ID, &s.OrganizationID, &s.ISP, &s.AgentType,
 			&s.Status, &s.LastEvalAt, &s.DecisionsCount, &s.CurrentActions,
-			&s.ErrorMessage, &s.S3StateKey, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			&s.ErrorMessage, &s.S3StateKey, &s.Shadow, &s.CreatedAt, &s.UpdatedAt); err != nil {
 			continue
 		}
 		states = append(states, s)
@@ -95,6 +101,17 @@ func (ds *DBDecisionStore) GetISPAgentStates(ctx context.Context, orgID string,
 	return states, rows.Err()
 }
 
+func (ds *DBDecisionStore) UpdateAgentShadow(ctx context.Context, orgID string, isp ISP, agentType AgentType, shadow bool) error {
+	_, err := ds.DB.ExecContext(ctx,
+		`INSERT INTO mailing_engine_agent_state
+		(organization_id, isp, agent_type, status, current_actions, shadow)
+		VALUES ($1,$2,$3,'active','[]',$4)
+		ON CONFLICT (organization_id, isp, agent_type) DO UPDATE SET
+		shadow = $4, updated_at = NOW()`,
+		orgID, isp, agentType, shadow)
+	return err
+}
+
 func (ds *DBDecisionStore) UpdateAgentStatus(ctx context.Context, orgID string, isp ISP, agentType AgentType, status AgentStatus) error {
 	_, err := ds.DB.ExecContext(ctx,
 		`UPDATE mailing_engine_agent_state SET status = $4, updated_at = NOW()
@@ -103,26 +120,69 @@ func (ds *DBDecisionStore) UpdateAgentStatus(ctx context.Context, orgID string,
 	return err
 }
 
+const decisionColumns = `id, organization_id, isp, agent_type, signal_values,
+	action_taken, action_params, COALESCE(target_type,''), COALESCE(target_value,''),
+	result, reverted_at, COALESCE(revert_reason,''), created_at, expires_at, revert_condition, rule_id`
+
 func (ds *DBDecisionStore) QueryDecisions(ctx context.Context, orgID string, isp *ISP, agentType *AgentType, since *time.Time, limit int) ([]Decision, error) {
 	var query string
 	var args []interface{}
 
 	if isp != nil {
-		query = `SELECT id, organization_id, isp, agent_type, signal_values,
-			action_taken, action_params, COALESCE(target_type,''), COALESCE(target_value,''),
-			result, reverted_at, COALESCE(revert_reason,''), created_at
+		query = `SELECT ` + decisionColumns + `
 			FROM mailing_engine_decisions WHERE organization_id = $1 AND isp = $2
 			ORDER BY created_at DESC LIMIT $3`
 		args = []interface{}{orgID, *isp, limit}
 	} else {
-		query = `SELECT id, organization_id, isp, agent_type, signal_values,
-			action_taken, action_params, COALESCE(target_type,''), COALESCE(target_value,''),
-			result, reverted_at, COALESCE(revert_reason,''), created_at
+		query = `SELECT ` + decisionColumns + `
 			FROM mailing_engine_decisions WHERE organization_id = $1
 			ORDER BY created_at DESC LIMIT $2`
 		args = []interface{}{orgID, limit}
 	}
 
+	return ds.queryDecisions(ctx, query, args...)
+}
+
+func (ds *DBDecisionStore) QueryRevertible(ctx context.Context, orgID string) ([]Decision, error) {
+	return ds.queryDecisions(ctx,
+		`SELECT `+decisionColumns+`
+		 FROM mailing_engine_decisions
+		 WHERE organization_id = $1 AND reverted_at IS NULL AND result <> 'reverted'
+		   AND (expires_at IS NOT NULL OR revert_condition IS NOT NULL)
+		 ORDER BY created_at`,
+		orgID)
+}
+
+// QueryDecisionRange returns live and shadow decisions created in [from, to),
+// oldest first, optionally for one ISP.
+func (ds *DBDecisionStore) QueryDecisionRange(ctx context.Context, orgID string, isp *ISP, from, to time.Time, limit int) ([]Decision, error) {
+	query := `SELECT ` + decisionColumns + `
+		FROM mailing_engine_decisions
+		WHERE organization_id = $1 AND created_at >= $2 AND created_at < $3`
+	args := []interface{}{orgID, from, to}
+	if isp != nil {
+		query += ` AND isp = $4`
+		args = append(args, *isp)
+	}
+	query += fmt.Sprintf(` ORDER BY created_at LIMIT %d`, limit)
+	return ds.queryDecisions(ctx, query, args...)
+}
+
+func (ds *DBDecisionStore) MarkReverted(ctx context.Context, orgID, id, reason string, at time.Time) error {
+	res, err := ds.DB.ExecContext(ctx,
+		`UPDATE mailing_engine_decisions SET result = 'reverted', reverted_at = $3, revert_reason = $4
+		 WHERE organization_id = $1 AND id = $2 AND reverted_at IS NULL`,
+		orgID, id, at, reason)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("decision %s not found or already reverted", id)
+	}
+	return nil
+}
+
+func (ds *DBDecisionStore) queryDecisions(ctx context.Context, query string, args ...interface{}) ([]Decision, error) {
 	rows, err := ds.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
@@ -132,12 +192,20 @@ func (ds *DBDecisionStore) QueryDecisions(ctx context.Context, orgID string, isp
 	var decisions []Decision
 	for rows.Next() {
 		var d Decision
+		var cond []byte
 		if err := rows.Scan(&d.ID, &d.OrganizationID, &d.ISP, &d.AgentType,
 			&d.SignalValues, &d.ActionTaken, &d.ActionParams,
 			&d.TargetType, &d.TargetValue, &d.Result,
-			&d.RevertedAt, &d.RevertReason, &d.CreatedAt); err != nil {
+			&d.RevertedAt, &d.RevertReason, &d.CreatedAt,
+			&d.ExpiresAt, &cond, &d.RuleID); err != nil {
 			continue
 		}
+		if len(cond) > 0 {
+			var c RevertCondition
+			if json.Unmarshal(cond, &c) == nil {
+				d.RevertCondition = &c
+			}
+		}
 		decisions = append(decisions, d)
 	}
 	return decisions, rows.Err()
@@ -194,6 +262,30 @@ func (ss *DBSignalStore) PersistSignals(ctx context.Context, orgID string, snap
 	return nil
 }
 
+// DBRateAdjustmentStore implements RateAdjustmentStore using *sql.DB,
+// writing to the throttle adjustment log shared with the worker throttles.
+type DBRateAdjustmentStore struct {
+	DB *sql.DB
+}
+
+func (s *DBRateAdjustmentStore) LogRateAdjustment(ctx context.Context, orgID string, adj RateAdjustment) error {
+	targetType, targetName := "isp", string(adj.ISP)
+	if adj.IP != "" {
+		targetType, targetName = "ip", adj.IP
+	}
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO mailing_throttle_adjustment_log
+		(org_id, target_type, target_name, isp, adjustment_type,
+		 old_hourly_limit, new_hourly_limit, old_msgs_per_minute, new_msgs_per_minute, ceiling_msgs_per_minute,
+		 reason, triggered_by, attempts, deferral_rate, refusal_rate, accept_latency_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'auto', $12, $13, $14, $15, $16)`,
+		orgID, targetType, targetName, adj.ISP, adj.Direction,
+		int(math.Round(adj.OldRate*60)), int(math.Round(adj.NewRate*60)), adj.OldRate, adj.NewRate, adj.Ceiling,
+		adj.Reason, adj.Inputs.Attempts, adj.Inputs.DeferralRate, adj.Inputs.RefusalRate, adj.Inputs.AcceptLatencyMs, adj.At,
+	)
+	return err
+}
+
 // DBSuppressionRepo implements SuppressionRepository using *sql.DB.
 type DBSuppressionRepo struct {
 	DB *sql.DB
@@ -383,10 +475,127 @@ func (r *DBSuppressionRepo) ListEmails(ctx context.Context, orgID string, isp IS
 	return emails, rows.Err()
 }
 
+// DBIncidentStore implements IncidentStore using *sql.DB.
+type DBIncidentStore struct {
+	DB *sql.DB
+}
+
+const incidentColumns = `id, isp, trigger, COALESCE(severity,''), COALESCE(alert_key,''), status,
+	trigger_metrics, affected_ips, affected_domains, dsn_samples, actions_taken,
+	alert_count, started_at, detected_at, last_alert_at, resolved_at,
+	acknowledged_at, COALESCE(acknowledged_by,'')`
+
+func (s *DBIncidentStore) SaveIncident(ctx context.Context, orgID string, inc IncidentReport) error {
+	metrics := inc.TriggerMetrics
+	if metrics == nil {
+		metrics = json.RawMessage("{}")
+	}
+	ips, _ := json.Marshal(nonNil(inc.AffectedIPs))
+	domains, _ := json.Marshal(nonNil(inc.AffectedDomains))
+	samples, _ := json.Marshal(nonNil(inc.DSNSamples))
+	actions, _ := json.Marshal(nonNil(inc.ActionsTaken))
+
+	// Acknowledgement columns are owned by AcknowledgeIncident and are not
+	// overwritten here.
+	_, err := s.DB.ExecContext(ctx,
+		`INSERT INTO mailing_engine_incidents
+		(id, organization_id, isp, trigger, severity, alert_key, status,
+		 trigger_metrics, affected_ips, affected_domains, dsn_samples, actions_taken,
+		 alert_count, started_at, detected_at, last_alert_at, resolved_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)
+		ON CONFLICT (id) DO UPDATE SET
+		 alert_count = EXCLUDED.alert_count,
+		 last_alert_at = EXCLUDED.last_alert_at,
+		 resolved_at = EXCLUDED.resolved_at,
+		 status = CASE WHEN mailing_engine_incidents.status = 'acknowledged' AND EXCLUDED.status = 'active'
+		          THEN mailing_engine_incidents.status ELSE EXCLUDED.status END,
+		 updated_at = NOW()`,
+		inc.ID, orgID, inc.ISP, inc.Trigger, inc.Severity, inc.AlertKey, inc.Status,
+		metrics, ips, domains, samples, actions,
+		inc.AlertCount, inc.StartedAt, inc.DetectedAt, inc.LastAlertAt, inc.ResolvedAt,
+	)
+	return err
+}
+
+func (s *DBIncidentStore) AcknowledgeIncident(ctx context.Context, orgID, id, by string, at time.Time) (*IncidentReport, error) {
+	row := s.DB.QueryRowContext(ctx,
+		`UPDATE mailing_engine_incidents
+		 SET status = 'acknowledged', acknowledged_at = $3, acknowledged_by = $4, updated_at = NOW()
+		 WHERE organization_id = $1 AND id = $2
+		 RETURNING `+incidentColumns,
+		orgID, id, at, by)
+	inc, err := scanIncident(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("incident %s not found", id)
+	}
+	return inc, err
+}
+
+func (s *DBIncidentStore) ListIncidents(ctx context.Context, orgID string, status string, limit int) ([]IncidentReport, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+incidentColumns+`
+		 FROM mailing_engine_incidents
+		 WHERE organization_id = $1 AND ($2 = '' OR status = $2)
+		 ORDER BY detected_at DESC LIMIT $3`,
+		orgID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []IncidentReport
+	for rows.Next() {
+		inc, err := scanIncident(rows)
+		if err != nil {
+			log.Printf("[incidents] scan error: %v", err)
+			continue
+		}
+		out = append(out, *inc)
+	}
+	return out, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanIncident(row rowScanner) (*IncidentReport, error) {
+	var inc IncidentReport
+	var ips, domains, samples, actions []byte
+	err := row.Scan(&inc.ID, &inc.ISP, &inc.Trigger, &inc.Severity, &inc.AlertKey, &inc.Status,
+		&inc.TriggerMetrics, &ips, &domains, &samples, &actions,
+		&inc.AlertCount, &inc.StartedAt, &inc.DetectedAt, &inc.LastAlertAt, &inc.ResolvedAt,
+		&inc.AcknowledgedAt, &inc.AcknowledgedBy)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal(ips, &inc.AffectedIPs)
+	json.Unmarshal(domains, &inc.AffectedDomains)
+	json.Unmarshal(samples, &inc.DSNSamples)
+	json.Unmarshal(actions, &inc.ActionsTaken)
+	return &inc, nil
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
 // Compile-time interface satisfaction checks
 var (
 	_ DecisionStore         = (*DBDecisionStore)(nil)
 	_ SignalStore           = (*DBSignalStore)(nil)
 	_ SuppressionRepository = (*DBSuppressionRepo)(nil)
+	_ IncidentStore         = (*DBIncidentStore)(nil)
+	_ AlertSender           = (*Alerter)(nil)
+	_ ActionExecutor        = (*Executor)(nil)
+	_ ActionExecutor        = (*NoopExecutor)(nil)
+	_ ActionExecutor        = (*Fleet)(nil)
+	_ PMTABackend           = (*SSHBackend)(nil)
+	_ PMTABackend           = (*HTTPBackend)(nil)
+	_ PMTABackend           = (*FakePMTA)(nil)
+	_ DecisionStore         = (*MemDecisionStore)(nil)
 )
 
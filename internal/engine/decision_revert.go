@@ -0,0 +1,511 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RevertCondition is a recovery check evaluated against SignalProcessor
+// snapshots. The decision is reverted once Metric compares true against
+// Threshold on every snapshot for SustainSeconds.
+//
+// ISP-wide metrics: bounce_rate_1m, bounce_rate_5m, bounce_rate_1h,
+// complaint_rate_1h, deferral_rate_5m, deferral_rate_1h.
+// Metrics scoped to an IP-targeted decision: ip_bounce_rate_1h,
+// ip_deferral_rate_5m, ip_complaint_rate_24h.
+//
+// A snapshot without traffic for the metric's window cannot confirm recovery
+// and restarts the sustain timer.
+type RevertCondition struct {
+	Metric         string  `json:"metric"`
+	Operator       string  `json:"operator"` // <, <=, >, >=
+	Threshold      float64 `json:"threshold"`
+	SustainSeconds int     `json:"sustain_seconds"`
+}
+
+// Validate rejects unknown metrics and operators.
+func (c RevertCondition) Validate() error {
+	if _, ok := revertMetrics[c.Metric]; !ok {
+		return fmt.Errorf("unknown revert metric %q", c.Metric)
+	}
+	switch c.Operator {
+	case "<", "<=", ">", ">=":
+	default:
+		return fmt.Errorf("unknown revert operator %q", c.Operator)
+	}
+	if c.SustainSeconds < 0 {
+		return fmt.Errorf("sustain_seconds must not be negative")
+	}
+	return nil
+}
+
+func (c RevertCondition) String() string {
+	return fmt.Sprintf("%s %s %.2f for %s", c.Metric, c.Operator, c.Threshold, c.sustain())
+}
+
+func (c RevertCondition) sustain() time.Duration {
+	return time.Duration(c.SustainSeconds) * time.Second
+}
+
+func (c RevertCondition) holds(v float64) bool {
+	return compareThreshold(v, c.Operator, c.Threshold)
+}
+
+// compareThreshold applies a rule or condition operator.
+func compareThreshold(v float64, op string, threshold float64) bool {
+	switch op {
+	case "<":
+		return v < threshold
+	case "<=":
+		return v <= threshold
+	case ">":
+		return v > threshold
+	case ">=":
+		return v >= threshold
+	case "==":
+		return v == threshold
+	case "!=":
+		return v != threshold
+	}
+	return false
+}
+
+// revertMetrics reads a metric from a snapshot for a decision. The bool is
+// false when the window saw no traffic.
+var revertMetrics = map[string]func(snap SignalSnapshot, d Decision) (float64, bool){
+	"bounce_rate_1m": func(s SignalSnapshot, _ Decision) (float64, bool) { return s.BounceRate1m, s.Sent5m > 0 },
+	"bounce_rate_5m": func(s SignalSnapshot, _ Decision) (float64, bool) { return s.BounceRate5m, s.Sent5m > 0 },
+	"bounce_rate_1h": func(s SignalSnapshot, _ Decision) (float64, bool) { return s.BounceRate1h, s.Sent1h > 0 },
+	"complaint_rate_1h": func(s SignalSnapshot, _ Decision) (float64, bool) {
+		return s.ComplaintRate1h, s.Sent1h > 0
+	},
+	"deferral_rate_5m": func(s SignalSnapshot, _ Decision) (float64, bool) { return s.DeferralRate5m, s.Sent5m > 0 },
+	"deferral_rate_1h": func(s SignalSnapshot, _ Decision) (float64, bool) { return s.DeferralRate1h, s.Sent1h > 0 },
+	"ip_bounce_rate_1h": func(s SignalSnapshot, d Decision) (float64, bool) {
+		m, ok := s.IPMetrics[d.TargetValue]
+		return m.BounceRate1h, ok && m.Sent1h > 0
+	},
+	"ip_deferral_rate_5m": func(s SignalSnapshot, d Decision) (float64, bool) {
+		m, ok := s.IPMetrics[d.TargetValue]
+		return m.DeferralRate, ok && m.Sent1h > 0
+	},
+	"ip_complaint_rate_24h": func(s SignalSnapshot, d Decision) (float64, bool) {
+		m, ok := s.IPMetrics[d.TargetValue]
+		return m.ComplaintRate, ok && m.Sent1h > 0
+	},
+}
+
+// inverseActions maps each revertible action to the action that undoes it.
+var inverseActions = map[string]string{
+	"deprioritize_ip":   "reprioritize_ip",
+	"disable_source_ip": "enable_source_ip",
+	"quarantine_ip":     "enable_source_ip",
+	"pause_isp_queues":  "resume_isp_queues",
+	"pause_warmup":      "resume_isp_queues",
+	"emergency_halt":    "resume_isp",
+	"reduce_rate":       "increase_rate",
+	"backoff_mode":      "increase_rate",
+}
+
+// InverseAction returns the action that undoes action, if any.
+func InverseAction(action string) (string, bool) {
+	inv, ok := inverseActions[action]
+	return inv, ok
+}
+
+// ApplyRevertPolicy fills in the default expiry and recovery condition for
+// actions that should not outlive the problem that caused them. Decisions
+// that already carry either are left alone. Emergency halts, quarantines and
+// warmup pauses stay in place until an operator resumes them.
+func ApplyRevertPolicy(d *Decision, cfg ISPConfig) {
+	if d.ExpiresAt != nil || d.RevertCondition != nil {
+		return
+	}
+	var ttl time.Duration
+	var cond *RevertCondition
+	switch d.ActionTaken {
+	case "deprioritize_ip":
+		ttl = 6 * time.Hour
+		cond = &RevertCondition{Metric: "ip_bounce_rate_1h", Operator: "<", Threshold: cfg.BounceWarnPct, SustainSeconds: 1800}
+	case "reduce_rate", "backoff_mode":
+		ttl = 4 * time.Hour
+		cond = &RevertCondition{Metric: "deferral_rate_5m", Operator: "<", Threshold: 20, SustainSeconds: 1800}
+	case "pause_isp_queues":
+		// Paused queues produce no traffic to measure recovery against.
+		ttl = time.Hour
+	case "disable_source_ip":
+		ttl = 24 * time.Hour
+	default:
+		return
+	}
+	base := d.CreatedAt
+	if base.IsZero() {
+		base = time.Now()
+	}
+	expires := base.Add(ttl)
+	d.ExpiresAt = &expires
+	d.RevertCondition = cond
+}
+
+// PendingRevert is a decision the DecisionReverter is waiting to undo.
+type PendingRevert struct {
+	Decision      Decision   `json:"decision"`
+	InverseAction string     `json:"inverse_action"`
+	HeldSince     *time.Time `json:"held_since,omitempty"` // when the recovery condition started holding
+	Attempts      int        `json:"failed_attempts,omitempty"`
+	RetryAt       *time.Time `json:"retry_at,omitempty"`
+}
+
+type revertWatch struct {
+	decision  Decision
+	heldSince time.Time
+	attempts  int       // failed inverse executions
+	retryAt   time.Time // no retry before this after a failure
+}
+
+// A failed inverse is retried after revertRetryBase, doubling up to
+// revertRetryMax between attempts. After maxRevertAttempts failures the
+// decision is dropped from the watch list and escalated to an operator.
+const (
+	revertRetryBase   = time.Minute
+	revertRetryMax    = 30 * time.Minute
+	maxRevertAttempts = 5
+)
+
+func revertBackoff(attempts int) time.Duration {
+	d := revertRetryBase
+	for i := 1; i < attempts && d < revertRetryMax; i++ {
+		d *= 2
+	}
+	return min(d, revertRetryMax)
+}
+
+// decisionExecutor is the slice of *Executor the reverter needs.
+type decisionExecutor interface {
+	Execute(ctx context.Context, d Decision) error
+}
+
+// DecisionReverter tracks temporary decisions and executes their inverse
+// once they expire or their recovery condition is met. Each revert is
+// persisted as its own decision and the original is marked reverted.
+type DecisionReverter struct {
+	store   DecisionStore
+	orgID   string
+	exec    decisionExecutor
+	alerter AlertSender
+	now     func() time.Time
+
+	mu      sync.Mutex
+	watches map[string]*revertWatch
+}
+
+// NewDecisionReverter creates a reverter that executes inverses via exec.
+func NewDecisionReverter(store DecisionStore, orgID string, exec decisionExecutor) *DecisionReverter {
+	return &DecisionReverter{
+		store:   store,
+		orgID:   orgID,
+		exec:    exec,
+		now:     time.Now,
+		watches: make(map[string]*revertWatch),
+	}
+}
+
+// SetAlerter sets where reverts that keep failing are escalated.
+func (r *DecisionReverter) SetAlerter(a AlertSender) {
+	r.alerter = a
+}
+
+// Load resumes watching unreverted temporary decisions after a restart.
+func (r *DecisionReverter) Load(ctx context.Context) error {
+	decisions, err := r.store.QueryRevertible(ctx, r.orgID)
+	if err != nil {
+		return err
+	}
+	for _, d := range decisions {
+		r.Track(d)
+	}
+	if len(decisions) > 0 {
+		log.Printf("[reverter] watching %d temporary decisions", len(decisions))
+	}
+	return nil
+}
+
+// Track starts watching d. Decisions without an ID, an inverse action, or
+// an expiry/condition are ignored.
+func (r *DecisionReverter) Track(d Decision) bool {
+	if d.ID == "" || d.RevertedAt != nil || (d.ExpiresAt == nil && d.RevertCondition == nil) {
+		return false
+	}
+	if _, ok := inverseActions[d.ActionTaken]; !ok {
+		return false
+	}
+	r.mu.Lock()
+	r.watches[d.ID] = &revertWatch{decision: d}
+	r.mu.Unlock()
+	return true
+}
+
+// Observe stops watching decisions that an agent has undone itself, for
+// example a throttle agent stepping back up with increase_rate, and marks
+// them reverted.
+func (r *DecisionReverter) Observe(ctx context.Context, d Decision) {
+	at := d.CreatedAt
+	if at.IsZero() {
+		at = r.now()
+	}
+	var superseded []string
+	r.mu.Lock()
+	for id, w := range r.watches {
+		if w.decision.ISP == d.ISP && w.decision.TargetValue == d.TargetValue &&
+			inverseActions[w.decision.ActionTaken] == d.ActionTaken {
+			superseded = append(superseded, id)
+			delete(r.watches, id)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, id := range superseded {
+		reason := "superseded by " + d.ActionTaken + " from " + string(d.AgentType) + " agent"
+		if err := r.store.MarkReverted(ctx, r.orgID, id, reason, at); err != nil {
+			log.Printf("[reverter] mark %s reverted: %v", id, err)
+		}
+	}
+}
+
+// Evaluate checks every watched decision for snap's ISP and reverts those
+// whose condition has held long enough or whose expiry has passed. The
+// snapshot timestamp is the clock. It returns the inverse decisions issued.
+func (r *DecisionReverter) Evaluate(ctx context.Context, snap SignalSnapshot) []Decision {
+	now := snap.Timestamp
+	if now.IsZero() {
+		now = r.now()
+	}
+
+	type due struct {
+		w      *revertWatch
+		reason string
+	}
+	var ready []due
+
+	r.mu.Lock()
+	for id, w := range r.watches {
+		d := w.decision
+		if d.ISP != snap.ISP || now.Before(w.retryAt) {
+			continue
+		}
+		reason := ""
+		if c := d.RevertCondition; c != nil {
+			v, ok := revertMetrics[c.Metric](snap, d)
+			if ok && c.holds(v) {
+				if w.heldSince.IsZero() {
+					w.heldSince = now
+				}
+				if now.Sub(w.heldSince) >= c.sustain() {
+					reason = fmt.Sprintf("recovered: %s (now %.2f)", c, v)
+				}
+			} else {
+				w.heldSince = time.Time{}
+			}
+		}
+		if reason == "" && d.ExpiresAt != nil && !now.Before(*d.ExpiresAt) {
+			reason = fmt.Sprintf("expired after %s", d.ExpiresAt.Sub(d.CreatedAt).Round(time.Second))
+		}
+		if reason != "" {
+			ready = append(ready, due{w, reason})
+			delete(r.watches, id)
+		}
+	}
+	// One inverse undoes every watched decision on the same target, so
+	// repeated backoff steps collapse into a single revert.
+	var batches [][]*revertWatch
+	var reasons []string
+	for _, x := range ready {
+		if x.w == nil {
+			continue
+		}
+		batch := []*revertWatch{x.w}
+		for i := range ready {
+			if ready[i].w != nil && ready[i].w != x.w && sameRevertTarget(ready[i].w.decision, x.w.decision) {
+				batch = append(batch, ready[i].w)
+				ready[i].w = nil
+			}
+		}
+		for id, w := range r.watches {
+			if sameRevertTarget(w.decision, x.w.decision) {
+				batch = append(batch, w)
+				delete(r.watches, id)
+			}
+		}
+		batches = append(batches, batch)
+		reasons = append(reasons, x.reason)
+	}
+	r.mu.Unlock()
+
+	var out []Decision
+	for i, batch := range batches {
+		inv, err := r.revert(ctx, batch, reasons[i], now)
+		if err != nil {
+			r.retryLater(batch, inv, now)
+		}
+		out = append(out, inv)
+	}
+	return out
+}
+
+func sameRevertTarget(a, b Decision) bool {
+	return a.ISP == b.ISP && a.TargetValue == b.TargetValue &&
+		inverseActions[a.ActionTaken] == inverseActions[b.ActionTaken]
+}
+
+func (r *DecisionReverter) restore(batch []*revertWatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range batch {
+		if _, ok := r.watches[w.decision.ID]; !ok {
+			r.watches[w.decision.ID] = w
+		}
+	}
+}
+
+// retryLater keeps watching a batch whose inverse failed, backing off
+// before the next attempt, or gives up and escalates once it has failed
+// maxRevertAttempts times. The decisions stay unreverted in the store.
+func (r *DecisionReverter) retryLater(batch []*revertWatch, inv Decision, at time.Time) {
+	attempts := 0
+	for _, w := range batch {
+		w.attempts++
+		attempts = max(attempts, w.attempts)
+	}
+	if attempts < maxRevertAttempts {
+		for _, w := range batch {
+			w.retryAt = at.Add(revertBackoff(attempts))
+		}
+		r.restore(batch)
+		return
+	}
+
+	log.Printf("[reverter] giving up on %s %s on %s after %d failed attempts; manual action required",
+		inv.ActionTaken, inv.TargetValue, inv.ISP, attempts)
+	if r.alerter != nil {
+		esc := inv
+		esc.ActionTaken = "revert_failed"
+		esc.ActionParams = mustJSON(map[string]interface{}{
+			"inverse_action":  inv.ActionTaken,
+			"attempts":        attempts,
+			"reverts":         batchIDs(batch),
+			"manual_required": true,
+		})
+		r.alerter.SendDecisionAlert(esc)
+	}
+}
+
+func batchIDs(batch []*revertWatch) []string {
+	ids := make([]string, len(batch))
+	for i, w := range batch {
+		ids[i] = w.decision.ID
+	}
+	return ids
+}
+
+// Revert immediately undoes a watched decision, along with any other
+// watched decisions the same inverse action undoes.
+func (r *DecisionReverter) Revert(ctx context.Context, id, reason string) (*Decision, error) {
+	r.mu.Lock()
+	w, ok := r.watches[id]
+	var batch []*revertWatch
+	if ok {
+		for wid, other := range r.watches {
+			if sameRevertTarget(other.decision, w.decision) {
+				batch = append(batch, other)
+				delete(r.watches, wid)
+			}
+		}
+	}
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("decision %s is not pending revert", id)
+	}
+	inv, err := r.revert(ctx, batch, reason, r.now())
+	if err != nil {
+		r.restore(batch)
+		return &inv, err
+	}
+	return &inv, nil
+}
+
+// Pending lists watched decisions, soonest expiry first.
+func (r *DecisionReverter) Pending() []PendingRevert {
+	r.mu.Lock()
+	out := make([]PendingRevert, 0, len(r.watches))
+	for _, w := range r.watches {
+		p := PendingRevert{Decision: w.decision, InverseAction: inverseActions[w.decision.ActionTaken], Attempts: w.attempts}
+		if !w.heldSince.IsZero() {
+			held := w.heldSince
+			p.HeldSince = &held
+		}
+		if !w.retryAt.IsZero() {
+			retry := w.retryAt
+			p.RetryAt = &retry
+		}
+		out = append(out, p)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].Decision.ExpiresAt, out[j].Decision.ExpiresAt
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
+	return out
+}
+
+func (r *DecisionReverter) revert(ctx context.Context, batch []*revertWatch, reason string, at time.Time) (Decision, error) {
+	d := batch[0].decision
+	ids := batchIDs(batch)
+	inv := Decision{
+		ID:             uuid.New().String(),
+		OrganizationID: r.orgID,
+		ISP:            d.ISP,
+		AgentType:      d.AgentType,
+		SignalValues:   json.RawMessage("{}"),
+		ActionTaken:    inverseActions[d.ActionTaken],
+		ActionParams: mustJSON(map[string]interface{}{
+			"reverts":         ids,
+			"reverted_action": d.ActionTaken,
+			"reason":          reason,
+		}),
+		TargetType:  d.TargetType,
+		TargetValue: d.TargetValue,
+		Result:      "applied",
+		CreatedAt:   at,
+	}
+
+	execErr := r.exec.Execute(ctx, inv)
+	if execErr != nil {
+		inv.Result = "failed"
+		log.Printf("[reverter] %s for decision %s failed: %v", inv.ActionTaken, d.ID, execErr)
+	}
+	if err := r.store.PersistDecision(ctx, inv); err != nil {
+		log.Printf("[reverter] persist revert of %s: %v", d.ID, err)
+	}
+	if execErr != nil {
+		return inv, execErr
+	}
+
+	for _, id := range ids {
+		if err := r.store.MarkReverted(ctx, r.orgID, id, reason, at); err != nil {
+			log.Printf("[reverter] mark %s reverted: %v", id, err)
+		}
+	}
+	log.Printf("[reverter] %s %s on %s: %s", inv.ActionTaken, d.TargetValue, d.ISP, reason)
+	return inv, nil
+}
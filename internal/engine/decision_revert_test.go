@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memDecisionStore struct {
+	DecisionStore
+	mu        sync.Mutex
+	persisted []Decision
+	reverted  map[string]string
+}
+
+func (s *memDecisionStore) PersistDecision(_ context.Context, d Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persisted = append(s.persisted, d)
+	return nil
+}
+
+func (s *memDecisionStore) MarkReverted(_ context.Context, _ string, id, reason string, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reverted[id] = reason
+	return nil
+}
+
+type recordingExecutor struct {
+	actions []string
+	err     error
+}
+
+func (e *recordingExecutor) Execute(_ context.Context, d Decision) error {
+	e.actions = append(e.actions, d.ActionTaken+":"+d.TargetValue)
+	return e.err
+}
+
+func newTestReverter() (*DecisionReverter, *memDecisionStore, *recordingExecutor) {
+	store := &memDecisionStore{reverted: map[string]string{}}
+	exec := &recordingExecutor{}
+	return NewDecisionReverter(store, "org-1", exec), store, exec
+}
+
+func ipSnap(at time.Time, ip string, bounceRate float64, sent int) SignalSnapshot {
+	return SignalSnapshot{
+		ISP:       ISPGmail,
+		Timestamp: at,
+		IPMetrics: map[string]IPMetric{ip: {IP: ip, BounceRate1h: bounceRate, Sent1h: sent}},
+	}
+}
+
+func TestDecisionReverter_RecoveryConditionMustBeSustained(t *testing.T) {
+	r, store, exec := newTestReverter()
+	t0 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	d := Decision{ID: "d-1", ISP: ISPGmail, AgentType: AgentReputation, ActionTaken: "deprioritize_ip",
+		TargetType: "ip", TargetValue: "10.0.0.1", CreatedAt: t0}
+	ApplyRevertPolicy(&d, ISPConfig{BounceWarnPct: 2})
+	require.NotNil(t, d.RevertCondition)
+	assert.Equal(t, t0.Add(6*time.Hour), *d.ExpiresAt)
+	require.True(t, r.Track(d))
+
+	assert.Empty(t, r.Evaluate(context.Background(), ipSnap(t0.Add(5*time.Minute), "10.0.0.1", 1.0, 500)))
+	// A relapse restarts the sustain window.
+	assert.Empty(t, r.Evaluate(context.Background(), ipSnap(t0.Add(20*time.Minute), "10.0.0.1", 3.0, 500)))
+	assert.Empty(t, r.Evaluate(context.Background(), ipSnap(t0.Add(25*time.Minute), "10.0.0.1", 1.5, 500)))
+	// No traffic cannot confirm recovery either.
+	assert.Empty(t, r.Evaluate(context.Background(), ipSnap(t0.Add(40*time.Minute), "10.0.0.1", 0, 0)))
+	assert.Empty(t, r.Evaluate(context.Background(), ipSnap(t0.Add(45*time.Minute), "10.0.0.1", 1.2, 500)))
+	require.Len(t, r.Pending(), 1)
+	assert.NotNil(t, r.Pending()[0].HeldSince)
+
+	out := r.Evaluate(context.Background(), ipSnap(t0.Add(75*time.Minute), "10.0.0.1", 1.1, 500))
+	require.Len(t, out, 1)
+	assert.Equal(t, "reprioritize_ip", out[0].ActionTaken)
+	assert.Equal(t, "applied", out[0].Result)
+	assert.Equal(t, []string{"reprioritize_ip:10.0.0.1"}, exec.actions)
+	assert.Contains(t, store.reverted["d-1"], "recovered: ip_bounce_rate_1h < 2.00 for 30m0s")
+	require.Len(t, store.persisted, 1)
+	assert.Empty(t, r.Pending())
+}
+
+func TestDecisionReverter_ExpiryCollapsesRepeatedSteps(t *testing.T) {
+	r, store, exec := newTestReverter()
+	t0 := time.Now()
+	exp := t0.Add(time.Hour)
+
+	for _, id := range []string{"r-1", "r-2"} {
+		r.Track(Decision{ID: id, ISP: ISPGmail, AgentType: AgentThrottle, ActionTaken: "reduce_rate",
+			TargetType: "isp", TargetValue: "gmail", CreatedAt: t0, ExpiresAt: &exp})
+	}
+	r.Track(Decision{ID: "y-1", ISP: ISPYahoo, ActionTaken: "reduce_rate", TargetValue: "yahoo", CreatedAt: t0, ExpiresAt: &exp})
+
+	assert.Empty(t, r.Evaluate(context.Background(), SignalSnapshot{ISP: ISPGmail, Timestamp: t0.Add(30 * time.Minute)}))
+	out := r.Evaluate(context.Background(), SignalSnapshot{ISP: ISPGmail, Timestamp: t0.Add(61 * time.Minute)})
+	require.Len(t, out, 1)
+	assert.Equal(t, []string{"increase_rate:gmail"}, exec.actions)
+	assert.Equal(t, "expired after 1h0m0s", store.reverted["r-1"])
+	assert.Equal(t, "expired after 1h0m0s", store.reverted["r-2"])
+	require.Len(t, r.Pending(), 1, "other ISPs are untouched")
+}
+
+type recordingAlerts struct {
+	AlertSender
+	decisions []Decision
+}
+
+func (a *recordingAlerts) SendDecisionAlert(d Decision) {
+	a.decisions = append(a.decisions, d)
+}
+
+func TestDecisionReverter_FailedInverseIsRetried(t *testing.T) {
+	r, store, exec := newTestReverter()
+	t0 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	exp := t0.Add(-time.Minute)
+	r.Track(Decision{ID: "p-1", ISP: ISPGmail, ActionTaken: "pause_isp_queues", TargetValue: "gmail", ExpiresAt: &exp})
+
+	exec.err = errors.New("ssh: connection refused")
+	out := r.Evaluate(context.Background(), SignalSnapshot{ISP: ISPGmail, Timestamp: t0})
+	require.Len(t, out, 1)
+	assert.Equal(t, "failed", out[0].Result)
+	assert.Empty(t, store.reverted)
+	require.Len(t, r.Pending(), 1)
+	assert.Equal(t, 1, r.Pending()[0].Attempts)
+	assert.Equal(t, t0.Add(time.Minute), *r.Pending()[0].RetryAt)
+
+	// Nothing is retried before the backoff has passed.
+	assert.Empty(t, r.Evaluate(context.Background(), SignalSnapshot{ISP: ISPGmail, Timestamp: t0.Add(30 * time.Second)}))
+
+	exec.err = nil
+	r.Evaluate(context.Background(), SignalSnapshot{ISP: ISPGmail, Timestamp: t0.Add(time.Minute)})
+	assert.Equal(t, []string{"resume_isp_queues:gmail", "resume_isp_queues:gmail"}, exec.actions)
+	assert.Contains(t, store.reverted, "p-1")
+}
+
+func TestDecisionReverter_GivesUpAndEscalates(t *testing.T) {
+	r, store, exec := newTestReverter()
+	alerts := &recordingAlerts{}
+	r.SetAlerter(alerts)
+	t0 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	exp := t0.Add(-time.Minute)
+	r.Track(Decision{ID: "p-1", ISP: ISPGmail, ActionTaken: "pause_isp_queues", TargetValue: "gmail", ExpiresAt: &exp})
+
+	exec.err = errors.New("ssh: connection refused")
+	at := t0
+	for i := 0; i < maxRevertAttempts; i++ {
+		require.Len(t, r.Evaluate(context.Background(), SignalSnapshot{ISP: ISPGmail, Timestamp: at}), 1)
+		at = at.Add(revertRetryMax)
+	}
+	assert.Len(t, store.persisted, maxRevertAttempts)
+	assert.Empty(t, r.Pending())
+	assert.Empty(t, r.Evaluate(context.Background(), SignalSnapshot{ISP: ISPGmail, Timestamp: at}))
+	assert.Empty(t, store.reverted)
+
+	require.Len(t, alerts.decisions, 1)
+	assert.Equal(t, "revert_failed", alerts.decisions[0].ActionTaken)
+	assert.Contains(t, string(alerts.decisions[0].ActionParams), `"attempts":5`)
+
+	assert.Equal(t, revertRetryBase, revertBackoff(1))
+	assert.Equal(t, 8*time.Minute, revertBackoff(4))
+	assert.Equal(t, revertRetryMax, revertBackoff(10))
+}
+
+func TestDecisionReverter_AgentInverseSupersedes(t *testing.T) {
+	r, store, _ := newTestReverter()
+	exp := time.Now().Add(time.Hour)
+	r.Track(Decision{ID: "d-1", ISP: ISPGmail, ActionTaken: "deprioritize_ip", TargetValue: "10.0.0.1", ExpiresAt: &exp})
+	assert.False(t, r.Track(Decision{ID: "q-1", ISP: ISPGmail, ActionTaken: "quarantine_ip"}), "no expiry or condition")
+
+	r.Observe(context.Background(), Decision{ISP: ISPGmail, AgentType: AgentReputation, ActionTaken: "reprioritize_ip", TargetValue: "10.0.0.1"})
+	assert.Empty(t, r.Pending())
+	assert.Equal(t, "superseded by reprioritize_ip from reputation agent", store.reverted["d-1"])
+
+	_, err := r.Revert(context.Background(), "d-1", "manual")
+	assert.Error(t, err)
+}
+
+func TestRevertCondition_Validate(t *testing.T) {
+	assert.NoError(t, RevertCondition{Metric: "bounce_rate_1h", Operator: "<", Threshold: 2, SustainSeconds: 1800}.Validate())
+	assert.Error(t, RevertCondition{Metric: "open_rate", Operator: "<"}.Validate())
+	assert.Error(t, RevertCondition{Metric: "bounce_rate_1h", Operator: "=="}.Validate())
+}
+
+func TestDBDecisionStore_MarkReverted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	store := &DBDecisionStore{DB: db}
+	at := time.Now()
+
+	mock.ExpectExec("UPDATE mailing_engine_decisions SET result = 'reverted'").
+		WithArgs("org-1", "d-1", at, "expired after 1h0m0s").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_engine_decisions SET result = 'reverted'").
+		WithArgs("org-1", "d-1", at, "again").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	require.NoError(t, store.MarkReverted(context.Background(), "org-1", "d-1", "expired after 1h0m0s", at))
+	assert.Error(t, store.MarkReverted(context.Background(), "org-1", "d-1", "again", at))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
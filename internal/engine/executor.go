@@ -1,27 +1,21 @@
 package engine
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"strings"
+	"math"
 	"sync"
 	"time"
-
-	"golang.org/x/crypto/ssh"
 )
 
-// Executor translates agent decisions into PMTA actions via SSH.
+// Executor translates agent decisions into PMTA actions and hands them to a
+// PMTABackend: SSHBackend runs the pmta CLI, HTTPBackend calls the
+// management API and FakePMTA records state in memory.
 // Batches config changes to avoid excessive reloads
 // (max 1 reload per 30 seconds).
 type Executor struct {
-	pmtaHost     string
-	pmtaPort     int
-	sshUser      string
-	sshKeyPath   string
-	sshClient    *ssh.Client
+	backend PMTABackend
 
 	mu            sync.Mutex
 	pendingReload bool
@@ -29,14 +23,16 @@ type Executor struct {
 	reloadMinGap  time.Duration
 }
 
-// NewExecutor creates a new PMTA command executor.
+// NewExecutor creates a new PMTA command executor over SSH.
 // Pass an empty host to operate in dry-run mode.
 func NewExecutor(host string, port int, user, sshKeyPath string) *Executor {
+	return NewExecutorWithBackend(NewSSHBackend(host, port, user, sshKeyPath))
+}
+
+// NewExecutorWithBackend creates an executor that drives backend.
+func NewExecutorWithBackend(backend PMTABackend) *Executor {
 	return &Executor{
-		pmtaHost:     host,
-		pmtaPort:     port,
-		sshUser:      user,
-		sshKeyPath:   sshKeyPath,
+		backend:      backend,
 		reloadMinGap: 30 * time.Second,
 	}
 }
@@ -48,10 +44,16 @@ func (e *Executor) Execute(ctx context.Context, d Decision) error {
 		return e.disableSource(ctx, d.TargetValue, string(d.ISP))
 	case "quarantine_ip":
 		return e.disableSource(ctx, d.TargetValue, string(d.ISP))
+	case "enable_source_ip":
+		return e.enableSource(ctx, d.TargetValue, string(d.ISP))
 	case "pause_isp_queues":
 		return e.pauseQueues(ctx, d.ISP)
+	case "resume_isp_queues":
+		return e.resumeQueues(ctx, d.ISP)
 	case "emergency_halt":
 		return e.emergencyHalt(ctx, d.ISP)
+	case "resume_isp":
+		return e.ResumeISP(ctx, d.ISP)
 	case "deprioritize_ip":
 		return e.deprioritizeIP(ctx, d.TargetValue, d.ISP)
 	case "reprioritize_ip":
@@ -72,131 +74,67 @@ func (e *Executor) Execute(ctx context.Context, d Decision) error {
 	}
 }
 
-func (e *Executor) ensureSSH() (*ssh.Client, error) {
-	if e.sshClient != nil {
-		// Quick liveness check
-		_, _, err := e.sshClient.SendRequest("keepalive@openssh.com", true, nil)
-		if err == nil {
-			return e.sshClient, nil
-		}
-		e.sshClient.Close()
-		e.sshClient = nil
-	}
-
-	var keyBytes []byte
-	if strings.HasPrefix(e.sshKeyPath, "-----BEGIN") {
-		keyBytes = []byte(e.sshKeyPath)
-	} else {
-		raw, readErr := os.ReadFile(e.sshKeyPath)
-		if readErr != nil {
-			return nil, fmt.Errorf("read SSH key %s: %w", e.sshKeyPath, readErr)
-		}
-		keyBytes = raw
-	}
-	passphrase := os.Getenv("PMTA_SSH_PASSPHRASE")
-	var signer ssh.Signer
-	var parseErr error
-	if passphrase != "" {
-		signer, parseErr = ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
-	} else {
-		signer, parseErr = ssh.ParsePrivateKey(keyBytes)
-	}
-	if parseErr != nil {
-		return nil, fmt.Errorf("parse SSH key: %w", parseErr)
-	}
-
-	config := &ssh.ClientConfig{
-		User:            e.sshUser,
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	addr := fmt.Sprintf("%s:%d", e.pmtaHost, e.pmtaPort)
-	client, err := ssh.Dial("tcp", addr, config)
-	if err != nil {
-		return nil, fmt.Errorf("SSH dial %s: %w", addr, err)
-	}
-	e.sshClient = client
-	return client, nil
-}
-
-func (e *Executor) sendCommand(ctx context.Context, command string) error {
-	if e.pmtaHost == "" {
-		log.Printf("[executor] dry-run: %s", command)
-		return nil
-	}
-
-	client, err := e.ensureSSH()
-	if err != nil {
-		return fmt.Errorf("SSH connect: %w", err)
-	}
-
-	session, err := client.NewSession()
-	if err != nil {
-		// Connection may have broken; reset and retry once
-		e.sshClient = nil
-		client, err = e.ensureSSH()
-		if err != nil {
-			return fmt.Errorf("SSH reconnect: %w", err)
-		}
-		session, err = client.NewSession()
-		if err != nil {
-			return fmt.Errorf("SSH session: %w", err)
-		}
-	}
-	defer session.Close()
-
-	// Wrap with sudo since pmta CLI needs root
-	fullCmd := fmt.Sprintf("sudo /usr/sbin/%s", command)
-	output, err := session.CombinedOutput(fullCmd)
-	if err != nil {
-		return fmt.Errorf("pmta command '%s': %s (output: %s)", command, err, string(output))
-	}
-
-	log.Printf("[executor] command OK: %s → %s", command, string(output))
-	return nil
+func (e *Executor) disableSource(ctx context.Context, ip string, domain string) error {
+	return e.backend.DisableSource(ctx, ip, domain+"/*")
 }
 
-func (e *Executor) disableSource(ctx context.Context, ip string, domain string) error {
-	cmd := fmt.Sprintf("pmta disable source %s %s/*", ip, domain)
-	return e.sendCommand(ctx, cmd)
+func (e *Executor) enableSource(ctx context.Context, ip string, domain string) error {
+	return e.backend.EnableSource(ctx, ip, domain+"/*")
 }
 
 // deprioritizeIP puts a single IP into backoff mode for a specific ISP pool
 // instead of disabling it entirely. The IP is still usable at reduced throughput.
 func (e *Executor) deprioritizeIP(ctx context.Context, ip string, isp ISP) error {
-	cmd := fmt.Sprintf("pmta set queue --mode=backoff %s/%s-pool", ip, isp)
-	return e.sendCommand(ctx, cmd)
+	return e.backend.SetQueueMode(ctx, ipQueue(ip, isp), QueueModeBackoff)
 }
 
 // reprioritizeIP restores normal sending for a single IP on an ISP pool.
 func (e *Executor) reprioritizeIP(ctx context.Context, ip string, isp ISP) error {
-	cmd := fmt.Sprintf("pmta set queue --mode=normal %s/%s-pool", ip, isp)
-	return e.sendCommand(ctx, cmd)
+	return e.backend.SetQueueMode(ctx, ipQueue(ip, isp), QueueModeNormal)
 }
 
 func (e *Executor) pauseQueues(ctx context.Context, isp ISP) error {
-	cmd := fmt.Sprintf("pmta pause queue */%s-pool", isp)
-	return e.sendCommand(ctx, cmd)
+	return e.backend.PauseQueue(ctx, ispQueue(isp))
+}
+
+func (e *Executor) resumeQueues(ctx context.Context, isp ISP) error {
+	return e.backend.ResumeQueue(ctx, ispQueue(isp))
+}
+
+// ispQueue is the queue pattern covering every IP in an ISP's pool.
+func ispQueue(isp ISP) string {
+	return fmt.Sprintf("*/%s-pool", isp)
+}
+
+// ipQueue is the queue of a single IP in an ISP's pool.
+func ipQueue(ip string, isp ISP) string {
+	return fmt.Sprintf("%s/%s-pool", ip, isp)
+}
+
+// SetRate applies a rate controller rate as PMTA's max-msg-rate: on the
+// IP's queue in the ISP pool, or on the whole pool when ip is empty. The
+// pool pattern sets the rate of each IP queue it matches, not their total.
+func (e *Executor) SetRate(ctx context.Context, isp ISP, ip string, perMinute float64) error {
+	queue := ispQueue(isp)
+	if ip != "" {
+		queue = ipQueue(ip, isp)
+	}
+	return e.backend.SetQueueRate(ctx, queue, max(1, int(math.Round(perMinute*60))))
 }
 
 func (e *Executor) emergencyHalt(ctx context.Context, isp ISP) error {
 	if err := e.pauseQueues(ctx, isp); err != nil {
 		return err
 	}
-	cmd := fmt.Sprintf("pmta disable source * */%s-pool", isp)
-	return e.sendCommand(ctx, cmd)
+	return e.backend.DisableSource(ctx, "*", ispQueue(isp))
 }
 
 func (e *Executor) setBackoffMode(ctx context.Context, isp ISP) error {
-	cmd := fmt.Sprintf("pmta set queue --mode=backoff */%s-pool", isp)
-	return e.sendCommand(ctx, cmd)
+	return e.backend.SetQueueMode(ctx, ispQueue(isp), QueueModeBackoff)
 }
 
 func (e *Executor) setNormalMode(ctx context.Context, isp ISP) error {
-	cmd := fmt.Sprintf("pmta set queue --mode=normal */%s-pool", isp)
-	return e.sendCommand(ctx, cmd)
+	return e.backend.SetQueueMode(ctx, ispQueue(isp), QueueModeNormal)
 }
 
 func (e *Executor) triggerReload(ctx context.Context) error {
@@ -208,7 +146,7 @@ func (e *Executor) triggerReload(ctx context.Context) error {
 		return nil
 	}
 
-	if err := e.sendCommand(ctx, "pmta reload"); err != nil {
+	if err := e.backend.Reload(ctx); err != nil {
 		return err
 	}
 	e.lastReload = time.Now()
@@ -239,59 +177,31 @@ func (e *Executor) StartReloadLoop(ctx context.Context) {
 
 // ResumeAll resumes all queues and re-enables all sources (manual override).
 func (e *Executor) ResumeAll(ctx context.Context) error {
-	if err := e.sendCommand(ctx, "pmta resume queue */*"); err != nil {
+	if err := e.backend.ResumeQueue(ctx, "*/*"); err != nil {
 		return err
 	}
-	return e.sendCommand(ctx, "pmta enable source * */*")
+	return e.backend.EnableSource(ctx, "*", "*/*")
 }
 
 // ResumeISP resumes queues for a specific ISP.
 func (e *Executor) ResumeISP(ctx context.Context, isp ISP) error {
-	cmd := fmt.Sprintf("pmta resume queue */%s-pool", isp)
-	if err := e.sendCommand(ctx, cmd); err != nil {
+	if err := e.backend.ResumeQueue(ctx, ispQueue(isp)); err != nil {
 		return err
 	}
-	cmd = fmt.Sprintf("pmta enable source * */%s-pool", isp)
-	return e.sendCommand(ctx, cmd)
+	return e.backend.EnableSource(ctx, "*", ispQueue(isp))
 }
 
-// SCPFile copies a local file to the PMTA server via SSH/SFTP.
+// SCPFile copies a local file to the PMTA server.
 func (e *Executor) SCPFile(localPath, remotePath string) error {
-	if e.pmtaHost == "" {
-		log.Printf("[executor] dry-run: scp %s -> %s", localPath, remotePath)
-		return nil
-	}
-
-	client, err := e.ensureSSH()
-	if err != nil {
-		return err
-	}
-
-	data, err := os.ReadFile(localPath)
-	if err != nil {
-		return fmt.Errorf("read local file: %w", err)
-	}
-
-	session, err := client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
+	return e.backend.PutFile(context.Background(), localPath, remotePath)
+}
 
-	// Write via stdin to a temp file, then move atomically
-	tmpPath := remotePath + ".tmp"
-	cmd := fmt.Sprintf("sudo tee %s > /dev/null && sudo mv %s %s", tmpPath, tmpPath, remotePath)
-	session.Stdin = bytes.NewReader(data)
-	if err := session.Run(cmd); err != nil {
-		return fmt.Errorf("scp %s: %w", remotePath, err)
-	}
-	return nil
+// Backend returns the transport the executor drives.
+func (e *Executor) Backend() PMTABackend {
+	return e.backend
 }
 
-// Close shuts down the SSH connection.
+// Close shuts down the backend connection.
 func (e *Executor) Close() {
-	if e.sshClient != nil {
-		e.sshClient.Close()
-		e.sshClient = nil
-	}
+	e.backend.Close()
 }
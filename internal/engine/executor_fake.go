@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+)
+
+// FakePMTA is an in-process PMTABackend that records the queue, source and
+// mode state it was asked to set. Wildcard patterns behave like PMTA's:
+// resuming "*/*" clears every paused queue, enabling source "*" clears every
+// disabled source on matching queues.
+type FakePMTA struct {
+	mu       sync.Mutex
+	paused   map[string]bool
+	disabled map[fakeSource]bool
+	modes    map[string]string
+	rates    map[string]int
+	files    map[string][]byte
+	reloads  int
+	ops      []string
+	failNext error
+}
+
+type fakeSource struct{ source, queue string }
+
+// NewFakePMTA creates a fake with every queue running in normal mode.
+func NewFakePMTA() *FakePMTA {
+	return &FakePMTA{
+		paused:   make(map[string]bool),
+		disabled: make(map[fakeSource]bool),
+		modes:    make(map[string]string),
+		rates:    make(map[string]int),
+		files:    make(map[string][]byte),
+	}
+}
+
+// FailNext makes the next operation return err without changing state.
+func (f *FakePMTA) FailNext(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failNext = err
+}
+
+// record logs op and consumes a pending failure. Callers hold f.mu.
+func (f *FakePMTA) record(op string) error {
+	f.ops = append(f.ops, op)
+	if err := f.failNext; err != nil {
+		f.failNext = nil
+		return err
+	}
+	return nil
+}
+
+// pmtaPatternMatch reports whether key is covered by a PMTA pattern.
+func pmtaPatternMatch(pattern, key string) bool {
+	if pattern == key {
+		return true
+	}
+	ok, _ := path.Match(pattern, key)
+	return ok
+}
+
+func (f *FakePMTA) PauseQueue(_ context.Context, queue string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("pause queue " + queue); err != nil {
+		return err
+	}
+	f.paused[queue] = true
+	return nil
+}
+
+func (f *FakePMTA) ResumeQueue(_ context.Context, queue string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("resume queue " + queue); err != nil {
+		return err
+	}
+	for q := range f.paused {
+		if pmtaPatternMatch(queue, q) {
+			delete(f.paused, q)
+		}
+	}
+	return nil
+}
+
+func (f *FakePMTA) SetQueueMode(_ context.Context, queue, mode string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(fmt.Sprintf("set queue --mode=%s %s", mode, queue)); err != nil {
+		return err
+	}
+	if mode == QueueModeNormal {
+		delete(f.modes, queue)
+	} else {
+		f.modes[queue] = mode
+	}
+	return nil
+}
+
+func (f *FakePMTA) SetQueueRate(_ context.Context, queue string, perHour int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(fmt.Sprintf("set queue --max-msg-rate=%d/h %s", perHour, queue)); err != nil {
+		return err
+	}
+	f.rates[queue] = perHour
+	return nil
+}
+
+func (f *FakePMTA) DisableSource(_ context.Context, source, queue string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(fmt.Sprintf("disable source %s %s", source, queue)); err != nil {
+		return err
+	}
+	f.disabled[fakeSource{source, queue}] = true
+	return nil
+}
+
+func (f *FakePMTA) EnableSource(_ context.Context, source, queue string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record(fmt.Sprintf("enable source %s %s", source, queue)); err != nil {
+		return err
+	}
+	for k := range f.disabled {
+		if pmtaPatternMatch(source, k.source) && pmtaPatternMatch(queue, k.queue) {
+			delete(f.disabled, k)
+		}
+	}
+	return nil
+}
+
+func (f *FakePMTA) Reload(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("reload"); err != nil {
+		return err
+	}
+	f.reloads++
+	return nil
+}
+
+func (f *FakePMTA) PutFile(_ context.Context, localPath, remotePath string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("read local file: %w", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("put " + remotePath); err != nil {
+		return err
+	}
+	f.files[remotePath] = data
+	return nil
+}
+
+func (f *FakePMTA) Close() {}
+
+// QueuePaused reports whether queue was paused and not resumed since.
+func (f *FakePMTA) QueuePaused(queue string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paused[queue]
+}
+
+// PausedQueues returns the paused queue patterns, sorted.
+func (f *FakePMTA) PausedQueues() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, 0, len(f.paused))
+	for q := range f.paused {
+		out = append(out, q)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// SourceDisabled reports whether source was disabled on queue.
+func (f *FakePMTA) SourceDisabled(source, queue string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.disabled[fakeSource{source, queue}]
+}
+
+// QueueMode returns the mode set on queue; QueueModeNormal if none.
+func (f *FakePMTA) QueueMode(queue string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := f.modes[queue]; ok {
+		return m
+	}
+	return QueueModeNormal
+}
+
+// QueueRate returns the max-msg-rate per hour set on queue, or 0 if none.
+func (f *FakePMTA) QueueRate(queue string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rates[queue]
+}
+
+// Reloads returns how many reloads were performed.
+func (f *FakePMTA) Reloads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reloads
+}
+
+// File returns the contents last copied to remotePath.
+func (f *FakePMTA) File(remotePath string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[remotePath]
+	return data, ok
+}
+
+// Ops returns every operation requested, in CLI form, including failed ones.
+func (f *FakePMTA) Ops() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, len(f.ops))
+	copy(out, f.ops)
+	return out
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrFileTransferUnsupported is returned by backends that cannot write files
+// on the PMTA host.
+var ErrFileTransferUnsupported = errors.New("backend cannot copy files to the PMTA host")
+
+// HTTPBackendConfig configures the PMTA management API backend.
+type HTTPBackendConfig struct {
+	BaseURL  string // e.g. https://pmta1:19000
+	User     string // basic auth, as used by the ingestor's status polling
+	Password string
+	APIKey   string // bearer token; takes precedence over basic auth
+	Client   *http.Client
+}
+
+// HTTPBackend drives PMTA through its HTTP management API, so API containers
+// no longer need SSH keys. Each CLI command maps to a POST on the matching
+// path with the arguments as form values, e.g. "pmta pause queue */gmail-pool"
+// becomes POST /pause/queue with queue=*/gmail-pool.
+type HTTPBackend struct {
+	baseURL  string
+	user     string
+	password string
+	apiKey   string
+	client   *http.Client
+}
+
+// NewHTTPBackend creates a management API backend. An empty BaseURL puts it
+// in dry-run mode.
+func NewHTTPBackend(cfg HTTPBackendConfig) *HTTPBackend {
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{
+			Timeout: 15 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	return &HTTPBackend{
+		baseURL:  strings.TrimRight(cfg.BaseURL, "/"),
+		user:     cfg.User,
+		password: cfg.Password,
+		apiKey:   cfg.APIKey,
+		client:   client,
+	}
+}
+
+func (b *HTTPBackend) PauseQueue(ctx context.Context, queue string) error {
+	return b.post(ctx, "/pause/queue", url.Values{"queue": {queue}})
+}
+
+func (b *HTTPBackend) ResumeQueue(ctx context.Context, queue string) error {
+	return b.post(ctx, "/resume/queue", url.Values{"queue": {queue}})
+}
+
+func (b *HTTPBackend) SetQueueMode(ctx context.Context, queue, mode string) error {
+	return b.post(ctx, "/set/queue", url.Values{"queue": {queue}, "mode": {mode}})
+}
+
+func (b *HTTPBackend) SetQueueRate(ctx context.Context, queue string, perHour int) error {
+	return b.post(ctx, "/set/queue", url.Values{"queue": {queue}, "max-msg-rate": {fmt.Sprintf("%d/h", perHour)}})
+}
+
+func (b *HTTPBackend) DisableSource(ctx context.Context, source, queue string) error {
+	return b.post(ctx, "/disable/source", url.Values{"source": {source}, "queue": {queue}})
+}
+
+func (b *HTTPBackend) EnableSource(ctx context.Context, source, queue string) error {
+	return b.post(ctx, "/enable/source", url.Values{"source": {source}, "queue": {queue}})
+}
+
+func (b *HTTPBackend) Reload(ctx context.Context) error {
+	return b.post(ctx, "/reload", nil)
+}
+
+// PutFile is not available over the management API.
+func (b *HTTPBackend) PutFile(_ context.Context, _, remotePath string) error {
+	return fmt.Errorf("put %s: %w", remotePath, ErrFileTransferUnsupported)
+}
+
+func (b *HTTPBackend) Close() {}
+
+func (b *HTTPBackend) post(ctx context.Context, path string, args url.Values) error {
+	if b.baseURL == "" {
+		log.Printf("[executor] dry-run: POST %s %s", path, args.Encode())
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+path, strings.NewReader(args.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if b.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+b.apiKey)
+	} else if b.user != "" || b.password != "" {
+		req.SetBasicAuth(b.user, b.password)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pmta api %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("pmta api %s returned %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	log.Printf("[executor] api OK: %s %s", path, args.Encode())
+	return nil
+}
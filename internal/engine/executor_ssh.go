@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHBackend runs the pmta CLI on the PMTA host over SSH.
+type SSHBackend struct {
+	host    string
+	port    int
+	user    string
+	keyPath string // path to a private key, or the PEM itself
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewSSHBackend creates an SSH backend. Pass an empty host to operate in
+// dry-run mode, where commands are only logged.
+func NewSSHBackend(host string, port int, user, sshKeyPath string) *SSHBackend {
+	return &SSHBackend{host: host, port: port, user: user, keyPath: sshKeyPath}
+}
+
+func (b *SSHBackend) PauseQueue(ctx context.Context, queue string) error {
+	return b.sendCommand(ctx, fmt.Sprintf("pmta pause queue %s", queue))
+}
+
+func (b *SSHBackend) ResumeQueue(ctx context.Context, queue string) error {
+	return b.sendCommand(ctx, fmt.Sprintf("pmta resume queue %s", queue))
+}
+
+func (b *SSHBackend) SetQueueMode(ctx context.Context, queue, mode string) error {
+	return b.sendCommand(ctx, fmt.Sprintf("pmta set queue --mode=%s %s", mode, queue))
+}
+
+func (b *SSHBackend) SetQueueRate(ctx context.Context, queue string, perHour int) error {
+	return b.sendCommand(ctx, fmt.Sprintf("pmta set queue --max-msg-rate=%d/h %s", perHour, queue))
+}
+
+func (b *SSHBackend) DisableSource(ctx context.Context, source, queue string) error {
+	return b.sendCommand(ctx, fmt.Sprintf("pmta disable source %s %s", source, queue))
+}
+
+func (b *SSHBackend) EnableSource(ctx context.Context, source, queue string) error {
+	return b.sendCommand(ctx, fmt.Sprintf("pmta enable source %s %s", source, queue))
+}
+
+func (b *SSHBackend) Reload(ctx context.Context) error {
+	return b.sendCommand(ctx, "pmta reload")
+}
+
+func (b *SSHBackend) ensureSSH() (*ssh.Client, error) {
+	if b.client != nil {
+		// Quick liveness check
+		_, _, err := b.client.SendRequest("keepalive@openssh.com", true, nil)
+		if err == nil {
+			return b.client, nil
+		}
+		b.client.Close()
+		b.client = nil
+	}
+
+	var keyBytes []byte
+	if strings.HasPrefix(b.keyPath, "-----BEGIN") {
+		keyBytes = []byte(b.keyPath)
+	} else {
+		raw, readErr := os.ReadFile(b.keyPath)
+		if readErr != nil {
+			return nil, fmt.Errorf("read SSH key %s: %w", b.keyPath, readErr)
+		}
+		keyBytes = raw
+	}
+	passphrase := os.Getenv("PMTA_SSH_PASSPHRASE")
+	var signer ssh.Signer
+	var parseErr error
+	if passphrase != "" {
+		signer, parseErr = ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
+	} else {
+		signer, parseErr = ssh.ParsePrivateKey(keyBytes)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("parse SSH key: %w", parseErr)
+	}
+
+	config := &ssh.ClientConfig{
+		User:            b.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	}
+
+	addr := fmt.Sprintf("%s:%d", b.host, b.port)
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("SSH dial %s: %w", addr, err)
+	}
+	b.client = client
+	return client, nil
+}
+
+func (b *SSHBackend) sendCommand(ctx context.Context, command string) error {
+	if b.host == "" {
+		log.Printf("[executor] dry-run: %s", command)
+		return nil
+	}
+
+	// One command at a time over the shared connection.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	client, err := b.ensureSSH()
+	if err != nil {
+		return fmt.Errorf("SSH connect: %w", err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		// Connection may have broken; reset and retry once
+		b.client = nil
+		client, err = b.ensureSSH()
+		if err != nil {
+			return fmt.Errorf("SSH reconnect: %w", err)
+		}
+		session, err = client.NewSession()
+		if err != nil {
+			return fmt.Errorf("SSH session: %w", err)
+		}
+	}
+	defer session.Close()
+
+	// Wrap with sudo since pmta CLI needs root
+	fullCmd := fmt.Sprintf("sudo /usr/sbin/%s", command)
+	output, err := session.CombinedOutput(fullCmd)
+	if err != nil {
+		return fmt.Errorf("pmta command '%s': %s (output: %s)", command, err, string(output))
+	}
+
+	log.Printf("[executor] command OK: %s → %s", command, string(output))
+	return nil
+}
+
+// PutFile copies a local file to the PMTA server via SSH.
+func (b *SSHBackend) PutFile(_ context.Context, localPath, remotePath string) error {
+	if b.host == "" {
+		log.Printf("[executor] dry-run: scp %s -> %s", localPath, remotePath)
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	client, err := b.ensureSSH()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("read local file: %w", err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// Write via stdin to a temp file, then move atomically
+	tmpPath := remotePath + ".tmp"
+	cmd := fmt.Sprintf("sudo tee %s > /dev/null && sudo mv %s %s", tmpPath, tmpPath, remotePath)
+	session.Stdin = bytes.NewReader(data)
+	if err := session.Run(cmd); err != nil {
+		return fmt.Errorf("scp %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// Close shuts down the SSH connection.
+func (b *SSHBackend) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		b.client.Close()
+		b.client = nil
+	}
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutor_FakePMTAState(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakePMTA()
+	e := NewExecutorWithBackend(fake)
+
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "deprioritize_ip", TargetValue: "10.0.0.1"}))
+	assert.Equal(t, QueueModeBackoff, fake.QueueMode("10.0.0.1/gmail-pool"))
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "reprioritize_ip", TargetValue: "10.0.0.1"}))
+	assert.Equal(t, QueueModeNormal, fake.QueueMode("10.0.0.1/gmail-pool"))
+
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPYahoo, ActionTaken: "disable_source_ip", TargetValue: "10.0.0.2"}))
+	assert.True(t, fake.SourceDisabled("10.0.0.2", "yahoo/*"))
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPYahoo, ActionTaken: "enable_source_ip", TargetValue: "10.0.0.2"}))
+	assert.False(t, fake.SourceDisabled("10.0.0.2", "yahoo/*"))
+
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "emergency_halt"}))
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPYahoo, ActionTaken: "pause_isp_queues"}))
+	assert.Equal(t, []string{"*/gmail-pool", "*/yahoo-pool"}, fake.PausedQueues())
+	assert.True(t, fake.SourceDisabled("*", "*/gmail-pool"))
+
+	require.NoError(t, e.ResumeISP(ctx, ISPGmail))
+	assert.Equal(t, []string{"*/yahoo-pool"}, fake.PausedQueues())
+	assert.False(t, fake.SourceDisabled("*", "*/gmail-pool"))
+	require.NoError(t, e.ResumeAll(ctx))
+	assert.Empty(t, fake.PausedQueues())
+
+	// Reloads inside the minimum gap are deferred.
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "advance_warmup_day"}))
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "snap_to_stable_rate"}))
+	assert.Equal(t, 1, fake.Reloads())
+
+	fake.FailNext(errors.New("boom"))
+	assert.Error(t, e.Execute(ctx, Decision{ISP: ISPApple, ActionTaken: "pause_isp_queues"}))
+	assert.False(t, fake.QueuePaused("*/apple-pool"))
+
+	local := filepath.Join(t.TempDir(), "global_suppression.txt")
+	require.NoError(t, os.WriteFile(local, []byte("a@example.com\n"), 0o644))
+	require.NoError(t, e.SCPFile(local, "/etc/pmta/suppressions/global_suppression.txt"))
+	data, ok := fake.File("/etc/pmta/suppressions/global_suppression.txt")
+	require.True(t, ok)
+	assert.Equal(t, "a@example.com\n", string(data))
+}
+
+func TestHTTPBackend_Commands(t *testing.T) {
+	type call struct{ path, query string }
+	var calls []call
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin/secret", user+"/"+pass)
+		require.NoError(t, r.ParseForm())
+		calls = append(calls, call{r.URL.Path, r.PostForm.Encode()})
+		if r.PostForm.Get("queue") == "*/broken-pool" {
+			http.Error(w, "no such queue", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	e := NewExecutorWithBackend(NewHTTPBackend(HTTPBackendConfig{BaseURL: srv.URL + "/", User: "admin", Password: "secret"}))
+	ctx := context.Background()
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "emergency_halt"}))
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "reduce_rate"}))
+	require.NoError(t, e.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "advance_warmup_day"}))
+
+	assert.Equal(t, []call{
+		{"/pause/queue", "queue=%2A%2Fgmail-pool"},
+		{"/disable/source", "queue=%2A%2Fgmail-pool&source=%2A"},
+		{"/set/queue", "mode=backoff&queue=%2A%2Fgmail-pool"},
+		{"/reload", ""},
+	}, calls)
+
+	err := e.Execute(ctx, Decision{ISP: "broken", ActionTaken: "pause_isp_queues"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404: no such queue")
+	assert.ErrorIs(t, e.SCPFile("/tmp/x", "/etc/pmta/x"), ErrFileTransferUnsupported)
+}
+
+func TestOrchestrator_DecisionsReachFakePMTA(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakePMTA()
+	factory := NewAgentFactory(nil, "org-1", nil, nil, nil)
+	factory.InitializeWithConfigs(DefaultISPConfigs())
+	store := NewMemDecisionStore()
+	o := NewOrchestrator(store, "org-1", factory, nil, nil, NewExecutorWithBackend(fake), nil, nil, nil)
+
+	at := time.Now()
+	o.processDecision(ctx, Decision{ISP: ISPYahoo, AgentType: AgentEmergency, ActionTaken: "pause_isp_queues",
+		TargetType: "isp", TargetValue: "yahoo", Result: "pending", CreatedAt: at})
+	assert.True(t, fake.QueuePaused("*/yahoo-pool"))
+	require.Len(t, o.PendingReverts(), 1, "pause expires after an hour")
+
+	// Once the pause expires the reverter resumes the queues.
+	o.evaluateReverts(ctx, SignalSnapshot{ISP: ISPYahoo, Timestamp: at.Add(61 * time.Minute)})
+	assert.False(t, fake.QueuePaused("*/yahoo-pool"))
+	assert.Equal(t, []string{"pause queue */yahoo-pool", "resume queue */yahoo-pool"}, fake.Ops())
+
+	// Shadow agents never touch PMTA.
+	require.NoError(t, o.SetAgentShadow(ctx, ISPGmail, AgentEmergency, true))
+	o.processDecision(ctx, Decision{ISP: ISPGmail, AgentType: AgentEmergency, ActionTaken: "emergency_halt",
+		TargetValue: "gmail", Result: "pending", CreatedAt: at})
+	assert.Empty(t, fake.PausedQueues())
+	assert.Len(t, store.Decisions(), 3)
+}
@@ -0,0 +1,553 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Node transports.
+const (
+	NodeTransportSSH  = "ssh"
+	NodeTransportHTTP = "http"
+)
+
+// PMTANode is one PowerMTA server in the fleet with the source IPs and
+// virtual-MTA pools it carries.
+type PMTANode struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	Name           string    `json:"name"`
+	Transport      string    `json:"transport"` // ssh or http
+	Host           string    `json:"host"`      // SSH host
+	SSHPort        int       `json:"ssh_port"`
+	MgmtURL        string    `json:"mgmt_url"` // management API, e.g. https://pmta1:19000
+	IPs            []string  `json:"ips"`
+	Pools          []string  `json:"pools"` // e.g. gmail-pool; empty carries every pool
+	Enabled        bool      `json:"enabled"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// CarriesISP reports whether the node sends traffic for isp.
+func (n PMTANode) CarriesISP(isp ISP) bool {
+	if len(n.Pools) == 0 {
+		return true
+	}
+	pool := PoolNameForISP(isp)
+	for _, p := range n.Pools {
+		if p == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// HasIP reports whether ip is bound on the node.
+func (n PMTANode) HasIP(ip string) bool {
+	for _, v := range n.IPs {
+		if v == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the fields needed to reach the node.
+func (n PMTANode) Validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return fmt.Errorf("node name is required")
+	}
+	switch n.Transport {
+	case NodeTransportSSH:
+		if n.Host == "" {
+			return fmt.Errorf("node %s: host is required for ssh", n.Name)
+		}
+	case NodeTransportHTTP:
+		if n.MgmtURL == "" {
+			return fmt.Errorf("node %s: mgmt_url is required for http", n.Name)
+		}
+	default:
+		return fmt.Errorf("node %s: unknown transport %q", n.Name, n.Transport)
+	}
+	return nil
+}
+
+// NodeResult is the outcome of one decision on one node.
+type NodeResult struct {
+	DecisionID string    `json:"decision_id,omitempty"`
+	Node       string    `json:"node"`
+	Action     string    `json:"action"`
+	Result     string    `json:"result"` // applied or failed
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// FleetCredentials are shared by every node; only addresses live in the
+// database.
+type FleetCredentials struct {
+	SSHUser      string
+	SSHKey       string
+	MgmtUser     string
+	MgmtPassword string
+	MgmtAPIKey   string
+}
+
+// NodeBackends returns a constructor building each node's backend from its
+// transport.
+func NodeBackends(creds FleetCredentials) func(PMTANode) PMTABackend {
+	return func(n PMTANode) PMTABackend {
+		if n.Transport == NodeTransportHTTP {
+			return NewHTTPBackend(HTTPBackendConfig{
+				BaseURL:  n.MgmtURL,
+				User:     creds.MgmtUser,
+				Password: creds.MgmtPassword,
+				APIKey:   creds.MgmtAPIKey,
+			})
+		}
+		port := n.SSHPort
+		if port == 0 {
+			port = 22
+		}
+		return NewSSHBackend(n.Host, port, creds.SSHUser, creds.SSHKey)
+	}
+}
+
+type fleetMember struct {
+	node PMTANode
+	exec *Executor
+}
+
+// Fleet fans decisions out to every PMTA node carrying the affected ISP
+// pool, or to the node owning the target IP, and records a NodeResult per
+// node. With no nodes configured it falls back to a single executor.
+type Fleet struct {
+	store      *FleetStore
+	newBackend func(PMTANode) PMTABackend
+	fallback   *Executor
+
+	mu      sync.RWMutex
+	members []*fleetMember
+	recent  []NodeResult
+	loopCtx context.Context
+}
+
+// maxRecentNodeResults caps the in-memory result history.
+const maxRecentNodeResults = 500
+
+// ErrPartialApply is wrapped by fleet errors when an action was applied on
+// some target nodes and failed on others. The node results say which.
+var ErrPartialApply = errors.New("applied on some PMTA nodes only")
+
+// NewFleet creates an empty fleet. store may be nil.
+func NewFleet(store *FleetStore, newBackend func(PMTANode) PMTABackend) *Fleet {
+	return &Fleet{store: store, newBackend: newBackend}
+}
+
+// SetFallback sets the executor used while no nodes are configured.
+func (f *Fleet) SetFallback(e *Executor) {
+	f.fallback = e
+}
+
+// Load replaces the fleet with the enabled nodes in the store.
+func (f *Fleet) Load(ctx context.Context) error {
+	if f.store == nil {
+		return nil
+	}
+	nodes, err := f.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	f.SetNodes(nodes)
+	log.Printf("[fleet] loaded %d PMTA nodes", len(f.Nodes()))
+	return nil
+}
+
+// SetNodes replaces the fleet's nodes. Disabled nodes are dropped.
+func (f *Fleet) SetNodes(nodes []PMTANode) {
+	var members []*fleetMember
+	for _, n := range nodes {
+		if !n.Enabled {
+			continue
+		}
+		members = append(members, &fleetMember{node: n, exec: NewExecutorWithBackend(f.newBackend(n))})
+	}
+
+	f.mu.Lock()
+	old := f.members
+	f.members = members
+	if f.loopCtx != nil {
+		for _, m := range members {
+			m.exec.StartReloadLoop(f.loopCtx)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, m := range old {
+		m.exec.Close()
+	}
+}
+
+// Nodes returns the active nodes ordered by name.
+func (f *Fleet) Nodes() []PMTANode {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]PMTANode, 0, len(f.members))
+	for _, m := range f.members {
+		out = append(out, m.node)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// NodeForIP returns the name of the node that owns ip, or "".
+func (f *Fleet) NodeForIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, m := range f.members {
+		if m.node.HasIP(ip) {
+			return m.node.Name
+		}
+	}
+	return ""
+}
+
+// targets picks the nodes a decision applies to. IP-targeted actions go to
+// the node owning the IP; everything else goes to every node carrying the
+// ISP's pool.
+func (f *Fleet) targets(d Decision) []*fleetMember {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if ipTargetedActions[d.ActionTaken] || d.TargetType == "ip" {
+		var out []*fleetMember
+		for _, m := range f.members {
+			if m.node.HasIP(d.TargetValue) {
+				out = append(out, m)
+			}
+		}
+		if len(out) > 0 {
+			return out
+		}
+	}
+	var out []*fleetMember
+	for _, m := range f.members {
+		if d.ISP == "" || m.node.CarriesISP(d.ISP) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Targets returns the nodes a decision would be sent to.
+func (f *Fleet) Targets(d Decision) []PMTANode {
+	var out []PMTANode
+	for _, m := range f.targets(d) {
+		out = append(out, m.node)
+	}
+	return out
+}
+
+func (f *Fleet) empty() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.members) == 0
+}
+
+// Execute applies a decision on every target node in parallel. It fails
+// when any node did not apply it; the error wraps ErrPartialApply when
+// other nodes did.
+func (f *Fleet) Execute(ctx context.Context, d Decision) error {
+	if f.empty() && f.fallback != nil {
+		return f.fallback.Execute(ctx, d)
+	}
+	members := f.targets(d)
+	if len(members) == 0 {
+		return fmt.Errorf("no PMTA node carries %s", PoolNameForISP(d.ISP))
+	}
+	return f.fanOut(ctx, d.ID, d.ActionTaken, members, func(e *Executor) error {
+		return e.Execute(ctx, d)
+	})
+}
+
+// ResumeAll resumes every queue on every node.
+func (f *Fleet) ResumeAll(ctx context.Context) error {
+	if f.empty() && f.fallback != nil {
+		return f.fallback.ResumeAll(ctx)
+	}
+	f.mu.RLock()
+	members := append([]*fleetMember(nil), f.members...)
+	f.mu.RUnlock()
+	return f.fanOut(ctx, "", "resume_all", members, func(e *Executor) error {
+		return e.ResumeAll(ctx)
+	})
+}
+
+// ResumeISP resumes an ISP's queues on every node carrying it.
+func (f *Fleet) ResumeISP(ctx context.Context, isp ISP) error {
+	if f.empty() && f.fallback != nil {
+		return f.fallback.ResumeISP(ctx, isp)
+	}
+	return f.fanOut(ctx, "", "resume_isp", f.targets(Decision{ISP: isp}), func(e *Executor) error {
+		return e.ResumeISP(ctx, isp)
+	})
+}
+
+// SetRate applies a rate controller rate on the node owning ip, or on every
+// node carrying the ISP's pool when ip is empty. PMTA applies a pool-wide
+// rate to each IP queue in the pool, so an ISP-wide rate is split evenly
+// across the IPs of those nodes.
+func (f *Fleet) SetRate(ctx context.Context, isp ISP, ip string, perMinute float64) error {
+	if f.empty() && f.fallback != nil {
+		return f.fallback.SetRate(ctx, isp, ip, perMinute)
+	}
+	d := Decision{ISP: isp}
+	if ip != "" {
+		d.TargetType, d.TargetValue = "ip", ip
+	}
+	members := f.targets(d)
+	if len(members) == 0 {
+		return fmt.Errorf("no PMTA node carries %s", PoolNameForISP(isp))
+	}
+	if ip == "" {
+		queues := 0
+		for _, m := range members {
+			queues += max(1, len(m.node.IPs))
+		}
+		perMinute /= float64(queues)
+	}
+	return f.fanOut(ctx, "", "set_rate", members, func(e *Executor) error {
+		return e.SetRate(ctx, isp, ip, perMinute)
+	})
+}
+
+func (f *Fleet) fanOut(ctx context.Context, decisionID, action string, members []*fleetMember, op func(*Executor) error) error {
+	results := make([]NodeResult, len(members))
+	var wg sync.WaitGroup
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m *fleetMember) {
+			defer wg.Done()
+			start := time.Now()
+			err := op(m.exec)
+			res := NodeResult{
+				DecisionID: decisionID,
+				Node:       m.node.Name,
+				Action:     action,
+				Result:     "applied",
+				DurationMS: time.Since(start).Milliseconds(),
+				ExecutedAt: start,
+			}
+			if err != nil {
+				res.Result, res.Error = "failed", err.Error()
+			}
+			results[i] = res
+		}(i, m)
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Error != "" {
+			log.Printf("[fleet] %s on %s failed: %s", action, r.Node, r.Error)
+			errs = append(errs, fmt.Errorf("%s: %s", r.Node, r.Error))
+		}
+	}
+	f.mu.Lock()
+	f.recent = append(f.recent, results...)
+	if len(f.recent) > maxRecentNodeResults {
+		f.recent = f.recent[len(f.recent)-maxRecentNodeResults:]
+	}
+	f.mu.Unlock()
+	if f.store != nil && decisionID != "" {
+		if err := f.store.RecordResults(ctx, results); err != nil {
+			log.Printf("[fleet] record node results: %v", err)
+		}
+	}
+
+	switch {
+	case len(errs) == 0:
+		return nil
+	case len(errs) < len(results):
+		return fmt.Errorf("%s %w (%d of %d failed): %w", action, ErrPartialApply, len(errs), len(results), errors.Join(errs...))
+	default:
+		return errors.Join(errs...)
+	}
+}
+
+// RecentResults returns up to limit of the latest node results, newest first.
+func (f *Fleet) RecentResults(limit int) []NodeResult {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]NodeResult, 0, len(f.recent))
+	for i := len(f.recent) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		out = append(out, f.recent[i])
+	}
+	return out
+}
+
+// SCPFile copies a file to every node, or to the fallback when the fleet is
+// empty.
+func (f *Fleet) SCPFile(localPath, remotePath string) error {
+	if f.empty() && f.fallback != nil {
+		return f.fallback.SCPFile(localPath, remotePath)
+	}
+	f.mu.RLock()
+	members := append([]*fleetMember(nil), f.members...)
+	f.mu.RUnlock()
+	var errs []error
+	for _, m := range members {
+		if err := m.exec.SCPFile(localPath, remotePath); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.node.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StartReloadLoop runs each node's deferred-reload loop, including nodes
+// added later.
+func (f *Fleet) StartReloadLoop(ctx context.Context) {
+	f.mu.Lock()
+	f.loopCtx = ctx
+	members := append([]*fleetMember(nil), f.members...)
+	f.mu.Unlock()
+	for _, m := range members {
+		m.exec.StartReloadLoop(ctx)
+	}
+	if f.fallback != nil {
+		f.fallback.StartReloadLoop(ctx)
+	}
+}
+
+// FleetStore persists PMTA nodes and per-node decision results.
+type FleetStore struct {
+	db    *sql.DB
+	orgID string
+}
+
+// NewFleetStore creates a store for an organization's nodes.
+func NewFleetStore(db *sql.DB, orgID string) *FleetStore {
+	return &FleetStore{db: db, orgID: orgID}
+}
+
+// List returns all nodes ordered by name.
+func (s *FleetStore) List(ctx context.Context) ([]PMTANode, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, organization_id, name, transport, host, ssh_port, mgmt_url, ips, pools,
+		 enabled, created_at, updated_at
+		 FROM mailing_pmta_nodes WHERE organization_id = $1 ORDER BY name`,
+		s.orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PMTANode
+	for rows.Next() {
+		var n PMTANode
+		if err := rows.Scan(&n.ID, &n.OrganizationID, &n.Name, &n.Transport, &n.Host, &n.SSHPort,
+			&n.MgmtURL, pq.Array(&n.IPs), pq.Array(&n.Pools), &n.Enabled, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// Upsert creates or replaces a node by name.
+func (s *FleetStore) Upsert(ctx context.Context, n PMTANode) (*PMTANode, error) {
+	n.Name = strings.TrimSpace(n.Name)
+	if n.Transport == "" {
+		n.Transport = NodeTransportSSH
+	}
+	if n.SSHPort == 0 {
+		n.SSHPort = 22
+	}
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	if n.IPs == nil {
+		n.IPs = []string{}
+	}
+	if n.Pools == nil {
+		n.Pools = []string{}
+	}
+	n.OrganizationID = s.orgID
+
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO mailing_pmta_nodes (organization_id, name, transport, host, ssh_port, mgmt_url, ips, pools, enabled)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 ON CONFLICT (organization_id, name) DO UPDATE
+		 SET transport = EXCLUDED.transport, host = EXCLUDED.host, ssh_port = EXCLUDED.ssh_port,
+		     mgmt_url = EXCLUDED.mgmt_url, ips = EXCLUDED.ips, pools = EXCLUDED.pools,
+		     enabled = EXCLUDED.enabled, updated_at = NOW()
+		 RETURNING id, created_at, updated_at`,
+		s.orgID, n.Name, n.Transport, n.Host, n.SSHPort, n.MgmtURL, pq.Array(n.IPs), pq.Array(n.Pools), n.Enabled,
+	).Scan(&n.ID, &n.CreatedAt, &n.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// Delete removes a node by name.
+func (s *FleetStore) Delete(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM mailing_pmta_nodes WHERE organization_id = $1 AND name = $2`, s.orgID, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecordResults stores per-node outcomes of a decision.
+func (s *FleetStore) RecordResults(ctx context.Context, results []NodeResult) error {
+	for _, r := range results {
+		_, err := s.db.ExecContext(ctx,
+			`INSERT INTO mailing_engine_decision_node_results
+			 (organization_id, decision_id, node_name, action, result, error, duration_ms, executed_at)
+			 VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)`,
+			s.orgID, r.DecisionID, r.Node, r.Action, r.Result, r.Error, r.DurationMS, r.ExecutedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResultsForDecision returns the node results of one decision.
+func (s *FleetStore) ResultsForDecision(ctx context.Context, decisionID string) ([]NodeResult, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT decision_id, node_name, action, result, COALESCE(error, ''), duration_ms, executed_at
+		 FROM mailing_engine_decision_node_results
+		 WHERE organization_id = $1 AND decision_id = $2 ORDER BY node_name`,
+		s.orgID, decisionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []NodeResult{}
+	for rows.Next() {
+		var r NodeResult
+		if err := rows.Scan(&r.DecisionID, &r.Node, &r.Action, &r.Result, &r.Error, &r.DurationMS, &r.ExecutedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
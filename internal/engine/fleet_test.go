@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeFleet(t *testing.T, store *FleetStore, nodes ...PMTANode) (*Fleet, map[string]*FakePMTA) {
+	t.Helper()
+	fakes := make(map[string]*FakePMTA)
+	for _, n := range nodes {
+		fakes[n.Name] = NewFakePMTA()
+	}
+	f := NewFleet(store, func(n PMTANode) PMTABackend { return fakes[n.Name] })
+	f.SetNodes(nodes)
+	return f, fakes
+}
+
+func TestFleet_Targets(t *testing.T) {
+	f, _ := newFakeFleet(t, nil,
+		PMTANode{Name: "pmta1", Enabled: true, IPs: []string{"10.0.0.1"}, Pools: []string{"gmail-pool"}},
+		PMTANode{Name: "pmta2", Enabled: true, IPs: []string{"10.0.0.2"}},
+		PMTANode{Name: "pmta3", Enabled: false, IPs: []string{"10.0.0.3"}},
+	)
+
+	names := func(nodes []PMTANode) []string {
+		var out []string
+		for _, n := range nodes {
+			out = append(out, n.Name)
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"pmta1", "pmta2"}, names(f.Nodes()), "disabled nodes are dropped")
+	assert.Equal(t, []string{"pmta1", "pmta2"}, names(f.Targets(Decision{ISP: ISPGmail, ActionTaken: "pause_isp_queues"})))
+	assert.Equal(t, []string{"pmta2"}, names(f.Targets(Decision{ISP: ISPYahoo, ActionTaken: "pause_isp_queues"})))
+	assert.Equal(t, []string{"pmta2"}, names(f.Targets(Decision{ISP: ISPGmail, ActionTaken: "disable_source_ip", TargetValue: "10.0.0.2"})))
+	// An unknown IP falls back to the pool's nodes.
+	assert.Equal(t, []string{"pmta1", "pmta2"}, names(f.Targets(Decision{ISP: ISPGmail, ActionTaken: "disable_source_ip", TargetValue: "10.9.9.9"})))
+
+	assert.Equal(t, "pmta2", f.NodeForIP("10.0.0.2"))
+	assert.Equal(t, "", f.NodeForIP("10.0.0.3"))
+}
+
+func TestFleet_FanOutAndPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	f, fakes := newFakeFleet(t, nil,
+		PMTANode{Name: "pmta1", Enabled: true},
+		PMTANode{Name: "pmta2", Enabled: true},
+	)
+
+	require.NoError(t, f.Execute(ctx, Decision{ID: "d1", ISP: ISPYahoo, ActionTaken: "pause_isp_queues"}))
+	assert.True(t, fakes["pmta1"].QueuePaused("*/yahoo-pool"))
+	assert.True(t, fakes["pmta2"].QueuePaused("*/yahoo-pool"))
+
+	// One node failing is reported as a partial failure.
+	fakes["pmta2"].FailNext(errors.New("connection refused"))
+	err := f.Execute(ctx, Decision{ID: "d2", ISP: ISPGmail, ActionTaken: "pause_isp_queues"})
+	require.ErrorIs(t, err, ErrPartialApply)
+	assert.Contains(t, err.Error(), "pmta2: connection refused")
+	assert.True(t, fakes["pmta1"].QueuePaused("*/gmail-pool"))
+	assert.False(t, fakes["pmta2"].QueuePaused("*/gmail-pool"))
+
+	recent := f.RecentResults(2)
+	require.Len(t, recent, 2)
+	byNode := map[string]NodeResult{}
+	for _, r := range recent {
+		byNode[r.Node] = r
+	}
+	assert.Equal(t, "applied", byNode["pmta1"].Result)
+	assert.Equal(t, "failed", byNode["pmta2"].Result)
+	assert.Equal(t, "connection refused", byNode["pmta2"].Error)
+	assert.Equal(t, "d2", byNode["pmta2"].DecisionID)
+
+	// Every node failing fails the decision.
+	fakes["pmta1"].FailNext(errors.New("down"))
+	fakes["pmta2"].FailNext(errors.New("down"))
+	err = f.Execute(ctx, Decision{ID: "d3", ISP: ISPApple, ActionTaken: "pause_isp_queues"})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrPartialApply)
+	assert.Contains(t, err.Error(), "pmta1: down")
+
+	require.NoError(t, f.ResumeAll(ctx))
+	assert.Empty(t, fakes["pmta1"].PausedQueues())
+	assert.Empty(t, fakes["pmta2"].PausedQueues())
+}
+
+func TestFleet_FallbackWhenEmpty(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakePMTA()
+	f := NewFleet(nil, nil)
+	assert.Error(t, f.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "pause_isp_queues"}))
+
+	f.SetFallback(NewExecutorWithBackend(fake))
+	require.NoError(t, f.Execute(ctx, Decision{ISP: ISPGmail, ActionTaken: "pause_isp_queues"}))
+	assert.True(t, fake.QueuePaused("*/gmail-pool"))
+	assert.Empty(t, f.RecentResults(0))
+}
+
+func TestFleet_SetRate(t *testing.T) {
+	ctx := context.Background()
+	f, fakes := newFakeFleet(t, nil,
+		PMTANode{Name: "pmta1", Enabled: true, IPs: []string{"10.0.0.1"}, Pools: []string{"gmail-pool"}},
+		PMTANode{Name: "pmta2", Enabled: true, IPs: []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}, Pools: []string{"gmail-pool"}},
+	)
+
+	// The ISP-wide rate is split across the IP queues of every node
+	// carrying the pool.
+	require.NoError(t, f.SetRate(ctx, ISPGmail, "", 100))
+	assert.Equal(t, 1500, fakes["pmta1"].QueueRate("*/gmail-pool"))
+	assert.Equal(t, 1500, fakes["pmta2"].QueueRate("*/gmail-pool"))
+
+	// A per-IP rate only goes to the node owning the IP.
+	require.NoError(t, f.SetRate(ctx, ISPGmail, "10.0.0.2", 2.5))
+	assert.Equal(t, 150, fakes["pmta2"].QueueRate("10.0.0.2/gmail-pool"))
+	assert.Zero(t, fakes["pmta1"].QueueRate("10.0.0.2/gmail-pool"))
+
+	// A rate that rounds to zero still lets mail through.
+	require.NoError(t, f.SetRate(ctx, ISPGmail, "10.0.0.1", 0.001))
+	assert.Equal(t, 1, fakes["pmta1"].QueueRate("10.0.0.1/gmail-pool"))
+}
+
+func TestFleetStore_UpsertAndRecordResults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	store := NewFleetStore(db, "org-1")
+	ctx := context.Background()
+
+	_, err = store.Upsert(ctx, PMTANode{Name: "pmta1", Transport: NodeTransportHTTP})
+	assert.Error(t, err, "http nodes need a management URL")
+
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO mailing_pmta_nodes").
+		WithArgs("org-1", "pmta1", "ssh", "pmta1.example.com", 22, "", sqlmock.AnyArg(), sqlmock.AnyArg(), true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow("n1", now, now))
+	n, err := store.Upsert(ctx, PMTANode{Name: " pmta1 ", Host: "pmta1.example.com", Enabled: true})
+	require.NoError(t, err)
+	assert.Equal(t, "n1", n.ID)
+	assert.Equal(t, "pmta1", n.Name)
+
+	f, fakes := newFakeFleet(t, store, PMTANode{Name: "pmta1", Enabled: true})
+	fakes["pmta1"].FailNext(errors.New("timeout"))
+	mock.ExpectExec("INSERT INTO mailing_engine_decision_node_results").
+		WithArgs("org-1", "d1", "pmta1", "pause_isp_queues", "failed", "timeout", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.Error(t, f.Execute(ctx, Decision{ID: "d1", ISP: ISPGmail, ActionTaken: "pause_isp_queues"}))
+
+	mock.ExpectExec("DELETE FROM mailing_pmta_nodes").
+		WithArgs("org-1", "missing").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, store.Delete(ctx, "missing"), sql.ErrNoRows)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
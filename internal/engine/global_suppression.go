@@ -37,7 +37,7 @@ type GlobalSuppressionHub struct {
 	globalFilePath string
 	remoteDir      string // Path on the PMTA server where suppression files live
 
-	executor *Executor // Optional: if set, SCP files to PMTA after rebuild
+	executor FileSyncer // Optional: if set, SCP files to PMTA after rebuild
 
 	subMu       sync.RWMutex
 	subscribers map[string]chan SuppressionEvent
@@ -45,8 +45,14 @@ type GlobalSuppressionHub struct {
 	stopCh chan struct{}
 }
 
+// FileSyncer copies files to PMTA. *Executor syncs one host; *Fleet syncs
+// every node.
+type FileSyncer interface {
+	SCPFile(localPath, remotePath string) error
+}
+
 // SetExecutor connects the hub to the PMTA executor for remote file sync.
-func (h *GlobalSuppressionHub) SetExecutor(e *Executor, remoteDir string) {
+func (h *GlobalSuppressionHub) SetExecutor(e FileSyncer, remoteDir string) {
 	h.executor = e
 	h.remoteDir = remoteDir
 }
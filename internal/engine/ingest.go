@@ -3,16 +3,15 @@ package engine
 import (
 	"context"
 	"crypto/tls"
-	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
 )
 
 // campaignRecorder abstracts campaign event recording for testability.
@@ -20,16 +19,13 @@ type campaignRecorder interface {
 	RecordEvent(e CampaignEvent)
 }
 
-// Ingestor receives PMTA accounting records via webhook and polls PMTA
-// status APIs. It classifies each record by ISP and fans out to the
-// SignalProcessor, agent clusters, CampaignEventTracker, and
-// GlobalSuppressionHub.
+// Ingestor receives PMTA accounting records from the espevents PMTA adapter
+// and polls PMTA status APIs. It classifies each record by ISP and fans out
+// to the SignalProcessor, agent clusters and CampaignEventTracker.
 type Ingestor struct {
 	registry  *ISPRegistry
 	processor *SignalProcessor
 	tracker   campaignRecorder
-	globalHub *GlobalSuppressionHub
-	db        *sql.DB
 
 	// Record listeners (agents subscribe to their ISP's records)
 	listeners map[ISP][]chan<- AccountingRecord
@@ -41,6 +37,21 @@ type Ingestor struct {
 	pmtaPassword string
 	pollInterval time.Duration
 	httpClient   *http.Client
+
+	// Multi-node fleet: every node with a management URL is polled and
+	// records are tagged with their node.
+	fleet      *Fleet
+	statusMu   sync.Mutex
+	nodeStatus map[string]NodeStatus
+}
+
+// NodeStatus is the result of the last status poll of a PMTA node.
+type NodeStatus struct {
+	Node       string    `json:"node"`
+	URL        string    `json:"url"`
+	OK         bool      `json:"ok"`
+	Error      string    `json:"error,omitempty"`
+	LastPollAt time.Time `json:"last_poll_at"`
 }
 
 // IngestorConfig holds configuration for the ingestor.
@@ -57,16 +68,22 @@ func (ing *Ingestor) SetCampaignTracker(t *CampaignEventTracker) {
 	ing.tracker = t
 }
 
-// SetGlobalSuppressionHub attaches the global suppression hub so that
-// every negative signal (bounce, complaint) is auto-suppressed globally.
-func (ing *Ingestor) SetGlobalSuppressionHub(hub *GlobalSuppressionHub) {
-	ing.globalHub = hub
+// SetFleet makes the ingestor poll every fleet node and attribute
+// untagged records to the node owning their source IP.
+func (ing *Ingestor) SetFleet(f *Fleet) {
+	ing.fleet = f
 }
 
-// SetDB attaches a database handle for persisting accounting events to
-// mailing_tracking_events and updating mailing_campaigns counters.
-func (ing *Ingestor) SetDB(db *sql.DB) {
-	ing.db = db
+// NodeStatuses returns the last poll result of each node, ordered by name.
+func (ing *Ingestor) NodeStatuses() []NodeStatus {
+	ing.statusMu.Lock()
+	defer ing.statusMu.Unlock()
+	out := make([]NodeStatus, 0, len(ing.nodeStatus))
+	for _, st := range ing.nodeStatus {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
 }
 
 // NewIngestor creates a new data ingestor.
@@ -84,6 +101,7 @@ func NewIngestor(registry *ISPRegistry, processor *SignalProcessor, cfg Ingestor
 		pmtaUser:     cfg.PMTAUser,
 		pmtaPassword: cfg.PMTAPassword,
 		pollInterval: interval,
+		nodeStatus:   make(map[string]NodeStatus),
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
@@ -98,73 +116,35 @@ func (ing *Ingestor) SubscribeISP(isp ISP, ch chan<- AccountingRecord) {
 	ing.listeners[isp] = append(ing.listeners[isp], ch)
 }
 
-// HandleWebhook is the HTTP handler for PMTA accounting webhook POSTs.
-// Expects JSON array of AccountingRecord objects.
-func (ing *Ingestor) HandleWebhook(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// IngestSignals feeds a record to the ISP signal processor, ISP listeners
+// and the campaign tracker without suppressing or persisting it. The
+// espevents pipeline calls it for each PMTA record whose event it applied
+// for the first time, so redelivered batches are not counted twice.
+func (ing *Ingestor) IngestSignals(rec AccountingRecord) {
+	if rec.Node == "" && ing.fleet != nil {
+		rec.Node = ing.fleet.NodeForIP(rec.SourceIP)
 	}
+	ing.ingestSignals(rec, ing.classifyRecord(rec))
+}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
-	if err != nil {
-		http.Error(w, "read error", http.StatusBadRequest)
+// ingestSignals does the ISP-specific processing, only for classified
+// domains.
+func (ing *Ingestor) ingestSignals(rec AccountingRecord, isp ISP) {
+	if isp == "" {
 		return
 	}
-	defer r.Body.Close()
+	ing.processor.Ingest(isp, rec)
 
-	var records []AccountingRecord
-	if err := json.Unmarshal(body, &records); err != nil {
-		// Try single record
-		var single AccountingRecord
-		if err2 := json.Unmarshal(body, &single); err2 == nil {
-			records = []AccountingRecord{single}
-		} else {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
-			return
+	for _, ch := range ing.listeners[isp] {
+		select {
+		case ch <- rec:
+		default:
 		}
 	}
 
-	processed := 0
-	for _, rec := range records {
-		isp := ing.classifyRecord(rec)
-
-		// Global suppression and DB persistence fire for ALL records,
-		// even if the domain doesn't map to a known ISP.
-		if ing.globalHub != nil {
-			ing.routeToGlobalSuppression(rec, isp)
-		}
-		if ing.db != nil {
-			ing.persistToDB(rec, isp)
-		}
-
-		if isp == "" {
-			processed++
-			continue
-		}
-
-		// ISP-specific processing only for classified domains
-		ing.processor.Ingest(isp, rec)
-
-		for _, ch := range ing.listeners[isp] {
-			select {
-			case ch <- rec:
-			default:
-			}
-		}
-
-		if ing.tracker != nil && rec.JobID != "" {
-			ing.routeToCampaignTracker(rec, isp)
-		}
-
-		processed++
+	if ing.tracker != nil && rec.JobID != "" {
+		ing.routeToCampaignTracker(rec, isp)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"received":  len(records),
-		"processed": processed,
-	})
 }
 
 func (ing *Ingestor) routeToCampaignTracker(rec AccountingRecord, isp ISP) {
@@ -173,7 +153,7 @@ func (ing *Ingestor) routeToCampaignTracker(rec AccountingRecord, isp ISP) {
 	case "d":
 		eventType = "delivered"
 	case "b":
-		eventType = ClassifyBounce(rec.BounceCat)
+		eventType = bounceEventType(classifyBounce(rec))
 	case "t", "tq":
 		eventType = "deferred"
 	case "f":
@@ -195,222 +175,11 @@ func (ing *Ingestor) routeToCampaignTracker(rec AccountingRecord, isp ISP) {
 	})
 }
 
-// routeToGlobalSuppression suppresses permanent failures and recipient-quality
-// signals: hard bounces, quota-issues (Yahoo treats full-mailbox as a list
-// hygiene signal), and FBL complaints. Transient issues like rate-limiting,
-// message-expired, and deferrals are NOT suppressed.
-func (ing *Ingestor) routeToGlobalSuppression(rec AccountingRecord, isp ISP) {
-	if rec.Recipient == "" {
-		return
-	}
-
-	var reason, source string
-	switch rec.Type {
-	case "b": // bounce
-		switch rec.BounceCat {
-		case "bad-mailbox", "bad-domain", "inactive-mailbox",
-			"no-answer-from-host", "routing-errors", "quota-issues":
-			reason = "hard_bounce"
-			source = "pmta_bounce"
-		default:
-			return
-		}
-	case "f": // FBL complaint
-		reason = "spam_complaint"
-		source = "pmta_fbl"
-	default:
-		return
-	}
-
-	ctx := context.Background()
-	ing.globalHub.Suppress(ctx, rec.Recipient, reason, source, string(isp), rec.DSNStatus, rec.DSNDiag, rec.SourceIP, rec.JobID)
-}
-
-// persistToDB writes PMTA accounting events to mailing_tracking_events and
-// updates mailing_campaigns counters. Since PMTA v5.0r7 doesn't support
-// process-x-job, the jobId may be the envelope sender domain rather than
-// the campaign UUID. We resolve the campaign by looking up the most recent
-// 'sent' event for the recipient email.
-func (ing *Ingestor) persistToDB(rec AccountingRecord, isp ISP) {
-	if rec.Recipient == "" {
-		return
-	}
-
-	var eventType string
-	switch rec.Type {
-	case "d":
-		eventType = "delivered"
-	case "b":
-		eventType = "bounced"
-	case "f":
-		eventType = "complained"
-	case "t", "tq":
-		eventType = "deferred"
-	default:
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Resolve campaign_id: try jobId first (if it's a UUID), else look up by recipient
-	recipientEmail := strings.ToLower(strings.TrimSpace(rec.Recipient))
-	campaignID := rec.JobID
-	if !isUUID(campaignID) {
-		var resolved sql.NullString
-		ing.db.QueryRowContext(ctx, `
-			SELECT campaign_id::text FROM mailing_message_log
-			WHERE LOWER(email) = $1
-			ORDER BY sent_at DESC LIMIT 1
-		`, recipientEmail).Scan(&resolved)
-		if resolved.Valid {
-			campaignID = resolved.String
-		} else {
-			log.Printf("[ingest-db] no campaign found for recipient %s, skipping DB persist", rec.Recipient[:min(20, len(rec.Recipient))])
-			return
-		}
-	}
-
-	campUUID, err := uuid.Parse(campaignID)
-	if err != nil {
-		return
-	}
-
-	// Look up subscriber_id and organization_id from campaign list membership
-	var subscriberID, orgID sql.NullString
-	ing.db.QueryRowContext(ctx, `
-		SELECT s.id::text, c.organization_id::text
-		FROM mailing_subscribers s
-		JOIN mailing_campaigns c ON c.list_id = s.list_id AND c.id = $1
-		WHERE LOWER(s.email) = $2
-		LIMIT 1
-	`, campUUID, recipientEmail).Scan(&subscriberID, &orgID)
-
-	// If subscriber lookup didn't yield org_id, get it directly from the campaign
-	if !orgID.Valid {
-		ing.db.QueryRowContext(ctx, `
-			SELECT organization_id::text FROM mailing_campaigns WHERE id = $1
-		`, campUUID).Scan(&orgID)
-	}
-
-	if !orgID.Valid {
-		log.Printf("[ingest-db] no organization_id for campaign %s, skipping", campUUID)
-		return
-	}
-
-	eventID := uuid.New()
-	var subIDPtr *uuid.UUID
-	orgUUID, _ := uuid.Parse(orgID.String)
-	orgIDPtr := &orgUUID
-
-	if subscriberID.Valid {
-		if u, e := uuid.Parse(subscriberID.String); e == nil {
-			subIDPtr = &u
-		}
-	}
-
-	// Extract sending domain from the envelope-sender address
-	sendingDomain := ""
-	if atIdx := strings.LastIndex(rec.Sender, "@"); atIdx >= 0 {
-		sendingDomain = strings.ToLower(rec.Sender[atIdx+1:])
-	}
-
-	recipientDomain := ""
-	if parts := strings.SplitN(recipientEmail, "@", 2); len(parts) == 2 {
-		recipientDomain = strings.ToLower(parts[1])
-	}
-
-	sendingIP := rec.SourceIP
-	if sendingIP == "" && rec.VMTA != "" {
-		sendingIP = rec.VMTA
-	}
-
-	_, err = ing.db.ExecContext(ctx, `
-		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, bounce_type, bounce_reason, event_at, sending_domain, sending_ip, recipient_domain)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8, $9, $10)
-		ON CONFLICT DO NOTHING
-	`, eventID, orgIDPtr, campUUID, subIDPtr, eventType, rec.BounceCat, rec.DSNStatus, sendingDomain, sendingIP, recipientDomain)
-	if err != nil {
-		log.Printf("[ingest-db] tracking event insert error: %v", err)
-	}
-
-	// Update campaign aggregate counters.
-	switch eventType {
-	case "delivered":
-		ing.db.ExecContext(ctx, `UPDATE mailing_campaigns SET delivered_count = COALESCE(delivered_count, 0) + 1, updated_at = NOW() WHERE id = $1`, campUUID)
-	case "bounced":
-		ing.db.ExecContext(ctx, `UPDATE mailing_campaigns SET bounce_count = COALESCE(bounce_count, 0) + 1, updated_at = NOW() WHERE id = $1`, campUUID)
-		if isHardBounceCategory(rec.BounceCat) {
-			ing.db.ExecContext(ctx, `UPDATE mailing_campaigns SET hard_bounce_count = COALESCE(hard_bounce_count, 0) + 1 WHERE id = $1`, campUUID)
-		} else {
-			ing.db.ExecContext(ctx, `UPDATE mailing_campaigns SET soft_bounce_count = COALESCE(soft_bounce_count, 0) + 1 WHERE id = $1`, campUUID)
-		}
-	case "complained":
-		ing.db.ExecContext(ctx, `UPDATE mailing_campaigns SET complaint_count = COALESCE(complaint_count, 0) + 1, updated_at = NOW() WHERE id = $1`, campUUID)
-	case "deferred":
-		// Deferrals live in mailing_tracking_events only; no campaign counter.
-	}
-
-	// Update IP address counters when we can resolve the source IP.
-	if sendingIP != "" && (eventType == "delivered" || eventType == "bounced") {
-		switch eventType {
-		case "delivered":
-			ing.db.ExecContext(ctx, `
-				UPDATE mailing_ip_addresses SET total_delivered = COALESCE(total_delivered, 0) + 1, updated_at = NOW()
-				WHERE ip_address = $1::inet`, sendingIP)
-		case "bounced":
-			ing.db.ExecContext(ctx, `
-				UPDATE mailing_ip_addresses SET total_bounced = COALESCE(total_bounced, 0) + 1, updated_at = NOW()
-				WHERE ip_address = $1::inet`, sendingIP)
-		}
-	}
-
-	// Enrich inbox profiles with delivery/bounce data.
-	if eventType == "delivered" || eventType == "bounced" {
-		domain := ""
-		if parts := strings.SplitN(recipientEmail, "@", 2); len(parts) == 2 {
-			domain = parts[1]
-		}
-		if eventType == "delivered" {
-			ing.db.ExecContext(ctx, `
-				INSERT INTO mailing_inbox_profiles (id, email, domain, total_sent, last_sent_at, created_at, updated_at)
-				VALUES (gen_random_uuid(), $1, $2, 1, NOW(), NOW(), NOW())
-				ON CONFLICT (email) DO UPDATE SET total_sent = mailing_inbox_profiles.total_sent + 1, last_sent_at = NOW(), updated_at = NOW()
-			`, recipientEmail, domain)
-		} else {
-			ing.db.ExecContext(ctx, `
-				INSERT INTO mailing_inbox_profiles (id, email, domain, total_bounces, last_bounce_at, created_at, updated_at)
-				VALUES (gen_random_uuid(), $1, $2, 1, NOW(), NOW(), NOW())
-				ON CONFLICT (email) DO UPDATE SET total_bounces = COALESCE(mailing_inbox_profiles.total_bounces, 0) + 1, last_bounce_at = NOW(), updated_at = NOW()
-			`, recipientEmail, domain)
-		}
-	}
-}
-
-// isHardBounceCategory returns true for PMTA bounce categories that indicate
-// a permanent delivery failure. Aligns with routeToGlobalSuppression.
-func isHardBounceCategory(cat string) bool {
-	switch cat {
-	case "bad-mailbox", "bad-domain", "inactive-mailbox", "no-answer-from-host", "routing-errors":
-		return true
-	default:
-		return false
-	}
-}
-
-func isUUID(s string) bool {
-	_, err := uuid.Parse(s)
-	return err == nil
-}
-
-func sanitizeJSON(s string) string {
-	s = strings.ReplaceAll(s, `"`, `\"`)
-	s = strings.ReplaceAll(s, "\n", " ")
-	s = strings.ReplaceAll(s, "\r", "")
-	if len(s) > 500 {
-		s = s[:500]
-	}
-	return s
+// classifyBounce runs a PMTA bounce record through the shared smtputil
+// classifier. The DSN status and diagnostic take precedence; PMTA's own
+// bounceCat is used when they are inconclusive.
+func classifyBounce(rec AccountingRecord) smtputil.Classification {
+	return smtputil.ClassifyDSN(rec.DSNStatus, rec.DSNDiag, rec.BounceCat)
 }
 
 func (ing *Ingestor) classifyRecord(rec AccountingRecord) ISP {
@@ -426,9 +195,10 @@ func (ing *Ingestor) classifyRecord(rec AccountingRecord) ISP {
 	return ""
 }
 
-// StartPolling begins periodically polling the PMTA management API.
+// StartPolling begins periodically polling the PMTA management API of the
+// configured host and every fleet node.
 func (ing *Ingestor) StartPolling(ctx context.Context) {
-	if ing.pmtaHost == "" {
+	if ing.pmtaHost == "" && ing.fleet == nil {
 		log.Println("[ingest] PMTA polling disabled: no host configured")
 		return
 	}
@@ -442,28 +212,67 @@ func (ing *Ingestor) StartPolling(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				ing.pollPMTAStatus(ctx)
+				ing.pollAllNodes(ctx)
 			}
 		}
 	}()
 }
 
-func (ing *Ingestor) pollPMTAStatus(ctx context.Context) {
+// pollTargets maps node name to management API base URL. The single
+// configured host is polled as "default" when no fleet node covers it.
+func (ing *Ingestor) pollTargets() map[string]string {
+	targets := make(map[string]string)
+	if ing.fleet != nil {
+		for _, n := range ing.fleet.Nodes() {
+			if n.MgmtURL != "" {
+				targets[n.Name] = strings.TrimRight(n.MgmtURL, "/")
+			}
+		}
+	}
+	if len(targets) == 0 && ing.pmtaHost != "" {
+		targets["default"] = fmt.Sprintf("https://%s:%d", ing.pmtaHost, ing.pmtaPort)
+	}
+	return targets
+}
+
+func (ing *Ingestor) pollAllNodes(ctx context.Context) {
+	var wg sync.WaitGroup
+	for name, baseURL := range ing.pollTargets() {
+		wg.Add(1)
+		go func(name, baseURL string) {
+			defer wg.Done()
+			st := NodeStatus{Node: name, URL: baseURL, LastPollAt: time.Now(), OK: true}
+			if err := ing.pollPMTAStatus(ctx, baseURL); err != nil {
+				st.OK, st.Error = false, err.Error()
+				log.Printf("[ingest] PMTA poll %s: %v", name, err)
+			}
+			ing.statusMu.Lock()
+			ing.nodeStatus[name] = st
+			ing.statusMu.Unlock()
+		}(name, baseURL)
+	}
+	wg.Wait()
+}
+
+func (ing *Ingestor) pollPMTAStatus(ctx context.Context, baseURL string) error {
 	endpoints := []string{"status", "queues", "vmtas", "domains"}
 	for _, ep := range endpoints {
-		url := fmt.Sprintf("https://%s:%d/%s?format=json", ing.pmtaHost, ing.pmtaPort, ep)
+		url := fmt.Sprintf("%s/%s?format=json", baseURL, ep)
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
-			continue
+			return err
 		}
 		if ing.pmtaUser != "" || ing.pmtaPassword != "" {
 			req.SetBasicAuth(ing.pmtaUser, ing.pmtaPassword)
 		}
 		resp, err := ing.httpClient.Do(req)
 		if err != nil {
-			log.Printf("[ingest] PMTA poll %s error: %v", ep, err)
-			continue
+			return fmt.Errorf("%s: %w", ep, err)
 		}
 		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %d", ep, resp.StatusCode)
+		}
 	}
+	return nil
 }
@@ -14,10 +14,46 @@ type DecisionStore interface {
 	GetAgentStates(ctx context.Context, orgID string) ([]AgentState, error)
 	GetISPAgentStates(ctx context.Context, orgID string, isp ISP) ([]AgentState, error)
 	UpdateAgentStatus(ctx context.Context, orgID string, isp ISP, agentType AgentType, status AgentStatus) error
+	UpdateAgentShadow(ctx context.Context, orgID string, isp ISP, agentType AgentType, shadow bool) error
 	QueryDecisions(ctx context.Context, orgID string, isp *ISP, agentType *AgentType, since *time.Time, limit int) ([]Decision, error)
 	QueryIPWarmupState(ctx context.Context, orgID string, poolName string) (activeIPs, warmupIPs, quarantinedIPs, dailyCap int, err error)
+	// QueryRevertible returns unreverted decisions that carry an expiry or
+	// recovery condition, for the DecisionReverter to resume after restart.
+	QueryRevertible(ctx context.Context, orgID string) ([]Decision, error)
+	MarkReverted(ctx context.Context, orgID, id, reason string, at time.Time) error
 }
 
+// ActionExecutor applies decisions to the MTA. *Executor drives PMTA
+// through a PMTABackend; replays use NoopExecutor.
+type ActionExecutor interface {
+	Execute(ctx context.Context, d Decision) error
+	ResumeAll(ctx context.Context) error
+	ResumeISP(ctx context.Context, isp ISP) error
+}
+
+// PMTABackend carries out individual PMTA operations. Queues use PMTA's
+// "domain/vmta" pattern syntax (e.g. "*/gmail-pool"); source "*" means every
+// source IP.
+type PMTABackend interface {
+	PauseQueue(ctx context.Context, queue string) error
+	ResumeQueue(ctx context.Context, queue string) error
+	SetQueueMode(ctx context.Context, queue, mode string) error
+	// SetQueueRate sets max-msg-rate, in messages per hour, on queue.
+	SetQueueRate(ctx context.Context, queue string, perHour int) error
+	DisableSource(ctx context.Context, source, queue string) error
+	EnableSource(ctx context.Context, source, queue string) error
+	Reload(ctx context.Context) error
+	// PutFile copies a local file to remotePath on the PMTA host.
+	PutFile(ctx context.Context, localPath, remotePath string) error
+	Close()
+}
+
+// Queue modes accepted by PMTABackend.SetQueueMode.
+const (
+	QueueModeNormal  = "normal"
+	QueueModeBackoff = "backoff"
+)
+
 // SignalStore persists computed signal snapshots and metric values.
 // The SignalProcessor uses this to write rolling-window metrics to the database.
 type SignalStore interface {
@@ -60,10 +96,33 @@ type ISPConfigStore interface {
 	UpdateISPConfig(ctx context.Context, orgID string, cfg ISPConfig) error
 }
 
+// RateAdjustmentStore records the RateController's rate changes together
+// with the signals behind them.
+type RateAdjustmentStore interface {
+	LogRateAdjustment(ctx context.Context, orgID string, adj RateAdjustment) error
+}
+
+// RateSink applies a controller rate, in messages per minute, to whatever
+// paces sending. ip is empty for the ISP-wide rate.
+type RateSink interface {
+	SetRate(ctx context.Context, isp ISP, ip string, perMinute float64) error
+}
+
 // AlertSender sends governance alert notifications via email or other channels.
+// The Orchestrator depends on this rather than *Alerter so replay and tests
+// can substitute a recorder.
 type AlertSender interface {
 	SendDecisionAlert(d Decision)
-	SendEmergencyAlert(isp ISP, message string)
+	SendEmergencyAlert(incident IncidentReport)
+	SendVelocityAlert(isp ISP, count5m int, threshold int)
+}
+
+// IncidentStore persists incidents opened by the Alerter together with
+// their acknowledgement state.
+type IncidentStore interface {
+	SaveIncident(ctx context.Context, orgID string, inc IncidentReport) error
+	AcknowledgeIncident(ctx context.Context, orgID, id, by string, at time.Time) (*IncidentReport, error)
+	ListIncidents(ctx context.Context, orgID string, status string, limit int) ([]IncidentReport, error)
 }
 
 // WorkerHealthReporter exposes health status for background workers.
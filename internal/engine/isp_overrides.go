@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
+)
+
+// ISPDomainOverride pins a recipient domain to an ISP group.
+type ISPDomainOverride struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	Domain         string    `json:"domain"`
+	ISP            ISP       `json:"isp"`
+	Note           string    `json:"note,omitempty"`
+	CreatedBy      string    `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ISPOverrideStore persists admin domain overrides and keeps the registry's
+// resolver in sync with the database.
+type ISPOverrideStore struct {
+	db       *sql.DB
+	orgID    string
+	registry *ISPRegistry
+}
+
+// NewISPOverrideStore creates an override store bound to a registry.
+func NewISPOverrideStore(db *sql.DB, orgID string, registry *ISPRegistry) *ISPOverrideStore {
+	return &ISPOverrideStore{db: db, orgID: orgID, registry: registry}
+}
+
+// ValidOverrideISP reports whether an override may target the ISP group.
+// "other" is allowed so a domain can be pulled out of a managed ISP.
+func ValidOverrideISP(i ISP) bool {
+	if i == ISP(isp.Other) {
+		return true
+	}
+	for _, known := range AllISPs() {
+		if i == known {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFromDB replaces the resolver's overrides with the stored set.
+func (s *ISPOverrideStore) LoadFromDB(ctx context.Context) error {
+	overrides, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	m := make(map[string]string, len(overrides))
+	for _, o := range overrides {
+		m[o.Domain] = string(o.ISP)
+	}
+	s.registry.Resolver().ReplaceOverrides(m)
+	log.Printf("[isp-overrides] loaded %d domain overrides", len(m))
+	return nil
+}
+
+// List returns all overrides ordered by domain.
+func (s *ISPOverrideStore) List(ctx context.Context) ([]ISPDomainOverride, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, organization_id, domain, isp, COALESCE(note,''), COALESCE(created_by,''),
+		 created_at, updated_at
+		 FROM mailing_isp_domain_overrides WHERE organization_id = $1 ORDER BY domain`,
+		s.orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ISPDomainOverride
+	for rows.Next() {
+		var o ISPDomainOverride
+		if err := rows.Scan(&o.ID, &o.OrganizationID, &o.Domain, &o.ISP, &o.Note,
+			&o.CreatedBy, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			continue
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// Upsert creates or replaces the override for a domain and applies it to
+// the resolver immediately.
+func (s *ISPOverrideStore) Upsert(ctx context.Context, o ISPDomainOverride) (*ISPDomainOverride, error) {
+	o.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(o.Domain)), ".")
+	o.ISP = ISP(strings.ToLower(strings.TrimSpace(string(o.ISP))))
+	if o.Domain == "" || strings.Contains(o.Domain, "@") {
+		return nil, fmt.Errorf("invalid domain %q", o.Domain)
+	}
+	if !ValidOverrideISP(o.ISP) {
+		return nil, fmt.Errorf("unknown ISP %q", o.ISP)
+	}
+	o.OrganizationID = s.orgID
+
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO mailing_isp_domain_overrides (organization_id, domain, isp, note, created_by)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (organization_id, domain) DO UPDATE
+		 SET isp = EXCLUDED.isp, note = EXCLUDED.note, updated_at = NOW()
+		 RETURNING id, created_at, updated_at`,
+		s.orgID, o.Domain, o.ISP, o.Note, o.CreatedBy,
+	).Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.registry.Resolver().SetOverride(o.Domain, string(o.ISP))
+	return &o, nil
+}
+
+// Delete removes the override for a domain.
+func (s *ISPOverrideStore) Delete(ctx context.Context, domain string) error {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM mailing_isp_domain_overrides WHERE organization_id = $1 AND domain = $2`,
+		s.orgID, domain)
+	if err != nil {
+		return err
+	}
+	s.registry.Resolver().RemoveOverride(domain)
+	return nil
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
+	"github.com/lib/pq"
+)
+
+// DomainGroupLabel maps an ISP group to the domain_group vocabulary used in
+// mailing_subscribers.custom_fields (see datanorm), where Gmail is "google".
+func DomainGroupLabel(group string) string {
+	if group == isp.Gmail {
+		return "google"
+	}
+	return group
+}
+
+// ReclassifyResult summarizes a domain_group reclassification run.
+type ReclassifyResult struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at,omitempty"`
+	Scanned    int            `json:"scanned"`
+	Updated    int            `json:"updated"`
+	Domains    int            `json:"domains"`
+	ByGroup    map[string]int `json:"by_group"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// ISPReclassifier re-resolves every subscriber's email domain with the shared
+// resolver and rewrites custom_fields.domain_group where it changed. Domains
+// the resolver cannot place (no override, known domain or MX match) keep
+// their existing label, which may come from an import vendor.
+type ISPReclassifier struct {
+	db        *sql.DB
+	registry  *ISPRegistry
+	batchSize int
+
+	mu      sync.Mutex
+	running bool
+	last    *ReclassifyResult
+}
+
+// NewISPReclassifier creates a reclassifier that scans subscribers in batches.
+func NewISPReclassifier(db *sql.DB, registry *ISPRegistry) *ISPReclassifier {
+	return &ISPReclassifier{db: db, registry: registry, batchSize: 5000}
+}
+
+// Status returns whether a run is in progress and the latest result.
+func (rc *ISPReclassifier) Status() (bool, *ReclassifyResult) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.last == nil {
+		return rc.running, nil
+	}
+	res := *rc.last
+	res.ByGroup = make(map[string]int, len(rc.last.ByGroup))
+	for g, n := range rc.last.ByGroup {
+		res.ByGroup[g] = n
+	}
+	return rc.running, &res
+}
+
+// Start launches a run in the background. It returns false if one is
+// already running. ctx should outlive the HTTP request that triggered it.
+func (rc *ISPReclassifier) Start(ctx context.Context, orgID string) bool {
+	rc.mu.Lock()
+	if rc.running {
+		rc.mu.Unlock()
+		return false
+	}
+	rc.running = true
+	rc.mu.Unlock()
+
+	go func() {
+		res, err := rc.run(ctx, orgID)
+		if err != nil {
+			log.Printf("[isp-reclassify] run failed after %d subscribers: %v", res.Scanned, err)
+		}
+	}()
+	return true
+}
+
+// Run reclassifies subscribers synchronously. An empty orgID covers all
+// organizations.
+func (rc *ISPReclassifier) Run(ctx context.Context, orgID string) (*ReclassifyResult, error) {
+	rc.mu.Lock()
+	if rc.running {
+		rc.mu.Unlock()
+		return nil, fmt.Errorf("reclassification already running")
+	}
+	rc.running = true
+	rc.mu.Unlock()
+	return rc.run(ctx, orgID)
+}
+
+func (rc *ISPReclassifier) run(ctx context.Context, orgID string) (*ReclassifyResult, error) {
+	res := &ReclassifyResult{StartedAt: time.Now(), ByGroup: make(map[string]int)}
+	rc.mu.Lock()
+	rc.last = res
+	rc.mu.Unlock()
+
+	err := rc.scan(ctx, orgID, res)
+
+	rc.mu.Lock()
+	res.FinishedAt = time.Now()
+	if err != nil {
+		res.Error = err.Error()
+	}
+	rc.running = false
+	rc.mu.Unlock()
+
+	log.Printf("[isp-reclassify] scanned=%d updated=%d domains=%d in %s",
+		res.Scanned, res.Updated, res.Domains, res.FinishedAt.Sub(res.StartedAt).Round(time.Second))
+	return res, err
+}
+
+func (rc *ISPReclassifier) scan(ctx context.Context, orgID string, res *ReclassifyResult) error {
+	resolver := rc.registry.Resolver()
+	labels := make(map[string]string) // domain -> label ("" = leave as is)
+	lastID := "00000000-0000-0000-0000-000000000000"
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rows, err := rc.db.QueryContext(ctx,
+			`SELECT id, email, COALESCE(custom_fields->>'domain_group', '')
+			 FROM mailing_subscribers
+			 WHERE id > $1 AND ($2 = '' OR organization_id::text = $2)
+			 ORDER BY id LIMIT $3`,
+			lastID, orgID, rc.batchSize)
+		if err != nil {
+			return fmt.Errorf("scan subscribers: %w", err)
+		}
+
+		type subscriberRow struct{ id, email, current string }
+		var batch []subscriberRow
+		for rows.Next() {
+			var sr subscriberRow
+			if err := rows.Scan(&sr.id, &sr.email, &sr.current); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan subscriber row: %w", err)
+			}
+			batch = append(batch, sr)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("scan subscribers: %w", err)
+		}
+
+		// Resolve after the rows are closed so MX lookups don't hold a
+		// connection open.
+		updates := make(map[string][]string) // label -> subscriber IDs
+		for _, sr := range batch {
+			lastID = sr.id
+			at := strings.LastIndexByte(sr.email, '@')
+			if at < 0 {
+				continue
+			}
+			domain := strings.ToLower(strings.TrimSpace(sr.email[at+1:]))
+			label, seen := labels[domain]
+			if !seen {
+				if r := resolver.ResolveMX(ctx, domain); r.Source != isp.SourceNone {
+					label = DomainGroupLabel(r.Group)
+				}
+				labels[domain] = label
+			}
+			if label == "" || label == sr.current {
+				continue
+			}
+			updates[label] = append(updates[label], sr.id)
+		}
+
+		for label, ids := range updates {
+			result, err := rc.db.ExecContext(ctx,
+				`UPDATE mailing_subscribers
+				 SET custom_fields = jsonb_set(COALESCE(custom_fields, '{}'::jsonb), '{domain_group}', to_jsonb($1::text)),
+				     updated_at = NOW()
+				 WHERE id = ANY($2::uuid[])`,
+				label, pq.Array(ids))
+			if err != nil {
+				return fmt.Errorf("update domain_group=%s: %w", label, err)
+			}
+			affected, _ := result.RowsAffected()
+			rc.mu.Lock()
+			res.Updated += int(affected)
+			res.ByGroup[label] += int(affected)
+			rc.mu.Unlock()
+		}
+
+		rc.mu.Lock()
+		res.Scanned += len(batch)
+		res.Domains = len(labels)
+		rc.mu.Unlock()
+
+		if len(batch) < rc.batchSize {
+			return nil
+		}
+	}
+}
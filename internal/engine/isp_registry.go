@@ -1,98 +1,63 @@
 package engine
 
 import (
-	"net"
 	"strings"
-	"sync"
-	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
 )
 
-// ISPRegistry maps recipient domains to ISP groups using static domain lists
-// and dynamic MX-based resolution with caching.
+// ISPRegistry maps recipient domains to ISP groups. It is a typed view over
+// an organization's isp.Resolver, so the engine, the campaign builder and
+// segmentation all classify that organization's domains the same way,
+// including its admin overrides and cached MX-based resolution of vanity
+// domains.
 type ISPRegistry struct {
-	staticMap map[string]ISP
-	mxCache   sync.Map // domain -> cacheEntry
-	cacheTTL  time.Duration
-}
-
-type cacheEntry struct {
-	isp       ISP
-	expiresAt time.Time
+	resolver *isp.Resolver
 }
 
-// NewISPRegistry creates a registry with all 8 ISP groups mapped.
+// NewISPRegistry creates a registry backed by a fresh resolver with the
+// built-in domains, for replays and tests.
 func NewISPRegistry() *ISPRegistry {
-	r := &ISPRegistry{
-		staticMap: make(map[string]ISP),
-		cacheTTL:  1 * time.Hour,
-	}
-	r.seedStaticMap()
-	return r
+	return NewISPRegistryWithResolver(isp.NewResolver())
 }
 
-func (r *ISPRegistry) seedStaticMap() {
-	gmail := []string{"gmail.com", "googlemail.com", "google.com"}
-	yahoo := []string{
-		"yahoo.com", "yahoo.co.uk", "yahoo.co.jp", "yahoo.co.in", "yahoo.ca",
-		"yahoo.com.au", "yahoo.com.br", "yahoo.fr", "yahoo.de", "yahoo.it",
-		"ymail.com", "aol.com", "aim.com", "verizon.net", "frontier.com", "rogers.com",
-	}
-	microsoft := []string{"outlook.com", "hotmail.com", "live.com", "msn.com", "passport.com"}
-	apple := []string{"icloud.com", "me.com", "mac.com"}
-	comcast := []string{"comcast.net", "xfinity.com"}
-	att := []string{
-		"att.net", "sbcglobal.net", "pacbell.net", "bellsouth.net",
-		"ameritech.net", "nvbell.net", "prodigy.net",
-	}
-	cox := []string{"cox.net"}
-	charter := []string{"charter.net", "spectrum.net", "rr.com", "twc.com", "brighthouse.com"}
-
-	for _, d := range gmail {
-		r.staticMap[d] = ISPGmail
-	}
-	for _, d := range yahoo {
-		r.staticMap[d] = ISPYahoo
-	}
-	for _, d := range microsoft {
-		r.staticMap[d] = ISPMicrosoft
-	}
-	for _, d := range apple {
-		r.staticMap[d] = ISPApple
-	}
-	for _, d := range comcast {
-		r.staticMap[d] = ISPComcast
-	}
-	for _, d := range att {
-		r.staticMap[d] = ISPAtt
-	}
-	for _, d := range cox {
-		r.staticMap[d] = ISPCox
-	}
-	for _, d := range charter {
-		r.staticMap[d] = ISPCharter
-	}
+// NewISPRegistryForOrg creates a registry backed by the organization's
+// resolver (isp.ForOrg), which its overrides are applied to.
+func NewISPRegistryForOrg(orgID string) *ISPRegistry {
+	return NewISPRegistryWithResolver(isp.ForOrg(orgID))
 }
 
-// ClassifyDomain returns the ISP group for a recipient domain.
-// Checks static map first, then falls back to MX-based resolution with caching.
-func (r *ISPRegistry) ClassifyDomain(domain string) ISP {
-	domain = strings.ToLower(strings.TrimSpace(domain))
+// NewISPRegistryWithResolver creates a registry backed by a specific resolver.
+func NewISPRegistryWithResolver(r *isp.Resolver) *ISPRegistry {
+	return &ISPRegistry{resolver: r}
+}
 
-	if isp, ok := r.staticMap[domain]; ok {
-		return isp
-	}
+// Resolver returns the underlying domain resolver.
+func (r *ISPRegistry) Resolver() *isp.Resolver {
+	return r.resolver
+}
 
-	if entry, ok := r.mxCache.Load(domain); ok {
-		ce := entry.(cacheEntry)
-		if time.Now().Before(ce.expiresAt) {
-			return ce.isp
+// ApplyConfigs registers the domain and MX patterns from ISP configs with
+// the resolver, so edits to mailing_engine_isp_config take effect without a
+// code change.
+func (r *ISPRegistry) ApplyConfigs(configs []ISPConfig) {
+	for _, cfg := range configs {
+		if !cfg.Enabled {
+			continue
 		}
-		r.mxCache.Delete(domain)
+		r.resolver.AddDomains(string(cfg.ISP), cfg.DomainPatterns...)
+		r.resolver.AddMXPatterns(string(cfg.ISP), cfg.MXPatterns...)
 	}
+}
 
-	isp := r.resolveMX(domain)
-	r.mxCache.Store(domain, cacheEntry{isp: isp, expiresAt: time.Now().Add(r.cacheTTL)})
-	return isp
+// ClassifyDomain returns the ISP group for a recipient domain, or "" when the
+// domain does not belong to a managed ISP.
+func (r *ISPRegistry) ClassifyDomain(domain string) ISP {
+	g := r.resolver.GroupFromDomain(domain)
+	if g == isp.Other {
+		return ""
+	}
+	return ISP(g)
 }
 
 // ClassifyEmail extracts the domain from an email and classifies it.
@@ -104,46 +69,9 @@ func (r *ISPRegistry) ClassifyEmail(email string) ISP {
 	return r.ClassifyDomain(parts[1])
 }
 
-// DomainsForISP returns all static domains assigned to an ISP.
-func (r *ISPRegistry) DomainsForISP(isp ISP) []string {
-	var domains []string
-	for d, i := range r.staticMap {
-		if i == isp {
-			domains = append(domains, d)
-		}
-	}
-	return domains
-}
-
-// mxPatterns maps MX hostname suffixes to ISP groups.
-var mxPatterns = map[string]ISP{
-	"google.com":                ISPGmail,
-	"googlemail.com":            ISPGmail,
-	"yahoodns.net":              ISPYahoo,
-	"protection.outlook.com":    ISPMicrosoft,
-	"olc.protection.outlook.com": ISPMicrosoft,
-	"icloud.com":                ISPApple,
-	"comcast.net":               ISPComcast,
-	"att.net":                   ISPAtt,
-	"cox.net":                   ISPCox,
-	"charter.net":               ISPCharter,
-}
-
-func (r *ISPRegistry) resolveMX(domain string) ISP {
-	records, err := net.LookupMX(domain)
-	if err != nil || len(records) == 0 {
-		return ""
-	}
-
-	for _, mx := range records {
-		host := strings.TrimSuffix(strings.ToLower(mx.Host), ".")
-		for suffix, isp := range mxPatterns {
-			if strings.HasSuffix(host, suffix) {
-				return isp
-			}
-		}
-	}
-	return ""
+// DomainsForISP returns all known domains assigned to an ISP.
+func (r *ISPRegistry) DomainsForISP(i ISP) []string {
+	return r.resolver.Domains(string(i))
 }
 
 // PoolNameForISP returns the PMTA pool name for an ISP.
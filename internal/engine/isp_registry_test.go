@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(mx map[string]string) *ISPRegistry {
+	r := isp.NewResolver()
+	r.SetMXLookup(func(_ context.Context, domain string) ([]*net.MX, error) {
+		if host, ok := mx[domain]; ok {
+			return []*net.MX{{Host: host, Pref: 10}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	})
+	return NewISPRegistryWithResolver(r)
+}
+
+func TestISPRegistry_ClassifyDomain(t *testing.T) {
+	reg := newTestRegistry(map[string]string{
+		"brand.com": "brand-com.mail.protection.outlook.com.",
+	})
+
+	assert.Equal(t, ISPGmail, reg.ClassifyDomain("gmail.com"))
+	assert.Equal(t, ISPYahoo, reg.ClassifyEmail("a@verizon.net"))
+	assert.Equal(t, ISP(""), reg.ClassifyEmail("a@Brand.com"), "classification never looks up MX records")
+	reg.Resolver().ResolveMX(context.Background(), "brand.com")
+	assert.Equal(t, ISPMicrosoft, reg.ClassifyEmail("a@Brand.com"))
+	assert.Equal(t, ISP(""), reg.ClassifyDomain("unknown.example"))
+	assert.Equal(t, ISP(""), reg.ClassifyEmail("not-an-email"))
+}
+
+func TestISPRegistry_ApplyConfigs(t *testing.T) {
+	reg := newTestRegistry(map[string]string{
+		"regional.de": "mx00.ionos.de.",
+	})
+	reg.Resolver().ResolveMX(context.Background(), "regional.de")
+	assert.Equal(t, ISP(""), reg.ClassifyDomain("regional.de"))
+
+	reg.ApplyConfigs([]ISPConfig{
+		{ISP: ISPCox, Enabled: true, DomainPatterns: []string{"cox.com"}, MXPatterns: []string{"*.ionos.de"}},
+		{ISP: ISPApple, Enabled: false, DomainPatterns: []string{"disabled.example"}},
+	})
+
+	assert.Equal(t, ISPCox, reg.ClassifyDomain("cox.com"))
+	assert.Equal(t, ISP(""), reg.ClassifyDomain("regional.de"), "MX cache is cleared when patterns change")
+	reg.Resolver().ResolveMX(context.Background(), "regional.de")
+	assert.Equal(t, ISPCox, reg.ClassifyDomain("regional.de"))
+	assert.Equal(t, ISP(""), reg.ClassifyDomain("disabled.example"))
+	assert.Contains(t, reg.DomainsForISP(ISPCox), "cox.com")
+}
+
+func TestISPOverrideStore_UpsertAppliesOverride(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	reg := newTestRegistry(nil)
+	store := NewISPOverrideStore(db, "org-1", reg)
+
+	mock.ExpectQuery("INSERT INTO mailing_isp_domain_overrides").
+		WithArgs("org-1", "corp.example", ISPMicrosoft, "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).
+			AddRow("ov-1", time.Now(), time.Now()))
+
+	o, err := store.Upsert(context.Background(), ISPDomainOverride{Domain: " Corp.Example. ", ISP: "Microsoft"})
+	require.NoError(t, err)
+	assert.Equal(t, "corp.example", o.Domain)
+	assert.Equal(t, ISPMicrosoft, reg.ClassifyDomain("corp.example"))
+
+	_, err = store.Upsert(context.Background(), ISPDomainOverride{Domain: "x.example", ISP: "hotmail"})
+	assert.Error(t, err, "unknown ISP is rejected before touching the DB")
+
+	mock.ExpectExec("DELETE FROM mailing_isp_domain_overrides").
+		WithArgs("org-1", "corp.example").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, store.Delete(context.Background(), "corp.example"))
+	assert.Equal(t, ISP(""), reg.ClassifyDomain("corp.example"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestISPReclassifier_Run(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	reg := newTestRegistry(map[string]string{
+		"acme.io": "aspmx.l.google.com.",
+	})
+	reg.Resolver().SetOverride("pinned.example", isp.Yahoo)
+	rc := NewISPReclassifier(db, reg)
+	rc.batchSize = 3
+
+	mock.ExpectQuery("SELECT id, email").
+		WithArgs("00000000-0000-0000-0000-000000000000", "", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "domain_group"}).
+			AddRow("id-1", "a@acme.io", "other").          // MX -> google
+			AddRow("id-2", "b@gmail.com", "google").       // already correct
+			AddRow("id-3", "c@selfhosted.example", "cox")) // unresolvable: keep vendor label
+	mock.ExpectExec("UPDATE mailing_subscribers").
+		WithArgs("google", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, email").
+		WithArgs("id-3", "", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "domain_group"}).
+			AddRow("id-4", "d@pinned.example", ""))
+	mock.ExpectExec("UPDATE mailing_subscribers").
+		WithArgs("yahoo", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := rc.Run(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, 4, res.Scanned)
+	assert.Equal(t, 2, res.Updated)
+	assert.Equal(t, map[string]int{"google": 1, "yahoo": 1}, res.ByGroup)
+
+	running, last := rc.Status()
+	assert.False(t, running)
+	require.NotNil(t, last)
+	assert.Equal(t, 2, last.Updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemDecisionStore is an in-memory DecisionStore for replays and tests.
+type MemDecisionStore struct {
+	mu        sync.Mutex
+	decisions []Decision
+	agents    map[AgentID]*AgentState
+}
+
+// NewMemDecisionStore creates an empty store.
+func NewMemDecisionStore() *MemDecisionStore {
+	return &MemDecisionStore{agents: make(map[AgentID]*AgentState)}
+}
+
+func (s *MemDecisionStore) PersistDecision(_ context.Context, d Decision) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	s.mu.Lock()
+	s.decisions = append(s.decisions, d)
+	s.mu.Unlock()
+	return nil
+}
+
+// Decisions returns every persisted decision in insertion order.
+func (s *MemDecisionStore) Decisions() []Decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Decision, len(s.decisions))
+	copy(out, s.decisions)
+	return out
+}
+
+// Decision returns a persisted decision by ID.
+func (s *MemDecisionStore) Decision(id string) (Decision, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.decisions {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return Decision{}, false
+}
+
+func (s *MemDecisionStore) agent(orgID string, isp ISP, agentType AgentType) *AgentState {
+	id := AgentID{ISP: isp, AgentType: agentType}
+	st, ok := s.agents[id]
+	if !ok {
+		st = &AgentState{OrganizationID: orgID, ISP: isp, AgentType: agentType, Status: StatusActive}
+		s.agents[id] = st
+	}
+	return st
+}
+
+func (s *MemDecisionStore) PersistAgentState(_ context.Context, orgID string, isp ISP, agentType AgentType, status AgentStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.agent(orgID, isp, agentType)
+	st.Status = status
+	st.DecisionsCount++
+	return nil
+}
+
+func (s *MemDecisionStore) GetAgentStates(_ context.Context, _ string) ([]AgentState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]AgentState, 0, len(s.agents))
+	for _, st := range s.agents {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ISP != out[j].ISP {
+			return out[i].ISP < out[j].ISP
+		}
+		return out[i].AgentType < out[j].AgentType
+	})
+	return out, nil
+}
+
+func (s *MemDecisionStore) GetISPAgentStates(ctx context.Context, orgID string, isp ISP) ([]AgentState, error) {
+	all, _ := s.GetAgentStates(ctx, orgID)
+	var out []AgentState
+	for _, st := range all {
+		if st.ISP == isp {
+			out = append(out, st)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemDecisionStore) UpdateAgentStatus(_ context.Context, orgID string, isp ISP, agentType AgentType, status AgentStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agent(orgID, isp, agentType).Status = status
+	return nil
+}
+
+func (s *MemDecisionStore) UpdateAgentShadow(_ context.Context, orgID string, isp ISP, agentType AgentType, shadow bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agent(orgID, isp, agentType).Shadow = shadow
+	return nil
+}
+
+func (s *MemDecisionStore) QueryDecisions(_ context.Context, _ string, isp *ISP, agentType *AgentType, since *time.Time, limit int) ([]Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Decision
+	for i := len(s.decisions) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		d := s.decisions[i]
+		if (isp != nil && d.ISP != *isp) || (agentType != nil && d.AgentType != *agentType) ||
+			(since != nil && d.CreatedAt.Before(*since)) {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (s *MemDecisionStore) QueryIPWarmupState(context.Context, string, string) (int, int, int, int, error) {
+	return 0, 0, 0, 0, nil
+}
+
+func (s *MemDecisionStore) QueryRevertible(context.Context, string) ([]Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Decision
+	for _, d := range s.decisions {
+		if d.RevertedAt == nil && d.Result != "reverted" && (d.ExpiresAt != nil || d.RevertCondition != nil) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (s *MemDecisionStore) MarkReverted(_ context.Context, _ string, id, reason string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.decisions {
+		d := &s.decisions[i]
+		if d.ID == id && d.RevertedAt == nil {
+			d.Result, d.RevertReason, d.RevertedAt = "reverted", reason, &at
+			return nil
+		}
+	}
+	return fmt.Errorf("decision %s not found or already reverted", id)
+}
+
+// NoopExecutor accepts every decision without touching an MTA. It counts
+// what it was asked to do.
+type NoopExecutor struct {
+	mu      sync.Mutex
+	actions map[string]int
+}
+
+func (e *NoopExecutor) Execute(_ context.Context, d Decision) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.actions == nil {
+		e.actions = make(map[string]int)
+	}
+	e.actions[d.ActionTaken]++
+	return nil
+}
+
+func (e *NoopExecutor) ResumeAll(context.Context) error { return nil }
+
+func (e *NoopExecutor) ResumeISP(context.Context, ISP) error { return nil }
+
+// Actions returns how many times each action was executed.
+func (e *NoopExecutor) Actions() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make(map[string]int, len(e.actions))
+	for k, v := range e.actions {
+		out[k] = v
+	}
+	return out
+}
@@ -3,9 +3,12 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
 // Orchestrator coordinates all 48 agents across 8 ISPs. It receives signal
@@ -17,13 +20,16 @@ type Orchestrator struct {
 	factory   *AgentFactory
 	processor *SignalProcessor
 	ingestor  *Ingestor
-	executor  *Executor
-	alerter   *Alerter
+	executor  ActionExecutor
+	alerter   AlertSender
 	memory    *MemoryStore
 	store     *SuppressionStore
+	reverter  *DecisionReverter
+	shadow    *ShadowRuleEvaluator
 
 	mu             sync.Mutex
 	recentDecisions []Decision
+	shadowAgents   map[AgentID]bool
 	running        bool
 	cancelFn       context.CancelFunc
 }
@@ -35,11 +41,15 @@ func NewOrchestrator(
 	factory *AgentFactory,
 	processor *SignalProcessor,
 	ingestor *Ingestor,
-	executor *Executor,
-	alerter *Alerter,
+	executor ActionExecutor,
+	alerter AlertSender,
 	memory *MemoryStore,
 	store *SuppressionStore,
 ) *Orchestrator {
+	reverter := NewDecisionReverter(decisions, orgID, executor)
+	if alerter != nil {
+		reverter.SetAlerter(alerter)
+	}
 	return &Orchestrator{
 		decisions: decisions,
 		orgID:     orgID,
@@ -50,15 +60,29 @@ func NewOrchestrator(
 		alerter:   alerter,
 		memory:    memory,
 		store:     store,
+		reverter:  reverter,
+		shadow:    NewShadowRuleEvaluator(nil),
+
+		shadowAgents: make(map[AgentID]bool),
 	}
 }
 
+// SetShadowRules replaces the evaluator for shadow-mode rules.
+func (o *Orchestrator) SetShadowRules(e *ShadowRuleEvaluator) {
+	o.shadow = e
+}
+
 // Start begins the orchestration loops.
 func (o *Orchestrator) Start(ctx context.Context) {
 	ctx, cancel := context.WithCancel(ctx)
 	o.cancelFn = cancel
 	o.running = true
 
+	if err := o.reverter.Load(ctx); err != nil {
+		log.Printf("[orchestrator] load revertible decisions: %v", err)
+	}
+	o.loadShadowState(ctx)
+
 	// Subscribe ingestor records to suppression agents
 	for _, isp := range AllISPs() {
 		ch := make(chan AccountingRecord, 5000)
@@ -81,7 +105,9 @@ func (o *Orchestrator) Start(ctx context.Context) {
 	o.ingestor.StartPolling(ctx)
 
 	// Start executor reload loop
-	o.executor.StartReloadLoop(ctx)
+	if rl, ok := o.executor.(interface{ StartReloadLoop(context.Context) }); ok {
+		rl.StartReloadLoop(ctx)
+	}
 
 	// Start suppression file sync
 	o.store.StartFileSync(ctx)
@@ -128,11 +154,21 @@ func (o *Orchestrator) runAgentEvalLoop(ctx context.Context, signalCh <-chan Sig
 		case <-ctx.Done():
 			return
 		case snap := <-signalCh:
-			o.evaluateISPAgents(snap)
+			o.handleSnapshot(ctx, snap)
 		}
 	}
 }
 
+// handleSnapshot runs the ISP's agents, due reverts and shadow rules against
+// one snapshot. Agent decisions arrive separately on the alert channel.
+func (o *Orchestrator) handleSnapshot(ctx context.Context, snap SignalSnapshot) {
+	o.evaluateISPAgents(snap)
+	o.evaluateReverts(ctx, snap)
+	for _, d := range o.shadow.Evaluate(snap) {
+		o.processDecision(ctx, d)
+	}
+}
+
 func (o *Orchestrator) evaluateISPAgents(snap SignalSnapshot) {
 	agents := o.factory.GetISPAgents(snap.ISP)
 	for _, agent := range agents {
@@ -143,6 +179,17 @@ func (o *Orchestrator) evaluateISPAgents(snap SignalSnapshot) {
 	}
 }
 
+// evaluateReverts undoes temporary decisions whose expiry or recovery
+// condition has been reached and records the inverse actions.
+func (o *Orchestrator) evaluateReverts(ctx context.Context, snap SignalSnapshot) {
+	for _, inv := range o.reverter.Evaluate(ctx, snap) {
+		o.trackDecision(inv)
+		if o.memory != nil {
+			o.memory.AppendDecision(ctx, inv.ISP, inv.AgentType, inv)
+		}
+	}
+}
+
 func (o *Orchestrator) runDecisionProcessor(ctx context.Context) {
 	alertCh := o.factory.AlertChannel()
 
@@ -164,6 +211,25 @@ func (o *Orchestrator) processDecision(ctx context.Context, d Decision) {
 	if d.ActionParams == nil {
 		d.ActionParams = json.RawMessage("{}")
 	}
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	if d.Result == "pending" && o.IsAgentShadow(d.ISP, d.AgentType) {
+		d.Result = ResultShadow
+	}
+	if d.Result == ResultShadow {
+		// Shadow decisions are recorded for comparison only: no executor,
+		// alerts, revert tracking or agent memory.
+		if err := o.decisions.PersistDecision(ctx, d); err != nil {
+			log.Printf("[orchestrator] persist shadow decision error: %v", err)
+		}
+		return
+	}
+	if d.Result == "pending" {
+		if cfg, ok := o.factory.Config(d.ISP); ok {
+			ApplyRevertPolicy(&d, cfg)
+		}
+	}
 
 	if err := o.decisions.PersistDecision(ctx, d); err != nil {
 		log.Printf("[orchestrator] persist decision error: %v", err)
@@ -172,18 +238,21 @@ func (o *Orchestrator) processDecision(ctx context.Context, d Decision) {
 	// Update agent state in DB
 	o.updateAgentState(ctx, d.ISP, d.AgentType)
 
-	// Track recent decisions
-	o.mu.Lock()
-	o.recentDecisions = append(o.recentDecisions, d)
-	if len(o.recentDecisions) > 200 {
-		o.recentDecisions = o.recentDecisions[len(o.recentDecisions)-200:]
-	}
-	o.mu.Unlock()
+	o.trackDecision(d)
+
+	// An agent undoing an action itself replaces any scheduled revert.
+	o.reverter.Observe(ctx, d)
 
 	// Execute PMTA command if action is pending
 	if d.Result == "pending" {
 		if err := o.executor.Execute(ctx, d); err != nil {
 			log.Printf("[orchestrator] execute error: %v", err)
+			// Still in effect on the nodes that applied it.
+			if errors.Is(err, ErrPartialApply) {
+				o.reverter.Track(d)
+			}
+		} else {
+			o.reverter.Track(d)
 		}
 	}
 
@@ -216,6 +285,16 @@ func (o *Orchestrator) processDecision(ctx context.Context, d Decision) {
 	}
 }
 
+// trackDecision keeps the last 200 decisions in memory for dashboards.
+func (o *Orchestrator) trackDecision(d Decision) {
+	o.mu.Lock()
+	o.recentDecisions = append(o.recentDecisions, d)
+	if len(o.recentDecisions) > 200 {
+		o.recentDecisions = o.recentDecisions[len(o.recentDecisions)-200:]
+	}
+	o.mu.Unlock()
+}
+
 func (o *Orchestrator) updateAgentState(ctx context.Context, isp ISP, agentType AgentType) {
 	agent := o.factory.GetAgent(isp, agentType)
 	if agent == nil {
@@ -241,6 +320,72 @@ func (o *Orchestrator) GetRecentDecisions(limit int) []Decision {
 	return result
 }
 
+// IsAgentShadow reports whether an agent's decisions are recorded as shadow
+// decisions instead of executed.
+func (o *Orchestrator) IsAgentShadow(isp ISP, agentType AgentType) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.shadowAgents[AgentID{ISP: isp, AgentType: agentType}]
+}
+
+// SetAgentShadow switches an agent between live and shadow mode. In shadow
+// mode the agent keeps evaluating but its decisions never reach the executor.
+func (o *Orchestrator) SetAgentShadow(ctx context.Context, isp ISP, agentType AgentType, shadow bool) error {
+	if err := o.decisions.UpdateAgentShadow(ctx, o.orgID, isp, agentType, shadow); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	if shadow {
+		o.shadowAgents[AgentID{ISP: isp, AgentType: agentType}] = true
+	} else {
+		delete(o.shadowAgents, AgentID{ISP: isp, AgentType: agentType})
+	}
+	o.mu.Unlock()
+	return nil
+}
+
+// ReloadShadowRules re-reads shadow-mode rules after a rule change.
+func (o *Orchestrator) ReloadShadowRules(ctx context.Context) error {
+	return o.shadow.Reload(ctx)
+}
+
+func (o *Orchestrator) loadShadowState(ctx context.Context) {
+	if err := o.shadow.Reload(ctx); err != nil {
+		log.Printf("[orchestrator] load shadow rules: %v", err)
+	}
+	states, err := o.decisions.GetAgentStates(ctx, o.orgID)
+	if err != nil {
+		log.Printf("[orchestrator] load shadow agents: %v", err)
+		return
+	}
+	o.mu.Lock()
+	for _, st := range states {
+		if st.Shadow {
+			o.shadowAgents[AgentID{ISP: st.ISP, AgentType: st.AgentType}] = true
+		}
+	}
+	n := len(o.shadowAgents)
+	o.mu.Unlock()
+	if n > 0 || o.shadow.Count() > 0 {
+		log.Printf("[orchestrator] shadow mode: %d agents, %d rules", n, o.shadow.Count())
+	}
+}
+
+// PendingReverts lists temporary decisions waiting to be undone.
+func (o *Orchestrator) PendingReverts() []PendingRevert {
+	return o.reverter.Pending()
+}
+
+// RevertDecision undoes a temporary decision now instead of waiting for
+// its expiry or recovery condition.
+func (o *Orchestrator) RevertDecision(ctx context.Context, id, reason string) (*Decision, error) {
+	inv, err := o.reverter.Revert(ctx, id, reason)
+	if inv != nil {
+		o.trackDecision(*inv)
+	}
+	return inv, err
+}
+
 // GetAgentStates returns all agent states from the database.
 func (o *Orchestrator) GetAgentStates(ctx context.Context) ([]AgentState, error) {
 	return o.decisions.GetAgentStates(ctx, o.orgID)
@@ -0,0 +1,388 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateControllerConfig tunes the AIMD loop of the RateController. Zero
+// fields take the values of DefaultRateControllerConfig.
+type RateControllerConfig struct {
+	// Interval is the minimum time between two adjustments of an ISP.
+	Interval time.Duration
+	// MinAttempts is how many attempts (sent + deferred) a five-minute
+	// window needs before the rate is moved at all.
+	MinAttempts int
+
+	// The rate is cut when the deferral or refusal share of attempts, in
+	// percent, or the median acceptance latency exceeds its target.
+	DeferralTarget float64
+	RefusalTarget  float64
+	LatencyTarget  time.Duration
+
+	// IncreaseStep is the additive increase as a fraction of the ceiling,
+	// DecreaseFactor the multiplier applied on congestion, and MinRate the
+	// floor as a fraction of the ceiling.
+	IncreaseStep   float64
+	DecreaseFactor float64
+	MinRate        float64
+
+	// Refresh is how often a rate that has not moved is applied again, so
+	// sinks may let what they were given expire once the controller stops.
+	Refresh time.Duration
+}
+
+// DefaultRateControllerConfig returns the production tuning.
+func DefaultRateControllerConfig() RateControllerConfig {
+	return RateControllerConfig{
+		Interval:       time.Minute,
+		MinAttempts:    20,
+		DeferralTarget: 5,
+		RefusalTarget:  2,
+		LatencyTarget:  5 * time.Minute,
+		IncreaseStep:   0.05,
+		DecreaseFactor: 0.7,
+		MinRate:        0.05,
+		Refresh:        10 * time.Minute,
+	}
+}
+
+func (c RateControllerConfig) withDefaults() RateControllerConfig {
+	d := DefaultRateControllerConfig()
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.MinAttempts <= 0 {
+		c.MinAttempts = d.MinAttempts
+	}
+	if c.DeferralTarget <= 0 {
+		c.DeferralTarget = d.DeferralTarget
+	}
+	if c.RefusalTarget <= 0 {
+		c.RefusalTarget = d.RefusalTarget
+	}
+	if c.LatencyTarget <= 0 {
+		c.LatencyTarget = d.LatencyTarget
+	}
+	if c.IncreaseStep <= 0 {
+		c.IncreaseStep = d.IncreaseStep
+	}
+	if c.DecreaseFactor <= 0 || c.DecreaseFactor >= 1 {
+		c.DecreaseFactor = d.DecreaseFactor
+	}
+	if c.MinRate <= 0 {
+		c.MinRate = d.MinRate
+	}
+	if c.Refresh <= 0 {
+		c.Refresh = d.Refresh
+	}
+	return c
+}
+
+// RateInputs are the live signals a rate decision is made from, over the
+// last five minutes.
+type RateInputs struct {
+	Attempts        int     `json:"attempts_5m"`
+	DeferralRate    float64 `json:"deferral_rate"`
+	RefusalRate     float64 `json:"refusal_rate"`
+	AcceptLatencyMs int64   `json:"accept_latency_ms"`
+}
+
+// RateAdjustment is one change of the allowed rate for an ISP, or for one
+// source IP at that ISP when IP is set. Rates are messages per minute.
+type RateAdjustment struct {
+	ISP       ISP        `json:"isp"`
+	IP        string     `json:"ip,omitempty"`
+	Direction string     `json:"direction"` // increase, decrease
+	OldRate   float64    `json:"old_msgs_per_minute"`
+	NewRate   float64    `json:"new_msgs_per_minute"`
+	Ceiling   float64    `json:"ceiling_msgs_per_minute"`
+	Inputs    RateInputs `json:"inputs"`
+	Reason    string     `json:"reason"`
+	At        time.Time  `json:"at"`
+}
+
+// AllowedRate is the current controller output for an ISP or source IP.
+type AllowedRate struct {
+	ISP     ISP     `json:"isp"`
+	IP      string  `json:"ip,omitempty"`
+	Rate    float64 `json:"msgs_per_minute"`
+	Ceiling float64 `json:"ceiling_msgs_per_minute"`
+}
+
+type rateKey struct {
+	isp ISP
+	ip  string
+}
+
+// RateController sets the allowed send rate per ISP or per source IP with
+// additive-increase/multiplicative-decrease on the SignalProcessor's live
+// deferral rate, connection refusals and acceptance latency. ISPConfig's
+// MaxMsgRate (per hour) is the ceiling; a rate starts there and only drops
+// once an ISP pushes back.
+//
+// An ISP is controlled as a whole until its snapshots carry per-IP metrics;
+// from then on only its IPs are, each within an even share of the ceiling,
+// so the rates applied never add up to more than MaxMsgRate.
+type RateController struct {
+	orgID string
+	cfg   RateControllerConfig
+	store RateAdjustmentStore
+	sink  RateSink
+
+	mu       sync.Mutex
+	ceilings map[ISP]float64
+	rates    map[rateKey]float64
+	applied  map[rateKey]time.Time
+	shares   map[ISP]int // IPs the ceiling is split across; 0 while controlled as a whole
+	lastRun  map[ISP]time.Time
+}
+
+// NewRateController creates a controller for the enabled ISPs in configs.
+func NewRateController(orgID string, configs []ISPConfig, store RateAdjustmentStore, cfg RateControllerConfig) *RateController {
+	c := &RateController{
+		orgID:   orgID,
+		cfg:     cfg.withDefaults(),
+		store:   store,
+		rates:   make(map[rateKey]float64),
+		applied: make(map[rateKey]time.Time),
+		shares:  make(map[ISP]int),
+		lastRun: make(map[ISP]time.Time),
+	}
+	c.SetISPConfigs(configs)
+	return c
+}
+
+// SetSink sets where new rates are applied.
+func (c *RateController) SetSink(sink RateSink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sink = sink
+}
+
+// SetISPConfigs replaces the ceilings, e.g. after max_msg_rate is edited.
+// Rates above a lowered ceiling come down on the next adjustment.
+func (c *RateController) SetISPConfigs(configs []ISPConfig) {
+	ceilings := make(map[ISP]float64)
+	for _, cfg := range configs {
+		if cfg.Enabled && cfg.MaxMsgRate > 0 {
+			ceilings[cfg.ISP] = float64(cfg.MaxMsgRate) / 60
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ceilings = ceilings
+}
+
+// Start feeds the processor's snapshots into Update until ctx is done.
+func (c *RateController) Start(ctx context.Context, processor *SignalProcessor) {
+	ch := make(chan SignalSnapshot, 100)
+	processor.Subscribe(ch)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case snap := <-ch:
+				c.Update(ctx, snap)
+			}
+		}
+	}()
+}
+
+// Update runs one control step for the snapshot's ISP, or for each of its
+// source IPs once it is controlled per IP, then logs and applies every rate
+// that moved and re-applies those not applied within cfg.Refresh.
+func (c *RateController) Update(ctx context.Context, snap SignalSnapshot) []RateAdjustment {
+	c.mu.Lock()
+	ceiling, ok := c.ceilings[snap.ISP]
+	last, ran := c.lastRun[snap.ISP]
+	if !ok || (ran && snap.Timestamp.Sub(last) < c.cfg.Interval) {
+		c.mu.Unlock()
+		return nil
+	}
+	c.lastRun[snap.ISP] = snap.Timestamp
+
+	if n := len(snap.IPMetrics); n > 0 {
+		if c.shares[snap.ISP] == 0 {
+			// Switching to per-IP control drops the ISP-wide rate
+			delete(c.rates, rateKey{isp: snap.ISP})
+			delete(c.applied, rateKey{isp: snap.ISP})
+		}
+		c.shares[snap.ISP] = n
+	}
+
+	var adjustments []RateAdjustment
+	stepped := make(map[rateKey]bool)
+	if c.shares[snap.ISP] == 0 {
+		key := rateKey{isp: snap.ISP}
+		inputs := RateInputs{
+			Attempts:        snap.Sent5m + snap.Deferred5m,
+			DeferralRate:    safeRate(snap.Deferred5m, snap.Sent5m+snap.Deferred5m),
+			RefusalRate:     safeRate(snap.Refused5m, snap.Sent5m+snap.Deferred5m),
+			AcceptLatencyMs: snap.AcceptLatencyMs5m,
+		}
+		if adj, ok := c.step(key, ceiling, inputs, snap.Timestamp); ok {
+			adjustments = append(adjustments, adj)
+			stepped[key] = true
+		}
+	} else {
+		share := ceiling / float64(c.shares[snap.ISP])
+		ips := make([]string, 0, len(snap.IPMetrics))
+		for ip := range snap.IPMetrics {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		for _, ip := range ips {
+			m := snap.IPMetrics[ip]
+			key := rateKey{isp: snap.ISP, ip: ip}
+			inputs := RateInputs{
+				Attempts:        m.Sent5m + m.Deferred5m,
+				DeferralRate:    safeRate(m.Deferred5m, m.Sent5m+m.Deferred5m),
+				RefusalRate:     safeRate(m.Refused5m, m.Sent5m+m.Deferred5m),
+				AcceptLatencyMs: m.AcceptLatencyMs,
+			}
+			if adj, ok := c.step(key, share, inputs, snap.Timestamp); ok {
+				adjustments = append(adjustments, adj)
+				stepped[key] = true
+			}
+		}
+	}
+
+	// Rates that held still are applied again before sinks let them lapse
+	var refresh []AllowedRate
+	for key, rate := range c.rates {
+		if key.isp != snap.ISP || stepped[key] {
+			continue
+		}
+		if at, ok := c.applied[key]; !ok || snap.Timestamp.Sub(at) >= c.cfg.Refresh {
+			refresh = append(refresh, AllowedRate{ISP: key.isp, IP: key.ip, Rate: rate})
+			c.applied[key] = snap.Timestamp
+		}
+	}
+	for key := range stepped {
+		c.applied[key] = snap.Timestamp
+	}
+	sink := c.sink
+	c.mu.Unlock()
+
+	for _, adj := range adjustments {
+		target := rateTarget(adj.ISP, adj.IP)
+		log.Printf("[RateController] %s %s %.1f -> %.1f msgs/min: %s", target, adj.Direction, adj.OldRate, adj.NewRate, adj.Reason)
+		if c.store != nil {
+			if err := c.store.LogRateAdjustment(ctx, c.orgID, adj); err != nil {
+				log.Printf("[RateController] log adjustment %s: %v", target, err)
+			}
+		}
+		if sink != nil {
+			if err := sink.SetRate(ctx, adj.ISP, adj.IP, adj.NewRate); err != nil {
+				log.Printf("[RateController] apply rate %s: %v", target, err)
+			}
+		}
+	}
+	if sink != nil {
+		for _, r := range refresh {
+			if err := sink.SetRate(ctx, r.ISP, r.IP, r.Rate); err != nil {
+				log.Printf("[RateController] refresh rate %s: %v", rateTarget(r.ISP, r.IP), err)
+			}
+		}
+	}
+	return adjustments
+}
+
+func rateTarget(isp ISP, ip string) string {
+	if ip == "" {
+		return string(isp)
+	}
+	return string(isp) + "/" + ip
+}
+
+// step moves one rate. Congestion cuts it multiplicatively; a healthy
+// window with enough attempts raises it by a fixed step toward the ceiling.
+// Caller holds c.mu.
+func (c *RateController) step(key rateKey, ceiling float64, in RateInputs, at time.Time) (RateAdjustment, bool) {
+	old, ok := c.rates[key]
+	if !ok {
+		old = ceiling
+	}
+	floor := math.Min(ceiling, math.Max(1, ceiling*c.cfg.MinRate))
+
+	next, reason := old, ""
+	switch {
+	case old > ceiling:
+		next, reason = ceiling, fmt.Sprintf("ceiling lowered to %.1f msgs/min", ceiling)
+	case in.Attempts < c.cfg.MinAttempts:
+		// Too little traffic to judge; hold.
+	case in.DeferralRate > c.cfg.DeferralTarget:
+		next = old * c.cfg.DecreaseFactor
+		reason = fmt.Sprintf("deferral rate %.1f%% above %.1f%%", in.DeferralRate, c.cfg.DeferralTarget)
+	case in.RefusalRate > c.cfg.RefusalTarget:
+		next = old * c.cfg.DecreaseFactor
+		reason = fmt.Sprintf("refusal rate %.1f%% above %.1f%%", in.RefusalRate, c.cfg.RefusalTarget)
+	case time.Duration(in.AcceptLatencyMs)*time.Millisecond > c.cfg.LatencyTarget:
+		next = old * c.cfg.DecreaseFactor
+		reason = fmt.Sprintf("acceptance latency %s above %s",
+			(time.Duration(in.AcceptLatencyMs) * time.Millisecond).Round(time.Second), c.cfg.LatencyTarget)
+	default:
+		next = old + ceiling*c.cfg.IncreaseStep
+		reason = fmt.Sprintf("healthy: deferrals %.1f%%, refusals %.1f%%", in.DeferralRate, in.RefusalRate)
+	}
+	next = math.Max(floor, math.Min(ceiling, next))
+	c.rates[key] = next
+
+	if math.Abs(next-old) < 0.01 {
+		return RateAdjustment{}, false
+	}
+	direction := "increase"
+	if next < old {
+		direction = "decrease"
+	}
+	return RateAdjustment{
+		ISP:       key.isp,
+		IP:        key.ip,
+		Direction: direction,
+		OldRate:   old,
+		NewRate:   next,
+		Ceiling:   ceiling,
+		Inputs:    in,
+		Reason:    reason,
+		At:        at,
+	}, true
+}
+
+// Rates returns the allowed rate of every controlled ISP, or of each of
+// its IPs once it is controlled per IP.
+func (c *RateController) Rates() []AllowedRate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var out []AllowedRate
+	for isp, ceiling := range c.ceilings {
+		if n := c.shares[isp]; n > 0 {
+			share := ceiling / float64(n)
+			for key, rate := range c.rates {
+				if key.isp == isp && key.ip != "" {
+					out = append(out, AllowedRate{ISP: isp, IP: key.ip, Rate: math.Min(rate, share), Ceiling: share})
+				}
+			}
+			continue
+		}
+		rate, ok := c.rates[rateKey{isp: isp}]
+		if !ok {
+			rate = ceiling
+		}
+		out = append(out, AllowedRate{ISP: isp, Rate: rate, Ceiling: ceiling})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ISP != out[j].ISP {
+			return out[i].ISP < out[j].ISP
+		}
+		return out[i].IP < out[j].IP
+	})
+	return out
+}
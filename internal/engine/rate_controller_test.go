@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingRates struct {
+	logged  []RateAdjustment
+	applied map[string]float64
+}
+
+func (r *recordingRates) LogRateAdjustment(ctx context.Context, orgID string, adj RateAdjustment) error {
+	r.logged = append(r.logged, adj)
+	return nil
+}
+
+func (r *recordingRates) SetRate(ctx context.Context, isp ISP, ip string, perMinute float64) error {
+	r.applied[string(isp)+"/"+ip] = perMinute
+	return nil
+}
+
+func TestSignalProcessor_RefusalsAndLatency(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	sp := NewSignalProcessor(nil, "org", nil)
+	sp.SetClock(func() time.Time { return now })
+	ch := make(chan SignalSnapshot, len(AllISPs()))
+	sp.Subscribe(ch)
+
+	for _, queued := range []string{"2026-10-16 11:59:00+0000", "2026-10-16T11:58:00Z", "2026-10-16 11:00:00+0000"} {
+		sp.Ingest(ISPGmail, AccountingRecord{Type: "d", SourceIP: "10.0.0.1", TimeQueued: queued,
+			DeliveryTime: "2026-10-16 12:00:00+0000"})
+	}
+	sp.Ingest(ISPGmail, AccountingRecord{Type: "t", SourceIP: "10.0.0.1",
+		DSNDiag: "dial tcp 142.250.1.1:25: connect: connection refused"})
+	sp.Ingest(ISPGmail, AccountingRecord{Type: "t", SourceIP: "10.0.0.1", DSNStatus: "4.2.2", DSNDiag: "mailbox full"})
+	sp.Tick()
+
+	var snap SignalSnapshot
+	for range AllISPs() {
+		if s := <-ch; s.ISP == ISPGmail {
+			snap = s
+		}
+	}
+	assert.Equal(t, 2, snap.Deferred5m)
+	assert.Equal(t, 1, snap.Refused5m)
+	assert.InDelta(t, 33.3, snap.RefusalRate5m, 0.1)
+	assert.Equal(t, int64(2*time.Minute/time.Millisecond), snap.AcceptLatencyMs5m, "median, not mean")
+	ip := snap.IPMetrics["10.0.0.1"]
+	assert.Equal(t, 3, ip.Sent5m)
+	assert.Equal(t, 1, ip.Refused5m)
+	assert.Equal(t, snap.AcceptLatencyMs5m, ip.AcceptLatencyMs)
+}
+
+func TestRateController_AIMD(t *testing.T) {
+	rec := &recordingRates{applied: map[string]float64{}}
+	configs := []ISPConfig{
+		{ISP: ISPGmail, MaxMsgRate: 600, Enabled: true}, // 10/min
+		{ISP: ISPYahoo, MaxMsgRate: 600, Enabled: false},
+	}
+	c := NewRateController("org", configs, rec, RateControllerConfig{})
+	c.SetSink(rec)
+	ctx := context.Background()
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	snap := func(at time.Time, sent, deferred int, ips map[string]IPMetric) SignalSnapshot {
+		return SignalSnapshot{ISP: ISPGmail, Timestamp: at, Sent5m: sent, Deferred5m: deferred, IPMetrics: ips}
+	}
+
+	// Without per-IP metrics the ISP is controlled as a whole: 10%
+	// deferrals cut its rate.
+	adjs := c.Update(ctx, snap(t0, 90, 10, nil))
+	require.Len(t, adjs, 1)
+	assert.Equal(t, ISPGmail, adjs[0].ISP)
+	assert.Empty(t, adjs[0].IP)
+	assert.Equal(t, "decrease", adjs[0].Direction)
+	assert.InDelta(t, 10, adjs[0].OldRate, 0.001)
+	assert.InDelta(t, 7, adjs[0].NewRate, 0.001)
+	assert.InDelta(t, 10, adjs[0].Inputs.DeferralRate, 0.001)
+	assert.Equal(t, 100, adjs[0].Inputs.Attempts)
+	assert.Equal(t, adjs, rec.logged)
+	assert.InDelta(t, 7, rec.applied["gmail/"], 0.001)
+
+	// Within the interval nothing moves; yahoo is not controlled.
+	assert.Empty(t, c.Update(ctx, snap(t0.Add(30*time.Second), 100, 0, nil)))
+	assert.Empty(t, c.Update(ctx, SignalSnapshot{ISP: ISPYahoo, Timestamp: t0, Sent5m: 10, Deferred5m: 90}))
+
+	// Healthy: additive increase of 5% of the ceiling.
+	adjs = c.Update(ctx, snap(t0.Add(time.Minute), 100, 0, nil))
+	require.Len(t, adjs, 1)
+	assert.Equal(t, "increase", adjs[0].Direction)
+	assert.InDelta(t, 7.5, adjs[0].NewRate, 0.001)
+
+	// Slow acceptance is congestion too; too few attempts hold the rate.
+	adjs = c.Update(ctx, SignalSnapshot{ISP: ISPGmail, Timestamp: t0.Add(2 * time.Minute),
+		Sent5m: 100, AcceptLatencyMs5m: int64(10 * time.Minute / time.Millisecond)})
+	require.Len(t, adjs, 1)
+	assert.InDelta(t, 5.25, adjs[0].NewRate, 0.001)
+	assert.Empty(t, c.Update(ctx, snap(t0.Add(3*time.Minute), 5, 0, nil)))
+
+	// Once IPs report, only they are controlled, each within an even share
+	// of the ceiling: a refusing IP is cut and a healthy one is applied at
+	// its share.
+	delete(rec.applied, "gmail/")
+	adjs = c.Update(ctx, snap(t0.Add(4*time.Minute), 147, 3, map[string]IPMetric{
+		"10.0.0.1": {Sent5m: 50},
+		"10.0.0.2": {Sent5m: 97, Deferred5m: 3, Refused5m: 3},
+	}))
+	require.Len(t, adjs, 1)
+	assert.Equal(t, "10.0.0.2", adjs[0].IP)
+	assert.Contains(t, adjs[0].Reason, "refusal rate")
+	assert.InDelta(t, 5, adjs[0].Ceiling, 0.001)
+	assert.InDelta(t, 3.5, adjs[0].NewRate, 0.001)
+	assert.Equal(t, map[string]float64{"gmail/10.0.0.1": 5, "gmail/10.0.0.2": 3.5}, rec.applied)
+
+	// A lowered max_msg_rate pulls the rates down at once.
+	c.SetISPConfigs([]ISPConfig{{ISP: ISPGmail, MaxMsgRate: 180, Enabled: true}})
+	adjs = c.Update(ctx, snap(t0.Add(5*time.Minute), 10, 0, map[string]IPMetric{
+		"10.0.0.1": {Sent5m: 5},
+		"10.0.0.2": {Sent5m: 5},
+	}))
+	require.Len(t, adjs, 2)
+	assert.InDelta(t, 1.5, adjs[0].NewRate, 0.001)
+	assert.InDelta(t, 1.5, adjs[1].NewRate, 0.001)
+
+	// A snapshot without IP metrics does not bring back the ISP-wide rate,
+	// and rates that held still are applied again after cfg.Refresh.
+	rec.applied = map[string]float64{}
+	assert.Empty(t, c.Update(ctx, snap(t0.Add(6*time.Minute), 5, 0, nil)))
+	assert.Empty(t, rec.applied)
+	assert.Empty(t, c.Update(ctx, snap(t0.Add(16*time.Minute), 5, 0, nil)))
+	assert.Equal(t, map[string]float64{"gmail/10.0.0.1": 1.5, "gmail/10.0.0.2": 1.5}, rec.applied)
+
+	rates := c.Rates()
+	require.Len(t, rates, 2)
+	assert.Equal(t, AllowedRate{ISP: ISPGmail, IP: "10.0.0.1", Rate: 1.5, Ceiling: 1.5}, rates[0])
+	assert.Equal(t, "10.0.0.2", rates[1].IP)
+}
@@ -0,0 +1,296 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pmta"
+)
+
+// ReplayRecord is an accounting record with the time it was logged. The
+// replay clock advances to At before the record is ingested.
+type ReplayRecord struct {
+	At  time.Time
+	Rec AccountingRecord
+}
+
+// ReplayRecordFromAcct converts a parsed PMTA accounting row.
+func ReplayRecordFromAcct(r pmta.AcctRecord) ReplayRecord {
+	return ReplayRecord{
+		At: r.TimeLogged,
+		Rec: AccountingRecord{
+			Type:         r.Type,
+			Recipient:    r.Rcpt,
+			Sender:       r.Orig,
+			BounceCat:    r.BounceCat,
+			DSNStatus:    r.BounceCode,
+			DSNDiag:      r.DSNDiag,
+			SourceIP:     r.SourceIP,
+			VMTA:         r.VMTA,
+			Domain:       r.Domain,
+			DeliveryTime: r.TimeLogged.Format(time.RFC3339),
+			JobID:        r.JobID,
+		},
+	}
+}
+
+// LoadAcctFiles parses PMTA accounting CSVs into replay records.
+func LoadAcctFiles(paths []string) ([]ReplayRecord, error) {
+	var out []ReplayRecord
+	for _, path := range paths {
+		// Each file carries its own header line.
+		records, err := pmta.NewAcctParser().ParseFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			out = append(out, ReplayRecordFromAcct(r))
+		}
+	}
+	return out, nil
+}
+
+// trackingEventTypes maps mailing_tracking_events types to accounting
+// record types. Both the 001 and 029 spellings are accepted.
+var trackingEventTypes = map[string]string{
+	"delivered":  "d",
+	"bounced":    "b",
+	"bounce":     "b",
+	"complained": "f",
+	"complaint":  "f",
+	"deferred":   "t",
+}
+
+// LoadTrackingEvents reads delivery, bounce, deferral and complaint events
+// for an organization from mailing_tracking_events.
+func LoadTrackingEvents(ctx context.Context, db *sql.DB, orgID string, from, to time.Time) ([]ReplayRecord, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT event_type, COALESCE(event_time, event_at), COALESCE(email, ''),
+			COALESCE(sending_ip, ''), COALESCE(bounce_type, ''), COALESCE(bounce_reason, '')
+		FROM mailing_tracking_events
+		WHERE organization_id = $1
+		  AND event_type IN ('delivered', 'bounced', 'bounce', 'complained', 'complaint', 'deferred')
+		  AND COALESCE(event_time, event_at) >= $2 AND COALESCE(event_time, event_at) < $3
+		ORDER BY 2`, orgID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query tracking events: %w", err)
+	}
+	defer rows.Close()
+
+	var out []ReplayRecord
+	for rows.Next() {
+		var eventType, email, ip, bounceType, reason string
+		var at time.Time
+		if err := rows.Scan(&eventType, &at, &email, &ip, &bounceType, &reason); err != nil {
+			return nil, err
+		}
+		domain := ""
+		if i := strings.LastIndex(email, "@"); i >= 0 {
+			domain = strings.ToLower(email[i+1:])
+		}
+		out = append(out, ReplayRecord{At: at, Rec: AccountingRecord{
+			Type:      trackingEventTypes[eventType],
+			Recipient: email,
+			SourceIP:  ip,
+			Domain:    domain,
+			BounceCat: bounceType,
+			DSNDiag:   reason,
+		}})
+	}
+	return out, rows.Err()
+}
+
+// ReplayConfig controls a replay run.
+type ReplayConfig struct {
+	OrgID string
+	// Configs are the ISP thresholds under test. Defaults to DefaultISPConfigs.
+	Configs []ISPConfig
+	// ShadowRules are evaluated alongside the agents. They are forced into
+	// shadow mode so their decisions are reported separately.
+	ShadowRules []Rule
+	Registry    *ISPRegistry
+	// TickInterval is how often snapshots are computed on the virtual
+	// clock. Defaults to 10s, matching the live signal loop.
+	TickInterval time.Duration
+	// From and To bound the replay. Zero values take the first and last
+	// record times.
+	From time.Time
+	To   time.Time
+}
+
+// ReplayReport lists every decision the engine would have made.
+type ReplayReport struct {
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Records   int            `json:"records"`
+	Skipped   int            `json:"skipped"` // unclassified or outside the range
+	ByISP     map[ISP]int    `json:"records_by_isp"`
+	Snapshots int            `json:"snapshots"`
+	ByAction  map[string]int `json:"decisions_by_action"`
+	Shadow    int            `json:"shadow_decisions"`
+	Reverted  int            `json:"reverted"` // decisions auto-reverted within the range
+	Decisions []Decision     `json:"decisions"`
+}
+
+// replayClock is the virtual time shared by the processor and agents.
+type replayClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *replayClock) set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// Replay feeds historical records through a fresh SignalProcessor, agent set
+// and Orchestrator on a virtual clock. Decisions go to an in-memory store
+// and a no-op executor, so nothing touches PMTA or the database. Use it to
+// backtest threshold and rule changes against a past incident.
+func Replay(ctx context.Context, records []ReplayRecord, cfg ReplayConfig) (*ReplayReport, error) {
+	if len(records) == 0 {
+		return nil, fmt.Errorf("replay: no records")
+	}
+	if cfg.TickInterval <= 0 {
+		cfg.TickInterval = 10 * time.Second
+	}
+	if len(cfg.Configs) == 0 {
+		cfg.Configs = DefaultISPConfigs()
+	}
+	if cfg.Registry == nil {
+		cfg.Registry = NewISPRegistry()
+	}
+
+	sorted := make([]ReplayRecord, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].At.Before(sorted[j].At) })
+	from, to := cfg.From, cfg.To
+	if from.IsZero() {
+		from = sorted[0].At
+	}
+	if to.IsZero() {
+		to = sorted[len(sorted)-1].At
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("replay: empty time range %s - %s", from, to)
+	}
+
+	clock := &replayClock{now: from}
+	processor := NewSignalProcessor(nil, cfg.OrgID, cfg.Registry)
+	processor.SetClock(clock.Now)
+	snapCh := make(chan SignalSnapshot, 4*len(AllISPs()))
+	processor.Subscribe(snapCh)
+
+	factory := NewAgentFactory(nil, cfg.OrgID, nil, nil, nil)
+	factory.SetRegistry(cfg.Registry)
+	factory.InitializeWithConfigs(cfg.Configs)
+	factory.SetClock(clock.Now)
+
+	store := NewMemDecisionStore()
+	o := NewOrchestrator(store, cfg.OrgID, factory, processor, nil, &NoopExecutor{}, nil, nil, nil)
+	if len(cfg.ShadowRules) > 0 {
+		rules := make([]Rule, len(cfg.ShadowRules))
+		for i, r := range cfg.ShadowRules {
+			r.Mode = RuleModeShadow
+			rules[i] = r
+		}
+		shadow := NewShadowRuleEvaluator(nil)
+		shadow.SetRules(rules)
+		o.SetShadowRules(shadow)
+	}
+
+	report := &ReplayReport{From: from, To: to, ByISP: map[ISP]int{}, ByAction: map[string]int{}}
+	alertCh := factory.AlertChannel()
+	next := from.Add(cfg.TickInterval)
+	lastPrune := from
+
+	tick := func(at time.Time) {
+		clock.set(at)
+		processor.Tick()
+		for drained := false; !drained; {
+			select {
+			case snap := <-snapCh:
+				report.Snapshots++
+				o.handleSnapshot(ctx, snap)
+			default:
+				drained = true
+			}
+		}
+		for drained := false; !drained; {
+			select {
+			case d := <-alertCh:
+				o.processDecision(ctx, d)
+			default:
+				drained = true
+			}
+		}
+		if at.Sub(lastPrune) >= 5*time.Minute {
+			processor.Prune()
+			lastPrune = at
+		}
+	}
+
+	for _, r := range sorted {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if r.At.Before(from) || r.At.After(to) {
+			report.Skipped++
+			continue
+		}
+		for !next.After(r.At) {
+			tick(next)
+			next = next.Add(cfg.TickInterval)
+		}
+
+		isp := ISP("")
+		if r.Rec.Domain != "" {
+			isp = cfg.Registry.ClassifyDomain(r.Rec.Domain)
+		}
+		if isp == "" && r.Rec.Recipient != "" {
+			isp = cfg.Registry.ClassifyEmail(r.Rec.Recipient)
+		}
+		if isp == "" {
+			report.Skipped++
+			continue
+		}
+		clock.set(r.At)
+		processor.Ingest(isp, r.Rec)
+		report.Records++
+		report.ByISP[isp]++
+	}
+	for !next.After(to) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tick(next)
+		next = next.Add(cfg.TickInterval)
+	}
+
+	report.Decisions = store.Decisions()
+	sort.SliceStable(report.Decisions, func(i, j int) bool {
+		return report.Decisions[i].CreatedAt.Before(report.Decisions[j].CreatedAt)
+	})
+	for _, d := range report.Decisions {
+		report.ByAction[d.ActionTaken]++
+		if d.Result == ResultShadow {
+			report.Shadow++
+		}
+		if d.RevertedAt != nil {
+			report.Reverted++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const replayAcctHeader = "#type,timeLogged,orig,rcpt,orcpt,dsnAction,dsnStatus,dsnDiag,dsnMTA,bounceCat,srcType,srcMTA,dlvType,dlvSourceIp,dlvDestinationIp,dlvEsmtpAvailable,dlvSize,vmta,jobId,envId,queue,vmtaPool"
+
+// yahooDeferralStorm writes ten minutes of Yahoo traffic where every other
+// delivery attempt after the first two minutes is deferred with TSS04.
+func yahooDeferralStorm(t *testing.T, t0 time.Time) string {
+	var b strings.Builder
+	b.WriteString(replayAcctHeader + "\n")
+	for i := 0; i < 600; i++ {
+		at := t0.Add(time.Duration(i) * time.Second).Format("2006-01-02 15:04:05")
+		rcpt := fmt.Sprintf("user%d@yahoo.com", i)
+		fmt.Fprintf(&b, "d,%s,news@example.com,%s,,relayed,2.0.0,250 ok,,,,,,10.0.0.1,,,1000,mta1,job-1,,,\n", at, rcpt)
+		if i >= 120 && i%2 == 0 {
+			fmt.Fprintf(&b, "t,%s,news@example.com,%s,,delayed,4.7.0,421 4.7.0 [TSS04] Messages temporarily deferred,,policy-related,,,,10.0.0.1,,,1000,mta1,job-1,,,\n", at, rcpt)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "acct-yahoo.csv")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o644))
+	return path
+}
+
+func TestReplay_DeferralStormFiresThrottle(t *testing.T) {
+	t0 := time.Date(2026, 4, 14, 9, 0, 0, 0, time.UTC)
+	records, err := LoadAcctFiles([]string{yahooDeferralStorm(t, t0)})
+	require.NoError(t, err)
+	records = append(records, ReplayRecord{At: t0.Add(time.Minute), Rec: AccountingRecord{Type: "d", Recipient: "x@unknown.example"}})
+
+	report, err := Replay(context.Background(), records, ReplayConfig{
+		OrgID: "org-1",
+		ShadowRules: []Rule{{ID: "r-early", ISP: ISPYahoo, AgentType: AgentThrottle, Metric: "deferral_rate_5m",
+			Operator: ">", Threshold: 10, Action: "reduce_rate", CooldownSeconds: 3600, Enabled: true}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 600+240, report.Records)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 840, report.ByISP[ISPYahoo])
+	assert.Equal(t, t0, report.From)
+	assert.Positive(t, report.Snapshots)
+	assert.Equal(t, 1, report.Shadow, "rule cooldown limits the shadow rule to one hit")
+
+	var throttle []Decision
+	for _, d := range report.Decisions {
+		if d.Result != ResultShadow && d.AgentType == AgentThrottle {
+			throttle = append(throttle, d)
+		}
+		assert.False(t, d.CreatedAt.Before(t0) || d.CreatedAt.After(report.To), "decision stamped with virtual time")
+	}
+	require.NotEmpty(t, throttle)
+	assert.Equal(t, ISPYahoo, throttle[0].ISP)
+	assert.Contains(t, []string{"reduce_rate", "backoff_mode"}, throttle[0].ActionTaken)
+	assert.True(t, throttle[0].CreatedAt.After(t0.Add(2*time.Minute)), "fires only once deferrals start")
+	assert.NotNil(t, throttle[0].ExpiresAt, "revert policy applied as in production")
+}
+
+func TestReplay_RejectsEmptyInput(t *testing.T) {
+	_, err := Replay(context.Background(), nil, ReplayConfig{})
+	assert.Error(t, err)
+
+	at := time.Now()
+	_, err = Replay(context.Background(), []ReplayRecord{{At: at}}, ReplayConfig{})
+	assert.Error(t, err, "a single instant is not a range")
+}
+
+func TestLoadTrackingEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	from := time.Date(2026, 4, 14, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	mock.ExpectQuery("FROM mailing_tracking_events").
+		WithArgs("org-1", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"event_type", "at", "email", "sending_ip", "bounce_type", "bounce_reason"}).
+			AddRow("delivered", from.Add(time.Minute), "a@Gmail.com", "10.0.0.1", "", "").
+			AddRow("bounce", from.Add(2*time.Minute), "b@yahoo.com", "10.0.0.2", "hard", "550 5.1.1 user unknown"))
+
+	records, err := LoadTrackingEvents(context.Background(), db, "org-1", from, to)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "d", records[0].Rec.Type)
+	assert.Equal(t, "gmail.com", records[0].Rec.Domain)
+	assert.Equal(t, "b", records[1].Rec.Type)
+	assert.Equal(t, "hard", records[1].Rec.BounceCat)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
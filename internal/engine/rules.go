@@ -23,7 +23,7 @@ func NewRuleStore(db *sql.DB, orgID string) *RuleStore {
 func (rs *RuleStore) ListRules(ctx context.Context, isp string, agentType string) ([]Rule, error) {
 	query := `SELECT id, organization_id, isp, agent_type, name, COALESCE(description,''),
 		metric, operator, threshold, window_seconds, action, action_params,
-		cooldown_seconds, priority, enabled, created_at, updated_at
+		cooldown_seconds, priority, enabled, COALESCE(mode,'live'), created_at, updated_at
 		FROM mailing_engine_rules WHERE organization_id = $1`
 	args := []interface{}{rs.orgID}
 	argN := 2
@@ -52,7 +52,7 @@ func (rs *RuleStore) ListRules(ctx context.Context, isp string, agentType string
 		if err := rows.Scan(&r.ID, &r.OrganizationID, &r.ISP, &r.AgentType,
 			&r.Name, &r.Description, &r.Metric, &r.Operator, &r.Threshold,
 			&r.WindowSeconds, &r.Action, &r.ActionParams, &r.CooldownSeconds,
-			&r.Priority, &r.Enabled, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			&r.Priority, &r.Enabled, &r.Mode, &r.CreatedAt, &r.UpdatedAt); err != nil {
 			continue
 		}
 		rules = append(rules, r)
@@ -66,15 +66,18 @@ func (rs *RuleStore) CreateRule(ctx context.Context, r Rule) (*Rule, error) {
 	if r.ActionParams == nil {
 		r.ActionParams = json.RawMessage("{}")
 	}
+	if r.Mode == "" {
+		r.Mode = RuleModeLive
+	}
 	err := rs.db.QueryRowContext(ctx,
 		`INSERT INTO mailing_engine_rules
 		(organization_id, isp, agent_type, name, description, metric, operator, threshold,
-		 window_seconds, action, action_params, cooldown_seconds, priority, enabled)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+		 window_seconds, action, action_params, cooldown_seconds, priority, enabled, mode)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
 		RETURNING id, created_at, updated_at`,
 		r.OrganizationID, r.ISP, r.AgentType, r.Name, r.Description,
 		r.Metric, r.Operator, r.Threshold, r.WindowSeconds,
-		r.Action, r.ActionParams, r.CooldownSeconds, r.Priority, r.Enabled,
+		r.Action, r.ActionParams, r.CooldownSeconds, r.Priority, r.Enabled, r.Mode,
 	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -87,16 +90,19 @@ func (rs *RuleStore) UpdateRule(ctx context.Context, id string, r Rule) (*Rule,
 	if r.ActionParams == nil {
 		r.ActionParams = json.RawMessage("{}")
 	}
+	if r.Mode == "" {
+		r.Mode = RuleModeLive
+	}
 	err := rs.db.QueryRowContext(ctx,
 		`UPDATE mailing_engine_rules SET
 		isp=$1, agent_type=$2, name=$3, description=$4, metric=$5, operator=$6,
 		threshold=$7, window_seconds=$8, action=$9, action_params=$10,
-		cooldown_seconds=$11, priority=$12, enabled=$13, updated_at=NOW()
+		cooldown_seconds=$11, priority=$12, enabled=$13, mode=$16, updated_at=NOW()
 		WHERE id=$14 AND organization_id=$15
 		RETURNING id, created_at, updated_at`,
 		r.ISP, r.AgentType, r.Name, r.Description, r.Metric, r.Operator,
 		r.Threshold, r.WindowSeconds, r.Action, r.ActionParams,
-		r.CooldownSeconds, r.Priority, r.Enabled, id, rs.orgID,
+		r.CooldownSeconds, r.Priority, r.Enabled, id, rs.orgID, r.Mode,
 	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,316 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ResultShadow marks a decision that was evaluated and persisted but never
+// sent to the executor: it came from a shadow-mode rule or agent.
+const ResultShadow = "shadow"
+
+// Rule modes. Live rules are enforced by the agents; shadow rules are
+// evaluated by the ShadowRuleEvaluator and only ever produce shadow
+// decisions.
+const (
+	RuleModeLive   = "live"
+	RuleModeShadow = "shadow"
+)
+
+// ValidRuleMode reports whether m is a known rule mode. Empty means live.
+func ValidRuleMode(m string) bool {
+	return m == "" || m == RuleModeLive || m == RuleModeShadow
+}
+
+// ipTargetedActions are evaluated once per IP in the snapshot.
+var ipTargetedActions = map[string]bool{
+	"disable_source_ip":   true,
+	"quarantine_ip":       true,
+	"deprioritize_ip":     true,
+	"reprioritize_ip":     true,
+	"reduce_ip_volume":    true,
+	"warn_bounce_rate":    true,
+	"warn_complaint_rate": true,
+}
+
+// ruleMetric reads a rule metric from a snapshot, per IP when ip is set. The
+// bool is false when the metric is unknown at that scope or saw no traffic.
+func ruleMetric(snap SignalSnapshot, metric, ip string) (float64, bool) {
+	if ip != "" {
+		m, ok := snap.IPMetrics[ip]
+		if !ok || m.Sent1h == 0 {
+			return 0, false
+		}
+		switch metric {
+		case "bounce_rate_1h":
+			return m.BounceRate1h, true
+		case "complaint_rate_24h":
+			return m.ComplaintRate, true
+		case "deferral_rate_5m":
+			return m.DeferralRate, true
+		case "score":
+			return m.Score, true
+		}
+		return 0, false
+	}
+	switch metric {
+	case "bounce_rate_1m":
+		return snap.BounceRate1m, snap.Sent5m > 0
+	case "bounce_rate_5m":
+		return snap.BounceRate5m, snap.Sent5m > 0
+	case "bounce_rate_1h":
+		return snap.BounceRate1h, snap.Sent1h > 0
+	case "complaint_rate_1h":
+		return snap.ComplaintRate1h, snap.Sent1h > 0
+	case "deferral_rate_5m":
+		return snap.DeferralRate5m, snap.Sent5m > 0
+	case "deferral_rate_1h":
+		return snap.DeferralRate1h, snap.Sent1h > 0
+	}
+	return 0, false
+}
+
+// ShadowRuleEvaluator evaluates enabled shadow-mode rules against signal
+// snapshots so new thresholds can be tried without touching PMTA. Every hit
+// becomes a decision with Result ResultShadow and the rule's ID, subject to
+// the rule's cooldown per target.
+type ShadowRuleEvaluator struct {
+	rules *RuleStore
+
+	mu        sync.Mutex
+	byISP     map[ISP][]Rule
+	lastFired map[string]time.Time
+}
+
+// NewShadowRuleEvaluator creates an evaluator backed by rules. A nil store
+// is allowed; rules are then supplied with SetRules.
+func NewShadowRuleEvaluator(rules *RuleStore) *ShadowRuleEvaluator {
+	return &ShadowRuleEvaluator{
+		rules:     rules,
+		byISP:     make(map[ISP][]Rule),
+		lastFired: make(map[string]time.Time),
+	}
+}
+
+// Reload re-reads shadow rules from the store.
+func (e *ShadowRuleEvaluator) Reload(ctx context.Context) error {
+	if e.rules == nil {
+		return nil
+	}
+	rules, err := e.rules.ListRules(ctx, "", "")
+	if err != nil {
+		return err
+	}
+	e.SetRules(rules)
+	return nil
+}
+
+// SetRules replaces the evaluated rule set. Live and disabled rules are
+// ignored.
+func (e *ShadowRuleEvaluator) SetRules(rules []Rule) {
+	byISP := make(map[ISP][]Rule)
+	for _, r := range rules {
+		if r.Enabled && r.Mode == RuleModeShadow {
+			byISP[r.ISP] = append(byISP[r.ISP], r)
+		}
+	}
+	e.mu.Lock()
+	e.byISP = byISP
+	e.mu.Unlock()
+}
+
+// Count returns the number of shadow rules being evaluated.
+func (e *ShadowRuleEvaluator) Count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for _, rs := range e.byISP {
+		n += len(rs)
+	}
+	return n
+}
+
+// Evaluate returns the shadow decisions snap triggers.
+func (e *ShadowRuleEvaluator) Evaluate(snap SignalSnapshot) []Decision {
+	now := snap.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var out []Decision
+	for _, r := range e.byISP[snap.ISP] {
+		targets := []string{""}
+		if ipTargetedActions[r.Action] {
+			targets = targets[:0]
+			for ip := range snap.IPMetrics {
+				targets = append(targets, ip)
+			}
+			sort.Strings(targets)
+		}
+		for _, ip := range targets {
+			v, ok := ruleMetric(snap, r.Metric, ip)
+			if !ok || !compareThreshold(v, r.Operator, r.Threshold) {
+				continue
+			}
+			key := r.ID + "|" + ip
+			if last, ok := e.lastFired[key]; ok && now.Sub(last) < time.Duration(r.CooldownSeconds)*time.Second {
+				continue
+			}
+			e.lastFired[key] = now
+
+			ruleID := r.ID
+			d := Decision{
+				ISP:         snap.ISP,
+				AgentType:   r.AgentType,
+				RuleID:      &ruleID,
+				ActionTaken: r.Action,
+				SignalValues: mustJSON(map[string]interface{}{
+					r.Metric:    v,
+					"threshold": r.Threshold,
+					"operator":  r.Operator,
+					"rule":      r.Name,
+				}),
+				ActionParams: r.ActionParams,
+				TargetType:   "isp",
+				TargetValue:  string(snap.ISP),
+				Result:       ResultShadow,
+				CreatedAt:    now,
+			}
+			if ip != "" {
+				d.TargetType, d.TargetValue = "ip", ip
+			}
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// DecisionStreamSummary counts one side of a shadow/live comparison.
+type DecisionStreamSummary struct {
+	Total    int            `json:"total"`
+	ByAction map[string]int `json:"by_action"`
+	ByISP    map[ISP]int    `json:"by_isp"`
+}
+
+// RuleShadowSummary reports how a single shadow rule would have behaved.
+type RuleShadowSummary struct {
+	RuleID  string     `json:"rule_id"`
+	Fired   int        `json:"fired"`
+	Matched int        `json:"matched"` // a live decision took the same action within tolerance
+	FirstAt *time.Time `json:"first_at,omitempty"`
+	LastAt  *time.Time `json:"last_at,omitempty"`
+}
+
+// ShadowComparison lines shadow decisions up against live ones over a time
+// range. Two decisions match when they share ISP, action and target and
+// occur within Tolerance of each other.
+type ShadowComparison struct {
+	From       time.Time             `json:"from"`
+	To         time.Time             `json:"to"`
+	Tolerance  string                `json:"tolerance"`
+	Live       DecisionStreamSummary `json:"live"`
+	Shadow     DecisionStreamSummary `json:"shadow"`
+	Matched    int                   `json:"matched"`
+	ShadowOnly []Decision            `json:"shadow_only"`
+	LiveOnly   []Decision            `json:"live_only"`
+	ByRule     []RuleShadowSummary   `json:"by_rule"`
+}
+
+// maxComparisonSamples caps the unmatched decisions returned per side.
+const maxComparisonSamples = 100
+
+// CompareDecisionStreams splits decisions into live and shadow streams and
+// matches them.
+func CompareDecisionStreams(decisions []Decision, from, to time.Time, tolerance time.Duration) ShadowComparison {
+	cmp := ShadowComparison{
+		From:       from,
+		To:         to,
+		Tolerance:  tolerance.String(),
+		Live:       DecisionStreamSummary{ByAction: map[string]int{}, ByISP: map[ISP]int{}},
+		Shadow:     DecisionStreamSummary{ByAction: map[string]int{}, ByISP: map[ISP]int{}},
+		ShadowOnly: []Decision{},
+		LiveOnly:   []Decision{},
+	}
+
+	type key struct {
+		isp    ISP
+		action string
+		target string
+	}
+	var shadow []Decision
+	live := map[key][]Decision{}
+	for _, d := range decisions {
+		if d.Result == ResultShadow {
+			shadow = append(shadow, d)
+			cmp.Shadow.Total++
+			cmp.Shadow.ByAction[d.ActionTaken]++
+			cmp.Shadow.ByISP[d.ISP]++
+			continue
+		}
+		k := key{d.ISP, d.ActionTaken, d.TargetValue}
+		live[k] = append(live[k], d)
+		cmp.Live.Total++
+		cmp.Live.ByAction[d.ActionTaken]++
+		cmp.Live.ByISP[d.ISP]++
+	}
+
+	liveMatched := map[string]bool{}
+	rules := map[string]*RuleShadowSummary{}
+	for _, s := range shadow {
+		matched := false
+		for _, l := range live[key{s.ISP, s.ActionTaken, s.TargetValue}] {
+			if diff := s.CreatedAt.Sub(l.CreatedAt); diff <= tolerance && diff >= -tolerance {
+				matched = true
+				liveMatched[l.ID] = true
+			}
+		}
+		if matched {
+			cmp.Matched++
+		} else if len(cmp.ShadowOnly) < maxComparisonSamples {
+			cmp.ShadowOnly = append(cmp.ShadowOnly, s)
+		}
+
+		if s.RuleID == nil {
+			continue
+		}
+		rs, ok := rules[*s.RuleID]
+		if !ok {
+			rs = &RuleShadowSummary{RuleID: *s.RuleID}
+			rules[*s.RuleID] = rs
+		}
+		rs.Fired++
+		if matched {
+			rs.Matched++
+		}
+		at := s.CreatedAt
+		if rs.FirstAt == nil || at.Before(*rs.FirstAt) {
+			rs.FirstAt = &at
+		}
+		if rs.LastAt == nil || at.After(*rs.LastAt) {
+			rs.LastAt = &at
+		}
+	}
+
+	for _, ds := range live {
+		for _, l := range ds {
+			if !liveMatched[l.ID] {
+				cmp.LiveOnly = append(cmp.LiveOnly, l)
+			}
+		}
+	}
+	sort.Slice(cmp.LiveOnly, func(i, j int) bool { return cmp.LiveOnly[i].CreatedAt.Before(cmp.LiveOnly[j].CreatedAt) })
+	if len(cmp.LiveOnly) > maxComparisonSamples {
+		cmp.LiveOnly = cmp.LiveOnly[:maxComparisonSamples]
+	}
+
+	for _, rs := range rules {
+		cmp.ByRule = append(cmp.ByRule, *rs)
+	}
+	sort.Slice(cmp.ByRule, func(i, j int) bool { return cmp.ByRule[i].Fired > cmp.ByRule[j].Fired })
+	return cmp
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowRuleEvaluator_Evaluate(t *testing.T) {
+	e := NewShadowRuleEvaluator(nil)
+	e.SetRules([]Rule{
+		{ID: "r-ip", ISP: ISPYahoo, AgentType: AgentReputation, Name: "Tighter bounce action",
+			Metric: "bounce_rate_1h", Operator: ">", Threshold: 3, Action: "deprioritize_ip",
+			CooldownSeconds: 600, Enabled: true, Mode: RuleModeShadow},
+		{ID: "r-isp", ISP: ISPYahoo, AgentType: AgentThrottle, Name: "Earlier throttle",
+			Metric: "deferral_rate_5m", Operator: ">=", Threshold: 10, Action: "reduce_rate",
+			Enabled: true, Mode: RuleModeShadow},
+		{ID: "r-live", ISP: ISPYahoo, Metric: "deferral_rate_5m", Operator: ">", Threshold: 0,
+			Action: "emergency_halt", Enabled: true, Mode: RuleModeLive},
+		{ID: "r-off", ISP: ISPYahoo, Metric: "deferral_rate_5m", Operator: ">", Threshold: 0,
+			Action: "emergency_halt", Enabled: false, Mode: RuleModeShadow},
+	})
+	assert.Equal(t, 2, e.Count())
+
+	t0 := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	snap := SignalSnapshot{
+		ISP: ISPYahoo, Timestamp: t0, Sent5m: 400, DeferralRate5m: 12,
+		IPMetrics: map[string]IPMetric{
+			"10.0.0.1": {BounceRate1h: 4.5, Sent1h: 900},
+			"10.0.0.2": {BounceRate1h: 1.0, Sent1h: 900},
+			"10.0.0.3": {BounceRate1h: 9.0, Sent1h: 0}, // no traffic: ignored
+		},
+	}
+
+	out := e.Evaluate(snap)
+	require.Len(t, out, 2)
+	assert.Equal(t, "deprioritize_ip", out[0].ActionTaken)
+	assert.Equal(t, "10.0.0.1", out[0].TargetValue)
+	assert.Equal(t, "r-ip", *out[0].RuleID)
+	assert.Equal(t, ResultShadow, out[0].Result)
+	assert.Equal(t, "reduce_rate", out[1].ActionTaken)
+	assert.Equal(t, "yahoo", out[1].TargetValue)
+
+	// The IP rule is on cooldown; the ISP rule has none.
+	snap.Timestamp = t0.Add(5 * time.Minute)
+	out = e.Evaluate(snap)
+	require.Len(t, out, 1)
+	assert.Equal(t, "r-isp", *out[0].RuleID)
+
+	snap.Timestamp = t0.Add(11 * time.Minute)
+	assert.Len(t, e.Evaluate(snap), 2)
+	assert.Empty(t, e.Evaluate(SignalSnapshot{ISP: ISPGmail, Timestamp: t0}))
+}
+
+func TestCompareDecisionStreams(t *testing.T) {
+	t0 := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	rule := "r-1"
+	decisions := []Decision{
+		{ID: "l-1", ISP: ISPYahoo, ActionTaken: "reduce_rate", TargetValue: "yahoo", Result: "applied", CreatedAt: t0},
+		{ID: "s-1", ISP: ISPYahoo, ActionTaken: "reduce_rate", TargetValue: "yahoo", Result: ResultShadow, RuleID: &rule, CreatedAt: t0.Add(-5 * time.Minute)},
+		{ID: "s-2", ISP: ISPYahoo, ActionTaken: "reduce_rate", TargetValue: "yahoo", Result: ResultShadow, RuleID: &rule, CreatedAt: t0.Add(2 * time.Hour)},
+		{ID: "l-2", ISP: ISPGmail, ActionTaken: "emergency_halt", TargetValue: "gmail", Result: "reverted", CreatedAt: t0},
+		{ID: "s-3", ISP: ISPGmail, ActionTaken: "pause_isp_queues", TargetValue: "gmail", Result: ResultShadow, CreatedAt: t0},
+	}
+
+	cmp := CompareDecisionStreams(decisions, t0.Add(-time.Hour), t0.Add(3*time.Hour), 15*time.Minute)
+	assert.Equal(t, 2, cmp.Live.Total)
+	assert.Equal(t, 3, cmp.Shadow.Total)
+	assert.Equal(t, 2, cmp.Shadow.ByAction["reduce_rate"])
+	assert.Equal(t, 1, cmp.Matched)
+	require.Len(t, cmp.ShadowOnly, 2)
+	assert.Equal(t, "s-2", cmp.ShadowOnly[0].ID)
+	require.Len(t, cmp.LiveOnly, 1)
+	assert.Equal(t, "l-2", cmp.LiveOnly[0].ID)
+	require.Len(t, cmp.ByRule, 1)
+	assert.Equal(t, RuleShadowSummary{RuleID: "r-1", Fired: 2, Matched: 1,
+		FirstAt: &decisions[1].CreatedAt, LastAt: &decisions[2].CreatedAt}, cmp.ByRule[0])
+}
@@ -3,8 +3,11 @@ package engine
 import (
 	"context"
 	"log"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
 )
 
 // SignalProcessor computes per-ISP rolling-window metrics from ingested records.
@@ -17,6 +20,7 @@ type SignalProcessor struct {
 	mu       sync.RWMutex
 	windows  map[ISP]*ISPSignalWindow
 	listeners []chan<- SignalSnapshot
+	now      func() time.Time
 }
 
 // ISPSignalWindow holds the rolling-window metrics for one ISP.
@@ -31,6 +35,8 @@ type ISPSignalWindow struct {
 	ipBounced    map[string]*windowCounter
 	ipComplaints map[string]*windowCounter
 	ipDeferred   map[string]*windowCounter
+	ipRefused    map[string]*windowCounter
+	ipLatency    map[string]*latencyWindow
 
 	// Per-domain metrics
 	domainSent    map[string]*windowCounter
@@ -42,6 +48,10 @@ type ISPSignalWindow struct {
 	totalBounced   *windowCounter
 	totalComplaints *windowCounter
 	totalDeferred  *windowCounter
+	totalRefused   *windowCounter
+
+	// Queued-to-accepted latency of deliveries
+	latency *latencyWindow
 
 	// DSN code samples (capped ring buffer for recent observations)
 	recentDSNCodes      []dsnSample
@@ -52,6 +62,46 @@ type windowCounter struct {
 	events []time.Time
 }
 
+// latencyWindow keeps acceptance latency samples for medians over a window.
+type latencyWindow struct {
+	samples []latencySample
+}
+
+type latencySample struct {
+	At      time.Time
+	Latency time.Duration
+}
+
+func (lw *latencyWindow) add(t time.Time, d time.Duration) {
+	lw.samples = append(lw.samples, latencySample{At: t, Latency: d})
+}
+
+// medianSince returns the median latency of samples after since. The
+// median keeps a few long-retried messages from dominating.
+func (lw *latencyWindow) medianSince(since time.Time) time.Duration {
+	var ds []time.Duration
+	for _, s := range lw.samples {
+		if s.At.After(since) {
+			ds = append(ds, s.Latency)
+		}
+	}
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)/2]
+}
+
+func (lw *latencyWindow) prune(before time.Time) {
+	pruned := lw.samples[:0]
+	for _, s := range lw.samples {
+		if s.At.After(before) {
+			pruned = append(pruned, s)
+		}
+	}
+	lw.samples = pruned
+}
+
 type dsnSample struct {
 	Code       string
 	Diagnostic string
@@ -96,6 +146,10 @@ type SignalSnapshot struct {
 	ComplaintRate1h float64          `json:"complaint_rate_1h"`
 	DeferralRate5m float64           `json:"deferral_rate_5m"`
 	DeferralRate1h float64           `json:"deferral_rate_1h"`
+	// Connection-level failures (refused, reset, timed out) as a share of
+	// sent, and the median queued-to-accepted latency of deliveries.
+	RefusalRate5m     float64        `json:"refusal_rate_5m"`
+	AcceptLatencyMs5m int64          `json:"accept_latency_ms_5m"`
 	IPMetrics     map[string]IPMetric `json:"ip_metrics"`
 
 	// Raw counts for conviction micro-context
@@ -104,6 +158,7 @@ type SignalSnapshot struct {
 	Bounced1h     int     `json:"bounced_1h"`
 	Deferred5m    int     `json:"deferred_5m"`
 	Deferred1h    int     `json:"deferred_1h"`
+	Refused5m     int     `json:"refused_5m"`
 	Complaints1h  int     `json:"complaints_1h"`
 	Accepted1h    int     `json:"accepted_1h"`
 
@@ -131,6 +186,11 @@ type IPMetric struct {
 	Deferred5m   int `json:"deferred_5m"`
 	Complaints24h int `json:"complaints_24h"`
 	Accepted1h   int `json:"accepted_1h"`
+
+	// Five-minute inputs for the send-rate controller
+	Sent5m          int   `json:"sent_5m"`
+	Refused5m       int   `json:"refused_5m"`
+	AcceptLatencyMs int64 `json:"accept_latency_ms_5m"`
 }
 
 // NewSignalProcessor creates a processor for all ISPs.
@@ -140,6 +200,7 @@ func NewSignalProcessor(store SignalStore, orgID string, registry *ISPRegistry)
 		orgID:    orgID,
 		registry: registry,
 		windows:  make(map[ISP]*ISPSignalWindow),
+		now:      time.Now,
 	}
 	for _, isp := range AllISPs() {
 		sp.windows[isp] = newISPSignalWindow(isp)
@@ -154,6 +215,8 @@ func newISPSignalWindow(isp ISP) *ISPSignalWindow {
 		ipBounced:       make(map[string]*windowCounter),
 		ipComplaints:    make(map[string]*windowCounter),
 		ipDeferred:      make(map[string]*windowCounter),
+		ipRefused:       make(map[string]*windowCounter),
+		ipLatency:       make(map[string]*latencyWindow),
 		domainSent:      make(map[string]*windowCounter),
 		domainBounced:   make(map[string]*windowCounter),
 		domainDeferred:  make(map[string]*windowCounter),
@@ -161,9 +224,26 @@ func newISPSignalWindow(isp ISP) *ISPSignalWindow {
 		totalBounced:    newWindowCounter(),
 		totalComplaints: newWindowCounter(),
 		totalDeferred:   newWindowCounter(),
+		totalRefused:    newWindowCounter(),
+		latency:         &latencyWindow{},
 	}
 }
 
+// SetClock replaces the time source used to stamp ingested records and
+// compute windows. Replays set a virtual clock and call Tick themselves.
+func (sp *SignalProcessor) SetClock(now func() time.Time) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.now = now
+}
+
+func (sp *SignalProcessor) clock() time.Time {
+	sp.mu.RLock()
+	now := sp.now
+	sp.mu.RUnlock()
+	return now()
+}
+
 // Subscribe adds a listener for signal snapshots.
 func (sp *SignalProcessor) Subscribe(ch chan<- SignalSnapshot) {
 	sp.mu.Lock()
@@ -180,7 +260,7 @@ func (sp *SignalProcessor) Ingest(isp ISP, rec AccountingRecord) {
 		return
 	}
 
-	now := time.Now()
+	now := sp.clock()
 	ip := rec.SourceIP
 	domain := rec.Domain
 
@@ -203,6 +283,15 @@ func (sp *SignalProcessor) Ingest(isp ISP, rec AccountingRecord) {
 		if domain != "" {
 			ensureCounter(w.domainSent, domain).add(now)
 		}
+		if latency, ok := acceptLatency(rec); ok {
+			w.latency.add(now, latency)
+			if ip != "" {
+				if _, ok := w.ipLatency[ip]; !ok {
+					w.ipLatency[ip] = &latencyWindow{}
+				}
+				w.ipLatency[ip].add(now, latency)
+			}
+		}
 
 	case "b": // bounce
 		w.totalBounced.add(now)
@@ -232,6 +321,14 @@ func (sp *SignalProcessor) Ingest(isp ISP, rec AccountingRecord) {
 		}
 	}
 
+	if (rec.Type == "b" || rec.Type == "t" || rec.Type == "tq") &&
+		classifyBounce(rec).Category == smtputil.CategoryConnection {
+		w.totalRefused.add(now)
+		if ip != "" {
+			ensureCounter(w.ipRefused, ip).add(now)
+		}
+	}
+
 	// Track DSN codes for micro-context
 	if rec.DSNStatus != "" || rec.DSNDiag != "" {
 		w.recentDSNCodes = append(w.recentDSNCodes, dsnSample{
@@ -266,6 +363,40 @@ func (sp *SignalProcessor) Start(ctx context.Context) {
 	}()
 }
 
+// Tick computes and publishes one round of snapshots immediately.
+func (sp *SignalProcessor) Tick() {
+	sp.computeSnapshots()
+}
+
+// Prune drops events older than the longest window.
+func (sp *SignalProcessor) Prune() {
+	sp.pruneOldEvents()
+}
+
+// acceptLatency is how long a delivered message waited between being
+// queued and being accepted by the receiving MTA.
+func acceptLatency(rec AccountingRecord) (time.Duration, bool) {
+	queued, ok := parseAcctTime(rec.TimeQueued)
+	if !ok {
+		return 0, false
+	}
+	logged, ok := parseAcctTime(rec.DeliveryTime)
+	if !ok || logged.Before(queued) {
+		return 0, false
+	}
+	return logged.Sub(queued), true
+}
+
+// parseAcctTime accepts RFC 3339 and PMTA's "2006-01-02 15:04:05-0700".
+func parseAcctTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05-0700", "2006-01-02 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 func safeRate(numerator, denominator int) float64 {
 	if denominator == 0 {
 		return 0
@@ -298,7 +429,7 @@ func deriveIPStatus(bounceRate, deferralRate, complaintRate, score float64, sent
 }
 
 func (sp *SignalProcessor) computeSnapshots() {
-	now := time.Now()
+	now := sp.clock()
 	sp.mu.RLock()
 	defer sp.mu.RUnlock()
 
@@ -329,12 +460,17 @@ func (sp *SignalProcessor) computeSnapshots() {
 		snap.DeferralRate5m = safeRate(deferred5m, sent5m)
 		snap.DeferralRate1h = safeRate(deferred1h, sent1h)
 
+		refused5m := w.totalRefused.countSince(now.Add(-5 * time.Minute))
+		snap.RefusalRate5m = safeRate(refused5m, sent5m)
+		snap.AcceptLatencyMs5m = w.latency.medianSince(now.Add(-5 * time.Minute)).Milliseconds()
+
 		// Populate raw counts for conviction context
 		snap.Sent1h = sent1h
 		snap.Sent5m = sent5m
 		snap.Bounced1h = bounced1h
 		snap.Deferred5m = deferred5m
 		snap.Deferred1h = deferred1h
+		snap.Refused5m = refused5m
 		snap.Complaints1h = complaints1h
 		snap.Accepted1h = sent1h - bounced1h
 
@@ -368,6 +504,14 @@ func (sp *SignalProcessor) computeSnapshots() {
 			if dc, ok := w.ipDeferred[ip]; ok {
 				ipDeferred5m = dc.countSince(now.Add(-5 * time.Minute))
 			}
+			ipRefused5m := 0
+			if rc, ok := w.ipRefused[ip]; ok {
+				ipRefused5m = rc.countSince(now.Add(-5 * time.Minute))
+			}
+			var ipLatencyMs int64
+			if lw, ok := w.ipLatency[ip]; ok {
+				ipLatencyMs = lw.medianSince(now.Add(-5 * time.Minute)).Milliseconds()
+			}
 
 			br := safeRate(ipBounced1h, ipSent1h)
 			cr := safeRate(ipComplaints24h, ipSent1h)
@@ -392,6 +536,10 @@ func (sp *SignalProcessor) computeSnapshots() {
 				Deferred5m:    ipDeferred5m,
 				Complaints24h: ipComplaints24h,
 				Accepted1h:    ipSent1h - ipBounced1h,
+
+				Sent5m:          sentCtr.countSince(now.Add(-5 * time.Minute)),
+				Refused5m:       ipRefused5m,
+				AcceptLatencyMs: ipLatencyMs,
 			}
 		}
 
@@ -433,8 +581,9 @@ func (sp *SignalProcessor) persistSnapshot(snap SignalSnapshot) {
 }
 
 func (sp *SignalProcessor) pruneOldEvents() {
-	cutoff := time.Now().Add(-25 * time.Hour)
-	dsnCutoff := time.Now().Add(-10 * time.Minute)
+	now := sp.clock()
+	cutoff := now.Add(-25 * time.Hour)
+	dsnCutoff := now.Add(-10 * time.Minute)
 	sp.mu.RLock()
 	defer sp.mu.RUnlock()
 	for _, w := range sp.windows {
@@ -443,10 +592,14 @@ func (sp *SignalProcessor) pruneOldEvents() {
 		w.totalBounced.prune(cutoff)
 		w.totalComplaints.prune(cutoff)
 		w.totalDeferred.prune(cutoff)
+		w.totalRefused.prune(cutoff)
+		w.latency.prune(dsnCutoff)
 		for _, c := range w.ipSent { c.prune(cutoff) }
 		for _, c := range w.ipBounced { c.prune(cutoff) }
 		for _, c := range w.ipComplaints { c.prune(cutoff) }
 		for _, c := range w.ipDeferred { c.prune(cutoff) }
+		for _, c := range w.ipRefused { c.prune(cutoff) }
+		for _, c := range w.ipLatency { c.prune(dsnCutoff) }
 		for _, c := range w.domainSent { c.prune(cutoff) }
 		for _, c := range w.domainBounced { c.prune(cutoff) }
 		for _, c := range w.domainDeferred { c.prune(cutoff) }
@@ -465,7 +618,7 @@ func (sp *SignalProcessor) pruneOldEvents() {
 
 // GetSnapshot returns the current signal snapshot for an ISP.
 func (sp *SignalProcessor) GetSnapshot(isp ISP) SignalSnapshot {
-	now := time.Now()
+	now := sp.clock()
 	sp.mu.RLock()
 	w, ok := sp.windows[isp]
 	sp.mu.RUnlock()
@@ -117,6 +117,7 @@ type Rule struct {
 	CooldownSeconds int             `json:"cooldown_seconds" db:"cooldown_seconds"`
 	Priority        int             `json:"priority" db:"priority"`
 	Enabled         bool            `json:"enabled" db:"enabled"`
+	Mode            string          `json:"mode" db:"mode"` // live or shadow; see ShadowRuleEvaluator
 	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time       `json:"updated_at" db:"updated_at"`
 }
@@ -138,6 +139,12 @@ type Decision struct {
 	RevertReason   string          `json:"revert_reason,omitempty" db:"revert_reason"`
 	S3DecisionKey  string          `json:"s3_decision_key,omitempty" db:"s3_decision_key"`
 	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+
+	// ExpiresAt and RevertCondition make a decision temporary. The
+	// orchestrator executes the inverse action once the expiry passes or the
+	// condition has held for its sustain window, whichever comes first.
+	ExpiresAt       *time.Time       `json:"expires_at,omitempty" db:"expires_at"`
+	RevertCondition *RevertCondition `json:"revert_condition,omitempty" db:"revert_condition"`
 }
 
 // AgentState represents the persisted state of one agent instance.
@@ -152,6 +159,7 @@ type AgentState struct {
 	CurrentActions json.RawMessage `json:"current_actions" db:"current_actions"`
 	ErrorMessage   string          `json:"error_message,omitempty" db:"error_message"`
 	S3StateKey     string          `json:"s3_state_key,omitempty" db:"s3_state_key"`
+	Shadow         bool            `json:"shadow" db:"shadow"`
 	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time       `json:"updated_at" db:"updated_at"`
 }
@@ -223,8 +231,11 @@ type AccountingRecord struct {
 	TLS          string `json:"tls"`
 	Size         int64  `json:"size"`
 	DeliveryTime string `json:"time_logged"`
+	TimeQueued   string `json:"time_queued"`
 	FeedbackType string `json:"feedback_type"`
 	JobID        string `json:"job_id"`
+	MessageID    string `json:"message_id"`
+	Node         string `json:"node,omitempty"` // PMTA node the record came from
 }
 
 // UnmarshalJSON handles both forwarder-style and legacy field names.
@@ -258,8 +269,11 @@ func (r *AccountingRecord) UnmarshalJSON(data []byte) error {
 	r.DestIP = str("dest_ip", "dlvDestIp", "dlvDestinationIp")
 	r.TLS = str("tls", "dlvTlsProtocol")
 	r.DeliveryTime = str("time_logged", "dlvStamp", "timeLogged")
+	r.TimeQueued = str("time_queued", "timeQueued")
 	r.FeedbackType = str("feedback_type", "fbType", "feedbackType")
 	r.JobID = str("job_id", "jobId")
+	r.MessageID = str("message_id", "header_Message-Id", "header_message-id", "messageId")
+	r.Node = str("node", "pmta_node")
 
 	if v, ok := raw["size"]; ok {
 		switch s := v.(type) {
@@ -342,6 +356,15 @@ type IncidentReport struct {
 	DSNSamples      []string        `json:"dsn_samples"`
 	ActionsTaken    []string        `json:"actions_taken"`
 	Status          string          `json:"status"`
+
+	// Alert delivery and acknowledgement. AlertKey is the dedup key that
+	// grouped repeat alerts into this incident.
+	AlertKey       string     `json:"alert_key,omitempty"`
+	Severity       string     `json:"severity,omitempty"`
+	AlertCount     int        `json:"alert_count,omitempty"`
+	LastAlertAt    *time.Time `json:"last_alert_at,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
 }
 
 // ---------------------------------------------------------------------------
@@ -0,0 +1,338 @@
+package espevents
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func post(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+}
+
+func signedPost(secret, body string, ts time.Time) *http.Request {
+	r := post(body)
+	r.Header.Set(SignatureTimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+	r.Header.Set(SignatureHeader, SignBody(secret, ts, []byte(body)))
+	return r
+}
+
+func TestSparkPost_VerifyAndParse(t *testing.T) {
+	a := NewSparkPostAdapter("hook", "s3cret")
+	body := `[
+		{"msys":{"message_event":{"type":"bounce","event_id":"e1","rcpt_to":"User@Example.com","message_id":"m1",
+			"bounce_class":"10","raw_reason":"550 5.1.1 unknown user","error_code":"550","sending_ip":"1.2.3.4",
+			"timestamp":"1700000000","rcpt_meta":{"campaign_id":"c1","subscriber_id":"s1"}}}},
+		{"msys":{"message_event":{"type":"delivery","event_id":"e2","rcpt_to":"b@example.com","message_id":"m2","timestamp":"1700000000"}}},
+		{"msys":{"track_event":{"type":"open","event_id":"e3","rcpt_to":"b@example.com"}}}
+	]`
+
+	r := post(body)
+	assert.ErrorIs(t, a.Verify(r, []byte(body)), ErrBadSignature)
+	r.SetBasicAuth("hook", "wrong")
+	assert.ErrorIs(t, a.Verify(r, []byte(body)), ErrBadSignature)
+	r.SetBasicAuth("hook", "s3cret")
+	require.NoError(t, a.Verify(r, []byte(body)))
+
+	events, err := a.Parse(r, []byte(body))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	e := events[0]
+	assert.Equal(t, "sparkpost:e1", e.Key)
+	assert.Equal(t, EventBounced, e.Type)
+	assert.Equal(t, "user@example.com", e.Recipient)
+	assert.Equal(t, "c1", e.CampaignID)
+	assert.Equal(t, "s1", e.SubscriberID)
+	assert.Equal(t, "bad-mailbox", e.MTACategory)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), e.Timestamp)
+	assert.Equal(t, EventDelivered, events[1].Type)
+}
+
+// snsTestSigner signs SNS messages with a self-signed certificate.
+type snsTestSigner struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newSNSTestSigner(t *testing.T) *snsTestSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &snsTestSigner{key: key, cert: cert}
+}
+
+func (s *snsTestSigner) sign(t *testing.T, m *snsEnvelope) string {
+	m.SignatureVersion = "2"
+	m.SigningCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+	digest := sha256.Sum256([]byte(m.stringToSign()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	m.Signature = base64.StdEncoding.EncodeToString(sig)
+	body, err := json.Marshal(m)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestSES_VerifyAndParse(t *testing.T) {
+	signer := newSNSTestSigner(t)
+	a := NewSESAdapter("arn:aws:sns:us-east-1:123:ses-events")
+	fetches := 0
+	a.fetchCert = func(string) (*x509.Certificate, error) {
+		fetches++
+		return signer.cert, nil
+	}
+
+	msg := `{"notificationType":"Bounce","mail":{"messageId":"ses-1","sourceIp":"5.6.7.8","tags":{"campaign_id":["c1"]}},
+		"bounce":{"bounceType":"Permanent","bounceSubType":"General",
+		"bouncedRecipients":[{"emailAddress":"A@example.com","status":"5.1.1","diagnosticCode":"smtp; 550 5.1.1 no such user"},
+		{"emailAddress":"b@example.com","status":"5.1.1"}]}}`
+	env := &snsEnvelope{
+		Type:      "Notification",
+		MessageID: "sns-1",
+		TopicArn:  "arn:aws:sns:us-east-1:123:ses-events",
+		Message:   msg,
+		Timestamp: "2026-01-02T03:04:05Z",
+	}
+	body := signer.sign(t, env)
+
+	require.NoError(t, a.Verify(post(body), []byte(body)))
+	require.NoError(t, a.Verify(post(body), []byte(body)))
+	assert.Equal(t, 1, fetches, "signing certificate is cached")
+
+	tampered := strings.Replace(body, "no such user", "no such usr", 1)
+	assert.ErrorIs(t, a.Verify(post(tampered), []byte(tampered)), ErrBadSignature)
+
+	other := *env
+	other.TopicArn = "arn:aws:sns:us-east-1:999:other"
+	otherBody := signer.sign(t, &other)
+	assert.ErrorIs(t, a.Verify(post(otherBody), []byte(otherBody)), ErrBadSignature)
+
+	events, err := a.Parse(post(body), []byte(body))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "ses:sns-1:a@example.com", events[0].Key)
+	assert.Equal(t, "ses:sns-1:b@example.com", events[1].Key)
+	assert.Equal(t, EventBounced, events[0].Type)
+	assert.Equal(t, "ses-1", events[0].MessageID)
+	assert.Equal(t, "c1", events[0].CampaignID)
+	assert.Equal(t, "bad-mailbox", events[0].MTACategory)
+	assert.Equal(t, "5.6.7.8", events[0].SourceIP)
+}
+
+func TestSES_RejectsForeignCertURL(t *testing.T) {
+	signer := newSNSTestSigner(t)
+	a := NewSESAdapter("t")
+	a.fetchCert = func(string) (*x509.Certificate, error) { return signer.cert, nil }
+
+	env := &snsEnvelope{Type: "Notification", MessageID: "x", TopicArn: "t", Message: "{}", Timestamp: "2026-01-02T03:04:05Z"}
+	signer.sign(t, env)
+	env.SigningCertURL = "https://sns.attacker.example/cert.pem"
+	body, _ := json.Marshal(env)
+	assert.ErrorIs(t, a.Verify(post(string(body)), body), ErrBadSignature)
+}
+
+func TestSES_RequiresAllowedTopic(t *testing.T) {
+	signer := newSNSTestSigner(t)
+	a := NewSESAdapter()
+	a.fetchCert = func(string) (*x509.Certificate, error) { return signer.cert, nil }
+
+	env := &snsEnvelope{Type: "Notification", MessageID: "x", TopicArn: "t", Message: "{}", Timestamp: "2026-01-02T03:04:05Z"}
+	body := signer.sign(t, env)
+	assert.ErrorIs(t, a.Verify(post(body), []byte(body)), ErrBadSignature, "no allowed topics accepts nothing")
+
+	confirmed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { confirmed = true }))
+	defer srv.Close()
+	a = NewSESAdapter("arn:aws:sns:us-east-1:123:ses-events")
+	sub := snsEnvelope{Type: "SubscriptionConfirmation", TopicArn: "arn:aws:sns:us-east-1:999:other", SubscribeURL: srv.URL}
+	subBody, _ := json.Marshal(sub)
+	_, err := a.Parse(post(string(subBody)), subBody)
+	assert.ErrorContains(t, err, "not allowed")
+	assert.False(t, confirmed, "subscriptions to unlisted topics are not confirmed")
+}
+
+func mailgunBody(key, event string, ts time.Time) string {
+	tsStr := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(tsStr + "tok"))
+	return `{"signature":{"timestamp":"` + tsStr + `","token":"tok","signature":"` + hex.EncodeToString(mac.Sum(nil)) + `"},
+		"event-data":{"id":"mg-1","event":"` + event + `","severity":"permanent","recipient":"a@example.com","timestamp":1700000000.5,
+		"message":{"headers":{"message-id":"abc@mg.example.com"}},
+		"delivery-status":{"code":550,"enhanced-code":"5.1.1","message":"mailbox unavailable"},
+		"user-variables":{"campaign_id":"c1","subscriber_id":"s1"}}}`
+}
+
+func TestMailgun_VerifyAndParse(t *testing.T) {
+	a := NewMailgunAdapter("key")
+	body := mailgunBody("key", "failed", time.Now())
+	require.NoError(t, a.Verify(post(body), []byte(body)))
+
+	forged := mailgunBody("other", "failed", time.Now())
+	assert.ErrorIs(t, a.Verify(post(forged), []byte(forged)), ErrBadSignature)
+	stale := mailgunBody("key", "failed", time.Now().Add(-time.Hour))
+	assert.ErrorIs(t, a.Verify(post(stale), []byte(stale)), ErrBadSignature)
+
+	events, err := a.Parse(post(body), []byte(body))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	e := events[0]
+	assert.Equal(t, "mailgun:mg-1", e.Key)
+	assert.Equal(t, EventBounced, e.Type)
+	assert.Equal(t, "abc@mg.example.com", e.MessageID)
+	assert.Equal(t, "550 5.1.1", e.DSNStatus)
+	assert.Equal(t, "c1", e.CampaignID)
+
+	opened := mailgunBody("key", "opened", time.Now())
+	events, err = a.Parse(post(opened), []byte(opened))
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestSendGrid_VerifyAndParse(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	a, err := NewSendGridAdapter(base64.StdEncoding.EncodeToString(der))
+	require.NoError(t, err)
+
+	body := `[{"event":"bounce","email":"a@example.com","sg_event_id":"sg-1","sg_message_id":"14c5d75ce93.dfd.64b469.filter0001.16648.5515E0B88.0",
+		"timestamp":1700000000,"status":"5.1.1","reason":"550 unknown user","type":"bounce","campaign_id":"c1"},
+		{"event":"spamreport","email":"b@example.com","sg_event_id":"sg-2","timestamp":1700000000},
+		{"event":"open","email":"b@example.com","sg_event_id":"sg-3"}]`
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	digest := sha256.Sum256([]byte(ts + body))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+
+	r := post(body)
+	r.Header.Set(sendGridTimestampHeader, ts)
+	r.Header.Set(sendGridSignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	require.NoError(t, a.Verify(r, []byte(body)))
+	assert.ErrorIs(t, a.Verify(r, []byte(body+" ")), ErrBadSignature)
+
+	events, err := a.Parse(r, []byte(body))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "sendgrid:sg-1", events[0].Key)
+	assert.Equal(t, "14c5d75ce93", events[0].MessageID)
+	assert.Equal(t, EventBounced, events[0].Type)
+	assert.Equal(t, EventComplained, events[1].Type)
+
+	_, err = NewSendGridAdapter("not-a-key")
+	assert.Error(t, err)
+}
+
+func TestPMTA_VerifyAndParse(t *testing.T) {
+	a := NewPMTAAdapter("secret")
+
+	body := `[{"type":"b","recipient":"A@gmail.com","job_id":"c1","dsn_status":"5.1.1","dsn_diag":"550 5.1.1 no such user","bounce_cat":"bad-mailbox","source_ip":"10.0.0.1"},
+		{"type":"d","recipient":"b@gmail.com","job_id":"c1","header_Message-Id":"<k1@news.example.com>"},
+		{"type":"r","recipient":"c@gmail.com"}]`
+	r := signedPost("secret", body, time.Now())
+	r.Header.Set("X-PMTA-Node", "pmta-2")
+	require.NoError(t, a.Verify(r, []byte(body)))
+
+	assert.ErrorIs(t, a.Verify(signedPost("other", body, time.Now()), []byte(body)), ErrBadSignature)
+	assert.ErrorIs(t, a.Verify(signedPost("secret", body, time.Now().Add(-time.Hour)), []byte(body)), ErrBadSignature)
+	assert.ErrorIs(t, NewPMTAAdapter("").Verify(r, []byte(body)), ErrBadSignature)
+
+	events, err := a.Parse(r, []byte(body))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "pmta-2", events[0].Node)
+	require.NotNil(t, events[0].Record, "events carry their record for signal processing")
+	assert.Equal(t, "pmta-2", events[0].Record.Node)
+	assert.Equal(t, "b", events[0].Record.Type)
+	assert.Equal(t, "d", events[1].Record.Type)
+	assert.Equal(t, EventBounced, events[0].Type)
+	assert.Equal(t, "a@gmail.com", events[0].Recipient)
+	assert.Equal(t, "c1", events[0].CampaignID)
+	assert.Equal(t, "bad-mailbox", events[0].MTACategory)
+	assert.Equal(t, "k1@news.example.com", events[1].MessageID)
+
+	again, err := a.Parse(r, []byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, events[0].Key, again[0].Key, "keys are stable across redeliveries")
+	assert.NotEqual(t, events[0].Key, events[1].Key)
+}
+
+const testARF = "From: feedback@yahoo.com\r\n" +
+	"To: fbl@example.com\r\n" +
+	"Subject: FW: Weekly deals\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=feedback-report; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"This is an email abuse report.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/feedback-report\r\n" +
+	"\r\n" +
+	"Feedback-Type: abuse\r\n" +
+	"User-Agent: Yahoo!-Mail-Feedback/2.0\r\n" +
+	"Version: 1\r\n" +
+	"Original-Rcpt-To: <User@yahoo.com>\r\n" +
+	"Source-IP: 10.0.0.1\r\n" +
+	"Reported-Domain: news.example.com\r\n" +
+	"Arrival-Date: Thu, 8 Oct 2026 10:00:00 +0000\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"Message-ID: <1f2e@news.example.com>\r\n" +
+	"X-Campaign-ID: c1\r\n" +
+	"X-Subscriber-ID: s1\r\n" +
+	"To: user@yahoo.com\r\n" +
+	"--b1--\r\n"
+
+func TestARF_VerifyAndParse(t *testing.T) {
+	a := NewARFAdapter("secret")
+	r := signedPost("secret", testARF, time.Now())
+	require.NoError(t, a.Verify(r, []byte(testARF)))
+
+	events, err := a.Parse(r, []byte(testARF))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	e := events[0]
+	assert.Equal(t, EventComplained, e.Type)
+	assert.Equal(t, "user@yahoo.com", e.Recipient)
+	assert.Equal(t, "1f2e@news.example.com", e.MessageID)
+	assert.Equal(t, "c1", e.CampaignID)
+	assert.Equal(t, "s1", e.SubscriberID)
+	assert.Equal(t, "abuse", e.FeedbackType)
+	assert.Equal(t, "news.example.com", e.SendingDomain)
+	assert.Equal(t, "yahoo.com", e.ReportingDomain)
+	assert.Equal(t, "10.0.0.1", e.SourceIP)
+
+	_, err = a.Parse(post("From: x\r\n\r\nhello"), []byte("From: x\r\n\r\nhello"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,345 @@
+package espevents
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotARF is returned for messages that are not a parseable feedback
+// report, such as bounces or replies landing in an FBL mailbox. Retrying
+// them is pointless.
+var ErrNotARF = errors.New("not an ARF feedback report")
+
+// ARFReport is an RFC 5965 feedback report together with what we recover
+// from the reported message.
+type ARFReport struct {
+	// Machine-readable part (message/feedback-report).
+	FeedbackType     string // abuse, fraud, virus, other, not-spam, auth-failure
+	UserAgent        string
+	Version          string
+	OriginalMailFrom string
+	OriginalRcptTo   string
+	ArrivalDate      time.Time
+	ReportingMTA     string
+	SourceIP         string
+	ReportedDomain   string
+	Incidents        int
+
+	// ReportedBy is the organizational domain of the report's sender,
+	// i.e. the ISP running the feedback loop.
+	ReportedBy string
+
+	// Recovered from the returned message or headers. ISPs redact to
+	// varying degrees; Feedback-ID carries the IDs when X- headers are
+	// stripped.
+	MessageID     string // Message-ID, as logged in mailing_message_log
+	CampaignID    string
+	SubscriberID  string
+	EmailID       string // X-Message-ID: the send queue item
+	SendingDomain string // DKIM d= of the reported message, else its From domain
+
+	// Recipient is the complaining address, or empty if the ISP redacted it.
+	Recipient string
+}
+
+// IsComplaint reports whether the report is a spam complaint, as opposed to
+// a not-spam vote or an authentication failure report (RFC 6591).
+func (r *ARFReport) IsComplaint() bool {
+	switch r.FeedbackType {
+	case "abuse", "fraud", "virus", "other":
+		return true
+	}
+	return false
+}
+
+// ParseARF parses a complete feedback report message, as found in an FBL
+// mailbox.
+func ParseARF(raw []byte) (*ARFReport, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotARF, err)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotARF, err)
+	}
+	rep, err := ParseARFBody(msg.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, err
+	}
+	if addr, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		rep.ReportedBy = organizationalDomain(domainOf(addr.Address))
+	}
+	return rep, nil
+}
+
+// ParseARFBody parses the MIME body of a multipart/report message.
+func ParseARFBody(contentType string, body []byte) (*ARFReport, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("%w: content type %q", ErrNotARF, contentType)
+	}
+	if mediaType == "multipart/report" && params["report-type"] != "" && params["report-type"] != "feedback-report" {
+		return nil, fmt.Errorf("%w: report type %q", ErrNotARF, params["report-type"])
+	}
+
+	rep := &ARFReport{}
+	var found bool
+	var original *mail.Message
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotARF, err)
+		}
+		data, err := readPart(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotARF, err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/feedback-report":
+			if err := rep.readFeedbackReport(data); err != nil {
+				return nil, err
+			}
+			found = true
+		case "message/rfc822", "text/rfc822-headers", "message/rfc822-headers":
+			// Headers-only parts have no blank line; add one.
+			if msg, err := mail.ReadMessage(bytes.NewReader(append(data, "\r\n\r\n"...))); err == nil {
+				original = msg
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: no message/feedback-report part", ErrNotARF)
+	}
+	if original != nil {
+		rep.readOriginal(original.Header)
+	}
+
+	// A recipient field that is present but not an address was redacted on
+	// purpose; the original To is then redacted too, or a placeholder.
+	rep.Recipient = validAddress(rep.OriginalRcptTo)
+	if rep.OriginalRcptTo == "" && original != nil {
+		if addr, err := mail.ParseAddress(original.Header.Get("To")); err == nil {
+			rep.Recipient = validAddress(addr.Address)
+		}
+	}
+	if rep.SendingDomain == "" {
+		rep.SendingDomain = strings.ToLower(rep.ReportedDomain)
+	}
+	return rep, nil
+}
+
+// readPart returns a MIME part's content, undoing base64 transfer encoding.
+// multipart.Reader already undoes quoted-printable.
+func readPart(part *multipart.Part) ([]byte, error) {
+	data, err := io.ReadAll(part)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(data)))
+	}
+	return data, nil
+}
+
+func (rep *ARFReport) readFeedbackReport(data []byte) error {
+	// The report is a header block; make sure it is terminated.
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(bytes.TrimRight(data, "\r\n"), "\r\n\r\n"...))))
+	h, err := tp.ReadMIMEHeader()
+	if err != nil && len(h) == 0 {
+		return fmt.Errorf("%w: feedback report: %v", ErrNotARF, err)
+	}
+	rep.FeedbackType = strings.ToLower(strings.TrimSpace(h.Get("Feedback-Type")))
+	if rep.FeedbackType == "" {
+		return fmt.Errorf("%w: feedback report has no Feedback-Type", ErrNotARF)
+	}
+	rep.UserAgent = h.Get("User-Agent")
+	rep.Version = h.Get("Version")
+	rep.OriginalMailFrom = stripAngle(h.Get("Original-Mail-From"))
+	rep.OriginalRcptTo = stripAngle(h.Get("Original-Rcpt-To"))
+	if rep.OriginalRcptTo == "" {
+		rep.OriginalRcptTo = stripAngle(h.Get("Removal-Recipient"))
+	}
+	if t, err := mail.ParseDate(h.Get("Arrival-Date")); err == nil {
+		rep.ArrivalDate = t.UTC()
+	}
+	rep.ReportingMTA = h.Get("Reporting-MTA")
+	if _, host, ok := strings.Cut(rep.ReportingMTA, ";"); ok {
+		rep.ReportingMTA = strings.TrimSpace(host)
+	}
+	rep.SourceIP = strings.TrimSpace(h.Get("Source-IP"))
+	rep.ReportedDomain = strings.TrimSpace(h.Get("Reported-Domain"))
+	rep.Incidents, _ = strconv.Atoi(strings.TrimSpace(h.Get("Incidents")))
+	return nil
+}
+
+// receivedFromIP matches the connecting IP in a Received header's from clause.
+var receivedFromIP = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f.:]+)\]`)
+
+func (rep *ARFReport) readOriginal(h mail.Header) {
+	rep.MessageID = stripAngle(h.Get("Message-ID"))
+	rep.CampaignID = strings.TrimSpace(h.Get("X-Campaign-ID"))
+	rep.SubscriberID = strings.TrimSpace(h.Get("X-Subscriber-ID"))
+	rep.EmailID = strings.TrimSpace(h.Get("X-Message-ID"))
+
+	// Feedback-ID: campaign:subscriber:email:sending-domain, set by the
+	// PMTA sender for Gmail and kept by ISPs that strip X- headers.
+	if fid := strings.Split(h.Get("Feedback-ID"), ":"); len(fid) == 4 {
+		if rep.CampaignID == "" {
+			rep.CampaignID = strings.TrimSpace(fid[0])
+		}
+		if rep.SubscriberID == "" {
+			rep.SubscriberID = strings.TrimSpace(fid[1])
+		}
+		if rep.EmailID == "" {
+			rep.EmailID = strings.TrimSpace(fid[2])
+		}
+	}
+	if rep.CampaignID == "" {
+		rep.CampaignID = strings.TrimSpace(h.Get("X-Job"))
+	}
+
+	rep.SendingDomain = dkimDomain(h["Dkim-Signature"])
+	if rep.SendingDomain == "" {
+		if addr, err := mail.ParseAddress(h.Get("From")); err == nil {
+			rep.SendingDomain = domainOf(addr.Address)
+		}
+	}
+
+	// The topmost Received header was added by the ISP's MX and names the
+	// IP we connected from.
+	if rep.SourceIP == "" {
+		if received := h["Received"]; len(received) > 0 {
+			from, _, _ := strings.Cut(received[0], " by ")
+			if m := receivedFromIP.FindStringSubmatch(from); m != nil {
+				rep.SourceIP = m[1]
+			}
+		}
+	}
+}
+
+// dkimDomain returns the d= tag of the first DKIM signature.
+func dkimDomain(signatures []string) string {
+	for _, sig := range signatures {
+		for _, tag := range strings.Split(sig, ";") {
+			k, v, ok := strings.Cut(tag, "=")
+			if ok && strings.TrimSpace(k) == "d" {
+				return strings.ToLower(strings.TrimSpace(v))
+			}
+		}
+	}
+	return ""
+}
+
+// validAddress returns addr normalized, or empty if it is not an address.
+// ISPs that redact the recipient put placeholders or hashes here.
+func validAddress(addr string) string {
+	a, err := mail.ParseAddress(addr)
+	if err != nil || !strings.Contains(a.Address, "@") {
+		return ""
+	}
+	return normalizeEmail(a.Address)
+}
+
+func domainOf(addr string) string {
+	if at := strings.LastIndex(addr, "@"); at >= 0 {
+		return strings.ToLower(addr[at+1:])
+	}
+	return ""
+}
+
+// organizationalDomain trims a host to its last two labels, e.g.
+// arf.mail.yahoo.com to yahoo.com. Good enough for the feedback-loop
+// senders we deal with.
+func organizationalDomain(host string) string {
+	labels := strings.Split(strings.Trim(host, "."), ".")
+	if len(labels) <= 2 {
+		return strings.Join(labels, ".")
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+func stripAngle(s string) string {
+	return strings.Trim(strings.TrimSpace(s), "<>")
+}
+
+// arfEvent turns a complaint report into an event. Reports arriving over
+// HTTP and from a mailbox share the key, so one relayed both ways counts
+// once.
+func arfEvent(rep *ARFReport) Event {
+	ts := rep.ArrivalDate
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+	return Event{
+		Key: eventKey("arf", "", rep.Recipient, rep.MessageID, rep.EmailID,
+			rep.ArrivalDate.Format(time.RFC3339), rep.SourceIP, rep.FeedbackType),
+		Provider:        "arf",
+		Type:            EventComplained,
+		Recipient:       rep.Recipient,
+		MessageID:       rep.MessageID,
+		CampaignID:      rep.CampaignID,
+		SubscriberID:    rep.SubscriberID,
+		SourceIP:        rep.SourceIP,
+		SendingDomain:   rep.SendingDomain,
+		ReportingDomain: rep.ReportedBy,
+		FeedbackType:    rep.FeedbackType,
+		Timestamp:       ts,
+	}
+}
+
+// ARFAdapter handles Abuse Reporting Format (RFC 5965) complaint reports
+// relayed over HTTP by our FBL mailbox forwarder, signed like PMTA batches.
+// The body is either the whole report message or, with a multipart/report
+// Content-Type on the request, just its MIME body.
+type ARFAdapter struct {
+	secret string
+}
+
+func NewARFAdapter(secret string) *ARFAdapter {
+	return &ARFAdapter{secret: secret}
+}
+
+func (a *ARFAdapter) Provider() string { return "arf" }
+
+func (a *ARFAdapter) Verify(r *http.Request, body []byte) error {
+	return verifyHMAC(a.secret, r, body)
+}
+
+func (a *ARFAdapter) Parse(r *http.Request, body []byte) ([]Event, error) {
+	var rep *ARFReport
+	var err error
+	if contentType := r.Header.Get("Content-Type"); strings.HasPrefix(strings.ToLower(contentType), "multipart/") {
+		rep, err = ParseARFBody(contentType, body)
+	} else {
+		rep, err = ParseARF(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !rep.IsComplaint() {
+		return nil, nil
+	}
+	if rep.Recipient == "" && rep.MessageID == "" && rep.CampaignID == "" {
+		return nil, fmt.Errorf("%w: report identifies neither recipient nor message", ErrNotARF)
+	}
+	return []Event{arfEvent(rep)}, nil
+}
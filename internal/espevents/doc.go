@@ -0,0 +1,2 @@
+// Package espevents ingests delivery events (deliveries, bounces, complaints, unsubscribes) from SparkPost, SES/SNS, Mailgun, SendGrid, PMTA accounting and ARF feedback-loop reports. Each provider has an Adapter that verifies the webhook signature and normalizes the payload; a single Pipeline applies suppressions, subscriber quality scores and campaign counters exactly once per event. FBLPoller reads ARF reports from feedback-loop mailboxes (Maildir or IMAP) into the same pipeline.
+package espevents
@@ -0,0 +1,103 @@
+package espevents
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+)
+
+// EventType is the normalized kind of a delivery event.
+type EventType string
+
+const (
+	EventDelivered    EventType = "delivered"
+	EventBounced      EventType = "bounced"
+	EventDeferred     EventType = "deferred"
+	EventComplained   EventType = "complained"
+	EventUnsubscribed EventType = "unsubscribed"
+)
+
+// Event is one delivery outcome reported by an ESP, PMTA or a mailbox
+// provider's feedback loop, normalized across providers.
+type Event struct {
+	// Key identifies the event across redeliveries: the provider's event ID
+	// where it has one, otherwise a hash of the fields that describe it.
+	Key          string
+	Provider     string
+	Type         EventType
+	Recipient    string
+	MessageID    string // ESP message ID as stored in mailing_message_log
+	CampaignID   string
+	SubscriberID string
+
+	// Bounce details, passed to smtputil.ClassifyDSN. MTACategory is a
+	// PowerMTA bounce category; adapters map the provider's own bounce
+	// classes onto it.
+	DSNStatus   string
+	Diagnostic  string
+	MTACategory string
+
+	SourceIP        string
+	Node            string // PMTA node that handled the message, where known
+	SendingDomain   string // our domain the message was sent from, where known
+	ReportingDomain string // ISP domain that sent a complaint
+	FeedbackType    string // abuse, fraud, virus, other
+	Timestamp       time.Time
+
+	// Record is the PMTA accounting record the event was parsed from, for
+	// the engine's ISP signal processing once the event is applied.
+	Record *engine.AccountingRecord
+}
+
+// Adapter turns one provider's webhook into events.
+type Adapter interface {
+	// Provider is the name used in routes, receipts and suppression sources.
+	Provider() string
+	// Verify checks the request's signature over the raw body.
+	Verify(r *http.Request, body []byte) error
+	// Parse extracts the events from a verified request.
+	Parse(r *http.Request, body []byte) ([]Event, error)
+}
+
+// ErrBadSignature is returned by Verify for unsigned or mis-signed requests.
+var ErrBadSignature = errors.New("invalid webhook signature")
+
+// hashKey builds an event key from the fields that identify an event when
+// the provider does not supply an ID.
+func hashKey(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:16])
+}
+
+// eventKey prefixes a provider event ID, or a hash of the fallback fields
+// when the ID is empty, with the provider name.
+func eventKey(provider, id string, fallback ...string) string {
+	if id == "" {
+		id = hashKey(fallback...)
+	}
+	return provider + ":" + id
+}
+
+// metaString returns a string value from provider metadata.
+func metaString(meta map[string]interface{}, key string) string {
+	s, _ := meta[key].(string)
+	return s
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// unixTime converts provider timestamps in seconds to a time, defaulting to
+// now.
+func unixTime(sec float64) time.Time {
+	if sec <= 0 {
+		return time.Now().UTC()
+	}
+	return time.Unix(int64(sec), int64((sec-float64(int64(sec)))*1e9)).UTC()
+}
@@ -0,0 +1,135 @@
+package espevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mailbox is a feedback-loop mailbox that ISPs (Yahoo, Microsoft JMRP,
+// Comcast) mail their ARF reports to.
+type Mailbox interface {
+	Name() string
+	// Poll calls fn with each unread message. A nil result marks the
+	// message read; ErrNotARF sets it aside as unprocessable; any other
+	// error leaves it unread for the next poll.
+	Poll(ctx context.Context, fn func(raw []byte) error) error
+}
+
+// FBLPoller reads complaint reports from mailboxes into the pipeline.
+type FBLPoller struct {
+	pipeline  *Pipeline
+	mailboxes []Mailbox
+}
+
+func NewFBLPoller(pipeline *Pipeline, mailboxes ...Mailbox) *FBLPoller {
+	return &FBLPoller{pipeline: pipeline, mailboxes: mailboxes}
+}
+
+// Start polls every interval until ctx is cancelled.
+func (f *FBLPoller) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			f.PollOnce(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// PollOnce reads every mailbox once.
+func (f *FBLPoller) PollOnce(ctx context.Context) {
+	for _, mb := range f.mailboxes {
+		var complaints, skipped int
+		err := mb.Poll(ctx, func(raw []byte) error {
+			isNew, err := f.handle(ctx, raw)
+			if errors.Is(err, ErrNotARF) {
+				skipped++
+			} else if isNew {
+				complaints++
+			}
+			return err
+		})
+		if err != nil {
+			log.Printf("[ESPEvents] FBL mailbox %s: %v", mb.Name(), err)
+		}
+		if complaints > 0 || skipped > 0 {
+			log.Printf("[ESPEvents] FBL mailbox %s: %d complaints, %d messages set aside", mb.Name(), complaints, skipped)
+		}
+	}
+}
+
+func (f *FBLPoller) handle(ctx context.Context, raw []byte) (bool, error) {
+	rep, err := ParseARF(raw)
+	if err != nil {
+		return false, err
+	}
+	if !rep.IsComplaint() {
+		return false, nil
+	}
+	if rep.Recipient == "" && rep.MessageID == "" && rep.CampaignID == "" {
+		return false, fmt.Errorf("%w: report identifies neither recipient nor message", ErrNotARF)
+	}
+	return f.pipeline.Process(ctx, arfEvent(rep))
+}
+
+// Maildir is a local Maildir the FBL addresses are delivered to. Handled
+// messages move from new/ to cur/ with the Seen flag, unprocessable ones
+// with the Flagged flag for someone to look at.
+type Maildir struct {
+	dir string
+}
+
+func NewMaildir(dir string) *Maildir {
+	return &Maildir{dir: dir}
+}
+
+func (m *Maildir) Name() string { return "maildir:" + m.dir }
+
+func (m *Maildir) Poll(ctx context.Context, fn func(raw []byte) error) error {
+	entries, err := os.ReadDir(filepath.Join(m.dir, "new"))
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names) // delivery order
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		path := filepath.Join(m.dir, "new", name)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		flags := "S"
+		if err := fn(raw); errors.Is(err, ErrNotARF) {
+			log.Printf("[ESPEvents] %s: setting aside %s: %v", m.Name(), name, err)
+			flags = "FS"
+		} else if err != nil {
+			return err
+		}
+		base, _, _ := strings.Cut(name, ":")
+		if err := os.Rename(path, filepath.Join(m.dir, "cur", base+":2,"+flags)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package espevents
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// yahooARF builds a Yahoo-style report: recipient redacted, X- headers
+// stripped, the original headers base64 encoded.
+func yahooARF() string {
+	original := "Received: from mta3.news.example.com (mta3.news.example.com [203.0.113.7])\r\n" +
+		"\tby mta1042.mail.gq1.yahoo.com with ESMTPS; Thu, 8 Oct 2026 10:00:00 +0000\r\n" +
+		"Received: from localhost by mta3.news.example.com [127.0.0.1]\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=News.Example.com; s=s1;\r\n" +
+		"\th=from:to:subject; bh=abc=; b=def=\r\n" +
+		"From: Deals <deals@mail.example.net>\r\n" +
+		"To: redacted@yahoo.com\r\n" +
+		"Message-ID: <1f2e@news.example.com>\r\n" +
+		"Feedback-ID: c1:s1:e1:news.example.com\r\n"
+	enc := base64.StdEncoding.EncodeToString([]byte(original))
+
+	return "From: Yahoo! Mail AntiSpam Feedback <feedback@arf.mail.yahoo.com>\r\n" +
+		"To: fbl@example.com\r\n" +
+		"Subject: FW: Deals\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/report; report-type=feedback-report; boundary=\"b2\"\r\n" +
+		"\r\n" +
+		"--b2\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"This is an email abuse report.\r\n" +
+		"--b2\r\n" +
+		"Content-Type: message/feedback-report\r\n" +
+		"\r\n" +
+		"Feedback-Type: abuse\r\n" +
+		"User-Agent: Yahoo!-Mail-Feedback/2.0\r\n" +
+		"Version: 1\r\n" +
+		"Original-Mail-From: <bounces@news.example.com>\r\n" +
+		"Original-Rcpt-To: <redacted>\r\n" +
+		"Arrival-Date: Thu, 8 Oct 2026 10:00:00 +0000\r\n" +
+		"Reporting-MTA: dns; mta1042.mail.gq1.yahoo.com\r\n" +
+		"Incidents: 1\r\n" +
+		"--b2\r\n" +
+		"Content-Type: text/rfc822-headers\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		enc + "\r\n" +
+		"--b2--\r\n"
+}
+
+const testDSN = "From: MAILER-DAEMON@mx.example.org\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"d\"\r\n" +
+	"\r\n" +
+	"--d\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; a@example.org\r\n" +
+	"--d--\r\n"
+
+func TestParseARF_RecoversIDsAndAttribution(t *testing.T) {
+	rep, err := ParseARF([]byte(yahooARF()))
+	require.NoError(t, err)
+
+	assert.True(t, rep.IsComplaint())
+	assert.Equal(t, "abuse", rep.FeedbackType)
+	assert.Equal(t, "Yahoo!-Mail-Feedback/2.0", rep.UserAgent)
+	assert.Equal(t, "bounces@news.example.com", rep.OriginalMailFrom)
+	assert.Equal(t, "mta1042.mail.gq1.yahoo.com", rep.ReportingMTA)
+	assert.Equal(t, 1, rep.Incidents)
+	assert.Equal(t, time.Date(2026, 10, 8, 10, 0, 0, 0, time.UTC), rep.ArrivalDate)
+	assert.Equal(t, "yahoo.com", rep.ReportedBy)
+
+	assert.Equal(t, "", rep.Recipient, "redacted recipient is not an address")
+	assert.Equal(t, "1f2e@news.example.com", rep.MessageID)
+	assert.Equal(t, "c1", rep.CampaignID, "recovered from Feedback-ID")
+	assert.Equal(t, "s1", rep.SubscriberID)
+	assert.Equal(t, "e1", rep.EmailID)
+	assert.Equal(t, "news.example.com", rep.SendingDomain, "DKIM d= wins over From")
+	assert.Equal(t, "203.0.113.7", rep.SourceIP, "topmost Received header")
+}
+
+func TestParseARF_RejectsOtherMessages(t *testing.T) {
+	_, err := ParseARF([]byte(testDSN))
+	assert.ErrorIs(t, err, ErrNotARF)
+
+	_, err = ParseARF([]byte("From: someone@example.org\r\nSubject: unsubscribe me\r\n\r\nplease"))
+	assert.ErrorIs(t, err, ErrNotARF)
+
+	notSpam := strings.Replace(testARF, "Feedback-Type: abuse", "Feedback-Type: not-spam", 1)
+	rep, err := ParseARF([]byte(notSpam))
+	require.NoError(t, err)
+	assert.False(t, rep.IsComplaint())
+}
+
+func writeMaildir(t *testing.T, messages map[string]string) string {
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o755))
+	}
+	for name, body := range messages {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "new", name), []byte(body), 0o644))
+	}
+	return dir
+}
+
+func TestMaildir_Poll(t *testing.T) {
+	dir := writeMaildir(t, map[string]string{
+		"1700000001.a.host": "one",
+		"1700000002.b.host": "two",
+		"1700000003.c.host": "three",
+	})
+	m := NewMaildir(dir)
+
+	var seen []string
+	err := m.Poll(context.Background(), func(raw []byte) error {
+		seen = append(seen, string(raw))
+		switch string(raw) {
+		case "two":
+			return fmt.Errorf("%w: bounce", ErrNotARF)
+		case "three":
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"one", "two", "three"}, seen)
+
+	assert.FileExists(t, filepath.Join(dir, "cur", "1700000001.a.host:2,S"))
+	assert.FileExists(t, filepath.Join(dir, "cur", "1700000002.b.host:2,FS"))
+	assert.FileExists(t, filepath.Join(dir, "new", "1700000003.c.host"), "failed message is retried")
+}
+
+// fakeIMAP serves one mailbox over a pipe and records the commands it got.
+func fakeIMAP(t *testing.T, messages map[string]string) (*IMAPMailbox, *[]string) {
+	var commands []string
+	m := NewIMAPMailbox("imap.example.com:993", "fbl", `p"w`, "")
+	m.dial = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			r := bufio.NewReader(server)
+			fmt.Fprint(server, "* OK IMAP4rev1 ready\r\n")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\r\n")
+				tag, cmd, _ := strings.Cut(line, " ")
+				commands = append(commands, cmd)
+				switch {
+				case strings.HasPrefix(cmd, "LOGIN"):
+					if cmd != `LOGIN "fbl" "p\"w"` {
+						fmt.Fprintf(server, "%s NO authentication failed\r\n", tag)
+						continue
+					}
+				case strings.HasPrefix(cmd, "SELECT"):
+					fmt.Fprint(server, "* 2 EXISTS\r\n")
+				case cmd == "UID SEARCH UNSEEN":
+					fmt.Fprint(server, "* SEARCH 7 9\r\n")
+				case strings.HasPrefix(cmd, "UID FETCH"):
+					uid := strings.Fields(cmd)[2]
+					body := messages[uid]
+					fmt.Fprintf(server, "* 1 FETCH (UID %s BODY[] {%d}\r\n%s)\r\n", uid, len(body), body)
+				case cmd == "LOGOUT":
+					fmt.Fprintf(server, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+					return
+				}
+				fmt.Fprintf(server, "%s OK done\r\n", tag)
+			}
+		}()
+		return client, nil
+	}
+	return m, &commands
+}
+
+func TestIMAPMailbox_Poll(t *testing.T) {
+	m, commands := fakeIMAP(t, map[string]string{"7": testARF, "9": testDSN})
+
+	var got []string
+	err := m.Poll(context.Background(), func(raw []byte) error {
+		got = append(got, string(raw))
+		_, err := ParseARF(raw)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{testARF, testDSN}, got, "literals are read intact")
+	assert.Equal(t, []string{
+		`LOGIN "fbl" "p\"w"`,
+		`SELECT "INBOX"`,
+		"UID SEARCH UNSEEN",
+		"UID FETCH 7 BODY.PEEK[]",
+		`UID STORE 7 +FLAGS.SILENT (\Seen)`,
+		"UID FETCH 9 BODY.PEEK[]",
+		`UID STORE 9 +FLAGS.SILENT (\Seen \Flagged)`,
+		"LOGOUT",
+	}, *commands)
+}
+
+func TestIMAPMailbox_LoginFailure(t *testing.T) {
+	m, _ := fakeIMAP(t, nil)
+	m.password = "wrong"
+	err := m.Poll(context.Background(), func([]byte) error { return nil })
+	assert.ErrorContains(t, err, "imap LOGIN: NO authentication failed")
+}
+
+func TestFBLPoller_FeedsPipeline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dir := writeMaildir(t, map[string]string{"1.a.host": testARF, "2.b.host": testDSN})
+	supp := &fakeSuppressor{}
+	p := NewPipeline(db)
+	p.SetSuppressor(supp)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").
+		WithArgs(arfEvent(mustParseARF(t, testARF)).Key, "arf", "complained").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM mailing_message_log").WillReturnRows(sqlmock.NewRows(logColumns))
+	mock.ExpectQuery("WHERE LOWER\\(email\\) = \\$1").WithArgs("user@yahoo.com").WillReturnRows(sqlmock.NewRows(logColumns))
+	mock.ExpectExec("UPDATE mailing_send_ledger").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	NewFBLPoller(p, NewMaildir(dir)).PollOnce(context.Background())
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []suppressCall{{"user@yahoo.com", "spam_complaint", "arf_fbl", ""}}, supp.calls)
+	assert.FileExists(t, filepath.Join(dir, "cur", "1.a.host:2,S"))
+	assert.FileExists(t, filepath.Join(dir, "cur", "2.b.host:2,FS"))
+}
+
+func mustParseARF(t *testing.T, raw string) *ARFReport {
+	rep, err := ParseARF([]byte(raw))
+	require.NoError(t, err)
+	return rep
+}
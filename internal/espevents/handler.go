@@ -0,0 +1,121 @@
+package espevents
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBodySize bounds a webhook request body. SES and SendGrid batch events
+// but stay well below this.
+const maxBodySize = 10 << 20
+
+// Handler receives provider webhooks at /{provider}, verifies them with the
+// provider's adapter and feeds the events into the pipeline.
+type Handler struct {
+	pipeline *Pipeline
+	adapters map[string]Adapter
+}
+
+func NewHandler(pipeline *Pipeline) *Handler {
+	return &Handler{pipeline: pipeline, adapters: make(map[string]Adapter)}
+}
+
+// Register adds an adapter, replacing any with the same provider name.
+func (h *Handler) Register(a Adapter) {
+	h.adapters[a.Provider()] = a
+}
+
+// Routes returns the webhook routes.
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Post("/{provider}", h.HandleWebhook)
+	return r
+}
+
+// Provider returns a handler for one provider's webhooks, for endpoints
+// that predate /{provider}. Until the provider is registered it answers 503,
+// so senders still posting there retry instead of dropping events.
+func (h *Handler) Provider(provider string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := h.adapters[provider]; !ok {
+			http.Error(w, provider+" webhooks are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		h.serve(w, r, provider)
+	}
+}
+
+// Registered reports whether an adapter is registered for provider.
+func (h *Handler) Registered(provider string) bool {
+	_, ok := h.adapters[provider]
+	return ok
+}
+
+// HandleWebhook answers 401 for bad signatures and 400 for unparseable
+// bodies, which providers should not retry. Any pipeline failure answers
+// 500 so the provider redelivers; events already applied are skipped then.
+func (h *Handler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, chi.URLParam(r, "provider"))
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, provider string) {
+	a, ok := h.adapters[provider]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		http.Error(w, "read error", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxBodySize {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := a.Verify(r, body); err != nil {
+		log.Printf("[ESPEvents] %s: rejected webhook from %s: %v", provider, r.RemoteAddr, err)
+		if errors.Is(err, ErrBadSignature) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "verification unavailable", http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	events, err := a.Parse(r, body)
+	if err != nil {
+		log.Printf("[ESPEvents] %s: %v", provider, err)
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var processed, duplicates int
+	for _, e := range events {
+		isNew, err := h.pipeline.Process(r.Context(), e)
+		if err != nil {
+			log.Printf("[ESPEvents] %s: %v", provider, err)
+			http.Error(w, "processing failed", http.StatusInternalServerError)
+			return
+		}
+		if isNew {
+			processed++
+		} else {
+			duplicates++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"received":   len(events),
+		"processed":  processed,
+		"duplicates": duplicates,
+	})
+}
@@ -0,0 +1,187 @@
+package espevents
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapTimeout bounds one poll of an IMAP mailbox.
+const imapTimeout = 5 * time.Minute
+
+// IMAPMailbox reads FBL reports from an IMAP mailbox over implicit TLS. It
+// speaks just enough IMAP4rev1 for that: unseen messages are fetched
+// without marking them, then flagged \Seen once handled, or \Seen and
+// \Flagged when set aside.
+type IMAPMailbox struct {
+	addr, user, password, mailbox string
+
+	// dial is swapped out in tests.
+	dial func(ctx context.Context) (net.Conn, error)
+}
+
+// NewIMAPMailbox connects to addr (host:993). mailbox defaults to INBOX.
+func NewIMAPMailbox(addr, user, password, mailbox string) *IMAPMailbox {
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	m := &IMAPMailbox{addr: addr, user: user, password: password, mailbox: mailbox}
+	m.dial = func(ctx context.Context) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		d := &tls.Dialer{Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	return m
+}
+
+func (m *IMAPMailbox) Name() string { return "imap:" + m.user + "@" + m.addr + "/" + m.mailbox }
+
+func (m *IMAPMailbox) Poll(ctx context.Context, fn func(raw []byte) error) error {
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(imapTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	c := &imapConn{r: bufio.NewReader(conn), w: conn}
+	greeting, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") && !strings.HasPrefix(greeting.text, "* PREAUTH") {
+		return fmt.Errorf("imap: unexpected greeting %q", greeting.text)
+	}
+	defer c.command("LOGOUT")
+
+	if _, err := c.command("LOGIN " + imapQuote(m.user) + " " + imapQuote(m.password)); err != nil {
+		return err
+	}
+	if _, err := c.command("SELECT " + imapQuote(m.mailbox)); err != nil {
+		return err
+	}
+	untagged, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return err
+	}
+	var uids []string
+	for _, l := range untagged {
+		if rest, ok := strings.CutPrefix(l.text, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+
+	for _, uid := range uids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		untagged, err := c.command("UID FETCH " + uid + " BODY.PEEK[]")
+		if err != nil {
+			return err
+		}
+		var raw []byte
+		for _, l := range untagged {
+			if strings.Contains(l.text, "FETCH") && len(l.literals) > 0 {
+				raw = l.literals[0]
+				break
+			}
+		}
+		if raw == nil {
+			continue // expunged meanwhile
+		}
+
+		flags := `(\Seen)`
+		if err := fn(raw); errors.Is(err, ErrNotARF) {
+			flags = `(\Seen \Flagged)`
+		} else if err != nil {
+			return err
+		}
+		if _, err := c.command("UID STORE " + uid + " +FLAGS.SILENT " + flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imapLine is one response line with the literals embedded in it.
+type imapLine struct {
+	text     string
+	literals [][]byte
+}
+
+type imapConn struct {
+	r   *bufio.Reader
+	w   io.Writer
+	tag int
+}
+
+// command sends a command and returns its untagged responses, or an error
+// unless the command completed OK.
+func (c *imapConn) command(cmd string) ([]imapLine, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	if _, err := fmt.Fprintf(c.w, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+	verb, _, _ := strings.Cut(cmd, " ")
+	var untagged []imapLine
+	for {
+		l, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		status, ok := strings.CutPrefix(l.text, tag+" ")
+		if !ok {
+			untagged = append(untagged, l)
+			continue
+		}
+		if !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("imap %s: %s", verb, status)
+		}
+		return untagged, nil
+	}
+}
+
+// readLine reads a response line, reading any {n} literals it announces.
+func (c *imapConn) readLine() (imapLine, error) {
+	var l imapLine
+	var b strings.Builder
+	for {
+		s, err := c.r.ReadString('\n')
+		if err != nil {
+			return l, err
+		}
+		s = strings.TrimRight(s, "\r\n")
+		b.WriteString(s)
+		open := strings.LastIndexByte(s, '{')
+		if open < 0 || !strings.HasSuffix(s, "}") {
+			l.text = b.String()
+			return l, nil
+		}
+		n, err := strconv.Atoi(s[open+1 : len(s)-1])
+		if err != nil {
+			l.text = b.String()
+			return l, nil
+		}
+		lit := make([]byte, n)
+		if _, err := io.ReadFull(c.r, lit); err != nil {
+			return l, err
+		}
+		l.literals = append(l.literals, lit)
+	}
+}
+
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,136 @@
+package espevents
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// mailgunTolerance bounds the age of a Mailgun signature timestamp.
+const mailgunTolerance = 15 * time.Minute
+
+// MailgunAdapter handles Mailgun JSON event webhooks, signed with the
+// account's webhook signing key.
+type MailgunAdapter struct {
+	signingKey string
+}
+
+func NewMailgunAdapter(signingKey string) *MailgunAdapter {
+	return &MailgunAdapter{signingKey: signingKey}
+}
+
+func (a *MailgunAdapter) Provider() string { return "mailgun" }
+
+type mailgunPayload struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData struct {
+		ID        string  `json:"id"`
+		Event     string  `json:"event"`
+		Severity  string  `json:"severity"`
+		Reason    string  `json:"reason"`
+		Recipient string  `json:"recipient"`
+		Timestamp float64 `json:"timestamp"`
+		Message   struct {
+			Headers struct {
+				MessageID string `json:"message-id"`
+			} `json:"headers"`
+		} `json:"message"`
+		DeliveryStatus struct {
+			Code         int    `json:"code"`
+			EnhancedCode string `json:"enhanced-code"`
+			Message      string `json:"message"`
+			Description  string `json:"description"`
+		} `json:"delivery-status"`
+		Envelope struct {
+			SendingIP string `json:"sending-ip"`
+		} `json:"envelope"`
+		UserVariables map[string]interface{} `json:"user-variables"`
+	} `json:"event-data"`
+}
+
+func (a *MailgunAdapter) Verify(r *http.Request, body []byte) error {
+	if a.signingKey == "" {
+		return fmt.Errorf("%w: no signing key configured", ErrBadSignature)
+	}
+	var p mailgunPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	sig := p.Signature
+	ts, err := strconv.ParseInt(sig.Timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", ErrBadSignature)
+	}
+	if err := checkFresh(time.Unix(ts, 0), mailgunTolerance); err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(a.signingKey))
+	mac.Write([]byte(sig.Timestamp + sig.Token))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(sig.Signature)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func (a *MailgunAdapter) Parse(r *http.Request, body []byte) ([]Event, error) {
+	var p mailgunPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("mailgun: %w", err)
+	}
+	d := p.EventData
+
+	var typ EventType
+	switch d.Event {
+	case "delivered":
+		typ = EventDelivered
+	case "failed":
+		typ = EventBounced
+		if d.Severity == "temporary" {
+			typ = EventDeferred
+		}
+	case "complained":
+		typ = EventComplained
+	case "unsubscribed":
+		typ = EventUnsubscribed
+	default:
+		return nil, nil
+	}
+
+	e := Event{
+		Key:          eventKey(a.Provider(), d.ID, d.Event, d.Message.Headers.MessageID, d.Recipient),
+		Provider:     a.Provider(),
+		Type:         typ,
+		Recipient:    normalizeEmail(d.Recipient),
+		MessageID:    d.Message.Headers.MessageID,
+		CampaignID:   metaString(d.UserVariables, "campaign_id"),
+		SubscriberID: metaString(d.UserVariables, "subscriber_id"),
+		SourceIP:     d.Envelope.SendingIP,
+		Timestamp:    unixTime(d.Timestamp),
+	}
+	if typ == EventBounced || typ == EventDeferred {
+		ds := d.DeliveryStatus
+		e.DSNStatus = ds.EnhancedCode
+		if ds.Code != 0 {
+			e.DSNStatus = strconv.Itoa(ds.Code) + " " + ds.EnhancedCode
+		}
+		e.Diagnostic = ds.Message
+		if ds.Description != "" {
+			e.Diagnostic += " " + ds.Description
+		}
+		// Mailgun drops mail to addresses that bounced before without an
+		// SMTP session; the reason says so.
+		if d.Reason == "suppress-bounce" {
+			e.MTACategory = "bad-mailbox"
+		}
+	}
+	return []Event{e}, nil
+}
@@ -0,0 +1,416 @@
+package espevents
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
+)
+
+// receiptRetention is how long event keys are kept for deduplication;
+// providers stop retrying long before.
+const receiptRetention = "30 days"
+
+// deliveredQualityFloor is the data_quality_score a delivery lifts an
+// address to: the "mx_valid" tier. Catch-all domains accept everything, so
+// a delivery alone does not verify the mailbox.
+const deliveredQualityFloor = 0.25
+
+// Suppressor is the global suppression list; engine.GlobalSuppressionHub
+// implements it. Suppress must be idempotent.
+type Suppressor interface {
+	Suppress(ctx context.Context, email, reason, source, isp, dsnCode, dsnDiag, sourceIP, campaign string) (bool, error)
+}
+
+// Pipeline applies normalized delivery events: it records the event,
+// updates campaign, sending IP and inbox profile counters, subscriber status
+// and data quality score, and suppresses hard bounces, complaints and unsubscribes. Each event key is
+// applied once; redeliveries are skipped.
+type Pipeline struct {
+	db         *sql.DB
+	suppressor Suppressor
+	observers  []func(Event, smtputil.Classification)
+
+	processed  int64
+	duplicates int64
+	failed     int64
+}
+
+func NewPipeline(db *sql.DB) *Pipeline {
+	return &Pipeline{db: db}
+}
+
+// SetSuppressor sets the global suppression list.
+func (p *Pipeline) SetSuppressor(s Suppressor) {
+	p.suppressor = s
+}
+
+// OnEvent registers a callback run after an event was applied for the
+// first time.
+func (p *Pipeline) OnEvent(fn func(Event, smtputil.Classification)) {
+	p.observers = append(p.observers, fn)
+}
+
+// target is the send an event belongs to.
+type target struct {
+	orgID        uuid.NullUUID
+	campaignID   uuid.NullUUID
+	subscriberID uuid.NullUUID
+	messageID    string
+	email        sql.NullString // the address sent to
+}
+
+// Process applies an event and reports whether it was new. All database
+// effects commit together with the event's receipt, so an event that fails
+// half way is retried in full and one that succeeded is never re-applied.
+func (p *Pipeline) Process(ctx context.Context, e Event) (bool, error) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	c := classify(e)
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO mailing_esp_event_receipts (event_key, provider, event_type)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (event_key) DO NOTHING
+	`, e.Key, e.Provider, string(e.Type))
+	if err != nil {
+		atomic.AddInt64(&p.failed, 1)
+		return false, fmt.Errorf("claim event %s: %w", e.Key, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		atomic.AddInt64(&p.duplicates, 1)
+		return false, nil
+	}
+
+	t, err := p.resolve(ctx, tx, e)
+	if err != nil {
+		atomic.AddInt64(&p.failed, 1)
+		return false, fmt.Errorf("resolve event %s: %w", e.Key, err)
+	}
+	// Feedback loops often redact the complaining address; the send log
+	// knows it.
+	if e.Recipient == "" {
+		e.Recipient = normalizeEmail(t.email.String)
+	}
+	if err := p.apply(ctx, tx, e, c, t); err != nil {
+		atomic.AddInt64(&p.failed, 1)
+		return false, fmt.Errorf("apply event %s: %w", e.Key, err)
+	}
+	if err := p.account(ctx, tx, e, t); err != nil {
+		atomic.AddInt64(&p.failed, 1)
+		return false, fmt.Errorf("account event %s: %w", e.Key, err)
+	}
+
+	// Suppression lives outside this transaction. It is idempotent, so it
+	// runs before the commit: a failure here leaves the event to be retried.
+	if reason := suppressionReason(e, c); reason != "" && p.suppressor != nil && e.Recipient != "" {
+		campaign := ""
+		if t.campaignID.Valid {
+			campaign = t.campaignID.UUID.String()
+		}
+		if _, err := p.suppressor.Suppress(ctx, e.Recipient, reason, e.Provider+"_"+suppressionSource(e.Type),
+			e.ReportingDomain, e.DSNStatus, e.Diagnostic, e.SourceIP, campaign); err != nil {
+			atomic.AddInt64(&p.failed, 1)
+			return false, fmt.Errorf("suppress event %s: %w", e.Key, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		atomic.AddInt64(&p.failed, 1)
+		return false, err
+	}
+	atomic.AddInt64(&p.processed, 1)
+
+	if t.campaignID.Valid && e.CampaignID == "" {
+		e.CampaignID = t.campaignID.UUID.String()
+	}
+	for _, fn := range p.observers {
+		fn(e, c)
+	}
+	return true, nil
+}
+
+// classify runs bounces and deferrals through the shared classifier.
+func classify(e Event) smtputil.Classification {
+	if e.Type != EventBounced && e.Type != EventDeferred {
+		return smtputil.Classification{}
+	}
+	return smtputil.ClassifyDSN(e.DSNStatus, e.Diagnostic, e.MTACategory)
+}
+
+// isHardBounce reports whether a bounce means the address is undeliverable.
+func isHardBounce(e Event, c smtputil.Classification) bool {
+	return e.Type == EventBounced && c.Action == smtputil.ActionSuppress
+}
+
+func suppressionReason(e Event, c smtputil.Classification) string {
+	switch {
+	case isHardBounce(e, c):
+		return "hard_bounce"
+	case e.Type == EventComplained:
+		return "spam_complaint"
+	case e.Type == EventUnsubscribed:
+		return "unsubscribe"
+	}
+	return ""
+}
+
+func suppressionSource(t EventType) string {
+	switch t {
+	case EventComplained:
+		return "fbl"
+	case EventUnsubscribed:
+		return "unsubscribe"
+	}
+	return "bounce"
+}
+
+// resolve finds the organization, campaign, subscriber and address of an
+// event: by the ESP message ID logged at send, then by the campaign and
+// subscriber IDs the provider echoed back, then by the last send to the
+// recipient.
+func (p *Pipeline) resolve(ctx context.Context, tx *sql.Tx, e Event) (target, error) {
+	var t target
+	if e.MessageID != "" {
+		err := tx.QueryRowContext(ctx, `
+			SELECT organization_id, campaign_id, subscriber_id, message_id, email
+			FROM mailing_message_log
+			WHERE message_id IN ($1, '<' || $1 || '>')
+			LIMIT 1
+		`, e.MessageID).Scan(&t.orgID, &t.campaignID, &t.subscriberID, &t.messageID, &t.email)
+		if err == nil {
+			return t, nil
+		}
+		if err != sql.ErrNoRows {
+			return t, err
+		}
+	}
+
+	if campaignID, err := uuid.Parse(e.CampaignID); err == nil {
+		var subscriberID uuid.NullUUID
+		if id, err := uuid.Parse(e.SubscriberID); err == nil {
+			subscriberID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+		err := tx.QueryRowContext(ctx, `
+			SELECT c.organization_id, c.id, s.id, s.email
+			FROM mailing_campaigns c
+			LEFT JOIN mailing_subscribers s ON s.organization_id = c.organization_id AND s.id = COALESCE($2::uuid,
+				(SELECT s2.id FROM mailing_subscribers s2 WHERE s2.list_id = c.list_id AND LOWER(s2.email) = $3 LIMIT 1))
+			WHERE c.id = $1
+		`, campaignID, subscriberID, e.Recipient).Scan(&t.orgID, &t.campaignID, &t.subscriberID, &t.email)
+		if err == nil {
+			return t, nil
+		}
+		if err != sql.ErrNoRows {
+			return t, err
+		}
+	}
+
+	if e.Recipient == "" {
+		return t, nil
+	}
+	err := tx.QueryRowContext(ctx, `
+		SELECT organization_id, campaign_id, subscriber_id, message_id, email
+		FROM mailing_message_log
+		WHERE LOWER(email) = $1
+		ORDER BY sent_at DESC LIMIT 1
+	`, e.Recipient).Scan(&t.orgID, &t.campaignID, &t.subscriberID, &t.messageID, &t.email)
+	if err != nil && err != sql.ErrNoRows {
+		return t, err
+	}
+	return t, nil
+}
+
+// apply writes the event's database effects.
+func (p *Pipeline) apply(ctx context.Context, tx *sql.Tx, e Event, c smtputil.Classification, t target) error {
+	hard := isHardBounce(e, c)
+
+	if t.orgID.Valid && t.campaignID.Valid {
+		var bounceType, bounceReason sql.NullString
+		if e.Type == EventBounced || e.Type == EventDeferred {
+			bounceType = sql.NullString{String: string(c.Type), Valid: true}
+			bounceReason = sql.NullString{String: strings.TrimSpace(e.DSNStatus + " " + e.Diagnostic), Valid: true}
+		}
+		recipientDomain := ""
+		if at := strings.LastIndex(e.Recipient, "@"); at >= 0 {
+			recipientDomain = e.Recipient[at+1:]
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type,
+				bounce_type, bounce_reason, event_at, sending_ip, recipient_domain, sending_domain, pmta_node)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, NULLIF($11, ''), NULLIF($12, ''))
+		`, uuid.New(), t.orgID, t.campaignID, t.subscriberID, string(e.Type),
+			bounceType, bounceReason, e.Timestamp, e.SourceIP, recipientDomain, e.SendingDomain, e.Node); err != nil {
+			return err
+		}
+
+		var counters []string
+		switch {
+		case e.Type == EventDelivered:
+			counters = []string{"delivered_count"}
+		case hard:
+			counters = []string{"bounce_count", "hard_bounce_count"}
+		case e.Type == EventBounced:
+			counters = []string{"bounce_count", "soft_bounce_count"}
+		case e.Type == EventComplained:
+			counters = []string{"complaint_count"}
+		case e.Type == EventUnsubscribed:
+			counters = []string{"unsubscribe_count"}
+		}
+		if len(counters) > 0 {
+			set := make([]string, len(counters))
+			for i, col := range counters {
+				set[i] = fmt.Sprintf("%s = COALESCE(%s, 0) + 1", col, col)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE mailing_campaigns SET `+strings.Join(set, ", ")+`, updated_at = NOW() WHERE id = $1`, t.campaignID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t.messageID != "" && (e.Type == EventDelivered || e.Type == EventBounced || e.Type == EventComplained) {
+		// Bounces and complaints are final; a complaint supersedes delivered.
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE mailing_message_log
+			SET status = $2,
+			    delivered_at = CASE WHEN $2 = 'delivered' THEN COALESCE(delivered_at, $3) ELSE delivered_at END
+			WHERE message_id = $1 AND status NOT IN ('bounced', 'complained')
+		`, t.messageID, string(e.Type), e.Timestamp); err != nil {
+			return err
+		}
+	}
+
+	if err := p.enrich(ctx, tx, e); err != nil {
+		return err
+	}
+
+	if !t.orgID.Valid || e.Recipient == "" {
+		return nil
+	}
+	// Address-level facts apply to every list the address is on.
+	sum := sha256.Sum256([]byte(e.Recipient))
+	hash := hex.EncodeToString(sum[:])
+	var query string
+	switch {
+	case hard:
+		query = `UPDATE mailing_subscribers SET status = CASE WHEN status IN ('confirmed', 'pending') THEN 'bounced' ELSE status END,
+			data_quality_score = 0, updated_at = NOW()
+			WHERE organization_id = $1 AND email_hash = $2`
+	case e.Type == EventComplained:
+		query = `UPDATE mailing_subscribers SET status = 'complained', updated_at = NOW()
+			WHERE organization_id = $1 AND email_hash = $2 AND status IN ('confirmed', 'pending', 'unsubscribed')`
+	case e.Type == EventUnsubscribed:
+		query = `UPDATE mailing_subscribers SET status = 'unsubscribed', unsubscribed_at = NOW(), updated_at = NOW()
+			WHERE organization_id = $1 AND email_hash = $2 AND status IN ('confirmed', 'pending')`
+	case e.Type == EventDelivered:
+		query = fmt.Sprintf(`UPDATE mailing_subscribers SET data_quality_score = %.2f, updated_at = NOW()
+			WHERE organization_id = $1 AND email_hash = $2 AND COALESCE(data_quality_score, 0) < %.2f`,
+			deliveredQualityFloor, deliveredQualityFloor)
+	default:
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, query, t.orgID, hash)
+	return err
+}
+
+// enrich counts deliveries and bounces on the sending IP and the
+// recipient's inbox profile.
+func (p *Pipeline) enrich(ctx context.Context, tx *sql.Tx, e Event) error {
+	var ipColumn, profileColumn, profileAt string
+	switch e.Type {
+	case EventDelivered:
+		ipColumn, profileColumn, profileAt = "total_delivered", "total_sent", "last_sent_at"
+	case EventBounced:
+		ipColumn, profileColumn, profileAt = "total_bounced", "total_bounces", "last_bounce_at"
+	default:
+		return nil
+	}
+
+	// PMTA falls back to the VMTA name when the source IP is unknown.
+	if net.ParseIP(e.SourceIP) != nil {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE mailing_ip_addresses SET %s = COALESCE(%s, 0) + 1, updated_at = NOW()
+			WHERE ip_address = $1::inet`, ipColumn, ipColumn), e.SourceIP); err != nil {
+			return err
+		}
+	}
+
+	at := strings.LastIndex(e.Recipient, "@")
+	if at < 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO mailing_inbox_profiles (id, email, domain, %[1]s, %[2]s, created_at, updated_at)
+		VALUES (gen_random_uuid(), $1, $2, 1, $3, NOW(), NOW())
+		ON CONFLICT (email) DO UPDATE SET %[1]s = COALESCE(mailing_inbox_profiles.%[1]s, 0) + 1, %[2]s = $3, updated_at = NOW()
+	`, profileColumn, profileAt), e.Recipient, e.Recipient[at+1:], e.Timestamp)
+	return err
+}
+
+// account stamps the send ledger entry an event belongs to, by Message-ID
+// or ESP message ID, or by campaign and subscriber while the send is still
+// submitting. Any event means the message was accepted, so the
+// QueueRecoveryWorker will not send it again.
+func (p *Pipeline) account(ctx context.Context, tx *sql.Tx, e Event, t target) error {
+	messageID := e.MessageID
+	if messageID == "" {
+		messageID = t.messageID
+	}
+	messageID = strings.Trim(messageID, "<>")
+	if messageID == "" && !(t.campaignID.Valid && t.subscriberID.Valid) {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE mailing_send_ledger
+		SET accounted_event = $4, accounted_at = COALESCE(accounted_at, $5), updated_at = NOW()
+		WHERE ($1 <> '' AND (message_id = $1 OR esp_message_id = $1))
+		   OR (state = 'submitting' AND campaign_id = $2 AND subscriber_id = $3)
+	`, messageID, t.campaignID, t.subscriberID, string(e.Type), e.Timestamp)
+	return err
+}
+
+// StartPruning deletes expired receipts hourly until ctx is cancelled.
+func (p *Pipeline) StartPruning(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				res, err := p.db.ExecContext(ctx, `DELETE FROM mailing_esp_event_receipts WHERE received_at < NOW() - $1::interval`, receiptRetention)
+				if err != nil {
+					log.Printf("[ESPEvents] pruning receipts: %v", err)
+				} else if n, _ := res.RowsAffected(); n > 0 {
+					log.Printf("[ESPEvents] pruned %d receipts", n)
+				}
+			}
+		}
+	}()
+}
+
+// Stats returns processing counters.
+func (p *Pipeline) Stats() map[string]int64 {
+	return map[string]int64{
+		"processed":  atomic.LoadInt64(&p.processed),
+		"duplicates": atomic.LoadInt64(&p.duplicates),
+		"failed":     atomic.LoadInt64(&p.failed),
+	}
+}
@@ -0,0 +1,310 @@
+package espevents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type suppressCall struct {
+	email, reason, source, campaign string
+}
+
+type fakeSuppressor struct {
+	calls []suppressCall
+	err   error
+}
+
+func (f *fakeSuppressor) Suppress(ctx context.Context, email, reason, source, isp, dsnCode, dsnDiag, sourceIP, campaign string) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	f.calls = append(f.calls, suppressCall{email, reason, source, campaign})
+	return true, nil
+}
+
+var logColumns = []string{"organization_id", "campaign_id", "subscriber_id", "message_id", "email"}
+
+func hardBounce() Event {
+	return Event{
+		Key:         "ses:sns-1:a@example.com",
+		Provider:    "ses",
+		Type:        EventBounced,
+		Recipient:   "a@example.com",
+		MessageID:   "ses-1",
+		DSNStatus:   "5.1.1",
+		Diagnostic:  "550 5.1.1 no such user",
+		MTACategory: "bad-mailbox",
+		Timestamp:   time.Now(),
+	}
+}
+
+func TestPipeline_ProcessesEventExactlyOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	orgID, campaignID, subscriberID := uuid.New(), uuid.New(), uuid.New()
+	supp := &fakeSuppressor{}
+	p := NewPipeline(db)
+	p.SetSuppressor(supp)
+	var observed []smtputil.Classification
+	p.OnEvent(func(e Event, c smtputil.Classification) { observed = append(observed, c) })
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").
+		WithArgs("ses:sns-1:a@example.com", "ses", "bounced").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM mailing_message_log").WithArgs("ses-1").
+		WillReturnRows(sqlmock.NewRows(logColumns).
+			AddRow(orgID, campaignID, subscriberID, "ses-1", "a@example.com"))
+	mock.ExpectExec("INSERT INTO mailing_tracking_events").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_campaigns SET bounce_count = COALESCE\\(bounce_count, 0\\) \\+ 1, hard_bounce_count").
+		WithArgs(uuid.NullUUID{UUID: campaignID, Valid: true}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_message_log").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO mailing_inbox_profiles(.|\n)*total_bounces").
+		WithArgs("a@example.com", "example.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_subscribers SET status = CASE").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE mailing_send_ledger").
+		WithArgs("ses-1", uuid.NullUUID{UUID: campaignID, Valid: true}, uuid.NullUUID{UUID: subscriberID, Valid: true},
+			"bounced", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// The provider retries the same notification.
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	isNew, err := p.Process(context.Background(), hardBounce())
+	require.NoError(t, err)
+	assert.True(t, isNew)
+
+	isNew, err = p.Process(context.Background(), hardBounce())
+	require.NoError(t, err)
+	assert.False(t, isNew)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, supp.calls, 1)
+	assert.Equal(t, suppressCall{"a@example.com", "hard_bounce", "ses_bounce", campaignID.String()}, supp.calls[0])
+	require.Len(t, observed, 1)
+	assert.Equal(t, smtputil.BounceHard, observed[0].Type)
+	assert.Equal(t, map[string]int64{"processed": 1, "duplicates": 1, "failed": 0}, p.Stats())
+}
+
+func TestPipeline_SoftBounceIsNotSuppressed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	orgID, campaignID := uuid.New(), uuid.New()
+	supp := &fakeSuppressor{}
+	p := NewPipeline(db)
+	p.SetSuppressor(supp)
+
+	e := hardBounce()
+	e.DSNStatus, e.Diagnostic, e.MTACategory = "4.2.2", "452 4.2.2 mailbox full", "quota-issues"
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM mailing_message_log").
+		WillReturnRows(sqlmock.NewRows(logColumns).
+			AddRow(orgID, campaignID, nil, "ses-1", "a@example.com"))
+	mock.ExpectExec("INSERT INTO mailing_tracking_events").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("soft_bounce_count").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_message_log").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO mailing_inbox_profiles").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_send_ledger").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	_, err = p.Process(context.Background(), e)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, supp.calls)
+}
+
+func TestPipeline_UnknownRecipientStillSuppressed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	supp := &fakeSuppressor{}
+	p := NewPipeline(db)
+	p.SetSuppressor(supp)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("WHERE LOWER\\(email\\) = \\$1").WithArgs("a@example.com").
+		WillReturnRows(sqlmock.NewRows(logColumns))
+	mock.ExpectCommit()
+
+	_, err = p.Process(context.Background(), Event{
+		Key: "arf:1", Provider: "arf", Type: EventComplained, Recipient: "a@example.com", CampaignID: "not-a-uuid",
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []suppressCall{{"a@example.com", "spam_complaint", "arf_fbl", ""}}, supp.calls)
+}
+
+func TestPipeline_RecoversRedactedRecipient(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	orgID, campaignID, subscriberID := uuid.New(), uuid.New(), uuid.New()
+	supp := &fakeSuppressor{}
+	p := NewPipeline(db)
+	p.SetSuppressor(supp)
+	var observed []Event
+	p.OnEvent(func(e Event, _ smtputil.Classification) { observed = append(observed, e) })
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM mailing_message_log").WithArgs("1f2e@news.example.com").
+		WillReturnRows(sqlmock.NewRows(logColumns).
+			AddRow(orgID, campaignID, subscriberID, "1f2e@news.example.com", "User@Yahoo.com"))
+	mock.ExpectExec("INSERT INTO mailing_tracking_events").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "complained",
+			nil, nil, sqlmock.AnyArg(), "10.0.0.1", "yahoo.com", "news.example.com", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("complaint_count").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_message_log").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_subscribers SET status = 'complained'").
+		WithArgs(uuid.NullUUID{UUID: orgID, Valid: true}, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_send_ledger").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err = p.Process(context.Background(), Event{
+		Key: "arf:2", Provider: "arf", Type: EventComplained, MessageID: "1f2e@news.example.com",
+		SourceIP: "10.0.0.1", SendingDomain: "news.example.com", ReportingDomain: "yahoo.com",
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []suppressCall{{"user@yahoo.com", "spam_complaint", "arf_fbl", campaignID.String()}}, supp.calls)
+	require.Len(t, observed, 1)
+	assert.Equal(t, "user@yahoo.com", observed[0].Recipient)
+	assert.Equal(t, campaignID.String(), observed[0].CampaignID)
+}
+
+func TestPipeline_PMTADeliveryCountsIPAndNode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	orgID, campaignID, subscriberID := uuid.New(), uuid.New(), uuid.New()
+	p := NewPipeline(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM mailing_message_log").
+		WillReturnRows(sqlmock.NewRows(logColumns).
+			AddRow(orgID, campaignID, subscriberID, "k1@news.example.com", "b@gmail.com"))
+	mock.ExpectExec("INSERT INTO mailing_tracking_events(.|\n)*pmta_node").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "delivered",
+			nil, nil, sqlmock.AnyArg(), "10.0.0.1", "gmail.com", "", "pmta-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("delivered_count").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_message_log").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_ip_addresses SET total_delivered").WithArgs("10.0.0.1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO mailing_inbox_profiles(.|\n)*total_sent").
+		WithArgs("b@gmail.com", "gmail.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_subscribers SET data_quality_score").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_send_ledger").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err = p.Process(context.Background(), Event{
+		Key: "pmta:1", Provider: "pmta", Type: EventDelivered, Recipient: "b@gmail.com",
+		MessageID: "k1@news.example.com", SourceIP: "10.0.0.1", Node: "pmta-2",
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPipeline_SuppressionFailureRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	p := NewPipeline(db)
+	p.SetSuppressor(&fakeSuppressor{err: errors.New("db down")})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM mailing_message_log").
+		WillReturnRows(sqlmock.NewRows(logColumns))
+	mock.ExpectQuery("WHERE LOWER\\(email\\) = \\$1").
+		WillReturnRows(sqlmock.NewRows(logColumns))
+	mock.ExpectRollback()
+
+	isNew, err := p.Process(context.Background(), hardBounce())
+	assert.Error(t, err)
+	assert.False(t, isNew)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, int64(1), p.Stats()["failed"])
+}
+
+func TestHandler_Webhook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	h := NewHandler(NewPipeline(db))
+	h.Register(NewPMTAAdapter("secret"))
+	router := chi.NewRouter()
+	router.Mount("/webhooks/esp", h.Routes())
+
+	body := `[{"type":"t","recipient":"a@gmail.com","dsn_status":"4.7.0","dsn_diag":"421 try later"}]`
+	send := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	at := func(path string, r *http.Request) *http.Request {
+		r.URL.Path = path
+		return r
+	}
+
+	w := send(at("/webhooks/esp/mailgun", signedPost("secret", body, time.Now())))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send(at("/webhooks/esp/pmta", signedPost("wrong", body, time.Now())))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+	w = send(at("/webhooks/esp/pmta", signedPost("secret", body, time.Now())))
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "failures are retried by the sender")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_esp_event_receipts").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	w = send(at("/webhooks/esp/pmta", signedPost("secret", body, time.Now())))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]int
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, map[string]int{"received": 1, "processed": 0, "duplicates": 1}, resp)
+
+	// Legacy endpoints go through the provider's adapter too.
+	router.Post("/engine/webhook", h.Provider("pmta"))
+	router.Post("/fbl/report", h.Provider("arf"))
+	w = send(at("/engine/webhook", signedPost("wrong", body, time.Now())))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = send(at("/fbl/report", signedPost("secret", body, time.Now())))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "unconfigured legacy senders retry")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
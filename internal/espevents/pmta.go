@@ -0,0 +1,97 @@
+package espevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/engine"
+)
+
+// PMTAAdapter handles PowerMTA accounting records posted by our accounting
+// forwarders, which sign each batch with the shared secret (see SignBody).
+// Each event carries its record so a Pipeline observer can pass new events
+// on to the engine Ingestor's ISP signal processing.
+type PMTAAdapter struct {
+	secret string
+}
+
+func NewPMTAAdapter(secret string) *PMTAAdapter {
+	return &PMTAAdapter{secret: secret}
+}
+
+func (a *PMTAAdapter) Provider() string { return "pmta" }
+
+func (a *PMTAAdapter) Verify(r *http.Request, body []byte) error {
+	return verifyHMAC(a.secret, r, body)
+}
+
+func (a *PMTAAdapter) Parse(r *http.Request, body []byte) ([]Event, error) {
+	var records []engine.AccountingRecord
+	if err := json.Unmarshal(body, &records); err != nil {
+		var single engine.AccountingRecord
+		if err2 := json.Unmarshal(body, &single); err2 != nil {
+			return nil, fmt.Errorf("pmta: %w", err)
+		}
+		records = []engine.AccountingRecord{single}
+	}
+
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		node = r.Header.Get("X-PMTA-Node")
+	}
+
+	var events []Event
+	for _, rec := range records {
+		if rec.Node == "" {
+			rec.Node = node
+		}
+
+		var typ EventType
+		switch rec.Type {
+		case "d":
+			typ = EventDelivered
+		case "b":
+			typ = EventBounced
+		case "t", "tq":
+			typ = EventDeferred
+		case "f":
+			typ = EventComplained
+		default:
+			continue
+		}
+		if rec.Recipient == "" {
+			continue
+		}
+
+		ts, _ := time.Parse(time.RFC3339, rec.DeliveryTime)
+		if ts.IsZero() {
+			ts = time.Now().UTC()
+		}
+		sourceIP := rec.SourceIP
+		if sourceIP == "" {
+			sourceIP = rec.VMTA
+		}
+		rec := rec
+		events = append(events, Event{
+			Key: eventKey(a.Provider(), "", rec.Type, rec.Recipient, rec.JobID, rec.DeliveryTime,
+				rec.DSNStatus, rec.DSNDiag, rec.SourceIP, rec.Node),
+			Provider:     a.Provider(),
+			Type:         typ,
+			Recipient:    normalizeEmail(rec.Recipient),
+			MessageID:    strings.Trim(rec.MessageID, "<> "),
+			CampaignID:   rec.JobID,
+			DSNStatus:    rec.DSNStatus,
+			Diagnostic:   rec.DSNDiag,
+			MTACategory:  rec.BounceCat,
+			SourceIP:     sourceIP,
+			Node:         rec.Node,
+			FeedbackType: rec.FeedbackType,
+			Timestamp:    ts,
+			Record:       &rec,
+		})
+	}
+	return events, nil
+}
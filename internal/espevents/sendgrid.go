@@ -0,0 +1,134 @@
+package espevents
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SendGrid Signed Event Webhook headers.
+const (
+	sendGridSignatureHeader = "X-Twilio-Email-Event-Webhook-Signature"
+	sendGridTimestampHeader = "X-Twilio-Email-Event-Webhook-Timestamp"
+)
+
+// SendGridAdapter handles SendGrid's signed event webhook. Requests carry an
+// ECDSA signature over the timestamp and body, checked against the
+// verification key shown in the SendGrid settings.
+type SendGridAdapter struct {
+	key *ecdsa.PublicKey
+}
+
+// NewSendGridAdapter takes the base64 verification key from the SendGrid
+// webhook settings.
+func NewSendGridAdapter(publicKey string) (*SendGridAdapter, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("sendgrid verification key: %w", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("sendgrid verification key: %w", err)
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("sendgrid verification key is not ECDSA")
+	}
+	return &SendGridAdapter{key: key}, nil
+}
+
+func (a *SendGridAdapter) Provider() string { return "sendgrid" }
+
+func (a *SendGridAdapter) Verify(r *http.Request, body []byte) error {
+	tsHeader := r.Header.Get(sendGridTimestampHeader)
+	ts, err := strconv.ParseInt(tsHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", ErrBadSignature)
+	}
+	if err := checkFresh(time.Unix(ts, 0), signatureTolerance); err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(sendGridSignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("%w: missing signature", ErrBadSignature)
+	}
+	digest := sha256.Sum256(append([]byte(tsHeader), body...))
+	if !ecdsa.VerifyASN1(a.key, digest[:], sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+type sendGridEvent struct {
+	Event        string  `json:"event"`
+	Email        string  `json:"email"`
+	SGEventID    string  `json:"sg_event_id"`
+	SGMessageID  string  `json:"sg_message_id"`
+	Timestamp    float64 `json:"timestamp"`
+	Status       string  `json:"status"`
+	Reason       string  `json:"reason"`
+	Response     string  `json:"response"`
+	Type         string  `json:"type"` // bounce or blocked
+	IP           string  `json:"ip"`
+	CampaignID   string  `json:"campaign_id"`
+	SubscriberID string  `json:"subscriber_id"`
+}
+
+func (a *SendGridAdapter) Parse(r *http.Request, body []byte) ([]Event, error) {
+	var batch []sendGridEvent
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("sendgrid: %w", err)
+	}
+	var events []Event
+	for _, s := range batch {
+		var typ EventType
+		switch s.Event {
+		case "delivered":
+			typ = EventDelivered
+		case "bounce":
+			typ = EventBounced
+		case "deferred":
+			typ = EventDeferred
+		case "spamreport":
+			typ = EventComplained
+		case "unsubscribe", "group_unsubscribe":
+			typ = EventUnsubscribed
+		default:
+			continue
+		}
+
+		// sg_message_id is "<x-message-id>.filter..."; the send API
+		// returned only the part before the first dot.
+		messageID, _, _ := strings.Cut(s.SGMessageID, ".")
+		e := Event{
+			Key:          eventKey(a.Provider(), s.SGEventID, s.Event, s.SGMessageID, s.Email),
+			Provider:     a.Provider(),
+			Type:         typ,
+			Recipient:    normalizeEmail(s.Email),
+			MessageID:    messageID,
+			CampaignID:   s.CampaignID,
+			SubscriberID: s.SubscriberID,
+			SourceIP:     s.IP,
+			Timestamp:    unixTime(s.Timestamp),
+		}
+		if typ == EventBounced || typ == EventDeferred {
+			e.DSNStatus = s.Status
+			e.Diagnostic = s.Reason
+			if e.Diagnostic == "" {
+				e.Diagnostic = s.Response
+			}
+			if s.Type == "blocked" {
+				e.MTACategory = "policy-related"
+			}
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,338 @@
+package espevents
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snsHostRe matches the hosts SNS signing certificates and subscription
+// confirmations are served from.
+var snsHostRe = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// SESAdapter handles SES notifications delivered through SNS. Messages are
+// verified against the SNS signing certificate and must come from an allowed
+// topic; subscription confirmations are confirmed automatically for allowed
+// topics only. An adapter without allowed topics accepts nothing.
+type SESAdapter struct {
+	topics map[string]bool // allowed topic ARNs
+
+	httpClient *http.Client
+	certMu     sync.Mutex
+	certs      map[string]*x509.Certificate
+	// fetchCert is swapped out in tests.
+	fetchCert func(certURL string) (*x509.Certificate, error)
+}
+
+func NewSESAdapter(topicARNs ...string) *SESAdapter {
+	a := &SESAdapter{
+		topics:     make(map[string]bool),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		certs:      make(map[string]*x509.Certificate),
+	}
+	for _, arn := range topicARNs {
+		if arn = strings.TrimSpace(arn); arn != "" {
+			a.topics[arn] = true
+		}
+	}
+	a.fetchCert = a.downloadCert
+	return a
+}
+
+func (a *SESAdapter) Provider() string { return "ses" }
+
+type snsEnvelope struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// stringToSign builds the canonical SNS signing string for the message type.
+func (m *snsEnvelope) stringToSign() string {
+	var b strings.Builder
+	add := func(k, v string) {
+		b.WriteString(k + "\n" + v + "\n")
+	}
+	add("Message", m.Message)
+	add("MessageId", m.MessageID)
+	if m.Type == "Notification" {
+		if m.Subject != "" {
+			add("Subject", m.Subject)
+		}
+		add("Timestamp", m.Timestamp)
+		add("TopicArn", m.TopicArn)
+		add("Type", m.Type)
+		return b.String()
+	}
+	add("SubscribeURL", m.SubscribeURL)
+	add("Timestamp", m.Timestamp)
+	add("Token", m.Token)
+	add("TopicArn", m.TopicArn)
+	add("Type", m.Type)
+	return b.String()
+}
+
+func (a *SESAdapter) Verify(r *http.Request, body []byte) error {
+	var m snsEnvelope
+	if err := json.Unmarshal(body, &m); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	if !a.topics[m.TopicArn] {
+		return fmt.Errorf("%w: topic %s not allowed", ErrBadSignature, m.TopicArn)
+	}
+
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: unsupported signature version %q", ErrBadSignature, m.SignatureVersion)
+	}
+	if err := checkSNSURL(m.SigningCertURL); err != nil {
+		return err
+	}
+	cert, err := a.cert(m.SigningCertURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate is not RSA", ErrBadSignature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		d := sha1.Sum([]byte(m.stringToSign()))
+		digest = d[:]
+	} else {
+		d := sha256.Sum256([]byte(m.stringToSign()))
+		digest = d[:]
+	}
+	if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// checkSNSURL only allows HTTPS URLs on SNS hosts, so a forged message
+// cannot point verification or confirmation at an attacker's server.
+func checkSNSURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || !snsHostRe.MatchString(u.Hostname()) {
+		return fmt.Errorf("%w: untrusted SNS URL %q", ErrBadSignature, raw)
+	}
+	return nil
+}
+
+func (a *SESAdapter) cert(certURL string) (*x509.Certificate, error) {
+	a.certMu.Lock()
+	defer a.certMu.Unlock()
+	if c, ok := a.certs[certURL]; ok {
+		return c, nil
+	}
+	c, err := a.fetchCert(certURL)
+	if err != nil {
+		return nil, err
+	}
+	a.certs[certURL] = c
+	return c, nil
+}
+
+func (a *SESAdapter) downloadCert(certURL string) (*x509.Certificate, error) {
+	resp, err := a.httpClient.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signing certificate: HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing certificate: no PEM block")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+type sesRecipient struct {
+	EmailAddress   string `json:"emailAddress"`
+	Status         string `json:"status"`
+	DiagnosticCode string `json:"diagnosticCode"`
+}
+
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"` // configuration-set event publishing
+	Mail             struct {
+		MessageID string              `json:"messageId"`
+		SourceIP  string              `json:"sourceIp"`
+		Tags      map[string][]string `json:"tags"`
+	} `json:"mail"`
+	Bounce *struct {
+		BounceType        string         `json:"bounceType"`
+		BounceSubType     string         `json:"bounceSubType"`
+		BouncedRecipients []sesRecipient `json:"bouncedRecipients"`
+		FeedbackID        string         `json:"feedbackId"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplainedRecipients  []sesRecipient `json:"complainedRecipients"`
+		ComplaintFeedbackType string         `json:"complaintFeedbackType"`
+		FeedbackID            string         `json:"feedbackId"`
+		UserAgent             string         `json:"userAgent"`
+	} `json:"complaint"`
+	Delivery *struct {
+		Recipients []string `json:"recipients"`
+	} `json:"delivery"`
+	DeliveryDelay *struct {
+		DelayType         string         `json:"delayType"`
+		DelayedRecipients []sesRecipient `json:"delayedRecipients"`
+	} `json:"deliveryDelay"`
+}
+
+// sesBounceCategory maps SES bounce types to PowerMTA bounce categories.
+func sesBounceCategory(bounceType, subType string) string {
+	if bounceType == "Permanent" {
+		switch subType {
+		case "MessageTooLarge", "ContentRejected", "AttachmentRejected":
+			return "content-related"
+		default: // General, NoEmail, Suppressed, OnAccountSuppressionList
+			return "bad-mailbox"
+		}
+	}
+	switch subType {
+	case "MailboxFull":
+		return "quota-issues"
+	case "MessageTooLarge", "ContentRejected", "AttachmentRejected":
+		return "content-related"
+	}
+	return "other"
+}
+
+func (a *SESAdapter) Parse(r *http.Request, body []byte) ([]Event, error) {
+	var m snsEnvelope
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("ses: %w", err)
+	}
+	switch m.Type {
+	case "SubscriptionConfirmation":
+		return nil, a.confirm(m)
+	case "Notification":
+	default:
+		return nil, nil
+	}
+
+	var n sesNotification
+	if err := json.Unmarshal([]byte(m.Message), &n); err != nil {
+		return nil, fmt.Errorf("ses message: %w", err)
+	}
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+	ts, _ := time.Parse(time.RFC3339, m.Timestamp)
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+	base := Event{
+		Provider:  a.Provider(),
+		MessageID: n.Mail.MessageID,
+		SourceIP:  n.Mail.SourceIP,
+		Timestamp: ts,
+	}
+	if v := n.Mail.Tags["campaign_id"]; len(v) > 0 {
+		base.CampaignID = v[0]
+	}
+	if v := n.Mail.Tags["subscriber_id"]; len(v) > 0 {
+		base.SubscriberID = v[0]
+	}
+	key := func(rcpt string) string {
+		return eventKey(a.Provider(), m.MessageID+":"+normalizeEmail(rcpt))
+	}
+
+	var events []Event
+	switch {
+	case kind == "Bounce" && n.Bounce != nil:
+		for _, rc := range n.Bounce.BouncedRecipients {
+			e := base
+			e.Key, e.Type, e.Recipient = key(rc.EmailAddress), EventBounced, normalizeEmail(rc.EmailAddress)
+			e.DSNStatus, e.Diagnostic = rc.Status, rc.DiagnosticCode
+			e.MTACategory = sesBounceCategory(n.Bounce.BounceType, n.Bounce.BounceSubType)
+			events = append(events, e)
+		}
+	case kind == "Complaint" && n.Complaint != nil:
+		for _, rc := range n.Complaint.ComplainedRecipients {
+			e := base
+			e.Key, e.Type, e.Recipient = key(rc.EmailAddress), EventComplained, normalizeEmail(rc.EmailAddress)
+			e.FeedbackType = n.Complaint.ComplaintFeedbackType
+			e.ReportingDomain = n.Complaint.UserAgent
+			events = append(events, e)
+		}
+	case kind == "Delivery" && n.Delivery != nil:
+		for _, rcpt := range n.Delivery.Recipients {
+			e := base
+			e.Key, e.Type, e.Recipient = key(rcpt), EventDelivered, normalizeEmail(rcpt)
+			events = append(events, e)
+		}
+	case kind == "DeliveryDelay" && n.DeliveryDelay != nil:
+		for _, rc := range n.DeliveryDelay.DelayedRecipients {
+			e := base
+			e.Key, e.Type, e.Recipient = key(rc.EmailAddress), EventDeferred, normalizeEmail(rc.EmailAddress)
+			e.DSNStatus, e.Diagnostic = rc.Status, rc.DiagnosticCode
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// confirm visits the SubscribeURL of a verified subscription confirmation
+// for an allowed topic.
+func (a *SESAdapter) confirm(m snsEnvelope) error {
+	if !a.topics[m.TopicArn] {
+		return fmt.Errorf("confirm SNS subscription: topic %s not allowed", m.TopicArn)
+	}
+	if err := checkSNSURL(m.SubscribeURL); err != nil {
+		return err
+	}
+	resp, err := a.httpClient.Get(m.SubscribeURL)
+	if err != nil {
+		return fmt.Errorf("confirm SNS subscription: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("confirm SNS subscription: HTTP %d", resp.StatusCode)
+	}
+	log.Printf("[ESPEvents] confirmed SNS subscription to %s", m.TopicArn)
+	return nil
+}
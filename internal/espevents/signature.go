@@ -0,0 +1,76 @@
+package espevents
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers for webhooks we sign ourselves (PMTA accounting forwarders, ARF
+// relays): an HMAC-SHA256 over "<timestamp>.<body>" in hex.
+const (
+	SignatureHeader          = "X-Signature"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+// signatureTolerance bounds how old a signed timestamp may be, limiting
+// replays of captured requests.
+const signatureTolerance = 5 * time.Minute
+
+// SignBody returns the X-Signature value for a body signed at ts.
+func SignBody(secret string, ts time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts.Unix())
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHMAC checks the X-Signature headers of a request.
+func verifyHMAC(secret string, r *http.Request, body []byte) error {
+	if secret == "" {
+		return fmt.Errorf("%w: no signing secret configured", ErrBadSignature)
+	}
+	ts, err := strconv.ParseInt(r.Header.Get(SignatureTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", ErrBadSignature)
+	}
+	if err := checkFresh(time.Unix(ts, 0), signatureTolerance); err != nil {
+		return err
+	}
+	want := SignBody(secret, time.Unix(ts, 0), body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(SignatureHeader))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// checkFresh rejects timestamps further than tolerance from now.
+func checkFresh(ts time.Time, tolerance time.Duration) error {
+	if d := time.Since(ts); d > tolerance || d < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrBadSignature)
+	}
+	return nil
+}
+
+// verifyBasicAuth compares HTTP basic credentials in constant time.
+func verifyBasicAuth(user, pass string, r *http.Request) error {
+	if user == "" && pass == "" {
+		return fmt.Errorf("%w: no credentials configured", ErrBadSignature)
+	}
+	u, p, ok := r.BasicAuth()
+	if !ok {
+		return fmt.Errorf("%w: missing credentials", ErrBadSignature)
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(strings.TrimSpace(u)), []byte(user)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+	if !userOK || !passOK {
+		return ErrBadSignature
+	}
+	return nil
+}
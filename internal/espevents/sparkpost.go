@@ -0,0 +1,111 @@
+package espevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// SparkPostAdapter handles SparkPost event webhooks. SparkPost
+// authenticates webhooks with the basic-auth credentials configured on the
+// webhook.
+type SparkPostAdapter struct {
+	user, pass string
+}
+
+func NewSparkPostAdapter(user, pass string) *SparkPostAdapter {
+	return &SparkPostAdapter{user: user, pass: pass}
+}
+
+func (a *SparkPostAdapter) Provider() string { return "sparkpost" }
+
+func (a *SparkPostAdapter) Verify(r *http.Request, body []byte) error {
+	return verifyBasicAuth(a.user, a.pass, r)
+}
+
+type sparkPostEvent struct {
+	EventID     string                 `json:"event_id"`
+	Type        string                 `json:"type"`
+	RcptTo      string                 `json:"rcpt_to"`
+	MessageID   string                 `json:"message_id"`
+	BounceClass string                 `json:"bounce_class"`
+	RawReason   string                 `json:"raw_reason"`
+	Reason      string                 `json:"reason"`
+	ErrorCode   string                 `json:"error_code"`
+	SendingIP   string                 `json:"sending_ip"`
+	FBType      string                 `json:"fbtype"`
+	ReportBy    string                 `json:"report_by"`
+	Timestamp   string                 `json:"timestamp"`
+	RcptMeta    map[string]interface{} `json:"rcpt_meta"`
+}
+
+// sparkPostBounceClasses maps SparkPost bounce classes to PowerMTA bounce
+// categories. See https://support.sparkpost.com/docs/deliverability/bounce-classification-codes
+var sparkPostBounceClasses = map[string]string{
+	"1": "other", "10": "bad-mailbox", "20": "other", "21": "bad-domain",
+	"22": "quota-issues", "23": "content-related", "24": "message-expired",
+	"25": "policy-related", "30": "bad-mailbox", "40": "other",
+	"50": "policy-related", "51": "spam-related", "52": "content-related",
+	"53": "virus-related", "54": "relaying-issues", "60": "other",
+	"70": "other", "80": "other", "90": "other", "100": "other",
+}
+
+func (a *SparkPostAdapter) Parse(r *http.Request, body []byte) ([]Event, error) {
+	var batch []struct {
+		Msys map[string]json.RawMessage `json:"msys"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("sparkpost: %w", err)
+	}
+
+	var events []Event
+	for _, item := range batch {
+		for category, raw := range item.Msys {
+			var e sparkPostEvent
+			if err := json.Unmarshal(raw, &e); err != nil {
+				return nil, fmt.Errorf("sparkpost %s: %w", category, err)
+			}
+			var typ EventType
+			switch e.Type {
+			case "delivery":
+				typ = EventDelivered
+			case "bounce", "out_of_band":
+				typ = EventBounced
+			case "delay":
+				typ = EventDeferred
+			case "spam_complaint":
+				typ = EventComplained
+			case "list_unsubscribe", "link_unsubscribe":
+				typ = EventUnsubscribed
+			default:
+				continue // injections, opens, clicks, relay events
+			}
+
+			sec, _ := strconv.ParseFloat(e.Timestamp, 64)
+			evt := Event{
+				Key:             eventKey(a.Provider(), e.EventID, e.Type, e.MessageID, e.RcptTo, e.Timestamp),
+				Provider:        a.Provider(),
+				Type:            typ,
+				Recipient:       normalizeEmail(e.RcptTo),
+				MessageID:       e.MessageID,
+				CampaignID:      metaString(e.RcptMeta, "campaign_id"),
+				SubscriberID:    metaString(e.RcptMeta, "subscriber_id"),
+				SourceIP:        e.SendingIP,
+				ReportingDomain: e.ReportBy,
+				FeedbackType:    e.FBType,
+				Timestamp:       unixTime(sec),
+			}
+			if typ == EventBounced || typ == EventDeferred {
+				evt.Diagnostic = e.RawReason
+				if evt.Diagnostic == "" {
+					evt.Diagnostic = e.Reason
+				}
+				evt.DSNStatus = e.ErrorCode
+				evt.MTACategory = sparkPostBounceClasses[e.BounceClass]
+			}
+			events = append(events, evt)
+		}
+	}
+	return events, nil
+}
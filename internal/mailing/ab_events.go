@@ -0,0 +1,85 @@
+package mailing
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// A/B event types written to mailing_ab_events. Bandit allocation counts
+// sends as trials and opens, clicks or conversions as rewards.
+const (
+	ABEventSend  = "send"
+	ABEventOpen  = "open"
+	ABEventClick = "click"
+)
+
+// Execer runs statements on a *sql.DB or inside a *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// currentABTest selects a campaign's current test: the newest running one,
+// else the newest of any status. Campaign $1.
+const currentABTest = `
+	SELECT t.id FROM mailing_ab_tests t
+	WHERE t.campaign_id = $1
+	ORDER BY (t.status = 'running') DESC, t.created_at DESC
+	LIMIT 1`
+
+// CurrentABTest returns the id and allocation mode of the campaign's
+// current A/B test. It returns sql.ErrNoRows when the campaign has none.
+func CurrentABTest(ctx context.Context, db *sql.DB, campaignID uuid.UUID) (uuid.UUID, AllocationMode, error) {
+	var id uuid.UUID
+	var mode string
+	err := db.QueryRowContext(ctx, `
+		SELECT id, COALESCE(allocation_mode, 'static') FROM mailing_ab_tests
+		WHERE id = (`+currentABTest+`)`, campaignID).Scan(&id, &mode)
+	return id, AllocationMode(mode), err
+}
+
+// RecordABSend records that subscriberID was sent variantName of the
+// campaign's current A/B test. Sends without a variant and repeated sends
+// to the same subscriber are ignored.
+func RecordABSend(ctx context.Context, db *sql.DB, campaignID, subscriberID uuid.UUID, variantName string) error {
+	if variantName == "" {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO mailing_ab_events (test_id, variant_id, subscriber_id, event_type)
+		SELECT v.test_id, v.id, $2, 'send'
+		FROM mailing_ab_variants v
+		WHERE v.test_id = (`+currentABTest+`)
+		  AND v.variant_name = $3
+		  AND NOT EXISTS (
+			SELECT 1 FROM mailing_ab_events e
+			WHERE e.test_id = v.test_id AND e.subscriber_id = $2 AND e.event_type = 'send'
+		  )`,
+		campaignID, subscriberID, variantName)
+	return err
+}
+
+// RecordABEngagement records an open or click against the variant the
+// subscriber was sent. It does nothing when the subscriber received no
+// variant of the campaign. trafficClass is kept in event_data so machine
+// opens and scanner clicks are not counted as rewards.
+//
+// Each subscriber counts once per test and event type: repeat hits are
+// dropped, except that a human event is still recorded after machine ones.
+func RecordABEngagement(ctx context.Context, db Execer, campaignID, subscriberID uuid.UUID, eventType, trafficClass string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO mailing_ab_events (test_id, variant_id, subscriber_id, event_type, event_data)
+		SELECT s.test_id, s.variant_id, s.subscriber_id, $3, jsonb_build_object('traffic_class', $4::text)
+		FROM mailing_ab_events s
+		JOIN mailing_ab_tests t ON t.id = s.test_id
+		WHERE t.campaign_id = $1 AND s.subscriber_id = $2 AND s.event_type = 'send'
+		  AND NOT EXISTS (
+			SELECT 1 FROM mailing_ab_events e
+			WHERE e.test_id = s.test_id AND e.subscriber_id = s.subscriber_id AND e.event_type = $3
+			  AND COALESCE(e.event_data->>'traffic_class', 'human') IN ('human', $4::text)
+		  )
+		LIMIT 1`,
+		campaignID, subscriberID, eventType, trafficClass)
+	return err
+}
@@ -0,0 +1,307 @@
+package mailing
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// ABMethod selects how the VariantEvaluator decides an A/B test.
+type ABMethod string
+
+const (
+	// ABMethodZTest is the fixed-horizon two-proportion z-test. It is only
+	// valid when read once, so repeated evaluation inflates false positives.
+	ABMethodZTest ABMethod = "z_test"
+	// ABMethodBayesian uses beta-binomial posteriors and declares the best
+	// variant once its probability to beat all others clears the confidence
+	// threshold and its expected loss is negligible.
+	ABMethodBayesian ABMethod = "bayesian"
+	// ABMethodSequential is the mixture sequential probability ratio test
+	// (mSPRT); its p-values stay valid under continuous monitoring.
+	ABMethodSequential ABMethod = "sequential"
+)
+
+// DefaultABMethod is used for tests without an explicit method.
+const DefaultABMethod = ABMethodSequential
+
+// ValidABMethod reports whether m is a known evaluation method.
+func ValidABMethod(m ABMethod) bool {
+	switch m {
+	case ABMethodZTest, ABMethodBayesian, ABMethodSequential:
+		return true
+	}
+	return false
+}
+
+// ABArm is the observed outcome for one variant: Successes out of Trials
+// for the test's winner metric.
+type ABArm struct {
+	Trials    int
+	Successes int
+}
+
+// Rate is the observed success rate.
+func (a ABArm) Rate() float64 {
+	if a.Trials == 0 {
+		return 0
+	}
+	return float64(a.Successes) / float64(a.Trials)
+}
+
+// ABOptions tunes EvaluateAB.
+type ABOptions struct {
+	// Confidence is 1-alpha for the frequentist methods and the required
+	// probability to beat all others for the Bayesian method.
+	Confidence float64
+	// LossThreshold is the largest expected loss (in rate units) the
+	// Bayesian method accepts for the winner.
+	LossThreshold float64
+	// MixingSD is the standard deviation of the normal mixing distribution
+	// over effect sizes used by the mSPRT.
+	MixingSD float64
+	// Draws and Seed control the Monte Carlo estimate of the posteriors.
+	Draws int
+	Seed  int64
+}
+
+// DefaultABOptions returns the evaluator defaults.
+func DefaultABOptions() ABOptions {
+	return ABOptions{
+		Confidence:    0.95,
+		LossThreshold: 0.001,
+		MixingSD:      0.02,
+		Draws:         20000,
+		Seed:          1,
+	}
+}
+
+// ABArmResult is the per-variant outcome of an evaluation.
+type ABArmResult struct {
+	Rate float64
+	// ProbBest and ExpectedLoss come from the beta posteriors and are
+	// reported for every method.
+	ProbBest     float64
+	ExpectedLoss float64
+	// PValue compares the arm with the best arm; for the best arm it is the
+	// largest of those comparisons. For the sequential method it is the
+	// always-valid p-value.
+	PValue float64
+	// Significant means the best arm beats this arm after multiple-comparison
+	// correction. For the best arm it equals ABEvaluation.Decided.
+	Significant bool
+}
+
+// Confidence is the score stored in mailing_ab_result_snapshots: the
+// probability to be best for the Bayesian method, 1-p otherwise.
+func (r ABArmResult) Confidence(method ABMethod) float64 {
+	if method == ABMethodBayesian {
+		return r.ProbBest
+	}
+	return 1 - r.PValue
+}
+
+// ABEvaluation is the result of EvaluateAB.
+type ABEvaluation struct {
+	Method  ABMethod
+	Arms    []ABArmResult
+	Best    int
+	Decided bool
+	// Alpha is the family-wise error rate the frequentist methods control.
+	Alpha float64
+}
+
+// EvaluateAB compares the arms with the given method. The best arm is the
+// one with the highest observed rate. With more than two arms the
+// frequentist methods apply Holm's step-down correction across the
+// best-versus-other comparisons; the Bayesian probability to beat all others
+// already accounts for every arm.
+func EvaluateAB(method ABMethod, arms []ABArm, opts ABOptions) ABEvaluation {
+	ev := ABEvaluation{Method: method, Arms: make([]ABArmResult, len(arms)), Alpha: 1 - opts.Confidence}
+	if len(arms) < 2 {
+		return ev
+	}
+	for i, a := range arms {
+		ev.Arms[i].Rate = a.Rate()
+		if a.Rate() > arms[ev.Best].Rate() {
+			ev.Best = i
+		}
+	}
+
+	probBest, loss := betaPosteriors(arms, opts.Draws, opts.Seed)
+	for i := range arms {
+		ev.Arms[i].ProbBest = probBest[i]
+		ev.Arms[i].ExpectedLoss = loss[i]
+		ev.Arms[i].PValue = 1
+	}
+
+	if method == ABMethodBayesian {
+		ev.Decided = probBest[ev.Best] >= opts.Confidence && loss[ev.Best] <= opts.LossThreshold
+		for i := range arms {
+			ev.Arms[i].Significant = ev.Decided
+		}
+		return ev
+	}
+
+	var others []int
+	var pvals []float64
+	worst := 0.0
+	best := arms[ev.Best]
+	for i, a := range arms {
+		if i == ev.Best {
+			continue
+		}
+		var p float64
+		if method == ABMethodSequential {
+			p = msprtPValue(best, a, opts.MixingSD)
+		} else {
+			p = twoProportionPValue(best.Rate(), a.Rate(), best.Trials, a.Trials)
+		}
+		ev.Arms[i].PValue = p
+		if p > worst {
+			worst = p
+		}
+		others = append(others, i)
+		pvals = append(pvals, p)
+	}
+
+	rejected := holm(pvals, ev.Alpha)
+	ev.Decided = true
+	for j, i := range others {
+		ev.Arms[i].Significant = rejected[j]
+		if !rejected[j] {
+			ev.Decided = false
+		}
+	}
+	ev.Arms[ev.Best].PValue = worst
+	ev.Arms[ev.Best].Significant = ev.Decided
+	return ev
+}
+
+// holm applies Holm's step-down procedure and reports which hypotheses are
+// rejected at family-wise level alpha.
+func holm(pvals []float64, alpha float64) []bool {
+	order := make([]int, len(pvals))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return pvals[order[a]] < pvals[order[b]] })
+
+	rejected := make([]bool, len(pvals))
+	m := len(pvals)
+	for rank, i := range order {
+		if pvals[i] > alpha/float64(m-rank) {
+			break
+		}
+		rejected[i] = true
+	}
+	return rejected
+}
+
+// twoProportionPValue is the two-sided p-value of the pooled two-proportion
+// z-test. Degenerate inputs return 1.
+func twoProportionPValue(rateA, rateB float64, nA, nB int) float64 {
+	if nA == 0 || nB == 0 {
+		return 1
+	}
+	pooled := (rateA*float64(nA) + rateB*float64(nB)) / float64(nA+nB)
+	if pooled <= 0 || pooled >= 1 {
+		return 1
+	}
+	se := math.Sqrt(pooled * (1 - pooled) * (1.0/float64(nA) + 1.0/float64(nB)))
+	if se == 0 {
+		return 1
+	}
+	z := math.Abs(rateA-rateB) / se
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// msprtPValue is the always-valid p-value of the normal-mixture SPRT for the
+// difference of two proportions (Johari et al., "Always Valid Inference").
+// With V the variance of the observed difference d and tau the mixing SD:
+//
+//	Lambda = sqrt(V/(V+tau^2)) * exp(tau^2 d^2 / (2 V (V+tau^2)))
+//
+// and p = min(1, 1/Lambda). Stopping the first time p < alpha keeps the
+// false-positive rate at alpha no matter how often the test is checked.
+func msprtPValue(a, b ABArm, tau float64) float64 {
+	if a.Trials == 0 || b.Trials == 0 || tau <= 0 {
+		return 1
+	}
+	pa, pb := a.Rate(), b.Rate()
+	v := pa*(1-pa)/float64(a.Trials) + pb*(1-pb)/float64(b.Trials)
+	if v <= 0 {
+		return 1
+	}
+	t2 := tau * tau
+	d := pa - pb
+	logLambda := 0.5*math.Log(v/(v+t2)) + t2*d*d/(2*v*(v+t2))
+	if logLambda <= 0 {
+		return 1
+	}
+	return math.Exp(-logLambda)
+}
+
+// betaPosteriors estimates, for Beta(1+s, 1+n-s) posteriors, each arm's
+// probability of having the highest rate and its expected loss
+// E[max_j p_j - p_i]. The estimate is Monte Carlo with a fixed seed so
+// repeated evaluations of the same counts agree.
+func betaPosteriors(arms []ABArm, draws int, seed int64) (probBest, loss []float64) {
+	probBest = make([]float64, len(arms))
+	loss = make([]float64, len(arms))
+	if draws <= 0 {
+		return probBest, loss
+	}
+	rng := rand.New(rand.NewSource(seed))
+	sample := make([]float64, len(arms))
+	for d := 0; d < draws; d++ {
+		best, max := 0, -1.0
+		for i, a := range arms {
+			sample[i] = betaSample(rng, float64(1+a.Successes), float64(1+a.Trials-a.Successes))
+			if sample[i] > max {
+				best, max = i, sample[i]
+			}
+		}
+		probBest[best]++
+		for i := range arms {
+			loss[i] += max - sample[i]
+		}
+	}
+	for i := range arms {
+		probBest[i] /= float64(draws)
+		loss[i] /= float64(draws)
+	}
+	return probBest, loss
+}
+
+// betaSample draws from Beta(a, b) via two gamma draws.
+func betaSample(rng *rand.Rand, a, b float64) float64 {
+	x := gammaSample(rng, a)
+	y := gammaSample(rng, b)
+	if x+y == 0 {
+		return 0
+	}
+	return x / (x + y)
+}
+
+// gammaSample draws from Gamma(shape, 1) using Marsaglia and Tsang's method.
+func gammaSample(rng *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		u := rng.Float64()
+		return gammaSample(rng, shape+1) * math.Pow(u, 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if u < 1-0.0331*x*x*x*x || math.Log(u) < 0.5*x*x+d*(1-v+math.Log(v)) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package mailing
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateAB_Bayesian(t *testing.T) {
+	opts := DefaultABOptions()
+
+	ev := EvaluateAB(ABMethodBayesian, []ABArm{{1000, 200}, {1000, 260}}, opts)
+	assert.Equal(t, 1, ev.Best)
+	assert.True(t, ev.Decided)
+	assert.Greater(t, ev.Arms[1].ProbBest, 0.99)
+	assert.Less(t, ev.Arms[1].ExpectedLoss, 0.001)
+	assert.Greater(t, ev.Arms[0].ExpectedLoss, 0.05, "the loser gives up about the observed lift")
+	assert.InDelta(t, 1, ev.Arms[0].ProbBest+ev.Arms[1].ProbBest, 1e-9)
+
+	ev = EvaluateAB(ABMethodBayesian, []ABArm{{1000, 200}, {1000, 201}}, opts)
+	assert.False(t, ev.Decided)
+	assert.InDelta(t, 0.5, ev.Arms[1].ProbBest, 0.05)
+
+	again := EvaluateAB(ABMethodBayesian, []ABArm{{1000, 200}, {1000, 201}}, opts)
+	assert.Equal(t, ev.Arms, again.Arms, "posteriors are reproducible")
+}
+
+func TestEvaluateAB_MultipleComparisons(t *testing.T) {
+	opts := DefaultABOptions()
+
+	// C clearly beats A, but not B: no winner is declared.
+	arms := []ABArm{{5000, 1000}, {5000, 1150}, {5000, 1180}}
+	for _, m := range []ABMethod{ABMethodZTest, ABMethodSequential} {
+		ev := EvaluateAB(m, arms, opts)
+		assert.Equal(t, 2, ev.Best, m)
+		assert.True(t, ev.Arms[0].Significant, m)
+		assert.False(t, ev.Arms[1].Significant, m)
+		assert.False(t, ev.Decided, m)
+		assert.Equal(t, ev.Arms[1].PValue, ev.Arms[2].PValue, "best arm reports its weakest comparison")
+	}
+
+	// Holm: the second-smallest p-value must clear alpha/(m-1).
+	assert.Equal(t, []bool{true, false, false}, holm([]float64{0.01, 0.04, 0.03}, 0.05))
+	assert.Equal(t, []bool{true, true, true}, holm([]float64{0.01, 0.04, 0.02}, 0.05))
+}
+
+// Repeatedly checking an A/A test inflates the z-test's false-positive rate
+// well past alpha; the mSPRT stays under it.
+func TestEvaluateAB_SequentialUnderPeeking(t *testing.T) {
+	opts := DefaultABOptions()
+	opts.Draws = 0
+	rng := rand.New(rand.NewSource(7))
+
+	const sims, looks, perLook = 200, 20, 250
+	falseZ, falseSeq := 0, 0
+	for s := 0; s < sims; s++ {
+		var a, b ABArm
+		stoppedZ, stoppedSeq := false, false
+		for l := 0; l < looks; l++ {
+			for i := 0; i < perLook; i++ {
+				a.Trials++
+				b.Trials++
+				if rng.Float64() < 0.2 {
+					a.Successes++
+				}
+				if rng.Float64() < 0.2 {
+					b.Successes++
+				}
+			}
+			arms := []ABArm{a, b}
+			if !stoppedZ && EvaluateAB(ABMethodZTest, arms, opts).Decided {
+				stoppedZ = true
+				falseZ++
+			}
+			if !stoppedSeq && EvaluateAB(ABMethodSequential, arms, opts).Decided {
+				stoppedSeq = true
+				falseSeq++
+			}
+		}
+	}
+	assert.Greater(t, float64(falseZ)/sims, 0.10)
+	assert.LessOrEqual(t, float64(falseSeq)/sims, 0.05)
+
+	// A real lift is still found.
+	ev := EvaluateAB(ABMethodSequential, []ABArm{{5000, 1000}, {5000, 1200}}, opts)
+	assert.True(t, ev.Decided)
+	assert.Less(t, ev.Arms[0].PValue, 0.05)
+}
+
+func TestVariantEvaluator_SnapshotsAndWinner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	ve := NewVariantEvaluator(db)
+	testID, campaignID := uuid.New(), uuid.New()
+	a, b := uuid.New(), uuid.New()
+
+	mock.ExpectQuery("SELECT id FROM mailing_ab_variants").WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(a).AddRow(b))
+	for _, v := range []struct {
+		id                  uuid.UUID
+		sends, opens, click int
+	}{{a, 1000, 180, 20}, {b, 1000, 260, 30}} {
+		mock.ExpectQuery("FROM subscriber_events WHERE variant_id").WithArgs(v.id).
+			WillReturnRows(sqlmock.NewRows([]string{"sends", "opens", "clicks", "conversions", "unsubscribes"}).
+				AddRow(v.sends, v.opens, v.click, 0, 0))
+	}
+	mock.ExpectExec("INSERT INTO mailing_ab_result_snapshots").
+		WithArgs(testID, a, 1000, 180, 20, 0.18, 0.02, sqlmock.AnyArg(), "bayesian", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), true, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO mailing_ab_result_snapshots").
+		WithArgs(testID, b, 1000, 260, 30, 0.26, 0.03, sqlmock.AnyArg(), "bayesian", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), true, true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE mailing_ab_variants SET is_winner").WithArgs(b).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_ab_tests SET status = 'completed'").WithArgs(b, testID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO content_learnings").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = ve.evaluateTest(context.Background(), abTestConfig{
+		id: testID, campaignID: campaignID, startedAt: time.Now(),
+		method: ABMethodBayesian, metric: "open_rate", confidence: 0.95,
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVariantMetrics_WinnerMetricArms(t *testing.T) {
+	m := variantMetrics{sends: 1000, opens: 200, clicks: 50, conversions: 10, unsubscribes: 4}
+	for metric, want := range map[string]ABArm{
+		"open_rate":          {1000, 200},
+		"click_rate":         {1000, 50},
+		"click_to_open_rate": {200, 50},
+		"conversion_rate":    {1000, 10},
+		"unsubscribe_rate":   {1000, 996}, // fewer unsubscribes is better
+	} {
+		got, ok := m.arm(metric)
+		require.True(t, ok, metric)
+		assert.Equal(t, want, got, metric)
+		assert.True(t, ValidWinnerMetric(metric), metric)
+	}
+	_, ok := m.arm("revenue")
+	assert.False(t, ok)
+	assert.False(t, ValidWinnerMetric("revenue"))
+}
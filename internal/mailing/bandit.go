@@ -0,0 +1,269 @@
+package mailing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// AllocationMode selects how the VariantSelector splits traffic.
+type AllocationMode string
+
+const (
+	// AllocationStatic hashes each email to a variant (the original behaviour).
+	AllocationStatic AllocationMode = "static"
+	// AllocationThompson sends traffic in proportion to each variant's
+	// posterior probability of being best (randomized probability matching).
+	AllocationThompson AllocationMode = "thompson"
+	// AllocationUCB sends the bulk of traffic to the variant with the highest
+	// UCB1 index and the exploration floor to the rest.
+	AllocationUCB AllocationMode = "ucb"
+)
+
+// Reward metrics a bandit can optimize, mapped to the mailing_ab_events
+// event types that count as a success.
+var banditRewardEvents = map[string]string{
+	"open":       `'open', 'opened'`,
+	"click":      `'click', 'clicked'`,
+	"conversion": `'conversion', 'converted'`,
+}
+
+// banditConfig is the allocation configuration of an A/B test.
+type banditConfig struct {
+	testID         uuid.UUID
+	mode           AllocationMode
+	rewardMetric   string
+	floor          float64
+	holdoutPercent int
+}
+
+// banditState is the posterior summary shared through Redis.
+type banditState struct {
+	Arms       []banditArm `json:"arms"`
+	Weights    []float64   `json:"weights"`
+	ComputedAt time.Time   `json:"computed_at"`
+}
+
+type banditArm struct {
+	VariantID uuid.UUID `json:"variant_id"`
+	Trials    int       `json:"trials"`
+	Successes int       `json:"successes"`
+}
+
+// rewardMetricFor maps a winner_metric or reward_metric value to a bandit
+// reward. Unknown metrics optimize opens.
+func rewardMetricFor(metric string) string {
+	switch metric {
+	case "click", "click_rate", "click_to_open_rate":
+		return "click"
+	case "conversion", "conversion_rate", "revenue":
+		return "conversion"
+	}
+	return "open"
+}
+
+// banditWeights returns the allocation for the variants, in order. Workers
+// share one computation per test through Redis; without Redis each worker
+// computes its own and keeps it for banditTTL.
+func (vs *VariantSelector) banditWeights(ctx context.Context, cfg *banditConfig, variants []ABVariant) ([]float64, error) {
+	key := "ab_bandit:" + cfg.testID.String()
+
+	vs.mu.RLock()
+	local, ok := vs.bandits[cfg.testID]
+	vs.mu.RUnlock()
+	if ok && time.Since(local.ComputedAt) < vs.banditTTL {
+		return alignWeights(local, variants), nil
+	}
+
+	if vs.redis != nil {
+		if raw, err := vs.redis.Get(ctx, key).Bytes(); err == nil {
+			var state banditState
+			if json.Unmarshal(raw, &state) == nil && time.Since(state.ComputedAt) < vs.banditTTL {
+				vs.storeBandit(cfg.testID, &state)
+				return alignWeights(&state, variants), nil
+			}
+		} else if err != redis.Nil {
+			log.Printf("[VariantSelector] redis get %s: %v", key, err)
+		}
+	}
+
+	state, err := vs.computeBandit(ctx, cfg, variants)
+	if err != nil {
+		return nil, err
+	}
+	vs.storeBandit(cfg.testID, state)
+	if vs.redis != nil {
+		if raw, err := json.Marshal(state); err == nil {
+			if err := vs.redis.Set(ctx, key, raw, vs.banditTTL).Err(); err != nil {
+				log.Printf("[VariantSelector] redis set %s: %v", key, err)
+			}
+		}
+	}
+	return alignWeights(state, variants), nil
+}
+
+func (vs *VariantSelector) storeBandit(testID uuid.UUID, state *banditState) {
+	vs.mu.Lock()
+	vs.bandits[testID] = state
+	vs.mu.Unlock()
+}
+
+// computeBandit loads live send and reward counts from mailing_ab_events
+// and turns them into allocation weights. Rewards from machine traffic are
+// not counted.
+func (vs *VariantSelector) computeBandit(ctx context.Context, cfg *banditConfig, variants []ABVariant) (*banditState, error) {
+	rewardTypes, ok := banditRewardEvents[cfg.rewardMetric]
+	if !ok {
+		rewardTypes = banditRewardEvents["open"]
+	}
+	rows, err := vs.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT variant_id,
+			COUNT(DISTINCT subscriber_id) FILTER (WHERE event_type IN ('send', 'sent')),
+			COUNT(DISTINCT subscriber_id) FILTER (WHERE event_type IN (%s)
+				AND COALESCE(event_data->>'traffic_class', 'human') = 'human')
+		FROM mailing_ab_events WHERE test_id = $1
+		GROUP BY variant_id`, rewardTypes), cfg.testID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[uuid.UUID]ABArm)
+	for rows.Next() {
+		var id uuid.UUID
+		var a ABArm
+		if err := rows.Scan(&id, &a.Trials, &a.Successes); err != nil {
+			return nil, err
+		}
+		counts[id] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	state := &banditState{ComputedAt: time.Now()}
+	arms := make([]ABArm, len(variants))
+	for i, v := range variants {
+		a := counts[v.ID]
+		if a.Successes > a.Trials {
+			a.Successes = a.Trials
+		}
+		arms[i] = a
+		state.Arms = append(state.Arms, banditArm{VariantID: v.ID, Trials: a.Trials, Successes: a.Successes})
+	}
+
+	switch cfg.mode {
+	case AllocationUCB:
+		state.Weights = ucbWeights(arms, cfg.floor)
+	default:
+		probBest, _ := betaPosteriors(arms, vs.banditDraws, int64(cfg.testID.ID()))
+		state.Weights = applyExplorationFloor(probBest, cfg.floor)
+	}
+	return state, nil
+}
+
+// ucbWeights gives the arm with the highest UCB1 index everything above the
+// exploration floor. Arms that have never been tried share the lead so each
+// gets an initial sample.
+func ucbWeights(arms []ABArm, floor float64) []float64 {
+	total := 0
+	for _, a := range arms {
+		total += a.Trials
+	}
+	lead := make([]float64, len(arms))
+	untried := 0
+	for i, a := range arms {
+		if a.Trials == 0 {
+			lead[i] = 1
+			untried++
+		}
+	}
+	if untried == 0 {
+		best, bestIdx := -1.0, 0
+		for i, a := range arms {
+			idx := a.Rate() + math.Sqrt(2*math.Log(float64(total))/float64(a.Trials))
+			if idx > best {
+				best, bestIdx = idx, i
+			}
+		}
+		lead[bestIdx] = 1
+		untried = 1
+	}
+	for i := range lead {
+		lead[i] /= float64(untried)
+	}
+	return applyExplorationFloor(lead, floor)
+}
+
+// applyExplorationFloor mixes the weights with a uniform share so each arm
+// gets at least floor of the traffic. The floor is capped at 1/len(weights).
+func applyExplorationFloor(weights []float64, floor float64) []float64 {
+	k := float64(len(weights))
+	if k == 0 {
+		return weights
+	}
+	if floor < 0 {
+		floor = 0
+	}
+	if floor > 1/k {
+		floor = 1 / k
+	}
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	out := make([]float64, len(weights))
+	for i, w := range weights {
+		share := 1 / k
+		if sum > 0 {
+			share = w / sum
+		}
+		out[i] = floor + (1-k*floor)*share
+	}
+	return out
+}
+
+// alignWeights orders the cached weights like variants. Variants missing
+// from the cached state (added after it was computed) get an equal share.
+func alignWeights(state *banditState, variants []ABVariant) []float64 {
+	byID := make(map[uuid.UUID]float64, len(state.Arms))
+	for i, a := range state.Arms {
+		if i < len(state.Weights) {
+			byID[a.VariantID] = state.Weights[i]
+		}
+	}
+	out := make([]float64, len(variants))
+	for i, v := range variants {
+		w, ok := byID[v.ID]
+		if !ok {
+			w = 1 / float64(len(variants))
+		}
+		out[i] = w
+	}
+	return out
+}
+
+// pickWeighted maps u in [0,1) onto the cumulative weights.
+func pickWeighted(weights []float64, u float64) int {
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+	if sum <= 0 {
+		return int(u * float64(len(weights)))
+	}
+	acc := 0.0
+	for i, w := range weights {
+		acc += w / sum
+		if u < acc {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
@@ -0,0 +1,149 @@
+package mailing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanditWeights(t *testing.T) {
+	w := applyExplorationFloor([]float64{1, 0, 0}, 0.05)
+	assert.InDeltaSlice(t, []float64{0.9, 0.05, 0.05}, w, 1e-9)
+	w = applyExplorationFloor([]float64{1, 0}, 0.8)
+	assert.InDeltaSlice(t, []float64{0.5, 0.5}, w, 1e-9, "floor is capped at an even split")
+
+	// Untried arms share the lead so each gets sampled.
+	assert.InDeltaSlice(t, []float64{0.05, 0.475, 0.475}, ucbWeights([]ABArm{{100, 20}, {}, {}}, 0.05), 1e-9)
+	// Otherwise the highest UCB index leads.
+	w = ucbWeights([]ABArm{{1000, 150}, {1000, 250}}, 0.1)
+	assert.InDeltaSlice(t, []float64{0.1, 0.9}, w, 1e-9)
+
+	assert.Equal(t, 0, pickWeighted([]float64{0.2, 0.8}, 0.1))
+	assert.Equal(t, 1, pickWeighted([]float64{0.2, 0.8}, 0.2))
+	assert.Equal(t, 1, pickWeighted([]float64{0.2, 0.8}, 0.999))
+
+	assert.Equal(t, "click", rewardMetricFor("click_rate"))
+	assert.Equal(t, "conversion", rewardMetricFor("revenue"))
+	assert.Equal(t, "open", rewardMetricFor("unsubscribe_rate"))
+}
+
+func expectBanditTest(mock sqlmock.Sqlmock, campaignID, testID, a, b uuid.UUID, mode string) {
+	mock.ExpectQuery("FROM mailing_ab_variants v").WithArgs(campaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "campaign_id", "variant_name", "variant_type", "variant_value", "traffic_percentage", "is_winner"}).
+			AddRow(a, campaignID, "A", "subject", "Subject A", 50, false).
+			AddRow(b, campaignID, "B", "subject", "Subject B", 50, false))
+	mock.ExpectQuery("SELECT id, COALESCE\\(allocation_mode").WithArgs(campaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mode", "reward", "floor", "holdout"}).
+			AddRow(testID, mode, "click_rate", 0.1, 20))
+}
+
+func TestVariantSelector_ThompsonAllocation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	campaignID, testID, a, b := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	expectBanditTest(mock, campaignID, testID, a, b, "thompson")
+	mock.ExpectQuery("FROM mailing_ab_events").WithArgs(testID).
+		WillReturnRows(sqlmock.NewRows([]string{"variant_id", "sends", "rewards"}).
+			AddRow(a, 2000, 40).
+			AddRow(b, 2000, 120))
+
+	vs := NewVariantSelector(db)
+	vs.SetRedis(rdb)
+	ctx := context.Background()
+
+	counts := map[AllocationMode]map[uuid.UUID]int{}
+	for i := 0; i < 2000; i++ {
+		email := fmt.Sprintf("user%d@example.com", i)
+		vc, err := vs.SelectVariant(ctx, campaignID, email)
+		require.NoError(t, err)
+		if counts[vc.Allocation] == nil {
+			counts[vc.Allocation] = map[uuid.UUID]int{}
+		}
+		counts[vc.Allocation][vc.VariantID]++
+
+		again, _ := vs.SelectVariant(ctx, campaignID, email)
+		assert.Equal(t, vc.VariantID, again.VariantID, "assignment is stable while weights are unchanged")
+
+		if vc.Allocation == AllocationHoldout {
+			hash := sha256.Sum256([]byte(email + campaignID.String()))
+			idx := int(binary.BigEndian.Uint64(hash[:8])) % 2
+			if idx < 0 {
+				idx = -idx
+			}
+			assert.Equal(t, []uuid.UUID{a, b}[idx], vc.VariantID, "holdout keeps the static assignment")
+		}
+	}
+	require.NoError(t, mock.ExpectationsWereMet(), "events are read once per TTL")
+
+	holdout := counts[AllocationHoldout][a] + counts[AllocationHoldout][b]
+	assert.InDelta(t, 400, holdout, 80, "20% holdout")
+	assert.InDelta(t, counts[AllocationHoldout][a], counts[AllocationHoldout][b], 80, "holdout splits evenly")
+
+	bandit := counts[AllocationThompson]
+	total := float64(bandit[a] + bandit[b])
+	assert.Greater(t, float64(bandit[b])/total, 0.85, "traffic shifts to the clicking variant")
+	assert.Greater(t, float64(bandit[a])/total, 0.05, "exploration floor")
+
+	// Another worker reuses the posteriors from Redis without querying events.
+	db2, mock2, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db2.Close()
+	expectBanditTest(mock2, campaignID, testID, a, b, "thompson")
+	other := NewVariantSelector(db2)
+	other.SetRedis(rdb)
+	for i := 0; i < 50; i++ {
+		_, err := other.SelectVariant(ctx, campaignID, fmt.Sprintf("other%d@example.com", i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, mock2.ExpectationsWereMet())
+}
+
+func TestVariantSelector_StaticTestKeepsHashAssignment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	campaignID, testID, a, b := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	expectBanditTest(mock, campaignID, testID, a, b, "static")
+
+	vc, err := NewVariantSelector(db).SelectVariant(context.Background(), campaignID, "test@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, AllocationStatic, vc.Allocation)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordABEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	campaignID, subscriberID := uuid.New(), uuid.New()
+
+	require.NoError(t, RecordABSend(ctx, db, campaignID, subscriberID, ""), "sends without a variant are not recorded")
+
+	// One test per campaign, one send per subscriber
+	mock.ExpectExec("INSERT INTO mailing_ab_events .*'send'.*FROM mailing_ab_variants v WHERE v.test_id = \\( SELECT t.id FROM mailing_ab_tests t .*LIMIT 1\\).*NOT EXISTS").
+		WithArgs(campaignID, subscriberID, "B").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, RecordABSend(ctx, db, campaignID, subscriberID, "B"))
+
+	// One engagement per test, subscriber and event type
+	mock.ExpectExec("INSERT INTO mailing_ab_events .*FROM mailing_ab_events s.*s.event_type = 'send'.*NOT EXISTS.*e.event_type = \\$3").
+		WithArgs(campaignID, subscriberID, ABEventClick, "human").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, RecordABEngagement(ctx, db, campaignID, subscriberID, ABEventClick, "human"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
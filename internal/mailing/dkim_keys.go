@@ -0,0 +1,389 @@
+package mailing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/dkim"
+)
+
+// dkimCacheTTL bounds how long a resolved key (or its absence) is reused
+// before mailing_dkim_keys is read again.
+const dkimCacheTTL = 5 * time.Minute
+
+// DKIMKeyStore resolves DKIM signing keys from mailing_dkim_keys by sending
+// domain and selector, caching the parsed keys.
+type DKIMKeyStore struct {
+	db *sql.DB
+
+	mu    sync.RWMutex
+	cache map[string]dkimCacheEntry
+}
+
+type dkimCacheEntry struct {
+	signer   *dkim.Signer // nil when the domain has no key
+	err      error
+	loadedAt time.Time
+}
+
+// NewDKIMKeyStore creates a key store.
+func NewDKIMKeyStore(db *sql.DB) *DKIMKeyStore {
+	return &DKIMKeyStore{db: db, cache: make(map[string]dkimCacheEntry)}
+}
+
+// Signer returns the signer for domain and selector. An empty selector
+// picks the domain's active key. It returns nil without error when there
+// is no usable key.
+func (k *DKIMKeyStore) Signer(ctx context.Context, domain, selector string) (*dkim.Signer, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	cacheKey := domain + "|" + selector
+
+	k.mu.RLock()
+	e, ok := k.cache[cacheKey]
+	k.mu.RUnlock()
+	if ok && time.Since(e.loadedAt) < dkimCacheTTL {
+		return e.signer, e.err
+	}
+
+	e = dkimCacheEntry{loadedAt: time.Now()}
+	e.signer, e.err = k.load(ctx, domain, selector)
+	if ctx.Err() != nil {
+		return e.signer, e.err // don't cache a cancelled lookup
+	}
+	k.mu.Lock()
+	k.cache[cacheKey] = e
+	k.mu.Unlock()
+	return e.signer, e.err
+}
+
+func (k *DKIMKeyStore) load(ctx context.Context, domain, selector string) (*dkim.Signer, error) {
+	var sel string
+	var pemBytes []byte
+	var err error
+	if selector == "" {
+		err = k.db.QueryRowContext(ctx, `
+			SELECT selector, private_key_encrypted FROM mailing_dkim_keys
+			WHERE domain = $1 AND active = true AND status = 'active'
+			ORDER BY activated_at DESC NULLS LAST, created_at DESC
+			LIMIT 1`, domain).Scan(&sel, &pemBytes)
+	} else {
+		// A published key can be named explicitly to test it before it
+		// becomes the domain's signing key.
+		err = k.db.QueryRowContext(ctx, `
+			SELECT selector, private_key_encrypted FROM mailing_dkim_keys
+			WHERE domain = $1 AND selector = $2 AND status IN ('published', 'active')`,
+			domain, selector).Scan(&sel, &pemBytes)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load DKIM key for %s: %w", domain, err)
+	}
+
+	key, err := dkim.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("DKIM key %s._domainkey.%s: %w", sel, domain, err)
+	}
+	return dkim.NewSigner(domain, sel, key)
+}
+
+// Invalidate drops cached keys for domain, e.g. after a rotation.
+func (k *DKIMKeyStore) Invalidate(domain string) {
+	prefix := strings.ToLower(domain) + "|"
+	k.mu.Lock()
+	for key := range k.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(k.cache, key)
+		}
+	}
+	k.mu.Unlock()
+}
+
+// Sign signs a raw message for the sending domain. Without a key for the
+// domain itself, the parent domains are tried, since a signature by the
+// organizational domain still aligns with From under relaxed DMARC. The
+// message is returned unchanged when no key exists.
+func (k *DKIMKeyStore) Sign(ctx context.Context, domain, selector string, message []byte) ([]byte, error) {
+	for d := strings.ToLower(domain); strings.Contains(d, "."); d = d[strings.IndexByte(d, '.')+1:] {
+		signer, err := k.Signer(ctx, d, selector)
+		if err != nil {
+			return message, err
+		}
+		if signer != nil {
+			return signer.Sign(message)
+		}
+	}
+	return message, nil
+}
+
+// DNSPublisher upserts DNS records; AWSInfrastructureService publishes
+// through Route53.
+type DNSPublisher interface {
+	CreateDNSRecord(ctx context.Context, orgID, recordName, recordType, recordValue string, ttl int) (*Route53Record, error)
+}
+
+// DKIMRotationConfig configures a DKIMRotator. Zero values take the
+// defaults noted on each field.
+type DKIMRotationConfig struct {
+	Interval     time.Duration  // age of the active key that triggers rotation (default 90 days)
+	Algorithm    dkim.Algorithm // for new keys (default rsa-sha256)
+	KeyBits      int            // RSA key size (default 2048)
+	MinPublished time.Duration  // time a new key must be in DNS before it signs (default 1h)
+	TTL          int            // TXT record TTL in seconds (default 3600)
+}
+
+// DKIMRotator rotates DKIM selectors on a schedule. Each rotation moves a
+// new key through pending → published → active: the public key goes out
+// through Route53 first, and the key only starts signing once the TXT
+// record resolves and resolver caches have had MinPublished to expire.
+// The key it replaces is retired but stays in DNS so mail already in
+// flight still verifies.
+type DKIMRotator struct {
+	db    *sql.DB
+	dns   DNSPublisher
+	store *DKIMKeyStore
+	cfg   DKIMRotationConfig
+
+	// lookupTXT and now are swapped out in tests.
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+	now       func() time.Time
+}
+
+// NewDKIMRotator creates a rotator that publishes through dns.
+func NewDKIMRotator(db *sql.DB, dns DNSPublisher, cfg DKIMRotationConfig) *DKIMRotator {
+	if cfg.Interval == 0 {
+		cfg.Interval = 90 * 24 * time.Hour
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = dkim.RSASHA256
+	}
+	if cfg.KeyBits == 0 {
+		cfg.KeyBits = 2048
+	}
+	if cfg.MinPublished == 0 {
+		cfg.MinPublished = time.Hour
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 3600
+	}
+	return &DKIMRotator{
+		db:        db,
+		dns:       dns,
+		cfg:       cfg,
+		lookupTXT: net.DefaultResolver.LookupTXT,
+		now:       time.Now,
+	}
+}
+
+// SetKeyStore lets the rotator drop cached keys when it activates a new one.
+func (r *DKIMRotator) SetKeyStore(store *DKIMKeyStore) {
+	r.store = store
+}
+
+// Start runs RunOnce every interval until ctx is cancelled.
+func (r *DKIMRotator) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := r.RunOnce(ctx); err != nil {
+				log.Printf("[DKIMRotator] %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce starts rotations that are due and advances those in progress.
+func (r *DKIMRotator) RunOnce(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT k.organization_id, k.domain FROM mailing_dkim_keys k
+		WHERE k.status = 'active'
+		AND NOT EXISTS (
+			SELECT 1 FROM mailing_dkim_keys n
+			WHERE n.domain = k.domain AND n.status IN ('pending', 'published')
+		)
+		GROUP BY k.organization_id, k.domain
+		HAVING MAX(COALESCE(k.activated_at, k.created_at)) < $1`,
+		r.now().Add(-r.cfg.Interval))
+	if err != nil {
+		return fmt.Errorf("find keys due for rotation: %w", err)
+	}
+	type due struct{ orgID, domain string }
+	var dues []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.orgID, &d.domain); err == nil {
+			dues = append(dues, d)
+		}
+	}
+	rows.Close()
+	for _, d := range dues {
+		if _, err := r.Rotate(ctx, d.orgID, d.domain); err != nil {
+			log.Printf("[DKIMRotator] rotate %s: %v", d.domain, err)
+		}
+	}
+
+	if err := r.publishPending(ctx); err != nil {
+		return err
+	}
+	return r.activatePublished(ctx)
+}
+
+// Rotate generates a new pending key for domain and returns its selector.
+// It is published and activated by the following runs.
+func (r *DKIMRotator) Rotate(ctx context.Context, orgID, domain string) (string, error) {
+	key, pemBytes, err := dkim.GenerateKey(r.cfg.Algorithm, r.cfg.KeyBits)
+	if err != nil {
+		return "", err
+	}
+	txt, err := dkim.TXTRecord(key.Public())
+	if err != nil {
+		return "", err
+	}
+	pubPEM, err := dkim.PublicKeyPEM(key.Public())
+	if err != nil {
+		return "", err
+	}
+	bits := r.cfg.KeyBits
+	if r.cfg.Algorithm == dkim.Ed25519SHA256 {
+		bits = 256
+	}
+
+	// Selectors are dated so DNS shows when a key was introduced.
+	base := "s" + r.now().UTC().Format("200601")
+	for _, suffix := range []string{"", "b", "c", "d", "e"} {
+		selector := base + suffix
+		res, err := r.db.ExecContext(ctx, `
+			INSERT INTO mailing_dkim_keys
+				(organization_id, domain, selector, private_key_encrypted, public_key,
+				 algorithm, key_size, dns_record_value, active, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, false, 'pending')
+			ON CONFLICT (domain, selector) DO NOTHING`,
+			orgID, domain, selector, pemBytes, pubPEM, string(r.cfg.Algorithm), bits, txt)
+		if err != nil {
+			return "", fmt.Errorf("insert DKIM key: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			log.Printf("[DKIMRotator] generated %s key %s", r.cfg.Algorithm, dkim.RecordName(selector, domain))
+			return selector, nil
+		}
+	}
+	return "", fmt.Errorf("no free selector for %s in %s", domain, base)
+}
+
+// publishPending upserts the TXT record of every pending key.
+func (r *DKIMRotator) publishPending(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, organization_id, domain, selector, dns_record_value
+		FROM mailing_dkim_keys WHERE status = 'pending'`)
+	if err != nil {
+		return fmt.Errorf("find pending DKIM keys: %w", err)
+	}
+	type pending struct{ id, orgID, domain, selector, txt string }
+	var keys []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.orgID, &p.domain, &p.selector, &p.txt); err == nil {
+			keys = append(keys, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range keys {
+		name := dkim.RecordName(p.selector, p.domain)
+		if _, err := r.dns.CreateDNSRecord(ctx, p.orgID, name, "TXT", dkim.QuoteTXT(p.txt), r.cfg.TTL); err != nil {
+			log.Printf("[DKIMRotator] publish %s: %v", name, err)
+			continue
+		}
+		if _, err := r.db.ExecContext(ctx, `
+			UPDATE mailing_dkim_keys SET status = 'published', published_at = $1
+			WHERE id = $2 AND status = 'pending'`, r.now(), p.id); err != nil {
+			return fmt.Errorf("mark %s published: %w", name, err)
+		}
+		log.Printf("[DKIMRotator] published %s", name)
+	}
+	return nil
+}
+
+// activatePublished switches signing to published keys that resolve in
+// DNS, retiring the keys they replace.
+func (r *DKIMRotator) activatePublished(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, domain, selector, dns_record_value
+		FROM mailing_dkim_keys WHERE status = 'published' AND published_at < $1`,
+		r.now().Add(-r.cfg.MinPublished))
+	if err != nil {
+		return fmt.Errorf("find published DKIM keys: %w", err)
+	}
+	type published struct{ id, domain, selector, txt string }
+	var keys []published
+	for rows.Next() {
+		var p published
+		if err := rows.Scan(&p.id, &p.domain, &p.selector, &p.txt); err == nil {
+			keys = append(keys, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range keys {
+		name := dkim.RecordName(p.selector, p.domain)
+		txts, err := r.lookupTXT(ctx, name)
+		if err != nil || !txtMatches(txts, p.txt) {
+			log.Printf("[DKIMRotator] %s not visible in DNS yet", name)
+			continue
+		}
+		if err := r.activate(ctx, p.id, p.domain); err != nil {
+			return fmt.Errorf("activate %s: %w", name, err)
+		}
+		if r.store != nil {
+			r.store.Invalidate(p.domain)
+		}
+		log.Printf("[DKIMRotator] %s is now the signing key for %s", name, p.domain)
+	}
+	return nil
+}
+
+func (r *DKIMRotator) activate(ctx context.Context, id, domain string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := r.now()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE mailing_dkim_keys SET active = false, status = 'retired', retired_at = $1
+		WHERE domain = $2 AND status = 'active'`, now, domain); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE mailing_dkim_keys SET active = true, status = 'active', activated_at = $1, dns_verified = true
+		WHERE id = $2`, now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// txtMatches reports whether one of the resolved TXT strings carries the
+// expected record, ignoring whitespace.
+func txtMatches(txts []string, expected string) bool {
+	want := strings.Join(strings.Fields(expected), "")
+	for _, txt := range txts {
+		if strings.Join(strings.Fields(txt), "") == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package mailing
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/dkim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDKIMKeyStore_SignsWithActiveKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, pemBytes, err := dkim.GenerateKey(dkim.Ed25519SHA256, 0)
+	require.NoError(t, err)
+
+	// No key for the subdomain; the organizational domain has one.
+	mock.ExpectQuery("FROM mailing_dkim_keys").WithArgs("news.example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"selector", "private_key_encrypted"}))
+	mock.ExpectQuery("FROM mailing_dkim_keys").WithArgs("example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"selector", "private_key_encrypted"}).AddRow("s202610", pemBytes))
+
+	store := NewDKIMKeyStore(db)
+	msg := []byte("From: deals@news.example.com\r\nTo: a@example.org\r\n\r\nhi\r\n")
+	for i := 0; i < 2; i++ {
+		signed, err := store.Sign(context.Background(), "News.Example.com", "", msg)
+		require.NoError(t, err)
+		assert.Contains(t, string(signed), "d=example.com; s=s202610;")
+	}
+	require.NoError(t, mock.ExpectationsWereMet(), "keys and misses are cached")
+
+	unsigned, err := NewDKIMKeyStore(db).Sign(context.Background(), "localhost", "", msg)
+	require.NoError(t, err)
+	assert.Equal(t, msg, unsigned)
+}
+
+type fakeDNS struct {
+	records map[string]string
+}
+
+func (f *fakeDNS) CreateDNSRecord(ctx context.Context, orgID, name, typ, value string, ttl int) (*Route53Record, error) {
+	f.records[name] = value
+	return &Route53Record{RecordName: name, RecordType: typ, RecordValue: value, TTL: ttl}, nil
+}
+
+func TestDKIMRotator_PublishesBeforeActivating(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	dns := &fakeDNS{records: map[string]string{}}
+	r := NewDKIMRotator(db, dns, DKIMRotationConfig{Algorithm: dkim.Ed25519SHA256})
+	r.now = func() time.Time { return now }
+	var resolved []string
+	r.lookupTXT = func(ctx context.Context, name string) ([]string, error) { return resolved, nil }
+
+	// Run 1: the key is due, a pending one is generated and published.
+	mock.ExpectQuery("HAVING MAX").WithArgs(now.Add(-90 * 24 * time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id", "domain"}).AddRow("org-1", "example.com"))
+	mock.ExpectExec("INSERT INTO mailing_dkim_keys").
+		WithArgs("org-1", "example.com", "s202610", sqlmock.AnyArg(), sqlmock.AnyArg(), "ed25519-sha256", 256, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0)) // selector taken
+	mock.ExpectExec("INSERT INTO mailing_dkim_keys").
+		WithArgs("org-1", "example.com", "s202610b", sqlmock.AnyArg(), sqlmock.AnyArg(), "ed25519-sha256", 256, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("WHERE status = 'pending'").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id", "domain", "selector", "dns_record_value"}).
+			AddRow("k2", "org-1", "example.com", "s202610b", "v=DKIM1; k=ed25519; p=AAAA"))
+	mock.ExpectExec("SET status = 'published'").WithArgs(now, "k2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("WHERE status = 'published'").WithArgs(now.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "domain", "selector", "dns_record_value"}))
+	require.NoError(t, r.RunOnce(context.Background()))
+	assert.Equal(t, map[string]string{"s202610b._domainkey.example.com": `"v=DKIM1; k=ed25519; p=AAAA"`}, dns.records)
+
+	// Run 2: the record is not visible yet, nothing is activated.
+	nothingDue := func() {
+		mock.ExpectQuery("HAVING MAX").WillReturnRows(sqlmock.NewRows([]string{"organization_id", "domain"}))
+		mock.ExpectQuery("WHERE status = 'pending'").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id", "domain", "selector", "dns_record_value"}))
+		mock.ExpectQuery("WHERE status = 'published'").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "domain", "selector", "dns_record_value"}).
+				AddRow("k2", "example.com", "s202610b", "v=DKIM1; k=ed25519; p=AAAA"))
+	}
+	nothingDue()
+	require.NoError(t, r.RunOnce(context.Background()))
+
+	// Run 3: it resolves, the old key is retired and the new one signs.
+	resolved = []string{"v=DKIM1;k=ed25519;p=AAAA"}
+	nothingDue()
+	mock.ExpectBegin()
+	mock.ExpectExec("SET active = false, status = 'retired'").WithArgs(now, "example.com").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SET active = true, status = 'active'").WithArgs(now, "k2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, r.RunOnce(context.Background()))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTXTMatches(t *testing.T) {
+	long := "v=DKIM1; k=rsa; p=" + strings.Repeat("A", 300)
+	assert.True(t, txtMatches([]string{"v=spf1 -all", strings.ReplaceAll(long, "; ", ";")}, long))
+	assert.False(t, txtMatches([]string{"v=DKIM1; k=rsa; p=BBBB"}, long))
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/botdetect"
 )
 
 // TrackingService handles email tracking (opens, clicks, unsubscribes)
@@ -408,30 +409,24 @@ func detectDevice(userAgent string) string {
 	return "desktop"
 }
 
-// BotDetector detects bot traffic
+// BotDetector detects bot traffic using the shared botdetect classifier
 type BotDetector struct {
-	botPatterns []string
+	classifier *botdetect.Classifier
 }
 
 // NewBotDetector creates a new bot detector
 func NewBotDetector() *BotDetector {
-	return &BotDetector{
-		botPatterns: []string{
-			"bot", "crawler", "spider", "slurp", "googlebot", "bingbot",
-			"yahoo", "baidu", "yandex", "preview", "proxy", "scanner",
-		},
-	}
+	return &BotDetector{classifier: botdetect.Default()}
 }
 
 // IsBot checks if the user agent is a bot
 func (bd *BotDetector) IsBot(userAgent string) bool {
-	ua := strings.ToLower(userAgent)
-	for _, pattern := range bd.botPatterns {
-		if strings.Contains(ua, pattern) {
-			return true
-		}
-	}
-	return false
+	return bd.classifier.IsBotUserAgent(userAgent)
+}
+
+// Classify classifies a tracking hit as human or machine traffic
+func (bd *BotDetector) Classify(s botdetect.Signals) botdetect.Verdict {
+	return bd.classifier.Classify(s)
 }
 
 // WebhookHandler handles ESP webhooks (bounces, complaints, etc.)
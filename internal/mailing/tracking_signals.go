@@ -0,0 +1,54 @@
+package mailing
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/botdetect"
+)
+
+// InsertBeforeBodyClose inserts snippet before the last </body> tag, matched
+// case-insensitively, or appends it when the HTML has none.
+func InsertBeforeBodyClose(html, snippet string) string {
+	if idx := strings.LastIndex(strings.ToLower(html), "</body>"); idx >= 0 {
+		return html[:idx] + snippet + html[idx:]
+	}
+	return html + snippet
+}
+
+// DeliveredAt returns when the campaign was delivered to the subscriber, or
+// the zero time when no delivery event is recorded.
+func DeliveredAt(ctx context.Context, db *sql.DB, subscriberID, campaignID uuid.UUID) time.Time {
+	var at time.Time
+	db.QueryRowContext(ctx, `
+		SELECT event_at FROM mailing_tracking_events
+		WHERE subscriber_id = $1 AND campaign_id = $2 AND event_type = 'delivered'
+		ORDER BY event_at DESC LIMIT 1
+	`, subscriberID, campaignID).Scan(&at)
+	return at
+}
+
+// RecentClicks returns the subscriber's clicks on the campaign in the minute
+// before at, for burst detection.
+func RecentClicks(ctx context.Context, db *sql.DB, subscriberID, campaignID uuid.UUID, at time.Time) []botdetect.Click {
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(link_url, ''), event_at FROM mailing_tracking_events
+		WHERE subscriber_id = $1 AND campaign_id = $2 AND event_type = 'clicked'
+		  AND event_at >= $3
+	`, subscriberID, campaignID, at.Add(-time.Minute))
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var clicks []botdetect.Click
+	for rows.Next() {
+		var c botdetect.Click
+		if err := rows.Scan(&c.URL, &c.At); err == nil {
+			clicks = append(clicks, c)
+		}
+	}
+	return clicks
+}
@@ -50,3 +50,23 @@ func TestValidateEmailTracking(t *testing.T) {
 		})
 	}
 }
+
+func TestInsertBeforeBodyClose(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"<html><body>hi</body></html>", "<html><body>hi<img></body></html>"},
+		{"<HTML><BODY>hi</BODY></HTML>", "<HTML><BODY>hi<img></BODY></HTML>"},
+		{"<body>a</body><body>b</Body>", "<body>a</body><body>b<img></Body>"},
+		{"plain", "plain<img>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.html, func(t *testing.T) {
+			if got := InsertBeforeBodyClose(tt.html, "<img>"); got != tt.want {
+				t.Errorf("InsertBeforeBodyClose(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
@@ -19,6 +19,8 @@ type VariantEvaluator struct {
 	maxWaitHours  int
 	pThreshold    float64
 	minEffect     float64
+	abOptions     ABOptions
+	excludeMachine bool
 	ctx           context.Context
 	cancel        context.CancelFunc
 	lastRunAt     time.Time
@@ -33,10 +35,17 @@ func NewVariantEvaluator(db *sql.DB) *VariantEvaluator {
 		maxWaitHours:  24,
 		pThreshold:    0.05,
 		minEffect:     0.005, // 0.5 percentage points
+		abOptions:     DefaultABOptions(),
+		excludeMachine: true,
 		healthy:       true,
 	}
 }
 
+// SetExcludeMachineEvents controls whether opens and clicks classified as
+// machine traffic (MPP prefetches, scanner clicks) are left out of variant
+// metrics. Enabled by default.
+func (ve *VariantEvaluator) SetExcludeMachineEvents(exclude bool) { ve.excludeMachine = exclude }
+
 func (ve *VariantEvaluator) Start() {
 	ve.ctx, ve.cancel = context.WithCancel(context.Background())
 	go func() {
@@ -73,7 +82,9 @@ func (ve *VariantEvaluator) runOnce() {
 	ctx := ve.ctx
 
 	rows, err := ve.db.QueryContext(ctx,
-		`SELECT id, campaign_id, created_at FROM mailing_ab_tests WHERE status = 'running'`)
+		`SELECT id, campaign_id, created_at, COALESCE(evaluation_method, ''),
+			COALESCE(winner_metric, 'open_rate'), COALESCE(winner_confidence_threshold, 0.95)
+		FROM mailing_ab_tests WHERE status = 'running'`)
 	if err != nil {
 		log.Printf("[VariantEvaluator] query error: %v", err)
 		ve.healthy = false
@@ -81,41 +92,105 @@ func (ve *VariantEvaluator) runOnce() {
 	}
 	defer rows.Close()
 
+	var tests []abTestConfig
 	for rows.Next() {
-		var testID, campaignID uuid.UUID
-		var createdAt time.Time
-		if err := rows.Scan(&testID, &campaignID, &createdAt); err != nil {
+		var t abTestConfig
+		var method string
+		if err := rows.Scan(&t.id, &t.campaignID, &t.startedAt, &method, &t.metric, &t.confidence); err != nil {
 			continue
 		}
-		if err := ve.evaluateTest(ctx, testID, campaignID, createdAt); err != nil {
-			log.Printf("[VariantEvaluator] evaluate test %s error: %v", testID, err)
+		t.method = ABMethod(method)
+		switch {
+		case method == "":
+			// Tests created before evaluation methods keep the z-test
+			// they were started with
+			t.method = ABMethodZTest
+		case !ValidABMethod(t.method):
+			t.method = DefaultABMethod
+		}
+		tests = append(tests, t)
+	}
+	rows.Close()
+
+	for _, t := range tests {
+		if err := ve.evaluateTest(ctx, t); err != nil {
+			log.Printf("[VariantEvaluator] evaluate test %s error: %v", t.id, err)
 		}
 	}
 }
 
+// abTestConfig is the part of a mailing_ab_tests row the evaluator needs.
+type abTestConfig struct {
+	id, campaignID uuid.UUID
+	startedAt      time.Time
+	method         ABMethod
+	metric         string
+	confidence     float64
+}
+
 type variantMetrics struct {
-	variantID  uuid.UUID
-	sends      int
-	opens      int
-	clicks     int
-	openRate   float64
-	clickRate  float64
+	variantID    uuid.UUID
+	sends        int
+	opens        int
+	clicks       int
+	conversions  int
+	unsubscribes int
+	openRate     float64
+	clickRate    float64
+}
+
+// WinnerMetrics lists the winner_metric values the evaluator can decide a
+// test on.
+var WinnerMetrics = []string{"open_rate", "click_rate", "click_to_open_rate", "conversion_rate", "unsubscribe_rate"}
+
+// ValidWinnerMetric reports whether the evaluator can decide on metric.
+func ValidWinnerMetric(metric string) bool {
+	for _, m := range WinnerMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
 }
 
-func (ve *VariantEvaluator) evaluateTest(ctx context.Context, testID, campaignID uuid.UUID, testStarted time.Time) error {
+// arm returns the variant's outcome for the test's winner metric, as a
+// proportion where higher is better. For unsubscribe_rate, where lower is
+// better, the success is a send that did not unsubscribe. It returns false
+// for metrics the evaluator does not track.
+func (m variantMetrics) arm(metric string) (ABArm, bool) {
+	switch metric {
+	case "open_rate":
+		return ABArm{Trials: m.sends, Successes: m.opens}, true
+	case "click_rate":
+		return ABArm{Trials: m.sends, Successes: m.clicks}, true
+	case "click_to_open_rate":
+		return ABArm{Trials: m.opens, Successes: min(m.clicks, m.opens)}, true
+	case "conversion_rate":
+		return ABArm{Trials: m.sends, Successes: m.conversions}, true
+	case "unsubscribe_rate":
+		return ABArm{Trials: m.sends, Successes: max(0, m.sends-m.unsubscribes)}, true
+	}
+	return ABArm{}, false
+}
+
+func (ve *VariantEvaluator) evaluateTest(ctx context.Context, t abTestConfig) error {
 	vRows, err := ve.db.QueryContext(ctx,
-		`SELECT id FROM mailing_ab_variants WHERE test_id = $1`, testID)
+		`SELECT id FROM mailing_ab_variants WHERE test_id = $1`, t.id)
 	if err != nil {
 		return err
 	}
-	defer vRows.Close()
-
-	var metrics []variantMetrics
+	var variantIDs []uuid.UUID
 	for vRows.Next() {
 		var vid uuid.UUID
 		if err := vRows.Scan(&vid); err != nil {
 			continue
 		}
+		variantIDs = append(variantIDs, vid)
+	}
+	vRows.Close()
+
+	var metrics []variantMetrics
+	for _, vid := range variantIDs {
 		m, err := ve.computeVariantMetrics(ctx, vid)
 		if err != nil {
 			continue
@@ -127,6 +202,22 @@ func (ve *VariantEvaluator) evaluateTest(ctx context.Context, testID, campaignID
 		return nil
 	}
 
+	arms := make([]ABArm, len(metrics))
+	for i, m := range metrics {
+		arm, ok := m.arm(t.metric)
+		if !ok {
+			log.Printf("[VariantEvaluator] Test %s: winner metric %q cannot be evaluated; pick a winner manually", t.id, t.metric)
+			return nil
+		}
+		arms[i] = arm
+	}
+	opts := ve.abOptions
+	if t.confidence > 0 && t.confidence < 1 {
+		opts.Confidence = t.confidence
+	}
+	ev := EvaluateAB(t.method, arms, opts)
+	ve.recordSnapshots(ctx, t.id, metrics, ev)
+
 	// Check if all variants have minimum sample
 	allReady := true
 	for _, m := range metrics {
@@ -137,40 +228,26 @@ func (ve *VariantEvaluator) evaluateTest(ctx context.Context, testID, campaignID
 	}
 
 	// H18: Force decision after maxWaitHours even if not significant
-	timedOut := time.Since(testStarted) > time.Duration(ve.maxWaitHours)*time.Hour
+	timedOut := time.Since(t.startedAt) > time.Duration(ve.maxWaitHours)*time.Hour
 
 	if !allReady && !timedOut {
 		return nil
 	}
 
-	// Find best performer by open rate
-	bestIdx := 0
-	for i, m := range metrics {
-		if m.openRate > metrics[bestIdx].openRate {
-			bestIdx = i
-		}
-	}
+	bestIdx := ev.Best
+	significant := ev.Decided
 
-	// Check statistical significance between best and second best
-	significant := false
-	if len(metrics) == 2 {
-		significant = ve.isStatisticallySignificant(
-			metrics[0].openRate, metrics[1].openRate,
-			metrics[0].sends, metrics[1].sends,
-		)
-	}
-
-	// H18: Early stopping — 3+ standard deviations ahead after 500 samples
+	// H18: Early stopping — 3+ standard deviations ahead after 500 samples.
+	// Only the fixed-horizon z-test needs this; the sequential and Bayesian
+	// methods are safe to read on every run.
 	earlyStop := false
-	if len(metrics) == 2 && metrics[0].sends >= 500 && metrics[1].sends >= 500 {
-		best := metrics[bestIdx]
-		other := metrics[1-bestIdx]
-		if best.sends > 0 && other.sends > 0 {
-			pooledSE := math.Sqrt(best.openRate*(1-best.openRate)/float64(best.sends) +
-				other.openRate*(1-other.openRate)/float64(other.sends))
-			if pooledSE > 0 && math.Abs(best.openRate-other.openRate)/pooledSE >= 3.0 {
-				earlyStop = true
-			}
+	if t.method == ABMethodZTest && len(metrics) == 2 && metrics[0].sends >= 500 && metrics[1].sends >= 500 {
+		best := arms[bestIdx]
+		other := arms[1-bestIdx]
+		pooledSE := math.Sqrt(best.Rate()*(1-best.Rate())/float64(best.Trials) +
+			other.Rate()*(1-other.Rate())/float64(other.Trials))
+		if pooledSE > 0 && math.Abs(best.Rate()-other.Rate())/pooledSE >= 3.0 {
+			earlyStop = true
 		}
 	}
 
@@ -179,7 +256,13 @@ func (ve *VariantEvaluator) evaluateTest(ctx context.Context, testID, campaignID
 	}
 
 	// H18: Don't declare winner for trivial differences
-	if len(metrics) == 2 && math.Abs(metrics[0].openRate-metrics[1].openRate) < ve.minEffect && !timedOut {
+	runnerUp := 0.0
+	for i, a := range arms {
+		if i != bestIdx && a.Rate() > runnerUp {
+			runnerUp = a.Rate()
+		}
+	}
+	if arms[bestIdx].Rate()-runnerUp < ve.minEffect && !timedOut {
 		return nil
 	}
 
@@ -190,29 +273,53 @@ func (ve *VariantEvaluator) evaluateTest(ctx context.Context, testID, campaignID
 		`UPDATE mailing_ab_variants SET is_winner = TRUE WHERE id = $1`, winner.variantID)
 	ve.db.ExecContext(ctx,
 		`UPDATE mailing_ab_tests SET status = 'completed', winner_variant_id = $1, completed_at = NOW() WHERE id = $2`,
-		winner.variantID, testID)
+		winner.variantID, t.id)
 
 	// Record learning
-	ve.recordLearning(ctx, testID, campaignID, winner)
+	ve.recordLearning(ctx, t.id, t.campaignID, winner)
 
-	log.Printf("[VariantEvaluator] Test %s: winner=%s open_rate=%.4f (significant=%v timeout=%v early=%v)",
-		testID, winner.variantID, winner.openRate, significant, timedOut, earlyStop)
+	log.Printf("[VariantEvaluator] Test %s: winner=%s method=%s %s=%.4f (significant=%v timeout=%v early=%v)",
+		t.id, winner.variantID, t.method, t.metric, arms[bestIdx].Rate(), significant, timedOut, earlyStop)
 
 	return nil
 }
 
+// recordSnapshots writes one mailing_ab_result_snapshots row per variant so
+// the timeline shows how the evaluation evolved.
+func (ve *VariantEvaluator) recordSnapshots(ctx context.Context, testID uuid.UUID, metrics []variantMetrics, ev ABEvaluation) {
+	for i, m := range metrics {
+		r := ev.Arms[i]
+		if _, err := ve.db.ExecContext(ctx,
+			`INSERT INTO mailing_ab_result_snapshots
+				(test_id, variant_id, sent_count, open_count, click_count, open_rate, click_rate,
+				 confidence_score, evaluation_method, prob_best, expected_loss, p_value, is_significant, is_best)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+			testID, m.variantID, m.sends, m.opens, m.clicks, m.openRate, m.clickRate,
+			r.Confidence(ev.Method), string(ev.Method), r.ProbBest, r.ExpectedLoss, r.PValue, r.Significant, i == ev.Best,
+		); err != nil {
+			log.Printf("[VariantEvaluator] snapshot test %s variant %s error: %v", testID, m.variantID, err)
+		}
+	}
+}
+
 func (ve *VariantEvaluator) computeVariantMetrics(ctx context.Context, variantID uuid.UUID) (variantMetrics, error) {
 	m := variantMetrics{variantID: variantID}
+	humanOnly := ""
+	if ve.excludeMachine {
+		humanOnly = ` AND COALESCE(metadata->>'traffic_class', 'human') = 'human'`
+	}
 
-	ve.db.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM subscriber_events WHERE variant_id = $1 AND event_type = 'send'`,
-		variantID).Scan(&m.sends)
-	ve.db.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM subscriber_events WHERE variant_id = $1 AND event_type = 'open'`,
-		variantID).Scan(&m.opens)
-	ve.db.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM subscriber_events WHERE variant_id = $1 AND event_type = 'click'`,
-		variantID).Scan(&m.clicks)
+	err := ve.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE event_type = 'send'),
+			COUNT(*) FILTER (WHERE event_type = 'open'`+humanOnly+`),
+			COUNT(*) FILTER (WHERE event_type = 'click'`+humanOnly+`),
+			COUNT(*) FILTER (WHERE event_type = 'conversion'),
+			COUNT(*) FILTER (WHERE event_type = 'unsubscribe')
+		FROM subscriber_events WHERE variant_id = $1`,
+		variantID).Scan(&m.sends, &m.opens, &m.clicks, &m.conversions, &m.unsubscribes)
+	if err != nil {
+		return m, err
+	}
 
 	if m.sends > 0 {
 		m.openRate = float64(m.opens) / float64(m.sends)
@@ -221,22 +328,11 @@ func (ve *VariantEvaluator) computeVariantMetrics(ctx context.Context, variantID
 	return m, nil
 }
 
-// isStatisticallySignificant performs a Z-test for two proportions.
+// isStatisticallySignificant performs a Z-test for two proportions at
+// pThreshold. It is a single fixed-horizon look; see ABMethodSequential for
+// repeated evaluation.
 func (ve *VariantEvaluator) isStatisticallySignificant(rateA, rateB float64, nA, nB int) bool {
-	if nA == 0 || nB == 0 {
-		return false
-	}
-	pooled := (rateA*float64(nA) + rateB*float64(nB)) / float64(nA+nB)
-	if pooled <= 0 || pooled >= 1 {
-		return false
-	}
-	se := math.Sqrt(pooled * (1 - pooled) * (1.0/float64(nA) + 1.0/float64(nB)))
-	if se == 0 {
-		return false
-	}
-	z := math.Abs(rateA-rateB) / se
-	// p < 0.05 corresponds to |z| > 1.96
-	return z > 1.96
+	return twoProportionPValue(rateA, rateB, nA, nB) < ve.pThreshold
 }
 
 func (ve *VariantEvaluator) recordLearning(ctx context.Context, testID, campaignID uuid.UUID, winner variantMetrics) {
@@ -1,7 +1,12 @@
 package mailing
 
 import (
+	"context"
+	"regexp"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
 )
 
 func TestStatisticalSignificance(t *testing.T) {
@@ -34,3 +39,35 @@ func TestStatisticalSignificance(t *testing.T) {
 		})
 	}
 }
+
+func TestComputeVariantMetricsExcludesMachineTraffic(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	id := uuid.New()
+	human := regexp.QuoteMeta(`AND COALESCE(metadata->>'traffic_class', 'human') = 'human'`)
+	mock.ExpectQuery(`event_type = 'open' ` + human + `.*event_type = 'click' ` + human).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"sends", "opens", "clicks", "conversions", "unsubscribes"}).AddRow(1000, 150, 20, 0, 0))
+
+	m, err := NewVariantEvaluator(db).computeVariantMetrics(context.Background(), id)
+	if err != nil {
+		t.Fatalf("computeVariantMetrics() error = %v", err)
+	}
+	if m.opens != 150 || m.clicks != 20 || m.openRate != 0.15 {
+		t.Errorf("metrics = %+v", m)
+	}
+
+	ve := NewVariantEvaluator(db)
+	ve.SetExcludeMachineEvents(false)
+	mock.ExpectQuery(`event_type = 'open'\),.*event_type = 'click'\),`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"sends", "opens", "clicks", "conversions", "unsubscribes"}).AddRow(1000, 400, 30, 0, 0))
+	if m, _ = ve.computeVariantMetrics(context.Background(), id); m.opens != 400 {
+		t.Errorf("opens with machine traffic = %d, want 400", m.opens)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
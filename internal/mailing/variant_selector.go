@@ -5,10 +5,12 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/binary"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
 // VariantContent holds the subject/HTML override from an A/B variant.
@@ -17,38 +19,66 @@ type VariantContent struct {
 	Subject     string
 	HTMLContent string
 	FromName    string
+	// Allocation is how the variant was chosen: static, holdout, thompson
+	// or ucb.
+	Allocation AllocationMode
 }
 
+// AllocationHoldout marks deterministic assignments made for the holdout
+// group of a bandit test.
+const AllocationHoldout AllocationMode = "holdout"
+
 // variantCacheEntry stores loaded variants with a TTL.
 type variantCacheEntry struct {
 	variants  []ABVariant
 	winner    *ABVariant
+	bandit    *banditConfig
 	fetchedAt time.Time
 }
 
-// VariantSelector deterministically assigns a subscriber to an A/B variant.
-// It caches variant lists per campaign with a configurable TTL (H6).
+// VariantSelector assigns a subscriber to an A/B variant, either
+// deterministically by email hash or, for tests in a bandit allocation mode,
+// in proportion to live bandit weights. It caches variant lists per campaign
+// with a configurable TTL (H6).
 type VariantSelector struct {
 	db    *sql.DB
+	redis *redis.Client
 	cache map[uuid.UUID]*variantCacheEntry
 	mu    sync.RWMutex
 	ttl   time.Duration
+
+	bandits     map[uuid.UUID]*banditState
+	banditTTL   time.Duration
+	banditDraws int
 }
 
 func NewVariantSelector(db *sql.DB) *VariantSelector {
 	return &VariantSelector{
-		db:    db,
-		cache: make(map[uuid.UUID]*variantCacheEntry),
-		ttl:   5 * time.Minute,
+		db:          db,
+		cache:       make(map[uuid.UUID]*variantCacheEntry),
+		ttl:         5 * time.Minute,
+		bandits:     make(map[uuid.UUID]*banditState),
+		banditTTL:   time.Minute,
+		banditDraws: 10000,
 	}
 }
 
+// SetRedis shares bandit posteriors across workers.
+func (vs *VariantSelector) SetRedis(client *redis.Client) {
+	vs.redis = client
+}
+
 // SelectVariant returns the variant content for a given email+campaign.
 // Uses SHA256(email + campaign_id) for deterministic assignment.
 // If no A/B test exists for this campaign, returns nil.
 // If the test has a declared winner, always returns the winner.
+//
+// For thompson/ucb tests the hash still places holdout_percent of emails
+// in a holdout that keeps the deterministic assignment; the rest are mapped
+// onto the bandit weights, so an email keeps its variant while the weights
+// are unchanged.
 func (vs *VariantSelector) SelectVariant(ctx context.Context, campaignID uuid.UUID, email string) (*VariantContent, error) {
-	variants, winner, err := vs.getVariants(ctx, campaignID)
+	variants, winner, bandit, err := vs.getVariants(ctx, campaignID)
 	if err != nil || len(variants) == 0 {
 		return nil, err
 	}
@@ -59,6 +89,7 @@ func (vs *VariantSelector) SelectVariant(ctx context.Context, campaignID uuid.UU
 			Subject:     winner.VariantValue,
 			HTMLContent: "",
 			FromName:    "",
+			Allocation:  AllocationStatic,
 		}, nil
 	}
 
@@ -67,6 +98,21 @@ func (vs *VariantSelector) SelectVariant(ctx context.Context, campaignID uuid.UU
 	if idx < 0 {
 		idx = -idx
 	}
+	allocation := AllocationStatic
+
+	if bandit != nil && bandit.mode != AllocationStatic {
+		allocation = AllocationHoldout
+		if int(binary.BigEndian.Uint64(hash[8:16])%100) >= bandit.holdoutPercent {
+			weights, err := vs.banditWeights(ctx, bandit, variants)
+			if err != nil {
+				log.Printf("[VariantSelector] bandit weights for test %s: %v", bandit.testID, err)
+			} else {
+				u := float64(binary.BigEndian.Uint64(hash[16:24])>>11) / (1 << 53)
+				idx = pickWeighted(weights, u)
+				allocation = bandit.mode
+			}
+		}
+	}
 
 	v := variants[idx]
 	return &VariantContent{
@@ -74,24 +120,29 @@ func (vs *VariantSelector) SelectVariant(ctx context.Context, campaignID uuid.UU
 		Subject:     v.VariantValue,
 		HTMLContent: "",
 		FromName:    "",
+		Allocation:  allocation,
 	}, nil
 }
 
-func (vs *VariantSelector) getVariants(ctx context.Context, campaignID uuid.UUID) ([]ABVariant, *ABVariant, error) {
+func (vs *VariantSelector) getVariants(ctx context.Context, campaignID uuid.UUID) ([]ABVariant, *ABVariant, *banditConfig, error) {
 	vs.mu.RLock()
 	cached, ok := vs.cache[campaignID]
 	vs.mu.RUnlock()
 
 	if ok && time.Since(cached.fetchedAt) < vs.ttl {
-		return cached.variants, cached.winner, nil
+		return cached.variants, cached.winner, cached.bandit, nil
 	}
 
 	variants, err := vs.loadVariants(ctx, campaignID)
 	if err != nil {
 		if ok {
-			return cached.variants, cached.winner, nil
+			return cached.variants, cached.winner, cached.bandit, nil
 		}
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	bandit, err := vs.loadBanditConfig(ctx, campaignID)
+	if err != nil {
+		log.Printf("[VariantSelector] allocation config for campaign %s: %v", campaignID, err)
 	}
 
 	var winner *ABVariant
@@ -106,20 +157,49 @@ func (vs *VariantSelector) getVariants(ctx context.Context, campaignID uuid.UUID
 	vs.cache[campaignID] = &variantCacheEntry{
 		variants:  variants,
 		winner:    winner,
+		bandit:    bandit,
 		fetchedAt: time.Now(),
 	}
 	vs.mu.Unlock()
 
-	return variants, winner, nil
+	return variants, winner, bandit, nil
+}
+
+// loadBanditConfig reads the allocation settings of the campaign's running
+// test. It returns nil for static tests.
+func (vs *VariantSelector) loadBanditConfig(ctx context.Context, campaignID uuid.UUID) (*banditConfig, error) {
+	var cfg banditConfig
+	var mode, reward string
+	err := vs.db.QueryRowContext(ctx,
+		`SELECT id, COALESCE(allocation_mode, 'static'),
+		        COALESCE(reward_metric, winner_metric, 'open_rate'),
+		        COALESCE(exploration_floor, 0.05), COALESCE(holdout_percent, 10)
+		FROM mailing_ab_tests
+		WHERE campaign_id = $1 AND status = 'running'
+		ORDER BY created_at DESC LIMIT 1`, campaignID,
+	).Scan(&cfg.testID, &mode, &reward, &cfg.floor, &cfg.holdoutPercent)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg.mode = AllocationMode(mode)
+	if cfg.mode != AllocationThompson && cfg.mode != AllocationUCB {
+		return nil, nil
+	}
+	cfg.rewardMetric = rewardMetricFor(reward)
+	return &cfg, nil
 }
 
 func (vs *VariantSelector) loadVariants(ctx context.Context, campaignID uuid.UUID) ([]ABVariant, error) {
 	rows, err := vs.db.QueryContext(ctx,
-		`SELECT v.id, v.campaign_id, v.variant_name, COALESCE(v.variant_type,''), COALESCE(v.variant_value,''),
-		        v.traffic_percentage, v.is_winner
+		`SELECT v.id, t.campaign_id, v.variant_name, 'subject', COALESCE(v.subject, ''),
+		        COALESCE(v.split_percent, 0), COALESCE(v.is_winner, FALSE)
 		FROM mailing_ab_variants v
-		JOIN mailing_ab_tests t ON t.campaign_id = v.campaign_id
-		WHERE v.campaign_id = $1 AND t.status IN ('running', 'completed')
+		JOIN mailing_ab_tests t ON t.id = v.test_id
+		WHERE t.campaign_id = $1 AND t.status IN ('running', 'completed')
+		  AND COALESCE(v.status, 'active') != 'eliminated'
 		ORDER BY v.variant_name`, campaignID)
 	if err != nil {
 		return nil, err
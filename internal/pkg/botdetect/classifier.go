@@ -0,0 +1,344 @@
+package botdetect
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Class is the traffic classification of a tracking event.
+type Class string
+
+const (
+	ClassHuman        Class = "human"
+	ClassMachineOpen  Class = "machine_open"  // open fetched by software, not a reader
+	ClassMPPProxy     Class = "mpp_proxy"     // Apple Mail Privacy Protection prefetch
+	ClassScannerClick Class = "scanner_click" // click made by a link scanner
+)
+
+// IsMachine reports whether the class is anything other than human.
+// An empty class (events recorded before classification) counts as human.
+func (c Class) IsMachine() bool {
+	return c != "" && c != ClassHuman
+}
+
+// Reason is a signal that contributed to a classification.
+type Reason string
+
+const (
+	ReasonAppleProxyIP      Reason = "apple_proxy_ip"
+	ReasonGoogleImageProxy  Reason = "google_image_proxy"
+	ReasonBotUserAgent      Reason = "bot_user_agent"
+	ReasonFastAfterDelivery Reason = "fast_after_delivery"
+	ReasonHoneypotLink      Reason = "honeypot_link"
+	ReasonClickBurst        Reason = "click_burst"
+)
+
+// Kind is the tracking event being classified.
+type Kind string
+
+const (
+	KindOpen  Kind = "open"
+	KindClick Kind = "click"
+)
+
+// MetadataKey is the JSON metadata key that carries the Class on event
+// tables without a traffic_class column (subscriber_events.metadata,
+// mailing_ab_events.event_data).
+const MetadataKey = "traffic_class"
+
+// HoneypotURL is the destination of the hidden link injected into each
+// message. Readers never see it, so any click on it is a scanner.
+const HoneypotURL = "https://honeypot.invalid/"
+
+// IsHoneypot reports whether a tracked link points at the honeypot.
+func IsHoneypot(url string) bool {
+	return strings.HasPrefix(url, HoneypotURL)
+}
+
+// HoneypotAnchor returns the hidden anchor to inject for a tracked
+// honeypot href.
+func HoneypotAnchor(href string) string {
+	return fmt.Sprintf(`<a href="%s" style="display:none;font-size:0;line-height:0" aria-hidden="true" tabindex="-1"></a>`, href)
+}
+
+// Click is an earlier click by the same recipient on the same message.
+type Click struct {
+	URL string
+	At  time.Time
+}
+
+// Signals is what is known about a tracking hit.
+type Signals struct {
+	Kind        Kind
+	IP          string
+	UserAgent   string
+	At          time.Time
+	DeliveredAt time.Time // zero when the delivery time is unknown
+	LinkURL     string
+	// RecentClicks are the recipient's earlier clicks on the message, at
+	// least those within the burst window.
+	RecentClicks []Click
+	// LinkCount is the number of tracked links in the message, 0 if unknown.
+	LinkCount int
+}
+
+// Verdict is the classification of one event.
+type Verdict struct {
+	Class   Class    `json:"class"`
+	Reasons []Reason `json:"reasons,omitempty"`
+}
+
+// Machine reports whether the event should be excluded from engagement.
+func (v Verdict) Machine() bool {
+	return v.Class.IsMachine()
+}
+
+// ReasonStrings returns the reason codes as strings for storage.
+func (v Verdict) ReasonStrings() []string {
+	out := make([]string, len(v.Reasons))
+	for i, r := range v.Reasons {
+		out[i] = string(r)
+	}
+	return out
+}
+
+// Classifier classifies tracking hits. It is safe for concurrent use.
+type Classifier struct {
+	mu           sync.RWMutex
+	appleNets    []*net.IPNet
+	googleNets   []*net.IPNet
+	botPatterns  []string
+	openWindow   time.Duration
+	clickWindow  time.Duration
+	burstWindow  time.Duration
+	burstMinLink int
+}
+
+// Apple publishes its Mail Privacy Protection egress ranges inside its own
+// 17.0.0.0/8 allocation; Gmail's image proxy fetches from Google ranges.
+var (
+	defaultAppleRanges  = []string{"17.0.0.0/8"}
+	defaultGoogleRanges = []string{
+		"64.233.160.0/19", "66.102.0.0/20", "66.249.80.0/20",
+		"72.14.192.0/18", "74.125.0.0/16", "209.85.128.0/17",
+	}
+	defaultBotPatterns = []string{
+		"bot", "crawler", "spider", "slurp", "baidu", "yandex", "preview",
+		"scanner", "headlesschrome", "phantomjs", "python-requests",
+		"go-http-client", "curl/", "wget", "java/", "barracuda",
+		"mimecast", "proofpoint", "safelinks",
+	}
+)
+
+// NewClassifier creates a classifier with the built-in proxy ranges, bot
+// user-agent patterns and thresholds.
+func NewClassifier() *Classifier {
+	c := &Classifier{
+		botPatterns:  append([]string(nil), defaultBotPatterns...),
+		openWindow:   30 * time.Second,
+		clickWindow:  15 * time.Second,
+		burstWindow:  5 * time.Second,
+		burstMinLink: 3,
+	}
+	if err := c.AddProxyRanges(ReasonAppleProxyIP, defaultAppleRanges...); err != nil {
+		panic(err)
+	}
+	if err := c.AddProxyRanges(ReasonGoogleImageProxy, defaultGoogleRanges...); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// AddProxyRanges adds CIDR ranges for ReasonAppleProxyIP or
+// ReasonGoogleImageProxy.
+func (c *Classifier) AddProxyRanges(reason Reason, cidrs ...string) error {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return fmt.Errorf("botdetect: invalid range %q: %w", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch reason {
+	case ReasonAppleProxyIP:
+		c.appleNets = append(c.appleNets, nets...)
+	case ReasonGoogleImageProxy:
+		c.googleNets = append(c.googleNets, nets...)
+	default:
+		return fmt.Errorf("botdetect: %s is not a proxy reason", reason)
+	}
+	return nil
+}
+
+// AddBotPatterns adds lower-case user-agent substrings that mark automated
+// clients.
+func (c *Classifier) AddBotPatterns(patterns ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range patterns {
+		c.botPatterns = append(c.botPatterns, strings.ToLower(p))
+	}
+}
+
+// SetThresholds sets how soon after delivery an open or click counts as
+// automated, and how many distinct links clicked within burstWindow make a
+// burst. Zero values keep the current setting.
+func (c *Classifier) SetThresholds(openWindow, clickWindow, burstWindow time.Duration, burstLinks int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if openWindow > 0 {
+		c.openWindow = openWindow
+	}
+	if clickWindow > 0 {
+		c.clickWindow = clickWindow
+	}
+	if burstWindow > 0 {
+		c.burstWindow = burstWindow
+	}
+	if burstLinks > 0 {
+		c.burstMinLink = burstLinks
+	}
+}
+
+// IsBotUserAgent reports whether the user agent matches a bot pattern.
+func (c *Classifier) IsBotUserAgent(userAgent string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isBotLocked(userAgent)
+}
+
+// Classify returns the verdict for a tracking hit.
+//
+// Opens: Apple proxy IPs are MPP prefetches; bot user agents and opens
+// within the open window after delivery are machine opens. Gmail's image
+// proxy fetches when the reader views the message, so it is recorded as a
+// reason but stays human unless another signal fires.
+//
+// Clicks: honeypot links, bot user agents, clicks within the click window
+// after delivery and bursts of distinct links are scanner clicks.
+func (c *Classifier) Classify(s Signals) Verdict {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	v := Verdict{Class: ClassHuman}
+	mark := func(class Class, reason Reason) {
+		v.Reasons = append(v.Reasons, reason)
+		if v.Class == ClassHuman || (class == ClassMPPProxy && v.Class == ClassMachineOpen) {
+			v.Class = class
+		}
+	}
+
+	ip := parseIP(s.IP)
+	sinceDelivery := time.Duration(-1)
+	if !s.DeliveredAt.IsZero() && !s.At.IsZero() && !s.At.Before(s.DeliveredAt) {
+		sinceDelivery = s.At.Sub(s.DeliveredAt)
+	}
+
+	switch s.Kind {
+	case KindOpen:
+		if containsIP(c.appleNets, ip) {
+			mark(ClassMPPProxy, ReasonAppleProxyIP)
+		}
+		if containsIP(c.googleNets, ip) || strings.Contains(strings.ToLower(s.UserAgent), "googleimageproxy") {
+			v.Reasons = append(v.Reasons, ReasonGoogleImageProxy)
+		}
+		if c.isBotLocked(s.UserAgent) {
+			mark(ClassMachineOpen, ReasonBotUserAgent)
+		}
+		if sinceDelivery >= 0 && sinceDelivery <= c.openWindow {
+			mark(ClassMachineOpen, ReasonFastAfterDelivery)
+		}
+
+	case KindClick:
+		if IsHoneypot(s.LinkURL) {
+			mark(ClassScannerClick, ReasonHoneypotLink)
+		}
+		if c.isBotLocked(s.UserAgent) {
+			mark(ClassScannerClick, ReasonBotUserAgent)
+		}
+		if sinceDelivery >= 0 && sinceDelivery <= c.clickWindow {
+			mark(ClassScannerClick, ReasonFastAfterDelivery)
+		}
+		if c.isBurst(s) {
+			mark(ClassScannerClick, ReasonClickBurst)
+		}
+	}
+	return v
+}
+
+func (c *Classifier) isBotLocked(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return false
+	}
+	for _, p := range c.botPatterns {
+		if strings.Contains(ua, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBurst reports whether the click completes a run of distinct links
+// clicked within the burst window, or every link of the message.
+func (c *Classifier) isBurst(s Signals) bool {
+	links := map[string]bool{s.LinkURL: true}
+	for _, click := range s.RecentClicks {
+		d := s.At.Sub(click.At)
+		if d < 0 {
+			d = -d
+		}
+		if d <= c.burstWindow {
+			links[click.URL] = true
+		}
+	}
+	if len(links) >= c.burstMinLink {
+		return true
+	}
+	return s.LinkCount > 1 && len(links) >= s.LinkCount
+}
+
+func parseIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	defaultMu         sync.RWMutex
+	defaultClassifier = NewClassifier()
+)
+
+// Default returns the process-wide classifier.
+func Default() *Classifier {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultClassifier
+}
+
+// SetDefault replaces the process-wide classifier.
+func SetDefault(c *Classifier) {
+	defaultMu.Lock()
+	defaultClassifier = c
+	defaultMu.Unlock()
+}
@@ -0,0 +1,97 @@
+package botdetect
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClassifyOpen(t *testing.T) {
+	delivered := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewClassifier()
+
+	tests := []struct {
+		name    string
+		s       Signals
+		class   Class
+		reasons []Reason
+	}{
+		{"reader", Signals{Kind: KindOpen, IP: "203.0.113.7", UserAgent: "Mozilla/5.0 (Windows NT 10.0)", At: delivered.Add(2 * time.Hour), DeliveredAt: delivered}, ClassHuman, nil},
+		{"apple proxy", Signals{Kind: KindOpen, IP: "17.58.0.12", UserAgent: "Mozilla/5.0", At: delivered.Add(time.Hour)}, ClassMPPProxy, []Reason{ReasonAppleProxyIP}},
+		{"apple proxy at delivery", Signals{Kind: KindOpen, IP: "17.58.0.12:443", At: delivered.Add(3 * time.Second), DeliveredAt: delivered}, ClassMPPProxy, []Reason{ReasonAppleProxyIP, ReasonFastAfterDelivery}},
+		{"gmail image proxy", Signals{Kind: KindOpen, IP: "66.249.84.10", UserAgent: "Mozilla/5.0 (via ggpht.com GoogleImageProxy)", At: delivered.Add(time.Hour), DeliveredAt: delivered}, ClassHuman, []Reason{ReasonGoogleImageProxy}},
+		{"fast open", Signals{Kind: KindOpen, IP: "198.51.100.1", At: delivered.Add(10 * time.Second), DeliveredAt: delivered}, ClassMachineOpen, []Reason{ReasonFastAfterDelivery}},
+		{"bot user agent", Signals{Kind: KindOpen, UserAgent: "Barracuda Sentinel (EE)", At: delivered}, ClassMachineOpen, []Reason{ReasonBotUserAgent}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := c.Classify(tt.s)
+			if v.Class != tt.class || !reflect.DeepEqual(v.Reasons, tt.reasons) {
+				t.Errorf("Classify() = %s %v, want %s %v", v.Class, v.Reasons, tt.class, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestClassifyClick(t *testing.T) {
+	delivered := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := delivered.Add(time.Hour)
+	c := NewClassifier()
+
+	tests := []struct {
+		name    string
+		s       Signals
+		class   Class
+		reasons []Reason
+	}{
+		{"reader", Signals{Kind: KindClick, LinkURL: "https://shop.example/a", At: at, DeliveredAt: delivered,
+			RecentClicks: []Click{{"https://shop.example/b", at.Add(-2 * time.Minute)}}}, ClassHuman, nil},
+		{"honeypot", Signals{Kind: KindClick, LinkURL: HoneypotURL, At: at}, ClassScannerClick, []Reason{ReasonHoneypotLink}},
+		{"click at delivery", Signals{Kind: KindClick, LinkURL: "https://shop.example/a", At: delivered.Add(4 * time.Second), DeliveredAt: delivered}, ClassScannerClick, []Reason{ReasonFastAfterDelivery}},
+		{"burst", Signals{Kind: KindClick, LinkURL: "https://shop.example/c", At: at, RecentClicks: []Click{
+			{"https://shop.example/a", at.Add(-2 * time.Second)},
+			{"https://shop.example/b", at.Add(-1 * time.Second)},
+		}}, ClassScannerClick, []Reason{ReasonClickBurst}},
+		{"every link", Signals{Kind: KindClick, LinkURL: "https://shop.example/b", At: at, LinkCount: 2,
+			RecentClicks: []Click{{"https://shop.example/a", at.Add(-time.Second)}}}, ClassScannerClick, []Reason{ReasonClickBurst}},
+		{"same link twice", Signals{Kind: KindClick, LinkURL: "https://shop.example/a", At: at, LinkCount: 2,
+			RecentClicks: []Click{{"https://shop.example/a", at.Add(-time.Second)}}}, ClassHuman, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := c.Classify(tt.s)
+			if v.Class != tt.class || !reflect.DeepEqual(v.Reasons, tt.reasons) {
+				t.Errorf("Classify() = %s %v, want %s %v", v.Class, v.Reasons, tt.class, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestClassifierConfiguration(t *testing.T) {
+	c := NewClassifier()
+	if err := c.AddProxyRanges(ReasonBotUserAgent, "10.0.0.0/8"); err == nil {
+		t.Error("expected non-proxy reason to be rejected")
+	}
+	if err := c.AddProxyRanges(ReasonAppleProxyIP, "not-a-cidr"); err == nil {
+		t.Error("expected invalid CIDR to be rejected")
+	}
+	if err := c.AddProxyRanges(ReasonAppleProxyIP, "2620:149::/32"); err != nil {
+		t.Fatalf("AddProxyRanges() error = %v", err)
+	}
+	if v := c.Classify(Signals{Kind: KindOpen, IP: "2620:149:a:1::5"}); v.Class != ClassMPPProxy {
+		t.Errorf("IPv6 proxy range not matched: %+v", v)
+	}
+
+	c.AddBotPatterns("AcmeLinkCheck")
+	if !c.IsBotUserAgent("Mozilla/5.0 acmelinkcheck/2.1") {
+		t.Error("expected added pattern to match")
+	}
+	c.SetThresholds(0, time.Minute, 0, 0)
+	delivered := time.Now()
+	if v := c.Classify(Signals{Kind: KindClick, LinkURL: "https://x.example", At: delivered.Add(40 * time.Second), DeliveredAt: delivered}); v.Class != ClassScannerClick {
+		t.Errorf("expected click window to be widened: %+v", v)
+	}
+	if Class("").IsMachine() || ClassHuman.IsMachine() || !ClassMPPProxy.IsMachine() {
+		t.Error("IsMachine() mismatch")
+	}
+}
@@ -0,0 +1,9 @@
+// Package botdetect classifies tracking hits as human or machine traffic.
+//
+// Opens fetched by Apple Mail Privacy Protection, image proxies and
+// security scanners, and clicks made by link scanners, inflate engagement
+// and mislead A/B evaluation. Classify combines proxy IP ranges, user-agent
+// patterns, time since delivery, hidden honeypot links and click bursts into
+// a Class with reason codes. Events are still recorded; analytics,
+// segmentation and the A/B evaluator filter on the class.
+package botdetect
@@ -0,0 +1,102 @@
+package dkim
+
+import (
+	"bytes"
+	"strings"
+)
+
+// header is one raw header field, folding included, without its final CRLF.
+type header struct {
+	name string // lowercased
+	raw  string
+}
+
+// splitMessage normalizes line endings to CRLF and splits the message into
+// its header fields and body.
+func splitMessage(message []byte) ([]header, []byte) {
+	msg := normalizeCRLF(message)
+	head, body := msg, []byte(nil)
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		head, body = msg[:i+2], msg[i+4:]
+	}
+
+	var headers []header
+	for _, line := range strings.SplitAfter(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1].raw += "\r\n" + strings.TrimSuffix(line, "\r\n")
+			continue
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		name, _, _ := strings.Cut(line, ":")
+		headers = append(headers, header{name: strings.ToLower(strings.TrimSpace(name)), raw: line})
+	}
+	return headers, body
+}
+
+// normalizeCRLF turns bare LFs into CRLF, as the SMTP DATA writer will.
+func normalizeCRLF(b []byte) []byte {
+	if !bytes.Contains(b, []byte("\n")) {
+		return b
+	}
+	out := make([]byte, 0, len(b)+bytes.Count(b, []byte("\n")))
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// relaxedHeader canonicalizes one header field (RFC 6376 3.4.2).
+func relaxedHeader(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(collapseWSP(value)) + "\r\n"
+}
+
+// relaxedBody canonicalizes the body (RFC 6376 3.4.4). An empty body
+// canonicalizes to nothing.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	var b strings.Builder
+	blank := 0
+	for i, line := range lines {
+		if i == len(lines)-1 && line == "" {
+			break // text after the final CRLF
+		}
+		line = strings.TrimRight(collapseWSP(line), " ")
+		if line == "" {
+			blank++
+			continue
+		}
+		for ; blank > 0; blank-- {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String())
+}
+
+// collapseWSP reduces every run of spaces and tabs to a single space.
+func collapseWSP(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	inWSP := false
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == ' ' || c == '\t' {
+			if !inWSP {
+				b.WriteByte(' ')
+			}
+			inWSP = true
+			continue
+		}
+		inWSP = false
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,2 @@
+// Package dkim signs outgoing messages with DKIM (RFC 6376) using relaxed/relaxed canonicalization and either rsa-sha256 or ed25519-sha256 (RFC 8463), and generates, parses and publishes the keys it signs with.
+package dkim
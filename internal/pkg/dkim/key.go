@@ -0,0 +1,110 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// GenerateKey creates a key for alg and returns it with its PEM encoding:
+// PKCS#1 for RSA, which is what PowerMTA reads, and PKCS#8 for Ed25519.
+// bits applies to RSA only and defaults to 2048.
+func GenerateKey(alg Algorithm, bits int) (crypto.Signer, []byte, error) {
+	switch alg {
+	case RSASHA256, "":
+		if bits == 0 {
+			bits = 2048
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+	case Ed25519SHA256:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, nil, fmt.Errorf("dkim: unsupported algorithm %q", alg)
+	}
+}
+
+// ParsePrivateKey reads a PEM-encoded RSA (PKCS#1 or PKCS#8) or Ed25519
+// (PKCS#8) private key.
+func ParsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM block in private key")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: parse private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+	if _, err := keyAlgorithm(signer); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// TXTRecord returns the value of the selector._domainkey TXT record that
+// publishes pub.
+func TXTRecord(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k), nil
+	default:
+		return "", fmt.Errorf("dkim: unsupported public key type %T", pub)
+	}
+}
+
+// RecordName returns the DNS name a selector's key is published under.
+func RecordName(selector, domain string) string {
+	return selector + "._domainkey." + strings.TrimSuffix(domain, ".")
+}
+
+// QuoteTXT splits a TXT value into quoted character-strings of at most 255
+// bytes, the form Route53 and zone files expect for 2048-bit RSA keys.
+func QuoteTXT(value string) string {
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, `"`+value[:255]+`"`)
+		value = value[255:]
+	}
+	parts = append(parts, `"`+value+`"`)
+	return strings.Join(parts, " ")
+}
+
+// PublicKeyPEM returns the PEM "PUBLIC KEY" block for pub.
+func PublicKeyPEM(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
@@ -0,0 +1,176 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithm is a DKIM signing algorithm (the a= tag).
+type Algorithm string
+
+const (
+	RSASHA256     Algorithm = "rsa-sha256"
+	Ed25519SHA256 Algorithm = "ed25519-sha256"
+)
+
+// DefaultHeaders are the header fields signed when present. From is listed
+// twice so a second From added in transit breaks the signature.
+var DefaultHeaders = []string{
+	"from", "from", "to", "cc", "subject", "date", "message-id", "reply-to",
+	"mime-version", "content-type", "content-transfer-encoding",
+	"list-unsubscribe", "list-unsubscribe-post", "list-id", "feedback-id",
+	"in-reply-to", "references",
+}
+
+// Signer signs messages for one domain and selector.
+type Signer struct {
+	Domain   string
+	Selector string
+	Headers  []string // defaults to DefaultHeaders
+
+	key crypto.Signer
+	alg Algorithm
+
+	// now is swapped out in tests.
+	now func() time.Time
+}
+
+// NewSigner creates a Signer. key must be an *rsa.PrivateKey or an
+// ed25519.PrivateKey.
+func NewSigner(domain, selector string, key crypto.Signer) (*Signer, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: domain and selector are required")
+	}
+	alg, err := keyAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		Domain:   strings.ToLower(domain),
+		Selector: selector,
+		key:      key,
+		alg:      alg,
+		now:      time.Now,
+	}, nil
+}
+
+// Algorithm returns the algorithm the signer's key implies.
+func (s *Signer) Algorithm() Algorithm { return s.alg }
+
+// Sign returns message with a DKIM-Signature header prepended. Bare LFs
+// are converted to CRLF first, matching what goes over the wire.
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	headers, body := splitMessage(message)
+
+	names := s.Headers
+	if len(names) == 0 {
+		names = DefaultHeaders
+	}
+	// Header instances are consumed bottom-up (RFC 6376 5.4.2).
+	byName := make(map[string][]header)
+	for _, h := range headers {
+		byName[h.name] = append(byName[h.name], h)
+	}
+	if len(byName["from"]) == 0 {
+		return nil, errors.New("dkim: message has no From header")
+	}
+	var signed []string
+	var canon strings.Builder
+	for _, name := range names {
+		name = strings.ToLower(name)
+		signed = append(signed, name)
+		if hs := byName[name]; len(hs) > 0 {
+			canon.WriteString(relaxedHeader(hs[len(hs)-1].raw))
+			byName[name] = hs[:len(hs)-1]
+		}
+	}
+	// Drop names that matched nothing, except the From oversign.
+	signed = trimUnsigned(signed, headers)
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	sig := s.signatureHeader(signed, base64.StdEncoding.EncodeToString(bodyHash[:]))
+	canon.WriteString(strings.TrimSuffix(relaxedHeader(sig), "\r\n"))
+
+	digest := sha256.Sum256([]byte(canon.String()))
+	var b []byte
+	var err error
+	switch s.alg {
+	case Ed25519SHA256:
+		b, err = s.key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	default:
+		b, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: sign: %w", err)
+	}
+
+	out := make([]byte, 0, len(sig)+len(message)+512)
+	out = append(out, sig...)
+	out = append(out, foldBase64(base64.StdEncoding.EncodeToString(b))...)
+	out = append(out, "\r\n"...)
+	return append(out, normalizeCRLF(message)...), nil
+}
+
+// signatureHeader builds the DKIM-Signature field up to the empty b= tag.
+func (s *Signer) signatureHeader(signed []string, bodyHash string) string {
+	var b strings.Builder
+	b.WriteString("DKIM-Signature: v=1; a=" + string(s.alg) + "; c=relaxed/relaxed;")
+	b.WriteString("\r\n\td=" + s.Domain + "; s=" + s.Selector + ";")
+	b.WriteString(" t=" + strconv.FormatInt(s.now().Unix(), 10) + ";")
+	b.WriteString("\r\n\th=" + strings.Join(signed, ":") + ";")
+	b.WriteString("\r\n\tbh=" + bodyHash + ";")
+	b.WriteString("\r\n\tb=")
+	return b.String()
+}
+
+// trimUnsigned removes names for headers the message lacks; the extra
+// From entries stay as the oversign.
+func trimUnsigned(names []string, headers []header) []string {
+	present := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		present[h.name] = true
+	}
+	out := names[:0]
+	for _, n := range names {
+		if present[n] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// foldBase64 splits a long b= value across continuation lines.
+func foldBase64(s string) string {
+	const width = 72
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\r\n\t ")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func keyAlgorithm(key crypto.Signer) (Algorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 1024 {
+			return "", fmt.Errorf("dkim: RSA key of %d bits is too short", k.N.BitLen())
+		}
+		return RSASHA256, nil
+	case ed25519.PrivateKey:
+		return Ed25519SHA256, nil
+	default:
+		return "", fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+}
@@ -1,3 +1,8 @@
 // Package isp maps email domains to ISP group names (gmail, yahoo, microsoft, etc.).
+// Domains are classified by admin overrides, known provider domains, and
+// finally by matching the domain's MX hosts against ISP MX patterns. MX
+// lookups only happen through Resolver.ResolveMX; everything else uses the
+// cached results. Each organization's overrides live on its own Resolver
+// (ForOrg).
 // Used by send-time recommendations, bounce classification, and campaign analytics.
 package isp
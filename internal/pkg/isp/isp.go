@@ -1,7 +1,5 @@
 package isp
 
-import "strings"
-
 // Known ISP group names returned by Group and GroupFromDomain.
 const (
 	Gmail     = "gmail"
@@ -15,45 +13,16 @@ const (
 	Other     = "other"
 )
 
-var domainToISP = map[string]string{
-	"gmail.com":       Gmail,
-	"googlemail.com":  Gmail,
-	"yahoo.com":       Yahoo,
-	"ymail.com":       Yahoo,
-	"aol.com":         Yahoo,
-	"att.net":         ATT,
-	"sbcglobal.net":   ATT,
-	"bellsouth.net":   ATT,
-	"outlook.com":     Microsoft,
-	"hotmail.com":     Microsoft,
-	"live.com":        Microsoft,
-	"msn.com":         Microsoft,
-	"icloud.com":      Apple,
-	"me.com":          Apple,
-	"mac.com":         Apple,
-	"comcast.net":     Comcast,
-	"xfinity.com":     Comcast,
-	"charter.net":     Charter,
-	"spectrum.net":    Charter,
-	"cox.net":         Cox,
-}
-
 // Group returns the ISP group name for an email address.
 // Returns "other" for unrecognized domains or malformed addresses.
 func Group(email string) string {
-	at := strings.LastIndexByte(email, '@')
-	if at < 0 || at == len(email)-1 {
-		return Other
-	}
-	return GroupFromDomain(email[at+1:])
+	return Default().Group(email)
 }
 
-// GroupFromDomain returns the ISP group name for a bare domain.
+// GroupFromDomain returns the ISP group name for a bare domain using the
+// Default resolver's known domains. It never queries DNS.
 func GroupFromDomain(domain string) string {
-	if g, ok := domainToISP[strings.ToLower(strings.TrimSpace(domain))]; ok {
-		return g
-	}
-	return Other
+	return Default().GroupFromDomain(domain)
 }
 
 // KnownGroups returns the list of recognized ISP group names.
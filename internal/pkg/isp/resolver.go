@@ -0,0 +1,448 @@
+package isp
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources reported by Resolver.Resolve.
+const (
+	SourceOverride = "override" // admin-defined domain override
+	SourceDomain   = "domain"   // known mailbox-provider domain
+	SourceMX       = "mx"       // MX host matched an ISP MX pattern
+	SourceNone     = "none"     // no match; group is Other
+)
+
+// Resolution is the outcome of classifying a domain.
+type Resolution struct {
+	Domain string `json:"domain"`
+	Group  string `json:"group"`
+	Source string `json:"source"`
+	MXHost string `json:"mx_host,omitempty"`
+}
+
+// MXLookupFunc resolves the MX records of a domain. It matches
+// net.Resolver.LookupMX so tests can substitute a fake.
+type MXLookupFunc func(ctx context.Context, domain string) ([]*net.MX, error)
+
+type mxPattern struct {
+	suffix string
+	group  string
+}
+
+// defaultMXCacheSize bounds the MX cache; the least recently used domains
+// are evicted beyond it.
+const defaultMXCacheSize = 50000
+
+type mxCacheEntry struct {
+	domain    string
+	group     string
+	host      string
+	expiresAt time.Time
+}
+
+// Resolver classifies recipient domains into ISP groups. Lookups check admin
+// overrides, then known provider domains, then the domain's MX hosts against
+// the ISP MX patterns, so vanity domains hosted by Google Workspace or
+// Microsoft 365 resolve to their mailbox provider. Only ResolveMX queries
+// DNS; Resolve and GroupFromDomain use MX results it has cached, so they
+// never block on the network. MX results, including misses, are cached, up
+// to a bounded number of domains.
+type Resolver struct {
+	mu        sync.RWMutex
+	overrides map[string]string
+	domains   map[string]string
+	patterns  []mxPattern
+	version   uint64 // bumped whenever overrides or domains change
+
+	cacheMu   sync.Mutex
+	mxCache   map[string]*list.Element // of *mxCacheEntry, in mxLRU
+	mxLRU     *list.List               // most recently used first
+	cacheSize int
+	cacheTTL  time.Duration
+	missTTL   time.Duration
+
+	lookup        MXLookupFunc
+	lookupTimeout time.Duration
+}
+
+// NewResolver creates a resolver seeded with the built-in provider domains
+// and MX patterns.
+func NewResolver() *Resolver {
+	r := &Resolver{
+		overrides:     make(map[string]string),
+		domains:       make(map[string]string),
+		mxCache:       make(map[string]*list.Element),
+		mxLRU:         list.New(),
+		cacheSize:     defaultMXCacheSize,
+		cacheTTL:      24 * time.Hour,
+		missTTL:       1 * time.Hour,
+		lookup:        net.DefaultResolver.LookupMX,
+		lookupTimeout: 3 * time.Second,
+	}
+	for group, domains := range defaultDomains {
+		r.AddDomains(group, domains...)
+	}
+	for group, patterns := range defaultMXPatterns {
+		r.AddMXPatterns(group, patterns...)
+	}
+	return r
+}
+
+var defaultDomains = map[string][]string{
+	Gmail: {"gmail.com", "googlemail.com", "google.com"},
+	Yahoo: {
+		"yahoo.com", "yahoo.co.uk", "yahoo.co.jp", "yahoo.co.in", "yahoo.ca",
+		"yahoo.com.au", "yahoo.com.br", "yahoo.fr", "yahoo.de", "yahoo.it",
+		"ymail.com", "rocketmail.com", "aol.com", "aim.com", "verizon.net",
+		"frontier.com", "rogers.com",
+	},
+	Microsoft: {
+		"outlook.com", "hotmail.com", "hotmail.co.uk", "live.com", "msn.com",
+		"passport.com",
+	},
+	Apple:   {"icloud.com", "me.com", "mac.com"},
+	Comcast: {"comcast.net", "xfinity.com"},
+	ATT: {
+		"att.net", "sbcglobal.net", "pacbell.net", "bellsouth.net",
+		"ameritech.net", "nvbell.net", "prodigy.net",
+	},
+	Cox:     {"cox.net"},
+	Charter: {"charter.net", "spectrum.net", "rr.com", "twc.com", "brighthouse.com"},
+}
+
+var defaultMXPatterns = map[string][]string{
+	Gmail:     {"*.google.com", "*.googlemail.com"},
+	Yahoo:     {"*.yahoodns.net"},
+	Microsoft: {"*.protection.outlook.com"},
+	Apple:     {"*.icloud.com"},
+	Comcast:   {"*.comcast.net"},
+	ATT:       {"*.att.net"},
+	Cox:       {"*.cox.net"},
+	Charter:   {"*.charter.net"},
+}
+
+// SetMXLookup replaces the MX lookup function and clears the MX cache.
+func (r *Resolver) SetMXLookup(fn MXLookupFunc) {
+	r.cacheMu.Lock()
+	r.lookup = fn
+	r.resetCache()
+	r.cacheMu.Unlock()
+}
+
+// SetCacheSize sets how many domains the MX cache holds before evicting the
+// least recently used.
+func (r *Resolver) SetCacheSize(n int) {
+	r.cacheMu.Lock()
+	r.cacheSize = n
+	r.evict()
+	r.cacheMu.Unlock()
+}
+
+// SetCacheTTL sets how long MX matches and misses are cached.
+func (r *Resolver) SetCacheTTL(hit, miss time.Duration) {
+	r.cacheMu.Lock()
+	r.cacheTTL, r.missTTL = hit, miss
+	r.cacheMu.Unlock()
+}
+
+// AddDomains assigns provider domains to an ISP group.
+func (r *Resolver) AddDomains(group string, domains ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range domains {
+		if d = normalizeDomain(d); d != "" {
+			r.domains[d] = group
+		}
+	}
+	r.version++
+}
+
+// AddMXPatterns assigns MX host patterns to an ISP group. Patterns are host
+// suffixes, optionally written as wildcards: "*.google.com" and "google.com"
+// both match "aspmx.l.google.com".
+func (r *Resolver) AddMXPatterns(group string, patterns ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range patterns {
+		suffix := strings.TrimPrefix(normalizeDomain(p), "*.")
+		if suffix == "" {
+			continue
+		}
+		known := false
+		for i, existing := range r.patterns {
+			if existing.suffix == suffix {
+				r.patterns[i].group = group
+				known = true
+				break
+			}
+		}
+		if !known {
+			r.patterns = append(r.patterns, mxPattern{suffix: suffix, group: group})
+		}
+	}
+	// Longest suffix first so specific patterns win over broad ones.
+	sort.SliceStable(r.patterns, func(i, j int) bool {
+		return len(r.patterns[i].suffix) > len(r.patterns[j].suffix)
+	})
+	r.clearCache()
+}
+
+// SetOverride pins a domain to an ISP group, taking precedence over the
+// built-in domains and MX lookup.
+func (r *Resolver) SetOverride(domain, group string) {
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return
+	}
+	r.mu.Lock()
+	r.overrides[domain] = strings.ToLower(strings.TrimSpace(group))
+	r.version++
+	r.mu.Unlock()
+}
+
+// RemoveOverride deletes a domain override.
+func (r *Resolver) RemoveOverride(domain string) {
+	r.mu.Lock()
+	delete(r.overrides, normalizeDomain(domain))
+	r.version++
+	r.mu.Unlock()
+}
+
+// ReplaceOverrides swaps the full override set, e.g. after reloading it
+// from the database.
+func (r *Resolver) ReplaceOverrides(overrides map[string]string) {
+	next := make(map[string]string, len(overrides))
+	for d, g := range overrides {
+		if d = normalizeDomain(d); d != "" {
+			next[d] = strings.ToLower(strings.TrimSpace(g))
+		}
+	}
+	r.mu.Lock()
+	r.overrides = next
+	r.version++
+	r.mu.Unlock()
+}
+
+// Version changes whenever the overrides or known domains change, so
+// results computed from the domain table (e.g. cached segment queries) can
+// tell when they are stale.
+func (r *Resolver) Version() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.version
+}
+
+// Overrides returns a copy of the current domain overrides.
+func (r *Resolver) Overrides() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]string, len(r.overrides))
+	for d, g := range r.overrides {
+		out[d] = g
+	}
+	return out
+}
+
+// Domains returns the known provider domains of an ISP group, sorted.
+func (r *Resolver) Domains(group string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []string
+	for d, g := range r.domains {
+		if g == group {
+			out = append(out, d)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Group returns the ISP group for an email address, or Other for malformed
+// addresses. It does not query DNS.
+func (r *Resolver) Group(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 || at == len(email)-1 {
+		return Other
+	}
+	return r.GroupFromDomain(email[at+1:])
+}
+
+// GroupFromDomain returns the ISP group for a domain, or Other. It does not
+// query DNS.
+func (r *Resolver) GroupFromDomain(domain string) string {
+	return r.Resolve(context.Background(), domain).Group
+}
+
+// Resolve classifies a domain from overrides, known domains and cached MX
+// results, and reports which source decided it. It does not query DNS.
+func (r *Resolver) Resolve(ctx context.Context, domain string) Resolution {
+	return r.resolve(ctx, domain, false)
+}
+
+// ResolveMX is Resolve, but looks up the MX records of a domain that is not
+// known or cached. It blocks for up to the lookup timeout, so it belongs in
+// background jobs and admin tools, not on the send path.
+func (r *Resolver) ResolveMX(ctx context.Context, domain string) Resolution {
+	return r.resolve(ctx, domain, true)
+}
+
+func (r *Resolver) resolve(ctx context.Context, domain string, lookupMX bool) Resolution {
+	domain = normalizeDomain(domain)
+	res := Resolution{Domain: domain, Group: Other, Source: SourceNone}
+	if domain == "" {
+		return res
+	}
+
+	r.mu.RLock()
+	override, hasOverride := r.overrides[domain]
+	known, hasDomain := r.domains[domain]
+	r.mu.RUnlock()
+
+	switch {
+	case hasOverride:
+		res.Group, res.Source = override, SourceOverride
+	case hasDomain:
+		res.Group, res.Source = known, SourceDomain
+	default:
+		if group, host := r.resolveMX(ctx, domain, lookupMX); group != "" {
+			res.Group, res.Source, res.MXHost = group, SourceMX, host
+		}
+	}
+	return res
+}
+
+func (r *Resolver) resolveMX(ctx context.Context, domain string, lookupMX bool) (group, host string) {
+	now := time.Now()
+	r.cacheMu.Lock()
+	var entry mxCacheEntry
+	elem, ok := r.mxCache[domain]
+	if ok {
+		entry = *elem.Value.(*mxCacheEntry)
+		r.mxLRU.MoveToFront(elem)
+	}
+	lookup, timeout := r.lookup, r.lookupTimeout
+	r.cacheMu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.group, entry.host
+	}
+	if !lookupMX {
+		return "", ""
+	}
+
+	lctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	records, err := lookup(lctx, domain)
+	if err != nil && len(records) == 0 {
+		// Transient resolver failures are not cached so the next call retries.
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			return "", ""
+		}
+	}
+
+	group, host = r.matchMX(records)
+	ttl := r.cacheTTL
+	if group == "" {
+		ttl = r.missTTL
+	}
+	r.cacheMu.Lock()
+	entry = mxCacheEntry{domain: domain, group: group, host: host, expiresAt: now.Add(ttl)}
+	if elem, ok := r.mxCache[domain]; ok {
+		elem.Value = &entry
+		r.mxLRU.MoveToFront(elem)
+	} else {
+		r.mxCache[domain] = r.mxLRU.PushFront(&entry)
+		r.evict()
+	}
+	r.cacheMu.Unlock()
+	return group, host
+}
+
+// evict drops the least recently used MX entries beyond the cache size.
+// cacheMu must be held.
+func (r *Resolver) evict() {
+	for r.cacheSize > 0 && r.mxLRU.Len() > r.cacheSize {
+		oldest := r.mxLRU.Back()
+		r.mxLRU.Remove(oldest)
+		delete(r.mxCache, oldest.Value.(*mxCacheEntry).domain)
+	}
+}
+
+// resetCache empties the MX cache. cacheMu must be held.
+func (r *Resolver) resetCache() {
+	r.mxCache = make(map[string]*list.Element)
+	r.mxLRU.Init()
+}
+
+func (r *Resolver) matchMX(records []*net.MX) (group, host string) {
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, mx := range records {
+		h := normalizeDomain(mx.Host)
+		for _, p := range r.patterns {
+			if h == p.suffix || strings.HasSuffix(h, "."+p.suffix) {
+				return p.group, h
+			}
+		}
+	}
+	return "", ""
+}
+
+func (r *Resolver) clearCache() {
+	r.cacheMu.Lock()
+	r.resetCache()
+	r.cacheMu.Unlock()
+}
+
+func normalizeDomain(d string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultResolver = NewResolver()
+)
+
+// Default returns the process-wide resolver used by Group and GroupFromDomain.
+// It holds the built-in domains only; organization overrides live on the
+// resolvers returned by ForOrg.
+func Default() *Resolver {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultResolver
+}
+
+// SetDefault replaces the process-wide resolver.
+func SetDefault(r *Resolver) {
+	defaultMu.Lock()
+	defaultResolver = r
+	defaultMu.Unlock()
+}
+
+var (
+	orgMu        sync.Mutex
+	orgResolvers = make(map[string]*Resolver)
+)
+
+// ForOrg returns the resolver holding an organization's domain overrides,
+// creating it on first use. Overrides set on it never reach another
+// organization or the Default resolver. An empty orgID returns Default.
+func ForOrg(orgID string) *Resolver {
+	if orgID == "" {
+		return Default()
+	}
+	orgMu.Lock()
+	defer orgMu.Unlock()
+	r, ok := orgResolvers[orgID]
+	if !ok {
+		r = NewResolver()
+		orgResolvers[orgID] = r
+	}
+	return r
+}
@@ -0,0 +1,228 @@
+package isp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeMX serves MX records from a map and counts lookups.
+type fakeMX struct {
+	records map[string][]*net.MX
+	calls   map[string]int
+}
+
+func newFakeMX(records map[string][]*net.MX) *fakeMX {
+	return &fakeMX{records: records, calls: make(map[string]int)}
+}
+
+func (f *fakeMX) lookup(_ context.Context, domain string) ([]*net.MX, error) {
+	f.calls[domain]++
+	if recs, ok := f.records[domain]; ok {
+		return recs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+}
+
+func TestResolver_MXFallback(t *testing.T) {
+	fake := newFakeMX(map[string][]*net.MX{
+		"acme-workspace.com": {
+			{Host: "alt1.aspmx.l.google.com.", Pref: 5},
+			{Host: "aspmx.l.google.com.", Pref: 1},
+		},
+		"contoso.co.uk":  {{Host: "contoso-co-uk.mail.protection.outlook.com.", Pref: 0}},
+		"selfhosted.org": {{Host: "mx.selfhosted.org.", Pref: 10}},
+	})
+	r := NewResolver()
+	r.SetMXLookup(fake.lookup)
+	ctx := context.Background()
+
+	// Resolve never looks up MX records itself.
+	if res := r.Resolve(ctx, "acme-workspace.com"); res.Group != Other || res.Source != SourceNone {
+		t.Errorf("uncached workspace domain: got %+v", res)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("Resolve queried DNS: %v", fake.calls)
+	}
+
+	res := r.ResolveMX(ctx, "Acme-Workspace.com")
+	if res.Group != Gmail || res.Source != SourceMX || res.MXHost != "aspmx.l.google.com" {
+		t.Errorf("workspace domain: got %+v", res)
+	}
+	if g := r.ResolveMX(ctx, "contoso.co.uk").Group; g != Microsoft {
+		t.Errorf("microsoft 365 domain: got %q", g)
+	}
+	if res := r.ResolveMX(ctx, "selfhosted.org"); res.Group != Other || res.Source != SourceNone {
+		t.Errorf("self-hosted domain: got %+v", res)
+	}
+	if g := r.ResolveMX(ctx, "nxdomain.invalid").Group; g != Other {
+		t.Errorf("missing domain: got %q", g)
+	}
+
+	// Hits and misses are cached, and Resolve serves them.
+	if g := r.GroupFromDomain("acme-workspace.com"); g != Gmail {
+		t.Errorf("cached workspace domain: got %q", g)
+	}
+	r.ResolveMX(ctx, "selfhosted.org")
+	r.ResolveMX(ctx, "nxdomain.invalid")
+	for _, d := range []string{"acme-workspace.com", "selfhosted.org", "nxdomain.invalid"} {
+		if fake.calls[d] != 1 {
+			t.Errorf("%s: want 1 MX lookup, got %d", d, fake.calls[d])
+		}
+	}
+
+	// Known domains never hit DNS.
+	if g := r.ResolveMX(ctx, "gmail.com").Group; g != Gmail {
+		t.Errorf("gmail.com: got %q", g)
+	}
+	if fake.calls["gmail.com"] != 0 {
+		t.Error("known domain should not trigger MX lookup")
+	}
+}
+
+func TestResolver_TransientErrorNotCached(t *testing.T) {
+	calls := 0
+	r := NewResolver()
+	r.SetMXLookup(func(context.Context, string) ([]*net.MX, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("i/o timeout")
+		}
+		return []*net.MX{{Host: "mx1.hc1234-56.iphmx.com.", Pref: 10}}, nil
+	})
+	r.AddMXPatterns(Comcast, "*.iphmx.com")
+
+	if g := r.ResolveMX(context.Background(), "cable.example").Group; g != Other {
+		t.Errorf("transient failure: got %q", g)
+	}
+	if g := r.ResolveMX(context.Background(), "cable.example").Group; g != Comcast {
+		t.Errorf("retry after transient failure: got %q", g)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 lookups, got %d", calls)
+	}
+}
+
+func TestResolver_MXCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	google := []*net.MX{{Host: "aspmx.l.google.com.", Pref: 1}}
+	fake := newFakeMX(map[string][]*net.MX{"a.com": google, "b.com": google, "c.com": google})
+	r := NewResolver()
+	r.SetMXLookup(fake.lookup)
+	r.SetCacheSize(2)
+	ctx := context.Background()
+
+	r.ResolveMX(ctx, "a.com")
+	r.ResolveMX(ctx, "b.com")
+	r.ResolveMX(ctx, "a.com") // a.com is now the most recently used
+	r.ResolveMX(ctx, "c.com")
+
+	if g := r.GroupFromDomain("b.com"); g != Other {
+		t.Errorf("b.com should have been evicted, got %q", g)
+	}
+	for _, d := range []string{"a.com", "c.com"} {
+		if g := r.GroupFromDomain(d); g != Gmail {
+			t.Errorf("%s: got %q", d, g)
+		}
+	}
+	if fake.calls["a.com"] != 1 {
+		t.Errorf("a.com: want 1 MX lookup, got %d", fake.calls["a.com"])
+	}
+	if g := r.Group("someone@c.com"); g != Gmail {
+		t.Errorf("Group: got %q", g)
+	}
+}
+
+func TestResolver_Overrides(t *testing.T) {
+	r := NewResolver()
+	r.SetMXLookup(newFakeMX(map[string][]*net.MX{
+		"vanity.com": {{Host: "aspmx.l.google.com.", Pref: 1}},
+	}).lookup)
+
+	r.SetOverride(" Vanity.COM ", Microsoft)
+	res := r.Resolve(context.Background(), "vanity.com")
+	if res.Group != Microsoft || res.Source != SourceOverride {
+		t.Errorf("override: got %+v", res)
+	}
+
+	r.SetOverride("yahoo.com", Other)
+	if g := r.GroupFromDomain("yahoo.com"); g != Other {
+		t.Errorf("override of known domain: got %q", g)
+	}
+
+	r.RemoveOverride("vanity.com")
+	if g := r.ResolveMX(context.Background(), "vanity.com").Group; g != Gmail {
+		t.Errorf("after removing override: got %q", g)
+	}
+
+	r.ReplaceOverrides(map[string]string{"corp.io": Apple})
+	if got := r.Overrides(); len(got) != 1 || got["corp.io"] != Apple {
+		t.Errorf("ReplaceOverrides: got %v", got)
+	}
+	if g := r.GroupFromDomain("yahoo.com"); g != Yahoo {
+		t.Errorf("replaced override should be gone: got %q", g)
+	}
+}
+
+func TestResolver_VersionChangesWithOverrides(t *testing.T) {
+	r := NewResolver()
+	v := r.Version()
+	for name, change := range map[string]func(){
+		"SetOverride":      func() { r.SetOverride("vanity.com", Gmail) },
+		"RemoveOverride":   func() { r.RemoveOverride("vanity.com") },
+		"ReplaceOverrides": func() { r.ReplaceOverrides(map[string]string{"corp.io": Apple}) },
+		"AddDomains":       func() { r.AddDomains(Yahoo, "ymail.example") },
+	} {
+		change()
+		if next := r.Version(); next == v {
+			t.Errorf("%s: version did not change", name)
+		} else {
+			v = next
+		}
+	}
+	r.GroupFromDomain("vanity.com")
+	if r.Version() != v {
+		t.Error("lookups should not change the version")
+	}
+}
+
+func TestResolver_MXPatternSpecificity(t *testing.T) {
+	r := NewResolver()
+	r.SetMXLookup(newFakeMX(map[string][]*net.MX{
+		"regional.net": {{Host: "mx.east.cox.net.", Pref: 1}},
+	}).lookup)
+	r.AddMXPatterns(Charter, "east.cox.net")
+
+	if g := r.ResolveMX(context.Background(), "regional.net").Group; g != Charter {
+		t.Errorf("longest pattern should win: got %q", g)
+	}
+}
+
+func TestResolver_Domains(t *testing.T) {
+	r := NewResolver()
+	r.AddDomains(Cox, "COX.com")
+	got := r.Domains(Cox)
+	if len(got) != 2 || got[0] != "cox.com" || got[1] != "cox.net" {
+		t.Errorf("Domains(cox) = %v", got)
+	}
+}
+
+func TestForOrg_OverridesStayInTheirOrg(t *testing.T) {
+	ForOrg("org-a").SetOverride("vanity.com", Gmail)
+
+	if ForOrg("org-a") != ForOrg("org-a") {
+		t.Error("ForOrg should return the same resolver for an org")
+	}
+	if g := ForOrg("org-a").GroupFromDomain("vanity.com"); g != Gmail {
+		t.Errorf("org-a override: got %q", g)
+	}
+	if g := ForOrg("org-b").GroupFromDomain("vanity.com"); g != Other {
+		t.Errorf("org-a override leaked into org-b: got %q", g)
+	}
+	if g := GroupFromDomain("vanity.com"); g != Other {
+		t.Errorf("org-a override leaked into the default resolver: got %q", g)
+	}
+	if ForOrg("") != Default() {
+		t.Error("ForOrg(\"\") should be the default resolver")
+	}
+}
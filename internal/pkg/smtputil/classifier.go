@@ -1,6 +1,9 @@
 package smtputil
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 // BounceType classifies an SMTP failure as hard (permanent) or soft (transient).
 type BounceType string
@@ -10,21 +13,209 @@ const (
 	BounceSoft BounceType = "soft"
 )
 
-// hardCodes are SMTP reply codes that indicate permanent failures.
-var hardCodes = []string{"550", "551", "552", "553", "554", "555"}
+// Category is the normalized reason a delivery attempt failed.
+type Category string
+
+const (
+	CategoryBadMailbox      Category = "bad-mailbox"
+	CategoryMailboxFull     Category = "mailbox-full"
+	CategoryPolicyBlock     Category = "policy-block"
+	CategoryReputationBlock Category = "reputation-block"
+	CategoryContentRejected Category = "content-rejected"
+	CategoryDNSFailure      Category = "dns-failure"
+	CategoryRateLimited     Category = "rate-limited"
+	CategoryConnection      Category = "connection"
+	CategoryUnknown         Category = "unknown"
+)
+
+// Action is the recommended response to a classified failure.
+type Action string
+
+const (
+	ActionSuppress      Action = "suppress"       // never mail the recipient again
+	ActionRetry         Action = "retry"          // retry the message later
+	ActionThrottle      Action = "throttle"       // slow the sending rate toward the ISP
+	ActionPauseISP      Action = "pause-isp"      // stop sending to the ISP from the affected IP/domain
+	ActionReviewContent Action = "review-content" // the message body or links were rejected
+	ActionReviewPolicy  Action = "review-policy"  // authentication or relay configuration needs fixing
+)
+
+// Classification is the result of parsing an SMTP failure.
+//
+// Type is hard only when the recipient address itself is permanently
+// undeliverable. Permanent blocks caused by the sender (policy, reputation,
+// content) are soft: the address is fine, the sending infrastructure is not.
+type Classification struct {
+	ReplyCode int        `json:"reply_code,omitempty"`
+	Enhanced  string     `json:"enhanced_code,omitempty"`
+	Category  Category   `json:"category"`
+	Type      BounceType `json:"type"`
+	Permanent bool       `json:"permanent"`
+	Action    Action     `json:"action"`
+	ISP       string     `json:"isp,omitempty"`
+	Rule      string     `json:"rule,omitempty"`
+}
 
-// ClassifyError examines an SMTP error string and returns the bounce type.
-// 5xx responses are hard bounces; everything else (4xx, connection errors,
-// timeouts) is treated as a soft bounce. A nil error returns BounceSoft.
+// ClassifyError examines an SMTP error and returns the bounce type.
+// A nil error returns BounceSoft. See Classify for the full result.
 func ClassifyError(err error) BounceType {
 	if err == nil {
 		return BounceSoft
 	}
-	msg := err.Error()
-	for _, code := range hardCodes {
-		if strings.Contains(msg, code) {
-			return BounceHard
+	return Classify(err.Error()).Type
+}
+
+// Classify parses a raw SMTP reply or client error string, such as
+// "550 5.1.1 <a@b.com>: Recipient address rejected: User unknown" or
+// "RCPT TO: 421 4.7.0 [TSS04] Messages temporarily deferred".
+func Classify(text string) Classification {
+	return classify(text, text, "")
+}
+
+// ClassifyDSN classifies a failure reported as separate DSN fields, as found
+// in MTA accounting records and ESP webhooks. status is the RFC 3464 status
+// ("5.1.1" or "5.1.1 (bad destination mailbox address)"), diagnostic is the
+// remote server's reply ("smtp;550 5.1.1 user unknown") and mtaCategory is
+// the MTA's own bounce category (PowerMTA bounceCat), used only when the
+// codes and text are inconclusive. Any argument may be empty.
+func ClassifyDSN(status, diagnostic, mtaCategory string) Classification {
+	return classify(status+" "+diagnostic, diagnostic, mtaCategory)
+}
+
+func classify(codeText, diagnostic, mtaCategory string) Classification {
+	c := Classification{
+		ReplyCode: parseReplyCode(codeText),
+		Enhanced:  parseEnhancedCode(codeText),
+	}
+	lower := strings.ToLower(diagnostic)
+	hasCode := c.ReplyCode != 0 || c.Enhanced != ""
+	switch {
+	case c.Enhanced != "":
+		c.Permanent = c.Enhanced[0] == '5'
+	case c.ReplyCode != 0:
+		c.Permanent = c.ReplyCode >= 500
+	}
+
+	enhanced, strong := enhancedCategory(c.Enhanced)
+	mta, mtaPermanent, mtaKnown := mtaBounceCategory(mtaCategory)
+
+	if p, ok := matchISPPattern(lower); ok {
+		c.Category, c.ISP, c.Rule = p.category, p.isp, "isp:"+p.isp+":"+p.match
+	} else if strong {
+		c.Category, c.Rule = enhanced, "enhanced:"+c.Enhanced
+	} else if p, ok := matchTextPattern(lower, hasCode); ok {
+		c.Category, c.Rule = p.category, "text:"+p.match
+	} else if mtaKnown {
+		c.Category, c.Rule = mta, "mta:"+strings.ToLower(mtaCategory)
+	} else if enhanced != "" {
+		c.Category, c.Rule = enhanced, "enhanced:"+c.Enhanced
+	} else if cat := replyCodeCategory(c.ReplyCode); cat != "" {
+		c.Category, c.Rule = cat, "reply:"+strconv.Itoa(c.ReplyCode)
+	} else {
+		c.Category = CategoryUnknown
+	}
+
+	if !hasCode && mtaKnown {
+		c.Permanent = mtaPermanent
+	}
+	c.Type, c.Action = outcome(c)
+	return c
+}
+
+// outcome maps a category and permanence to a bounce type and action.
+func outcome(c Classification) (BounceType, Action) {
+	switch c.Category {
+	case CategoryBadMailbox, CategoryDNSFailure:
+		if c.Permanent {
+			return BounceHard, ActionSuppress
+		}
+		return BounceSoft, ActionRetry
+	case CategoryMailboxFull, CategoryConnection:
+		return BounceSoft, ActionRetry
+	case CategoryRateLimited:
+		return BounceSoft, ActionThrottle
+	case CategoryReputationBlock:
+		return BounceSoft, ActionPauseISP
+	case CategoryContentRejected:
+		return BounceSoft, ActionReviewContent
+	case CategoryPolicyBlock:
+		return BounceSoft, ActionReviewPolicy
+	default:
+		// An unexplained permanent failure will fail the same way on every
+		// retry, unless it is a command or protocol error on our side.
+		if c.Permanent && !isProtocolError(c) {
+			return BounceHard, ActionSuppress
+		}
+		return BounceSoft, ActionRetry
+	}
+}
+
+// isProtocolError reports whether the failure is a command syntax or protocol
+// error (50x replies, X.5.x status). Those describe our SMTP session, not the
+// recipient, and 50x also collides with HTTP 5xx statuses from ESP APIs.
+func isProtocolError(c Classification) bool {
+	if c.ReplyCode >= 500 && c.ReplyCode < 510 {
+		return true
+	}
+	return strings.HasPrefix(c.Enhanced, "5.5.")
+}
+
+// parseReplyCode returns the first three-digit SMTP reply code (2xx, 4xx or
+// 5xx) followed by a space, hyphen or end of string. Digits that are part of
+// IP addresses or enhanced codes are skipped.
+func parseReplyCode(s string) int {
+	for i := 0; i+3 <= len(s); i++ {
+		if s[i] != '2' && s[i] != '4' && s[i] != '5' {
+			continue
+		}
+		if !isDigit(s[i+1]) || !isDigit(s[i+2]) {
+			continue
 		}
+		if i > 0 && (isDigit(s[i-1]) || s[i-1] == '.') {
+			continue
+		}
+		if i+3 < len(s) && s[i+3] != ' ' && s[i+3] != '-' {
+			continue
+		}
+		code, _ := strconv.Atoi(s[i : i+3])
+		return code
 	}
-	return BounceSoft
+	return 0
 }
+
+// parseEnhancedCode returns the first RFC 3463 status code (class.subject.detail)
+// found in s, or "" if there is none.
+func parseEnhancedCode(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '2' && s[i] != '4' && s[i] != '5' {
+			continue
+		}
+		if i > 0 && (isDigit(s[i-1]) || s[i-1] == '.') {
+			continue
+		}
+		j := i + 1
+		parts := 0
+		for parts < 2 && j < len(s) && s[j] == '.' {
+			k := j + 1
+			for k < len(s) && k-j <= 3 && isDigit(s[k]) {
+				k++
+			}
+			if k == j+1 {
+				break
+			}
+			j = k
+			parts++
+		}
+		if parts != 2 {
+			continue
+		}
+		// Reject IPv4 addresses and version strings like 5.1.1.4.
+		if j < len(s) && (isDigit(s[j]) || (s[j] == '.' && j+1 < len(s) && isDigit(s[j+1]))) {
+			continue
+		}
+		return s[i:j]
+	}
+	return ""
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
@@ -13,8 +13,8 @@ func TestClassifyError(t *testing.T) {
 	}{
 		{"nil error", nil, BounceSoft},
 		{"550 user unknown", errors.New("550 5.1.1 User unknown"), BounceHard},
-		{"551 relay denied", errors.New("551 relay not permitted"), BounceHard},
-		{"552 mailbox full hard", errors.New("552 mailbox full"), BounceHard},
+		{"551 relay denied is a sender problem", errors.New("551 relay not permitted"), BounceSoft},
+		{"552 mailbox full is transient", errors.New("552 mailbox full"), BounceSoft},
 		{"553 invalid address", errors.New("553 invalid mailbox"), BounceHard},
 		{"554 transaction failed", errors.New("554 transaction failed"), BounceHard},
 		{"421 service unavailable", errors.New("421 service not available"), BounceSoft},
@@ -36,3 +36,131 @@ func TestClassifyError(t *testing.T) {
 		})
 	}
 }
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		category  Category
+		typ       BounceType
+		action    Action
+		permanent bool
+	}{
+		{"user unknown", "550 5.1.1 <a@example.com>: Recipient address rejected: User unknown", CategoryBadMailbox, BounceHard, ActionSuppress, true},
+		{"mailbox full enhanced", "552 5.2.2 Mailbox full", CategoryMailboxFull, BounceSoft, ActionRetry, true},
+		{"mailbox full bare", "552 mailbox full", CategoryMailboxFull, BounceSoft, ActionRetry, true},
+		{"bad destination system", "550 5.1.2 Host unknown", CategoryDNSFailure, BounceHard, ActionSuppress, true},
+		{"null mx", "556 5.1.10 Recipient address has null MX", CategoryDNSFailure, BounceHard, ActionSuppress, true},
+		{"generic 4.7.0 blocked", "421 4.7.0 IP blocked, try again later", CategoryReputationBlock, BounceSoft, ActionPauseISP, false},
+		{"spamhaus listing", "554 5.7.1 Service unavailable; Client host [1.2.3.4] blocked using zen.spamhaus.org", CategoryReputationBlock, BounceSoft, ActionPauseISP, true},
+		{"uri blocklist is content", "554 5.7.1 Message rejected, URL listed at multi.surbl.org", CategoryContentRejected, BounceSoft, ActionReviewContent, true},
+		{"dmarc failure", "550 5.7.26 Unauthenticated email is not accepted due to the domain's DMARC policy", CategoryPolicyBlock, BounceSoft, ActionReviewPolicy, true},
+		{"mail flood", "451 4.7.28 Mail flood detected", CategoryRateLimited, BounceSoft, ActionThrottle, false},
+		{"congestion", "451 4.4.5 Server busy", CategoryRateLimited, BounceSoft, ActionThrottle, false},
+		{"message too big", "552 5.3.4 Message size exceeds fixed limit", CategoryContentRejected, BounceSoft, ActionReviewContent, true},
+		{"dns lookup error", "dial tcp: lookup mx.example.invalid: no such host", CategoryDNSFailure, BounceSoft, ActionRetry, false},
+		{"connection refused", "dial tcp 1.2.3.4:25: connect: connection refused", CategoryConnection, BounceSoft, ActionRetry, false},
+		{"unexplained 5xx", "554 transaction failed", CategoryUnknown, BounceHard, ActionSuppress, true},
+		{"syntax error is not the recipient", "500 5.5.2 Syntax error", CategoryUnknown, BounceSoft, ActionRetry, true},
+		{"unexplained 4xx", "451 requested action aborted", CategoryUnknown, BounceSoft, ActionRetry, false},
+		{"empty", "", CategoryUnknown, BounceSoft, ActionRetry, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(tt.text)
+			if got.Category != tt.category || got.Type != tt.typ || got.Action != tt.action || got.Permanent != tt.permanent {
+				t.Errorf("Classify(%q) = {%s %s %s permanent=%v}, want {%s %s %s permanent=%v}",
+					tt.text, got.Category, got.Type, got.Action, got.Permanent,
+					tt.category, tt.typ, tt.action, tt.permanent)
+			}
+		})
+	}
+}
+
+func TestClassifyISPPatterns(t *testing.T) {
+	tests := []struct {
+		text     string
+		isp      string
+		category Category
+	}{
+		{"421 4.7.0 [TSS04] Messages from 1.2.3.4 temporarily deferred due to unexpected volume or user complaints", "yahoo", CategoryReputationBlock},
+		{"552 1 Requested mail action aborted, mailbox not found", "yahoo", CategoryBadMailbox},
+		{"554 delivery error: dd This user doesn't have a yahoo.com account", "yahoo", CategoryBadMailbox},
+		{"421-4.7.28 Our system has detected an unusual rate of unsolicited mail originating from your IP address", "gmail", CategoryRateLimited},
+		{"550-5.7.1 Our system has detected that this message is likely unsolicited mail", "gmail", CategoryReputationBlock},
+		{"452-4.2.2 The email account that you tried to reach is over quota", "gmail", CategoryMailboxFull},
+		{"550 5.7.1 Unfortunately, messages from [1.2.3.4] weren't sent. (S3150)", "microsoft", CategoryReputationBlock},
+		{"554 5.7.1 [CS01] Message rejected due to local policy", "apple", CategoryReputationBlock},
+		{"554 resimta-ch2-01v.sys.comcast.net comcast 1.2.3.4 Comcast BL000000 blocked", "comcast", CategoryReputationBlock},
+	}
+
+	for _, tt := range tests {
+		got := Classify(tt.text)
+		if got.ISP != tt.isp || got.Category != tt.category {
+			t.Errorf("Classify(%q) = {isp=%q %s}, want {isp=%q %s}", tt.text, got.ISP, got.Category, tt.isp, tt.category)
+		}
+	}
+
+	if got := Classify("552 1 Requested mail action aborted, mailbox not found"); got.Type != BounceHard {
+		t.Errorf("yahoo mailbox-not-found should be hard, got %s", got.Type)
+	}
+	if got := Classify("421 4.7.0 [TSS04] deferred"); got.Type != BounceSoft || got.Action != ActionPauseISP {
+		t.Errorf("yahoo TSS04 should be soft with pause-isp, got %s/%s", got.Type, got.Action)
+	}
+}
+
+func TestClassifyDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		diag     string
+		mtaCat   string
+		category Category
+		typ      BounceType
+	}{
+		{"pmta bad mailbox", "5.1.1 (bad destination mailbox address)", "smtp;550 5.1.1 user unknown", "bad-mailbox", CategoryBadMailbox, BounceHard},
+		{"enhanced overrides mta category", "5.2.2", "smtp;552 5.2.2 over quota", "bad-mailbox", CategoryMailboxFull, BounceSoft},
+		{"mta category only", "", "", "inactive-mailbox", CategoryBadMailbox, BounceHard},
+		{"mta quota is soft", "", "", "quota-issues", CategoryMailboxFull, BounceSoft},
+		{"mta category refines generic 5.7.1", "5.7.1", "smtp;550 5.7.1 message refused", "spam-related", CategoryReputationBlock, BounceSoft},
+		{"transient content", "4.3.1", "", "content-related", CategoryContentRejected, BounceSoft},
+		{"ip address is not an enhanced code", "", "smtp;550 rejected from 15.204.22.176", "", CategoryBadMailbox, BounceHard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyDSN(tt.status, tt.diag, tt.mtaCat)
+			if got.Category != tt.category || got.Type != tt.typ {
+				t.Errorf("ClassifyDSN(%q, %q, %q) = {%s %s}, want {%s %s}",
+					tt.status, tt.diag, tt.mtaCat, got.Category, got.Type, tt.category, tt.typ)
+			}
+		})
+	}
+}
+
+func TestParseCodes(t *testing.T) {
+	tests := []struct {
+		text     string
+		reply    int
+		enhanced string
+	}{
+		{"550 5.1.1 User unknown", 550, "5.1.1"},
+		{"550-5.7.1 multi-line", 550, "5.7.1"},
+		{"smtp;421 4.7.0 [TSS04]", 421, "4.7.0"},
+		{"RCPT TO: 451 4.7.28 flood", 451, "4.7.28"},
+		{"connect to 15.204.22.176:25 failed", 0, ""},
+		{"5.1.1 (bad destination mailbox address)", 0, "5.1.1"},
+		{"version 5.1.1.4 released", 0, ""},
+		{"no codes here", 0, ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseReplyCode(tt.text); got != tt.reply {
+			t.Errorf("parseReplyCode(%q) = %d, want %d", tt.text, got, tt.reply)
+		}
+		if got := parseEnhancedCode(tt.text); got != tt.enhanced {
+			t.Errorf("parseEnhancedCode(%q) = %q, want %q", tt.text, got, tt.enhanced)
+		}
+	}
+}
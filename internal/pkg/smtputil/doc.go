@@ -1,4 +1,9 @@
 // Package smtputil provides SMTP error classification for bounce handling.
-// Hard bounces (5xx) trigger immediate global suppression; soft bounces (4xx)
-// are retried or tracked without suppression.
+//
+// Classify and ClassifyDSN parse the reply code, the RFC 3463 enhanced status
+// code and provider-specific diagnostic text into a Category and a
+// recommended Action. Only permanent recipient failures (bad mailbox, dead
+// domain) are hard bounces that trigger global suppression; mailbox-full,
+// rate limits and sender-side blocks are soft and are retried, throttled or
+// escalated instead.
 package smtputil
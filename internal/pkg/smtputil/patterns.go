@@ -0,0 +1,287 @@
+package smtputil
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
+)
+
+// pattern maps a lowercase diagnostic substring to a category.
+type pattern struct {
+	isp      string
+	match    string
+	category Category
+}
+
+// ispPatterns are mailbox-provider specific diagnostics. They are checked
+// before the enhanced status code because providers reuse generic codes
+// (5.7.1, 4.7.0) for very different conditions and only the text tells
+// them apart.
+var ispPatterns = []pattern{
+	// Gmail
+	{isp.Gmail, "unusual rate of unsolicited mail", CategoryRateLimited},
+	{isp.Gmail, "is receiving mail at a rate that", CategoryRateLimited},
+	{isp.Gmail, "likely unsolicited mail", CategoryReputationBlock},
+	{isp.Gmail, "low reputation of the sending domain", CategoryReputationBlock},
+	{isp.Gmail, "sender is unauthenticated", CategoryPolicyBlock},
+	{isp.Gmail, "account that you tried to reach is over quota", CategoryMailboxFull},
+	{isp.Gmail, "account that you tried to reach does not exist", CategoryBadMailbox},
+	{isp.Gmail, "account that you tried to reach is disabled", CategoryBadMailbox},
+
+	// Yahoo / AOL
+	{isp.Yahoo, "[tss04]", CategoryReputationBlock},
+	{isp.Yahoo, "[tss09]", CategoryReputationBlock},
+	{isp.Yahoo, "[ts03]", CategoryReputationBlock},
+	{isp.Yahoo, "[ts01]", CategoryRateLimited},
+	{isp.Yahoo, "[ts02]", CategoryRateLimited},
+	{isp.Yahoo, "requested mail action aborted, mailbox not found", CategoryBadMailbox},
+	{isp.Yahoo, "doesn't have a yahoo.com account", CategoryBadMailbox},
+	{isp.Yahoo, "not accepted for policy reasons", CategoryContentRejected},
+
+	// Microsoft (Outlook.com / Hotmail / Office 365)
+	{isp.Microsoft, "(s3150)", CategoryReputationBlock},
+	{isp.Microsoft, "(s3140)", CategoryReputationBlock},
+	{isp.Microsoft, "(s3115)", CategoryReputationBlock},
+	{isp.Microsoft, "banned sending ip", CategoryReputationBlock},
+	{isp.Microsoft, "traffic not accepted from this ip", CategoryReputationBlock},
+	{isp.Microsoft, "temporarily rate limited due to ip reputation", CategoryRateLimited},
+	{isp.Microsoft, "4.7.500 server busy", CategoryRateLimited},
+	{isp.Microsoft, "requested action not taken: mailbox unavailable", CategoryBadMailbox},
+
+	// Apple iCloud
+	{isp.Apple, "[cs01]", CategoryReputationBlock},
+	{isp.Apple, "[hm08]", CategoryReputationBlock},
+
+	// Comcast
+	{isp.Comcast, "bl000000", CategoryReputationBlock},
+	{isp.Comcast, "bl000001", CategoryReputationBlock},
+	{isp.Comcast, "rl000001", CategoryRateLimited},
+	{isp.Comcast, "rl000002", CategoryRateLimited},
+
+	// AT&T
+	{isp.ATT, "abuse_rbl@abuse-att.net", CategoryReputationBlock},
+}
+
+// textPatterns are provider-neutral diagnostics, checked in order. Content
+// comes before reputation so URI blocklists (SURBL, URIBL) are not mistaken
+// for IP blocklists.
+var textPatterns = []pattern{
+	{"", "surbl", CategoryContentRejected},
+	{"", "uribl", CategoryContentRejected},
+	{"", "content rejected", CategoryContentRejected},
+	{"", "message content", CategoryContentRejected},
+	{"", "spam content", CategoryContentRejected},
+	{"", "looks like spam", CategoryContentRejected},
+	{"", "considered spam", CategoryContentRejected},
+	{"", "virus", CategoryContentRejected},
+	{"", "malware", CategoryContentRejected},
+	{"", "phishing", CategoryContentRejected},
+
+	{"", "blocklist", CategoryReputationBlock},
+	{"", "blacklist", CategoryReputationBlock},
+	{"", "block list", CategoryReputationBlock},
+	{"", "spamhaus", CategoryReputationBlock},
+	{"", "spamcop", CategoryReputationBlock},
+	{"", "barracuda", CategoryReputationBlock},
+	{"", "dnsbl", CategoryReputationBlock},
+	{"", "rbl", CategoryReputationBlock},
+	{"", "reputation", CategoryReputationBlock},
+	{"", "unsolicited", CategoryReputationBlock},
+	{"", "blocked", CategoryReputationBlock},
+
+	{"", "rate limit", CategoryRateLimited},
+	{"", "ratelimit", CategoryRateLimited},
+	{"", "rate-limit", CategoryRateLimited},
+	{"", "throttl", CategoryRateLimited},
+	{"", "too many", CategoryRateLimited},
+	{"", "temporarily deferred", CategoryRateLimited},
+	{"", "try again later", CategoryRateLimited},
+
+	{"", "mailbox full", CategoryMailboxFull},
+	{"", "mailbox is full", CategoryMailboxFull},
+	{"", "over quota", CategoryMailboxFull},
+	{"", "quota exceeded", CategoryMailboxFull},
+	{"", "exceeded storage", CategoryMailboxFull},
+	{"", "insufficient storage", CategoryMailboxFull},
+	{"", "insufficient system storage", CategoryMailboxFull},
+
+	{"", "user unknown", CategoryBadMailbox},
+	{"", "unknown user", CategoryBadMailbox},
+	{"", "unknown recipient", CategoryBadMailbox},
+	{"", "no such user", CategoryBadMailbox},
+	{"", "no such recipient", CategoryBadMailbox},
+	{"", "mailbox not found", CategoryBadMailbox},
+	{"", "mailbox unavailable", CategoryBadMailbox},
+	{"", "recipient not found", CategoryBadMailbox},
+	{"", "address not found", CategoryBadMailbox},
+	{"", "does not exist", CategoryBadMailbox},
+	{"", "invalid recipient", CategoryBadMailbox},
+	{"", "invalid mailbox", CategoryBadMailbox},
+	{"", "account disabled", CategoryBadMailbox},
+	{"", "account has been disabled", CategoryBadMailbox},
+	{"", "deactivated", CategoryBadMailbox},
+
+	{"", "no such host", CategoryDNSFailure},
+	{"", "host not found", CategoryDNSFailure},
+	{"", "domain not found", CategoryDNSFailure},
+	{"", "no such domain", CategoryDNSFailure},
+	{"", "nxdomain", CategoryDNSFailure},
+	{"", "no mx", CategoryDNSFailure},
+	{"", "name service error", CategoryDNSFailure},
+
+	{"", "relay", CategoryPolicyBlock},
+	{"", "not permitted", CategoryPolicyBlock},
+	{"", "not authorized", CategoryPolicyBlock},
+	{"", "access denied", CategoryPolicyBlock},
+	{"", "spf", CategoryPolicyBlock},
+	{"", "dkim", CategoryPolicyBlock},
+	{"", "dmarc", CategoryPolicyBlock},
+	{"", "reverse dns", CategoryPolicyBlock},
+	{"", "policy", CategoryPolicyBlock},
+}
+
+// connectionPatterns describe client-side failures that carry no SMTP reply.
+// They are only consulted when no reply code was found.
+var connectionPatterns = []pattern{
+	{"", "connection refused", CategoryConnection},
+	{"", "connection reset", CategoryConnection},
+	{"", "timeout", CategoryConnection},
+	{"", "timed out", CategoryConnection},
+	{"", "broken pipe", CategoryConnection},
+	{"", "no route to host", CategoryConnection},
+	{"", "network is unreachable", CategoryConnection},
+	{"", "tls", CategoryConnection},
+	{"", "eof", CategoryConnection},
+}
+
+func matchISPPattern(lower string) (pattern, bool) {
+	return matchPatterns(ispPatterns, lower)
+}
+
+func matchTextPattern(lower string, hasCode bool) (pattern, bool) {
+	if p, ok := matchPatterns(textPatterns, lower); ok {
+		return p, true
+	}
+	if !hasCode {
+		return matchPatterns(connectionPatterns, lower)
+	}
+	return pattern{}, false
+}
+
+func matchPatterns(patterns []pattern, lower string) (pattern, bool) {
+	if lower == "" {
+		return pattern{}, false
+	}
+	for _, p := range patterns {
+		if strings.Contains(lower, p.match) {
+			return p, true
+		}
+	}
+	return pattern{}, false
+}
+
+// enhancedCategory maps an RFC 3463 status code to a category. strong is
+// false for codes that are too generic (X.0.0, X.7.0, X.7.1) to override
+// the diagnostic text or the MTA's own categorization.
+func enhancedCategory(code string) (cat Category, strong bool) {
+	parts := strings.Split(code, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	subject, _ := strconv.Atoi(parts[1])
+	detail, _ := strconv.Atoi(parts[2])
+
+	switch subject {
+	case 1: // addressing status
+		switch detail {
+		case 0, 1, 3, 6:
+			return CategoryBadMailbox, true
+		case 2, 10:
+			return CategoryDNSFailure, true
+		case 7, 8:
+			return CategoryPolicyBlock, true
+		}
+	case 2: // mailbox status
+		switch detail {
+		case 1:
+			return CategoryBadMailbox, true
+		case 2:
+			return CategoryMailboxFull, true
+		case 3:
+			return CategoryContentRejected, true
+		}
+	case 3: // mail system status
+		switch detail {
+		case 2:
+			return CategoryConnection, true
+		case 4:
+			return CategoryContentRejected, true
+		}
+	case 4: // network and routing status
+		switch detail {
+		case 1, 2, 6, 7:
+			return CategoryConnection, true
+		case 3, 4:
+			return CategoryDNSFailure, true
+		case 5:
+			return CategoryRateLimited, true
+		}
+	case 6: // message content or media status
+		return CategoryContentRejected, true
+	case 7: // security or policy status
+		switch detail {
+		case 0, 1:
+			return CategoryPolicyBlock, false
+		case 28:
+			return CategoryRateLimited, true
+		default:
+			return CategoryPolicyBlock, true
+		}
+	}
+	return "", false
+}
+
+// replyCodeCategory maps a bare RFC 5321 reply code to a category.
+func replyCodeCategory(code int) Category {
+	switch code {
+	case 421:
+		return CategoryRateLimited
+	case 452, 552:
+		return CategoryMailboxFull
+	case 550, 551, 553:
+		return CategoryBadMailbox
+	case 450, 451, 554, 555:
+		return CategoryUnknown
+	}
+	return ""
+}
+
+// mtaBounceCategories maps PowerMTA bounce categories (bounceCat) to a
+// category and whether the MTA treats the failure as permanent.
+var mtaBounceCategories = map[string]struct {
+	category  Category
+	permanent bool
+}{
+	"bad-mailbox":         {CategoryBadMailbox, true},
+	"inactive-mailbox":    {CategoryBadMailbox, true},
+	"bad-domain":          {CategoryDNSFailure, true},
+	"no-answer-from-host": {CategoryDNSFailure, true},
+	"routing-errors":      {CategoryDNSFailure, true},
+	"quota-issues":        {CategoryMailboxFull, false},
+	"policy-related":      {CategoryPolicyBlock, true},
+	"relaying-issues":     {CategoryPolicyBlock, true},
+	"spam-related":        {CategoryReputationBlock, true},
+	"content-related":     {CategoryContentRejected, false},
+	"virus-related":       {CategoryContentRejected, true},
+	"message-expired":     {CategoryConnection, false},
+	"bad-connection":      {CategoryConnection, false},
+	"rate-limited":        {CategoryRateLimited, false},
+	"protocol-errors":     {CategoryUnknown, false},
+	"other":               {CategoryUnknown, false},
+}
+
+func mtaBounceCategory(name string) (Category, bool, bool) {
+	m, ok := mtaBounceCategories[strings.ToLower(strings.TrimSpace(name))]
+	return m.category, m.permanent, ok
+}
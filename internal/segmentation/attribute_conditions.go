@@ -0,0 +1,436 @@
+package segmentation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
+)
+
+// ==========================================
+// REVENUE, PREDICTIVE AND CROSS-ENTITY CONDITIONS
+// ==========================================
+
+// Revenue condition fields. Revenue is summed from the subscriber's Everflow
+// conversions in mailing_revenue_attributions.
+const (
+	RevenueFieldTotal            = "total"
+	RevenueFieldConversions      = "conversions"
+	RevenueFieldLastConversionAt = "last_conversion_at"
+)
+
+// predictiveField maps a predictive condition field onto a key of one of the
+// JSONB profiles in mailing_subscriber_intelligence.
+type predictiveField struct {
+	column  string
+	key     string
+	numeric bool
+}
+
+// predictiveFields lists the subscriber intelligence attributes segments can
+// filter on. Field names are never interpolated into SQL unless listed here.
+var predictiveFields = map[string]predictiveField{
+	"next_open_probability":  {"predictive_scores", "next_open_probability", true},
+	"next_click_probability": {"predictive_scores", "next_click_probability", true},
+	"ltv":                    {"predictive_scores", "ltv", true},
+	"reengage_score":         {"predictive_scores", "reengage_score", true},
+	"optimal_send_time":      {"predictive_scores", "optimal_send_time", false},
+	"best_send_hour":         {"temporal_profile", "best_send_hour", true},
+	"best_send_day":          {"temporal_profile", "best_send_day", true},
+	"engagement_score":       {"engagement_profile", "engagement_score", true},
+	"open_rate_30d":          {"engagement_profile", "open_rate_30d", true},
+	"click_rate_30d":         {"engagement_profile", "click_rate_30d", true},
+	"engagement_trend":       {"engagement_profile", "engagement_trend", false},
+	"churn_risk":             {"risk_profile", "churn_risk", true},
+}
+
+// PredictiveFields returns the field names accepted by predictive conditions.
+func PredictiveFields() []string {
+	fields := make([]string, 0, len(predictiveFields))
+	for f := range predictiveFields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// DataQualityTier is a named band of mailing_subscribers.data_quality_score.
+// Min is inclusive, Max exclusive; a zero Max leaves the band open-ended.
+type DataQualityTier struct {
+	Name string  `json:"name"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max,omitempty"`
+}
+
+// DataQualityTiers are the verification tiers, lowest first. The scores match
+// what address verification writes: 0.25 for a valid MX, 0.30 for catch-all
+// domains, 0.50 for a verified mailbox and 1.00 once the subscriber engaged.
+var DataQualityTiers = []DataQualityTier{
+	{Name: "unverified", Min: 0, Max: 0.25},
+	{Name: "mx_valid", Min: 0.25, Max: 0.30},
+	{Name: "catch_all", Min: 0.30, Max: 0.50},
+	{Name: "verified", Min: 0.50, Max: 1.00},
+	{Name: "engaged", Min: 1.00},
+}
+
+// DataQualityTierFor returns the tier name of a data_quality_score.
+func DataQualityTierFor(score float64) string {
+	for i := len(DataQualityTiers) - 1; i >= 0; i-- {
+		if score >= DataQualityTiers[i].Min {
+			return DataQualityTiers[i].Name
+		}
+	}
+	return DataQualityTiers[0].Name
+}
+
+// conditionValues returns the values of a set-style condition: ValuesArray
+// when given, otherwise the single Value.
+func conditionValues(cond ConditionBuilder) []string {
+	if len(cond.ValuesArray) > 0 {
+		return cond.ValuesArray
+	}
+	if cond.Value != "" {
+		return []string{cond.Value}
+	}
+	return nil
+}
+
+// buildNumericComparison compares a numeric SQL expression with the
+// condition's value(s).
+func (qb *QueryBuilder) buildNumericComparison(expr string, cond ConditionBuilder) (string, error) {
+	switch cond.Operator {
+	case OpEquals:
+		return fmt.Sprintf("%s = %s", expr, qb.nextArg(cond.Value)), nil
+	case OpNotEquals:
+		return fmt.Sprintf("%s != %s", expr, qb.nextArg(cond.Value)), nil
+	case OpGt:
+		return fmt.Sprintf("%s > %s", expr, qb.nextArg(cond.Value)), nil
+	case OpGte:
+		return fmt.Sprintf("%s >= %s", expr, qb.nextArg(cond.Value)), nil
+	case OpLt:
+		return fmt.Sprintf("%s < %s", expr, qb.nextArg(cond.Value)), nil
+	case OpLte:
+		return fmt.Sprintf("%s <= %s", expr, qb.nextArg(cond.Value)), nil
+	case OpBetween:
+		return fmt.Sprintf("%s BETWEEN %s AND %s", expr, qb.nextArg(cond.Value), qb.nextArg(cond.ValueSecondary)), nil
+	case OpNotBetween:
+		return fmt.Sprintf("%s NOT BETWEEN %s AND %s", expr, qb.nextArg(cond.Value), qb.nextArg(cond.ValueSecondary)), nil
+	case OpIsNull:
+		return fmt.Sprintf("%s IS NULL", expr), nil
+	case OpIsNotNull:
+		return fmt.Sprintf("%s IS NOT NULL", expr), nil
+	default:
+		return "", fmt.Errorf("unsupported numeric operator: %s", cond.Operator)
+	}
+}
+
+// daysArg binds a day count for interval arithmetic.
+func (qb *QueryBuilder) daysArg(value string) (string, error) {
+	days, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || days < 0 {
+		return "", fmt.Errorf("invalid day count: %q", value)
+	}
+	return qb.nextArg(days), nil
+}
+
+// buildRevenueCondition builds SQL for Everflow conversion revenue attributed
+// to the subscriber, optionally limited to an Everflow offer, a campaign and
+// a window of EventTimeWindowDays.
+func (qb *QueryBuilder) buildRevenueCondition(cond ConditionBuilder) (string, error) {
+	filters := ""
+	if cond.OfferID != "" {
+		filters += fmt.Sprintf(" AND ra.everflow_offer_id = %s", qb.nextArg(cond.OfferID))
+	}
+	if cond.CampaignID != "" {
+		filters += fmt.Sprintf(" AND ra.campaign_id::text = %s", qb.nextArg(cond.CampaignID))
+	}
+	if window := qb.buildTimestampFilter("ra", "converted_at", cond.EventTimeWindowDays); window != "" {
+		filters += " " + window
+	}
+	from := "FROM mailing_revenue_attributions ra WHERE ra.subscriber_id = s.id" + filters
+
+	switch cond.Field {
+	case RevenueFieldTotal, "":
+		return qb.buildNumericComparison(
+			fmt.Sprintf("(SELECT COALESCE(SUM(COALESCE(ra.attributed_revenue, ra.revenue)), 0) %s)", from), cond)
+	case RevenueFieldConversions:
+		return qb.buildNumericComparison(fmt.Sprintf("(SELECT COUNT(*) %s)", from), cond)
+	case RevenueFieldLastConversionAt:
+		expr := fmt.Sprintf("(SELECT MAX(ra.converted_at) %s)", from)
+		switch cond.Operator {
+		case OpInLastDays:
+			days, err := qb.daysArg(cond.Value)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s >= NOW() - %s * INTERVAL '1 day'", expr, days), nil
+		case OpMoreThanDaysAgo:
+			days, err := qb.daysArg(cond.Value)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s < NOW() - %s * INTERVAL '1 day'", expr, days), nil
+		case OpDateBefore:
+			return fmt.Sprintf("%s < %s", expr, qb.nextArg(cond.Value)), nil
+		case OpDateAfter:
+			return fmt.Sprintf("%s > %s", expr, qb.nextArg(cond.Value)), nil
+		case OpIsNull:
+			return fmt.Sprintf("%s IS NULL", expr), nil
+		case OpIsNotNull:
+			return fmt.Sprintf("%s IS NOT NULL", expr), nil
+		default:
+			return "", fmt.Errorf("unsupported revenue date operator: %s", cond.Operator)
+		}
+	default:
+		return "", fmt.Errorf("unknown revenue field: %s", cond.Field)
+	}
+}
+
+// buildPredictiveCondition builds SQL for scores computed by the
+// intelligence builder. Subscribers without an intelligence row never match
+// a comparison, only is_null.
+func (qb *QueryBuilder) buildPredictiveCondition(cond ConditionBuilder) (string, error) {
+	pf, ok := predictiveFields[cond.Field]
+	if !ok {
+		return "", fmt.Errorf("unknown predictive field: %s", cond.Field)
+	}
+	expr := fmt.Sprintf("si.%s->>'%s'", pf.column, pf.key)
+	if pf.numeric {
+		expr = fmt.Sprintf("NULLIF(%s, '')::numeric", expr)
+	}
+	expr = fmt.Sprintf("(SELECT %s FROM mailing_subscriber_intelligence si WHERE si.subscriber_id = s.id)", expr)
+
+	if pf.numeric {
+		return qb.buildNumericComparison(expr, cond)
+	}
+	switch cond.Operator {
+	case OpEquals:
+		return fmt.Sprintf("%s = %s", expr, qb.nextArg(cond.Value)), nil
+	case OpNotEquals:
+		return fmt.Sprintf("%s != %s", expr, qb.nextArg(cond.Value)), nil
+	case OpContainsAny:
+		return fmt.Sprintf("%s = ANY(ARRAY[%s])", expr, qb.argList(cond.ValuesArray)), nil
+	case OpIsNull:
+		return fmt.Sprintf("%s IS NULL", expr), nil
+	case OpIsNotNull:
+		return fmt.Sprintf("%s IS NOT NULL", expr), nil
+	default:
+		return "", fmt.Errorf("unsupported operator for predictive field %s: %s", cond.Field, cond.Operator)
+	}
+}
+
+// argList binds each value and returns the comma-separated placeholders.
+func (qb *QueryBuilder) argList(values []string) string {
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = qb.nextArg(v)
+	}
+	return strings.Join(placeholders, ",")
+}
+
+// emailDomainExpr extracts the lower-cased domain of the subscriber address.
+const emailDomainExpr = "LOWER(SPLIT_PART(s.email, '@', 2))"
+
+// ispGroupDomains returns the domains the resolver assigns to each known
+// group, overrides included.
+func ispGroupDomains(r *isp.Resolver) map[string][]string {
+	overrides := r.Overrides()
+	byGroup := make(map[string][]string)
+	for _, g := range isp.KnownGroups() {
+		for _, d := range r.Domains(g) {
+			if o, ok := overrides[d]; ok && o != g {
+				continue
+			}
+			byGroup[g] = append(byGroup[g], d)
+		}
+	}
+	for d, g := range overrides {
+		if g == isp.Other {
+			continue
+		}
+		found := false
+		for _, known := range byGroup[g] {
+			if known == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			byGroup[g] = append(byGroup[g], d)
+		}
+	}
+	for g := range byGroup {
+		sort.Strings(byGroup[g])
+	}
+	return byGroup
+}
+
+// buildISPCondition builds SQL matching the mailbox provider of the address.
+// Groups are matched on the resolver's domain table and overrides; domains
+// only classified through MX lookups fall into "other".
+func (qb *QueryBuilder) buildISPCondition(cond ConditionBuilder) (string, error) {
+	groups := conditionValues(cond)
+	if len(groups) == 0 {
+		return "", fmt.Errorf("isp_group condition requires at least one group")
+	}
+
+	resolver := qb.ispResolver
+	if resolver == nil {
+		resolver = isp.ForOrg(qb.organizationID)
+	}
+	byGroup := ispGroupDomains(resolver)
+
+	var domains []string
+	includeOther := false
+	for _, g := range groups {
+		g = strings.ToLower(strings.TrimSpace(g))
+		if g == isp.Other {
+			includeOther = true
+			continue
+		}
+		domains = append(domains, byGroup[g]...)
+	}
+
+	var parts []string
+	if len(domains) > 0 {
+		parts = append(parts, fmt.Sprintf("%s = ANY(ARRAY[%s])", emailDomainExpr, qb.argList(domains)))
+	}
+	if includeOther {
+		var known []string
+		for _, g := range isp.KnownGroups() {
+			known = append(known, byGroup[g]...)
+		}
+		parts = append(parts, fmt.Sprintf("NOT (%s = ANY(ARRAY[%s]))", emailDomainExpr, qb.argList(known)))
+	}
+
+	match := "FALSE"
+	if len(parts) == 1 {
+		match = parts[0]
+	} else if len(parts) > 1 {
+		match = "(" + strings.Join(parts, " OR ") + ")"
+	}
+
+	switch cond.Operator {
+	case OpEquals, OpContainsAny:
+		return match, nil
+	case OpNotEquals, OpNotContainsAny:
+		return "NOT (" + match + ")", nil
+	default:
+		return "", fmt.Errorf("unsupported isp_group operator: %s", cond.Operator)
+	}
+}
+
+// buildDataQualityCondition builds SQL on data_quality_score. The "score"
+// field compares the raw score; otherwise values are tier names.
+func (qb *QueryBuilder) buildDataQualityCondition(cond ConditionBuilder) (string, error) {
+	const score = "COALESCE(s.data_quality_score, 0)"
+	if cond.Field == "score" {
+		return qb.buildNumericComparison(score, cond)
+	}
+
+	names := conditionValues(cond)
+	if len(names) == 0 {
+		return "", fmt.Errorf("data_quality condition requires at least one tier")
+	}
+	var ranges []string
+	for _, name := range names {
+		var tier *DataQualityTier
+		for i := range DataQualityTiers {
+			if DataQualityTiers[i].Name == name {
+				tier = &DataQualityTiers[i]
+				break
+			}
+		}
+		if tier == nil {
+			return "", fmt.Errorf("unknown data quality tier: %s", name)
+		}
+		r := fmt.Sprintf("%s >= %s", score, qb.nextArg(tier.Min))
+		if tier.Max > 0 {
+			r = fmt.Sprintf("(%s AND %s < %s)", r, score, qb.nextArg(tier.Max))
+		}
+		ranges = append(ranges, r)
+	}
+	match := ranges[0]
+	if len(ranges) > 1 {
+		match = "(" + strings.Join(ranges, " OR ") + ")"
+	}
+
+	switch cond.Operator {
+	case OpEquals, OpContainsAny:
+		return match, nil
+	case OpNotEquals, OpNotContainsAny:
+		return "NOT " + match, nil
+	default:
+		return "", fmt.Errorf("unsupported data_quality operator: %s", cond.Operator)
+	}
+}
+
+// buildCampaignCondition builds SQL on what a subscriber received and
+// clicked. With an OfferID, clicks only count when the link carries the
+// offer's Everflow encoding; with a CampaignID as well, only clicks in that
+// campaign count. ExcludeMachine drops scanner clicks.
+func (qb *QueryBuilder) buildCampaignCondition(cond ConditionBuilder) (string, error) {
+	match := qb.buildTrackingSubscriberMatch("e")
+
+	received := func() string {
+		return fmt.Sprintf(`
+			EXISTS (
+				SELECT 1 FROM mailing_tracking_events e
+				WHERE %s
+				AND e.campaign_id = %s
+				AND e.event_type IN ('sent', 'delivered')
+			)`, match, qb.nextArg(cond.CampaignID))
+	}
+	clicked := func() string {
+		filters := ""
+		if cond.CampaignID != "" {
+			filters += fmt.Sprintf("\n\t\t\t\tAND e.campaign_id = %s", qb.nextArg(cond.CampaignID))
+		}
+		if cond.OfferID != "" {
+			filters += fmt.Sprintf(`
+				AND EXISTS (
+					SELECT 1 FROM mailing_offer_encodings oe
+					WHERE oe.organization_id = s.organization_id
+					AND oe.offer_id = %s
+					AND e.link_url ILIKE '%%' || oe.encoded_value || '%%'
+				)`, qb.nextArg(cond.OfferID))
+		}
+		if cond.ExcludeMachine {
+			filters += "\n\t\t\t\t" + humanTrafficFilter("e")
+		}
+		return fmt.Sprintf(`
+			EXISTS (
+				SELECT 1 FROM mailing_tracking_events e
+				WHERE %s
+				AND e.event_type = 'clicked'%s
+			)`, match, filters)
+	}
+
+	switch cond.Operator {
+	case OpCampaignReceived, OpCampaignNotReceived, OpCampaignReceivedNotClicked:
+		if cond.CampaignID == "" {
+			return "", fmt.Errorf("%s requires a campaign_id", cond.Operator)
+		}
+	case OpCampaignClicked, OpCampaignNotClicked:
+		if cond.CampaignID == "" && cond.OfferID == "" {
+			return "", fmt.Errorf("%s requires a campaign_id or offer_id", cond.Operator)
+		}
+	default:
+		return "", fmt.Errorf("unsupported campaign operator: %s", cond.Operator)
+	}
+
+	switch cond.Operator {
+	case OpCampaignReceived:
+		return received(), nil
+	case OpCampaignNotReceived:
+		return "NOT " + received(), nil
+	case OpCampaignClicked:
+		return clicked(), nil
+	case OpCampaignNotClicked:
+		return "NOT " + clicked(), nil
+	default:
+		return fmt.Sprintf("(%s\n\t\t\tAND NOT %s)", received(), clicked()), nil
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,16 +14,19 @@ import (
 
 // Engine is the main segmentation engine
 type Engine struct {
-	store *Store
-	db    *sql.DB
+	store      *Store
+	db         *sql.DB
+	membership *MembershipEngine
 }
 
 // NewEngine creates a new segmentation engine
 func NewEngine(db *sql.DB) *Engine {
-	return &Engine{
+	e := &Engine{
 		store: NewStore(db),
 		db:    db,
 	}
+	e.membership = NewMembershipEngine(e)
+	return e
 }
 
 // Store returns the underlying store for direct access
@@ -30,6 +34,11 @@ func (e *Engine) Store() *Store {
 	return e.store
 }
 
+// Membership returns the incremental membership engine for realtime segments
+func (e *Engine) Membership() *MembershipEngine {
+	return e.membership
+}
+
 func (e *Engine) NewQueryBuilder(ctx context.Context) *QueryBuilder {
 	qb := NewQueryBuilder()
 	qb.SetTrackingEmailMatchEnabled(e.store.SupportsTrackingEmailMatch(ctx))
@@ -378,8 +387,16 @@ func (e *Engine) TrackEvent(ctx context.Context, event *CustomEvent) error {
 		// Non-fatal, just log
 	}
 
-	// TODO: Trigger real-time segment evaluation for automation triggers
-	// This would notify any listening automation workflows
+	// Re-evaluate realtime segments that reference this event or the
+	// computed fields it just refreshed
+	if _, err := e.membership.Apply(ctx, Change{
+		OrganizationID: event.OrganizationID,
+		SubscriberID:   event.SubscriberID,
+		Events:         []string{event.EventName},
+		Computed:       true,
+	}); err != nil {
+		log.Printf("[SegmentMembership] event %s for %s: %v", event.EventName, event.SubscriberID, err)
+	}
 
 	return nil
 }
@@ -419,6 +436,14 @@ func (e *Engine) ValidateConditions(conditions ConditionGroupBuilder) []string {
 		if cond.ConditionType == ConditionEvent && cond.EventName == "" {
 			errors = append(errors, "event conditions require an event name")
 		}
+
+		// Check cross-entity conditions compile
+		switch cond.ConditionType {
+		case ConditionRevenue, ConditionPredictive, ConditionISP, ConditionDataQuality, ConditionCampaign:
+			if _, err := NewQueryBuilder().buildCondition(cond); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
 	}
 
 	// Recursively validate child groups
@@ -0,0 +1,582 @@
+package segmentation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ==========================================
+// INCREMENTAL MEMBERSHIP
+// ==========================================
+
+// MembershipEventType is the kind of membership transition.
+type MembershipEventType string
+
+const (
+	SegmentEntered MembershipEventType = "entered"
+	SegmentExited  MembershipEventType = "exited"
+)
+
+// MembershipEvent is emitted when a subscriber enters or leaves a segment.
+type MembershipEvent struct {
+	ID             uuid.UUID           `json:"id"`
+	Type           MembershipEventType `json:"type"`
+	SegmentID      uuid.UUID           `json:"segment_id"`
+	OrganizationID uuid.UUID           `json:"organization_id"`
+	SubscriberID   uuid.UUID           `json:"subscriber_id"`
+	Email          string              `json:"email"`
+	At             time.Time           `json:"at"`
+}
+
+// MembershipListener receives membership events after they are recorded.
+// Listeners run synchronously on the goroutine that applied the change.
+type MembershipListener func(ctx context.Context, event MembershipEvent)
+
+// Change describes what changed for one subscriber. Only segments whose
+// conditions reference one of the changes are re-evaluated.
+type Change struct {
+	OrganizationID uuid.UUID
+	SubscriberID   uuid.UUID
+	// Fields are mailing_subscribers columns that changed.
+	Fields []string
+	// CustomFields are keys of custom_fields that changed.
+	CustomFields []string
+	// Events are tracking event types (opened, clicked, ...) or custom
+	// event names recorded for the subscriber.
+	Events []string
+	// Tags, Computed and Suppressed mark changes to the tag array, the
+	// computed fields (subscriber intelligence included) and the suppression
+	// list. Revenue marks a new attributed conversion.
+	Tags       bool
+	Computed   bool
+	Suppressed bool
+	Revenue    bool
+}
+
+// Dependency keys shared by Change and the segment index.
+const (
+	depAnyEvent    = "event:*"
+	depTags        = "tag"
+	depComputed    = "computed"
+	depSuppression = "suppression"
+	depRevenue     = "revenue"
+)
+
+// keys returns the dependency keys touched by the change.
+func (c Change) keys() []string {
+	var keys []string
+	for _, f := range c.Fields {
+		keys = append(keys, "profile:"+f)
+	}
+	for _, f := range c.CustomFields {
+		keys = append(keys, "custom:"+f)
+	}
+	for _, ev := range c.Events {
+		if mapped, ok := trackingEventMap[ev]; ok {
+			ev = mapped
+		}
+		keys = append(keys, "event:"+ev)
+	}
+	if c.Tags {
+		keys = append(keys, depTags)
+	}
+	if c.Computed {
+		keys = append(keys, depComputed)
+	}
+	if c.Suppressed {
+		keys = append(keys, depSuppression)
+	}
+	if c.Revenue {
+		keys = append(keys, depRevenue)
+	}
+	return keys
+}
+
+// Dependencies returns the keys a segment's result depends on. Every segment
+// depends on subscriber status, list and suppression through the base
+// filters applied by the QueryBuilder.
+func Dependencies(group ConditionGroupBuilder, exclusions []ConditionBuilder) map[string]bool {
+	deps := map[string]bool{
+		"profile:status":  true,
+		"profile:list_id": true,
+		depSuppression:    true,
+	}
+	addGroupDependencies(deps, group)
+	for _, cond := range exclusions {
+		addConditionDependency(deps, cond)
+	}
+	return deps
+}
+
+func addGroupDependencies(deps map[string]bool, group ConditionGroupBuilder) {
+	for _, cond := range group.Conditions {
+		addConditionDependency(deps, cond)
+	}
+	for _, sub := range group.Groups {
+		addGroupDependencies(deps, sub)
+	}
+}
+
+func addConditionDependency(deps map[string]bool, cond ConditionBuilder) {
+	switch cond.ConditionType {
+	case ConditionCustomField:
+		deps["custom:"+cond.Field] = true
+	case ConditionEvent:
+		_, _, val := resolveEventTable(cond.EventName)
+		if val == "" {
+			deps[depAnyEvent] = true
+		} else {
+			deps["event:"+val] = true
+		}
+	case ConditionComputed:
+		deps[depComputed] = true
+	case ConditionTag:
+		deps[depTags] = true
+	case ConditionPredictive:
+		deps[depComputed] = true
+	case ConditionRevenue:
+		deps[depRevenue] = true
+	case ConditionISP:
+		deps["profile:email"] = true
+	case ConditionDataQuality:
+		deps["profile:data_quality_score"] = true
+	case ConditionCampaign:
+		deps["event:sent"] = true
+		deps["event:delivered"] = true
+		deps["event:clicked"] = true
+	default:
+		deps["profile:"+cond.Field] = true
+	}
+}
+
+// affects reports whether a change with the given keys can alter the result
+// of a segment with these dependencies.
+func affects(deps map[string]bool, change Change, keys []string) bool {
+	if deps[depAnyEvent] && len(change.Events) > 0 {
+		return true
+	}
+	for _, k := range keys {
+		if deps[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// membershipSegment is a realtime segment with its parsed conditions.
+type membershipSegment struct {
+	segment    *Segment
+	conditions ConditionGroupBuilder
+	exclusions []ConditionBuilder
+	deps       map[string]bool
+}
+
+type membershipIndex struct {
+	segments []*membershipSegment
+	loadedAt time.Time
+}
+
+// MembershipEngine keeps mailing_segment_memberships current for segments in
+// realtime or hybrid calculation mode. Each Change re-evaluates only the
+// segments that depend on it and emits entered/exited events for the
+// transitions. A segment takes part once its memberships are seeded; Seed
+// and Refresh recompute a whole segment, which ages out conditions relative
+// to the current time (e.g. "opened in the last 7 days") and catches up
+// changes that were never applied.
+type MembershipEngine struct {
+	engine   *Engine
+	indexTTL time.Duration
+
+	mu        sync.RWMutex
+	index     map[uuid.UUID]*membershipIndex
+	listeners []MembershipListener
+}
+
+// NewMembershipEngine creates a membership engine on top of a segmentation engine.
+func NewMembershipEngine(engine *Engine) *MembershipEngine {
+	return &MembershipEngine{
+		engine:   engine,
+		indexTTL: time.Minute,
+		index:    make(map[uuid.UUID]*membershipIndex),
+	}
+}
+
+// Subscribe registers a listener for membership events.
+func (m *MembershipEngine) Subscribe(listener MembershipListener) {
+	m.mu.Lock()
+	m.listeners = append(m.listeners, listener)
+	m.mu.Unlock()
+}
+
+// Invalidate drops the cached segment index for an organization so the next
+// change picks up edited segments immediately.
+func (m *MembershipEngine) Invalidate(orgID uuid.UUID) {
+	m.mu.Lock()
+	delete(m.index, orgID)
+	m.mu.Unlock()
+}
+
+// Apply re-evaluates the segments affected by a change and records any
+// membership transitions. It returns the events that were emitted.
+func (m *MembershipEngine) Apply(ctx context.Context, change Change) ([]MembershipEvent, error) {
+	if change.OrganizationID == uuid.Nil || change.SubscriberID == uuid.Nil {
+		return nil, fmt.Errorf("change requires organization and subscriber")
+	}
+
+	segments, err := m.segmentsFor(ctx, change.OrganizationID)
+	if err != nil {
+		return nil, fmt.Errorf("load segments: %w", err)
+	}
+
+	keys := change.keys()
+	var events []MembershipEvent
+	for _, seg := range segments {
+		if !affects(seg.deps, change, keys) {
+			continue
+		}
+		matches, err := m.evaluate(ctx, seg, change.SubscriberID)
+		if err != nil {
+			log.Printf("[SegmentMembership] evaluate segment=%s subscriber=%s: %v", seg.segment.ID, change.SubscriberID, err)
+			continue
+		}
+		evType, err := m.transition(ctx, seg.segment, change.SubscriberID, matches)
+		if err != nil {
+			log.Printf("[SegmentMembership] update segment=%s subscriber=%s: %v", seg.segment.ID, change.SubscriberID, err)
+			continue
+		}
+		if evType == "" {
+			continue
+		}
+		events = append(events, MembershipEvent{
+			ID:             uuid.New(),
+			Type:           evType,
+			SegmentID:      seg.segment.ID,
+			OrganizationID: change.OrganizationID,
+			SubscriberID:   change.SubscriberID,
+			At:             time.Now(),
+		})
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	m.dispatch(ctx, events)
+	return events, nil
+}
+
+// dispatch fills in subscriber emails, records the events and passes them
+// to the listeners.
+func (m *MembershipEngine) dispatch(ctx context.Context, events []MembershipEvent) {
+	m.mu.RLock()
+	listeners := m.listeners
+	m.mu.RUnlock()
+
+	emails := make(map[uuid.UUID]string)
+	for i := range events {
+		email, ok := emails[events[i].SubscriberID]
+		if !ok {
+			m.engine.db.QueryRowContext(ctx, `SELECT email FROM mailing_subscribers WHERE id = $1`, events[i].SubscriberID).Scan(&email)
+			emails[events[i].SubscriberID] = email
+		}
+		events[i].Email = email
+		if err := m.recordEvent(ctx, events[i]); err != nil {
+			log.Printf("[SegmentMembership] record event: %v", err)
+		}
+		for _, l := range listeners {
+			l(ctx, events[i])
+		}
+	}
+}
+
+// segmentsFor returns the cached realtime segments of an organization whose
+// memberships are seeded.
+func (m *MembershipEngine) segmentsFor(ctx context.Context, orgID uuid.UUID) ([]*membershipSegment, error) {
+	m.mu.RLock()
+	idx, ok := m.index[orgID]
+	m.mu.RUnlock()
+	if ok && time.Since(idx.loadedAt) < m.indexTTL {
+		return idx.segments, nil
+	}
+
+	rows, err := m.engine.db.QueryContext(ctx, `
+		SELECT id, list_id, include_suppressed, COALESCE(global_exclusion_rules, '[]'::jsonb)
+		FROM mailing_segments
+		WHERE organization_id = $1 AND status = 'active' AND segment_type = 'dynamic'
+		  AND calculation_mode IN ('realtime', 'hybrid') AND memberships_synced_at IS NOT NULL
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*membershipSegment
+	for rows.Next() {
+		seg := &Segment{OrganizationID: orgID}
+		if err := rows.Scan(&seg.ID, &seg.ListID, &seg.IncludeSuppressed, &seg.GlobalExclusionRules); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		segments = append(segments, &membershipSegment{segment: seg})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, ms := range segments {
+		if err := m.prepare(ctx, ms); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	m.index[orgID] = &membershipIndex{segments: segments, loadedAt: time.Now()}
+	m.mu.Unlock()
+	return segments, nil
+}
+
+// prepare loads a segment's conditions and dependencies.
+func (m *MembershipEngine) prepare(ctx context.Context, ms *membershipSegment) error {
+	conditions, err := m.engine.store.GetSegmentConditions(ctx, ms.segment.ID)
+	if err != nil {
+		return fmt.Errorf("conditions for %s: %w", ms.segment.ID, err)
+	}
+	ms.conditions = ConditionGroupBuilder{LogicOperator: LogicAnd}
+	if conditions != nil {
+		ms.conditions = *conditions
+	}
+	if len(ms.segment.GlobalExclusionRules) > 0 {
+		json.Unmarshal(ms.segment.GlobalExclusionRules, &ms.exclusions)
+	}
+	ms.deps = Dependencies(ms.conditions, ms.exclusions)
+	return nil
+}
+
+// queryBuilder returns a QueryBuilder scoped to the segment's organization,
+// list and suppression setting.
+func (m *MembershipEngine) queryBuilder(ctx context.Context, seg *Segment) *QueryBuilder {
+	qb := m.engine.NewQueryBuilder(ctx)
+	qb.SetOrganizationID(seg.OrganizationID.String())
+	if seg.ListID != nil {
+		qb.SetListID(seg.ListID.String())
+	}
+	qb.SetIncludeSuppressed(seg.IncludeSuppressed)
+	return qb
+}
+
+// evaluate checks a single subscriber against a segment.
+func (m *MembershipEngine) evaluate(ctx context.Context, seg *membershipSegment, subscriberID uuid.UUID) (bool, error) {
+	qb := m.queryBuilder(ctx, seg.segment)
+	query, args, err := qb.BuildCountQuery(seg.conditions, seg.exclusions)
+	if err != nil {
+		return false, err
+	}
+	query += fmt.Sprintf("\n  AND s.id = $%d", len(args)+1)
+	args = append(args, subscriberID)
+
+	var count int
+	if err := m.engine.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// transition stores the evaluated membership and reports the resulting
+// event, if any. Only members get a row until they first exit; the
+// conditional updates make concurrent evaluations emit each transition once.
+func (m *MembershipEngine) transition(ctx context.Context, seg *Segment, subscriberID uuid.UUID, member bool) (MembershipEventType, error) {
+	db := m.engine.db
+	if !member {
+		res, err := db.ExecContext(ctx, `
+			UPDATE mailing_segment_memberships
+			SET is_member = FALSE, exited_at = NOW(), updated_at = NOW()
+			WHERE segment_id = $1 AND subscriber_id = $2 AND is_member
+		`, seg.ID, subscriberID)
+		if err != nil {
+			return "", err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return SegmentExited, nil
+		}
+		return "", nil
+	}
+
+	enter := func() (bool, error) {
+		res, err := db.ExecContext(ctx, `
+			UPDATE mailing_segment_memberships
+			SET is_member = TRUE, entered_at = NOW(), exited_at = NULL, updated_at = NOW()
+			WHERE segment_id = $1 AND subscriber_id = $2 AND NOT is_member
+		`, seg.ID, subscriberID)
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n > 0, nil
+	}
+
+	ok, err := enter()
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return SegmentEntered, nil
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO mailing_segment_memberships (segment_id, subscriber_id, organization_id, is_member, entered_at, updated_at)
+		VALUES ($1, $2, $3, TRUE, NOW(), NOW())
+		ON CONFLICT (segment_id, subscriber_id) DO NOTHING
+	`, seg.ID, subscriberID, seg.OrganizationID)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return SegmentEntered, nil
+	}
+	// The row exists: either already a member or a concurrent exit landed
+	// between the two statements.
+	if ok, err = enter(); err != nil || !ok {
+		return "", err
+	}
+	return SegmentEntered, nil
+}
+
+func (m *MembershipEngine) recordEvent(ctx context.Context, ev MembershipEvent) error {
+	_, err := m.engine.db.ExecContext(ctx, `
+		INSERT INTO mailing_segment_membership_events (id, segment_id, subscriber_id, organization_id, event_type, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, ev.ID, ev.SegmentID, ev.SubscriberID, ev.OrganizationID, string(ev.Type), ev.At)
+	return err
+}
+
+// Seed recomputes a realtime segment's memberships without emitting events,
+// so subscribers who already match do not enter it again. It does nothing
+// for segments that are not realtime or hybrid.
+func (m *MembershipEngine) Seed(ctx context.Context, segmentID uuid.UUID) error {
+	_, err := m.sync(ctx, segmentID, false)
+	return err
+}
+
+// Refresh recomputes a seeded realtime segment's memberships and emits the
+// transitions incremental updates missed, including members aging out of
+// time-relative conditions. It returns the events that were emitted.
+func (m *MembershipEngine) Refresh(ctx context.Context, segmentID uuid.UUID) ([]MembershipEvent, error) {
+	return m.sync(ctx, segmentID, true)
+}
+
+// sync recomputes every membership of a segment in one transaction and
+// marks the segment seeded. With emit set, the transitions are recorded and
+// passed to the listeners after the commit.
+func (m *MembershipEngine) sync(ctx context.Context, segmentID uuid.UUID, emit bool) ([]MembershipEvent, error) {
+	db := m.engine.db
+	seg := &Segment{ID: segmentID}
+	err := db.QueryRowContext(ctx, `
+		SELECT organization_id, list_id, include_suppressed, COALESCE(global_exclusion_rules, '[]'::jsonb)
+		FROM mailing_segments
+		WHERE id = $1 AND status = 'active' AND segment_type = 'dynamic'
+		  AND calculation_mode IN ('realtime', 'hybrid')
+	`, segmentID).Scan(&seg.OrganizationID, &seg.ListID, &seg.IncludeSuppressed, &seg.GlobalExclusionRules)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load segment: %w", err)
+	}
+	ms := &membershipSegment{segment: seg}
+	if err := m.prepare(ctx, ms); err != nil {
+		return nil, err
+	}
+
+	members, args, err := m.queryBuilder(ctx, seg).BuildIDQuery(ms.conditions, ms.exclusions)
+	if err != nil {
+		return nil, err
+	}
+	n := len(args)
+	returning := ""
+	if emit {
+		returning = "\nRETURNING subscriber_id"
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	entered, err := syncTransitions(ctx, tx, fmt.Sprintf(`
+		WITH members AS (%s)
+		INSERT INTO mailing_segment_memberships (segment_id, subscriber_id, organization_id, is_member, entered_at, updated_at)
+		SELECT $%d, id, $%d, TRUE, NOW(), NOW() FROM members
+		ON CONFLICT (segment_id, subscriber_id) DO UPDATE
+		SET is_member = TRUE, entered_at = NOW(), exited_at = NULL, updated_at = NOW()
+		WHERE NOT mailing_segment_memberships.is_member`+returning,
+		members, n+1, n+2), append(args, seg.ID, seg.OrganizationID)...)
+	if err != nil {
+		return nil, fmt.Errorf("enter members: %w", err)
+	}
+	exited, err := syncTransitions(ctx, tx, fmt.Sprintf(`
+		WITH members AS (%s)
+		UPDATE mailing_segment_memberships
+		SET is_member = FALSE, exited_at = NOW(), updated_at = NOW()
+		WHERE segment_id = $%d AND is_member AND subscriber_id NOT IN (SELECT id FROM members)`+returning,
+		members, n+1), append(args, seg.ID)...)
+	if err != nil {
+		return nil, fmt.Errorf("exit members: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE mailing_segments SET memberships_synced_at = NOW() WHERE id = $1
+	`, seg.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	m.Invalidate(seg.OrganizationID)
+
+	if !emit {
+		return nil, nil
+	}
+	var events []MembershipEvent
+	now := time.Now()
+	for _, group := range []struct {
+		evType MembershipEventType
+		ids    []uuid.UUID
+	}{{SegmentEntered, entered}, {SegmentExited, exited}} {
+		for _, id := range group.ids {
+			events = append(events, MembershipEvent{
+				ID:             uuid.New(),
+				Type:           group.evType,
+				SegmentID:      seg.ID,
+				OrganizationID: seg.OrganizationID,
+				SubscriberID:   id,
+				At:             now,
+			})
+		}
+	}
+	if len(events) > 0 {
+		m.dispatch(ctx, events)
+	}
+	return events, nil
+}
+
+// syncTransitions runs a membership statement and returns the subscriber ids
+// it reports, if any.
+func syncTransitions(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]uuid.UUID, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,255 @@
+package segmentation
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestDependencies(t *testing.T) {
+	group := ConditionGroupBuilder{
+		LogicOperator: LogicAnd,
+		Conditions: []ConditionBuilder{
+			{ConditionType: ConditionProfile, Field: "engagement_score", Operator: OpGte, Value: "50"},
+			{ConditionType: ConditionEvent, EventName: "email_clicked", Operator: OpEventInLastDays, Value: "7"},
+		},
+		Groups: []ConditionGroupBuilder{{
+			LogicOperator: LogicOr,
+			Conditions: []ConditionBuilder{
+				{ConditionType: ConditionCustomField, Field: "plan", Operator: OpEquals, Value: "pro"},
+				{ConditionType: ConditionEvent, EventName: "purchase", Operator: OpEventCountGte, Value: "1"},
+			},
+		}},
+	}
+	exclusions := []ConditionBuilder{{ConditionType: ConditionTag, Field: "tags", Operator: OpContainsAny, ValuesArray: []string{"vip"}}}
+	deps := Dependencies(group, exclusions)
+
+	for _, want := range []string{"profile:engagement_score", "event:clicked", "custom:plan", "event:purchase", "tag", "profile:status", "suppression"} {
+		if !deps[want] {
+			t.Errorf("missing dependency %q in %v", want, deps)
+		}
+	}
+
+	cases := []struct {
+		change Change
+		want   bool
+	}{
+		{Change{Events: []string{"clicked"}}, true},
+		{Change{Events: []string{"email_clicked"}}, true},
+		{Change{Events: []string{"opened"}}, false},
+		{Change{Events: []string{"purchase"}}, true},
+		{Change{Fields: []string{"first_name"}}, false},
+		{Change{Fields: []string{"status"}}, true},
+		{Change{CustomFields: []string{"plan"}}, true},
+		{Change{CustomFields: []string{"country"}}, false},
+		{Change{Computed: true}, false},
+		{Change{Suppressed: true}, true},
+	}
+	for _, c := range cases {
+		if got := affects(deps, c.change, c.change.keys()); got != c.want {
+			t.Errorf("affects(%+v) = %v, want %v", c.change, got, c.want)
+		}
+	}
+
+	crossEntity := Dependencies(ConditionGroupBuilder{Conditions: []ConditionBuilder{
+		{ConditionType: ConditionRevenue, Operator: OpGte, Value: "50"},
+		{ConditionType: ConditionDataQuality, Operator: OpEquals, Value: "verified"},
+		{ConditionType: ConditionCampaign, Operator: OpCampaignReceivedNotClicked, CampaignID: "c"},
+	}}, nil)
+	for _, c := range []Change{{Revenue: true}, {Fields: []string{"data_quality_score"}}, {Events: []string{"clicked"}}, {Events: []string{"sent"}}} {
+		if !affects(crossEntity, c, c.keys()) {
+			t.Errorf("expected %+v to affect cross-entity conditions", c)
+		}
+	}
+	if affects(crossEntity, Change{Computed: true}, Change{Computed: true}.keys()) {
+		t.Error("computed changes do not affect revenue or campaign conditions")
+	}
+
+	anyEvent := Dependencies(ConditionGroupBuilder{Conditions: []ConditionBuilder{
+		{ConditionType: ConditionEvent, Operator: OpEventCountGte, Value: "3"},
+	}}, nil)
+	if !affects(anyEvent, Change{Events: []string{"bounced"}}, nil) {
+		t.Error("a condition on any event is affected by every event")
+	}
+}
+
+func TestMembershipEngineApply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	engine := NewEngine(db)
+	orgID, subID := uuid.New(), uuid.New()
+	planSeg, openSeg := uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	var received []MembershipEvent
+	engine.Membership().Subscribe(func(_ context.Context, ev MembershipEvent) {
+		received = append(received, ev)
+	})
+
+	expectCount := func(n int) {
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM mailing_subscribers s(.|\n)*AND s.id = \$\d+$`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(n))
+	}
+
+	// An open re-evaluates only the segment built on opens.
+	mock.ExpectQuery("FROM mailing_segments").WithArgs(orgID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "list_id", "include_suppressed", "global_exclusion_rules"}).
+			AddRow(planSeg, nil, false, []byte("[]")).
+			AddRow(openSeg, nil, false, []byte("[]")))
+	mock.ExpectQuery("SELECT conditions").WithArgs(planSeg).
+		WillReturnRows(sqlmock.NewRows([]string{"conditions"}).AddRow(
+			`{"logic_operator":"AND","conditions":[{"condition_type":"custom_field","field":"plan","operator":"equals","value":"pro"}]}`))
+	mock.ExpectQuery("SELECT conditions").WithArgs(openSeg).
+		WillReturnRows(sqlmock.NewRows([]string{"conditions"}).AddRow(
+			`{"logic_operator":"AND","conditions":[{"condition_type":"event","event_name":"email_opened","operator":"event_in_last_days","value":"30"}]}`))
+	mock.ExpectQuery("WITH tracking_tables AS").
+		WillReturnRows(sqlmock.NewRows([]string{"supported"}).AddRow(false))
+	expectCount(1)
+	mock.ExpectExec("UPDATE mailing_segment_memberships(.|\n)*NOT is_member").WithArgs(openSeg, subID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO mailing_segment_memberships").WithArgs(openSeg, subID, orgID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT email FROM mailing_subscribers").WithArgs(subID).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("reader@example.com"))
+	mock.ExpectExec("INSERT INTO mailing_segment_membership_events").
+		WithArgs(sqlmock.AnyArg(), openSeg, subID, orgID, "entered", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	events, err := engine.Membership().Apply(ctx, Change{OrganizationID: orgID, SubscriberID: subID, Events: []string{"opened"}})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Type != SegmentEntered || events[0].SegmentID != openSeg {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if len(received) != 1 || received[0].Email != "reader@example.com" {
+		t.Fatalf("listener got %+v", received)
+	}
+
+	// A custom field edit that leaves a non-member out emits nothing.
+	expectCount(0)
+	mock.ExpectExec("UPDATE mailing_segment_memberships(.|\n)*AND is_member").WithArgs(planSeg, subID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	events, err = engine.Membership().Apply(ctx, Change{OrganizationID: orgID, SubscriberID: subID, CustomFields: []string{"plan"}})
+	if err != nil || len(events) != 0 {
+		t.Fatalf("Apply() = %+v, %v; want no events", events, err)
+	}
+
+	// Unsubscribing affects every segment; the subscriber exits the one it was in.
+	expectCount(0)
+	mock.ExpectExec("UPDATE mailing_segment_memberships(.|\n)*AND is_member").WithArgs(planSeg, subID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectCount(0)
+	mock.ExpectExec("UPDATE mailing_segment_memberships(.|\n)*AND is_member").WithArgs(openSeg, subID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT email FROM mailing_subscribers").WithArgs(subID).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("reader@example.com"))
+	mock.ExpectExec("INSERT INTO mailing_segment_membership_events").
+		WithArgs(sqlmock.AnyArg(), openSeg, subID, orgID, "exited", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	events, err = engine.Membership().Apply(ctx, Change{OrganizationID: orgID, SubscriberID: subID, Fields: []string{"status"}, Suppressed: true})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Type != SegmentExited || len(received) != 2 {
+		t.Fatalf("unexpected events %+v (listener %+v)", events, received)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("sqlmock expectations were not met: %v", err)
+	}
+}
+
+func TestMembershipEngineSeedAndRefresh(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	engine := NewEngine(db)
+	orgID, segID := uuid.New(), uuid.New()
+	joined, left := uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	var received []MembershipEvent
+	engine.Membership().Subscribe(func(_ context.Context, ev MembershipEvent) {
+		received = append(received, ev)
+	})
+
+	expectSegment := func() {
+		mock.ExpectQuery("FROM mailing_segments(.|\n)*calculation_mode IN").WithArgs(segID).
+			WillReturnRows(sqlmock.NewRows([]string{"organization_id", "list_id", "include_suppressed", "global_exclusion_rules"}).
+				AddRow(orgID, nil, false, []byte("[]")))
+		mock.ExpectQuery("SELECT conditions").WithArgs(segID).
+			WillReturnRows(sqlmock.NewRows([]string{"conditions"}).AddRow(
+				`{"logic_operator":"AND","conditions":[{"condition_type":"event","event_name":"email_opened","operator":"event_in_last_days","value":"7"}]}`))
+	}
+
+	// A batch segment is left alone.
+	mock.ExpectQuery("FROM mailing_segments").WithArgs(segID).WillReturnError(sql.ErrNoRows)
+	if err := engine.Membership().Seed(ctx, segID); err != nil {
+		t.Fatalf("Seed() on a batch segment error = %v", err)
+	}
+
+	// Seeding stores the current members without recording events.
+	expectSegment()
+	mock.ExpectQuery("WITH tracking_tables AS").
+		WillReturnRows(sqlmock.NewRows([]string{"supported"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WITH members AS \(SELECT DISTINCT s.id FROM mailing_subscribers s(.|\n)*INSERT INTO mailing_segment_memberships(.|\n)*NOT mailing_segment_memberships.is_member$`).
+		WillReturnRows(sqlmock.NewRows([]string{"subscriber_id"}))
+	mock.ExpectQuery(`WITH members AS(.|\n)*SET is_member = FALSE(.|\n)*NOT IN \(SELECT id FROM members\)$`).
+		WillReturnRows(sqlmock.NewRows([]string{"subscriber_id"}))
+	mock.ExpectExec("UPDATE mailing_segments SET memberships_synced_at = NOW()").WithArgs(segID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := engine.Membership().Seed(ctx, segID); err != nil {
+		t.Fatalf("Seed() error = %v", err)
+	}
+
+	// Refreshing emits the transitions it finds.
+	expectSegment()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO mailing_segment_memberships(.|\n)*RETURNING subscriber_id`).
+		WillReturnRows(sqlmock.NewRows([]string{"subscriber_id"}).AddRow(joined))
+	mock.ExpectQuery(`SET is_member = FALSE(.|\n)*RETURNING subscriber_id`).
+		WillReturnRows(sqlmock.NewRows([]string{"subscriber_id"}).AddRow(left))
+	mock.ExpectExec("UPDATE mailing_segments SET memberships_synced_at = NOW()").WithArgs(segID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	for _, ev := range []struct {
+		sub   uuid.UUID
+		email string
+		typ   string
+	}{{joined, "new@example.com", "entered"}, {left, "lapsed@example.com", "exited"}} {
+		mock.ExpectQuery("SELECT email FROM mailing_subscribers").WithArgs(ev.sub).
+			WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow(ev.email))
+		mock.ExpectExec("INSERT INTO mailing_segment_membership_events").
+			WithArgs(sqlmock.AnyArg(), segID, ev.sub, orgID, ev.typ, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	events, err := engine.Membership().Refresh(ctx, segID)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(events) != 2 || events[0].Type != SegmentEntered || events[1].Type != SegmentExited {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if len(received) != 2 || received[1].Email != "lapsed@example.com" {
+		t.Fatalf("listener got %+v", received)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("sqlmock expectations were not met: %v", err)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
 )
 
 // QueryBuilder builds SQL queries from segment conditions
@@ -17,6 +19,7 @@ type QueryBuilder struct {
 	listID           string
 	organizationID   string
 	trackingEmailOK  bool
+	ispResolver      *isp.Resolver
 	debug            bool
 }
 
@@ -54,6 +57,13 @@ func (qb *QueryBuilder) SetIncludeSuppressed(include bool) *QueryBuilder {
 	return qb
 }
 
+// SetISPResolver sets the resolver whose domain table isp_group conditions
+// match against (default: the organization's, isp.ForOrg).
+func (qb *QueryBuilder) SetISPResolver(r *isp.Resolver) *QueryBuilder {
+	qb.ispResolver = r
+	return qb
+}
+
 // SetDebug enables debug mode
 func (qb *QueryBuilder) SetDebug(debug bool) *QueryBuilder {
 	qb.debug = debug
@@ -207,6 +217,16 @@ func (qb *QueryBuilder) BuildCountQuery(group ConditionGroupBuilder, globalExclu
 	return query, qb.args, nil
 }
 
+// BuildIDQuery builds a query selecting the ids of matching subscribers,
+// with the same filters as BuildCountQuery.
+func (qb *QueryBuilder) BuildIDQuery(group ConditionGroupBuilder, globalExclusions []ConditionBuilder) (string, []interface{}, error) {
+	query, args, err := qb.BuildCountQuery(group, globalExclusions)
+	if err != nil {
+		return "", nil, err
+	}
+	return "SELECT DISTINCT s.id" + strings.TrimPrefix(query, "SELECT COUNT(*)"), args, nil
+}
+
 // buildJoins determines what JOINs are needed
 func (qb *QueryBuilder) buildJoins(group ConditionGroupBuilder) string {
 	joins := []string{}
@@ -297,6 +317,16 @@ func (qb *QueryBuilder) buildCondition(cond ConditionBuilder) (string, error) {
 		return qb.buildComputedCondition(cond)
 	case ConditionTag:
 		return qb.buildTagCondition(cond)
+	case ConditionRevenue:
+		return qb.buildRevenueCondition(cond)
+	case ConditionPredictive:
+		return qb.buildPredictiveCondition(cond)
+	case ConditionISP:
+		return qb.buildISPCondition(cond)
+	case ConditionDataQuality:
+		return qb.buildDataQualityCondition(cond)
+	case ConditionCampaign:
+		return qb.buildCampaignCondition(cond)
 	default:
 		return qb.buildProfileCondition(cond)
 	}
@@ -478,6 +508,12 @@ func (qb *QueryBuilder) buildTrackingEventFilter(alias, eventCol string, cond Co
 	return fmt.Sprintf("AND %s.%s = %s", alias, eventCol, qb.nextArg(eventVal))
 }
 
+// humanTrafficFilter restricts tracking events to those classified as
+// human. Events recorded before classification count as human.
+func humanTrafficFilter(alias string) string {
+	return fmt.Sprintf("AND COALESCE(%s.traffic_class, 'human') = 'human'", alias)
+}
+
 func (qb *QueryBuilder) buildTrackingSubscriberMatch(alias string) string {
 	if !qb.trackingEmailOK {
 		return fmt.Sprintf("%s.subscriber_id = s.id", alias)
@@ -504,6 +540,9 @@ func (qb *QueryBuilder) buildEventCondition(cond ConditionBuilder) (string, erro
 	if cond.EventSendingDomain != "" && useTrackingTable {
 		domainFilter = fmt.Sprintf("AND e.sending_domain ILIKE %s", qb.nextArg("%"+cond.EventSendingDomain+"%"))
 	}
+	if cond.ExcludeMachine && useTrackingTable {
+		domainFilter += " " + humanTrafficFilter("e")
+	}
 
 	switch cond.Operator {
 	case OpEquals:
@@ -675,21 +714,42 @@ func (qb *QueryBuilder) buildTagCondition(cond ConditionBuilder) (string, error)
 	}
 }
 
-// HashQuery generates a deterministic hash of the query for caching
+// HashQuery generates a deterministic hash of the query for caching. Queries
+// with isp_group conditions also hash the organization's ISP resolver
+// version, since a domain override changes which subscribers they match.
 func HashQuery(group ConditionGroupBuilder, exclusions []ConditionBuilder, orgID, listID string) string {
 	data := struct {
 		Group      ConditionGroupBuilder `json:"group"`
 		Exclusions []ConditionBuilder    `json:"exclusions"`
 		OrgID      string                `json:"org_id"`
 		ListID     string                `json:"list_id"`
+		ISPVersion uint64                `json:"isp_version,omitempty"`
 	}{
 		Group:      group,
 		Exclusions: exclusions,
 		OrgID:      orgID,
 		ListID:     listID,
 	}
+	if usesISPGroup(group, exclusions) {
+		data.ISPVersion = isp.ForOrg(orgID).Version()
+	}
 
 	jsonBytes, _ := json.Marshal(data)
 	hash := sha256.Sum256(jsonBytes)
 	return hex.EncodeToString(hash[:])
 }
+
+// usesISPGroup reports whether any condition matches on isp_group.
+func usesISPGroup(group ConditionGroupBuilder, exclusions []ConditionBuilder) bool {
+	for _, c := range append(group.Conditions, exclusions...) {
+		if c.ConditionType == ConditionISP {
+			return true
+		}
+	}
+	for _, g := range group.Groups {
+		if usesISPGroup(g, nil) {
+			return true
+		}
+	}
+	return false
+}
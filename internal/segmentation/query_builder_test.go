@@ -3,6 +3,8 @@ package segmentation
 import (
 	"strings"
 	"testing"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
 )
 
 func TestBuildCountQueryUsesTrackingEventsForEmailSent(t *testing.T) {
@@ -120,3 +122,212 @@ func TestBuildCountQueryMailedButNotOpened(t *testing.T) {
 		t.Fatalf("expected 3 args (org-id + sent + opened), got %d (%#v)", len(args), args)
 	}
 }
+
+func TestBuildCountQueryRevenueAndPredictive(t *testing.T) {
+	qb := NewQueryBuilder()
+	qb.SetOrganizationID("org-123")
+
+	group := ConditionGroupBuilder{
+		LogicOperator: LogicAnd,
+		Conditions: []ConditionBuilder{
+			{
+				ConditionType:       ConditionRevenue,
+				Field:               RevenueFieldTotal,
+				Operator:            OpGte,
+				Value:               "100",
+				OfferID:             "offer-9",
+				EventTimeWindowDays: 30,
+			},
+			{
+				ConditionType: ConditionPredictive,
+				Field:         "next_open_probability",
+				Operator:      OpGt,
+				Value:         "0.4",
+			},
+			{
+				ConditionType: ConditionPredictive,
+				Field:         "engagement_trend",
+				Operator:      OpEquals,
+				Value:         "declining",
+			},
+		},
+	}
+
+	query, args, err := qb.BuildCountQuery(group, nil)
+	if err != nil {
+		t.Fatalf("BuildCountQuery() error = %v", err)
+	}
+	for _, want := range []string{
+		"FROM mailing_revenue_attributions ra WHERE ra.subscriber_id = s.id AND ra.everflow_offer_id = $2",
+		"ra.converted_at >= NOW() - INTERVAL '30 days'",
+		"NULLIF(si.predictive_scores->>'next_open_probability', '')::numeric",
+		"si.engagement_profile->>'engagement_trend' FROM",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("expected %q in query, got:\n%s", want, query)
+		}
+	}
+	if len(args) != 5 || args[1] != "offer-9" || args[2] != "100" || args[3] != "0.4" || args[4] != "declining" {
+		t.Fatalf("unexpected args %#v", args)
+	}
+
+	_, _, err = NewQueryBuilder().BuildCountQuery(ConditionGroupBuilder{Conditions: []ConditionBuilder{
+		{ConditionType: ConditionPredictive, Field: "x'; DROP TABLE s; --", Operator: OpGt, Value: "1"},
+	}}, nil)
+	if err == nil {
+		t.Fatal("expected unknown predictive field to be rejected")
+	}
+}
+
+func TestBuildISPCondition(t *testing.T) {
+	r := isp.NewResolver()
+	r.SetOverride("corp-mail.example", isp.Gmail)
+	r.SetOverride("googlemail.com", isp.Other)
+
+	qb := NewQueryBuilder().SetISPResolver(r)
+	sql, err := qb.buildCondition(ConditionBuilder{ConditionType: ConditionISP, Operator: OpEquals, Value: "gmail"})
+	if err != nil {
+		t.Fatalf("buildCondition() error = %v", err)
+	}
+	if !strings.HasPrefix(sql, "LOWER(SPLIT_PART(s.email, '@', 2)) = ANY(ARRAY[") {
+		t.Fatalf("unexpected SQL %s", sql)
+	}
+	got := map[interface{}]bool{}
+	for _, a := range qb.args {
+		got[a] = true
+	}
+	if !got["gmail.com"] || !got["corp-mail.example"] || got["googlemail.com"] {
+		t.Fatalf("unexpected gmail domains %#v", qb.args)
+	}
+
+	qb = NewQueryBuilder().SetISPResolver(r)
+	sql, err = qb.buildCondition(ConditionBuilder{ConditionType: ConditionISP, Operator: OpNotContainsAny, ValuesArray: []string{"other"}})
+	if err != nil {
+		t.Fatalf("buildCondition() error = %v", err)
+	}
+	if !strings.HasPrefix(sql, "NOT (NOT (") {
+		t.Fatalf("expected negated other match, got %s", sql)
+	}
+	for _, a := range qb.args {
+		if a == "googlemail.com" {
+			t.Fatal("a domain overridden to other must not count as known")
+		}
+	}
+}
+
+func TestDataQualityTiers(t *testing.T) {
+	for score, want := range map[float64]string{0: "unverified", 0.25: "mx_valid", 0.3: "catch_all", 0.5: "verified", 0.99: "verified", 1: "engaged"} {
+		if got := DataQualityTierFor(score); got != want {
+			t.Errorf("DataQualityTierFor(%v) = %s, want %s", score, got, want)
+		}
+	}
+
+	qb := NewQueryBuilder()
+	sql, err := qb.buildCondition(ConditionBuilder{ConditionType: ConditionDataQuality, Operator: OpContainsAny, ValuesArray: []string{"verified", "engaged"}})
+	if err != nil {
+		t.Fatalf("buildCondition() error = %v", err)
+	}
+	want := "((COALESCE(s.data_quality_score, 0) >= $1 AND COALESCE(s.data_quality_score, 0) < $2) OR COALESCE(s.data_quality_score, 0) >= $3)"
+	if sql != want {
+		t.Fatalf("got %s, want %s", sql, want)
+	}
+	if len(qb.args) != 3 || qb.args[0] != 0.5 || qb.args[1] != 1.0 || qb.args[2] != 1.0 {
+		t.Fatalf("unexpected args %#v", qb.args)
+	}
+
+	if _, err := NewQueryBuilder().buildCondition(ConditionBuilder{ConditionType: ConditionDataQuality, Operator: OpEquals, Value: "gold"}); err == nil {
+		t.Fatal("expected unknown tier to be rejected")
+	}
+}
+
+func TestBuildEventConditionExcludesMachineTraffic(t *testing.T) {
+	human := "AND COALESCE(e.traffic_class, 'human') = 'human'"
+	for _, cond := range []ConditionBuilder{
+		{ConditionType: ConditionEvent, Operator: OpEventCountGte, EventName: "email_opened", Value: "2", ExcludeMachine: true},
+		{ConditionType: ConditionCampaign, Operator: OpCampaignClicked, CampaignID: "camp-1", ExcludeMachine: true},
+	} {
+		sql, err := NewQueryBuilder().buildCondition(cond)
+		if err != nil {
+			t.Fatalf("buildCondition(%s) error = %v", cond.Operator, err)
+		}
+		if !strings.Contains(sql, human) {
+			t.Errorf("expected machine traffic filter for %s, got:\n%s", cond.Operator, sql)
+		}
+		cond.ExcludeMachine = false
+		if sql, _ = NewQueryBuilder().buildCondition(cond); strings.Contains(sql, "traffic_class") {
+			t.Errorf("unexpected machine traffic filter for %s:\n%s", cond.Operator, sql)
+		}
+	}
+
+	sql, _ := NewQueryBuilder().buildCondition(ConditionBuilder{
+		ConditionType: ConditionEvent, Operator: OpEquals, EventName: "purchase", ExcludeMachine: true,
+	})
+	if strings.Contains(sql, "traffic_class") {
+		t.Errorf("custom events have no traffic class, got:\n%s", sql)
+	}
+}
+
+func TestBuildCampaignReceivedNotClickedOffer(t *testing.T) {
+	qb := NewQueryBuilder()
+	qb.SetOrganizationID("org-123")
+
+	group := ConditionGroupBuilder{Conditions: []ConditionBuilder{{
+		ConditionType: ConditionCampaign,
+		Operator:      OpCampaignReceivedNotClicked,
+		CampaignID:    "camp-1",
+		OfferID:       "529",
+	}}}
+	query, args, err := qb.BuildCountQuery(group, nil)
+	if err != nil {
+		t.Fatalf("BuildCountQuery() error = %v", err)
+	}
+	for _, want := range []string{
+		"e.event_type IN ('sent', 'delivered')",
+		"AND NOT \n\t\t\tEXISTS",
+		"e.event_type = 'clicked'",
+		"FROM mailing_offer_encodings oe",
+		"e.link_url ILIKE '%' || oe.encoded_value || '%'",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("expected %q in query, got:\n%s", want, query)
+		}
+	}
+	if len(args) != 4 || args[1] != "camp-1" || args[2] != "camp-1" || args[3] != "529" {
+		t.Fatalf("unexpected args %#v", args)
+	}
+
+	if _, err := NewQueryBuilder().buildCondition(ConditionBuilder{ConditionType: ConditionCampaign, Operator: OpCampaignReceived}); err == nil {
+		t.Fatal("expected campaign_received without a campaign to be rejected")
+	}
+}
+
+func TestHashQueryCoversCrossEntityConditions(t *testing.T) {
+	base := ConditionBuilder{ConditionType: ConditionCampaign, Operator: OpCampaignReceivedNotClicked, CampaignID: "camp-1", OfferID: "529"}
+	other := base
+	other.OfferID = "530"
+
+	h1 := HashQuery(ConditionGroupBuilder{Conditions: []ConditionBuilder{base}}, nil, "org", "")
+	h2 := HashQuery(ConditionGroupBuilder{Conditions: []ConditionBuilder{other}}, nil, "org", "")
+	if h1 == h2 {
+		t.Fatal("expected offer_id to change the query hash")
+	}
+	if h1 != HashQuery(ConditionGroupBuilder{Conditions: []ConditionBuilder{base}}, nil, "org", "") {
+		t.Fatal("expected the hash to be deterministic")
+	}
+}
+
+func TestHashQueryChangesWithISPOverrides(t *testing.T) {
+	const org = "hash-isp-org"
+	ispGroup := ConditionGroupBuilder{Conditions: []ConditionBuilder{{ConditionType: ConditionISP, Operator: OpEquals, Value: isp.Gmail}}}
+	other := ConditionGroupBuilder{Conditions: []ConditionBuilder{{ConditionType: ConditionProfile, Field: "email", Operator: OpEquals, Value: "a@b.com"}}}
+
+	before, otherBefore := HashQuery(ispGroup, nil, org, ""), HashQuery(other, nil, org, "")
+	isp.ForOrg(org).SetOverride("vanity.com", isp.Gmail)
+
+	if HashQuery(ispGroup, nil, org, "") == before {
+		t.Fatal("expected an ISP override to change the hash of an isp_group query")
+	}
+	if HashQuery(other, nil, org, "") != otherBefore {
+		t.Fatal("expected queries without isp_group conditions to keep their hash")
+	}
+}
@@ -70,6 +70,13 @@ const (
 	OpEventNotInLastDays    Operator = "event_not_in_last_days"
 	OpEventPropertyEquals   Operator = "event_property_equals"
 	OpEventPropertyContains Operator = "event_property_contains"
+
+	// Campaign operators (ConditionCampaign)
+	OpCampaignReceived           Operator = "campaign_received"
+	OpCampaignNotReceived        Operator = "campaign_not_received"
+	OpCampaignClicked            Operator = "campaign_clicked"
+	OpCampaignNotClicked         Operator = "campaign_not_clicked"
+	OpCampaignReceivedNotClicked Operator = "campaign_received_not_clicked"
 )
 
 // OperatorMetadata contains info about an operator
@@ -138,6 +145,13 @@ func GetOperatorMetadata() []OperatorMetadata {
 		{OpEventNotInLastDays, "Event NOT in last X days", "Event did not occur in the last N days", []FieldType{FieldEvent}, true, false, false},
 		{OpEventPropertyEquals, "Event property equals", "Event has a property with specific value", []FieldType{FieldEvent}, true, false, false},
 		{OpEventPropertyContains, "Event property contains", "Event property contains value", []FieldType{FieldEvent}, true, false, false},
+
+		// Campaign operators
+		{OpCampaignReceived, "Received campaign", "Was sent the campaign", []FieldType{FieldCampaign}, false, false, false},
+		{OpCampaignNotReceived, "Did not receive campaign", "Was never sent the campaign", []FieldType{FieldCampaign}, false, false, false},
+		{OpCampaignClicked, "Clicked in campaign", "Clicked the campaign, or the offer when one is set", []FieldType{FieldCampaign}, false, false, false},
+		{OpCampaignNotClicked, "Did not click in campaign", "Never clicked the campaign, or the offer when one is set", []FieldType{FieldCampaign}, false, false, false},
+		{OpCampaignReceivedNotClicked, "Received but did not click", "Was sent the campaign but never clicked it, or the offer when one is set", []FieldType{FieldCampaign}, false, false, false},
 	}
 }
 
@@ -159,6 +173,7 @@ const (
 	FieldArray    FieldType = "array"
 	FieldTags     FieldType = "tags"
 	FieldEvent    FieldType = "event"
+	FieldCampaign FieldType = "campaign"
 )
 
 // ==========================================
@@ -174,6 +189,11 @@ const (
 	ConditionEvent       ConditionType = "event"        // Behavioral events
 	ConditionComputed    ConditionType = "computed"     // Computed fields
 	ConditionTag         ConditionType = "tag"          // Array/tag matching
+	ConditionRevenue     ConditionType = "revenue"      // Everflow conversion revenue
+	ConditionPredictive  ConditionType = "predictive"   // Subscriber intelligence scores
+	ConditionISP         ConditionType = "isp_group"    // Mailbox provider of the address
+	ConditionDataQuality ConditionType = "data_quality" // data_quality_score tier
+	ConditionCampaign    ConditionType = "campaign"     // Received/clicked a campaign or offer
 )
 
 // ==========================================
@@ -291,6 +311,12 @@ type ConditionBuilder struct {
 	EventMaxCount       int           `json:"event_max_count,omitempty"`
 	EventPropertyPath   string        `json:"event_property_path,omitempty"`
 	EventSendingDomain  string        `json:"event_sending_domain,omitempty"`
+	CampaignID          string        `json:"campaign_id,omitempty"`
+	// OfferID is an Everflow offer id, as in mailing_offer_encodings.
+	OfferID string `json:"offer_id,omitempty"`
+	// ExcludeMachine ignores tracking events classified as machine traffic
+	// (MPP prefetches, scanner clicks).
+	ExcludeMachine bool `json:"exclude_machine,omitempty"`
 }
 
 // ==========================================
@@ -0,0 +1,152 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Bus carries tracking events from the tracking service to the consumer.
+// Delivery is at-least-once: a message that is not acknowledged is delivered
+// again, so consumers deduplicate on TrackingEvent.EventID.
+type Bus interface {
+	// Publish durably enqueues an event.
+	Publish(ctx context.Context, evt TrackingEvent) error
+	// Receive returns up to max messages, waiting up to a backend-specific
+	// poll interval when none are ready. An empty result is not an error.
+	Receive(ctx context.Context, max int) ([]Message, error)
+	// Ack removes a processed message from the bus.
+	Ack(ctx context.Context, msg Message) error
+}
+
+// BatchPublisher is implemented by buses that can enqueue several events in
+// one call. PublishBatch returns one error per event, nil where the event
+// was enqueued.
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, evts []TrackingEvent) []error
+}
+
+// Message is a received event and the backend handle used to acknowledge it.
+type Message struct {
+	Event   TrackingEvent
+	receipt string
+	spool   spoolRange
+}
+
+// sqsAPI is the subset of the SQS client used by SQSBus.
+type sqsAPI interface {
+	SendMessage(ctx context.Context, in *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	SendMessageBatch(ctx context.Context, in *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+	ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, in *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+// SQSBus is a Bus backed by an SQS queue. Unacknowledged messages are
+// redelivered after the queue's visibility timeout.
+type SQSBus struct {
+	client   sqsAPI
+	queueURL string
+}
+
+func NewSQSBus(client *sqs.Client, queueURL string) *SQSBus {
+	return &SQSBus{client: client, queueURL: queueURL}
+}
+
+func (b *SQSBus) Publish(ctx context.Context, evt TrackingEvent) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("marshal tracking event: %w", err)
+	}
+	_, err = b.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(b.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	return err
+}
+
+// sqsMaxBatch is the most entries SendMessageBatch accepts.
+const sqsMaxBatch = 10
+
+// PublishBatch sends evts with SendMessageBatch, ten per request.
+func (b *SQSBus) PublishBatch(ctx context.Context, evts []TrackingEvent) []error {
+	errs := make([]error, len(evts))
+	for start := 0; start < len(evts); start += sqsMaxBatch {
+		end := min(start+sqsMaxBatch, len(evts))
+		entries := make([]types.SendMessageBatchRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			body, err := json.Marshal(evts[i])
+			if err != nil {
+				errs[i] = fmt.Errorf("marshal tracking event: %w", err)
+				continue
+			}
+			entries = append(entries, types.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(body)),
+			})
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		out, err := b.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(b.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			for _, e := range entries {
+				i, _ := strconv.Atoi(aws.ToString(e.Id))
+				errs[i] = err
+			}
+			continue
+		}
+		for _, f := range out.Failed {
+			i, err := strconv.Atoi(aws.ToString(f.Id))
+			if err != nil || i < start || i >= end {
+				continue
+			}
+			errs[i] = fmt.Errorf("sqs %s: %s", aws.ToString(f.Code), aws.ToString(f.Message))
+		}
+	}
+	return errs
+}
+
+// Receive long-polls the queue for up to 20 seconds. Messages that cannot be
+// decoded are deleted and skipped.
+func (b *SQSBus) Receive(ctx context.Context, max int) ([]Message, error) {
+	if max <= 0 || max > 10 {
+		max = 10
+	}
+	out, err := b.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(b.queueURL),
+		MaxNumberOfMessages: int32(max),
+		WaitTimeSeconds:     20,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]Message, 0, len(out.Messages))
+	for _, m := range out.Messages {
+		var evt TrackingEvent
+		if err := json.Unmarshal([]byte(aws.ToString(m.Body)), &evt); err != nil {
+			log.Printf("[TrackingBus] SQS bad message: %v", err)
+			b.Ack(ctx, Message{receipt: aws.ToString(m.ReceiptHandle)})
+			continue
+		}
+		msgs = append(msgs, Message{Event: evt, receipt: aws.ToString(m.ReceiptHandle)})
+	}
+	return msgs, nil
+}
+
+func (b *SQSBus) Ack(ctx context.Context, msg Message) error {
+	_, err := b.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(b.queueURL),
+		ReceiptHandle: aws.String(msg.receipt),
+	})
+	return err
+}
@@ -3,34 +3,48 @@ package tracking
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"fmt"
 	"log"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/botdetect"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
+	"github.com/lib/pq"
 )
 
 type Consumer struct {
-	sqsClient *sqs.Client
-	queueURL  string
-	db        *sql.DB
-	done      chan struct{}
+	bus        Bus
+	db         *sql.DB
+	membership *segmentation.MembershipEngine
+	classifier *botdetect.Classifier
+	done       chan struct{}
 }
 
-func NewConsumer(sqsClient *sqs.Client, queueURL string, db *sql.DB) *Consumer {
+func NewConsumer(bus Bus, db *sql.DB) *Consumer {
 	return &Consumer{
-		sqsClient: sqsClient,
-		queueURL:  queueURL,
-		db:        db,
-		done:      make(chan struct{}),
+		bus:        bus,
+		db:         db,
+		classifier: botdetect.Default(),
+		done:       make(chan struct{}),
 	}
 }
 
+// SetMembership enables incremental segment membership updates for the
+// subscribers behind processed events.
+func (c *Consumer) SetMembership(m *segmentation.MembershipEngine) {
+	c.membership = m
+}
+
+// SetClassifier replaces the bot classifier (default: botdetect.Default()).
+func (c *Consumer) SetClassifier(cl *botdetect.Classifier) {
+	c.classifier = cl
+}
+
 func (c *Consumer) Start(ctx context.Context) {
-	log.Printf("SQS tracking consumer started (queue=%s)", c.queueURL)
+	log.Printf("tracking consumer started")
 	go c.poll(ctx)
 }
 
@@ -39,6 +53,7 @@ func (c *Consumer) Stop() {
 }
 
 func (c *Consumer) poll(ctx context.Context) {
+	lastPrune := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
@@ -48,160 +63,293 @@ func (c *Consumer) poll(ctx context.Context) {
 		default:
 		}
 
-		out, err := c.sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-			QueueUrl:            aws.String(c.queueURL),
-			MaxNumberOfMessages: 10,
-			WaitTimeSeconds:     20,
-		})
+		msgs, err := c.bus.Receive(ctx, 10)
 		if err != nil {
 			if ctx.Err() != nil {
 				return
 			}
-			log.Printf("SQS receive error: %v", err)
+			log.Printf("tracking bus receive error: %v", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
 
-		for _, msg := range out.Messages {
-			var evt TrackingEvent
-			if err := json.Unmarshal([]byte(*msg.Body), &evt); err != nil {
-				log.Printf("SQS bad message: %v", err)
-				c.deleteMessage(ctx, msg.ReceiptHandle)
+		for _, msg := range msgs {
+			if err := c.handle(ctx, msg.Event); err != nil {
+				log.Printf("tracking process error (%s): %v", msg.Event.EventType, err)
 				continue
 			}
-
-			if err := c.processEvent(ctx, evt); err != nil {
-				log.Printf("SQS process error (%s): %v", evt.EventType, err)
-				continue
+			if err := c.bus.Ack(ctx, msg); err != nil {
+				log.Printf("tracking bus ack error: %v", err)
 			}
+		}
 
-			c.deleteMessage(ctx, msg.ReceiptHandle)
+		if time.Since(lastPrune) >= time.Hour {
+			c.pruneReceipts(ctx)
+			lastPrune = time.Now()
 		}
 	}
 }
 
-func (c *Consumer) deleteMessage(ctx context.Context, handle *string) {
-	c.sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(c.queueURL),
-		ReceiptHandle: handle,
-	})
+// handle processes an event at most once per EventID. The receipt and
+// everything the event changes are written in one transaction, so a failed
+// event leaves no trace and is redelivered, and a consumer processing the
+// same event concurrently blocks on the receipt until this one commits.
+// Events published before event IDs existed are processed without
+// deduplication.
+func (c *Consumer) handle(ctx context.Context, evt TrackingEvent) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if eventID, err := uuid.Parse(evt.EventID); err == nil {
+		// Claims left in 'processing' by consumers predating this
+		// transaction are taken over.
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO mailing_tracking_event_receipts (event_id, event_type, state, claimed_at, processed_at)
+			VALUES ($1, $2, 'done', NOW(), NOW())
+			ON CONFLICT (event_id) DO UPDATE SET state = 'done', claimed_at = NOW(), processed_at = NOW()
+			WHERE mailing_tracking_event_receipts.state = 'processing'
+		`, eventID, string(evt.EventType))
+		if err != nil {
+			return fmt.Errorf("claim event %s: %w", eventID, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("DUPLICATE %s event=%s skipped", evt.EventType, eventID)
+			return nil
+		}
+	}
+
+	change, err := c.processEvent(ctx, tx, evt)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Membership is evaluated against the committed counters
+	if change != nil {
+		c.applySegments(ctx, *change)
+	}
+	return nil
+}
+
+// receiptRetention outlives the longest redelivery window of any bus (SQS
+// keeps messages for at most 14 days).
+const receiptRetention = "15 days"
+
+func (c *Consumer) pruneReceipts(ctx context.Context) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM mailing_tracking_event_receipts WHERE claimed_at < NOW() - $1::interval`, receiptRetention)
+	if err != nil {
+		log.Printf("tracking receipt prune error: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("pruned %d tracking event receipts", n)
+	}
 }
 
-func (c *Consumer) processEvent(ctx context.Context, evt TrackingEvent) error {
+// processEvent applies the event within tx and returns the change segment
+// membership must be re-evaluated for once it commits, if any.
+func (c *Consumer) processEvent(ctx context.Context, tx *sql.Tx, evt TrackingEvent) (*segmentation.Change, error) {
 	switch evt.EventType {
 	case EventOpen:
-		return c.processOpen(ctx, evt)
+		return c.processOpen(ctx, tx, evt)
 	case EventClick:
-		return c.processClick(ctx, evt)
+		return c.processClick(ctx, tx, evt)
 	case EventUnsubscribe:
-		return c.processUnsubscribe(ctx, evt)
+		return c.processUnsubscribe(ctx, tx, evt)
 	default:
 		log.Printf("unknown event type: %s", evt.EventType)
-		return nil
+		return nil, nil
 	}
 }
 
-func (c *Consumer) processOpen(ctx context.Context, evt TrackingEvent) error {
+func (c *Consumer) processOpen(ctx context.Context, tx *sql.Tx, evt TrackingEvent) (*segmentation.Change, error) {
 	orgID, _ := uuid.Parse(evt.OrgID)
 	campaignID, _ := uuid.Parse(evt.CampaignID)
 	subscriberID, _ := uuid.Parse(evt.SubscriberID)
 	emailID, _ := uuid.Parse(evt.EmailID)
 
 	var email string
-	c.db.QueryRowContext(ctx, `SELECT email FROM mailing_subscribers WHERE id = $1`, subscriberID).Scan(&email)
-
-	// MPP detection: check if delivery happened within 30 seconds of this open
-	isMachineOpen := false
-	var deliveredAt time.Time
-	if err := c.db.QueryRowContext(ctx, `
-		SELECT event_at FROM mailing_tracking_events
-		WHERE subscriber_id = $1 AND campaign_id = $2 AND event_type = 'delivered'
-		ORDER BY event_at DESC LIMIT 1
-	`, subscriberID, campaignID).Scan(&deliveredAt); err == nil {
-		if evt.Timestamp.Sub(deliveredAt) <= 30*time.Second {
-			isMachineOpen = true
-		}
-	}
+	tx.QueryRowContext(ctx, `SELECT email FROM mailing_subscribers WHERE id = $1`, subscriberID).Scan(&email)
+
+	verdict := c.classifier.Classify(botdetect.Signals{
+		Kind:        botdetect.KindOpen,
+		IP:          evt.IPAddress,
+		UserAgent:   evt.UserAgent,
+		At:          evt.Timestamp,
+		DeliveredAt: mailing.DeliveredAt(ctx, c.db, subscriberID, campaignID),
+	})
 
-	_, err := c.db.ExecContext(ctx, `
-		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, sending_domain, is_machine_open)
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, sending_domain, is_machine_open, traffic_class, bot_reasons)
 		SELECT $1, $2, $3, $4, 'opened', $5, $6, $7, $8,
-			LOWER(SPLIT_PART(c.from_email, '@', 2)), $9
+			LOWER(SPLIT_PART(c.from_email, '@', 2)), $9, $10, $11
 		FROM mailing_campaigns c WHERE c.id = $3
 		ON CONFLICT DO NOTHING
-	`, emailID, orgID, campaignID, subscriberID, evt.Timestamp, evt.IPAddress, evt.UserAgent, detectDevice(evt.UserAgent), isMachineOpen)
+	`, emailID, orgID, campaignID, subscriberID, evt.Timestamp, evt.IPAddress, evt.UserAgent, detectDevice(evt.UserAgent),
+		verdict.Machine(), string(verdict.Class), pq.Array(verdict.ReasonStrings()))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := mailing.RecordABEngagement(ctx, tx, campaignID, subscriberID, mailing.ABEventOpen, string(verdict.Class)); err != nil {
+		return nil, fmt.Errorf("A/B open event: %w", err)
 	}
 
-	c.db.ExecContext(ctx, `UPDATE mailing_campaigns SET open_count = open_count + 1 WHERE id = $1`, campaignID)
-	c.db.ExecContext(ctx, `UPDATE mailing_subscribers SET total_opens = total_opens + 1, last_open_at = NOW(), updated_at = NOW() WHERE id = $1`, subscriberID)
-	c.db.ExecContext(ctx, `UPDATE mailing_inbox_profiles SET total_opens = total_opens + 1, last_open_at = NOW(), updated_at = NOW() WHERE email = $1`, email)
-	c.updateEngagementScore(ctx, subscriberID)
+	if err := execAll(ctx, tx,
+		stmt{`UPDATE mailing_campaigns SET open_count = open_count + 1 WHERE id = $1`, []interface{}{campaignID}},
+		stmt{`UPDATE mailing_subscribers SET total_opens = total_opens + 1, last_open_at = NOW(), updated_at = NOW() WHERE id = $1`, []interface{}{subscriberID}},
+		stmt{`UPDATE mailing_inbox_profiles SET total_opens = total_opens + 1, last_open_at = NOW(), updated_at = NOW() WHERE email = $1`, []interface{}{email}},
+	); err != nil {
+		return nil, err
+	}
+	if err := c.updateEngagementScore(ctx, tx, subscriberID); err != nil {
+		return nil, err
+	}
 
-	log.Printf("PROCESSED OPEN: campaign=%s subscriber=%s email=%s", campaignID, subscriberID, email)
-	return nil
+	log.Printf("PROCESSED OPEN: campaign=%s subscriber=%s email=%s class=%s", campaignID, subscriberID, email, verdict.Class)
+	return &segmentation.Change{
+		OrganizationID: orgID,
+		SubscriberID:   subscriberID,
+		Fields:         []string{"total_opens", "last_open_at", "engagement_score"},
+		Events:         []string{"opened"},
+	}, nil
 }
 
-func (c *Consumer) processClick(ctx context.Context, evt TrackingEvent) error {
+func (c *Consumer) processClick(ctx context.Context, tx *sql.Tx, evt TrackingEvent) (*segmentation.Change, error) {
 	orgID, _ := uuid.Parse(evt.OrgID)
 	campaignID, _ := uuid.Parse(evt.CampaignID)
 	subscriberID, _ := uuid.Parse(evt.SubscriberID)
 
 	var email string
-	c.db.QueryRowContext(ctx, `SELECT email FROM mailing_subscribers WHERE id = $1`, subscriberID).Scan(&email)
+	tx.QueryRowContext(ctx, `SELECT email FROM mailing_subscribers WHERE id = $1`, subscriberID).Scan(&email)
+
+	verdict := c.classifier.Classify(botdetect.Signals{
+		Kind:         botdetect.KindClick,
+		IP:           evt.IPAddress,
+		UserAgent:    evt.UserAgent,
+		At:           evt.Timestamp,
+		DeliveredAt:  mailing.DeliveredAt(ctx, c.db, subscriberID, campaignID),
+		LinkURL:      evt.LinkURL,
+		RecentClicks: mailing.RecentClicks(ctx, c.db, subscriberID, campaignID, evt.Timestamp),
+	})
 
-	_, err := c.db.ExecContext(ctx, `
-		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, link_url, sending_domain)
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent, device_type, link_url, sending_domain, traffic_class, bot_reasons)
 		SELECT $1, $2, $3, $4, 'clicked', $5, $6, $7, $8, $9,
-			LOWER(SPLIT_PART(c.from_email, '@', 2))
+			LOWER(SPLIT_PART(c.from_email, '@', 2)), $10, $11
 		FROM mailing_campaigns c WHERE c.id = $3
-	`, uuid.New(), orgID, campaignID, subscriberID, evt.Timestamp, evt.IPAddress, evt.UserAgent, detectDevice(evt.UserAgent), evt.LinkURL)
+	`, uuid.New(), orgID, campaignID, subscriberID, evt.Timestamp, evt.IPAddress, evt.UserAgent, detectDevice(evt.UserAgent), evt.LinkURL,
+		string(verdict.Class), pq.Array(verdict.ReasonStrings()))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := mailing.RecordABEngagement(ctx, tx, campaignID, subscriberID, mailing.ABEventClick, string(verdict.Class)); err != nil {
+		return nil, fmt.Errorf("A/B click event: %w", err)
+	}
+
+	// Scanner clicks are recorded for reporting but are not the subscriber's
+	// engagement.
+	if verdict.Class == botdetect.ClassScannerClick {
+		log.Printf("PROCESSED SCANNER CLICK: campaign=%s subscriber=%s reasons=%v", campaignID, subscriberID, verdict.Reasons)
+		return nil, nil
 	}
 
-	c.db.ExecContext(ctx, `UPDATE mailing_campaigns SET click_count = click_count + 1 WHERE id = $1`, campaignID)
-	c.db.ExecContext(ctx, `UPDATE mailing_subscribers SET total_clicks = total_clicks + 1, last_click_at = NOW(), updated_at = NOW() WHERE id = $1`, subscriberID)
-	c.db.ExecContext(ctx, `UPDATE mailing_inbox_profiles SET total_clicks = total_clicks + 1, last_click_at = NOW(), updated_at = NOW() WHERE email = $1`, email)
-	c.updateEngagementScore(ctx, subscriberID)
+	if err := execAll(ctx, tx,
+		stmt{`UPDATE mailing_campaigns SET click_count = click_count + 1 WHERE id = $1`, []interface{}{campaignID}},
+		stmt{`UPDATE mailing_subscribers SET total_clicks = total_clicks + 1, last_click_at = NOW(), updated_at = NOW() WHERE id = $1`, []interface{}{subscriberID}},
+		stmt{`UPDATE mailing_inbox_profiles SET total_clicks = total_clicks + 1, last_click_at = NOW(), updated_at = NOW() WHERE email = $1`, []interface{}{email}},
+	); err != nil {
+		return nil, err
+	}
+	if err := c.updateEngagementScore(ctx, tx, subscriberID); err != nil {
+		return nil, err
+	}
 
 	log.Printf("PROCESSED CLICK: campaign=%s subscriber=%s url=%s", campaignID, subscriberID, evt.LinkURL)
-	return nil
+	return &segmentation.Change{
+		OrganizationID: orgID,
+		SubscriberID:   subscriberID,
+		Fields:         []string{"total_clicks", "last_click_at", "engagement_score"},
+		Events:         []string{"clicked"},
+	}, nil
 }
 
-func (c *Consumer) processUnsubscribe(ctx context.Context, evt TrackingEvent) error {
+func (c *Consumer) processUnsubscribe(ctx context.Context, tx *sql.Tx, evt TrackingEvent) (*segmentation.Change, error) {
 	orgID, _ := uuid.Parse(evt.OrgID)
 	campaignID, _ := uuid.Parse(evt.CampaignID)
 	subscriberID, _ := uuid.Parse(evt.SubscriberID)
 
 	var email string
-	c.db.QueryRowContext(ctx, `SELECT email FROM mailing_subscribers WHERE id = $1`, subscriberID).Scan(&email)
+	tx.QueryRowContext(ctx, `SELECT email FROM mailing_subscribers WHERE id = $1`, subscriberID).Scan(&email)
 
-	c.db.ExecContext(ctx, `
+	if err := execAll(ctx, tx,
+		stmt{`
 		INSERT INTO mailing_tracking_events (id, organization_id, campaign_id, subscriber_id, event_type, event_at, ip_address, user_agent)
 		VALUES ($1, $2, $3, $4, 'unsubscribed', $5, $6, $7)
-	`, uuid.New(), orgID, campaignID, subscriberID, evt.Timestamp, evt.IPAddress, evt.UserAgent)
-
-	c.db.ExecContext(ctx, `UPDATE mailing_subscribers SET status = 'unsubscribed', updated_at = NOW() WHERE id = $1`, subscriberID)
-	c.db.ExecContext(ctx, `UPDATE mailing_campaigns SET unsubscribe_count = COALESCE(unsubscribe_count, 0) + 1 WHERE id = $1`, campaignID)
-	c.db.ExecContext(ctx, `
+	`, []interface{}{uuid.New(), orgID, campaignID, subscriberID, evt.Timestamp, evt.IPAddress, evt.UserAgent}},
+		stmt{`UPDATE mailing_subscribers SET status = 'unsubscribed', updated_at = NOW() WHERE id = $1`, []interface{}{subscriberID}},
+		stmt{`UPDATE mailing_campaigns SET unsubscribe_count = COALESCE(unsubscribe_count, 0) + 1 WHERE id = $1`, []interface{}{campaignID}},
+		stmt{`
 		INSERT INTO mailing_suppressions (id, email, reason, source, active, created_at, updated_at)
 		VALUES ($1, $2, 'User unsubscribed', 'unsubscribe', true, NOW(), NOW())
 		ON CONFLICT (email) DO UPDATE SET active = true, reason = 'User unsubscribed', updated_at = NOW()
-	`, uuid.New(), email)
+	`, []interface{}{uuid.New(), email}},
+	); err != nil {
+		return nil, err
+	}
 
 	log.Printf("PROCESSED UNSUB: campaign=%s subscriber=%s email=%s", campaignID, subscriberID, email)
+	return &segmentation.Change{
+		OrganizationID: orgID,
+		SubscriberID:   subscriberID,
+		Fields:         []string{"status"},
+		Events:         []string{"unsubscribed"},
+		Suppressed:     true,
+	}, nil
+}
+
+// stmt is one statement of an event's transaction
+type stmt struct {
+	query string
+	args  []interface{}
+}
+
+// execAll runs statements in order and stops at the first failure, which
+// aborts the transaction anyway.
+func execAll(ctx context.Context, tx *sql.Tx, stmts ...stmt) error {
+	for _, s := range stmts {
+		if _, err := tx.ExecContext(ctx, s.query, s.args...); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *Consumer) updateEngagementScore(ctx context.Context, subscriberID uuid.UUID) {
+func (c *Consumer) applySegments(ctx context.Context, change segmentation.Change) {
+	if c.membership == nil {
+		return
+	}
+	if _, err := c.membership.Apply(ctx, change); err != nil {
+		log.Printf("segment membership update failed (subscriber=%s): %v", change.SubscriberID, err)
+	}
+}
+
+func (c *Consumer) updateEngagementScore(ctx context.Context, tx *sql.Tx, subscriberID uuid.UUID) error {
 	var totalOpens, totalClicks, totalEmails int
 	var lastOpenAt *time.Time
-	c.db.QueryRowContext(ctx, `
+	err := tx.QueryRowContext(ctx, `
 		SELECT COALESCE(total_opens,0), COALESCE(total_clicks,0), COALESCE(total_emails_received,1), last_open_at
 		FROM mailing_subscribers WHERE id = $1
 	`, subscriberID).Scan(&totalOpens, &totalClicks, &totalEmails, &lastOpenAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
 	if totalEmails < 1 {
 		totalEmails = 1
@@ -219,7 +367,8 @@ func (c *Consumer) updateEngagementScore(ctx context.Context, subscriberID uuid.
 		score = 100
 	}
 
-	c.db.ExecContext(ctx, `UPDATE mailing_subscribers SET engagement_score = $2, updated_at = NOW() WHERE id = $1`, subscriberID, score)
+	_, err = tx.ExecContext(ctx, `UPDATE mailing_subscribers SET engagement_score = $2, updated_at = NOW() WHERE id = $1`, subscriberID, score)
+	return err
 }
 
 func detectDevice(ua string) string {
@@ -0,0 +1,54 @@
+package tracking
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerProcessesEventIDOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	c := NewConsumer(&fakeBus{}, db)
+	ctx := context.Background()
+
+	id := uuid.New()
+	// An unknown event type keeps processing itself out of the expectations.
+	evt := TrackingEvent{EventID: id.String(), EventType: "forwarded"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_tracking_event_receipts").
+		WithArgs(id, "forwarded").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, c.handle(ctx, evt))
+
+	// Redelivered after it was processed: skipped and acknowledged.
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_tracking_event_receipts").
+		WithArgs(id, "forwarded").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	require.NoError(t, c.handle(ctx, evt))
+
+	// A failure rolls the receipt back with the event's changes, so the
+	// event is redelivered.
+	open := TrackingEvent{EventID: uuid.NewString(), EventType: EventOpen, SubscriberID: uuid.NewString()}
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mailing_tracking_event_receipts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT email FROM mailing_subscribers").WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("a@example.com"))
+	mock.ExpectQuery("FROM mailing_tracking_events").WillReturnRows(sqlmock.NewRows([]string{"event_at"}))
+	mock.ExpectExec("INSERT INTO mailing_tracking_events").WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	assert.ErrorIs(t, c.handle(ctx, open), assert.AnError)
+
+	// Events without an ID predate deduplication and are processed as is.
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	require.NoError(t, c.handle(ctx, TrackingEvent{EventType: "forwarded"}))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
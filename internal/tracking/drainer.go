@@ -0,0 +1,129 @@
+package tracking
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Drainer forwards events from a local spool to a remote bus. A spooled
+// event is acknowledged only after the remote publish succeeded; failures
+// back off exponentially and the event is retried.
+type Drainer struct {
+	src        Bus
+	dst        Bus
+	batch      int
+	parallel   int // concurrent publishes when dst is not a BatchPublisher
+	timeout    time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func NewDrainer(src, dst Bus) *Drainer {
+	return &Drainer{
+		src:        src,
+		dst:        dst,
+		batch:      50,
+		parallel:   8,
+		timeout:    5 * time.Second,
+		minBackoff: time.Second,
+		maxBackoff: time.Minute,
+	}
+}
+
+// SetBackoff sets the first and the largest retry delay after a failure.
+func (d *Drainer) SetBackoff(first, limit time.Duration) {
+	d.minBackoff, d.maxBackoff = first, limit
+}
+
+// Run drains until ctx is cancelled.
+func (d *Drainer) Run(ctx context.Context) {
+	backoff := d.minBackoff
+	failing := false
+	for ctx.Err() == nil {
+		n, err := d.DrainOnce(ctx)
+		if err == nil {
+			if failing {
+				log.Printf("[TrackingDrainer] remote bus recovered")
+				failing = false
+			}
+			backoff = d.minBackoff
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[TrackingDrainer] forwarded %d, retrying in %s: %v", n, backoff, err)
+		failing = true
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, d.maxBackoff)
+	}
+}
+
+// DrainOnce forwards one batch and returns how many events were forwarded.
+// The batch goes out in one PublishBatch call when dst supports it and as
+// concurrent publishes otherwise. Forwarded events are acknowledged; the
+// rest are released for redelivery and the first failure is returned.
+func (d *Drainer) DrainOnce(ctx context.Context) (int, error) {
+	msgs, err := d.src.Receive(ctx, d.batch)
+	if err != nil {
+		return 0, err
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	errs := d.publish(pubCtx, msgs)
+	cancel()
+
+	forwarded := 0
+	var firstErr error
+	for i, m := range msgs {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			if r, ok := d.src.(interface{ Release(Message) }); ok {
+				r.Release(m)
+			}
+			continue
+		}
+		forwarded++
+		if err := d.src.Ack(ctx, m); err != nil {
+			// The event will be forwarded again; consumers deduplicate.
+			log.Printf("[TrackingDrainer] ack %s: %v", m.Event.EventID, err)
+		}
+	}
+	return forwarded, firstErr
+}
+
+// publish sends msgs to dst and returns one error per message.
+func (d *Drainer) publish(ctx context.Context, msgs []Message) []error {
+	evts := make([]TrackingEvent, len(msgs))
+	for i, m := range msgs {
+		evts[i] = m.Event
+	}
+	if bp, ok := d.dst.(BatchPublisher); ok {
+		return bp.PublishBatch(ctx, evts)
+	}
+
+	errs := make([]error, len(evts))
+	sem := make(chan struct{}, max(1, d.parallel))
+	var wg sync.WaitGroup
+	for i := range evts {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = d.dst.Publish(ctx, evts[i])
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
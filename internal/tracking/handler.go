@@ -10,6 +10,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/ignite/sparkpost-monitor/internal/buildinfo"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/botdetect"
 )
 
 // 1x1 transparent GIF
@@ -21,18 +22,32 @@ var pixelGIF = []byte{
 }
 
 type Handler struct {
-	pub *Publisher
+	pub   *Publisher
+	prefs *PreferenceCenter
 }
 
 func NewHandler(pub *Publisher) *Handler {
 	return &Handler{pub: pub}
 }
 
+// SetPreferenceCenter enables the /preferences and /unsubscribe token
+// routes. Without it only the legacy signed-data unsubscribe link works.
+func (h *Handler) SetPreferenceCenter(pc *PreferenceCenter) {
+	h.prefs = pc
+}
+
 func (h *Handler) Routes() chi.Router {
 	r := chi.NewRouter()
 	r.Get("/track/open/{data}/{sig}", h.HandleOpen)
 	r.Get("/track/click/{data}/{sig}", h.HandleClick)
 	r.Get("/track/unsubscribe/{data}/{sig}", h.HandleUnsubscribe)
+	r.Post("/track/unsubscribe/{data}/{sig}", h.HandleUnsubscribe)
+	if h.prefs != nil {
+		r.Get("/preferences/{token}", h.prefs.HandlePage)
+		r.Post("/preferences/{token}", h.prefs.HandleSave)
+		r.Get("/unsubscribe/{token}", h.prefs.HandlePage)
+		r.Post("/unsubscribe/{token}", h.prefs.HandleUnsubscribePost)
+	}
 	r.Get("/health", h.HandleHealth)
 	r.Get("/version", h.HandleVersion)
 	return r
@@ -100,6 +115,10 @@ func (h *Handler) HandleClick(w http.ResponseWriter, r *http.Request) {
 	h.pub.Publish(r.Context(), evt)
 
 	log.Printf("CLICK campaign=%s subscriber=%s url=%s", evt.CampaignID, evt.SubscriberID, originalURL)
+	if botdetect.IsHoneypot(originalURL) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	http.Redirect(w, r, originalURL, http.StatusTemporaryRedirect)
 }
 
@@ -134,6 +153,12 @@ func (h *Handler) HandleUnsubscribe(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("UNSUB campaign=%s subscriber=%s", evt.CampaignID, evt.SubscriberID)
 
+	// RFC 8058 one-click requests from mailbox providers expect no page.
+	if r.Method == http.MethodPost && isOneClick(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte(`<!DOCTYPE html><html><body style="font-family:Arial,sans-serif;text-align:center;padding:50px;">
 		<h1>You have been unsubscribed</h1>
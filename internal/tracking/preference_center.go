@@ -0,0 +1,288 @@
+package tracking
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// frequencyOptions are the opt-down choices offered on the page, as
+// "cap:days" form values.
+var frequencyOptions = []struct {
+	Value string
+	Label string
+}{
+	{"0:0", "Every email"},
+	{"3:7", "Up to 3 emails a week"},
+	{"1:7", "At most one email a week"},
+	{"1:30", "At most one email a month"},
+}
+
+var pauseOptions = []int{7, 30, 90}
+
+// PreferenceCenter serves the hosted preference page behind unsubscribe
+// links and the RFC 8058 one-click unsubscribe endpoint.
+type PreferenceCenter struct {
+	tokens *Tokens
+	store  *PreferenceStore
+	pub    *Publisher
+}
+
+func NewPreferenceCenter(db *sql.DB, secret string, pub *Publisher) *PreferenceCenter {
+	return &PreferenceCenter{
+		tokens: NewTokens(db, secret),
+		store:  NewPreferenceStore(db),
+		pub:    pub,
+	}
+}
+
+type preferencePage struct {
+	Token       string
+	Prefs       *Preferences
+	Frequency   string
+	Frequencies interface{}
+	Pauses      []int
+	Expired     bool
+	Message     string
+}
+
+// HandlePage renders the preference center. It never changes anything, so
+// link scanners following the unsubscribe URL cannot unsubscribe anyone.
+func (pc *PreferenceCenter) HandlePage(w http.ResponseWriter, r *http.Request) {
+	ti, ok := pc.resolve(w, r)
+	if !ok {
+		return
+	}
+	prefs, err := pc.store.Load(r.Context(), ti)
+	if err != nil {
+		log.Printf("ERROR loading preferences: %v", err)
+		http.Error(w, "temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+	pc.render(w, http.StatusOK, ti, prefs, "")
+}
+
+// HandleSave applies the submitted preference form.
+func (pc *PreferenceCenter) HandleSave(w http.ResponseWriter, r *http.Request) {
+	ti, ok := pc.resolve(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("action") == "unsubscribe_all" {
+		pc.unsubscribeAll(w, r, ti, "preference_center")
+		return
+	}
+	if ti.Expired() {
+		pc.render(w, http.StatusGone, ti, &Preferences{Email: ti.Email}, "This link has expired. You can still unsubscribe from all email.")
+		return
+	}
+
+	ctx := r.Context()
+	prefs, err := pc.store.Load(ctx, ti)
+	if err != nil {
+		log.Printf("ERROR loading preferences: %v", err)
+		http.Error(w, "temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+	ch := parsePreferenceForm(r, prefs)
+	n, err := pc.store.Apply(ctx, ti, prefs, ch, pc.audit(r, "preference_center"))
+	if err != nil {
+		log.Printf("ERROR saving preferences: %v", err)
+		http.Error(w, "temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+	if n > 0 {
+		pc.tokens.MarkUsed(ctx, ti)
+	}
+	log.Printf("PREFS campaign=%s subscriber=%s changes=%d", ti.CampaignID, ti.SubscriberID, n)
+
+	if prefs, err = pc.store.Load(ctx, ti); err != nil {
+		log.Printf("ERROR loading preferences: %v", err)
+		http.Error(w, "temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+	pc.render(w, http.StatusOK, ti, prefs, "Your preferences have been saved.")
+}
+
+// HandleUnsubscribePost handles the List-Unsubscribe URL. A mailbox
+// provider's RFC 8058 one-click request (body List-Unsubscribe=One-Click)
+// unsubscribes and gets an empty 200; a form submitted from the page gets
+// the confirmation page.
+func (pc *PreferenceCenter) HandleUnsubscribePost(w http.ResponseWriter, r *http.Request) {
+	ti, ok := pc.resolve(w, r)
+	if !ok {
+		return
+	}
+	if isOneClick(r) {
+		if err := pc.apply(r, ti, "one_click"); err != nil {
+			http.Error(w, "temporarily unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	pc.unsubscribeAll(w, r, ti, "preference_center")
+}
+
+func (pc *PreferenceCenter) unsubscribeAll(w http.ResponseWriter, r *http.Request, ti *TokenInfo, source string) {
+	if err := pc.apply(r, ti, source); err != nil {
+		http.Error(w, "temporarily unavailable", http.StatusInternalServerError)
+		return
+	}
+	pc.render(w, http.StatusOK, ti, &Preferences{Email: ti.Email, Unsubscribed: true}, "You have been unsubscribed. You will no longer receive emails from us.")
+}
+
+// apply unsubscribes the token's address from everything and publishes the
+// unsubscribe event so the consumer suppresses it and counts it against
+// the campaign.
+func (pc *PreferenceCenter) apply(r *http.Request, ti *TokenInfo, source string) error {
+	ctx := r.Context()
+	prefs, err := pc.store.Load(ctx, ti)
+	if err != nil {
+		log.Printf("ERROR loading preferences: %v", err)
+		return err
+	}
+	if _, err := pc.store.Apply(ctx, ti, prefs, PreferenceChange{UnsubscribeAll: true}, pc.audit(r, source)); err != nil {
+		log.Printf("ERROR unsubscribing: %v", err)
+		return err
+	}
+	pc.tokens.MarkUsed(ctx, ti)
+
+	pc.pub.Publish(ctx, TrackingEvent{
+		EventType:    EventUnsubscribe,
+		OrgID:        ti.OrganizationID.String(),
+		CampaignID:   ti.CampaignID.String(),
+		SubscriberID: ti.SubscriberID.String(),
+		IPAddress:    realIP(r),
+		UserAgent:    r.UserAgent(),
+		Timestamp:    time.Now().UTC(),
+	})
+	log.Printf("UNSUB campaign=%s subscriber=%s source=%s", ti.CampaignID, ti.SubscriberID, source)
+	return nil
+}
+
+func (pc *PreferenceCenter) resolve(w http.ResponseWriter, r *http.Request) (*TokenInfo, bool) {
+	ti, err := pc.tokens.Resolve(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, ErrInvalidToken) {
+		http.Error(w, "this link is not valid", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR resolving unsubscribe token: %v", err)
+		http.Error(w, "temporarily unavailable", http.StatusInternalServerError)
+		return nil, false
+	}
+	return ti, true
+}
+
+func (pc *PreferenceCenter) audit(r *http.Request, source string) ConsentAudit {
+	return ConsentAudit{Source: source, IP: realIP(r), UserAgent: r.UserAgent()}
+}
+
+func (pc *PreferenceCenter) render(w http.ResponseWriter, status int, ti *TokenInfo, prefs *Preferences, msg string) {
+	page := preferencePage{
+		Token:       ti.Token,
+		Prefs:       prefs,
+		Frequency:   fmt.Sprintf("%d:%d", prefs.FrequencyCap, prefs.FrequencyDays),
+		Frequencies: frequencyOptions,
+		Pauses:      pauseOptions,
+		Expired:     ti.Expired(),
+		Message:     msg,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := preferenceTemplate.Execute(w, page); err != nil {
+		log.Printf("ERROR rendering preference center: %v", err)
+	}
+}
+
+// isOneClick reports whether the request is an RFC 8058 one-click
+// unsubscribe. The body may be form-urlencoded or multipart.
+func isOneClick(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(1 << 16); err != nil {
+			return false
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return false
+	}
+	return r.PostForm.Get("List-Unsubscribe") == "One-Click"
+}
+
+// parsePreferenceForm reads the submitted form into a change. Checkboxes
+// are absent when unticked, so every list and topic on the page is listed.
+func parsePreferenceForm(r *http.Request, prefs *Preferences) PreferenceChange {
+	ch := PreferenceChange{
+		Lists:  make(map[uuid.UUID]bool, len(prefs.Lists)),
+		Topics: make(map[uuid.UUID]bool, len(prefs.Topics)),
+	}
+	for _, l := range prefs.Lists {
+		ch.Lists[l.ID] = r.PostForm.Get("list_"+l.ID.String()) == "on"
+	}
+	for _, t := range prefs.Topics {
+		ch.Topics[t.ID] = r.PostForm.Get("topic_"+t.ID.String()) == "on"
+	}
+
+	ch.FrequencyCap, ch.FrequencyDays = prefs.FrequencyCap, prefs.FrequencyDays
+	for _, opt := range frequencyOptions {
+		if opt.Value == r.PostForm.Get("frequency") {
+			capStr, daysStr, _ := strings.Cut(opt.Value, ":")
+			ch.FrequencyCap, _ = strconv.Atoi(capStr)
+			ch.FrequencyDays, _ = strconv.Atoi(daysStr)
+		}
+	}
+	if days, err := strconv.Atoi(r.PostForm.Get("pause")); err == nil {
+		for _, d := range pauseOptions {
+			if d == days {
+				ch.PauseDays = days
+			}
+		}
+	}
+	return ch
+}
+
+var preferenceTemplate = template.Must(template.New("preferences").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1">
+<meta name="robots" content="noindex"><title>Email preferences</title></head>
+<body style="font-family:Arial,sans-serif;max-width:560px;margin:0 auto;padding:40px 20px;color:#222;">
+<h1 style="font-size:24px;">Email preferences</h1>
+<p>{{.Prefs.Email}}</p>
+{{if .Message}}<p style="background:#eef6ee;padding:12px;border-radius:4px;">{{.Message}}</p>{{end}}
+{{if .Prefs.Unsubscribed}}
+<p>You are unsubscribed from all email.</p>
+{{else}}
+{{if not .Expired}}
+<form method="post" action="/preferences/{{.Token}}">
+{{if .Prefs.Lists}}<h2 style="font-size:18px;">Lists</h2>
+{{range .Prefs.Lists}}<label style="display:block;margin:6px 0;"><input type="checkbox" name="list_{{.ID}}"{{if .Subscribed}} checked{{end}}{{if .Locked}} disabled{{end}}> {{.Name}}</label>
+{{end}}{{end}}
+{{if .Prefs.Topics}}<h2 style="font-size:18px;">Topics</h2>
+{{range .Prefs.Topics}}<label style="display:block;margin:6px 0;"><input type="checkbox" name="topic_{{.ID}}"{{if .Subscribed}} checked{{end}}> {{.Name}}{{if .Description}} <span style="color:#666;">— {{.Description}}</span>{{end}}</label>
+{{end}}{{end}}
+<h2 style="font-size:18px;">How often</h2>
+<select name="frequency">{{$cur := .Frequency}}{{range .Frequencies}}<option value="{{.Value}}"{{if eq .Value $cur}} selected{{end}}>{{.Label}}</option>{{end}}</select>
+<h2 style="font-size:18px;">Take a break</h2>
+{{if .Prefs.PausedUntil}}<p>Email is paused until {{.Prefs.PausedUntil.Format "January 2, 2006"}}.</p>{{end}}
+<select name="pause"><option value="0">Don't pause</option>{{range .Pauses}}<option value="{{.}}">Pause for {{.}} days</option>{{end}}</select>
+<p><button type="submit" name="action" value="save">Save preferences</button></p>
+</form>
+{{end}}
+<form method="post" action="/unsubscribe/{{.Token}}">
+<p><button type="submit">Unsubscribe from all email</button></p>
+</form>
+{{end}}
+</body></html>`))
@@ -0,0 +1,230 @@
+package tracking
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type prefFixture struct {
+	db       *sql.DB
+	mock     sqlmock.Sqlmock
+	bus      *fakeBus
+	handler  http.Handler
+	tokens   *Tokens
+	org      uuid.UUID
+	campaign uuid.UUID
+	sub      uuid.UUID
+	list     uuid.UUID
+	token    string
+}
+
+func newPrefFixture(t *testing.T) *prefFixture {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	f := &prefFixture{
+		db: db, mock: mock, bus: &fakeBus{},
+		org: uuid.New(), campaign: uuid.New(), sub: uuid.New(), list: uuid.New(),
+	}
+	pub := NewPublisher(f.bus)
+	h := NewHandler(pub)
+	pc := NewPreferenceCenter(db, "secret", pub)
+	h.SetPreferenceCenter(pc)
+	f.handler = h.Routes()
+	f.tokens = pc.tokens
+	f.token = f.tokens.Token(f.campaign, f.sub)
+	return f
+}
+
+// expectResolve issues f.token to expire at expires and expects it to be
+// resolved.
+func (f *prefFixture) expectResolve(expires time.Time) {
+	f.token = f.tokens.issue(f.campaign, f.sub, expires.Add(-f.tokens.ttl))
+	f.mock.ExpectQuery("FROM mailing_subscribers WHERE id").WithArgs(f.sub).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id", "email", "list_id"}).
+			AddRow(f.org, "Jane@Example.com", f.list))
+}
+
+func (f *prefFixture) expectLoad(topics ...uuid.UUID) {
+	f.mock.ExpectQuery("FROM mailing_subscriber_preferences").WillReturnError(sql.ErrNoRows)
+	f.mock.ExpectQuery("FROM mailing_subscribers s").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "status"}).AddRow(f.list, "Newsletter", "confirmed"))
+	rows := sqlmock.NewRows([]string{"id", "key", "name", "description"})
+	for _, id := range topics {
+		rows.AddRow(id, "deals", "Deals", "")
+	}
+	f.mock.ExpectQuery("FROM mailing_topics").WithArgs(f.org).WillReturnRows(rows)
+}
+
+func TestTokensResolveChecksSignature(t *testing.T) {
+	f := newPrefFixture(t)
+	ctx := context.Background()
+
+	f.expectResolve(time.Now().Add(time.Hour))
+	ti, err := f.tokens.Resolve(ctx, f.token)
+	require.NoError(t, err)
+	assert.Equal(t, f.org, ti.OrganizationID)
+	assert.Equal(t, f.campaign, ti.CampaignID)
+	assert.Equal(t, f.sub, ti.SubscriberID)
+	assert.Equal(t, "Jane@Example.com", ti.Email)
+	assert.False(t, ti.Expired())
+
+	f.expectResolve(time.Now().Add(-time.Hour))
+	ti, err = f.tokens.Resolve(ctx, f.token)
+	require.NoError(t, err)
+	assert.True(t, ti.Expired())
+
+	// Altered, foreign and malformed tokens never reach the database.
+	altered := []byte(f.tokens.Token(f.campaign, f.sub))
+	altered[5] ^= 1
+	for _, token := range []string{
+		string(altered),
+		NewTokens(f.db, "other-secret").Token(f.campaign, f.sub),
+		"u2short",
+		"x9" + f.token[2:],
+	} {
+		_, err = f.tokens.Resolve(ctx, token)
+		assert.ErrorIs(t, err, ErrInvalidToken, token)
+	}
+
+	// Legacy tokens are looked up and their stored row must match the signature.
+	legacy := f.tokens.legacyToken(f.campaign, f.sub)
+	legacyCols := []string{"campaign_id", "subscriber_id", "organization_id", "email", "list_id", "expires_at"}
+	f.mock.ExpectQuery("FROM mailing_unsubscribe_tokens t").WithArgs(legacy).
+		WillReturnRows(sqlmock.NewRows(legacyCols).AddRow(f.campaign, f.sub, f.org, "jane@example.com", nil, time.Now().Add(time.Hour)))
+	ti, err = f.tokens.Resolve(ctx, legacy)
+	require.NoError(t, err)
+	assert.Equal(t, f.sub, ti.SubscriberID)
+	f.mock.ExpectQuery("FROM mailing_unsubscribe_tokens t").WithArgs(legacy).
+		WillReturnRows(sqlmock.NewRows(legacyCols).AddRow(uuid.New(), f.sub, f.org, "jane@example.com", nil, time.Now()))
+	_, err = f.tokens.Resolve(ctx, legacy)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = f.tokens.Resolve(ctx, "u1short")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestUnsubscribeGetOnlyRendersPage(t *testing.T) {
+	f := newPrefFixture(t)
+	f.expectResolve(time.Now().Add(time.Hour))
+	f.expectLoad()
+
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unsubscribe/"+f.token, nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Newsletter")
+	assert.Contains(t, rec.Body.String(), `action="/unsubscribe/`+f.token+`"`)
+	assert.Empty(t, f.bus.published)
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	f := newPrefFixture(t)
+	f.expectResolve(time.Now().Add(-time.Hour)) // expired tokens still unsubscribe
+	f.expectLoad()
+	hash := emailHash("jane@example.com")
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec("INSERT INTO mailing_subscriber_preferences").
+		WithArgs(f.org, hash, "Jane@Example.com").WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec("SET unsubscribed_at = NOW").WithArgs(f.org, hash).WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec("UPDATE mailing_subscribers SET status = 'unsubscribed'").
+		WithArgs(f.org, hash).WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec("INSERT INTO mailing_consent_records").
+		WithArgs(sqlmock.AnyArg(), f.org, f.sub, "Jane@Example.com", hash, "withdrawn", sqlmock.AnyArg(),
+			"one_click", "203.0.113.9", "Mozilla/5.0", nil, sqlmock.AnyArg(), "global", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectCommit()
+	f.mock.ExpectExec("INSERT INTO mailing_unsubscribe_tokens").WithArgs(f.token, f.org, f.campaign, f.sub,
+		"Jane@Example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/unsubscribe/"+f.token, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String(), "one-click requests get no page")
+	require.Len(t, f.bus.published, 1)
+	evt := f.bus.published[0]
+	assert.Equal(t, EventUnsubscribe, evt.EventType)
+	assert.Equal(t, f.campaign.String(), evt.CampaignID)
+	assert.Equal(t, f.sub.String(), evt.SubscriberID)
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestSavePreferencesRecordsEachChange(t *testing.T) {
+	f := newPrefFixture(t)
+	topic := uuid.New()
+	f.expectResolve(time.Now().Add(time.Hour))
+	f.expectLoad(topic)
+	hash := emailHash("jane@example.com")
+
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec("INSERT INTO mailing_subscriber_preferences").WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec("UPDATE mailing_subscribers").
+		WithArgs(f.org, hash, "unsubscribed", f.list).WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec("UPDATE mailing_subscriber_preferences").
+		WithArgs(f.org, hash, 1, 7, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, scope := range []string{"list:" + f.list.String(), "topic:deals", "frequency", "pause"} {
+		f.mock.ExpectExec("INSERT INTO mailing_consent_records").
+			WithArgs(sqlmock.AnyArg(), f.org, f.sub, "Jane@Example.com", hash, sqlmock.AnyArg(), sqlmock.AnyArg(),
+				"preference_center", nil, "", sqlmock.AnyArg(), sqlmock.AnyArg(), scope, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	f.mock.ExpectCommit()
+	f.mock.ExpectExec("INSERT INTO mailing_unsubscribe_tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	f.expectLoad(topic)
+
+	// The list and topic checkboxes are unticked, so both are absent.
+	form := "action=save&frequency=1:7&pause=30"
+	req := httptest.NewRequest(http.MethodPost, "/preferences/"+f.token, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "")
+	req.RemoteAddr = "not-an-ip"
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Your preferences have been saved.")
+	assert.Empty(t, f.bus.published, "opting down is not a global unsubscribe")
+	assert.NoError(t, f.mock.ExpectationsWereMet())
+}
+
+func TestLegacyUnsubscribeOneClickPost(t *testing.T) {
+	bus := &fakeBus{}
+	h := NewHandler(NewPublisher(bus)).Routes()
+	data := base64.URLEncoding.EncodeToString([]byte("org|camp|sub|email"))
+
+	req := httptest.NewRequest(http.MethodPost, "/track/unsubscribe/"+data+"/sig", strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	require.Len(t, bus.published, 1)
+	assert.Equal(t, EventUnsubscribe, bus.published[0].EventType)
+
+	// Token routes are not mounted without a preference center.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/preferences/u1abc", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
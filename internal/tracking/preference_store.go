@@ -0,0 +1,308 @@
+package tracking
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ListChoice is a list the subscriber is on.
+type ListChoice struct {
+	ID         uuid.UUID
+	Name       string
+	Subscribed bool
+	// Locked lists (bounced, complained) cannot be resubscribed from the
+	// preference center.
+	Locked bool
+}
+
+// TopicChoice is a content topic campaigns can be tagged with.
+type TopicChoice struct {
+	ID          uuid.UUID
+	Key         string
+	Name        string
+	Description string
+	Subscribed  bool
+}
+
+// Preferences is a subscriber's current mail preferences across the
+// organization.
+type Preferences struct {
+	Email  string
+	Lists  []ListChoice
+	Topics []TopicChoice
+	// FrequencyCap limits mail to FrequencyCap messages per FrequencyDays;
+	// zero means no cap.
+	FrequencyCap  int
+	FrequencyDays int
+	PausedUntil   *time.Time
+	Unsubscribed  bool
+}
+
+// PreferenceChange is the state the subscriber asked for. Lists and topics
+// not present are left as they are.
+type PreferenceChange struct {
+	Lists          map[uuid.UUID]bool
+	Topics         map[uuid.UUID]bool
+	FrequencyCap   int
+	FrequencyDays  int
+	PauseDays      int
+	UnsubscribeAll bool
+}
+
+// ConsentAudit describes where a preference change came from.
+type ConsentAudit struct {
+	Source    string // preference_center, one_click
+	IP        string
+	UserAgent string
+}
+
+// PreferenceStore reads and writes subscriber preferences. Every change is
+// recorded in mailing_consent_records.
+type PreferenceStore struct {
+	db *sql.DB
+}
+
+func NewPreferenceStore(db *sql.DB) *PreferenceStore {
+	return &PreferenceStore{db: db}
+}
+
+func emailHash(email string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(h[:])
+}
+
+// Load returns the preferences of the subscriber behind a token.
+func (ps *PreferenceStore) Load(ctx context.Context, ti *TokenInfo) (*Preferences, error) {
+	p := &Preferences{Email: ti.Email}
+	hash := emailHash(ti.Email)
+
+	var optedOut []string
+	var paused, unsubscribed sql.NullTime
+	err := ps.db.QueryRowContext(ctx, `
+		SELECT frequency_cap, frequency_days, paused_until, opted_out_topics::text[], unsubscribed_at
+		FROM mailing_subscriber_preferences
+		WHERE organization_id = $1 AND email_hash = $2
+	`, ti.OrganizationID, hash).Scan(&p.FrequencyCap, &p.FrequencyDays, &paused, pq.Array(&optedOut), &unsubscribed)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("load preferences: %w", err)
+	}
+	if paused.Valid && paused.Time.After(time.Now()) {
+		p.PausedUntil = &paused.Time
+	}
+	p.Unsubscribed = unsubscribed.Valid
+
+	rows, err := ps.db.QueryContext(ctx, `
+		SELECT l.id, l.name, s.status
+		FROM mailing_subscribers s
+		JOIN mailing_lists l ON l.id = s.list_id
+		WHERE s.organization_id = $1 AND s.email_hash = $2
+		ORDER BY l.name
+	`, ti.OrganizationID, hash)
+	if err != nil {
+		return nil, fmt.Errorf("load lists: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l ListChoice
+		var status string
+		if err := rows.Scan(&l.ID, &l.Name, &status); err != nil {
+			return nil, fmt.Errorf("load lists: %w", err)
+		}
+		l.Subscribed = status == "confirmed" || status == "pending"
+		l.Locked = status == "bounced" || status == "complained" || status == "blacklisted"
+		p.Lists = append(p.Lists, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load lists: %w", err)
+	}
+
+	out := make(map[string]bool, len(optedOut))
+	for _, id := range optedOut {
+		out[id] = true
+	}
+	trows, err := ps.db.QueryContext(ctx, `
+		SELECT id, key, name, COALESCE(description, '')
+		FROM mailing_topics WHERE organization_id = $1
+		ORDER BY name
+	`, ti.OrganizationID)
+	if err != nil {
+		return nil, fmt.Errorf("load topics: %w", err)
+	}
+	defer trows.Close()
+	for trows.Next() {
+		var t TopicChoice
+		if err := trows.Scan(&t.ID, &t.Key, &t.Name, &t.Description); err != nil {
+			return nil, fmt.Errorf("load topics: %w", err)
+		}
+		t.Subscribed = !out[t.ID.String()]
+		p.Topics = append(p.Topics, t)
+	}
+	return p, trows.Err()
+}
+
+// consentEntry is one row for mailing_consent_records.
+type consentEntry struct {
+	status    string // granted, withdrawn
+	scope     string
+	text      string
+	details   map[string]interface{}
+	expiresAt *time.Time
+}
+
+// Apply moves the subscriber from cur to the requested state in one
+// transaction and returns the consent entries it recorded.
+func (ps *PreferenceStore) Apply(ctx context.Context, ti *TokenInfo, cur *Preferences, ch PreferenceChange, audit ConsentAudit) (int, error) {
+	hash := emailHash(ti.Email)
+	var entries []consentEntry
+
+	tx, err := ps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO mailing_subscriber_preferences (organization_id, email_hash, email)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, email_hash) DO NOTHING
+	`, ti.OrganizationID, hash, ti.Email); err != nil {
+		return 0, fmt.Errorf("apply preferences: %w", err)
+	}
+
+	if ch.UnsubscribeAll {
+		if !cur.Unsubscribed {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE mailing_subscriber_preferences SET unsubscribed_at = NOW(), updated_at = NOW()
+				WHERE organization_id = $1 AND email_hash = $2
+			`, ti.OrganizationID, hash); err != nil {
+				return 0, fmt.Errorf("apply preferences: %w", err)
+			}
+			entries = append(entries, consentEntry{status: "withdrawn", scope: "global", text: "Unsubscribed from all email"})
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE mailing_subscribers SET status = 'unsubscribed', unsubscribed_at = NOW(), updated_at = NOW()
+			WHERE organization_id = $1 AND email_hash = $2 AND status IN ('confirmed', 'pending')
+		`, ti.OrganizationID, hash); err != nil {
+			return 0, fmt.Errorf("apply preferences: %w", err)
+		}
+	} else {
+		for _, l := range cur.Lists {
+			want, ok := ch.Lists[l.ID]
+			if !ok || want == l.Subscribed || l.Locked || (want && cur.Unsubscribed) {
+				continue
+			}
+			status, newStatus, verb := "withdrawn", "unsubscribed", "Unsubscribed from"
+			if want {
+				status, newStatus, verb = "granted", "confirmed", "Resubscribed to"
+			}
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE mailing_subscribers
+				SET status = $3, unsubscribed_at = CASE WHEN $3 = 'unsubscribed' THEN NOW() END, updated_at = NOW()
+				WHERE organization_id = $1 AND email_hash = $2 AND list_id = $4
+			`, ti.OrganizationID, hash, newStatus, l.ID); err != nil {
+				return 0, fmt.Errorf("apply preferences: %w", err)
+			}
+			entries = append(entries, consentEntry{
+				status: status, scope: "list:" + l.ID.String(),
+				text: fmt.Sprintf("%s list %s", verb, l.Name), details: map[string]interface{}{"list_name": l.Name},
+			})
+		}
+
+		optedOut := []string{}
+		for _, t := range cur.Topics {
+			subscribed := t.Subscribed
+			if want, ok := ch.Topics[t.ID]; ok && want != t.Subscribed {
+				subscribed = want
+				status, verb := "withdrawn", "Opted out of"
+				if want {
+					status, verb = "granted", "Opted in to"
+				}
+				entries = append(entries, consentEntry{
+					status: status, scope: "topic:" + t.Key,
+					text: fmt.Sprintf("%s topic %s", verb, t.Name), details: map[string]interface{}{"topic_id": t.ID},
+				})
+			}
+			if !subscribed {
+				optedOut = append(optedOut, t.ID.String())
+			}
+		}
+
+		if ch.FrequencyCap != cur.FrequencyCap || (ch.FrequencyCap > 0 && ch.FrequencyDays != cur.FrequencyDays) {
+			text := "Removed email frequency cap"
+			if ch.FrequencyCap > 0 {
+				text = fmt.Sprintf("Limited email to %d per %d days", ch.FrequencyCap, ch.FrequencyDays)
+			}
+			entries = append(entries, consentEntry{
+				status: "granted", scope: "frequency", text: text,
+				details: map[string]interface{}{"max_emails": ch.FrequencyCap, "days": ch.FrequencyDays},
+			})
+		}
+
+		var pausedUntil *time.Time
+		if cur.PausedUntil != nil {
+			pausedUntil = cur.PausedUntil
+		}
+		if ch.PauseDays > 0 {
+			until := time.Now().Add(time.Duration(ch.PauseDays) * 24 * time.Hour).UTC()
+			pausedUntil = &until
+			entries = append(entries, consentEntry{
+				status: "withdrawn", scope: "pause", text: fmt.Sprintf("Paused email for %d days", ch.PauseDays),
+				details: map[string]interface{}{"days": ch.PauseDays}, expiresAt: &until,
+			})
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE mailing_subscriber_preferences
+			SET frequency_cap = $3, frequency_days = $4, paused_until = $5, opted_out_topics = $6::uuid[], updated_at = NOW()
+			WHERE organization_id = $1 AND email_hash = $2
+		`, ti.OrganizationID, hash, ch.FrequencyCap, ch.FrequencyDays, pausedUntil, pq.Array(optedOut)); err != nil {
+			return 0, fmt.Errorf("apply preferences: %w", err)
+		}
+	}
+
+	ip := clientIP(audit.IP)
+	for _, e := range entries {
+		details, _ := json.Marshal(e.details)
+		if e.details == nil {
+			details = []byte("{}")
+		}
+		withdrawnAt := sql.NullTime{Time: time.Now(), Valid: e.status == "withdrawn"}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO mailing_consent_records (
+				id, organization_id, subscriber_id, email, email_hash, consent_type,
+				status, legal_basis, consent_text, source, ip_address, user_agent,
+				consented_at, expires_at, withdrawn_at, scope, details
+			) VALUES ($1, $2, $3, $4, $5, 'marketing_email', $6, 'consent', $7, $8, $9, $10, NOW(), $11, $12, $13, $14)
+		`, uuid.New(), ti.OrganizationID, ti.SubscriberID, ti.Email, hash,
+			e.status, e.text, audit.Source, ip, audit.UserAgent, e.expiresAt, withdrawnAt, e.scope, details); err != nil {
+			return 0, fmt.Errorf("record consent: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// clientIP returns the address as stored in an INET column, or nil when it
+// is not an IP address.
+func clientIP(addr string) interface{} {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+		return ip.String()
+	}
+	return nil
+}
@@ -2,12 +2,10 @@ package tracking
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/google/uuid"
 )
 
 type EventType string
@@ -19,6 +17,8 @@ const (
 )
 
 type TrackingEvent struct {
+	// EventID identifies the hit; consumers process each ID at most once.
+	EventID      string    `json:"event_id,omitempty"`
 	EventType    EventType `json:"event_type"`
 	OrgID        string    `json:"org_id"`
 	CampaignID   string    `json:"campaign_id"`
@@ -30,32 +30,44 @@ type TrackingEvent struct {
 	Timestamp    time.Time `json:"timestamp"`
 }
 
+// Publisher hands tracking events to a bus, normally the local Spool that a
+// Drainer forwards to SQS or Redis.
 type Publisher struct {
-	client   *sqs.Client
-	queueURL string
+	bus      Bus
+	fallback Bus
 }
 
-func NewPublisher(client *sqs.Client, queueURL string) *Publisher {
-	return &Publisher{client: client, queueURL: queueURL}
+func NewPublisher(bus Bus) *Publisher {
+	return &Publisher{bus: bus}
 }
 
+// SetFallback sets a bus to publish to directly when the primary bus
+// rejects an event, for example because the spool disk is full.
+func (p *Publisher) SetFallback(b Bus) {
+	p.fallback = b
+}
+
+// Publish assigns the event an ID and enqueues it. Failures are logged; the
+// tracking response never waits on the remote bus.
 func (p *Publisher) Publish(ctx context.Context, evt TrackingEvent) {
-	body, err := json.Marshal(evt)
-	if err != nil {
-		log.Printf("ERROR marshal tracking event: %v", err)
+	if evt.EventID == "" {
+		evt.EventID = uuid.NewString()
+	}
+	err := p.bus.Publish(ctx, evt)
+	if err == nil {
+		return
+	}
+	if p.fallback == nil {
+		log.Printf("ERROR publishing tracking event %s: %v", evt.EventID, err)
 		return
 	}
 
+	log.Printf("WARN spooling tracking event %s failed, publishing directly: %v", evt.EventID, err)
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-
-		_, err := p.client.SendMessage(ctx, &sqs.SendMessageInput{
-			QueueUrl:    aws.String(p.queueURL),
-			MessageBody: aws.String(string(body)),
-		})
-		if err != nil {
-			log.Printf("ERROR publishing to SQS: %v", err)
+		if err := p.fallback.Publish(ctx, evt); err != nil {
+			log.Printf("ERROR publishing tracking event %s: %v", evt.EventID, err)
 		}
 	}()
 }
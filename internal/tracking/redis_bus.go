@@ -0,0 +1,140 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamBus is a Bus backed by a Redis Stream and consumer group.
+// Messages left unacknowledged by a crashed consumer are reclaimed by
+// another consumer once they have been pending for minIdle.
+type RedisStreamBus struct {
+	rdb      *redis.Client
+	stream   string
+	group    string
+	consumer string
+	maxLen   int64
+	block    time.Duration
+	minIdle  time.Duration
+
+	groupReady atomic.Bool
+}
+
+func NewRedisStreamBus(rdb *redis.Client, stream, group, consumer string) *RedisStreamBus {
+	return &RedisStreamBus{
+		rdb:      rdb,
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+		maxLen:   1_000_000,
+		block:    5 * time.Second,
+		minIdle:  2 * time.Minute,
+	}
+}
+
+// SetMaxLen caps the stream length. Trimming is approximate and only drops
+// the oldest entries, so keep it well above the expected consumer backlog.
+func (b *RedisStreamBus) SetMaxLen(n int64) { b.maxLen = n }
+
+// SetReclaimAfter sets how long a message may stay pending with a consumer
+// before another consumer takes it over.
+func (b *RedisStreamBus) SetReclaimAfter(d time.Duration) { b.minIdle = d }
+
+func (b *RedisStreamBus) Publish(ctx context.Context, evt TrackingEvent) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("marshal tracking event: %w", err)
+	}
+	return b.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.stream,
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"event": body},
+	}).Err()
+}
+
+// Receive first reclaims messages abandoned by other consumers, then reads
+// new ones, blocking for up to the block interval.
+func (b *RedisStreamBus) Receive(ctx context.Context, max int) ([]Message, error) {
+	if max <= 0 {
+		max = 10
+	}
+	if err := b.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	claimed, _, err := b.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   b.stream,
+		Group:    b.group,
+		Consumer: b.consumer,
+		MinIdle:  b.minIdle,
+		Start:    "0-0",
+		Count:    int64(max),
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("xautoclaim: %w", err)
+	}
+	if len(claimed) > 0 {
+		return b.decode(ctx, claimed), nil
+	}
+
+	streams, err := b.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    b.group,
+		Consumer: b.consumer,
+		Streams:  []string{b.stream, ">"},
+		Count:    int64(max),
+		Block:    b.block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("xreadgroup: %w", err)
+	}
+	var msgs []Message
+	for _, s := range streams {
+		msgs = append(msgs, b.decode(ctx, s.Messages)...)
+	}
+	return msgs, nil
+}
+
+func (b *RedisStreamBus) Ack(ctx context.Context, msg Message) error {
+	return b.rdb.XAck(ctx, b.stream, b.group, msg.receipt).Err()
+}
+
+func (b *RedisStreamBus) ensureGroup(ctx context.Context) error {
+	if b.groupReady.Load() {
+		return nil
+	}
+	err := b.rdb.XGroupCreateMkStream(ctx, b.stream, b.group, "0").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("create consumer group %s: %w", b.group, err)
+	}
+	b.groupReady.Store(true)
+	return nil
+}
+
+// decode turns stream entries into messages. Entries that cannot be decoded
+// are acknowledged and skipped.
+func (b *RedisStreamBus) decode(ctx context.Context, entries []redis.XMessage) []Message {
+	msgs := make([]Message, 0, len(entries))
+	for _, e := range entries {
+		raw, _ := e.Values["event"].(string)
+		var evt TrackingEvent
+		if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+			log.Printf("[TrackingBus] redis bad message %s: %v", e.ID, err)
+			b.Ack(ctx, Message{receipt: e.ID})
+			continue
+		}
+		msgs = append(msgs, Message{Event: evt, receipt: e.ID})
+	}
+	return msgs
+}
@@ -0,0 +1,50 @@
+package tracking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStreamBus(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	pub := NewRedisStreamBus(rdb, "tracking:events", "consumers", "publisher")
+	require.NoError(t, pub.Publish(ctx, TrackingEvent{EventID: "e1", EventType: EventOpen}))
+	require.NoError(t, pub.Publish(ctx, TrackingEvent{EventID: "e2", EventType: EventClick}))
+
+	a := NewRedisStreamBus(rdb, "tracking:events", "consumers", "a")
+	a.block = 10 * time.Millisecond
+	msgs, err := a.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "e1", msgs[0].Event.EventID)
+	require.NoError(t, a.Ack(ctx, msgs[0]))
+
+	// Consumer a dies holding e2; b reclaims it once it has been idle.
+	b := NewRedisStreamBus(rdb, "tracking:events", "consumers", "b")
+	b.block = 10 * time.Millisecond
+	b.SetReclaimAfter(time.Hour)
+	msgs, err = b.Receive(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, msgs, "pending message is not reclaimed before it is idle")
+
+	b.SetReclaimAfter(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	msgs, err = b.Receive(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "e2", msgs[0].Event.EventID)
+	require.NoError(t, b.Ack(ctx, msgs[0]))
+
+	msgs, err = b.Receive(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+}
@@ -0,0 +1,622 @@
+package tracking
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSpoolFull is returned by Spool.Publish when the spool has reached its
+// size limit.
+var ErrSpoolFull = errors.New("tracking spool is full")
+
+// spoolPos addresses a record: segment sequence number and byte offset.
+type spoolPos struct {
+	seg uint64
+	off int64
+}
+
+func (p spoolPos) before(q spoolPos) bool {
+	return p.seg < q.seg || (p.seg == q.seg && p.off < q.off)
+}
+
+// spoolRange is the position of a record and of the record after it.
+type spoolRange struct {
+	start, next spoolPos
+}
+
+const (
+	spoolHeaderSize = 8 // 4-byte length + 4-byte CRC32 of the payload
+	spoolMaxRecord  = 1 << 20
+	spoolCursorFile = "cursor"
+	spoolSegmentExt = ".wal"
+)
+
+// Spool is a Bus backed by a write-ahead log on local disk. Publish returns
+// once its record is fsynced, so an accepted event survives a crash or
+// restart. Appends are serialized but fsyncs are not: one fsync commits
+// every record appended before it, so concurrent publishes share it (group
+// commit). The log is split into segments; a segment is deleted once every
+// record in it has been acknowledged.
+//
+// Receive leases the records it returns for redeliverAfter; a record that is
+// neither acknowledged nor released in that time is returned again. The
+// acknowledged position is persisted at most once a second and on segment
+// boundaries, so after a crash a few records may be delivered twice.
+type Spool struct {
+	dir            string
+	segmentBytes   int64
+	maxBytes       int64
+	redeliverAfter time.Duration
+	poll           time.Duration
+
+	// syncMu serializes fsyncs and is taken before mu.
+	syncMu sync.Mutex
+	synced spoolPos // end of the durable log; guarded by syncMu
+
+	mu            sync.Mutex
+	active        *os.File
+	activeSeq     uint64
+	activeSize    int64
+	retired       []*os.File // rotated segments awaiting their last fsync
+	sizes         map[uint64]int64
+	cursor        spoolPos
+	acked         map[spoolPos]spoolPos
+	leased        map[spoolPos]time.Time
+	cursorSavedAt time.Time
+	closed        bool
+
+	wake chan struct{}
+}
+
+// OpenSpool opens or creates a spool in dir, truncating a partially written
+// record left at the end of the log by a crash.
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	s := &Spool{
+		dir:            dir,
+		segmentBytes:   16 << 20,
+		maxBytes:       1 << 30,
+		redeliverAfter: 30 * time.Second,
+		poll:           time.Second,
+		sizes:          make(map[uint64]int64),
+		acked:          make(map[spoolPos]spoolPos),
+		leased:         make(map[spoolPos]time.Time),
+		wake:           make(chan struct{}, 1),
+	}
+
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	s.cursor, err = s.loadCursor()
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) > 0 && s.cursor.seg < segs[0] {
+		s.cursor = spoolPos{seg: segs[0]}
+	}
+
+	s.activeSeq = s.cursor.seg
+	if len(segs) > 0 && segs[len(segs)-1] > s.activeSeq {
+		s.activeSeq = segs[len(segs)-1]
+	}
+	if s.activeSeq == 0 {
+		s.activeSeq = 1
+		s.cursor = spoolPos{seg: 1}
+	}
+	for _, seq := range segs {
+		fi, err := os.Stat(s.segmentPath(seq))
+		if err != nil {
+			return nil, err
+		}
+		s.sizes[seq] = fi.Size()
+	}
+
+	if err := s.recoverActive(); err != nil {
+		return nil, err
+	}
+	if size := s.sizes[s.cursor.seg]; s.cursor.off > size {
+		s.cursor.off = size
+	}
+	s.synced = spoolPos{seg: s.activeSeq, off: s.activeSize}
+	return s, nil
+}
+
+// SetLimits sets the size at which a new segment is started and the total
+// size at which Publish starts returning ErrSpoolFull. Zero keeps the current
+// value.
+func (s *Spool) SetLimits(segmentBytes, maxBytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if segmentBytes > 0 {
+		s.segmentBytes = segmentBytes
+	}
+	if maxBytes > 0 {
+		s.maxBytes = maxBytes
+	}
+}
+
+// SetRedeliverAfter sets how long a received record stays leased.
+func (s *Spool) SetRedeliverAfter(d time.Duration) {
+	s.mu.Lock()
+	s.redeliverAfter = d
+	s.mu.Unlock()
+}
+
+// Publish appends evt and waits for an fsync covering it. If the fsync
+// fails the event may still be delivered later; consumers deduplicate.
+func (s *Spool) Publish(ctx context.Context, evt TrackingEvent) error {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("marshal tracking event: %w", err)
+	}
+	if len(payload) > spoolMaxRecord {
+		return fmt.Errorf("tracking event of %d bytes exceeds spool record limit", len(payload))
+	}
+	rec := make([]byte, spoolHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(payload))
+	copy(rec[spoolHeaderSize:], payload)
+
+	end, err := s.append(rec)
+	if err != nil {
+		return err
+	}
+	if err := s.syncThrough(end); err != nil {
+		return err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// append writes rec to the active segment and returns the log position
+// after it.
+func (s *Spool) append(rec []byte) (spoolPos, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return spoolPos{}, errors.New("tracking spool is closed")
+	}
+	if s.maxBytes > 0 && s.totalBytes()+int64(len(rec)) > s.maxBytes {
+		return spoolPos{}, ErrSpoolFull
+	}
+	if s.activeSize > 0 && s.activeSize+int64(len(rec)) > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return spoolPos{}, err
+		}
+	}
+
+	if _, err := s.active.Write(rec); err != nil {
+		s.active.Truncate(s.activeSize)
+		return spoolPos{}, fmt.Errorf("append to spool: %w", err)
+	}
+	s.activeSize += int64(len(rec))
+	s.sizes[s.activeSeq] = s.activeSize
+	return spoolPos{seg: s.activeSeq, off: s.activeSize}, nil
+}
+
+// syncThrough returns once the log is durable up to end. The caller that
+// finds it not yet durable fsyncs every record appended so far, including
+// those of publishers queued behind it, which then return without syncing.
+func (s *Spool) syncThrough(end spoolPos) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	if !s.synced.before(end) {
+		return nil
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("tracking spool is closed")
+	}
+	files := append(s.retired, s.active)
+	s.retired = nil
+	target := spoolPos{seg: s.activeSeq, off: s.activeSize}
+	s.mu.Unlock()
+
+	for i, f := range files {
+		if err := f.Sync(); err != nil {
+			s.mu.Lock()
+			s.retired = append(files[i:len(files)-1:len(files)-1], s.retired...)
+			s.mu.Unlock()
+			return fmt.Errorf("sync spool: %w", err)
+		}
+		if i < len(files)-1 {
+			f.Close()
+		}
+	}
+	s.synced = target
+	return nil
+}
+
+// Receive returns up to max unleased records in log order, waiting up to a
+// second for a Publish when there are none.
+func (s *Spool) Receive(ctx context.Context, max int) ([]Message, error) {
+	if max <= 0 {
+		max = 10
+	}
+	msgs, err := s.read(max)
+	if err != nil || len(msgs) > 0 {
+		return msgs, err
+	}
+
+	timer := time.NewTimer(s.poll)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case <-s.wake:
+	case <-timer.C:
+	}
+	return s.read(max)
+}
+
+// Ack marks a record as processed. The persisted position only advances
+// past records that are acknowledged, so acks may arrive out of order.
+func (s *Spool) Ack(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ackLocked(msg.spool)
+}
+
+// Release returns a received record to the spool for immediate redelivery.
+func (s *Spool) Release(msg Message) {
+	s.mu.Lock()
+	delete(s.leased, msg.spool.start)
+	s.mu.Unlock()
+}
+
+// Close persists the acknowledged position, syncs and closes the segments.
+func (s *Spool) Close() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.saveCursor()
+	for _, f := range append(s.retired, s.active) {
+		if serr := f.Sync(); err == nil {
+			err = serr
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.retired = nil
+	if err == nil {
+		s.synced = spoolPos{seg: s.activeSeq, off: s.activeSize}
+	}
+	return err
+}
+
+func (s *Spool) ackLocked(r spoolRange) error {
+	delete(s.leased, r.start)
+	if r.start.before(s.cursor) {
+		return nil
+	}
+	s.acked[r.start] = r.next
+	return s.advanceLocked()
+}
+
+// advanceLocked moves the cursor over acknowledged records and finished
+// segments, deleting the segments it leaves behind.
+func (s *Spool) advanceLocked() error {
+	prevSeg := s.cursor.seg
+	for {
+		if next, ok := s.acked[s.cursor]; ok {
+			delete(s.acked, s.cursor)
+			s.cursor = next
+			continue
+		}
+		// A fully read, inactive segment is finished.
+		if s.cursor.seg < s.activeSeq && s.cursor.off >= s.sizes[s.cursor.seg] {
+			s.cursor = spoolPos{seg: s.cursor.seg + 1}
+			continue
+		}
+		break
+	}
+
+	if s.cursor.seg != prevSeg {
+		if err := s.saveCursor(); err != nil {
+			return err
+		}
+		for seq := range s.sizes {
+			if seq < s.cursor.seg {
+				if err := os.Remove(s.segmentPath(seq)); err != nil && !os.IsNotExist(err) {
+					log.Printf("[TrackingSpool] remove segment %d: %v", seq, err)
+					continue
+				}
+				delete(s.sizes, seq)
+			}
+		}
+		return nil
+	}
+	if time.Since(s.cursorSavedAt) >= time.Second {
+		return s.saveCursor()
+	}
+	return nil
+}
+
+// read collects up to max unacknowledged, unleased records from the cursor
+// onwards and leases them. The segments are scanned without holding s.mu,
+// from a snapshot of the cursor and segment sizes, so publishers are only
+// held up while each record is checked and leased.
+func (s *Spool) read(max int) ([]Message, error) {
+	type segment struct {
+		seq   uint64
+		off   int64
+		limit int64
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("tracking spool is closed")
+	}
+	var segs []segment
+	for seq := s.cursor.seg; seq <= s.activeSeq; seq++ {
+		size, ok := s.sizes[seq]
+		if !ok {
+			continue
+		}
+		seg := segment{seq: seq, limit: size}
+		if seq == s.cursor.seg {
+			seg.off = s.cursor.off
+		}
+		segs = append(segs, seg)
+	}
+	activeSeq := s.activeSeq
+	redeliverAfter := s.redeliverAfter
+	s.mu.Unlock()
+
+	now := time.Now()
+	var msgs []Message
+	var undecodable []spoolRange
+	damaged := make(map[uint64]int64)
+	for _, seg := range segs {
+		if len(msgs) >= max {
+			break
+		}
+		end := seg.off
+		err := s.scan(seg.seq, seg.off, seg.limit, func(r spoolRange, payload []byte) bool {
+			end = r.next.off
+			var evt TrackingEvent
+			if err := json.Unmarshal(payload, &evt); err != nil {
+				log.Printf("[TrackingSpool] skipping undecodable record at %d:%d: %v", r.start.seg, r.start.off, err)
+				undecodable = append(undecodable, r)
+				return true
+			}
+
+			s.mu.Lock()
+			_, done := s.acked[r.start]
+			until, leased := s.leased[r.start]
+			take := !done && !r.start.before(s.cursor) && (!leased || !now.Before(until))
+			if take {
+				s.leased[r.start] = now.Add(redeliverAfter)
+			}
+			s.mu.Unlock()
+			if take {
+				msgs = append(msgs, Message{Event: evt, spool: r})
+			}
+			return len(msgs) < max
+		})
+		switch {
+		case err == nil:
+		case errors.Is(err, os.ErrNotExist):
+			// Acknowledged and removed since the snapshot
+		case seg.seq == activeSeq:
+			return msgs, err
+		default:
+			log.Printf("[TrackingSpool] segment %d damaged at offset %d, skipping %d bytes: %v",
+				seg.seq, end, seg.limit-end, err)
+			damaged[seg.seq] = end
+		}
+	}
+	if len(undecodable) == 0 && len(damaged) == 0 {
+		return msgs, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range undecodable {
+		s.ackLocked(r)
+	}
+	if len(damaged) > 0 {
+		// A damaged inactive segment cannot be written to again; end it at
+		// the damage so the cursor moves on to the next segment rather than
+		// block everything behind it.
+		for seq, end := range damaged {
+			if size, ok := s.sizes[seq]; ok && end < size {
+				s.sizes[seq] = end
+			}
+		}
+		if err := s.advanceLocked(); err != nil {
+			log.Printf("[TrackingSpool] advance past damaged segment: %v", err)
+		}
+	}
+	return msgs, nil
+}
+
+// scan reads the records of segment seq between off and limit, calling fn
+// for each until it returns false. A truncated or corrupt record ends the
+// scan with an error.
+func (s *Spool) scan(seq uint64, off, limit int64, fn func(spoolRange, []byte) bool) error {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReader(f)
+	hdr := make([]byte, spoolHeaderSize)
+	for off < limit {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			return fmt.Errorf("read header: %w", err)
+		}
+		n := binary.BigEndian.Uint32(hdr[0:4])
+		if n > spoolMaxRecord {
+			return fmt.Errorf("record length %d out of range", n)
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return fmt.Errorf("read record: %w", err)
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(hdr[4:8]) {
+			return errors.New("checksum mismatch")
+		}
+		next := off + spoolHeaderSize + int64(n)
+		r := spoolRange{start: spoolPos{seq, off}, next: spoolPos{seq, next}}
+		off = next
+		if !fn(r, payload) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// recoverActive opens the newest segment for appending, cutting off a torn
+// record left by a crash mid-write.
+func (s *Spool) recoverActive() error {
+	seq := s.activeSeq
+	valid := int64(0)
+	if _, ok := s.sizes[seq]; ok {
+		err := s.scan(seq, 0, s.sizes[seq], func(r spoolRange, _ []byte) bool {
+			valid = r.next.off
+			return true
+		})
+		if err != nil {
+			log.Printf("[TrackingSpool] truncating segment %d at %d: %v", seq, valid, err)
+		}
+	}
+
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("open spool segment: %w", err)
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return fmt.Errorf("truncate spool segment: %w", err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.active = f
+	s.activeSize = valid
+	s.sizes[seq] = valid
+	return nil
+}
+
+// rotate starts a new segment. The old one stays open until the next fsync
+// has made its tail durable.
+func (s *Spool) rotate() error {
+	f, err := os.OpenFile(s.segmentPath(s.activeSeq+1), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("create spool segment: %w", err)
+	}
+	s.retired = append(s.retired, s.active)
+	s.activeSeq++
+	s.active = f
+	s.activeSize = 0
+	s.sizes[s.activeSeq] = 0
+	return nil
+}
+
+func (s *Spool) totalBytes() int64 {
+	var n int64
+	for _, size := range s.sizes {
+		n += size
+	}
+	return n - s.cursor.off
+}
+
+func (s *Spool) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+	var segs []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, seq)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", seq, spoolSegmentExt))
+}
+
+func (s *Spool) loadCursor() (spoolPos, error) {
+	raw, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if os.IsNotExist(err) {
+		return spoolPos{}, nil
+	}
+	if err != nil {
+		return spoolPos{}, fmt.Errorf("read spool cursor: %w", err)
+	}
+	var p spoolPos
+	if _, err := fmt.Sscanf(string(raw), "%d %d", &p.seg, &p.off); err != nil {
+		log.Printf("[TrackingSpool] ignoring unreadable cursor %q: %v", raw, err)
+		return spoolPos{}, nil
+	}
+	return p, nil
+}
+
+// saveCursor writes the acknowledged position atomically.
+func (s *Spool) saveCursor() error {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("write spool cursor: %w", err)
+	}
+	_, err = fmt.Fprintf(f, "%d %d\n", s.cursor.seg, s.cursor.off)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write spool cursor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write spool cursor: %w", err)
+	}
+	s.cursorSavedAt = time.Now()
+	return nil
+}
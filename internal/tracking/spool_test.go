@@ -0,0 +1,305 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func spoolEvents(n int) []TrackingEvent {
+	evts := make([]TrackingEvent, n)
+	for i := range evts {
+		evts[i] = TrackingEvent{EventID: string(rune('a' + i)), EventType: EventOpen, CampaignID: "c1"}
+	}
+	return evts
+}
+
+func receiveIDs(t *testing.T, s *Spool, max int) ([]Message, []string) {
+	t.Helper()
+	msgs, err := s.Receive(context.Background(), max)
+	require.NoError(t, err)
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.Event.EventID
+	}
+	return msgs, ids
+}
+
+func TestSpoolPublishReceiveAck(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir)
+	require.NoError(t, err)
+	s.poll = 10 * time.Millisecond
+	ctx := context.Background()
+
+	for _, e := range spoolEvents(4) {
+		require.NoError(t, s.Publish(ctx, e))
+	}
+
+	msgs, ids := receiveIDs(t, s, 3)
+	assert.Equal(t, []string{"a", "b", "c"}, ids)
+	// Leased records are not handed out again.
+	_, ids = receiveIDs(t, s, 10)
+	assert.Equal(t, []string{"d"}, ids)
+
+	// Out-of-order ack: b is done, a is released for redelivery.
+	require.NoError(t, s.Ack(ctx, msgs[1]))
+	s.Release(msgs[0])
+	_, ids = receiveIDs(t, s, 10)
+	assert.Equal(t, []string{"a"}, ids)
+
+	require.NoError(t, s.Ack(ctx, msgs[0]))
+	assert.Equal(t, msgs[1].spool.next, s.cursor, "cursor moves past a and the already acked b")
+	require.NoError(t, s.Close())
+
+	// After a restart, c and d are delivered again; a and b are not.
+	s, err = OpenSpool(dir)
+	require.NoError(t, err)
+	defer s.Close()
+	_, ids = receiveIDs(t, s, 10)
+	assert.Equal(t, []string{"c", "d"}, ids)
+}
+
+func TestSpoolRedeliversExpiredLease(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	s.poll = 10 * time.Millisecond
+	s.SetRedeliverAfter(20 * time.Millisecond)
+
+	require.NoError(t, s.Publish(context.Background(), spoolEvents(1)[0]))
+	_, ids := receiveIDs(t, s, 10)
+	require.Equal(t, []string{"a"}, ids)
+	_, ids = receiveIDs(t, s, 10)
+	assert.Empty(t, ids)
+	time.Sleep(30 * time.Millisecond)
+	_, ids = receiveIDs(t, s, 10)
+	assert.Equal(t, []string{"a"}, ids)
+}
+
+func TestSpoolTruncatesTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir)
+	require.NoError(t, err)
+	for _, e := range spoolEvents(2) {
+		require.NoError(t, s.Publish(context.Background(), e))
+	}
+	require.NoError(t, s.Close())
+
+	// Simulate a crash half way through appending a third record.
+	f, err := os.OpenFile(s.segmentPath(1), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 40, 1, 2, 3, 4, '{', '"'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = OpenSpool(dir)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Publish(context.Background(), TrackingEvent{EventID: "z"}))
+	_, ids := receiveIDs(t, s, 10)
+	assert.Equal(t, []string{"a", "b", "z"}, ids)
+}
+
+func TestSpoolSkipsDamagedSegment(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir)
+	require.NoError(t, err)
+	defer s.Close()
+	s.SetLimits(100, 0)
+	for _, e := range spoolEvents(3) {
+		require.NoError(t, s.Publish(context.Background(), e))
+	}
+
+	// Corrupt the only record of the first, inactive segment.
+	f, err := os.OpenFile(s.segmentPath(1), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{'!'}, spoolHeaderSize+1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	msgs, ids := receiveIDs(t, s, 10)
+	assert.Equal(t, []string{"b", "c"}, ids)
+	assert.Equal(t, spoolPos{seg: 2}, s.cursor, "cursor moves past the damaged segment")
+	_, err = os.Stat(s.segmentPath(1))
+	assert.True(t, os.IsNotExist(err), "damaged segment is deleted")
+
+	for _, m := range msgs {
+		require.NoError(t, s.Ack(context.Background(), m))
+	}
+	assert.Equal(t, msgs[1].spool.next, s.cursor)
+}
+
+func TestSpoolGroupCommit(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	s.SetLimits(400, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.Publish(context.Background(), TrackingEvent{EventType: EventOpen, CampaignID: "c1"}))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, spoolPos{seg: s.activeSeq, off: s.activeSize}, s.synced, "every accepted record is synced")
+	assert.Empty(t, s.retired, "rotated segments are closed once synced")
+	msgs, _ := receiveIDs(t, s, 100)
+	assert.Len(t, msgs, 40)
+}
+
+func TestSpoolSegmentsAndLimit(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir)
+	require.NoError(t, err)
+	defer s.Close()
+	ctx := context.Background()
+	s.SetLimits(100, 1000)
+
+	for _, e := range spoolEvents(3) {
+		require.NoError(t, s.Publish(ctx, e))
+	}
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	assert.Len(t, segs, 3, "each record larger than half a segment gets its own")
+
+	for err == nil {
+		err = s.Publish(ctx, TrackingEvent{EventID: "x"})
+	}
+	assert.ErrorIs(t, err, ErrSpoolFull)
+
+	msgs, _ := receiveIDs(t, s, 2)
+	for _, m := range msgs {
+		require.NoError(t, s.Ack(ctx, m))
+	}
+	segs, _ = filepath.Glob(filepath.Join(dir, "*.wal"))
+	assert.Len(t, segs, len(s.sizes), "acknowledged segments are deleted")
+	assert.NotContains(t, segs, s.segmentPath(1))
+	assert.NoError(t, s.Publish(ctx, TrackingEvent{EventID: "y"}), "space is reclaimed")
+}
+
+// fakeBus records published events and fails while failing is set.
+type fakeBus struct {
+	mu        sync.Mutex
+	published []TrackingEvent
+	failing   bool
+}
+
+func (b *fakeBus) Publish(ctx context.Context, evt TrackingEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failing {
+		return errors.New("queue unavailable")
+	}
+	b.published = append(b.published, evt)
+	return nil
+}
+
+func (b *fakeBus) Receive(ctx context.Context, max int) ([]Message, error) { return nil, nil }
+func (b *fakeBus) Ack(ctx context.Context, msg Message) error              { return nil }
+
+func TestDrainerRetriesUntilRemoteRecovers(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	s.poll = 10 * time.Millisecond
+	ctx := context.Background()
+
+	remote := &fakeBus{failing: true}
+	pub := NewPublisher(s)
+	for i := 0; i < 3; i++ {
+		pub.Publish(ctx, TrackingEvent{EventType: EventClick})
+	}
+
+	d := NewDrainer(s, remote)
+	n, err := d.DrainOnce(ctx)
+	assert.Error(t, err)
+	assert.Zero(t, n)
+
+	remote.failing = false
+	n, err = d.DrainOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n, "released events are retried immediately")
+
+	seen := map[string]bool{}
+	for _, e := range remote.published {
+		assert.NotEmpty(t, e.EventID, "publisher assigns event IDs")
+		seen[e.EventID] = true
+	}
+	assert.Len(t, seen, 3)
+
+	n, err = d.DrainOnce(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "forwarded events are acknowledged")
+}
+
+// batchBus is a fakeBus that publishes in batches and rejects failIDs.
+type batchBus struct {
+	fakeBus
+	calls   int
+	failIDs map[string]bool
+}
+
+func (b *batchBus) PublishBatch(ctx context.Context, evts []TrackingEvent) []error {
+	b.calls++
+	errs := make([]error, len(evts))
+	for i, e := range evts {
+		if b.failIDs[e.EventID] {
+			errs[i] = errors.New("throttled")
+			continue
+		}
+		b.published = append(b.published, e)
+	}
+	return errs
+}
+
+func TestDrainerPublishesBatches(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	s.poll = 10 * time.Millisecond
+	ctx := context.Background()
+	for _, e := range spoolEvents(4) {
+		require.NoError(t, s.Publish(ctx, e))
+	}
+
+	remote := &batchBus{failIDs: map[string]bool{"b": true}}
+	d := NewDrainer(s, remote)
+	n, err := d.DrainOnce(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 3, n, "events other than the rejected one are forwarded")
+	assert.Equal(t, 1, remote.calls)
+
+	remote.failIDs = nil
+	n, err = d.DrainOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "only the rejected event is retried")
+	assert.Equal(t, spoolPos{seg: 1, off: s.activeSize}, s.cursor)
+}
+
+func TestPublisherFallsBackWhenSpoolRejects(t *testing.T) {
+	s, err := OpenSpool(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	remote := &fakeBus{}
+	pub := NewPublisher(s)
+	pub.SetFallback(remote)
+	pub.Publish(context.Background(), TrackingEvent{EventType: EventOpen})
+
+	assert.Eventually(t, func() bool {
+		remote.mu.Lock()
+		defer remote.mu.Unlock()
+		return len(remote.published) == 1
+	}, time.Second, 5*time.Millisecond)
+}
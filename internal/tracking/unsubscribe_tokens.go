@@ -0,0 +1,175 @@
+package tracking
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, unknown or
+	// carry a bad signature.
+	ErrInvalidToken = errors.New("invalid unsubscribe token")
+)
+
+// Token format versions. u2 tokens carry the campaign, subscriber and issue
+// time under an HMAC and need no stored row; u1 tokens were an HMAC only and
+// are looked up in mailing_unsubscribe_tokens.
+const (
+	tokenPrefix       = "u2"
+	legacyTokenPrefix = "u1"
+)
+
+// tokenPayloadLen is the campaign ID, subscriber ID and issue time.
+const tokenPayloadLen = 16 + 16 + 4
+
+// tokenMACLen is the truncated HMAC-SHA256 length.
+const tokenMACLen = 16
+
+// TokenInfo is what an unsubscribe token stands for.
+type TokenInfo struct {
+	Token          string
+	OrganizationID uuid.UUID
+	CampaignID     uuid.UUID
+	SubscriberID   uuid.UUID
+	Email          string
+	ListID         uuid.NullUUID
+	ExpiresAt      time.Time
+}
+
+// Expired reports whether the token may no longer be used to change
+// preferences. Unsubscribing is honoured regardless.
+func (ti *TokenInfo) Expired() bool {
+	return time.Now().After(ti.ExpiresAt)
+}
+
+// Tokens issues and verifies the signed tokens behind List-Unsubscribe and
+// preference-center links.
+//
+// A token is the campaign, subscriber and issue time signed with an HMAC, so
+// issuing one needs no database write and a token cannot be forged or
+// altered without the secret. mailing_unsubscribe_tokens only records
+// tokens that were used, and still resolves legacy u1 tokens.
+type Tokens struct {
+	db     *sql.DB
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewTokens(db *sql.DB, secret string) *Tokens {
+	return &Tokens{db: db, secret: []byte(secret), ttl: 90 * 24 * time.Hour}
+}
+
+// Token returns a new token for a campaign send to a subscriber.
+func (t *Tokens) Token(campaignID, subscriberID uuid.UUID) string {
+	return t.issue(campaignID, subscriberID, time.Now())
+}
+
+func (t *Tokens) issue(campaignID, subscriberID uuid.UUID, at time.Time) string {
+	buf := make([]byte, tokenPayloadLen, tokenPayloadLen+tokenMACLen)
+	copy(buf, campaignID[:])
+	copy(buf[16:], subscriberID[:])
+	binary.BigEndian.PutUint32(buf[32:], uint32(at.Unix()))
+	buf = append(buf, t.mac(buf)...)
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (t *Tokens) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, t.secret)
+	h.Write([]byte(tokenPrefix))
+	h.Write(payload)
+	return h.Sum(nil)[:tokenMACLen]
+}
+
+// legacyToken is the u1 token of a campaign and subscriber.
+func (t *Tokens) legacyToken(campaignID, subscriberID uuid.UUID) string {
+	h := hmac.New(sha256.New, t.secret)
+	h.Write([]byte(campaignID.String() + "|" + subscriberID.String()))
+	return legacyTokenPrefix + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Resolve verifies a token and loads the subscriber it was issued to.
+func (t *Tokens) Resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	if strings.HasPrefix(token, legacyTokenPrefix) {
+		return t.resolveLegacy(ctx, token)
+	}
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(token[len(tokenPrefix):])
+	if err != nil || len(buf) != tokenPayloadLen+tokenMACLen {
+		return nil, ErrInvalidToken
+	}
+	payload := buf[:tokenPayloadLen]
+	if !hmac.Equal(t.mac(payload), buf[tokenPayloadLen:]) {
+		return nil, ErrInvalidToken
+	}
+
+	ti := &TokenInfo{Token: token}
+	copy(ti.CampaignID[:], payload[:16])
+	copy(ti.SubscriberID[:], payload[16:32])
+	issued := time.Unix(int64(binary.BigEndian.Uint32(payload[32:])), 0)
+	ti.ExpiresAt = issued.Add(t.ttl)
+
+	err = t.db.QueryRowContext(ctx, `
+		SELECT organization_id, email, list_id FROM mailing_subscribers WHERE id = $1
+	`, ti.SubscriberID).Scan(&ti.OrganizationID, &ti.Email, &ti.ListID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolve unsubscribe token: %w", err)
+	}
+	return ti, nil
+}
+
+// resolveLegacy looks a u1 token up and checks its signature against the
+// stored campaign and subscriber.
+func (t *Tokens) resolveLegacy(ctx context.Context, token string) (*TokenInfo, error) {
+	if len(token) != len(legacyTokenPrefix)+43 {
+		return nil, ErrInvalidToken
+	}
+
+	ti := &TokenInfo{Token: token}
+	var orgID, subscriberID uuid.NullUUID
+	err := t.db.QueryRowContext(ctx, `
+		SELECT t.campaign_id, t.subscriber_id, COALESCE(t.organization_id, s.organization_id), t.email, t.list_id, t.expires_at
+		FROM mailing_unsubscribe_tokens t
+		LEFT JOIN mailing_subscribers s ON s.id = t.subscriber_id
+		WHERE t.token = $1
+	`, token).Scan(&ti.CampaignID, &subscriberID, &orgID, &ti.Email, &ti.ListID, &ti.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolve unsubscribe token: %w", err)
+	}
+	if !subscriberID.Valid || !orgID.Valid {
+		return nil, ErrInvalidToken
+	}
+	ti.SubscriberID = subscriberID.UUID
+	ti.OrganizationID = orgID.UUID
+
+	if !hmac.Equal([]byte(t.legacyToken(ti.CampaignID, ti.SubscriberID)), []byte(token)) {
+		return nil, ErrInvalidToken
+	}
+	return ti, nil
+}
+
+// MarkUsed records the first time a token changed preferences.
+func (t *Tokens) MarkUsed(ctx context.Context, ti *TokenInfo) {
+	t.db.ExecContext(ctx, `
+		INSERT INTO mailing_unsubscribe_tokens (token, organization_id, campaign_id, subscriber_id, email, list_id, expires_at, used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (token) DO UPDATE SET used_at = COALESCE(mailing_unsubscribe_tokens.used_at, NOW())
+	`, ti.Token, ti.OrganizationID, ti.CampaignID, ti.SubscriberID, ti.Email, ti.ListID, ti.ExpiresAt)
+}
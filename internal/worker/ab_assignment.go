@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
+)
+
+// sendTimeVariant is the A/B variant a queue item was assigned at send time
+// and the content it was sent with.
+type sendTimeVariant struct {
+	Name        string
+	Subject     string
+	HTMLContent string
+	TextContent string
+}
+
+// assignSendTimeVariant assigns the variant of a bandit A/B test to a
+// mailing_campaign_queue item the scheduler left unassigned, so the bandit
+// allocates each message from the sends and rewards recorded so far. The
+// variant's name and content are written to the queue row, and the
+// assignment to mailing_ab_assignments, before the send enters the ledger:
+// a redelivered item keeps its variant and idempotency key. It returns nil
+// when the campaign has no variant for the subscriber.
+func assignSendTimeVariant(ctx context.Context, db *sql.DB, selector *mailing.VariantSelector, itemID, campaignID, subscriberID uuid.UUID, email string) (*sendTimeVariant, error) {
+	vc, err := selector.SelectVariant(ctx, campaignID, email)
+	if err != nil || vc == nil {
+		return nil, err
+	}
+
+	var v sendTimeVariant
+	err = db.QueryRowContext(ctx, `
+		WITH v AS (
+			SELECT id, test_id, variant_name, subject, html_content, text_content
+			FROM mailing_ab_variants WHERE id = $2
+		), a AS (
+			INSERT INTO mailing_ab_assignments (test_id, variant_id, subscriber_id, status)
+			SELECT test_id, id, $3, 'queued' FROM v
+			ON CONFLICT (test_id, subscriber_id) DO NOTHING
+		)
+		UPDATE mailing_campaign_queue q
+		SET variant_name = v.variant_name,
+		    subject = COALESCE(NULLIF(v.subject, ''), q.subject),
+		    html_content = COALESCE(NULLIF(v.html_content, ''), q.html_content),
+		    plain_content = COALESCE(NULLIF(v.text_content, ''), q.plain_content)
+		FROM v
+		WHERE q.id = $1 AND q.variant_name IS NULL
+		RETURNING q.variant_name, q.subject, COALESCE(q.html_content, ''), COALESCE(q.plain_content, '')
+	`, itemID, vc.VariantID, subscriberID).Scan(&v.Name, &v.Subject, &v.HTMLContent, &v.TextContent)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssignSendTimeVariant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	selector := mailing.NewVariantSelector(db)
+
+	itemID, campaignID, subscriberID, variantID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectQuery("FROM mailing_ab_variants v").WithArgs(campaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "campaign_id", "variant_name", "type", "value", "split", "is_winner"}).
+			AddRow(variantID, campaignID, "B", "subject", "Subject B", 100, true))
+	mock.ExpectQuery("FROM mailing_ab_tests").WithArgs(campaignID).WillReturnError(sql.ErrNoRows)
+
+	// The variant and its content are written to the queue row and the
+	// assignment is recorded.
+	mock.ExpectQuery("(?s)INSERT INTO mailing_ab_assignments.*UPDATE mailing_campaign_queue q.*q.variant_name IS NULL").
+		WithArgs(itemID, variantID, subscriberID).
+		WillReturnRows(sqlmock.NewRows([]string{"variant_name", "subject", "html_content", "plain_content"}).
+			AddRow("B", "Subject B", "<p>B</p>", "B"))
+
+	v, err := assignSendTimeVariant(ctx, db, selector, itemID, campaignID, subscriberID, "a@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, sendTimeVariant{Name: "B", Subject: "Subject B", HTMLContent: "<p>B</p>", TextContent: "B"}, *v)
+
+	// Campaigns without a test are not touched; the variant list is cached.
+	other := uuid.New()
+	mock.ExpectQuery("FROM mailing_ab_variants v").WithArgs(other).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "campaign_id", "variant_name", "type", "value", "split", "is_winner"}))
+	v, err = assignSendTimeVariant(ctx, db, selector, itemID, other, subscriberID, "a@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	v, err = assignSendTimeVariant(ctx, db, selector, itemID, other, subscriberID, "b@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -328,23 +328,10 @@ func (m *AdvancedThrottleManager) CanSend(ctx context.Context, orgID, email stri
 
 // checkISPThrottle checks ISP-level throttle limits
 func (m *AdvancedThrottleManager) checkISPThrottle(ctx context.Context, orgID, isp string, config *AdvancedThrottleConfig) (bool, string, error) {
-	// Find ISP rule in config
-	var ispRule *ISPThrottle
-	for i := range config.ISPRules {
-		if config.ISPRules[i].ISP == isp {
-			ispRule = &config.ISPRules[i]
-			break
-		}
-	}
-
-	// Fall back to defaults if no custom rule
+	ispRule := config.ispRule(isp)
 	if ispRule == nil {
-		if defaultRule, ok := DefaultISPLimits[isp]; ok {
-			ispRule = &defaultRule
-		} else {
-			// No limits for this ISP
-			return true, "", nil
-		}
+		// No limits for this ISP
+		return true, "", nil
 	}
 
 	now := time.Now()
@@ -388,23 +375,7 @@ func (m *AdvancedThrottleManager) checkISPThrottle(ctx context.Context, orgID, i
 
 // checkDomainThrottle checks domain-level throttle limits
 func (m *AdvancedThrottleManager) checkDomainThrottle(ctx context.Context, orgID, domain string, config *AdvancedThrottleConfig) (bool, string, error) {
-	// Find domain rule in config
-	var domainRule *DomainThrottle
-	for i := range config.DomainRules {
-		if strings.EqualFold(config.DomainRules[i].Domain, domain) {
-			domainRule = &config.DomainRules[i]
-			break
-		}
-	}
-
-	// If no specific domain rule, use defaults based on ISP or general defaults
-	if domainRule == nil {
-		domainRule = &DomainThrottle{
-			Domain:      domain,
-			HourlyLimit: 5000,  // Default domain limit
-			DailyLimit:  50000, // Default domain limit
-		}
-	}
+	domainRule := config.domainRule(domain)
 
 	now := time.Now()
 	hourKey := fmt.Sprintf(keyDomainHourly, orgID, domain, now.Format("2006010215"))
@@ -442,6 +413,35 @@ func (m *AdvancedThrottleManager) checkDomainThrottle(ctx context.Context, orgID
 	return true, "", nil
 }
 
+// ispRule returns the configured limits for an ISP, falling back to
+// DefaultISPLimits, or nil if the ISP is not throttled.
+func (c *AdvancedThrottleConfig) ispRule(isp string) *ISPThrottle {
+	for i := range c.ISPRules {
+		if c.ISPRules[i].ISP == isp {
+			return &c.ISPRules[i]
+		}
+	}
+	if defaultRule, ok := DefaultISPLimits[isp]; ok {
+		return &defaultRule
+	}
+	return nil
+}
+
+// domainRule returns the configured limits for a domain, or the general
+// domain defaults.
+func (c *AdvancedThrottleConfig) domainRule(domain string) *DomainThrottle {
+	for i := range c.DomainRules {
+		if strings.EqualFold(c.DomainRules[i].Domain, domain) {
+			return &c.DomainRules[i]
+		}
+	}
+	return &DomainThrottle{
+		Domain:      domain,
+		HourlyLimit: 5000,  // Default domain limit
+		DailyLimit:  50000, // Default domain limit
+	}
+}
+
 // checkGlobalThrottle checks organization-level global throttle limits
 func (m *AdvancedThrottleManager) checkGlobalThrottle(ctx context.Context, orgID string, config *AdvancedThrottleConfig) (bool, string, error) {
 	if config.GlobalHourly == 0 && config.GlobalDaily == 0 {
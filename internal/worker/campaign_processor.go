@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
 	"github.com/ignite/sparkpost-monitor/internal/pkg/distlock"
 	"github.com/redis/go-redis/v9"
 )
@@ -20,7 +22,7 @@ import (
 // =============================================================================
 // Processes campaigns from the queue with:
 // - Multi-ESP distribution based on quotas
-// - Real-time throttle control
+// - Hierarchical send limits (see SendLimiter)
 // - Pause/resume support
 // - Progress tracking
 // - Failover handling
@@ -30,10 +32,17 @@ type CampaignProcessor struct {
 	db          *sql.DB
 	redis       *redis.Client
 	distributor *ESPDistributor
-	throttle    *ThrottleManager
-	rateLimiter *RateLimiter
 	sender      *ProfileBasedSender
 
+	// Hierarchical send limiter; gates every send
+	limiter *SendLimiter
+
+	// Exactly-once ledger; every send is recorded before submission
+	ledger *SendLedger
+
+	// Assigns bandit A/B variants at send time; nil leaves them unassigned
+	variants *mailing.VariantSelector
+
 	// Worker configuration
 	workerID   string
 	numWorkers int
@@ -75,17 +84,31 @@ func NewCampaignProcessor(db *sql.DB, redisClient *redis.Client, config Campaign
 		config.BatchSize = 50
 	}
 
-	return &CampaignProcessor{
+	p := &CampaignProcessor{
 		db:          db,
 		redis:       redisClient,
 		distributor: NewESPDistributor(redisClient),
-		throttle:    NewThrottleManager(redisClient),
-		rateLimiter: NewRateLimiter(redisClient),
 		sender:      NewProfileBasedSender(db),
+		ledger:      NewSendLedger(db),
 		workerID:    fmt.Sprintf("processor-%s", uuid.New().String()[:8]),
 		numWorkers:  config.NumWorkers,
 		batchSize:   config.BatchSize,
 	}
+	if redisClient != nil {
+		p.limiter = NewSendLimiter(redisClient, db)
+	}
+	return p
+}
+
+// SetSendLimiter replaces the processor's own send limiter with a shared one
+func (p *CampaignProcessor) SetSendLimiter(limiter *SendLimiter) {
+	p.limiter = limiter
+}
+
+// SetVariantSelector assigns the variants of bandit A/B tests, which the
+// scheduler leaves unassigned, when each item is sent.
+func (p *CampaignProcessor) SetVariantSelector(vs *mailing.VariantSelector) {
+	p.variants = vs
 }
 
 // Start begins the campaign processor workers
@@ -183,6 +206,8 @@ type ProcessorQueueItem struct {
 	ESPQuotas       []ESPQuota
 	SubstitutionData map[string]interface{}
 	Priority        int
+	VariantName     string
+	WaveID          string
 }
 
 // claimBatch claims a batch of queue items for processing
@@ -211,11 +236,12 @@ func (p *CampaignProcessor) claimBatch() ([]ProcessorQueueItem, error) {
 			)
 			RETURNING id, campaign_id, subscriber_id, subject, 
 					  COALESCE(html_content, ''), COALESCE(plain_content, ''),
-					  priority
+					  priority, variant_name, wave_id
 		)
 		SELECT c.id, c.campaign_id, c.subscriber_id, c.subject, 
 			   c.html_content, c.plain_content, c.priority,
-			   s.email, camp.sending_profile_id, COALESCE(camp.esp_quotas::text, '[]')
+			   s.email, camp.sending_profile_id, COALESCE(camp.esp_quotas::text, '[]'),
+			   COALESCE(c.variant_name, ''), COALESCE(c.wave_id::text, '')
 		FROM claimed c
 		JOIN mailing_subscribers s ON s.id = c.subscriber_id
 		JOIN mailing_campaigns camp ON camp.id = c.campaign_id
@@ -243,6 +269,8 @@ func (p *CampaignProcessor) claimBatch() ([]ProcessorQueueItem, error) {
 			&item.Email,
 			&profileID,
 			&espQuotasJSON,
+			&item.VariantName,
+			&item.WaveID,
 		)
 		if err != nil {
 			continue
@@ -285,16 +313,9 @@ func (p *CampaignProcessor) processItem(item ProcessorQueueItem) error {
 		return p.skipItem(ctx, item.ID, "campaign_not_active")
 	}
 
-	// Get current throttle rate
-	throttleConfig, err := p.throttle.GetThrottle(ctx, item.CampaignID.String())
-	if err != nil {
-		throttleConfig = &ThrottleConfig{Rate: ThrottleGentle, RatePerMinute: 100}
-	}
-
-	// Apply throttling
-	if err := p.applyThrottle(ctx, item.CampaignID.String(), throttleConfig.RatePerMinute); err != nil {
-		// Return to queue on throttle error
-		return p.returnToQueue(ctx, item.ID)
+	// Preference center: global opt-out, pause, topic opt-out, frequency cap
+	if reason := preferenceSkipReason(ctx, p.db, item.CampaignID, item.Email); reason != "" {
+		return p.skipItem(ctx, item.ID, reason)
 	}
 
 	// Select ESP based on quotas or use default profile
@@ -304,17 +325,25 @@ func (p *CampaignProcessor) processItem(item ProcessorQueueItem) error {
 		return p.markFailed(ctx, item.ID, item.CampaignID, err.Error())
 	}
 
-	// Check ESP-level rate limits
-	espType := p.getESPType(ctx, profileID)
-	allowed, waitTime, err := p.rateLimiter.CheckAndIncrement(ctx, espType, 1)
-	if err != nil {
-		log.Printf("[CampaignProcessor] Rate limit check error: %v", err)
+	// One reservation across org, ESP, profile, pool, ISP, domain and campaign
+	if wait := p.limiter.Admit(ctx, SendScope{
+		ProfileID:  profileID,
+		Domain:     extractDomain(item.Email),
+		CampaignID: item.CampaignID.String(),
+	}); wait > 0 {
+		return p.returnToQueue(ctx, item.ID, wait)
 	}
-	if !allowed {
-		if waitTime > 0 {
-			time.Sleep(waitTime)
+
+	// Bandit A/B tests pick the variant now, from the rewards seen so far
+	if item.VariantName == "" && p.variants != nil {
+		v, err := assignSendTimeVariant(ctx, p.db, p.variants, item.ID, item.CampaignID, item.SubscriberID, item.Email)
+		if err != nil {
+			log.Printf("[CampaignProcessor] Variant assignment for item %s: %v", item.ID, err)
+			return p.returnToQueue(ctx, item.ID, time.Minute)
+		}
+		if v != nil {
+			item.VariantName, item.Subject, item.HTMLContent, item.PlainContent = v.Name, v.Subject, v.HTMLContent, v.TextContent
 		}
-		return p.returnToQueue(ctx, item.ID)
 	}
 
 	// Build and send email
@@ -335,11 +364,36 @@ func (p *CampaignProcessor) processItem(item ProcessorQueueItem) error {
 		FROM mailing_campaigns WHERE id = $1
 	`, item.CampaignID).Scan(&msg.FromName, &msg.FromEmail, &msg.ReplyTo)
 
+	// Record the send before submitting it; a key already submitted or sent
+	// by an earlier delivery of this item is not sent again
+	key := SendKey{
+		CampaignID:   item.CampaignID.String(),
+		SubscriberID: item.SubscriberID.String(),
+		Variant:      item.VariantName,
+		WaveID:       item.WaveID,
+	}
+	prior, err := p.ledger.Begin(ctx, QueueTableV1, item.ID, key, msg)
+	if errors.Is(err, ErrClaimLost) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("send ledger: %w", err)
+	}
+	if prior != nil {
+		atomic.AddInt64(&p.totalSkipped, 1)
+		return p.skipItem(ctx, item.ID, "duplicate_"+prior.State)
+	}
+
 	// Send
 	result, err := p.sender.Send(ctx, msg)
+	if err != nil && ambiguousSendError(err) {
+		// The ESP may have accepted it; recovery settles the item
+		log.Printf("[CampaignProcessor] Send unconfirmed, key %s: %v", key, err)
+		return err
+	}
 	if err != nil {
 		p.distributor.RecordFailure(ctx, item.CampaignID.String(), profileID)
 		atomic.AddInt64(&p.totalFailed, 1)
+		p.failLedger(ctx, key, err.Error())
 		return p.markFailed(ctx, item.ID, item.CampaignID, err.Error())
 	}
 
@@ -350,6 +404,7 @@ func (p *CampaignProcessor) processItem(item ProcessorQueueItem) error {
 		if result.Error != nil {
 			errMsg = result.Error.Error()
 		}
+		p.failLedger(ctx, key, errMsg)
 		return p.markFailed(ctx, item.ID, item.CampaignID, errMsg)
 	}
 
@@ -357,8 +412,17 @@ func (p *CampaignProcessor) processItem(item ProcessorQueueItem) error {
 	p.distributor.RecordSend(ctx, item.CampaignID.String(), profileID)
 	p.distributor.RecordSuccess(ctx, profileID)
 	atomic.AddInt64(&p.totalSent, 1)
+	if err := p.ledger.Complete(ctx, key, result.MessageID); err != nil {
+		log.Printf("[CampaignProcessor] Send ledger complete %s: %v", key, err)
+	}
 	
-	return p.markSent(ctx, item.ID, item.CampaignID, result.MessageID)
+	if err := p.markSent(ctx, item.ID, item.CampaignID, result.MessageID); err != nil {
+		return err
+	}
+	if err := mailing.RecordABSend(ctx, p.db, item.CampaignID, item.SubscriberID, item.VariantName); err != nil {
+		log.Printf("[CampaignProcessor] A/B send event for item %s: %v", item.ID, err)
+	}
+	return nil
 }
 
 // selectESP chooses which ESP to use based on quotas
@@ -388,67 +452,6 @@ func (p *CampaignProcessor) selectESP(ctx context.Context, item ProcessorQueueIt
 	return defaultProfileID, nil
 }
 
-// applyThrottle applies throttle delay based on rate per minute
-func (p *CampaignProcessor) applyThrottle(ctx context.Context, campaignID string, ratePerMinute int) error {
-	if ratePerMinute <= 0 {
-		return nil
-	}
-
-	// Use Redis for distributed rate limiting
-	key := fmt.Sprintf("throttle:campaign:%s", campaignID)
-	
-	// Simple token bucket implementation
-	script := `
-		local key = KEYS[1]
-		local rate = tonumber(ARGV[1])
-		local now = tonumber(ARGV[2])
-		
-		local bucket = redis.call('get', key)
-		if not bucket then
-			bucket = '{"tokens":' .. rate .. ',"last":' .. now .. '}'
-		end
-		
-		local data = cjson.decode(bucket)
-		local elapsed = now - data.last
-		local tokens = math.min(rate, data.tokens + elapsed * (rate / 60))
-		
-		if tokens >= 1 then
-			tokens = tokens - 1
-			redis.call('setex', key, 120, cjson.encode({tokens=tokens, last=now}))
-			return 1
-		else
-			return 0
-		end
-	`
-
-	result, err := p.redis.Eval(ctx, script, []string{key}, ratePerMinute, time.Now().Unix()).Int()
-	if err != nil {
-		return err
-	}
-
-	if result == 0 {
-		// Wait before retry
-		waitTime := time.Duration(60000/ratePerMinute) * time.Millisecond
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(waitTime):
-			return nil
-		}
-	}
-
-	return nil
-}
-
-// getESPType returns the vendor type for a profile
-func (p *CampaignProcessor) getESPType(ctx context.Context, profileID string) string {
-	var vendorType string
-	p.db.QueryRowContext(ctx, `
-		SELECT COALESCE(vendor_type, 'ses') FROM mailing_sending_profiles WHERE id = $1
-	`, profileID).Scan(&vendorType)
-	return vendorType
-}
-
 // markSent marks a queue item as sent and updates campaign stats
 func (p *CampaignProcessor) markSent(ctx context.Context, itemID, campaignID uuid.UUID, messageID string) error {
 	_, err := p.db.ExecContext(ctx, `
@@ -503,6 +506,13 @@ func (p *CampaignProcessor) markFailed(ctx context.Context, itemID, campaignID u
 	return err
 }
 
+// failLedger releases a rejected send's key for the next attempt
+func (p *CampaignProcessor) failLedger(ctx context.Context, key SendKey, reason string) {
+	if err := p.ledger.Fail(ctx, key, reason); err != nil {
+		log.Printf("[CampaignProcessor] Send ledger fail %s: %v", key, err)
+	}
+}
+
 // skipItem marks an item as skipped
 func (p *CampaignProcessor) skipItem(ctx context.Context, itemID uuid.UUID, reason string) error {
 	atomic.AddInt64(&p.totalSkipped, 1)
@@ -524,13 +534,14 @@ func (p *CampaignProcessor) pauseItem(ctx context.Context, itemID uuid.UUID) err
 	return err
 }
 
-// returnToQueue returns an item to the queue for retry
-func (p *CampaignProcessor) returnToQueue(ctx context.Context, itemID uuid.UUID) error {
+// returnToQueue returns a claimed item to the queue, due again after wait
+func (p *CampaignProcessor) returnToQueue(ctx context.Context, itemID uuid.UUID, wait time.Duration) error {
 	_, err := p.db.ExecContext(ctx, `
 		UPDATE mailing_campaign_queue
-		SET status = 'queued', worker_id = NULL, claimed_at = NULL
-		WHERE id = $1
-	`, itemID)
+		SET status = 'queued', worker_id = NULL, claimed_at = NULL,
+		    scheduled_at = NOW() + $2::interval
+		WHERE id = $1 AND status = 'claimed'
+	`, itemID, wait.String())
 	return err
 }
 
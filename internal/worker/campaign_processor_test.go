@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"testing"
-	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/alicebob/miniredis/v2"
@@ -233,71 +232,6 @@ func TestCampaignProcessor_SelectESP_WithProfileID(t *testing.T) {
 	}
 }
 
-// =============================================================================
-// THROTTLE INTEGRATION TESTS
-// =============================================================================
-
-func TestCampaignProcessor_ApplyThrottle(t *testing.T) {
-	mr, _ := miniredis.Run()
-	defer mr.Close()
-
-	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
-	defer redisClient.Close()
-
-	db, _, dbCleanup := setupTestDB(t)
-	defer dbCleanup()
-
-	processor := NewCampaignProcessor(db, redisClient, DefaultProcessorConfig())
-	ctx := context.Background()
-	campaignID := "throttle-test"
-
-	// Should not error with valid rate
-	err := processor.applyThrottle(ctx, campaignID, 100)
-	if err != nil {
-		t.Errorf("applyThrottle() error: %v", err)
-	}
-
-	// Zero rate should be no-op
-	err = processor.applyThrottle(ctx, campaignID, 0)
-	if err != nil {
-		t.Errorf("applyThrottle(0) error: %v", err)
-	}
-}
-
-func TestCampaignProcessor_ThrottleRespected(t *testing.T) {
-	mr, _ := miniredis.Run()
-	defer mr.Close()
-
-	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
-	defer redisClient.Close()
-
-	db, _, dbCleanup := setupTestDB(t)
-	defer dbCleanup()
-
-	processor := NewCampaignProcessor(db, redisClient, DefaultProcessorConfig())
-	ctx := context.Background()
-	campaignID := "throttle-timing-test"
-
-	// Set a low rate for testing
-	ratePerMinute := 60 // 1 per second
-
-	start := time.Now()
-	
-	// Make multiple calls - they should be throttled
-	for i := 0; i < 3; i++ {
-		processor.applyThrottle(ctx, campaignID, ratePerMinute)
-	}
-
-	elapsed := time.Since(start)
-	
-	// With 60/min rate, 3 calls should take at least 2 seconds
-	// But since we're using token bucket, first call is instant
-	// We just verify it doesn't take too long (< 5 seconds)
-	if elapsed > 5*time.Second {
-		t.Errorf("Throttle took too long: %v", elapsed)
-	}
-}
-
 // =============================================================================
 // CAMPAIGN STATUS TESTS
 // =============================================================================
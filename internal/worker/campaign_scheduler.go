@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
 	"github.com/ignite/sparkpost-monitor/internal/pkg/distlock"
 	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
@@ -58,6 +59,9 @@ type CampaignScheduler struct {
 	// Backpressure
 	backpressure *BackpressureMonitor
 
+	// A/B variant assignment for static tests; nil assigns round-robin
+	variants *mailing.VariantSelector
+
 	// Stats
 	campaignsProcessed int64
 	subscribersQueued  int64
@@ -122,6 +126,13 @@ func (cs *CampaignScheduler) SetBackpressure(bp *BackpressureMonitor) {
 	cs.backpressure = bp
 }
 
+// SetVariantSelector assigns the variants of static A/B tests through vs.
+// Variants of bandit tests are then left for the send worker pool to
+// assign when each message is sent, so the pool needs a selector as well.
+func (cs *CampaignScheduler) SetVariantSelector(vs *mailing.VariantSelector) {
+	cs.variants = vs
+}
+
 // Start begins the scheduler polling loop
 func (cs *CampaignScheduler) Start() error {
 	cs.mu.Lock()
@@ -212,19 +223,21 @@ func (cs *CampaignScheduler) checkCompletedCampaigns() {
 	defer cancel()
 
 	// Find campaigns in 'sending' status where all queue items are done.
-	// Queue statuses: queued → sending → sent/failed/skipped/dead_letter
+	// Queue statuses: queued → sending → sent/failed/skipped/dead_letter.
+	// Parked items are pending: the campaign completes once an operator
+	// resolves them (SendLedger.ResolveParked).
 	rows, err := cs.db.QueryContext(ctx, `
 		SELECT c.id, 
 			   COALESCE(SUM(CASE WHEN q.status = 'sent' THEN 1 ELSE 0 END), 0) as sent,
 			   COALESCE(SUM(CASE WHEN q.status IN ('failed','dead_letter') THEN 1 ELSE 0 END), 0) as failed,
 			   COALESCE(SUM(CASE WHEN q.status = 'skipped' THEN 1 ELSE 0 END), 0) as skipped,
-			   COALESCE(SUM(CASE WHEN q.status IN ('queued','sending','claimed','pending') THEN 1 ELSE 0 END), 0) as pending,
+			   COALESCE(SUM(CASE WHEN q.status IN ('queued','sending','claimed','submitting','parked','pending') THEN 1 ELSE 0 END), 0) as pending,
 			   COUNT(q.id) as total
 		FROM mailing_campaigns c
 		LEFT JOIN mailing_campaign_queue q ON q.campaign_id = c.id
 		WHERE c.status = 'sending'
 		GROUP BY c.id
-		HAVING COALESCE(SUM(CASE WHEN q.status IN ('queued','sending','claimed','pending') THEN 1 ELSE 0 END), 0) = 0
+		HAVING COALESCE(SUM(CASE WHEN q.status IN ('queued','sending','claimed','submitting','parked','pending') THEN 1 ELSE 0 END), 0) = 0
 		   AND NOT EXISTS (
 		       SELECT 1 FROM mailing_campaign_waves w
 		       WHERE w.campaign_id = c.id
@@ -281,7 +294,7 @@ func (cs *CampaignScheduler) checkCompletedCampaigns() {
 		JOIN mailing_campaign_queue q ON q.campaign_id = c.id
 		WHERE c.status IN ('draft', 'scheduled')
 		GROUP BY c.id
-		HAVING COALESCE(SUM(CASE WHEN q.status IN ('queued','sending','claimed','pending') THEN 1 ELSE 0 END), 0) = 0
+		HAVING COALESCE(SUM(CASE WHEN q.status IN ('queued','sending','claimed','submitting','parked','pending') THEN 1 ELSE 0 END), 0) = 0
 		   AND NOT EXISTS (
 		       SELECT 1 FROM mailing_campaign_waves w
 		       WHERE w.campaign_id = c.id
@@ -910,12 +923,15 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 
 	// =================================================================
 	// A/B VARIANT ASSIGNMENT
-	// Load variants from mailing_ab_variants if this campaign has an
-	// A/B test. Each subscriber gets round-robin assigned to a variant,
-	// which overrides the campaign's default subject/content.
+	// Load the variants of the campaign's current A/B test. Each
+	// subscriber is assigned a variant by the variant selector
+	// (round-robin without one), which overrides the campaign's default
+	// subject/content. Bandit tests are assigned at send time instead,
+	// once rewards have started to arrive.
 	// =================================================================
 	type abVariant struct {
 		ID          string
+		Name        string
 		Subject     string
 		HTMLContent string
 		TextContent string
@@ -923,20 +939,25 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 	}
 	var abVariants []abVariant
 
-	abRows, abErr := cs.db.QueryContext(ctx, `
-		SELECT v.id::text, COALESCE(v.subject, ''), COALESCE(v.html_content, ''),
-		       COALESCE(v.text_content, ''), COALESCE(v.from_name, '')
-		FROM mailing_ab_variants v
-		JOIN mailing_ab_tests t ON t.id = v.test_id
-		WHERE t.campaign_id = $1
-		  AND v.status != 'eliminated'
-		ORDER BY v.variant_name
-	`, campaign.ID)
-	if abErr == nil {
+	abTestID, allocation, abErr := mailing.CurrentABTest(ctx, cs.db, campaign.ID)
+	deferVariants := cs.variants != nil &&
+		(allocation == mailing.AllocationThompson || allocation == mailing.AllocationUCB)
+	var abRows *sql.Rows
+	if abErr == nil && !deferVariants {
+		abRows, abErr = cs.db.QueryContext(ctx, `
+			SELECT v.id::text, v.variant_name, COALESCE(v.subject, ''), COALESCE(v.html_content, ''),
+			       COALESCE(v.text_content, ''), COALESCE(v.from_name, '')
+			FROM mailing_ab_variants v
+			WHERE v.test_id = $1
+			  AND v.status != 'eliminated'
+			ORDER BY v.variant_name
+		`, abTestID)
+	}
+	if abErr == nil && abRows != nil {
 		defer abRows.Close()
 		for abRows.Next() {
 			var v abVariant
-			if err := abRows.Scan(&v.ID, &v.Subject, &v.HTMLContent, &v.TextContent, &v.FromName); err == nil {
+			if err := abRows.Scan(&v.ID, &v.Name, &v.Subject, &v.HTMLContent, &v.TextContent, &v.FromName); err == nil {
 				// Only include variants that have content
 				if v.Subject != "" || v.HTMLContent != "" {
 					abVariants = append(abVariants, v)
@@ -945,8 +966,15 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 		}
 	}
 
+	selector := cs.variants
+	abByID := make(map[string]abVariant, len(abVariants))
+	for _, v := range abVariants {
+		abByID[v.ID] = v
+	}
 	if len(abVariants) > 0 {
-		log.Printf("[CampaignScheduler] Campaign %s: A/B test with %d active variants — assigning round-robin", campaign.ID, len(abVariants))
+		log.Printf("[CampaignScheduler] Campaign %s: A/B test with %d active variants", campaign.ID, len(abVariants))
+	} else if deferVariants {
+		log.Printf("[CampaignScheduler] Campaign %s: %s A/B test, variants assigned at send time", campaign.ID, allocation)
 	}
 
 	// Get per-subscriber optimal send times if AI optimization is enabled
@@ -977,7 +1005,7 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 		"id", "campaign_id", "subscriber_id",
 		"subject", "html_content", "plain_content",
 		"status", "priority", "scheduled_at", "created_at",
-		"recipient_isp",
+		"recipient_isp", "variant_name",
 	))
 	if err != nil {
 		return 0, fmt.Errorf("failed to prepare COPY: %w", err)
@@ -1060,8 +1088,23 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 		htmlContent := campaign.HTMLContent
 		textContent := campaign.TextContent
 
+		var variantName interface{}
 		if len(abVariants) > 0 {
-			v := abVariants[variantIdx%len(abVariants)]
+			var v abVariant
+			ok := false
+			if selector != nil {
+				vc, err := selector.SelectVariant(ctx, campaign.ID, sub.Email)
+				if err != nil {
+					log.Printf("[CampaignScheduler] Campaign %s: variant selection failed, assigning round-robin: %v", campaign.ID, err)
+					selector = nil
+				} else if vc != nil {
+					v, ok = abByID[vc.VariantID.String()]
+				}
+			}
+			if !ok {
+				v = abVariants[variantIdx%len(abVariants)]
+				variantIdx++
+			}
 			if v.Subject != "" {
 				subject = v.Subject
 			}
@@ -1071,8 +1114,8 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 			if v.TextContent != "" {
 				textContent = v.TextContent
 			}
+			variantName = v.Name
 			abAssignments = append(abAssignments, abAssignment{VariantID: v.ID, SubscriberID: sub.ID})
-			variantIdx++
 		}
 
 		effectivePriority := priority + sub.Priority
@@ -1080,7 +1123,7 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 			uuid.New(), campaign.ID, sub.ID,
 			subject, htmlContent, textContent,
 			"queued", effectivePriority, scheduledAt, now,
-			recipientISP,
+			recipientISP, variantName,
 		)
 		if err != nil {
 			log.Printf("[CampaignScheduler] COPY row error for subscriber %s: %v", sub.ID, err)
@@ -1114,12 +1157,9 @@ func (cs *CampaignScheduler) enqueueSubscribers(ctx context.Context, campaign Sc
 				"id", "test_id", "variant_id", "subscriber_id", "assigned_at",
 			))
 			if err == nil {
-				// Resolve test_id once
-				var testID string
-				cs.db.QueryRowContext(ctx, `SELECT id::text FROM mailing_ab_tests WHERE campaign_id = $1 LIMIT 1`, campaign.ID).Scan(&testID)
-				if testID != "" {
+				if abTestID != uuid.Nil {
 					for _, a := range abAssignments {
-						abStmt.Exec(uuid.New(), testID, a.VariantID, a.SubscriberID, now)
+						abStmt.Exec(uuid.New(), abTestID, a.VariantID, a.SubscriberID, now)
 					}
 					abStmt.Exec()
 					abStmt.Close()
@@ -3,10 +3,14 @@ package worker
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 )
 
 // EmailVerificationProvider abstracts third-party email verification (H5).
@@ -16,8 +20,63 @@ type EmailVerificationProvider interface {
 
 // VerificationResult holds the outcome from a verification provider.
 type VerificationResult struct {
-	Status string  // "valid", "invalid", "catch-all", "unknown"
-	Score  float64 // mapped quality score
+	Status string  // one of the Verification* statuses
+	Score  float64 // mapped quality score, see VerificationScore
+	Reason string  // provider detail, e.g. the SMTP reply
+}
+
+// DeferredError is returned by providers that cannot reach a verdict yet,
+// e.g. because the recipient's MX greylisted the probe. The verifier asks
+// again after RetryAfter instead of waiting inline.
+type DeferredError struct {
+	RetryAfter time.Duration
+	Reason     string
+}
+
+func (e *DeferredError) Error() string { return "verification deferred: " + e.Reason }
+
+// Statuses reported by EmailVerificationProvider implementations.
+const (
+	VerificationValid      = "valid"
+	VerificationInvalid    = "invalid"
+	VerificationCatchAll   = "catch-all"
+	VerificationUnknown    = "unknown"
+	VerificationDisposable = "disposable"
+	VerificationRole       = "role"
+	VerificationRisky      = "risky" // spam traps, known complainers
+)
+
+// verificationTiers maps statuses to segmentation.DataQualityTiers. Role
+// accounts stay at mx_valid so warmup never picks them; disposable and
+// risky addresses drop to unverified without being marked bounced.
+var verificationTiers = map[string]string{
+	VerificationValid:      "verified",
+	VerificationCatchAll:   "catch_all",
+	VerificationUnknown:    "mx_valid",
+	VerificationRole:       "mx_valid",
+	VerificationInvalid:    "unverified",
+	VerificationDisposable: "unverified",
+	VerificationRisky:      "unverified",
+}
+
+// VerificationScore returns the data_quality_score for a status: the floor
+// of its tier.
+func VerificationScore(status string) float64 {
+	tier, ok := verificationTiers[status]
+	if !ok {
+		tier = verificationTiers[VerificationUnknown]
+	}
+	for _, t := range segmentation.DataQualityTiers {
+		if t.Name == tier {
+			return t.Min
+		}
+	}
+	return 0
+}
+
+// verificationResult builds a result with the status's score.
+func verificationResult(status, reason string) VerificationResult {
+	return VerificationResult{Status: status, Score: VerificationScore(status), Reason: reason}
 }
 
 // EmailVerifier runs background email validation on imported subscribers.
@@ -29,6 +88,8 @@ type EmailVerifier struct {
 	batchSize    int
 	interval     time.Duration
 	ratePerMin   int
+	concurrency  int // addresses verified at once; SMTPProbe also caps per MX
+	maxDeferrals int // deferrals before an address is scored unknown
 	ctx          context.Context
 	cancel       context.CancelFunc
 	lastRunAt    time.Time
@@ -37,12 +98,14 @@ type EmailVerifier struct {
 
 func NewEmailVerifier(db *sql.DB, provider EmailVerificationProvider) *EmailVerifier {
 	return &EmailVerifier{
-		db:         db,
-		provider:   provider,
-		batchSize:  50,
-		interval:   1 * time.Minute,
-		ratePerMin: 100,
-		healthy:    true,
+		db:           db,
+		provider:     provider,
+		batchSize:    50,
+		interval:     1 * time.Minute,
+		ratePerMin:   100,
+		concurrency:  10,
+		maxDeferrals: 3,
+		healthy:      true,
 	}
 }
 
@@ -94,6 +157,7 @@ func (v *EmailVerifier) verifyMXBatch(ctx context.Context) {
 	rows, err := v.db.QueryContext(ctx,
 		`SELECT id, email FROM mailing_subscribers
 		WHERE data_quality_score < 0.25 AND status = 'confirmed'
+		AND COALESCE(verification_status, '') NOT IN ('mx_failed', 'api_disposable', 'api_risky')
 		ORDER BY created_at ASC LIMIT $1`, v.batchSize)
 	if err != nil {
 		log.Printf("[EmailVerifier] MX batch query error: %v", err)
@@ -123,58 +187,103 @@ func (v *EmailVerifier) verifyMXBatch(ctx context.Context) {
 	}
 }
 
+// verifyCandidate is an address awaiting the provider's verdict.
+type verifyCandidate struct {
+	id, email string
+	deferrals int
+}
+
 func (v *EmailVerifier) verifyAPIBatch(ctx context.Context) {
+	limit := v.batchSize
+	if v.ratePerMin < limit {
+		limit = v.ratePerMin
+	}
 	rows, err := v.db.QueryContext(ctx,
-		`SELECT id, email FROM mailing_subscribers
+		`SELECT id, email, COALESCE(verification_deferrals, 0) FROM mailing_subscribers
 		WHERE data_quality_score = 0.25 AND verification_status = 'mx_valid' AND status = 'confirmed'
-		ORDER BY created_at ASC LIMIT $1`, v.batchSize)
+		AND (verification_retry_at IS NULL OR verification_retry_at <= NOW())
+		ORDER BY created_at ASC LIMIT $1`, limit)
 	if err != nil {
 		log.Printf("[EmailVerifier] API batch query error: %v", err)
 		return
 	}
-	defer rows.Close()
-
-	processed := 0
+	// Read the whole batch first so the connection isn't held while the
+	// provider is asked.
+	var batch []verifyCandidate
 	for rows.Next() {
-		if ctx.Err() != nil || processed >= v.ratePerMin {
-			return
-		}
-		var id, email string
-		if err := rows.Scan(&id, &email); err != nil {
+		var c verifyCandidate
+		if err := rows.Scan(&c.id, &c.email, &c.deferrals); err != nil {
 			continue
 		}
+		batch = append(batch, c)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		log.Printf("[EmailVerifier] API batch query error: %v", err)
+		return
+	}
 
-		result, err := v.provider.Verify(ctx, email)
-		if err != nil {
-			log.Printf("[EmailVerifier] API error for %s: %v", email, err)
-			continue
+	sem := make(chan struct{}, v.concurrency)
+	var wg sync.WaitGroup
+	for _, c := range batch {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
 		}
+		wg.Add(1)
+		go func(c verifyCandidate) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			v.verifyAddress(ctx, c)
+		}(c)
+	}
+	wg.Wait()
+}
 
-		status := "api_" + result.Status
-		score := result.Score
-
-		// H5: Score mapping
-		switch result.Status {
-		case "valid":
-			score = 0.50
-		case "catch-all":
-			score = 0.30
-		case "invalid":
-			score = 0.00
-			// Suppress invalid emails immediately
+// verifyAddress asks the provider about one address and stores the verdict.
+// Deferred addresses are requeued until maxDeferrals, then scored unknown.
+func (v *EmailVerifier) verifyAddress(ctx context.Context, c verifyCandidate) {
+	result, err := v.provider.Verify(ctx, c.email)
+	var deferred *DeferredError
+	if errors.As(err, &deferred) {
+		if c.deferrals+1 < v.maxDeferrals {
 			v.db.ExecContext(ctx,
-				`UPDATE mailing_subscribers SET status = 'bounced', data_quality_score = 0.00, verification_status = $1, verified_at = NOW(), updated_at = NOW()
-				WHERE id = $2`, status, id)
-			processed++
-			continue
-		default:
-			score = 0.25 // unknown — keep at MX level
+				`UPDATE mailing_subscribers SET verification_deferrals = COALESCE(verification_deferrals, 0) + 1,
+				verification_retry_at = NOW() + $1 * INTERVAL '1 second', updated_at = NOW()
+				WHERE id = $2`, deferred.RetryAfter.Seconds(), c.id)
+			return
 		}
+		result, err = verificationResult(VerificationUnknown, deferred.Reason), nil
+	}
+	if err != nil {
+		log.Printf("[EmailVerifier] API error for %s: %v", c.email, err)
+		return
+	}
+
+	status := "api_" + result.Status
 
+	// H5: Score mapping — providers score by tier (VerificationScore)
+	switch result.Status {
+	case VerificationInvalid:
+		// Suppress invalid emails immediately
+		v.db.ExecContext(ctx,
+			`UPDATE mailing_subscribers SET status = 'bounced', data_quality_score = 0.00, verification_status = $1,
+			verification_deferrals = 0, verification_retry_at = NULL, verified_at = NOW(), updated_at = NOW()
+			WHERE id = $2`, status, c.id)
+	case VerificationDisposable, VerificationRisky:
+		// Deliverable but not worth mailing: drop out of every tier
+		v.db.ExecContext(ctx,
+			`UPDATE mailing_subscribers SET data_quality_score = $1, verification_status = $2,
+			verification_deferrals = 0, verification_retry_at = NULL, verified_at = NOW(), updated_at = NOW()
+			WHERE id = $3`, result.Score, status, c.id)
+	default:
 		v.db.ExecContext(ctx,
-			`UPDATE mailing_subscribers SET data_quality_score = GREATEST(data_quality_score, $1), verification_status = $2, verified_at = NOW(), updated_at = NOW()
-			WHERE id = $3`, score, status, id)
-		processed++
+			`UPDATE mailing_subscribers SET data_quality_score = GREATEST(data_quality_score, $1), verification_status = $2,
+			verification_deferrals = 0, verification_retry_at = NULL, verified_at = NOW(), updated_at = NOW()
+			WHERE id = $3`, result.Score, status, c.id)
 	}
 }
 
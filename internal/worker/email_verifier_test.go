@@ -1,7 +1,14 @@
 package worker
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCheckMXValid(t *testing.T) {
@@ -25,3 +32,78 @@ func TestCheckMXBadFormat(t *testing.T) {
 		t.Error("expected false for badly formatted email")
 	}
 }
+
+func TestVerificationScoreFollowsTiers(t *testing.T) {
+	cases := map[string]float64{
+		VerificationValid:      0.50,
+		VerificationCatchAll:   0.30,
+		VerificationUnknown:    0.25,
+		VerificationRole:       0.25,
+		VerificationInvalid:    0,
+		VerificationDisposable: 0,
+		VerificationRisky:      0,
+		"something-new":        0.25,
+	}
+	for status, want := range cases {
+		if got := VerificationScore(status); got != want {
+			t.Errorf("VerificationScore(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+// barrierProvider answers once every address in the batch is being
+// verified, so it only passes when the verifier asks concurrently.
+type barrierProvider struct {
+	wg      sync.WaitGroup
+	results map[string]error
+}
+
+func (p *barrierProvider) Verify(ctx context.Context, email string) (VerificationResult, error) {
+	p.wg.Done()
+	done := make(chan struct{})
+	go func() { p.wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		return VerificationResult{}, context.DeadlineExceeded
+	}
+	if err := p.results[email]; err != nil {
+		return VerificationResult{}, err
+	}
+	return verificationResult(VerificationValid, "recipient accepted"), nil
+}
+
+func TestEmailVerifier_APIBatchConcurrentAndRequeuesDeferred(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	greylisted := &DeferredError{RetryAfter: time.Minute, Reason: "451 4.7.1 greylisted"}
+	provider := &barrierProvider{results: map[string]error{
+		"new@example.org":   greylisted,
+		"stale@example.org": greylisted,
+	}}
+	provider.wg.Add(3)
+
+	mock.ExpectQuery("SELECT id, email, COALESCE\\(verification_deferrals, 0\\) FROM mailing_subscribers").
+		WithArgs(50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "deferrals"}).
+			AddRow("s1", "ok@example.org", 0).
+			AddRow("s2", "new@example.org", 0).
+			AddRow("s3", "stale@example.org", 2))
+	mock.ExpectExec("UPDATE mailing_subscribers SET data_quality_score = GREATEST").
+		WithArgs(0.50, "api_valid", "s1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SET verification_deferrals = COALESCE\\(verification_deferrals, 0\\) \\+ 1").
+		WithArgs(60.0, "s2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE mailing_subscribers SET data_quality_score = GREATEST").
+		WithArgs(0.25, "api_unknown", "s3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	v := NewEmailVerifier(db, provider)
+	v.verifyAPIBatch(context.Background())
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
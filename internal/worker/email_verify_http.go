@@ -0,0 +1,171 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPVerifierConfig describes a commercial verification API that takes
+// one address per GET request and answers with a JSON object.
+type HTTPVerifierConfig struct {
+	Name   string // used in errors and the result reason
+	URL    string // request URL; {email} and {key} are replaced, query-escaped
+	APIKey string
+
+	// StatusField and SubStatusField are dotted paths into the response.
+	StatusField    string
+	SubStatusField string
+	// ErrorField, when set and non-empty in a response, fails the request.
+	ErrorField string
+
+	// Statuses maps the provider's status, or "status/sub_status" for a
+	// more specific match, to a Verification* status. Unmapped values
+	// become VerificationUnknown.
+	Statuses map[string]string
+
+	Timeout time.Duration // default 30s
+}
+
+// ZeroBounceConfig returns the configuration for ZeroBounce's v2 API.
+func ZeroBounceConfig(apiKey string) HTTPVerifierConfig {
+	return HTTPVerifierConfig{
+		Name:           "zerobounce",
+		URL:            "https://api.zerobounce.net/v2/validate?api_key={key}&email={email}&ip_address=",
+		APIKey:         apiKey,
+		StatusField:    "status",
+		SubStatusField: "sub_status",
+		ErrorField:     "error",
+		Statuses: map[string]string{
+			"valid":                               VerificationValid,
+			"invalid":                             VerificationInvalid,
+			"catch-all":                           VerificationCatchAll,
+			"unknown":                             VerificationUnknown,
+			"spamtrap":                            VerificationRisky,
+			"abuse":                               VerificationRisky,
+			"do_not_mail":                         VerificationRisky,
+			"do_not_mail/disposable":              VerificationDisposable,
+			"do_not_mail/role_based":              VerificationRole,
+			"do_not_mail/role_based_catch_all":    VerificationRole,
+			"do_not_mail/global_suppression":      VerificationRisky,
+			"do_not_mail/possible_trap":           VerificationRisky,
+			"do_not_mail/mx_forward":              VerificationUnknown,
+			"do_not_mail/toxic":                   VerificationRisky,
+			"invalid/mailbox_not_found":           VerificationInvalid,
+			"unknown/greylisted":                  VerificationUnknown,
+			"unknown/mail_server_temporary_error": VerificationUnknown,
+		},
+	}
+}
+
+// NeverBounceConfig returns the configuration for NeverBounce's v4 API.
+func NeverBounceConfig(apiKey string) HTTPVerifierConfig {
+	return HTTPVerifierConfig{
+		Name:        "neverbounce",
+		URL:         "https://api.neverbounce.com/v4/single/check?key={key}&email={email}",
+		APIKey:      apiKey,
+		StatusField: "result",
+		ErrorField:  "message",
+		Statuses: map[string]string{
+			"valid":      VerificationValid,
+			"invalid":    VerificationInvalid,
+			"disposable": VerificationDisposable,
+			"catchall":   VerificationCatchAll,
+			"unknown":    VerificationUnknown,
+		},
+	}
+}
+
+// HTTPVerifier is an EmailVerificationProvider backed by a commercial
+// verification API.
+type HTTPVerifier struct {
+	cfg    HTTPVerifierConfig
+	client *http.Client
+}
+
+// NewHTTPVerifier creates an HTTPVerifier.
+func NewHTTPVerifier(cfg HTTPVerifierConfig) *HTTPVerifier {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &HTTPVerifier{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Verify implements EmailVerificationProvider.
+func (h *HTTPVerifier) Verify(ctx context.Context, email string) (VerificationResult, error) {
+	u := strings.NewReplacer(
+		"{email}", url.QueryEscape(email),
+		"{key}", url.QueryEscape(h.cfg.APIKey),
+	).Replace(h.cfg.URL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return VerificationResult{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return VerificationResult{}, fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return VerificationResult{}, fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return VerificationResult{}, fmt.Errorf("%s: HTTP %d: %s", h.cfg.Name, resp.StatusCode, truncateString(string(body), 200))
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return VerificationResult{}, fmt.Errorf("%s: decode response: %w", h.cfg.Name, err)
+	}
+	status := strings.ToLower(jsonPath(doc, h.cfg.StatusField))
+	if status == "" {
+		if msg := jsonPath(doc, h.cfg.ErrorField); msg != "" {
+			return VerificationResult{}, fmt.Errorf("%s: %s", h.cfg.Name, msg)
+		}
+		return VerificationResult{}, fmt.Errorf("%s: response has no %q", h.cfg.Name, h.cfg.StatusField)
+	}
+	sub := strings.ToLower(jsonPath(doc, h.cfg.SubStatusField))
+
+	mapped, ok := h.cfg.Statuses[status+"/"+sub]
+	if !ok {
+		if mapped, ok = h.cfg.Statuses[status]; !ok {
+			mapped = VerificationUnknown
+		}
+	}
+	reason := h.cfg.Name + ": " + status
+	if sub != "" {
+		reason += "/" + sub
+	}
+	return verificationResult(mapped, reason), nil
+}
+
+// jsonPath returns the string at a dotted path, or "" if absent.
+func jsonPath(doc map[string]interface{}, path string) string {
+	if path == "" {
+		return ""
+	}
+	var cur interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		cur = m[key]
+	}
+	switch v := cur.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
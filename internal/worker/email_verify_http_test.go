@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPVerifier_ZeroBounce(t *testing.T) {
+	responses := map[string]string{
+		"good@example.org":  `{"address":"good@example.org","status":"valid","sub_status":""}`,
+		"any@example.org":   `{"status":"catch-all","sub_status":""}`,
+		"gone@example.org":  `{"status":"invalid","sub_status":"mailbox_not_found"}`,
+		"trap@example.org":  `{"status":"spamtrap","sub_status":""}`,
+		"temp@example.org":  `{"status":"do_not_mail","sub_status":"disposable"}`,
+		"sales@example.org": `{"status":"do_not_mail","sub_status":"role_based"}`,
+		"new@example.org":   `{"status":"brand_new","sub_status":""}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "k+1", r.URL.Query().Get("api_key"))
+		w.Write([]byte(responses[r.URL.Query().Get("email")]))
+	}))
+	defer srv.Close()
+
+	cfg := ZeroBounceConfig("k+1")
+	cfg.URL = strings.Replace(cfg.URL, "https://api.zerobounce.net", srv.URL, 1)
+	v := NewHTTPVerifier(cfg)
+
+	want := map[string]string{
+		"good@example.org":  VerificationValid,
+		"any@example.org":   VerificationCatchAll,
+		"gone@example.org":  VerificationInvalid,
+		"trap@example.org":  VerificationRisky,
+		"temp@example.org":  VerificationDisposable,
+		"sales@example.org": VerificationRole,
+		"new@example.org":   VerificationUnknown,
+	}
+	for email, status := range want {
+		res, err := v.Verify(context.Background(), email)
+		require.NoError(t, err, email)
+		assert.Equal(t, status, res.Status, email)
+		assert.Equal(t, VerificationScore(status), res.Score, email)
+	}
+}
+
+func TestHTTPVerifier_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("email") {
+		case "throttled@example.org":
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("slow down"))
+		default:
+			w.Write([]byte(`{"status":"auth_failure","message":"Invalid API key"}`))
+		}
+	}))
+	defer srv.Close()
+
+	cfg := NeverBounceConfig("bad")
+	cfg.URL = strings.Replace(cfg.URL, "https://api.neverbounce.com", srv.URL, 1)
+	v := NewHTTPVerifier(cfg)
+
+	_, err := v.Verify(context.Background(), "throttled@example.org")
+	assert.ErrorContains(t, err, "neverbounce: HTTP 429: slow down")
+	_, err = v.Verify(context.Background(), "a@example.org")
+	assert.ErrorContains(t, err, "neverbounce: Invalid API key")
+}
+
+type stubVerifier struct {
+	result VerificationResult
+	err    error
+	calls  int
+}
+
+func (s *stubVerifier) Verify(ctx context.Context, email string) (VerificationResult, error) {
+	s.calls++
+	return s.result, s.err
+}
+
+func TestListFilter(t *testing.T) {
+	next := &stubVerifier{result: verificationResult(VerificationValid, "")}
+	f := NewListFilter(next)
+	n, err := f.LoadDisposableDomains(strings.NewReader("# extra\n\nBurner.Example\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	res, err := f.Verify(context.Background(), "someone@mx.burner.example")
+	require.NoError(t, err)
+	assert.Equal(t, VerificationDisposable, res.Status, "subdomains of listed domains match")
+	assert.Equal(t, 0.0, res.Score)
+	res, _ = f.Verify(context.Background(), "x@mailinator.com")
+	assert.Equal(t, VerificationDisposable, res.Status)
+	assert.Zero(t, next.calls, "disposable addresses are not probed")
+
+	res, _ = f.Verify(context.Background(), "Support+eu@example.org")
+	assert.Equal(t, VerificationRole, res.Status)
+	assert.Equal(t, 0.25, res.Score)
+
+	next.result = verificationResult(VerificationInvalid, "550")
+	res, _ = f.Verify(context.Background(), "info@example.org")
+	assert.Equal(t, VerificationInvalid, res.Status, "a missing role mailbox is still invalid")
+
+	next.result = verificationResult(VerificationValid, "")
+	res, _ = f.Verify(context.Background(), "jane@example.org")
+	assert.Equal(t, VerificationValid, res.Status)
+
+	next.err = errors.New("timeout")
+	_, err = f.Verify(context.Background(), "jane@example.org")
+	assert.Error(t, err)
+
+	res, err = NewListFilter(nil).Verify(context.Background(), "jane@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, VerificationUnknown, res.Status)
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// defaultDisposableDomains seeds the disposable-domain list. Operators
+// extend it with LoadDisposableDomains from a maintained blocklist.
+var defaultDisposableDomains = []string{
+	"10minutemail.com", "20minutemail.com", "33mail.com", "discard.email",
+	"dispostable.com", "emailondeck.com", "fakeinbox.com", "getairmail.com",
+	"getnada.com", "guerrillamail.com", "guerrillamail.net", "guerrillamailblock.com",
+	"inboxkitten.com", "jetable.org", "maildrop.cc", "mailinator.com",
+	"mailnesia.com", "mintemail.com", "mohmal.com", "mytemp.email",
+	"mytrashmail.com", "sharklasers.com", "spam4.me", "spamgourmet.com",
+	"temp-mail.org", "tempail.com", "tempmail.com", "tempmailo.com",
+	"tempr.email", "throwawaymail.com", "trashmail.com", "trashmail.de",
+	"yopmail.com", "yopmail.net",
+}
+
+// roleLocalparts are mailboxes that belong to a function rather than a
+// person. They rarely opt in themselves and complain at a high rate.
+var roleLocalparts = []string{
+	"abuse", "admin", "administrator", "billing", "compliance", "contact",
+	"customerservice", "devnull", "dns", "enquiries", "ftp", "help",
+	"hostmaster", "info", "inquiries", "it", "jobs", "legal", "mail",
+	"mailer-daemon", "marketing", "media", "no-reply", "noc", "noreply",
+	"office", "postmaster", "privacy", "root", "sales", "security",
+	"spam", "support", "sysadmin", "team", "webmaster",
+}
+
+// ListFilter is an EmailVerificationProvider that catches disposable
+// domains and role accounts from local lists before handing addresses to
+// the wrapped provider. Disposable addresses are never sent on; role
+// accounts are, so a missing role mailbox still comes back invalid.
+type ListFilter struct {
+	next EmailVerificationProvider
+
+	mu         sync.RWMutex
+	disposable map[string]bool
+	roles      map[string]bool
+}
+
+// NewListFilter wraps next, which may be nil to use the lists alone.
+func NewListFilter(next EmailVerificationProvider) *ListFilter {
+	f := &ListFilter{
+		next:       next,
+		disposable: make(map[string]bool, len(defaultDisposableDomains)),
+		roles:      make(map[string]bool, len(roleLocalparts)),
+	}
+	for _, d := range defaultDisposableDomains {
+		f.disposable[d] = true
+	}
+	for _, r := range roleLocalparts {
+		f.roles[r] = true
+	}
+	return f
+}
+
+// LoadDisposableDomains adds one domain per line from r, skipping blank
+// lines and # comments. It returns the number of domains read.
+func (f *ListFilter) LoadDisposableDomains(r io.Reader) (int, error) {
+	var domains []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.ToLower(strings.TrimSpace(sc.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	for _, d := range domains {
+		f.disposable[d] = true
+	}
+	f.mu.Unlock()
+	return len(domains), nil
+}
+
+// IsDisposable reports whether domain or one of its parents is listed.
+func (f *ListFilter) IsDisposable(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for {
+		if f.disposable[domain] {
+			return true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// IsRole reports whether local names a role mailbox, ignoring +tags.
+func (f *ListFilter) IsRole(local string) bool {
+	local, _, _ = strings.Cut(strings.ToLower(local), "+")
+	return f.roles[local]
+}
+
+// Verify implements EmailVerificationProvider.
+func (f *ListFilter) Verify(ctx context.Context, email string) (VerificationResult, error) {
+	local, domain, ok := splitAddress(email)
+	if !ok {
+		return verificationResult(VerificationInvalid, "malformed address"), nil
+	}
+	if f.IsDisposable(domain) {
+		return verificationResult(VerificationDisposable, "disposable domain "+domain), nil
+	}
+
+	result := verificationResult(VerificationUnknown, "")
+	if f.next != nil {
+		var err error
+		if result, err = f.next.Verify(ctx, email); err != nil {
+			return result, err
+		}
+	}
+	if f.IsRole(local) {
+		switch result.Status {
+		case VerificationInvalid, VerificationDisposable, VerificationRisky:
+		default:
+			return verificationResult(VerificationRole, "role account "+local), nil
+		}
+	}
+	return result, nil
+}
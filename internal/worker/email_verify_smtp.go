@@ -0,0 +1,242 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
+)
+
+// SMTPProbeConfig configures an SMTPProbe. Zero values take the defaults
+// noted on each field.
+type SMTPProbeConfig struct {
+	HeloName      string        // EHLO name; must resolve back to the probing IP (default "localhost")
+	MailFrom      string        // envelope sender; empty sends the null sender <>
+	Port          int           // default 25
+	Timeout       time.Duration // per connection (default 20s)
+	PerMXLimit    int           // concurrent connections per MX host (default 2)
+	GreylistDelay time.Duration // how long a 4xx defers the address (default 60s)
+}
+
+// SMTPProbe verifies addresses by asking the recipient's MX: it opens a
+// transaction and issues RCPT TO, never DATA, so nothing is delivered. An
+// accepted address is checked again with a random localpart to tell real
+// mailboxes from catch-all domains.
+type SMTPProbe struct {
+	cfg SMTPProbeConfig
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+
+	// lookupMX, lookupHost and dial are swapped out in tests.
+	lookupMX   func(ctx context.Context, domain string) ([]*net.MX, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	dial       func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// errGreylisted marks a 4xx reply worth retrying later.
+var errGreylisted = errors.New("temporary failure")
+
+// NewSMTPProbe creates an SMTPProbe.
+func NewSMTPProbe(cfg SMTPProbeConfig) *SMTPProbe {
+	if cfg.HeloName == "" {
+		cfg.HeloName = "localhost"
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 25
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 20 * time.Second
+	}
+	if cfg.PerMXLimit <= 0 {
+		cfg.PerMXLimit = 2
+	}
+	if cfg.GreylistDelay == 0 {
+		cfg.GreylistDelay = 60 * time.Second
+	}
+	var d net.Dialer
+	return &SMTPProbe{
+		cfg:        cfg,
+		sems:       make(map[string]chan struct{}),
+		lookupMX:   net.DefaultResolver.LookupMX,
+		lookupHost: net.DefaultResolver.LookupHost,
+		dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		},
+	}
+}
+
+// Verify implements EmailVerificationProvider. Errors mean the probe could
+// not reach a verdict (DNS or connection trouble) and should be retried; a
+// greylisting MX yields a *DeferredError so the address is asked again after
+// GreylistDelay instead of holding a connection slot while it waits.
+func (p *SMTPProbe) Verify(ctx context.Context, email string) (VerificationResult, error) {
+	_, domain, ok := splitAddress(email)
+	if !ok {
+		return verificationResult(VerificationInvalid, "malformed address"), nil
+	}
+
+	hosts, res, err := p.mxHosts(ctx, domain)
+	if err != nil || res != nil {
+		if res != nil {
+			return *res, nil
+		}
+		return VerificationResult{}, err
+	}
+
+	var lastErr error
+	for _, host := range hosts {
+		res, err := p.probe(ctx, host, email)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+		if errors.Is(err, errGreylisted) {
+			// Same MX pool: the other hosts would greylist too.
+			return VerificationResult{}, &DeferredError{RetryAfter: p.cfg.GreylistDelay, Reason: err.Error()}
+		}
+	}
+	return VerificationResult{}, lastErr
+}
+
+// mxHosts resolves the hosts to probe in preference order. Domains that
+// cannot receive mail yield an invalid result instead.
+func (p *SMTPProbe) mxHosts(ctx context.Context, domain string) ([]string, *VerificationResult, error) {
+	mxs, err := p.lookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			// No MX records: fall back to the implicit MX (RFC 5321 5.1)
+			// unless the domain does not exist at all.
+			if _, err := p.lookupHost(ctx, domain); err == nil {
+				return []string{domain}, nil, nil
+			}
+			res := verificationResult(VerificationInvalid, "domain has no mail servers")
+			return nil, &res, nil
+		}
+		return nil, nil, fmt.Errorf("lookup MX for %s: %w", domain, err)
+	}
+	if len(mxs) == 0 {
+		return []string{domain}, nil, nil
+	}
+	if len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == "") {
+		res := verificationResult(VerificationInvalid, "domain publishes a null MX")
+		return nil, &res, nil
+	}
+	hosts := make([]string, 0, len(mxs))
+	for _, mx := range mxs {
+		hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+	}
+	return hosts, nil, nil
+}
+
+// probe runs one SMTP conversation with host. It returns errGreylisted for
+// 4xx replies and other errors when the host could not be asked.
+func (p *SMTPProbe) probe(ctx context.Context, host, email string) (VerificationResult, error) {
+	release, err := p.acquire(ctx, host)
+	if err != nil {
+		return VerificationResult{}, err
+	}
+	defer release()
+
+	conn, err := p.dial(ctx, net.JoinHostPort(host, strconv.Itoa(p.cfg.Port)))
+	if err != nil {
+		return VerificationResult{}, err
+	}
+	conn.SetDeadline(time.Now().Add(p.cfg.Timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return VerificationResult{}, smtpReplyError(err)
+	}
+	defer c.Close()
+	defer c.Quit()
+
+	if err := c.Hello(p.cfg.HeloName); err != nil {
+		return VerificationResult{}, smtpReplyError(err)
+	}
+	if err := c.Mail(p.cfg.MailFrom); err != nil {
+		if err := smtpReplyError(err); errors.Is(err, errGreylisted) {
+			return VerificationResult{}, err
+		}
+		// The MX refuses our sender, which says nothing about the address.
+		return verificationResult(VerificationUnknown, err.Error()), nil
+	}
+
+	if err := c.Rcpt(email); err != nil {
+		var tpErr *textproto.Error
+		if !errors.As(err, &tpErr) || tpErr.Code < 500 {
+			return VerificationResult{}, smtpReplyError(err)
+		}
+		reply := strconv.Itoa(tpErr.Code) + " " + tpErr.Msg
+		switch smtputil.Classify(reply).Category {
+		case smtputil.CategoryBadMailbox, smtputil.CategoryDNSFailure:
+			return verificationResult(VerificationInvalid, reply), nil
+		default:
+			// Policy, reputation and quota rejections don't prove the
+			// mailbox is missing.
+			return verificationResult(VerificationUnknown, reply), nil
+		}
+	}
+
+	_, domain, _ := splitAddress(email)
+	if err := c.Rcpt(randomLocalpart() + "@" + domain); err == nil {
+		return verificationResult(VerificationCatchAll, "random recipient accepted"), nil
+	}
+	c.Reset()
+	return verificationResult(VerificationValid, "recipient accepted"), nil
+}
+
+// acquire takes a connection slot for host, capping concurrent probes per
+// MX so a large list on one provider doesn't look like an attack.
+func (p *SMTPProbe) acquire(ctx context.Context, host string) (func(), error) {
+	host = strings.ToLower(host)
+	p.mu.Lock()
+	sem, ok := p.sems[host]
+	if !ok {
+		sem = make(chan struct{}, p.cfg.PerMXLimit)
+		p.sems[host] = sem
+	}
+	p.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// smtpReplyError wraps 4xx replies in errGreylisted.
+func smtpReplyError(err error) error {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 400 && tpErr.Code < 500 {
+		return fmt.Errorf("%w: %d %s", errGreylisted, tpErr.Code, tpErr.Msg)
+	}
+	return err
+}
+
+func randomLocalpart() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "verify-" + hex.EncodeToString(b)
+}
+
+// splitAddress splits an address into its lowercased local part and domain.
+func splitAddress(email string) (local, domain string, ok bool) {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 || at == len(email)-1 {
+		return "", "", false
+	}
+	return strings.ToLower(email[:at]), strings.ToLower(email[at+1:]), true
+}
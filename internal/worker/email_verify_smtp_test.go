@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is a local MX. rcpt decides the reply to each RCPT TO by
+// connection number (starting at 1) and address.
+type fakeSMTP struct {
+	ln   net.Listener
+	rcpt func(conn int, addr string) string
+
+	mu       sync.Mutex
+	conns    int
+	commands []string
+}
+
+func newFakeSMTP(t *testing.T, rcpt func(conn int, addr string) string) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSMTP{ln: ln, rcpt: rcpt}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			n := s.conns
+			s.mu.Unlock()
+			go s.serve(conn, n)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn, n int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 mx.example.org ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.Fields(line)[0])
+		switch verb {
+		case "EHLO":
+			fmt.Fprint(conn, "250-mx.example.org\r\n250 8BITMIME\r\n")
+		case "RCPT":
+			addr := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			fmt.Fprintf(conn, "%s\r\n", s.rcpt(n, addr))
+		case "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 OK\r\n")
+		}
+	}
+}
+
+func (s *fakeSMTP) probe(mx ...*net.MX) *SMTPProbe {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	var p int
+	fmt.Sscan(port, &p)
+	probe := NewSMTPProbe(SMTPProbeConfig{MailFrom: "verify@example.com", Port: p, GreylistDelay: time.Millisecond})
+	if len(mx) == 0 {
+		mx = []*net.MX{{Host: "127.0.0.1.", Pref: 10}}
+	}
+	probe.lookupMX = func(ctx context.Context, domain string) ([]*net.MX, error) { return mx, nil }
+	return probe
+}
+
+// onlyMailbox accepts one address and rejects every other.
+func onlyMailbox(addr string) func(int, string) string {
+	return func(_ int, rcpt string) string {
+		if rcpt == addr {
+			return "250 2.1.5 OK"
+		}
+		return "550 5.1.1 The email account that you tried to reach does not exist"
+	}
+}
+
+func TestSMTPProbe_Valid(t *testing.T) {
+	s := newFakeSMTP(t, onlyMailbox("jane@example.org"))
+	res, err := s.probe().Verify(context.Background(), "jane@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, VerificationValid, res.Status)
+	assert.Equal(t, 0.50, res.Score)
+
+	for _, cmd := range s.commands {
+		assert.NotEqual(t, "DATA", cmd, "the probe must never send a message")
+	}
+	assert.Contains(t, s.commands, "MAIL FROM:<verify@example.com> BODY=8BITMIME")
+}
+
+func TestSMTPProbe_UnknownMailbox(t *testing.T) {
+	s := newFakeSMTP(t, onlyMailbox("jane@example.org"))
+	res, err := s.probe().Verify(context.Background(), "john@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, VerificationInvalid, res.Status)
+	assert.Equal(t, 0.0, res.Score)
+	assert.Contains(t, res.Reason, "550 5.1.1")
+}
+
+func TestSMTPProbe_CatchAll(t *testing.T) {
+	s := newFakeSMTP(t, func(int, string) string { return "250 2.1.5 OK" })
+	res, err := s.probe().Verify(context.Background(), "anyone@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, VerificationCatchAll, res.Status)
+	assert.Equal(t, 0.30, res.Score)
+}
+
+func TestSMTPProbe_PolicyRejectionIsNotInvalid(t *testing.T) {
+	s := newFakeSMTP(t, func(int, string) string {
+		return "554 5.7.1 Service unavailable; Client host blocked using zen.spamhaus.org"
+	})
+	res, err := s.probe().Verify(context.Background(), "jane@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, VerificationUnknown, res.Status)
+	assert.Equal(t, 0.25, res.Score)
+}
+
+func TestSMTPProbe_GreylistDefers(t *testing.T) {
+	s := newFakeSMTP(t, func(int, string) string { return "451 4.7.1 Greylisted, please try again later" })
+	mx := []*net.MX{{Host: "127.0.0.1.", Pref: 10}, {Host: "localhost.", Pref: 20}}
+	_, err := s.probe(mx...).Verify(context.Background(), "jane@example.org")
+
+	var deferred *DeferredError
+	require.ErrorAs(t, err, &deferred)
+	assert.Equal(t, time.Millisecond, deferred.RetryAfter)
+	assert.Contains(t, deferred.Reason, "451 4.7.1")
+	assert.Equal(t, 1, s.conns, "greylisting is not retried inline or on the next MX")
+}
+
+func TestSMTPProbe_FallsBackToNextMX(t *testing.T) {
+	s := newFakeSMTP(t, onlyMailbox("jane@example.org"))
+	p := s.probe(&net.MX{Host: "mx1.invalid.", Pref: 5}, &net.MX{Host: "127.0.0.1.", Pref: 10})
+	dial := p.dial
+	p.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		if strings.HasPrefix(addr, "mx1.invalid:") {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return dial(ctx, addr)
+	}
+	res, err := p.Verify(context.Background(), "jane@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, VerificationValid, res.Status)
+}
+
+func TestSMTPProbe_DomainWithoutMail(t *testing.T) {
+	p := NewSMTPProbe(SMTPProbeConfig{})
+	p.lookupMX = func(ctx context.Context, domain string) ([]*net.MX, error) {
+		if domain == "null.example" {
+			return []*net.MX{{Host: ".", Pref: 0}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	p.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	p.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		t.Fatalf("unexpected dial to %s", addr)
+		return nil, nil
+	}
+
+	for _, email := range []string{"a@null.example", "a@nxdomain.example", "not-an-address"} {
+		res, err := p.Verify(context.Background(), email)
+		require.NoError(t, err)
+		assert.Equal(t, VerificationInvalid, res.Status, email)
+	}
+
+	p.lookupMX = func(ctx context.Context, domain string) ([]*net.MX, error) {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: domain, IsTemporary: true}
+	}
+	_, err := p.Verify(context.Background(), "a@example.org")
+	assert.Error(t, err, "DNS trouble is retried, not scored")
+}
+
+func TestSMTPProbe_PerMXLimit(t *testing.T) {
+	p := NewSMTPProbe(SMTPProbeConfig{PerMXLimit: 1})
+	release, err := p.acquire(context.Background(), "MX.example.org")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = p.acquire(ctx, "mx.example.org")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	other, err := p.acquire(context.Background(), "mx2.example.org")
+	require.NoError(t, err, "other MX hosts have their own slots")
+	other()
+
+	release()
+	again, err := p.acquire(context.Background(), "mx.example.org")
+	require.NoError(t, err)
+	again()
+}
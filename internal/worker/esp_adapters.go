@@ -9,7 +9,11 @@
 //   - esp_profile.go:   Database-driven profile resolver that delegates to the above
 package worker
 
-import "context"
+import (
+	"context"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
+)
 
 // BatchSender extends ESPSender for adapters that support multi-recipient
 // sends in a single API call. Mailgun and SendGrid implement this.
@@ -26,3 +30,15 @@ type BatchSendResult struct {
 	Results        []SendResult
 	Errors         []error
 }
+
+// failedSendResult builds the result for a message the ESP rejected. The
+// error text is run through the shared smtputil classifier so the send
+// worker can decide between retrying, throttling and suppressing.
+func failedSendResult(espType string, err error) *SendResult {
+	return &SendResult{
+		Success: false,
+		Error:   err,
+		ESPType: espType,
+		Bounce:  smtputil.Classify(err.Error()),
+	}
+}
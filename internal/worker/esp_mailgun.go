@@ -75,7 +75,7 @@ func (s *MailgunSender) Send(ctx context.Context, msg *EmailMessage) (*SendResul
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		return &SendResult{Success: false, Error: fmt.Errorf("Mailgun error %d: %s", resp.StatusCode, string(body)), ESPType: "mailgun"}, nil
+		return failedSendResult("mailgun", fmt.Errorf("Mailgun error %d: %s", resp.StatusCode, string(body))), nil
 	}
 
 	var result struct {
@@ -165,7 +165,7 @@ func (s *MailgunSender) SendBatch(ctx context.Context, messages []EmailMessage)
 		result.Rejected = len(messages)
 		result.Errors = append(result.Errors, errMsg)
 		for i := range messages {
-			result.Results[i] = SendResult{Success: false, Error: errMsg, ESPType: "mailgun"}
+			result.Results[i] = *failedSendResult("mailgun", errMsg)
 		}
 		return result, nil
 	}
@@ -5,12 +5,14 @@ import (
 	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"mime/quotedprintable"
 	"net"
 	"net/http"
 	"net/smtp"
+	"net/textproto"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -241,6 +243,7 @@ type PMTASender struct {
 
 	connPool *smtpPool
 	ipPool   *vmtaPool
+	dkim     DKIMSigner
 }
 
 func NewPMTASender(smtpHost string, smtpPort int, username, password string, db *sql.DB) *PMTASender {
@@ -256,6 +259,12 @@ func NewPMTASender(smtpHost string, smtpPort int, username, password string, db
 	}
 }
 
+// SetDKIMSigner signs messages before they are handed to PMTA. PMTA's own
+// domain-key signing still applies on top.
+func (s *PMTASender) SetDKIMSigner(signer DKIMSigner) {
+	s.dkim = signer
+}
+
 func (s *PMTASender) Send(ctx context.Context, msg *EmailMessage) (*SendResult, error) {
 	if s.smtpHost == "" {
 		return nil, fmt.Errorf("PMTA SMTP host not configured")
@@ -288,6 +297,9 @@ func (s *PMTASender) Send(ctx context.Context, msg *EmailMessage) (*SendResult,
 		msgDomain = parts[1]
 	}
 	messageID := fmt.Sprintf("%s@%s", uuid.New().String(), msgDomain)
+	if msg.MessageID != "" {
+		messageID = msg.MessageID
+	}
 
 	var headerBuf bytes.Buffer
 	headerBuf.WriteString(fmt.Sprintf("From: %s <%s>\r\n", msg.FromName, msg.FromEmail))
@@ -349,6 +361,14 @@ func (s *PMTASender) Send(ctx context.Context, msg *EmailMessage) (*SendResult,
 	bodyBuf.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
 
 	fullMessage := headerBuf.String() + bodyBuf.String()
+	if s.dkim != nil {
+		signed, err := s.dkim.Sign(ctx, msgDomain, msg.DKIMSelector, []byte(fullMessage))
+		if err != nil {
+			log.Printf("[PMTASender] DKIM signing failed for %s, sending unsigned: %v", msgDomain, err)
+		} else {
+			fullMessage = string(signed)
+		}
+	}
 
 	// Get a pooled SMTP connection and send
 	smtpClient, err := s.connPool.get(ctx)
@@ -358,8 +378,12 @@ func (s *PMTASender) Send(ctx context.Context, msg *EmailMessage) (*SendResult,
 
 	sendErr := s.sendOnClient(smtpClient, msg.FromEmail, msg.Email, []byte(fullMessage))
 	if sendErr != nil {
-		// Connection is likely dead; discard it and retry once with a fresh one
+		// Connection is likely dead; discard it and retry once with a fresh
+		// one, unless PMTA may already have the message.
 		smtpClient.Close()
+		if errors.Is(sendErr, ErrSendUnconfirmed) {
+			return nil, fmt.Errorf("PMTA SMTP send: %w", sendErr)
+		}
 		smtpClient, err = s.connPool.dial(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("PMTA SMTP reconnect failed: %w", err)
@@ -411,6 +435,12 @@ func (s *PMTASender) sendOnClient(client *smtp.Client, from, to string, msg []by
 		return fmt.Errorf("write: %w", err)
 	}
 	if err := w.Close(); err != nil {
+		var reply *textproto.Error
+		if !errors.As(err, &reply) {
+			// The final "." went out but PMTA's reply never came back: it
+			// may have queued the message.
+			return fmt.Errorf("DATA close: %w: %w", ErrSendUnconfirmed, err)
+		}
 		return fmt.Errorf("DATA close: %w", err)
 	}
 	return nil
@@ -6,10 +6,12 @@ import (
 	"crypto/tls"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/quotedprintable"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -53,6 +55,9 @@ func (s *PMTAAPISender) Send(ctx context.Context, msg *EmailMessage) (*SendResul
 		msgDomain = parts[1]
 	}
 	messageID := fmt.Sprintf("%s@%s", uuid.New().String(), msgDomain)
+	if msg.MessageID != "" {
+		messageID = msg.MessageID
+	}
 
 	// Build RFC822 message
 	boundary := fmt.Sprintf("=_%s", uuid.New().String()[:16])
@@ -143,7 +148,12 @@ func (s *PMTAAPISender) Send(ctx context.Context, msg *EmailMessage) (*SendResul
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("PMTA API request to %s: %w", injectURL, err)
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, fmt.Errorf("PMTA API request to %s: %w", injectURL, err)
+		}
+		// The request may have reached PMTA before the connection failed.
+		return nil, fmt.Errorf("PMTA API request to %s: %w: %w", injectURL, ErrSendUnconfirmed, err)
 	}
 	defer resp.Body.Close()
 
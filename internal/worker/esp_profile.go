@@ -22,6 +22,7 @@ type ProfileBasedSender struct {
 	db          *sql.DB
 	senderCache map[string]ESPSender
 	mu          sync.RWMutex
+	dkim        DKIMSigner
 }
 
 // NewProfileBasedSender creates a profile-based sender that reads
@@ -33,6 +34,21 @@ func NewProfileBasedSender(db *sql.DB) *ProfileBasedSender {
 	}
 }
 
+// SetDKIMSigner signs messages on the SMTP paths (PMTA). Call before the
+// first Send; senders already cached are not updated.
+func (s *ProfileBasedSender) SetDKIMSigner(signer DKIMSigner) {
+	s.dkim = signer
+}
+
+// newPMTASender creates a PMTA SMTP sender with the configured DKIM signer.
+func (s *ProfileBasedSender) newPMTASender(host string, port int, user, pass string) *PMTASender {
+	sender := NewPMTASender(host, port, user, pass, s.db)
+	if s.dkim != nil {
+		sender.SetDKIMSigner(s.dkim)
+	}
+	return sender
+}
+
 // Send looks up the sending profile for the message, creates the
 // appropriate ESP sender, and delegates delivery.
 func (s *ProfileBasedSender) Send(ctx context.Context, msg *EmailMessage) (*SendResult, error) {
@@ -112,14 +128,14 @@ func (s *ProfileBasedSender) Send(ctx context.Context, msg *EmailMessage) (*Send
 			return s.getCachedSender(msg.ProfileID+":pmta-combo", func() ESPSender {
 				return &pmtaComboSender{
 					apiSender:  NewPMTAAPISender(apiURL, s.db),
-					smtpSender: NewPMTASender(host, port, user, pass, s.db),
+					smtpSender: s.newPMTASender(host, port, user, pass),
 				}
 			}).Send(ctx, msg)
 		}
 		// SMTP only (no HTTP API endpoint configured)
 		if host != "" {
 			return s.getCachedSender(msg.ProfileID+":pmta-smtp", func() ESPSender {
-				return NewPMTASender(host, port, user, pass, s.db)
+				return s.newPMTASender(host, port, user, pass)
 			}).Send(ctx, msg)
 		}
 		// HTTP API only (no SMTP host)
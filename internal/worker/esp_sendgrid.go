@@ -91,7 +91,7 @@ func (s *SendGridSender) Send(ctx context.Context, msg *EmailMessage) (*SendResu
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		return &SendResult{Success: false, Error: fmt.Errorf("SendGrid error %d: %s", resp.StatusCode, string(body)), ESPType: "sendgrid"}, nil
+		return failedSendResult("sendgrid", fmt.Errorf("SendGrid error %d: %s", resp.StatusCode, string(body))), nil
 	}
 
 	messageID := resp.Header.Get("X-Message-Id")
@@ -184,7 +184,7 @@ func (s *SendGridSender) SendBatch(ctx context.Context, messages []EmailMessage)
 		result.Rejected = len(messages)
 		result.Errors = append(result.Errors, errMsg)
 		for i := range messages {
-			result.Results[i] = SendResult{Success: false, Error: errMsg, ESPType: "sendgrid"}
+			result.Results[i] = *failedSendResult("sendgrid", errMsg)
 		}
 		return result, nil
 	}
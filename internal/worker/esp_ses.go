@@ -86,7 +86,7 @@ func (s *SESSender) Send(ctx context.Context, msg *EmailMessage) (*SendResult, e
 	result, err := s.client.SendEmail(ctx, input)
 	if err != nil {
 		log.Printf("[SES] Failed to send to %s: %v", msg.Email, err)
-		return &SendResult{Success: false, Error: err, ESPType: "ses"}, nil
+		return failedSendResult("ses", err), nil
 	}
 
 	messageID := ""
@@ -121,7 +121,7 @@ func (s *SESSender) SendBatch(ctx context.Context, messages []EmailMessage) (*Ba
 	for i, msg := range messages {
 		result, err := s.Send(ctx, &msg)
 		if err != nil {
-			results.Results[i] = SendResult{Success: false, Error: err, ESPType: "ses"}
+			results.Results[i] = *failedSendResult("ses", err)
 			results.Rejected++
 		} else {
 			results.Results[i] = *result
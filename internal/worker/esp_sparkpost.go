@@ -74,10 +74,7 @@ func (s *SparkPostSender) Send(ctx context.Context, msg *EmailMessage) (*SendRes
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		return &SendResult{
-			Success: false,
-			Error:   fmt.Errorf("SparkPost error %d: %s", resp.StatusCode, string(body)),
-		}, nil
+		return failedSendResult("sparkpost", fmt.Errorf("SparkPost error %d: %s", resp.StatusCode, string(body))), nil
 	}
 
 	var result struct {
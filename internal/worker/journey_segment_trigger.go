@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
+)
+
+// Journey trigger types fired by segment membership changes. A journey uses
+// one either through its trigger_type/segment_id columns or through the
+// trigger node config ({"triggerType": "segment_entry", "segmentId": ...}).
+const (
+	JourneyTriggerSegmentEntry = "segment_entry"
+	JourneyTriggerSegmentExit  = "segment_exit"
+)
+
+// SegmentJourneyTrigger enrolls subscribers into active journeys when they
+// enter or leave the journey's trigger segment. The JourneyExecutor picks the
+// enrollments up like any other.
+type SegmentJourneyTrigger struct {
+	db *sql.DB
+}
+
+func NewSegmentJourneyTrigger(db *sql.DB) *SegmentJourneyTrigger {
+	return &SegmentJourneyTrigger{db: db}
+}
+
+// HandleMembershipEvent is a segmentation.MembershipListener.
+func (t *SegmentJourneyTrigger) HandleMembershipEvent(ctx context.Context, ev segmentation.MembershipEvent) {
+	if ev.Email == "" {
+		return
+	}
+	triggerType := JourneyTriggerSegmentEntry
+	if ev.Type == segmentation.SegmentExited {
+		triggerType = JourneyTriggerSegmentExit
+	}
+
+	rows, err := t.db.QueryContext(ctx, `
+		SELECT id, nodes FROM mailing_journeys
+		WHERE status = 'active'
+		  AND (organization_id = $3 OR organization_id IS NULL)
+		  AND ((trigger_type = $2 AND segment_id::text = $1)
+		    OR EXISTS (
+				SELECT 1 FROM jsonb_array_elements(COALESCE(nodes, '[]'::jsonb)) n
+				WHERE n->>'type' = 'trigger'
+				  AND n->'config'->>'triggerType' = $2
+				  AND n->'config'->>'segmentId' = $1))
+	`, ev.SegmentID.String(), triggerType, ev.OrganizationID)
+	if err != nil {
+		log.Printf("SegmentJourneyTrigger: journey lookup failed: %v", err)
+		return
+	}
+	type journey struct {
+		id    string
+		nodes []JourneyNodeExec
+	}
+	var journeys []journey
+	for rows.Next() {
+		var j journey
+		var nodesJSON []byte
+		if err := rows.Scan(&j.id, &nodesJSON); err != nil {
+			continue
+		}
+		json.Unmarshal(nodesJSON, &j.nodes)
+		journeys = append(journeys, j)
+	}
+	rows.Close()
+
+	for _, j := range journeys {
+		firstNodeID := ""
+		for _, node := range j.nodes {
+			if node.Type != "trigger" {
+				firstNodeID = node.ID
+				break
+			}
+		}
+		enrollmentID := fmt.Sprintf("enroll-%s", uuid.New().String()[:8])
+		res, err := t.db.ExecContext(ctx, `
+			INSERT INTO mailing_journey_enrollments (id, journey_id, subscriber_email, current_node_id, status, enrolled_at)
+			VALUES ($1, $2, $3, $4, 'active', NOW())
+			ON CONFLICT (journey_id, subscriber_email) DO NOTHING
+		`, enrollmentID, j.id, ev.Email, firstNodeID)
+		if err != nil {
+			log.Printf("SegmentJourneyTrigger: enroll %s in %s failed: %v", ev.Email, j.id, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("SegmentJourneyTrigger: enrolled %s in journey %s (segment %s %s)", ev.Email, j.id, ev.SegmentID, ev.Type)
+		}
+	}
+}
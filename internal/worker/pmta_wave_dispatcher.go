@@ -152,18 +152,24 @@ func EnqueuePMTAWave(ctx context.Context, db *sql.DB, waveID string) (int, error
 				sourceID = parsed
 			}
 		}
+		// The key is what the send ledger deduplicates on if the wave is
+		// ever enqueued or delivered twice.
+		key := SendKey{CampaignID: campaignID.String(), SubscriberID: rec.subscriberID.String(), Variant: v.VariantName, WaveID: waveID}
 		if _, err := tx.ExecContext(ctx, `
 			INSERT INTO mailing_campaign_queue (
 				id, campaign_id, subscriber_id, subject, html_content, plain_content,
 				status, priority, scheduled_at, created_at, isp_plan_id, wave_id,
-				recipient_isp, selection_rank, audience_source_type, audience_source_id
+				recipient_isp, selection_rank, audience_source_type, audience_source_id,
+				variant_name, idempotency_key
 			) VALUES (
 				$1, $2, $3, $4, $5, '',
 				'queued', 5, $6, NOW(), $7, $8,
-				$9, $10, $11, $12
+				$9, $10, $11, $12,
+				$13, $14
 			)
 		`, uuid.New(), campaignID, rec.subscriberID, coalesceWaveValue(v.Subject, campaignSubject.String), coalesceWaveValue(v.HTMLContent, campaignHTML.String),
 			scheduledAt, ispPlanID, waveID, rec.recipientISP, rec.selectionRank, rec.audienceSourceType, sourceID,
+			v.VariantName, key.String(),
 		); err != nil {
 			return 0, err
 		}
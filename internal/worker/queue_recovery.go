@@ -3,6 +3,7 @@ package worker
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 )
@@ -15,6 +16,13 @@ import (
 // scans for such items and either requeues them (if under the retry limit)
 // or moves them to 'dead_letter' status.
 //
+// Items in 'submitting' were recorded in the send ledger and may have been
+// accepted by the ESP, so they are never requeued blindly: the ledger, the
+// ESP/PMTA accounting stamped on it and mailing_message_log, all matched by
+// message ID, decide whether the item was sent. Without a recorded
+// rejection an unresolved item is parked, never sent again (see
+// reconcileSubmitting).
+//
 // Covers both queue tables:
 //   - mailing_campaign_queue   (CampaignProcessor / SendWorkerPool v1)
 //   - mailing_campaign_queue_v2 (SendWorkerPoolV2)
@@ -30,22 +38,28 @@ const (
 	// MaxRetryCount is the maximum number of times an item can be retried
 	// before it is moved to dead_letter status.
 	MaxRetryCount = 5
+
+	// DefaultAccountingGrace is how long a submitted send may go without an
+	// ESP acceptance or accounting record before its item is parked.
+	DefaultAccountingGrace = time.Hour
 )
 
 // QueueRecoveryWorker periodically reclaims stuck queue items and enforces
 // a maximum retry limit by moving permanently failed items to dead_letter.
 type QueueRecoveryWorker struct {
-	db       *sql.DB
-	interval time.Duration // check every 2 minutes by default
-	staleAge time.Duration // items claimed > 5 minutes ago are stuck
+	db              *sql.DB
+	interval        time.Duration // check every 2 minutes by default
+	staleAge        time.Duration // items claimed > 5 minutes ago are stuck
+	accountingGrace time.Duration // submitted sends without accounting after 1 hour are parked
 }
 
 // NewQueueRecoveryWorker creates a new recovery worker with default settings.
 func NewQueueRecoveryWorker(db *sql.DB) *QueueRecoveryWorker {
 	return &QueueRecoveryWorker{
-		db:       db,
-		interval: DefaultRecoveryInterval,
-		staleAge: DefaultStaleAge,
+		db:              db,
+		interval:        DefaultRecoveryInterval,
+		staleAge:        DefaultStaleAge,
+		accountingGrace: DefaultAccountingGrace,
 	}
 }
 
@@ -58,9 +72,10 @@ func NewQueueRecoveryWorkerWithConfig(db *sql.DB, interval, staleAge time.Durati
 		staleAge = DefaultStaleAge
 	}
 	return &QueueRecoveryWorker{
-		db:       db,
-		interval: interval,
-		staleAge: staleAge,
+		db:              db,
+		interval:        interval,
+		staleAge:        staleAge,
+		accountingGrace: DefaultAccountingGrace,
 	}
 }
 
@@ -83,9 +98,10 @@ func (qr *QueueRecoveryWorker) Start(ctx context.Context) {
 	}
 }
 
-// recoverStuckItems performs two passes on each queue table:
+// recoverStuckItems performs three passes on each queue table:
 //  1. Requeue items that have been claimed too long but are under the retry limit.
 //  2. Move items that have exceeded the retry limit to dead_letter.
+//  3. Settle submitted items from the send ledger (reconcileSubmitting).
 func (qr *QueueRecoveryWorker) recoverStuckItems(ctx context.Context) {
 	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
@@ -157,4 +173,92 @@ func (qr *QueueRecoveryWorker) recoverStuckItems(ctx context.Context) {
 	} else if n, _ := res.RowsAffected(); n > 0 {
 		log.Printf("[QueueRecovery] v2: moved %d items to dead_letter", n)
 	}
+
+	// ── Submitted sends, both queues ─────────────────────────────────────
+
+	for _, table := range []string{QueueTableV1, QueueTableV2} {
+		qr.reconcileSubmitting(queryCtx, table)
+	}
+}
+
+// reconcileSubmitting settles items left in 'submitting' by a worker that
+// died or timed out around the ESP handoff, using their send ledger entry:
+//   - sent, accounted by an ESP/PMTA event, or found in mailing_message_log
+//     by its message ID: the recipient has the message, so the item is
+//     marked sent;
+//   - failed: the ESP rejected it, so the item is requeued;
+//   - still submitting with no such evidence after the grace period: the
+//     item is parked for an operator, who lists it with SendLedger.Parked
+//     and settles it with SendLedger.ResolveParked. Its entry stays
+//     submitting, so late accounting still settles it as sent.
+//
+// Anything else is ambiguous and left alone until the accounting arrives.
+// An item is only ever sent again on a recorded rejection.
+func (qr *QueueRecoveryWorker) reconcileSubmitting(ctx context.Context, table string) {
+	res, err := qr.db.ExecContext(ctx, fmt.Sprintf(`
+		WITH accepted AS (
+			UPDATE mailing_send_ledger l
+			SET state = 'sent', sent_at = COALESCE(l.sent_at, l.accounted_at, NOW()), updated_at = NOW()
+			FROM %[1]s q
+			WHERE q.status IN ('submitting', 'parked')
+			  AND q.idempotency_key = l.idempotency_key
+			  AND l.submitted_at < NOW() - $1::interval
+			  AND (l.state = 'sent'
+			       OR l.accounted_at IS NOT NULL
+			       OR EXISTS (SELECT 1 FROM mailing_message_log ml
+			                  WHERE ml.message_id IN (l.message_id, '<' || l.message_id || '>', l.esp_message_id)))
+			RETURNING l.idempotency_key, COALESCE(l.esp_message_id, l.message_id) AS message_id, l.sent_at
+		)
+		UPDATE %[1]s q
+		SET status = 'sent', message_id = a.message_id, sent_at = a.sent_at
+		FROM accepted a
+		WHERE q.idempotency_key = a.idempotency_key AND q.status IN ('submitting', 'parked')
+	`, table), qr.staleAge.String())
+	if err != nil {
+		log.Printf("[QueueRecovery] %s settle sent error: %v", table, err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[QueueRecovery] %s: marked %d submitted items sent from the send ledger", table, n)
+	}
+
+	res, err = qr.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %[1]s q
+		SET status = CASE WHEN q.retry_count + 1 >= $2 THEN 'dead_letter' ELSE 'queued' END,
+		    worker_id = NULL,
+		    claimed_at = NULL,%[2]s
+		    retry_count = q.retry_count + 1
+		FROM mailing_send_ledger l
+		WHERE q.status IN ('submitting', 'parked')
+		  AND q.idempotency_key = l.idempotency_key
+		  AND l.state = 'failed'
+		  AND l.updated_at < NOW() - $1::interval
+	`, table, lockedAtReset(table)), qr.staleAge.String(), MaxRetryCount)
+	if err != nil {
+		log.Printf("[QueueRecovery] %s requeue rejected error: %v", table, err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[QueueRecovery] %s: requeued %d submitted items the ESP rejected", table, n)
+	}
+
+	res, err = qr.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %[1]s q
+		SET status = 'parked'
+		FROM mailing_send_ledger l
+		WHERE q.status = 'submitting'
+		  AND q.idempotency_key = l.idempotency_key
+		  AND l.state = 'submitting'
+		  AND l.accounted_at IS NULL
+		  AND l.submitted_at < NOW() - $1::interval
+	`, table), qr.accountingGrace.String())
+	if err != nil {
+		log.Printf("[QueueRecovery] %s park unconfirmed error: %v", table, err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[QueueRecovery] %s: parked %d submitted items with no ESP acceptance or accounting", table, n)
+	}
+}
+
+// lockedAtReset clears the v1 queue's locked_at, which v2 does not have.
+func lockedAtReset(table string) string {
+	if table == QueueTableV1 {
+		return "\n\t\t    locked_at = NULL,"
+	}
+	return ""
 }
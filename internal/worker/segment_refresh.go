@@ -10,16 +10,20 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/segmentation"
 )
 
 // SegmentRefreshWorker periodically recalculates subscriber_count for all
 // active dynamic segments. Without this, time-relative conditions like
-// "last_open_at within_last 7 days" would show stale counts.
+// "last_open_at within_last 7 days" would show stale counts. With a
+// membership engine set, it also seeds and recomputes the memberships of
+// realtime and hybrid segments.
 type SegmentRefreshWorker struct {
-	db       *sql.DB
-	interval time.Duration
-	stopChan chan struct{}
-	running  bool
+	db         *sql.DB
+	interval   time.Duration
+	membership *segmentation.MembershipEngine
+	stopChan   chan struct{}
+	running    bool
 }
 
 func NewSegmentRefreshWorker(db *sql.DB, interval time.Duration) *SegmentRefreshWorker {
@@ -30,6 +34,11 @@ func NewSegmentRefreshWorker(db *sql.DB, interval time.Duration) *SegmentRefresh
 	}
 }
 
+// SetMembership sets the engine that keeps realtime segment memberships.
+func (w *SegmentRefreshWorker) SetMembership(m *segmentation.MembershipEngine) {
+	w.membership = m
+}
+
 func (w *SegmentRefreshWorker) Start(ctx context.Context) {
 	if w.running {
 		return
@@ -76,6 +85,8 @@ type segmentRow struct {
 	SystemQuery    string
 	OrgID          string
 	OldCount       int
+	Realtime       bool
+	Synced         bool
 }
 
 func (w *SegmentRefreshWorker) refreshAll(ctx context.Context) {
@@ -87,7 +98,9 @@ func (w *SegmentRefreshWorker) refreshAll(ctx context.Context) {
 		       COALESCE(s.is_system, false),
 		       COALESCE(s.system_query, ''),
 		       s.organization_id::text,
-		       COALESCE(s.subscriber_count, 0)
+		       COALESCE(s.subscriber_count, 0),
+		       COALESCE(s.calculation_mode IN ('realtime', 'hybrid'), false),
+		       s.memberships_synced_at IS NOT NULL
 		FROM mailing_segments s
 		WHERE s.status = 'active'
 		  AND s.segment_type = 'dynamic'
@@ -103,7 +116,7 @@ func (w *SegmentRefreshWorker) refreshAll(ctx context.Context) {
 	var segments []segmentRow
 	for rows.Next() {
 		var s segmentRow
-		if err := rows.Scan(&s.ID, &s.ListID, &s.Name, &s.ConditionsJSON, &s.IsSystem, &s.SystemQuery, &s.OrgID, &s.OldCount); err != nil {
+		if err := rows.Scan(&s.ID, &s.ListID, &s.Name, &s.ConditionsJSON, &s.IsSystem, &s.SystemQuery, &s.OrgID, &s.OldCount, &s.Realtime, &s.Synced); err != nil {
 			log.Printf("SegmentRefreshWorker: scan error: %v", err)
 			continue
 		}
@@ -116,6 +129,7 @@ func (w *SegmentRefreshWorker) refreshAll(ctx context.Context) {
 
 	updated := 0
 	for _, seg := range segments {
+		w.syncMemberships(ctx, seg)
 		newCount := w.recalculate(ctx, seg)
 		if newCount < 0 {
 			continue
@@ -138,6 +152,28 @@ func (w *SegmentRefreshWorker) refreshAll(ctx context.Context) {
 	log.Printf("SegmentRefreshWorker: refreshed %d/%d segments in %s", updated, len(segments), time.Since(start).Round(time.Millisecond))
 }
 
+// syncMemberships seeds an unseeded realtime segment silently, or recomputes
+// a seeded one and emits the transitions incremental updates missed.
+func (w *SegmentRefreshWorker) syncMemberships(ctx context.Context, seg segmentRow) {
+	if w.membership == nil || !seg.Realtime {
+		return
+	}
+	if !seg.Synced {
+		if err := w.membership.Seed(ctx, seg.ID); err != nil {
+			log.Printf("SegmentRefreshWorker: membership seed error for %s (%s): %v", seg.Name, seg.ID, err)
+		}
+		return
+	}
+	events, err := w.membership.Refresh(ctx, seg.ID)
+	if err != nil {
+		log.Printf("SegmentRefreshWorker: membership refresh error for %s (%s): %v", seg.Name, seg.ID, err)
+		return
+	}
+	if len(events) > 0 {
+		log.Printf("SegmentRefreshWorker: %s — %d membership transitions", seg.Name, len(events))
+	}
+}
+
 // recalculate returns the new subscriber count, or -1 on failure.
 func (w *SegmentRefreshWorker) recalculate(ctx context.Context, seg segmentRow) int {
 	// System segments with a pre-built SQL query
@@ -0,0 +1,275 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// SEND LEDGER — Exactly-Once Sends
+// =============================================================================
+// A queue item can be delivered to a worker again after its message was
+// accepted: the worker dies between the ESP accepting it and markSent, and
+// the QueueRecoveryWorker requeues the row. To stop that second send, every
+// send gets a deterministic idempotency key and is recorded in
+// mailing_send_ledger before the message is handed to the ESP. The queue row
+// moves claimed -> submitting -> sent around the handoff, and a key that is
+// already submitting or sent is never sent again.
+
+// IdempotencyHeader carries a send's idempotency key on the message.
+const IdempotencyHeader = "X-Idempotency-Key"
+
+// Queue tables a ledger entry points back to.
+const (
+	QueueTableV1 = "mailing_campaign_queue"
+	QueueTableV2 = "mailing_campaign_queue_v2"
+)
+
+// Ledger states.
+const (
+	LedgerSubmitting = "submitting"
+	LedgerSent       = "sent"
+	LedgerFailed     = "failed"
+)
+
+// ErrClaimLost is returned by SendLedger.Begin when the queue row is no
+// longer claimed, e.g. because recovery requeued it to another worker.
+var ErrClaimLost = errors.New("send ledger: queue item is no longer claimed")
+
+// ErrSendUnconfirmed marks a send error raised after the message was handed
+// over, e.g. a connection drop after the final "." of DATA. The ESP may have
+// accepted it, so the send must not be retried blindly.
+var ErrSendUnconfirmed = errors.New("send unconfirmed")
+
+// SendKey identifies one intended send: one variant of a campaign to one
+// subscriber in one wave. Variant and WaveID are empty where not used.
+type SendKey struct {
+	CampaignID   string
+	SubscriberID string
+	Variant      string
+	WaveID       string
+}
+
+// String returns the idempotency key, a hash of the key's fields.
+func (k SendKey) String() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{k.CampaignID, k.SubscriberID, k.Variant, k.WaveID}, "|")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// MessageID returns the Message-ID (without angle brackets) every attempt
+// under the key carries, so PMTA accounting and ESP events for any attempt
+// find the same ledger entry.
+func (k SendKey) MessageID(fromEmail string) string {
+	domain := "mail.projectjarvis.io"
+	if at := strings.LastIndex(fromEmail, "@"); at >= 0 && at < len(fromEmail)-1 {
+		domain = strings.ToLower(fromEmail[at+1:])
+	}
+	return k.String() + "@" + domain
+}
+
+// LedgerEntry is the ledger's record of a key.
+type LedgerEntry struct {
+	Key          string
+	State        string
+	QueueTable   string
+	QueueItemID  uuid.UUID
+	MessageID    string
+	ESPMessageID string
+	Attempts     int
+	SubmittedAt  time.Time
+}
+
+// SendLedger records sends in mailing_send_ledger.
+type SendLedger struct {
+	db *sql.DB
+}
+
+// NewSendLedger creates a ledger on db.
+func NewSendLedger(db *sql.DB) *SendLedger {
+	return &SendLedger{db: db}
+}
+
+// Begin records that msg is about to be submitted for the claimed item and
+// moves the item to 'submitting', in one transaction. It stamps msg with the
+// key's Message-ID and IdempotencyHeader. If the key is already submitting
+// or sent, nothing changes and the existing entry is returned: the caller
+// must not send. A key whose last attempt failed is taken over.
+func (l *SendLedger) Begin(ctx context.Context, table string, itemID uuid.UUID, key SendKey, msg *EmailMessage) (*LedgerEntry, error) {
+	if table != QueueTableV1 && table != QueueTableV2 {
+		return nil, fmt.Errorf("send ledger: unknown queue table %q", table)
+	}
+	idemKey := key.String()
+	messageID := key.MessageID(msg.FromEmail)
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var attempts int
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO mailing_send_ledger (idempotency_key, campaign_id, subscriber_id, variant_name, wave_id,
+			queue_table, queue_item_id, esp_type, message_id, state, attempts, submitted_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, '')::uuid, $6, $7, NULLIF($8, ''), $9, 'submitting', 1, NOW(), NOW())
+		ON CONFLICT (idempotency_key) DO UPDATE
+		SET queue_table = EXCLUDED.queue_table,
+		    queue_item_id = EXCLUDED.queue_item_id,
+		    esp_type = EXCLUDED.esp_type,
+		    state = 'submitting',
+		    attempts = mailing_send_ledger.attempts + 1,
+		    esp_message_id = NULL,
+		    last_error = NULL,
+		    accounted_event = NULL,
+		    accounted_at = NULL,
+		    submitted_at = NOW(),
+		    updated_at = NOW()
+		WHERE mailing_send_ledger.state = 'failed'
+		RETURNING attempts
+	`, idemKey, key.CampaignID, key.SubscriberID, key.Variant, key.WaveID,
+		table, itemID, msg.ESPType, messageID).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		prior := &LedgerEntry{Key: idemKey}
+		var espMessageID sql.NullString
+		if err := tx.QueryRowContext(ctx, `
+			SELECT state, queue_table, queue_item_id, message_id, esp_message_id, attempts, submitted_at
+			FROM mailing_send_ledger WHERE idempotency_key = $1
+		`, idemKey).Scan(&prior.State, &prior.QueueTable, &prior.QueueItemID, &prior.MessageID,
+			&espMessageID, &prior.Attempts, &prior.SubmittedAt); err != nil {
+			return nil, fmt.Errorf("send ledger: load %s: %w", idemKey, err)
+		}
+		prior.ESPMessageID = espMessageID.String
+		return prior, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("send ledger: record %s: %w", idemKey, err)
+	}
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s
+		SET status = 'submitting', idempotency_key = $2, message_id = $3
+		WHERE id = $1 AND status = 'claimed'
+	`, table), itemID, idemKey, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrClaimLost
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	msg.MessageID = messageID
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]string)
+	}
+	msg.Headers[IdempotencyHeader] = idemKey
+	return nil, nil
+}
+
+// Complete records that the ESP accepted the message under key.
+func (l *SendLedger) Complete(ctx context.Context, key SendKey, espMessageID string) error {
+	_, err := l.db.ExecContext(ctx, `
+		UPDATE mailing_send_ledger
+		SET state = 'sent', esp_message_id = NULLIF($2, ''), sent_at = NOW(), updated_at = NOW()
+		WHERE idempotency_key = $1
+	`, key.String(), espMessageID)
+	return err
+}
+
+// Fail records that the ESP rejected the message under key, so the next
+// attempt may send it.
+func (l *SendLedger) Fail(ctx context.Context, key SendKey, reason string) error {
+	_, err := l.db.ExecContext(ctx, `
+		UPDATE mailing_send_ledger
+		SET state = 'failed', last_error = $2, updated_at = NOW()
+		WHERE idempotency_key = $1 AND state = 'submitting'
+	`, key.String(), reason)
+	return err
+}
+
+// ErrNotParked is returned by SendLedger.ResolveParked when the key's queue
+// item is not parked, e.g. because late accounting already settled it.
+var ErrNotParked = errors.New("send ledger: send is not parked")
+
+// ParkedSend is a send whose queue item recovery parked: it was submitted,
+// but no ESP acceptance, rejection or accounting ever arrived.
+type ParkedSend struct {
+	Key          string    `json:"idempotency_key"`
+	QueueTable   string    `json:"queue_table"`
+	QueueItemID  uuid.UUID `json:"queue_item_id"`
+	SubscriberID string    `json:"subscriber_id"`
+	MessageID    string    `json:"message_id"`
+	Attempts     int       `json:"attempts"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+}
+
+// parkedItem matches ledger entries l whose queue item is parked.
+const parkedItem = `l.state = 'submitting' AND (
+	EXISTS (SELECT 1 FROM mailing_campaign_queue q WHERE q.idempotency_key = l.idempotency_key AND q.status = 'parked')
+	OR EXISTS (SELECT 1 FROM mailing_campaign_queue_v2 q WHERE q.idempotency_key = l.idempotency_key AND q.status = 'parked'))`
+
+// Parked lists a campaign's parked sends, oldest first.
+func (l *SendLedger) Parked(ctx context.Context, campaignID string) ([]ParkedSend, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT l.idempotency_key, l.queue_table, l.queue_item_id, l.subscriber_id::text,
+		       l.message_id, l.attempts, l.submitted_at
+		FROM mailing_send_ledger l
+		WHERE l.campaign_id = $1 AND `+parkedItem+`
+		ORDER BY l.submitted_at
+	`, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parked := []ParkedSend{}
+	for rows.Next() {
+		var p ParkedSend
+		if err := rows.Scan(&p.Key, &p.QueueTable, &p.QueueItemID, &p.SubscriberID,
+			&p.MessageID, &p.Attempts, &p.SubmittedAt); err != nil {
+			return nil, err
+		}
+		parked = append(parked, p)
+	}
+	return parked, rows.Err()
+}
+
+// ResolveParked records an operator's verdict on a parked send, after
+// checking the ESP's own logs. A send the ESP accepted is marked sent; one
+// it never received is recorded as rejected, so the next recovery pass
+// requeues it. Either way the queue item is settled by
+// QueueRecoveryWorker.reconcileSubmitting, as if the ESP had reported it.
+func (l *SendLedger) ResolveParked(ctx context.Context, campaignID, key string, sent bool) error {
+	res, err := l.db.ExecContext(ctx, `
+		UPDATE mailing_send_ledger l
+		SET state = CASE WHEN $3 THEN 'sent' ELSE 'failed' END,
+		    sent_at = CASE WHEN $3 THEN NOW() END,
+		    last_error = CASE WHEN $3 THEN NULL ELSE 'not received by the ESP (resolved by operator)' END,
+		    updated_at = NOW()
+		WHERE l.idempotency_key = $1 AND l.campaign_id = $2 AND `+parkedItem, key, campaignID, sent)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotParked
+	}
+	return nil
+}
+
+// ambiguousSendError reports whether a send error leaves it unknown if the
+// ESP accepted the message. Such sends stay 'submitting' for recovery to
+// resolve from the accounting.
+func ambiguousSendError(err error) bool {
+	return errors.Is(err, ErrSendUnconfirmed) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
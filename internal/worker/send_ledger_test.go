@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendKey_Deterministic(t *testing.T) {
+	key := SendKey{CampaignID: "c1", SubscriberID: "s1", Variant: "A", WaveID: "w1"}
+	assert.Equal(t, key.String(), SendKey{CampaignID: "c1", SubscriberID: "s1", Variant: "A", WaveID: "w1"}.String())
+	assert.Len(t, key.String(), 32)
+	assert.NotEqual(t, key.String(), SendKey{CampaignID: "c1", SubscriberID: "s1", Variant: "B", WaveID: "w1"}.String())
+	assert.NotEqual(t, key.String(), SendKey{CampaignID: "c1", SubscriberID: "s1", Variant: "A", WaveID: "w2"}.String())
+	assert.Equal(t, key.String()+"@news.example.com", key.MessageID("deals@News.Example.com"))
+	assert.Equal(t, key.String()+"@mail.projectjarvis.io", key.MessageID(""))
+}
+
+func TestSendLedger_Begin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	ledger := NewSendLedger(db)
+	ctx := context.Background()
+
+	itemID := uuid.New()
+	key := SendKey{CampaignID: uuid.NewString(), SubscriberID: uuid.NewString(), Variant: "A", WaveID: uuid.NewString()}
+	messageID := key.MessageID("deals@news.example.com")
+
+	// First delivery: recorded, the row moves to submitting, the message is stamped.
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO mailing_send_ledger").
+		WithArgs(key.String(), key.CampaignID, key.SubscriberID, "A", key.WaveID, QueueTableV1, itemID, "pmta", messageID).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(1))
+	mock.ExpectExec("UPDATE mailing_campaign_queue\\s+SET status = 'submitting'").
+		WithArgs(itemID, key.String(), messageID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	msg := &EmailMessage{FromEmail: "deals@news.example.com", ESPType: "pmta"}
+	prior, err := ledger.Begin(ctx, QueueTableV1, itemID, key, msg)
+	require.NoError(t, err)
+	assert.Nil(t, prior)
+	assert.Equal(t, messageID, msg.MessageID)
+	assert.Equal(t, key.String(), msg.Headers[IdempotencyHeader])
+
+	// Redelivered after the ESP accepted it: the sent entry comes back and
+	// nothing is written.
+	submitted := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO mailing_send_ledger").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("FROM mailing_send_ledger WHERE idempotency_key").WithArgs(key.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"state", "queue_table", "queue_item_id", "message_id", "esp_message_id", "attempts", "submitted_at"}).
+			AddRow(LedgerSent, QueueTableV1, itemID, messageID, nil, 1, submitted))
+	mock.ExpectRollback()
+
+	msg = &EmailMessage{FromEmail: "deals@news.example.com"}
+	prior, err = ledger.Begin(ctx, QueueTableV1, itemID, key, msg)
+	require.NoError(t, err)
+	require.NotNil(t, prior)
+	assert.Equal(t, LedgerSent, prior.State)
+	assert.Equal(t, messageID, prior.MessageID)
+	assert.Empty(t, msg.MessageID)
+
+	// Recovery took the row back in the meantime.
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO mailing_send_ledger").
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(2))
+	mock.ExpectExec("UPDATE mailing_campaign_queue_v2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	_, err = ledger.Begin(ctx, QueueTableV2, itemID, key, &EmailMessage{})
+	assert.ErrorIs(t, err, ErrClaimLost)
+
+	_, err = ledger.Begin(ctx, "mailing_send_queue", itemID, key, &EmailMessage{})
+	assert.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueueRecovery_ReconcilesSubmitting(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	qr := NewQueueRecoveryWorker(db)
+
+	// Accepted, accounted or logged sends are marked sent.
+	mock.ExpectExec("(?s)WITH accepted AS .*l.accounted_at IS NOT NULL.*FROM mailing_message_log ml.*UPDATE mailing_campaign_queue q\\s+SET status = 'sent'").
+		WithArgs("5m0s").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	// Sends the ESP rejected are requeued.
+	mock.ExpectExec("(?s)UPDATE mailing_campaign_queue q.*locked_at = NULL.*l.state = 'failed'").
+		WithArgs("5m0s", MaxRetryCount).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Sends with no evidence either way are parked, not requeued.
+	mock.ExpectExec("(?s)UPDATE mailing_campaign_queue q\\s+SET status = 'parked'").
+		WithArgs("1h0m0s").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	qr.reconcileSubmitting(context.Background(), QueueTableV1)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.NotContains(t, lockedAtReset(QueueTableV2), "locked_at")
+}
+
+func TestSendLedger_ParkedSends(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	ledger := NewSendLedger(db)
+	itemID := uuid.New()
+	submitted := time.Now().Add(-2 * time.Hour)
+
+	mock.ExpectQuery("(?s)FROM mailing_send_ledger l.*l.state = 'submitting'.*mailing_campaign_queue q.*q.status = 'parked'.*mailing_campaign_queue_v2 q").
+		WithArgs("c1").
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_key", "queue_table", "queue_item_id", "subscriber_id", "message_id", "attempts", "submitted_at"}).
+			AddRow("k1", QueueTableV1, itemID, "s1", "k1@news.example.com", 1, submitted))
+	parked, err := ledger.Parked(context.Background(), "c1")
+	require.NoError(t, err)
+	require.Len(t, parked, 1)
+	assert.Equal(t, ParkedSend{Key: "k1", QueueTable: QueueTableV1, QueueItemID: itemID, SubscriberID: "s1",
+		MessageID: "k1@news.example.com", Attempts: 1, SubmittedAt: submitted}, parked[0])
+
+	// An operator's verdict goes to the ledger; recovery settles the item.
+	mock.ExpectExec("(?s)UPDATE mailing_send_ledger l\\s+SET state = CASE WHEN \\$3 THEN 'sent' ELSE 'failed' END.*q.status = 'parked'").
+		WithArgs("k1", "c1", false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, ledger.ResolveParked(context.Background(), "c1", "k1", false))
+
+	mock.ExpectExec("UPDATE mailing_send_ledger l").
+		WithArgs("k2", "c1", true).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, ledger.ResolveParked(context.Background(), "c1", "k2", true), ErrNotParked)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAmbiguousSendError(t *testing.T) {
+	assert.True(t, ambiguousSendError(fmt.Errorf("PMTA SMTP send: DATA close: %w: %w", ErrSendUnconfirmed, io.EOF)))
+	assert.True(t, ambiguousSendError(fmt.Errorf("send: %w", context.DeadlineExceeded)))
+	assert.False(t, ambiguousSendError(fmt.Errorf("DATA close: %w", &textproto.Error{Code: 550, Msg: "rejected"})))
+	assert.False(t, ambiguousSendError(errors.New("PMTA SMTP reconnect failed")))
+}
@@ -0,0 +1,204 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/isp"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSendLimiter(t *testing.T) (*SendLimiter, *miniredis.Miniredis, sqlmock.Sqlmock, *time.Time) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// Default ISP rules, no global limit.
+	config, _ := json.Marshal(AdvancedThrottleConfig{OrgID: "org-1"})
+	mr.Set(fmt.Sprintf(keyConfigCache, "org-1"), string(config))
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	l := NewSendLimiter(client, db)
+	l.now = func() time.Time { return now }
+	return l, mr, mock, &now
+}
+
+func levelState(t *testing.T, s *LimiterSnapshot, level LimitLevel) LevelState {
+	t.Helper()
+	for _, state := range s.Levels {
+		if state.Level == level {
+			return state
+		}
+	}
+	t.Fatalf("no %s level in snapshot", level)
+	return LevelState{}
+}
+
+func TestSendLimiter_ReservesAcrossLevels(t *testing.T) {
+	l, _, _, now := newTestSendLimiter(t)
+	ctx := context.Background()
+	require.NoError(t, l.campaigns.SetThrottle(ctx, "c1", ThrottleCareful, 0)) // 20/min
+
+	scope := SendScope{OrgID: "org-1", ESPType: "sparkpost", Domain: "Gmail.com", CampaignID: "c1"}
+	res, err := l.Reserve(ctx, scope, 15)
+	require.NoError(t, err)
+	assert.True(t, res.Granted)
+
+	res, err = l.Reserve(ctx, scope, 10)
+	require.NoError(t, err)
+	assert.False(t, res.Granted)
+	assert.Equal(t, LimitCampaign, res.Bottleneck)
+	assert.Equal(t, "c1", res.BottleneckID)
+	assert.InDelta(t, 15*time.Second, res.RetryAfter, float64(10*time.Millisecond))
+
+	// The refused batch took nothing from the other levels.
+	snapshot, err := l.Inspect(ctx, scope)
+	require.NoError(t, err)
+	assert.Equal(t, LimitCampaign, snapshot.Bottleneck)
+	assert.InDelta(t, 485, levelState(t, snapshot, LimitISP).Buckets[0].Tokens, 0.01)
+	assert.InDelta(t, 4985, levelState(t, snapshot, LimitDomain).Buckets[0].Tokens, 0.01)
+	assert.Equal(t, []LimitLevel{LimitESP, LimitISP, LimitDomain, LimitCampaign},
+		[]LimitLevel{snapshot.Levels[0].Level, snapshot.Levels[1].Level, snapshot.Levels[2].Level, snapshot.Levels[3].Level})
+
+	*now = now.Add(res.RetryAfter)
+	res, err = l.Reserve(ctx, scope, 10)
+	require.NoError(t, err)
+	assert.True(t, res.Granted)
+
+	res, err = l.Reserve(ctx, scope, 21)
+	assert.ErrorIs(t, err, ErrExceedsBurst)
+	assert.Equal(t, LimitCampaign, res.Bottleneck)
+}
+
+func TestSendLimiter_OverridesAndBackoff(t *testing.T) {
+	l, mr, mock, now := newTestSendLimiter(t)
+	ctx := context.Background()
+
+	scope := SendScope{OrgID: "org-1", IPPool: "warm-pool", Domain: "yahoo.com", CampaignID: "c2"}
+	require.NoError(t, l.SetLimit(ctx, "org-1", LimitIPPool, "warm-pool", []Bucket{WindowBucket(50, time.Minute)}))
+
+	snapshot, err := l.Inspect(ctx, scope)
+	require.NoError(t, err)
+	assert.Equal(t, LimitIPPool, snapshot.Bottleneck)
+	assert.Equal(t, "warm-pool", snapshot.BottleneckID)
+	assert.Equal(t, 50, snapshot.NextMinute)
+	assert.Equal(t, 400, levelState(t, snapshot, LimitISP).Available)
+
+	// Backpressure on the domain stops everything for it.
+	mr.Set(fmt.Sprintf(keyBackoff, "org-1", "yahoo.com"), fmt.Sprint(now.Add(time.Minute).Unix()))
+	res, err := l.Reserve(ctx, scope, 1)
+	require.NoError(t, err)
+	assert.False(t, res.Granted)
+	assert.Equal(t, LimitDomain, res.Bottleneck)
+	assert.Equal(t, time.Minute, res.RetryAfter)
+	snapshot, err = l.Inspect(ctx, scope)
+	require.NoError(t, err)
+	assert.Equal(t, LimitDomain, snapshot.Bottleneck)
+	mr.Del(fmt.Sprintf(keyBackoff, "org-1", "yahoo.com"))
+
+	// Without the override the pool's own IPs apply; it has a warmed IP,
+	// so the campaign's default 100/min is next.
+	mock.ExpectQuery("FROM mailing_ip_addresses").WithArgs("org-1", "warm-pool").
+		WillReturnRows(sqlmock.NewRows([]string{"active", "warmup"}).AddRow(1, 50))
+	require.NoError(t, l.SetLimit(ctx, "org-1", LimitIPPool, "warm-pool", nil))
+	snapshot, err = l.Inspect(ctx, scope)
+	require.NoError(t, err)
+	assert.Equal(t, LimitCampaign, snapshot.Bottleneck)
+	assert.Equal(t, 100, snapshot.NextMinute)
+
+	assert.Error(t, l.SetLimit(ctx, "org-1", LimitISP, "yahoo", []Bucket{{Rate: 0, Burst: 10}}))
+}
+
+func TestSendLimiter_ExpiringOverride(t *testing.T) {
+	l, mr, _, now := newTestSendLimiter(t)
+	ctx := context.Background()
+	scope := SendScope{OrgID: "org-1", Domain: "yahoo.com"}
+
+	// The override paces the ISP on top of its own limit, which is kept.
+	require.NoError(t, l.SetOverride(ctx, "org-1", LimitISP, "yahoo", []Bucket{WindowBucket(60, time.Minute)}, time.Minute))
+	snapshot, err := l.Inspect(ctx, scope)
+	require.NoError(t, err)
+	isp := levelState(t, snapshot, LimitISP)
+	assert.Len(t, isp.Buckets, 4)
+	assert.Equal(t, 60, isp.Available)
+	assert.False(t, mr.Exists(fmt.Sprintf(keySendLimit, "org-1", LimitISP, "yahoo")))
+
+	// Once it lapses the ISP's own limit applies alone.
+	mr.FastForward(2 * time.Minute)
+	*now = now.Add(2 * time.Minute)
+	snapshot, err = l.Inspect(ctx, scope)
+	require.NoError(t, err)
+	assert.Equal(t, 400, levelState(t, snapshot, LimitISP).Available)
+
+	assert.Error(t, l.SetOverride(ctx, "org-1", LimitISP, "yahoo", []Bucket{WindowBucket(60, time.Minute)}, 0))
+}
+
+func TestSendLimiter_ISPFromOrgResolver(t *testing.T) {
+	isp.ForOrg("org-isp").SetOverride("corp.example", isp.Microsoft)
+	t.Cleanup(func() { isp.ForOrg("org-isp").RemoveOverride("corp.example") })
+
+	assert.Equal(t, isp.Microsoft, SendScope{OrgID: "org-isp", Domain: "corp.example"}.id(LimitISP))
+	assert.Empty(t, SendScope{OrgID: "org-other", Domain: "corp.example"}.id(LimitISP), "overrides stay in their org")
+	assert.Equal(t, isp.Yahoo, SendScope{OrgID: "org-isp", Domain: "aol.com"}.id(LimitISP))
+}
+
+func TestSendLimiter_PrunesExpiredCache(t *testing.T) {
+	l, _, _, now := newTestSendLimiter(t)
+	l.store("a", cachedSendLimit{})
+	l.store("b", cachedSendLimit{})
+
+	*now = now.Add(l.cacheTTL)
+	l.store("c", cachedSendLimit{})
+	assert.Len(t, l.cache, 1)
+	_, ok := l.cached("c")
+	assert.True(t, ok)
+}
+
+func TestSendLimiter_ResolvesSendingProfile(t *testing.T) {
+	l, _, mock, _ := newTestSendLimiter(t)
+	ctx := context.Background()
+
+	mock.ExpectQuery("FROM mailing_sending_profiles").WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id", "vendor_type", "ip_pool", "hourly_limit", "daily_limit"}).
+			AddRow("org-1", "ses", "warm", 1000, 10000))
+	mock.ExpectQuery("FROM mailing_ip_addresses").WithArgs("org-1", "warm").
+		WillReturnRows(sqlmock.NewRows([]string{"active", "warmup"}).AddRow(0, 200))
+
+	for i := 0; i < 2; i++ {
+		snapshot, err := l.Inspect(ctx, SendScope{ProfileID: "p1"})
+		require.NoError(t, err)
+		assert.Equal(t, SendScope{OrgID: "org-1", ESPType: "ses", ProfileID: "p1", IPPool: "warm"}, snapshot.Scope)
+		assert.Len(t, levelState(t, snapshot, LimitESP).Buckets, 3)
+		assert.Equal(t, 1000, levelState(t, snapshot, LimitProfile).Available)
+		assert.Equal(t, LimitIPPool, snapshot.Bottleneck)
+		assert.Equal(t, 200, snapshot.NextMinute)
+	}
+	require.NoError(t, mock.ExpectationsWereMet(), "profiles and pools are cached")
+}
+
+func TestSendLimiter_Admit(t *testing.T) {
+	l, _, _, _ := newTestSendLimiter(t)
+	ctx := context.Background()
+	scope := SendScope{OrgID: "org-1", Domain: "gmail.com", CampaignID: "c3"}
+
+	require.NoError(t, l.SetLimit(ctx, "org-1", LimitCampaign, "c3", []Bucket{{Rate: 1, Burst: 1}}))
+	assert.Zero(t, l.Admit(ctx, scope))
+	assert.Equal(t, time.Second, l.Admit(ctx, scope), "held until the next token")
+
+	require.NoError(t, l.SetLimit(ctx, "org-1", LimitCampaign, "c3", []Bucket{{Rate: 1, Burst: 0}}))
+	assert.Equal(t, time.Minute, l.Admit(ctx, scope), "a level that grants nothing holds sends back")
+
+	var none *SendLimiter
+	assert.Zero(t, none.Admit(ctx, scope))
+}
@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/logger"
+)
+
+// preferenceSkipReason returns why the subscriber's preference-center
+// choices rule out sending the campaign to email, or "" to send. Every send
+// path checks it before sending. Lookup errors fail open like the
+// suppression checks.
+func preferenceSkipReason(ctx context.Context, db *sql.DB, campaignID uuid.UUID, email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	hash := hex.EncodeToString(sum[:])
+
+	var unsubscribed, paused, topicOptOut bool
+	var frequencyCap, frequencyDays int
+	err := db.QueryRowContext(ctx, `
+		SELECT pref.unsubscribed_at IS NOT NULL,
+		       COALESCE(pref.paused_until > NOW(), false),
+		       COALESCE(c.topic_id = ANY(pref.opted_out_topics), false),
+		       pref.frequency_cap, pref.frequency_days
+		FROM mailing_campaigns c
+		JOIN mailing_subscriber_preferences pref
+		  ON pref.organization_id = c.organization_id AND pref.email_hash = $2
+		WHERE c.id = $1
+	`, campaignID, hash).Scan(&unsubscribed, &paused, &topicOptOut, &frequencyCap, &frequencyDays)
+	if err == sql.ErrNoRows {
+		return ""
+	}
+	if err != nil {
+		log.Printf("Preference check error for %s: %v", logger.RedactEmail(email), err)
+		return ""
+	}
+
+	switch {
+	case unsubscribed:
+		return "preference_unsubscribed"
+	case paused:
+		return "preference_paused"
+	case topicOptOut:
+		return "preference_topic_opt_out"
+	}
+	if frequencyCap <= 0 || frequencyDays <= 0 {
+		return ""
+	}
+
+	var sent int
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM mailing_message_log ml
+		JOIN mailing_campaigns c ON c.id = $1 AND ml.organization_id = c.organization_id
+		WHERE ml.email = $2 AND ml.sent_at > NOW() - make_interval(days => $3)
+	`, campaignID, email, frequencyDays).Scan(&sent); err != nil {
+		log.Printf("Frequency cap check error for %s: %v", logger.RedactEmail(email), err)
+		return ""
+	}
+	if sent >= frequencyCap {
+		return "preference_frequency_cap"
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreferenceSkipReason(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	item := QueueItem{CampaignID: uuid.New(), Email: "Jane@Example.com"}
+	cols := []string{"unsubscribed", "paused", "topic_opt_out", "frequency_cap", "frequency_days"}
+
+	mock.ExpectQuery("FROM mailing_campaigns c").WillReturnError(sql.ErrNoRows)
+	assert.Empty(t, preferenceSkipReason(ctx, db, item.CampaignID, item.Email), "no preferences row")
+
+	mock.ExpectQuery("FROM mailing_campaigns c").WillReturnRows(sqlmock.NewRows(cols).AddRow(false, true, false, 0, 0))
+	assert.Equal(t, "preference_paused", preferenceSkipReason(ctx, db, item.CampaignID, item.Email))
+
+	mock.ExpectQuery("FROM mailing_campaigns c").WillReturnRows(sqlmock.NewRows(cols).AddRow(false, false, true, 0, 0))
+	assert.Equal(t, "preference_topic_opt_out", preferenceSkipReason(ctx, db, item.CampaignID, item.Email))
+
+	mock.ExpectQuery("FROM mailing_campaigns c").WillReturnRows(sqlmock.NewRows(cols).AddRow(false, false, false, 1, 7))
+	mock.ExpectQuery("FROM mailing_message_log").WithArgs(item.CampaignID, item.Email, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	assert.Equal(t, "preference_frequency_cap", preferenceSkipReason(ctx, db, item.CampaignID, item.Email))
+
+	mock.ExpectQuery("FROM mailing_campaigns c").WillReturnRows(sqlmock.NewRows(cols).AddRow(false, false, false, 3, 7))
+	mock.ExpectQuery("FROM mailing_message_log").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	assert.Empty(t, preferenceSkipReason(ctx, db, item.CampaignID, item.Email), "under the cap")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
@@ -19,7 +20,10 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/ignite/sparkpost-monitor/internal/mailing"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/botdetect"
 	"github.com/ignite/sparkpost-monitor/internal/pkg/logger"
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
+	"github.com/ignite/sparkpost-monitor/internal/tracking"
 	"github.com/lib/pq"
 )
 
@@ -73,6 +77,15 @@ type SendWorkerPool struct {
 
 	profileTrackingDomainCache map[string]string // profileID -> resolved tracking base URL
 	ptdMu                      sync.RWMutex
+
+	// Exactly-once ledger; every send is recorded before submission
+	ledger *SendLedger
+
+	// Hierarchical send limiter; items it holds back are rescheduled
+	limiter *SendLimiter
+
+	// Assigns bandit A/B variants at send time; nil leaves them unassigned
+	variants *mailing.VariantSelector
 }
 
 // ESPSender interface for sending via different ESPs
@@ -95,8 +108,16 @@ type EmailMessage struct {
 	PreviewText  string // Pre-header text (injected as hidden span before <body> content)
 	ProfileID    string
 	ESPType      string
+	MessageID    string // Optional; pre-assigned Message-ID (without <>), e.g. from the send ledger
 	Metadata     map[string]interface{}
 	Headers      map[string]string // Custom SMTP headers (List-Unsubscribe, X-Job, etc.)
+	DKIMSelector string            // Optional; empty signs with the sending domain's active key
+}
+
+// DKIMSigner signs a raw message for its sending domain, returning it
+// unchanged when the domain has no key. mailing.DKIMKeyStore implements it.
+type DKIMSigner interface {
+	Sign(ctx context.Context, domain, selector string, message []byte) ([]byte, error)
 }
 
 // injectPreviewText prepends a hidden preheader span into the HTML body.
@@ -135,6 +156,7 @@ type SendResult struct {
 	Error     error
 	ESPType   string
 	SentAt    time.Time
+	Bounce    smtputil.Classification // set by the adapter when the ESP rejected the message
 }
 
 // QueueItem represents an item from the send queue
@@ -173,6 +195,20 @@ type QueueItem struct {
 
 	// Campaign metadata for template context
 	CampaignName string
+
+	// Send identity: the variant and PMTA wave this item was enqueued for
+	VariantName string
+	WaveID      string
+}
+
+// sendKey is the item's idempotency key.
+func (item QueueItem) sendKey() SendKey {
+	return SendKey{
+		CampaignID:   item.CampaignID.String(),
+		SubscriberID: item.SubscriberID.String(),
+		Variant:      item.VariantName,
+		WaveID:       item.WaveID,
+	}
 }
 
 // NewSendWorkerPool creates a new worker pool
@@ -187,6 +223,7 @@ func NewSendWorkerPool(db *sql.DB, numWorkers int) *SendWorkerPool {
 		numWorkers:   numWorkers,
 		batchSize:    100,                    // Claim 100 items per batch
 		pollInterval: 100 * time.Millisecond, // Poll frequently for low latency
+		ledger:       NewSendLedger(db),
 	}
 }
 
@@ -214,6 +251,18 @@ func (p *SendWorkerPool) SetPMTASender(sender ESPSender) {
 	p.pmtaSender = sender
 }
 
+// SetSendLimiter gates every send, including PMTA wave items, with the
+// hierarchical send limiter.
+func (p *SendWorkerPool) SetSendLimiter(limiter *SendLimiter) {
+	p.limiter = limiter
+}
+
+// SetVariantSelector assigns the variants of bandit A/B tests, which the
+// scheduler leaves unassigned, when each item is sent.
+func (p *SendWorkerPool) SetVariantSelector(vs *mailing.VariantSelector) {
+	p.variants = vs
+}
+
 // SetTrackingConfig configures tracking pixel/click/unsubscribe injection.
 func (p *SendWorkerPool) SetTrackingConfig(trackingURL, trackingSecret, orgID string) {
 	p.trackingURL = trackingURL
@@ -379,7 +428,7 @@ func (p *SendWorkerPool) claimBatch() ([]QueueItem, error) {
 		WITH claimed AS (
 			UPDATE mailing_campaign_queue
 			SET 
-				status = 'sending',
+				status = 'claimed',
 				worker_id = $1,
 				locked_at = NOW()
 			WHERE id IN (
@@ -395,7 +444,7 @@ func (p *SendWorkerPool) claimBatch() ([]QueueItem, error) {
 				LIMIT $2
 				FOR UPDATE SKIP LOCKED
 			)
-			RETURNING id, campaign_id, subscriber_id, subject, html_content, plain_content
+			RETURNING id, campaign_id, subscriber_id, subject, html_content, plain_content, variant_name, wave_id
 		)
 		SELECT 
 			c.id,
@@ -426,7 +475,9 @@ func (p *SendWorkerPool) claimBatch() ([]QueueItem, error) {
 			COALESCE(s.status, 'confirmed'),
 			COALESCE(s.source, ''),
 			COALESCE(s.subscribed_at, s.created_at),
-			COALESCE(camp.name, '')
+			COALESCE(camp.name, ''),
+			COALESCE(c.variant_name, ''),
+			COALESCE(c.wave_id::text, '')
 		FROM claimed c
 		JOIN mailing_subscribers s ON s.id = c.subscriber_id
 		JOIN mailing_campaigns camp ON camp.id = c.campaign_id
@@ -446,7 +497,7 @@ func (p *SendWorkerPool) claimISPForOne(ctx context.Context, campaignID, isp str
 	rows, err := p.db.QueryContext(ctx, `
 		WITH claimed AS (
 			UPDATE mailing_campaign_queue
-			SET status = 'sending', worker_id = $1, locked_at = NOW()
+			SET status = 'claimed', worker_id = $1, locked_at = NOW()
 			WHERE id IN (
 				SELECT q.id FROM mailing_campaign_queue q
 				JOIN mailing_campaigns camp ON camp.id = q.campaign_id
@@ -460,7 +511,7 @@ func (p *SendWorkerPool) claimISPForOne(ctx context.Context, campaignID, isp str
 				LIMIT $4
 				FOR UPDATE SKIP LOCKED
 			)
-			RETURNING id, campaign_id, subscriber_id, subject, html_content, plain_content
+			RETURNING id, campaign_id, subscriber_id, subject, html_content, plain_content, variant_name, wave_id
 		)
 		SELECT
 			c.id, c.campaign_id, c.subscriber_id,
@@ -475,7 +526,8 @@ func (p *SendWorkerPool) claimISPForOne(ctx context.Context, campaignID, isp str
 			s.last_open_at, s.last_click_at, s.last_email_at,
 			s.optimal_send_hour_utc, COALESCE(s.timezone, ''),
 			COALESCE(s.status, 'confirmed'), COALESCE(s.source, ''),
-			COALESCE(s.subscribed_at, s.created_at), COALESCE(camp.name, '')
+			COALESCE(s.subscribed_at, s.created_at), COALESCE(camp.name, ''),
+			COALESCE(c.variant_name, ''), COALESCE(c.wave_id::text, '')
 		FROM claimed c
 		JOIN mailing_subscribers s ON s.id = c.subscriber_id
 		JOIN mailing_campaigns camp ON camp.id = c.campaign_id
@@ -776,6 +828,8 @@ func (p *SendWorkerPool) scanQueueRows(rows *sql.Rows) ([]QueueItem, error) {
 			&item.SubscriberSource,
 			&item.SubscribedAt,
 			&item.CampaignName,
+			&item.VariantName,
+			&item.WaveID,
 		)
 		if err != nil {
 			log.Printf("SendWorkerPool: scan error: %v", err)
@@ -823,8 +877,47 @@ func (p *SendWorkerPool) processItem(item QueueItem) error {
 		return p.markSkipped(ctx, item.ID, "global_suppressed")
 	}
 
+	// Preference center: global opt-out, pause, topic opt-out, frequency cap
+	if reason := preferenceSkipReason(ctx, p.db, item.CampaignID, item.Email); reason != "" {
+		atomic.AddInt64(&p.totalSkipped, 1)
+		return p.markSkipped(ctx, item.ID, reason)
+	}
+
+	// Send limits: org, ESP, profile, ISP, domain and campaign in one reservation
+	if wait := p.limiter.Admit(ctx, SendScope{
+		ProfileID:  item.ProfileID,
+		ESPType:    item.ESPType,
+		Domain:     extractDomain(item.Email),
+		CampaignID: item.CampaignID.String(),
+	}); wait > 0 {
+		return p.deferItem(ctx, item.ID, wait)
+	}
+
+	// Bandit A/B tests pick the variant now, from the rewards seen so far
+	if item.VariantName == "" && p.variants != nil {
+		v, err := assignSendTimeVariant(ctx, p.db, p.variants, item.ID, item.CampaignID, item.SubscriberID, item.Email)
+		if err != nil {
+			log.Printf("[ISPDispatch] Variant assignment for item %s: %v", item.ID, err)
+			return p.deferItem(ctx, item.ID, time.Minute)
+		}
+		if v != nil {
+			item.VariantName, item.Subject, item.HTMLContent, item.TextContent = v.Name, v.Subject, v.HTMLContent, v.TextContent
+		}
+	}
+
+	// Token links open the preference center and accept RFC 8058 one-click
+	// POSTs. They are issued before rendering so templates get them as
+	// system.unsubscribe_url and system.preferences_url.
+	var unsubURL, prefsURL string
+	trackBase := p.resolveTrackingURL(ctx, item.ProfileID)
+	if trackBase != "" {
+		token := tracking.NewTokens(p.db, p.trackingSecret).Token(item.CampaignID, item.SubscriberID)
+		unsubURL = trackBase + "/unsubscribe/" + token
+		prefsURL = trackBase + "/preferences/" + token
+	}
+
 	// ── Personalization: full Liquid template engine with all subscriber data ──
-	renderCtx := p.buildRenderContext(item)
+	renderCtx := p.buildRenderContext(item, trackBase, unsubURL, prefsURL)
 	templateSvc := mailing.NewTemplateService()
 
 	subject, _ := templateSvc.Render("s:"+item.CampaignID.String(), item.Subject, renderCtx)
@@ -840,32 +933,27 @@ func (p *SendWorkerPool) processItem(item QueueItem) error {
 
 	// ── Tracking + Unsubscribe ──
 	headers := make(map[string]string)
-	var unsubURL string
-	trackBase := p.resolveTrackingURL(ctx, item.ProfileID)
 	if trackBase != "" {
 		htmlContent = p.injectTrackingPixelAndLinks(
 			htmlContent,
 			item.CampaignID.String(), item.SubscriberID.String(), item.ID.String(),
 			trackBase,
 		)
-		unsubURL = p.generateUnsubscribeURL(item.CampaignID.String(), item.SubscriberID.String(), trackBase)
 		headers["List-Unsubscribe"] = fmt.Sprintf("<%s>", unsubURL)
 		headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
 
-		// Replace {{ system.unsubscribe_url }} in body if present
+		// Replace {{ system.unsubscribe_url }} and {{ system.preferences_url }} left unrendered
 		htmlContent = strings.ReplaceAll(htmlContent, "{{ system.unsubscribe_url }}", unsubURL)
 		htmlContent = strings.ReplaceAll(htmlContent, "{{system.unsubscribe_url}}", unsubURL)
+		htmlContent = strings.ReplaceAll(htmlContent, "{{ system.preferences_url }}", prefsURL)
+		htmlContent = strings.ReplaceAll(htmlContent, "{{system.preferences_url}}", prefsURL)
 
 		// CAN-SPAM: if no unsub link exists in the body, inject one before </body>
-		if !strings.Contains(strings.ToLower(htmlContent), "/track/unsubscribe/") {
+		if !strings.Contains(strings.ToLower(htmlContent), "/unsubscribe/") {
 			unsubBlock := fmt.Sprintf(
 				`<div style="text-align:center;padding:16px;font-size:12px;color:#999;font-family:Arial,sans-serif;">`+
 					`<a href="%s" style="color:#999;text-decoration:underline;">Unsubscribe</a></div>`, unsubURL)
-			if idx := strings.LastIndex(strings.ToLower(htmlContent), "</body>"); idx >= 0 {
-				htmlContent = htmlContent[:idx] + unsubBlock + htmlContent[idx:]
-			} else {
-				htmlContent += unsubBlock
-			}
+			htmlContent = mailing.InsertBeforeBodyClose(htmlContent, unsubBlock)
 		}
 	}
 	headers["X-Job"] = item.CampaignID.String()
@@ -917,23 +1005,57 @@ func (p *SendWorkerPool) processItem(item QueueItem) error {
 		return p.markFailed(ctx, item.ID, "no sender configured for "+item.ESPType)
 	}
 
+	// Record the send before submitting it; a key already submitted or sent
+	// by an earlier delivery of this item is not sent again.
+	key := item.sendKey()
+	prior, err := p.ledger.Begin(ctx, QueueTableV1, item.ID, key, msg)
+	if errors.Is(err, ErrClaimLost) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("send ledger: %w", err)
+	}
+	if prior != nil {
+		atomic.AddInt64(&p.totalSkipped, 1)
+		log.Printf("[SendWorkerPool] DUPLICATE campaign=%s email=%s key=%s already %s",
+			item.CampaignID, logger.RedactEmail(item.Email), prior.Key, prior.State)
+		return p.markSkipped(ctx, item.ID, "duplicate_"+prior.State)
+	}
+
 	// Send the email
 	result, err := sender.Send(ctx, msg)
+	if err != nil && ambiguousSendError(err) {
+		// The ESP may have accepted it; recovery settles the item.
+		log.Printf("[SendWorkerPool] SEND UNCONFIRMED campaign=%s email=%s key=%s: %v",
+			item.CampaignID, logger.RedactEmail(item.Email), key, err)
+		return err
+	}
 	if err != nil || !result.Success {
 		atomic.AddInt64(&p.totalFailed, 1)
 		errMsg := "unknown error"
 		if err != nil {
 			errMsg = err.Error()
+		} else if result.Error != nil {
+			errMsg = result.Error.Error()
+		}
+		bounce := smtputil.Classify(errMsg)
+		if err == nil && result.Bounce.Category != "" {
+			bounce = result.Bounce
 		}
-		log.Printf("[SendWorkerPool] SEND FAILED campaign=%s email=%s esp=%s err=%s",
-			item.CampaignID, logger.RedactEmail(item.Email), item.ESPType, errMsg)
+		log.Printf("[SendWorkerPool] SEND FAILED campaign=%s email=%s esp=%s category=%s err=%s",
+			item.CampaignID, logger.RedactEmail(item.Email), item.ESPType, bounce.Category, errMsg)
 
-		p.recordBounce(ctx, item, errMsg)
+		if err := p.ledger.Fail(ctx, key, errMsg); err != nil {
+			log.Printf("[SendWorkerPool] send ledger fail %s: %v", key, err)
+		}
+		p.recordBounce(ctx, item, errMsg, bounce)
 		return p.markFailed(ctx, item.ID, errMsg)
 	}
 
 	// Mark as sent and update campaign stats
 	atomic.AddInt64(&p.totalSent, 1)
+	if err := p.ledger.Complete(ctx, key, result.MessageID); err != nil {
+		log.Printf("[SendWorkerPool] send ledger complete %s: %v", key, err)
+	}
 	if err := p.markSent(ctx, item, result.MessageID); err != nil {
 		log.Printf("Error marking sent: %v", err)
 	}
@@ -1024,6 +1146,9 @@ func (p *SendWorkerPool) markSent(ctx context.Context, item QueueItem, messageID
 	`, item.CampaignID, item.SubscriberID, sendingDomain); trackErr != nil {
 		log.Printf("[send_worker] tracking event INSERT failed for campaign=%s sub=%s: %v", item.CampaignID, item.SubscriberID, trackErr)
 	}
+	if err := mailing.RecordABSend(ctx, p.db, item.CampaignID, item.SubscriberID, item.VariantName); err != nil {
+		log.Printf("[send_worker] A/B send event for campaign=%s sub=%s: %v", item.CampaignID, item.SubscriberID, err)
+	}
 
 	return nil
 }
@@ -1057,11 +1182,11 @@ func (p *SendWorkerPool) markFailed(ctx context.Context, itemID uuid.UUID, errMs
 	return err
 }
 
-// recordBounce inserts a tracking event for the failed send and, for hard
-// bounces, adds the address to the global suppression hub so it is never
-// mailed again.
-func (p *SendWorkerPool) recordBounce(ctx context.Context, item QueueItem, errMsg string) {
-	bounceType := classifySendError(errMsg)
+// recordBounce inserts a tracking event for the failed send and, when the
+// classifier recommends it, adds the address to the global suppression hub
+// so it is never mailed again.
+func (p *SendWorkerPool) recordBounce(ctx context.Context, item QueueItem, errMsg string, bounce smtputil.Classification) {
+	bounceType := string(bounce.Type)
 	sendingDomain := ""
 	if atIdx := strings.LastIndex(item.FromEmail, "@"); atIdx >= 0 {
 		sendingDomain = strings.ToLower(item.FromEmail[atIdx+1:])
@@ -1082,17 +1207,17 @@ func (p *SendWorkerPool) recordBounce(ctx context.Context, item QueueItem, errMs
 	}
 
 	p.db.ExecContext(ctx, `SELECT update_campaign_stat($1, 'bounce_count', 1)`, item.CampaignID)
-	if bounceType == "hard" {
+	if bounce.Type == smtputil.BounceHard {
 		p.db.ExecContext(ctx, `SELECT update_campaign_stat($1, 'hard_bounce_count', 1)`, item.CampaignID)
 	} else {
 		p.db.ExecContext(ctx, `SELECT update_campaign_stat($1, 'soft_bounce_count', 1)`, item.CampaignID)
 	}
 
-	if bounceType == "hard" && p.globalSuppressor != nil {
+	if bounce.Action == smtputil.ActionSuppress && p.globalSuppressor != nil {
 		ispGroup := recipientDomain
 		if _, suppressErr := p.globalSuppressor.Suppress(
 			ctx, item.Email, "hard_bounce", "send_worker",
-			ispGroup, "", errMsg, "", item.CampaignID.String(),
+			ispGroup, bounce.Enhanced, errMsg, "", item.CampaignID.String(),
 		); suppressErr != nil {
 			log.Printf("[SendWorkerPool] global suppress error for %s: %v",
 				logger.RedactEmail(item.Email), suppressErr)
@@ -1100,23 +1225,6 @@ func (p *SendWorkerPool) recordBounce(ctx context.Context, item QueueItem, errMs
 	}
 }
 
-// classifySendError determines hard vs soft bounce from SMTP error text.
-func classifySendError(errMsg string) string {
-	lower := strings.ToLower(errMsg)
-	hardIndicators := []string{
-		"550", "551", "552", "553", "554",
-		"user unknown", "mailbox not found", "does not exist",
-		"no such user", "invalid recipient", "rejected",
-		"permanently", "disabled", "deactivated",
-	}
-	for _, ind := range hardIndicators {
-		if strings.Contains(lower, ind) {
-			return "hard"
-		}
-	}
-	return "soft"
-}
-
 // markSkipped marks a queue item as skipped
 func (p *SendWorkerPool) markSkipped(ctx context.Context, itemID uuid.UUID, reason string) error {
 	_, err := p.db.ExecContext(ctx, `
@@ -1127,6 +1235,17 @@ func (p *SendWorkerPool) markSkipped(ctx context.Context, itemID uuid.UUID, reas
 	return err
 }
 
+// deferItem returns a claimed item to the queue, due again after wait
+func (p *SendWorkerPool) deferItem(ctx context.Context, itemID uuid.UUID, wait time.Duration) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE mailing_campaign_queue
+		SET status = 'queued', worker_id = NULL, locked_at = NULL,
+		    scheduled_at = NOW() + $2::interval
+		WHERE id = $1 AND status = 'claimed'
+	`, itemID, wait.String())
+	return err
+}
+
 // registerWorker registers this worker in the database
 func (p *SendWorkerPool) registerWorker() {
 	p.db.Exec(`
@@ -1207,11 +1326,7 @@ func (p *SendWorkerPool) injectTrackingPixelAndLinks(html, campaignID, subscribe
 	sig := p.trackSign(encoded)
 
 	pixel := fmt.Sprintf(`<img src="%s/track/open/%s/%s" width="1" height="1" alt="" style="display:none;width:1px;height:1px" />`, baseURL, encoded, sig)
-	if idx := strings.LastIndex(strings.ToLower(html), "</body>"); idx >= 0 {
-		html = html[:idx] + pixel + html[idx:]
-	} else {
-		html += pixel
-	}
+	html = mailing.InsertBeforeBodyClose(html, pixel)
 
 	html = linkRe.ReplaceAllStringFunc(html, func(match string) string {
 		parts := linkRe.FindStringSubmatch(match)
@@ -1229,20 +1344,19 @@ func (p *SendWorkerPool) injectTrackingPixelAndLinks(html, campaignID, subscribe
 		return fmt.Sprintf(`href="%s/track/click/%s/%s"`, baseURL, linkEncoded, linkSig)
 	})
 
-	return html
-}
+	// Hidden honeypot link: only link scanners follow it.
+	hpEncoded := base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%s", data, botdetect.HoneypotURL)))
+	honeypot := botdetect.HoneypotAnchor(fmt.Sprintf("%s/track/click/%s/%s", baseURL, hpEncoded, p.trackSign(hpEncoded)))
+	html = mailing.InsertBeforeBodyClose(html, honeypot)
 
-func (p *SendWorkerPool) generateUnsubscribeURL(campaignID, subscriberID, baseURL string) string {
-	data := fmt.Sprintf("%s|%s|%s", p.orgID, campaignID, subscriberID)
-	encoded := base64.URLEncoding.EncodeToString([]byte(data))
-	sig := p.trackSign(encoded)
-	return fmt.Sprintf("%s/track/unsubscribe/%s/%s", baseURL, encoded, sig)
+	return html
 }
 
 // buildRenderContext constructs a full Liquid render context from a queue item,
 // matching the schema produced by mailing.ContextBuilder.BuildContext but built
 // from data already loaded in the claim query (no extra DB round-trips).
-func (p *SendWorkerPool) buildRenderContext(item QueueItem) mailing.RenderContext {
+// trackBase and the token links are empty when the send is not tracked.
+func (p *SendWorkerPool) buildRenderContext(item QueueItem, trackBase, unsubURL, prefsURL string) mailing.RenderContext {
 	rc := make(mailing.RenderContext)
 
 	// Top-level profile fields
@@ -1283,11 +1397,10 @@ func (p *SendWorkerPool) buildRenderContext(item QueueItem) mailing.RenderContex
 		"current_hour":    now.Hour(),
 		"timestamp":       now.Unix(),
 	}
-	if p.trackingURL != "" {
-		tBase := p.trackingURL
-		system["unsubscribe_url"] = p.generateUnsubscribeURL(item.CampaignID.String(), item.SubscriberID.String(), tBase)
-		system["preferences_url"] = fmt.Sprintf("%s/preferences?sid=%s", tBase, item.SubscriberID.String())
-		system["view_in_browser_url"] = fmt.Sprintf("%s/view?cid=%s&sid=%s", tBase, item.CampaignID.String(), item.SubscriberID.String())
+	if trackBase != "" {
+		system["unsubscribe_url"] = unsubURL
+		system["preferences_url"] = prefsURL
+		system["view_in_browser_url"] = fmt.Sprintf("%s/view?cid=%s&sid=%s", trackBase, item.CampaignID.String(), item.SubscriberID.String())
 	}
 	rc["system"] = system
 	rc["now"] = now
@@ -5,6 +5,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -44,11 +45,10 @@ type BatchSendWorker struct {
 	db          *sql.DB
 	redis       *redis.Client
 	workerID    string
-	rateLimiter *RateLimiter
 
-	// Advanced throttle manager for per-domain/per-ISP rate limiting
-	advancedThrottle *AdvancedThrottleManager
-	orgID            string // Organization ID for throttling
+	// Hierarchical send limiter; gates every batch
+	limiter *SendLimiter
+	orgID   string // Organization ID for send limits
 
 	// Batch senders for each ESP
 	sparkPostSender BatchESPSender
@@ -103,6 +103,7 @@ type BatchQueueItem struct {
 type BatchItemResult struct {
 	ID        uuid.UUID
 	Success   bool
+	Skipped   bool // not sent on purpose; ErrorCode holds the reason
 	MessageID string
 	ErrorCode string
 	Error     error
@@ -255,7 +256,7 @@ func (g *BatchGrouper) ValidateBatch(messages []BatchQueueItem, espType string)
 
 // NewBatchSendWorker creates a new batch send worker
 func NewBatchSendWorker(db *sql.DB, redisClient *redis.Client) *BatchSendWorker {
-	return &BatchSendWorker{
+	w := &BatchSendWorker{
 		db:           db,
 		redis:        redisClient,
 		workerID:     fmt.Sprintf("batch-worker-%s", uuid.New().String()[:8]),
@@ -265,16 +266,16 @@ func NewBatchSendWorker(db *sql.DB, redisClient *redis.Client) *BatchSendWorker
 		numWorkers:   4,                        // 4 concurrent batch processors
 		contentCache: make(map[string]*CampaignContent),
 	}
+	if redisClient != nil {
+		w.limiter = NewSendLimiter(redisClient, db)
+	}
+	return w
 }
 
-// SetRateLimiter sets the rate limiter
-func (w *BatchSendWorker) SetRateLimiter(rl *RateLimiter) {
-	w.rateLimiter = rl
-}
-
-// SetAdvancedThrottle sets the advanced throttle manager for per-domain/per-ISP throttling
-func (w *BatchSendWorker) SetAdvancedThrottle(throttle *AdvancedThrottleManager, orgID string) {
-	w.advancedThrottle = throttle
+// SetSendLimiter replaces the worker's own send limiter with a shared one
+// and sets the organization its sends count against.
+func (w *BatchSendWorker) SetSendLimiter(limiter *SendLimiter, orgID string) {
+	w.limiter = limiter
 	w.orgID = orgID
 }
 
@@ -388,81 +389,26 @@ func (w *BatchSendWorker) processBatch(ctx context.Context, workerNum int) error
 
 	log.Printf("[BatchWorker %d] Claimed %d items", workerNum, len(items))
 
-	// 2. Group by ESP type
-	espGroups := w.groupByESP(items)
+	// 2. Drop recipients whose preferences rule the send out, then group by ESP type
+	sendable, allResults := w.filterPreferences(ctx, items)
+	espGroups := w.groupByESP(sendable)
 
 	// 3. Send each group using appropriate batch sender
-	var allResults []BatchItemResult
 
 	for espType, groupItems := range espGroups {
 		// Split into optimal batch sizes for this ESP
 		batches := w.batchGrouper.GroupIntoBatches(groupItems, espType)
 
 		for _, batch := range batches {
-			// Check rate limit before sending
-			if w.rateLimiter != nil {
-				allowed, waitTime, err := w.rateLimiter.CheckAndIncrement(ctx, espType, len(batch))
-				if err != nil {
-					log.Printf("[BatchWorker %d] Rate limit error for %s: %v", workerNum, espType, err)
-				}
-				if !allowed && waitTime > 0 {
-					// Return items to queue and wait
-					w.returnItemsToQueue(ctx, batch)
-					time.Sleep(waitTime)
-					continue
-				}
-			}
-
-			// Check advanced throttle (per-domain/per-ISP) before sending
-			if w.advancedThrottle != nil {
-				var allowedItems []BatchQueueItem
-				var throttledItems []BatchQueueItem
-
-				for _, item := range batch {
-					allowed, reason, err := w.advancedThrottle.CanSend(ctx, w.orgID, item.Email)
-					if err != nil {
-						log.Printf("[BatchWorker %d] Throttle check error for %s: %v", workerNum, logger.RedactEmail(item.Email), err)
-						allowed = true // Allow on error to avoid blocking
-					}
-					if allowed {
-						allowedItems = append(allowedItems, item)
-					} else {
-						throttledItems = append(throttledItems, item)
-						log.Printf("[BatchWorker %d] Throttled: %s (%s)", workerNum, item.Email, reason)
-						atomic.AddInt64(&w.totalSkipped, 1)
-					}
-				}
-
-				// Return throttled items to queue for later retry
-				if len(throttledItems) > 0 {
-					w.returnItemsToQueue(ctx, throttledItems)
-				}
-
-				// Continue with allowed items only
-				if len(allowedItems) == 0 {
-					continue
-				}
-				batch = allowedItems
+			// Reserve the whole batch across every send limit level
+			batch = w.reserveBatch(ctx, workerNum, espType, batch)
+			if len(batch) == 0 {
+				continue
 			}
 
 			// Send batch via appropriate sender
 			results := w.sendBatch(ctx, espType, batch)
 			allResults = append(allResults, results...)
-
-			// Record successful sends with advanced throttle
-			if w.advancedThrottle != nil {
-				for _, result := range results {
-					if result.Success {
-						// Find the email for this result
-						for _, item := range batch {
-							if item.ID == result.ID {
-								w.advancedThrottle.RecordSend(ctx, w.orgID, item.Email)
-								break
-							}
-						}
-					}
-				}
-			}
 		}
 	}
 
@@ -721,6 +667,9 @@ func (w *BatchSendWorker) updateQueueItems(ctx context.Context, results []BatchI
 			statuses[i] = "sent"
 			messageIDs[i] = r.MessageID
 			errorCodes[i] = ""
+		} else if r.Skipped {
+			statuses[i] = "skipped"
+			errorCodes[i] = r.ErrorCode
 		} else {
 			statuses[i] = "failed"
 			messageIDs[i] = ""
@@ -831,6 +780,86 @@ func (w *BatchSendWorker) batchLogMessages(ctx context.Context, results []BatchI
 	return nil
 }
 
+// filterPreferences splits claimed items into those to send and skipped
+// results for recipients whose preference-center choices rule the send out
+func (w *BatchSendWorker) filterPreferences(ctx context.Context, items []BatchQueueItem) ([]BatchQueueItem, []BatchItemResult) {
+	sendable := make([]BatchQueueItem, 0, len(items))
+	var skipped []BatchItemResult
+	for _, item := range items {
+		if reason := preferenceSkipReason(ctx, w.db, item.CampaignID, item.Email); reason != "" {
+			skipped = append(skipped, BatchItemResult{ID: item.ID, Skipped: true, ErrorCode: reason})
+			continue
+		}
+		sendable = append(sendable, item)
+	}
+	if len(skipped) > 0 {
+		atomic.AddInt64(&w.totalSkipped, int64(len(skipped)))
+	}
+	return sendable, skipped
+}
+
+// reserveBatch reserves send limiter tokens for a batch, one reservation
+// per campaign, profile and recipient domain. Items that are not granted
+// are returned to the queue.
+func (w *BatchSendWorker) reserveBatch(ctx context.Context, workerNum int, espType string, batch []BatchQueueItem) []BatchQueueItem {
+	if w.limiter == nil {
+		return batch
+	}
+	groups := make(map[SendScope][]BatchQueueItem)
+	var scopes []SendScope
+	for _, item := range batch {
+		scope := SendScope{
+			OrgID:      w.orgID,
+			ESPType:    espType,
+			ProfileID:  item.ProfileID,
+			Domain:     extractDomain(item.Email),
+			CampaignID: item.CampaignID.String(),
+		}
+		if _, ok := groups[scope]; !ok {
+			scopes = append(scopes, scope)
+		}
+		groups[scope] = append(groups[scope], item)
+	}
+
+	var allowed, deferred []BatchQueueItem
+	for _, scope := range scopes {
+		granted, rest := w.reserve(ctx, workerNum, scope, groups[scope])
+		allowed = append(allowed, granted...)
+		deferred = append(deferred, rest...)
+	}
+
+	if len(deferred) > 0 {
+		log.Printf("[BatchWorker %d] Send limits deferred %d of %d items", workerNum, len(deferred), len(batch))
+		atomic.AddInt64(&w.totalSkipped, int64(len(deferred)))
+		w.returnItemsToQueue(ctx, deferred)
+	}
+	return allowed
+}
+
+// reserve reserves tokens for items sharing a scope, halving groups that
+// are larger than some level's burst
+func (w *BatchSendWorker) reserve(ctx context.Context, workerNum int, scope SendScope, items []BatchQueueItem) (granted, deferred []BatchQueueItem) {
+	res, err := w.limiter.Reserve(ctx, scope, len(items))
+	switch {
+	case errors.Is(err, ErrExceedsBurst) && len(items) > 1:
+		half := len(items) / 2
+		granted, deferred = w.reserve(ctx, workerNum, scope, items[:half])
+		if len(deferred) > 0 {
+			return granted, append(deferred, items[half:]...)
+		}
+		more, rest := w.reserve(ctx, workerNum, scope, items[half:])
+		return append(granted, more...), rest
+	case errors.Is(err, ErrExceedsBurst):
+		return nil, items
+	case err != nil:
+		log.Printf("[BatchWorker %d] Send limiter error for %s: %v", workerNum, scope.Domain, err)
+		return items, nil // Allow on error to avoid blocking
+	case !res.Granted:
+		return nil, items
+	}
+	return items, nil
+}
+
 // returnItemsToQueue returns items to the queue for later processing
 func (w *BatchSendWorker) returnItemsToQueue(ctx context.Context, items []BatchQueueItem) error {
 	if len(items) == 0 {
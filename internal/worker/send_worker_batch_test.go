@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -9,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
 )
 
@@ -215,6 +217,34 @@ func TestBatchSendWorker_GroupByESP(t *testing.T) {
 	}
 }
 
+func TestBatchSendWorker_FilterPreferences(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	worker := &BatchSendWorker{db: db}
+
+	items := []BatchQueueItem{
+		{ID: uuid.New(), CampaignID: uuid.New(), Email: "paused@example.com"},
+		{ID: uuid.New(), CampaignID: uuid.New(), Email: "ok@example.com"},
+	}
+	cols := []string{"unsubscribed", "paused", "topic_opt_out", "frequency_cap", "frequency_days"}
+	mock.ExpectQuery("FROM mailing_campaigns c").WillReturnRows(sqlmock.NewRows(cols).AddRow(false, true, false, 0, 0))
+	mock.ExpectQuery("FROM mailing_campaigns c").WillReturnError(sql.ErrNoRows)
+
+	sendable, skipped := worker.filterPreferences(context.Background(), items)
+	if len(sendable) != 1 || sendable[0].ID != items[1].ID {
+		t.Errorf("sendable = %v, want only %s", sendable, items[1].ID)
+	}
+	if len(skipped) != 1 || skipped[0].ID != items[0].ID || !skipped[0].Skipped || skipped[0].ErrorCode != "preference_paused" {
+		t.Errorf("skipped = %+v", skipped)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
 // =============================================================================
 // SUBSTITUTION TESTS
 // =============================================================================
@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
@@ -17,6 +18,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ignite/sparkpost-monitor/internal/mailing"
 	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 )
@@ -43,8 +45,8 @@ type SendWorkerPoolV2 struct {
 	running         bool
 	mu              sync.RWMutex
 
-	// Rate limiter (optional)
-	rateLimiter     *RateLimiter
+	// Hierarchical send limiter (optional)
+	limiter         *SendLimiter
 
 	// Campaign content cache (reduces DB queries)
 	contentCache    map[string]*CampaignContent
@@ -58,6 +60,9 @@ type SendWorkerPoolV2 struct {
 
 	// Agent preprocessor (optional — enables AI-driven send decisions)
 	agentPreprocessor *AgentPreprocessor
+
+	// Exactly-once ledger; every send is recorded before submission
+	ledger          *SendLedger
 }
 
 // CampaignContent holds the static content for a campaign
@@ -82,6 +87,7 @@ type QueueItemV2 struct {
 	Email           string
 	SubstitutionData map[string]interface{}
 	Priority        int
+	VariantName     string
 }
 
 // AgentDecisionCache is the Redis-cached decision for a single recipient.
@@ -107,12 +113,13 @@ func NewSendWorkerPoolV2(db *sql.DB, numWorkers int) *SendWorkerPoolV2 {
 		pollInterval:  100 * time.Millisecond,
 		contentCache:  make(map[string]*CampaignContent),
 		profileSender: NewProfileBasedSender(db),
+		ledger:        NewSendLedger(db),
 	}
 }
 
-// SetRateLimiter sets the rate limiter
-func (p *SendWorkerPoolV2) SetRateLimiter(rl *RateLimiter) {
-	p.rateLimiter = rl
+// SetSendLimiter gates every send with the hierarchical send limiter
+func (p *SendWorkerPoolV2) SetSendLimiter(limiter *SendLimiter) {
+	p.limiter = limiter
 }
 
 // SetRedis sets the Redis client used for agent decision lookups
@@ -218,7 +225,7 @@ func (p *SendWorkerPoolV2) claimBatch() ([]QueueItemV2, error) {
 		WITH claimed AS (
 			UPDATE mailing_campaign_queue_v2
 			SET 
-				status = 'sending',
+				status = 'claimed',
 				worker_id = $1,
 				claimed_at = NOW()
 			WHERE id IN (
@@ -229,10 +236,10 @@ func (p *SendWorkerPoolV2) claimBatch() ([]QueueItemV2, error) {
 				LIMIT $2
 				FOR UPDATE SKIP LOCKED
 			)
-			RETURNING id, campaign_id, subscriber_id, email, substitution_data, priority
+			RETURNING id, campaign_id, subscriber_id, email, substitution_data, priority, variant_name
 		)
 		SELECT id, campaign_id, subscriber_id, email, 
-			   COALESCE(substitution_data, '{}')::text, priority
+			   COALESCE(substitution_data, '{}')::text, priority, COALESCE(variant_name, '')
 		FROM claimed
 	`, p.workerID, p.batchSize)
 
@@ -252,6 +259,7 @@ func (p *SendWorkerPoolV2) claimBatch() ([]QueueItemV2, error) {
 			&item.Email,
 			&subDataJSON,
 			&item.Priority,
+			&item.VariantName,
 		)
 		if err != nil {
 			continue
@@ -392,18 +400,20 @@ func (p *SendWorkerPoolV2) processItem(item QueueItemV2) error {
 		}
 	}
 
-	// Check rate limits if limiter is configured
-	if p.rateLimiter != nil {
-		allowed, waitTime, err := p.rateLimiter.CheckAndIncrement(ctx, content.ESPType, 1)
-		if err != nil {
-			log.Printf("[SendWorkerPoolV2] Rate limit error: %v", err)
-		}
-		if !allowed && waitTime > 0 {
-			// Return item to queue and wait
-			p.returnToQueue(ctx, item.ID)
-			time.Sleep(waitTime)
-			return nil
-		}
+	// Preference center: global opt-out, pause, topic opt-out, frequency cap
+	if reason := preferenceSkipReason(ctx, p.db, item.CampaignID, item.Email); reason != "" {
+		atomic.AddInt64(&p.totalSkipped, 1)
+		return p.markSkipped(ctx, item.ID, reason)
+	}
+
+	// Send limits: org, ESP, profile, ISP, domain and campaign in one reservation
+	if wait := p.limiter.Admit(ctx, SendScope{
+		ProfileID:  content.ProfileID,
+		ESPType:    content.ESPType,
+		Domain:     extractDomain(item.Email),
+		CampaignID: item.CampaignID.String(),
+	}); wait > 0 {
+		return p.returnToQueue(ctx, item.ID, wait)
 	}
 
 	// Merge substitution data into content (personalization)
@@ -428,10 +438,36 @@ func (p *SendWorkerPoolV2) processItem(item QueueItemV2) error {
 		Metadata:     item.SubstitutionData,
 	}
 
+	// Record the send before submitting it; a key already submitted or sent
+	// by an earlier delivery of this item is not sent again.
+	key := SendKey{
+		CampaignID:   item.CampaignID.String(),
+		SubscriberID: item.SubscriberID.String(),
+		Variant:      item.VariantName,
+	}
+	prior, err := p.ledger.Begin(ctx, QueueTableV2, item.ID, key, msg)
+	if errors.Is(err, ErrClaimLost) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("send ledger: %w", err)
+	}
+	if prior != nil {
+		atomic.AddInt64(&p.totalSkipped, 1)
+		log.Printf("[SendWorkerPoolV2] Duplicate send of %s skipped: key %s already %s",
+			logger.RedactEmail(item.Email), prior.Key, prior.State)
+		return p.markSkipped(ctx, item.ID, "duplicate_"+prior.State)
+	}
+
 	// Send using profile-based sender
 	result, err := p.profileSender.Send(ctx, msg)
+	if err != nil && ambiguousSendError(err) {
+		// The ESP may have accepted it; recovery settles the item.
+		log.Printf("[SendWorkerPoolV2] Send of %s unconfirmed, key %s: %v", logger.RedactEmail(item.Email), key, err)
+		return err
+	}
 	if err != nil {
 		atomic.AddInt64(&p.totalFailed, 1)
+		p.failLedger(ctx, key, err.Error())
 		return p.markFailed(ctx, item.ID, err.Error())
 	}
 
@@ -441,11 +477,15 @@ func (p *SendWorkerPoolV2) processItem(item QueueItemV2) error {
 		if result.Error != nil {
 			errMsg = result.Error.Error()
 		}
+		p.failLedger(ctx, key, errMsg)
 		return p.markFailed(ctx, item.ID, errMsg)
 	}
 
 	// Success
 	atomic.AddInt64(&p.totalSent, 1)
+	if err := p.ledger.Complete(ctx, key, result.MessageID); err != nil {
+		log.Printf("[SendWorkerPoolV2] Send ledger complete %s: %v", key, err)
+	}
 
 	// After successful send, mark agent decision as executed
 	if decision != nil && p.redis != nil {
@@ -460,7 +500,13 @@ func (p *SendWorkerPoolV2) processItem(item QueueItemV2) error {
 		}()
 	}
 
-	return p.markSent(ctx, item.ID, result.MessageID)
+	if err := p.markSent(ctx, item.ID, result.MessageID); err != nil {
+		return err
+	}
+	if err := mailing.RecordABSend(ctx, p.db, item.CampaignID, item.SubscriberID, item.VariantName); err != nil {
+		log.Printf("[SendWorkerPoolV2] A/B send event for item %s: %v", item.ID, err)
+	}
+	return nil
 }
 
 // sha256Hex returns the lowercase hex-encoded SHA-256 hash of s.
@@ -632,13 +678,21 @@ func (p *SendWorkerPoolV2) markFailed(ctx context.Context, id uuid.UUID, errorMs
 	return err
 }
 
-// returnToQueue returns an item to the queue for retry
-func (p *SendWorkerPoolV2) returnToQueue(ctx context.Context, id uuid.UUID) error {
+// failLedger releases a rejected send's key for the next attempt
+func (p *SendWorkerPoolV2) failLedger(ctx context.Context, key SendKey, reason string) {
+	if err := p.ledger.Fail(ctx, key, reason); err != nil {
+		log.Printf("[SendWorkerPoolV2] Send ledger fail %s: %v", key, err)
+	}
+}
+
+// returnToQueue returns a claimed item to the queue, due again after wait
+func (p *SendWorkerPoolV2) returnToQueue(ctx context.Context, id uuid.UUID, wait time.Duration) error {
 	_, err := p.db.ExecContext(ctx, `
 		UPDATE mailing_campaign_queue_v2
-		SET status = 'queued', worker_id = NULL, claimed_at = NULL
-		WHERE id = $1
-	`, id)
+		SET status = 'queued', worker_id = NULL, claimed_at = NULL,
+		    scheduled_at = NOW() + $2::interval
+		WHERE id = $1 AND status = 'claimed'
+	`, id, wait.String())
 	return err
 }
 
@@ -4,16 +4,22 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/ignite/sparkpost-monitor/internal/pkg/smtputil"
 )
 
 // WebhookReceiver handles incoming ESP webhook events
 // Designed for high-volume ingestion (10M+ events/day)
-// Events are written to a staging table and processed asynchronously
+// Events are written to a staging table and processed asynchronously.
+// Bounce events are classified with smtputil at ingestion time so the
+// staging row carries the normalized category and recommended action.
 type WebhookReceiver struct {
 	db         *sql.DB
 	insertStmt *sql.Stmt
@@ -28,8 +34,8 @@ type WebhookReceiver struct {
 func NewWebhookReceiver(db *sql.DB) (*WebhookReceiver, error) {
 	stmt, err := db.Prepare(`
 		INSERT INTO mailing_webhook_events 
-		(esp_type, event_type, message_id, payload, event_timestamp)
-		VALUES ($1, $2, $3, $4, $5)
+		(esp_type, event_type, message_id, payload, event_timestamp, bounce_category, bounce_action)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`)
 	if err != nil {
 		return nil, err
@@ -57,19 +63,24 @@ type SESEvent struct {
 
 // SNSMessage represents an AWS SNS notification wrapper
 type SNSMessage struct {
-	Type             string `json:"Type"`
-	SubscribeURL     string `json:"SubscribeURL"`
-	Message          string `json:"Message"`
-	MessageId        string `json:"MessageId"`
-	TopicArn         string `json:"TopicArn"`
+	Type         string `json:"Type"`
+	SubscribeURL string `json:"SubscribeURL"`
+	Message      string `json:"Message"`
+	MessageId    string `json:"MessageId"`
+	TopicArn     string `json:"TopicArn"`
 }
 
 // MailgunEvent represents a Mailgun webhook event
 type MailgunEvent struct {
 	EventData struct {
-		Event     string `json:"event"`
-		MessageID string `json:"message-id"`
-		Timestamp float64 `json:"timestamp"`
+		Event          string  `json:"event"`
+		MessageID      string  `json:"message-id"`
+		Timestamp      float64 `json:"timestamp"`
+		DeliveryStatus struct {
+			Code        int    `json:"code"`
+			Message     string `json:"message"`
+			Description string `json:"description"`
+		} `json:"delivery-status"`
 	} `json:"event-data"`
 }
 
@@ -78,6 +89,20 @@ type SendGridEvent struct {
 	Event       string `json:"event"`
 	SGMessageID string `json:"sg_message_id"`
 	Timestamp   int64  `json:"timestamp"`
+	Status      string `json:"status,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// bounceColumns runs a bounce diagnostic through the shared classifier and
+// returns the bounce_category and bounce_action column values. Events
+// without a diagnostic (deliveries, opens, clicks) get NULLs.
+func bounceColumns(status, diagnostic string) (sql.NullString, sql.NullString) {
+	if status == "" && diagnostic == "" {
+		return sql.NullString{}, sql.NullString{}
+	}
+	c := smtputil.ClassifyDSN(status, diagnostic, "")
+	return sql.NullString{String: string(c.Category), Valid: true},
+		sql.NullString{String: string(c.Action), Valid: true}
 }
 
 // HandleSparkPostWebhook processes SparkPost webhook batches
@@ -129,7 +154,13 @@ func (w *WebhookReceiver) HandleSparkPostWebhook(rw http.ResponseWriter, r *http
 
 			payload, _ := json.Marshal(data)
 
-			_, err := w.insertStmt.Exec("sparkpost", eventType, messageID, payload, timestamp)
+			var category, action sql.NullString
+			if eventType == "bounce" || eventType == "out_of_band" {
+				rawReason, _ := data["raw_reason"].(string)
+				category, action = bounceColumns("", rawReason)
+			}
+
+			_, err := w.insertStmt.Exec("sparkpost", eventType, messageID, payload, timestamp, category, action)
 			if err != nil {
 				atomic.AddInt64(&w.errors, 1)
 				log.Printf("[WebhookReceiver] SparkPost insert error: %v", err)
@@ -172,13 +203,18 @@ func (w *WebhookReceiver) HandleSESWebhook(rw http.ResponseWriter, r *http.Reque
 
 	// Parse the actual SES event from the SNS message
 	var sesNotification struct {
-		NotificationType string    `json:"notificationType"`
+		NotificationType string `json:"notificationType"`
 		Mail             struct {
 			MessageID string `json:"messageId"`
 		} `json:"mail"`
-		Bounce   *struct{} `json:"bounce,omitempty"`
+		Bounce *struct {
+			BouncedRecipients []struct {
+				Status         string `json:"status"`
+				DiagnosticCode string `json:"diagnosticCode"`
+			} `json:"bouncedRecipients"`
+		} `json:"bounce,omitempty"`
 		Complaint *struct{} `json:"complaint,omitempty"`
-		Delivery *struct{} `json:"delivery,omitempty"`
+		Delivery  *struct{} `json:"delivery,omitempty"`
 	}
 
 	if err := json.Unmarshal([]byte(snsMessage.Message), &sesNotification); err != nil {
@@ -190,7 +226,12 @@ func (w *WebhookReceiver) HandleSESWebhook(rw http.ResponseWriter, r *http.Reque
 	eventType := sesNotification.NotificationType
 	messageID := sesNotification.Mail.MessageID
 
-	_, err = w.insertStmt.Exec("ses", eventType, messageID, snsMessage.Message, time.Now())
+	var category, action sql.NullString
+	if b := sesNotification.Bounce; b != nil && len(b.BouncedRecipients) > 0 {
+		category, action = bounceColumns(b.BouncedRecipients[0].Status, b.BouncedRecipients[0].DiagnosticCode)
+	}
+
+	_, err = w.insertStmt.Exec("ses", eventType, messageID, snsMessage.Message, time.Now(), category, action)
 	if err != nil {
 		atomic.AddInt64(&w.errors, 1)
 		log.Printf("[WebhookReceiver] SES insert error: %v", err)
@@ -217,12 +258,24 @@ func (w *WebhookReceiver) HandleMailgunWebhook(rw http.ResponseWriter, r *http.R
 
 	timestamp := time.Unix(int64(event.EventData.Timestamp), 0)
 
+	var category, action sql.NullString
+	if event.EventData.Event == "failed" {
+		ds := event.EventData.DeliveryStatus
+		diag := ds.Message + " " + ds.Description
+		if ds.Code != 0 {
+			diag = fmt.Sprintf("%d %s", ds.Code, diag)
+		}
+		category, action = bounceColumns("", strings.TrimSpace(diag))
+	}
+
 	_, err = w.insertStmt.Exec(
 		"mailgun",
 		event.EventData.Event,
 		event.EventData.MessageID,
 		body,
 		timestamp,
+		category,
+		action,
 	)
 	if err != nil {
 		atomic.AddInt64(&w.errors, 1)
@@ -251,7 +304,12 @@ func (w *WebhookReceiver) HandleSendGridWebhook(rw http.ResponseWriter, r *http.
 		timestamp := time.Unix(event.Timestamp, 0)
 		payload, _ := json.Marshal(event)
 
-		_, err := w.insertStmt.Exec("sendgrid", event.Event, event.SGMessageID, payload, timestamp)
+		var category, action sql.NullString
+		if event.Event == "bounce" || event.Event == "deferred" {
+			category, action = bounceColumns(event.Status, event.Reason)
+		}
+
+		_, err := w.insertStmt.Exec("sendgrid", event.Event, event.SGMessageID, payload, timestamp, category, action)
 		if err != nil {
 			atomic.AddInt64(&w.errors, 1)
 		} else {
@@ -0,0 +1,21 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBounceColumns(t *testing.T) {
+	category, action := bounceColumns("5.1.1", "smtp; 550 5.1.1 user unknown")
+	assert.Equal(t, "bad-mailbox", category.String)
+	assert.Equal(t, "suppress", action.String)
+
+	category, action = bounceColumns("", "552 5.2.2 Mailbox full")
+	assert.Equal(t, "mailbox-full", category.String)
+	assert.Equal(t, "retry", action.String)
+
+	category, action = bounceColumns("", "")
+	assert.False(t, category.Valid)
+	assert.False(t, action.Valid)
+}
@@ -0,0 +1,24 @@
+-- 049: Bounce classification
+-- Stores the smtputil classifier's verdict (category + recommended action)
+-- on staged ESP webhook events so bounces can be split by cause instead of
+-- a bare hard/soft flag.
+
+CREATE TABLE IF NOT EXISTS mailing_webhook_events (
+    id BIGSERIAL PRIMARY KEY,
+    esp_type VARCHAR(20) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    message_id VARCHAR(255),
+    payload JSONB,
+    event_timestamp TIMESTAMPTZ,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed BOOLEAN NOT NULL DEFAULT FALSE,
+    processed_at TIMESTAMPTZ
+);
+
+ALTER TABLE mailing_webhook_events
+  ADD COLUMN IF NOT EXISTS bounce_category VARCHAR(32),
+  ADD COLUMN IF NOT EXISTS bounce_action VARCHAR(32);
+
+CREATE INDEX IF NOT EXISTS idx_mwe_unprocessed
+  ON mailing_webhook_events (received_at)
+  WHERE processed = FALSE;
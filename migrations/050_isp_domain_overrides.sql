@@ -0,0 +1,17 @@
+-- 050: ISP domain overrides
+-- Admin-editable domain -> ISP group pins for the shared ISP resolver
+-- (pkg/isp). Overrides win over the built-in provider domains and MX-based
+-- classification, and are reloaded into the resolver at startup.
+
+CREATE TABLE IF NOT EXISTS mailing_isp_domain_overrides (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id),
+    domain VARCHAR(255) NOT NULL,
+    isp VARCHAR(50) NOT NULL,
+    note TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE(organization_id, domain)
+);
+
@@ -0,0 +1,35 @@
+-- 051: Engine incidents
+-- Incidents opened by the governance Alerter. Repeat alerts that share a
+-- dedup key (alert_key) inside the routing window are folded into one row;
+-- acknowledgement is tracked alongside the incident report.
+
+CREATE TABLE IF NOT EXISTS mailing_engine_incidents (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id),
+    isp VARCHAR(50) NOT NULL,
+    trigger VARCHAR(100) NOT NULL,
+    severity VARCHAR(20),
+    alert_key VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active','acknowledged','resolved')),
+    trigger_metrics JSONB NOT NULL DEFAULT '{}',
+    affected_ips JSONB NOT NULL DEFAULT '[]',
+    affected_domains JSONB NOT NULL DEFAULT '[]',
+    dsn_samples JSONB NOT NULL DEFAULT '[]',
+    actions_taken JSONB NOT NULL DEFAULT '[]',
+    alert_count INTEGER NOT NULL DEFAULT 1,
+    started_at TIMESTAMPTZ NOT NULL,
+    detected_at TIMESTAMPTZ NOT NULL,
+    last_alert_at TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    acknowledged_at TIMESTAMPTZ,
+    acknowledged_by VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_engine_incidents_org_detected
+  ON mailing_engine_incidents (organization_id, detected_at DESC);
+CREATE INDEX IF NOT EXISTS idx_engine_incidents_open
+  ON mailing_engine_incidents (organization_id, status)
+  WHERE status <> 'resolved';
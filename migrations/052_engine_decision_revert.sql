@@ -0,0 +1,11 @@
+-- 052: Engine decision auto-revert
+-- Temporary decisions carry an expiry and/or a recovery condition. The
+-- orchestrator executes the inverse action once either is met and marks the
+-- original decision reverted with a reason.
+
+ALTER TABLE mailing_engine_decisions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE mailing_engine_decisions ADD COLUMN IF NOT EXISTS revert_condition JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_engine_decisions_revertible
+    ON mailing_engine_decisions(organization_id, created_at)
+    WHERE reverted_at IS NULL AND (expires_at IS NOT NULL OR revert_condition IS NOT NULL);
@@ -0,0 +1,17 @@
+-- 053: Engine shadow mode
+-- Rules and agents can run in shadow mode: they evaluate normally but their
+-- decisions are persisted with result 'shadow' and never reach the executor.
+
+ALTER TABLE mailing_engine_rules ADD COLUMN IF NOT EXISTS mode VARCHAR(10) NOT NULL DEFAULT 'live';
+ALTER TABLE mailing_engine_rules DROP CONSTRAINT IF EXISTS mailing_engine_rules_mode_check;
+ALTER TABLE mailing_engine_rules ADD CONSTRAINT mailing_engine_rules_mode_check
+    CHECK (mode IN ('live','shadow'));
+
+ALTER TABLE mailing_engine_agent_state ADD COLUMN IF NOT EXISTS shadow BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE mailing_engine_decisions DROP CONSTRAINT IF EXISTS mailing_engine_decisions_result_check;
+ALTER TABLE mailing_engine_decisions ADD CONSTRAINT mailing_engine_decisions_result_check
+    CHECK (result IN ('pending','applied','failed','reverted','skipped','shadow'));
+
+CREATE INDEX IF NOT EXISTS idx_engine_decisions_org_created
+    ON mailing_engine_decisions(organization_id, created_at);
@@ -0,0 +1,37 @@
+-- 054: PMTA fleet
+-- Registry of PowerMTA nodes with the source IPs and pools each carries.
+-- Engine decisions fan out to every node carrying the affected pool and
+-- record one result per node. Tracking events remember their node.
+
+CREATE TABLE IF NOT EXISTS mailing_pmta_nodes (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL,
+    name            VARCHAR(100) NOT NULL,
+    transport       VARCHAR(10) NOT NULL DEFAULT 'ssh' CHECK (transport IN ('ssh','http')),
+    host            VARCHAR(255) NOT NULL DEFAULT '',
+    ssh_port        INTEGER NOT NULL DEFAULT 22,
+    mgmt_url        TEXT NOT NULL DEFAULT '',
+    ips             TEXT[] NOT NULL DEFAULT '{}',
+    pools           TEXT[] NOT NULL DEFAULT '{}',
+    enabled         BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS mailing_engine_decision_node_results (
+    id              BIGSERIAL PRIMARY KEY,
+    organization_id UUID NOT NULL,
+    decision_id     UUID NOT NULL,
+    node_name       VARCHAR(100) NOT NULL,
+    action          VARCHAR(50) NOT NULL,
+    result          VARCHAR(10) NOT NULL CHECK (result IN ('applied','failed')),
+    error           TEXT,
+    duration_ms     BIGINT NOT NULL DEFAULT 0,
+    executed_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_decision_node_results_decision
+    ON mailing_engine_decision_node_results(organization_id, decision_id);
+
+ALTER TABLE mailing_tracking_events ADD COLUMN IF NOT EXISTS pmta_node VARCHAR(100);
@@ -0,0 +1,22 @@
+-- 055: Role-based access control
+-- Roles per user and organization live on users.role: viewer, marketer,
+-- deliverability-ops or admin. Legacy owner/user rows map to admin/marketer.
+-- Sensitive API actions are written to mailing_audit_log with the actor,
+-- request and outcome.
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+
+UPDATE users SET role = 'admin' WHERE role = 'owner';
+UPDATE users SET role = 'marketer' WHERE role = 'user';
+
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'viewer';
+ALTER TABLE users ADD CONSTRAINT users_role_check
+    CHECK (role IN ('viewer', 'marketer', 'deliverability-ops', 'admin'));
+
+ALTER TABLE mailing_audit_log ADD COLUMN IF NOT EXISTS actor_email VARCHAR(255);
+ALTER TABLE mailing_audit_log ADD COLUMN IF NOT EXISTS actor_role  VARCHAR(50);
+ALTER TABLE mailing_audit_log ADD COLUMN IF NOT EXISTS http_method VARCHAR(10);
+ALTER TABLE mailing_audit_log ADD COLUMN IF NOT EXISTS path        TEXT;
+ALTER TABLE mailing_audit_log ADD COLUMN IF NOT EXISTS status_code INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_audit_actor ON mailing_audit_log(organization_id, actor_email, created_at DESC);
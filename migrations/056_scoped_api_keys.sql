@@ -0,0 +1,13 @@
+-- 056: Scoped API keys
+-- Machine clients authenticate with "Authorization: Bearer ipk_..." keys.
+-- Only the SHA-256 of a key is stored; permissions holds its scopes such as
+-- ["subscribers:write", "suppressions:read"]. Each key has its own
+-- per-minute rate limit and can be revoked.
+
+ALTER TABLE api_keys ALTER COLUMN permissions SET DEFAULT '[]';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rate_limit_per_minute INTEGER NOT NULL DEFAULT 600;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS created_by VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_ip INET;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_org ON api_keys(organization_id, created_at DESC);
@@ -0,0 +1,5 @@
+-- 057: Automation step state
+-- wait_until_event steps record when they started waiting so the engine can
+-- look for the event since then and apply the step timeout.
+
+ALTER TABLE automation_executions ADD COLUMN IF NOT EXISTS step_started_at TIMESTAMPTZ;
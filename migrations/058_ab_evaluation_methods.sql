@@ -0,0 +1,22 @@
+-- 058: A/B evaluation methods
+-- Each test picks how the VariantEvaluator decides it: z_test (single
+-- fixed-horizon look), bayesian (probability to beat best and expected loss)
+-- or sequential (mSPRT, valid under continuous monitoring). Every evaluation
+-- run writes per-variant snapshots with the method's statistics. Tests that
+-- already exist keep the z-test they were started with; new ones default to
+-- sequential.
+
+ALTER TABLE mailing_ab_tests ADD COLUMN IF NOT EXISTS evaluation_method VARCHAR(20);
+UPDATE mailing_ab_tests SET evaluation_method = 'z_test' WHERE evaluation_method IS NULL;
+ALTER TABLE mailing_ab_tests ALTER COLUMN evaluation_method SET DEFAULT 'sequential';
+
+ALTER TABLE mailing_ab_tests DROP CONSTRAINT IF EXISTS mailing_ab_tests_evaluation_method_check;
+ALTER TABLE mailing_ab_tests ADD CONSTRAINT mailing_ab_tests_evaluation_method_check
+    CHECK (evaluation_method IN ('z_test', 'bayesian', 'sequential'));
+
+ALTER TABLE mailing_ab_result_snapshots ADD COLUMN IF NOT EXISTS evaluation_method VARCHAR(20);
+ALTER TABLE mailing_ab_result_snapshots ADD COLUMN IF NOT EXISTS prob_best         DECIMAL(5,4);
+ALTER TABLE mailing_ab_result_snapshots ADD COLUMN IF NOT EXISTS expected_loss     DECIMAL(10,6);
+ALTER TABLE mailing_ab_result_snapshots ADD COLUMN IF NOT EXISTS p_value           DECIMAL(10,8);
+ALTER TABLE mailing_ab_result_snapshots ADD COLUMN IF NOT EXISTS is_significant    BOOLEAN DEFAULT FALSE;
+ALTER TABLE mailing_ab_result_snapshots ADD COLUMN IF NOT EXISTS is_best           BOOLEAN DEFAULT FALSE;
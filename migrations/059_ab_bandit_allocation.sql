@@ -0,0 +1,20 @@
+-- 059: Bandit variant allocation
+-- Tests can shift traffic toward winning variants during the send with
+-- Thompson sampling or UCB, optimizing reward_metric (defaults to
+-- winner_metric). Every variant keeps at least exploration_floor of the
+-- traffic and holdout_percent of recipients keep the deterministic
+-- per-email assignment.
+
+ALTER TABLE mailing_ab_tests ADD COLUMN IF NOT EXISTS allocation_mode   VARCHAR(20) DEFAULT 'static';
+ALTER TABLE mailing_ab_tests ADD COLUMN IF NOT EXISTS reward_metric     VARCHAR(50);
+ALTER TABLE mailing_ab_tests ADD COLUMN IF NOT EXISTS exploration_floor DECIMAL(5,4) DEFAULT 0.05;
+ALTER TABLE mailing_ab_tests ADD COLUMN IF NOT EXISTS holdout_percent   INTEGER DEFAULT 10;
+
+ALTER TABLE mailing_ab_tests DROP CONSTRAINT IF EXISTS mailing_ab_tests_allocation_mode_check;
+ALTER TABLE mailing_ab_tests ADD CONSTRAINT mailing_ab_tests_allocation_mode_check
+    CHECK (allocation_mode IN ('static', 'thompson', 'ucb'));
+ALTER TABLE mailing_ab_tests DROP CONSTRAINT IF EXISTS mailing_ab_tests_holdout_percent_check;
+ALTER TABLE mailing_ab_tests ADD CONSTRAINT mailing_ab_tests_holdout_percent_check
+    CHECK (holdout_percent BETWEEN 0 AND 100);
+
+CREATE INDEX IF NOT EXISTS idx_ab_events_test_variant ON mailing_ab_events(test_id, variant_id, event_type);
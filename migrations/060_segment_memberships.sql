@@ -0,0 +1,38 @@
+-- 060: Incremental segment membership
+-- Segments in realtime or hybrid calculation mode keep per-subscriber
+-- membership current as subscriber events, profile edits and tracking events
+-- arrive. Every enter/exit transition is logged so journeys and automations
+-- can trigger on it.
+
+CREATE TABLE IF NOT EXISTS mailing_segment_memberships (
+    segment_id      UUID NOT NULL REFERENCES mailing_segments(id) ON DELETE CASCADE,
+    subscriber_id   UUID NOT NULL,
+    organization_id UUID NOT NULL,
+    is_member       BOOLEAN NOT NULL DEFAULT TRUE,
+    entered_at      TIMESTAMP WITH TIME ZONE,
+    exited_at       TIMESTAMP WITH TIME ZONE,
+    updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (segment_id, subscriber_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_segment_memberships_subscriber ON mailing_segment_memberships(subscriber_id);
+CREATE INDEX IF NOT EXISTS idx_segment_memberships_members ON mailing_segment_memberships(segment_id) WHERE is_member;
+
+CREATE TABLE IF NOT EXISTS mailing_segment_membership_events (
+    id              UUID PRIMARY KEY,
+    segment_id      UUID NOT NULL REFERENCES mailing_segments(id) ON DELETE CASCADE,
+    subscriber_id   UUID NOT NULL,
+    organization_id UUID NOT NULL,
+    event_type      VARCHAR(10) NOT NULL,
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE mailing_segment_membership_events DROP CONSTRAINT IF EXISTS mailing_segment_membership_events_type_check;
+ALTER TABLE mailing_segment_membership_events ADD CONSTRAINT mailing_segment_membership_events_type_check
+    CHECK (event_type IN ('entered', 'exited'));
+
+CREATE INDEX IF NOT EXISTS idx_segment_membership_events_segment ON mailing_segment_membership_events(segment_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_segment_membership_events_subscriber ON mailing_segment_membership_events(subscriber_id, created_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_segments_realtime ON mailing_segments(organization_id)
+    WHERE status = 'active' AND calculation_mode IN ('realtime', 'hybrid');
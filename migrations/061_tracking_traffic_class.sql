@@ -0,0 +1,16 @@
+-- 061: Bot and machine-open classification of tracking events
+-- Every open and click is classified as human, machine_open, mpp_proxy or
+-- scanner_click, with the reason codes that fired. is_machine_open stays in
+-- sync for opens so existing MPP reporting keeps working. Events recorded
+-- before classification count as human.
+
+ALTER TABLE mailing_tracking_events ADD COLUMN IF NOT EXISTS traffic_class VARCHAR(20) DEFAULT 'human';
+ALTER TABLE mailing_tracking_events ADD COLUMN IF NOT EXISTS bot_reasons TEXT[] DEFAULT '{}';
+
+ALTER TABLE mailing_tracking_events DROP CONSTRAINT IF EXISTS mailing_tracking_events_traffic_class_check;
+ALTER TABLE mailing_tracking_events ADD CONSTRAINT mailing_tracking_events_traffic_class_check
+    CHECK (traffic_class IN ('human', 'machine_open', 'mpp_proxy', 'scanner_click'));
+
+CREATE INDEX IF NOT EXISTS idx_mte_machine_traffic
+    ON mailing_tracking_events (campaign_id, event_type)
+    WHERE traffic_class <> 'human';
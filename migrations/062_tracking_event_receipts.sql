@@ -0,0 +1,20 @@
+-- 062: Idempotent tracking event processing
+-- The tracking bus delivers at least once (spool drain retries, SQS and
+-- Redis Streams redelivery), so the consumer records each event ID in the
+-- transaction that applies it and skips IDs it has already seen. Receipts
+-- are written as 'done'; a 'processing' claim is only ever taken over.
+-- Receipts are pruned after 15 days.
+
+CREATE TABLE IF NOT EXISTS mailing_tracking_event_receipts (
+    event_id UUID PRIMARY KEY,
+    event_type VARCHAR(20) NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'processing',
+    claimed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMPTZ
+);
+
+ALTER TABLE mailing_tracking_event_receipts DROP CONSTRAINT IF EXISTS mailing_tracking_event_receipts_state_check;
+ALTER TABLE mailing_tracking_event_receipts ADD CONSTRAINT mailing_tracking_event_receipts_state_check
+    CHECK (state IN ('processing', 'done'));
+
+CREATE INDEX IF NOT EXISTS idx_mter_claimed_at ON mailing_tracking_event_receipts (claimed_at);
@@ -0,0 +1,39 @@
+-- 063: Preference center and one-click unsubscribe
+-- Unsubscribe tokens carry the organization so the tracking service can
+-- resolve them without a join. Subscribers can opt down to a frequency cap,
+-- opt out of individual topics, pause for a number of days or unsubscribe
+-- globally; the send worker enforces mailing_subscriber_preferences.
+-- Each change is recorded in mailing_consent_records with a scope such as
+-- 'global', 'list:<id>', 'topic:<key>', 'frequency' or 'pause'.
+
+ALTER TABLE mailing_unsubscribe_tokens ADD COLUMN IF NOT EXISTS organization_id UUID;
+
+CREATE TABLE IF NOT EXISTS mailing_topics (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL,
+    key VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, key)
+);
+
+ALTER TABLE mailing_campaigns ADD COLUMN IF NOT EXISTS topic_id UUID REFERENCES mailing_topics(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS mailing_subscriber_preferences (
+    organization_id UUID NOT NULL,
+    email_hash VARCHAR(64) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    frequency_cap INTEGER NOT NULL DEFAULT 0,
+    frequency_days INTEGER NOT NULL DEFAULT 0,
+    paused_until TIMESTAMPTZ,
+    opted_out_topics UUID[] NOT NULL DEFAULT '{}',
+    unsubscribed_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, email_hash)
+);
+
+ALTER TABLE mailing_consent_records ADD COLUMN IF NOT EXISTS scope VARCHAR(100);
+ALTER TABLE mailing_consent_records ADD COLUMN IF NOT EXISTS details JSONB DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_consent_records_scope ON mailing_consent_records (organization_id, email_hash, scope);
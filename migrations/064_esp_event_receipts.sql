@@ -0,0 +1,16 @@
+-- 064: Unified ESP event ingestion
+-- SparkPost, SES, Mailgun, SendGrid, PMTA accounting and ARF feedback-loop
+-- webhooks are normalized into one event model (internal/espevents). Each
+-- event's key is recorded in the same transaction that updates campaign
+-- counters and subscribers, so provider retries never count an event twice.
+-- Receipts are pruned after 30 days.
+
+CREATE TABLE IF NOT EXISTS mailing_esp_event_receipts (
+    event_key VARCHAR(255) PRIMARY KEY,
+    provider VARCHAR(20) NOT NULL,
+    event_type VARCHAR(20) NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_meer_received_at ON mailing_esp_event_receipts (received_at);
+CREATE INDEX IF NOT EXISTS idx_message_log_email_lower ON mailing_message_log (LOWER(email), sent_at DESC);
@@ -0,0 +1,29 @@
+-- 065: Native DKIM signing and selector rotation
+-- The Go send path (PMTA SMTP, SES SMTP, test sends) signs with the active
+-- key of the sending domain (internal/pkg/dkim, mailing.DKIMKeyStore).
+-- Keys rotate on a schedule: a new selector is generated (pending),
+-- its public key is upserted into Route53 (published), and only once the
+-- TXT record resolves does it become the signing key (active). The
+-- previous key is retired but its DNS record is left in place so mail
+-- signed before the switch still verifies.
+
+ALTER TABLE mailing_dkim_keys
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS activated_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS retired_at TIMESTAMPTZ;
+
+-- Existing keys were generated and published by hand.
+UPDATE mailing_dkim_keys
+SET status = CASE WHEN active THEN 'active' ELSE 'retired' END,
+    activated_at = COALESCE(activated_at, created_at)
+WHERE activated_at IS NULL;
+
+-- New keys must be published before they sign.
+ALTER TABLE mailing_dkim_keys ALTER COLUMN status SET DEFAULT 'pending';
+
+ALTER TABLE mailing_dkim_keys DROP CONSTRAINT IF EXISTS chk_dkim_key_status;
+ALTER TABLE mailing_dkim_keys ADD CONSTRAINT chk_dkim_key_status
+    CHECK (status IN ('pending', 'published', 'active', 'retired'));
+
+CREATE INDEX IF NOT EXISTS idx_dkim_keys_domain_status ON mailing_dkim_keys (domain, status);
@@ -0,0 +1,20 @@
+-- 066: Adaptive per-ISP/IP send-rate controller
+-- engine.RateController runs an AIMD loop per ISP and per source IP on the
+-- live deferral rate, connection refusals and acceptance latency, capped at
+-- the ISP's max_msg_rate. Every adjustment is logged to the existing
+-- throttle adjustment log with the inputs it was based on. Rates are
+-- messages per minute; old/new_hourly_limit carry the same rate per hour.
+
+ALTER TABLE mailing_throttle_adjustment_log
+    ADD COLUMN IF NOT EXISTS isp VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS old_msgs_per_minute NUMERIC(10,2),
+    ADD COLUMN IF NOT EXISTS new_msgs_per_minute NUMERIC(10,2),
+    ADD COLUMN IF NOT EXISTS ceiling_msgs_per_minute NUMERIC(10,2),
+    ADD COLUMN IF NOT EXISTS attempts INTEGER,
+    ADD COLUMN IF NOT EXISTS deferral_rate DECIMAL(5,2),
+    ADD COLUMN IF NOT EXISTS refusal_rate DECIMAL(5,2),
+    ADD COLUMN IF NOT EXISTS accept_latency_ms BIGINT;
+
+-- target_type gains 'ip' (a source IP at one ISP).
+CREATE INDEX IF NOT EXISTS idx_throttle_adjustment_log_isp
+ON mailing_throttle_adjustment_log(isp, created_at DESC) WHERE isp IS NOT NULL;
@@ -0,0 +1,61 @@
+-- 067: Exactly-once sends
+-- Every send carries a deterministic idempotency key (campaign + subscriber
+-- + variant + wave) as X-Idempotency-Key and as its Message-ID. The key is
+-- written to mailing_send_ledger before the message is handed to the ESP,
+-- and queue rows move claimed -> submitting -> sent around the handoff. A
+-- row left in 'submitting' is ambiguous: the QueueRecoveryWorker marks it
+-- sent once the ledger, the ESP/PMTA accounting or mailing_message_log show
+-- the message ID was accepted, requeues it only on a recorded rejection, and
+-- otherwise parks it ('parked') for an operator after a grace period.
+
+CREATE TABLE IF NOT EXISTS mailing_send_ledger (
+    idempotency_key VARCHAR(64) PRIMARY KEY,
+    campaign_id UUID NOT NULL,
+    subscriber_id UUID NOT NULL,
+    variant_name VARCHAR(50),
+    wave_id UUID,
+    queue_table VARCHAR(50) NOT NULL,
+    queue_item_id UUID NOT NULL,
+    esp_type VARCHAR(20),
+    message_id VARCHAR(255) NOT NULL,
+    esp_message_id VARCHAR(255),
+    state VARCHAR(20) NOT NULL DEFAULT 'submitting'
+        CHECK (state IN ('submitting', 'sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 1,
+    last_error TEXT,
+    accounted_event VARCHAR(20),
+    accounted_at TIMESTAMPTZ,
+    submitted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_send_ledger_message_id ON mailing_send_ledger (message_id);
+CREATE INDEX IF NOT EXISTS idx_send_ledger_esp_message_id ON mailing_send_ledger (esp_message_id)
+    WHERE esp_message_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_send_ledger_submitting ON mailing_send_ledger (campaign_id, subscriber_id)
+    WHERE state = 'submitting';
+
+ALTER TABLE mailing_campaign_queue
+    ADD COLUMN IF NOT EXISTS variant_name VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(64);
+
+ALTER TABLE mailing_campaign_queue DROP CONSTRAINT IF EXISTS mailing_campaign_queue_status_check;
+ALTER TABLE mailing_campaign_queue ADD CONSTRAINT mailing_campaign_queue_status_check
+    CHECK (status IN ('queued', 'claimed', 'submitting', 'parked', 'sending', 'sent', 'failed', 'skipped', 'dead_letter'));
+
+CREATE INDEX IF NOT EXISTS idx_queue_idempotency_key ON mailing_campaign_queue (idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_queue_submitting ON mailing_campaign_queue (status)
+    WHERE status IN ('submitting', 'parked');
+
+ALTER TABLE mailing_campaign_queue_v2
+    ADD COLUMN IF NOT EXISTS variant_name VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(64);
+
+ALTER TABLE mailing_campaign_queue_v2 DROP CONSTRAINT IF EXISTS mailing_campaign_queue_v2_status_check;
+ALTER TABLE mailing_campaign_queue_v2 ADD CONSTRAINT mailing_campaign_queue_v2_status_check
+    CHECK (status IN ('queued', 'claimed', 'submitting', 'parked', 'sending', 'sent', 'failed', 'skipped', 'dead_letter'));
+
+CREATE INDEX IF NOT EXISTS idx_queue_v2_idempotency_key ON mailing_campaign_queue_v2 (idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,8 @@
+-- 068: Stateless unsubscribe tokens
+-- Unsubscribe and preference-center tokens now carry the campaign,
+-- subscriber and issue time under an HMAC, so sends no longer insert a row
+-- per message. mailing_unsubscribe_tokens keeps the legacy u1 tokens and
+-- records new tokens the first time they are used; they are longer than
+-- the old 64 characters.
+
+ALTER TABLE mailing_unsubscribe_tokens ALTER COLUMN token TYPE VARCHAR(128);
@@ -0,0 +1,7 @@
+-- 069: A/B event lookup
+-- Send workers record a 'send' event in mailing_ab_events for every
+-- variant they deliver, and opens and clicks are attributed to the variant
+-- by looking up the subscriber's send event.
+
+CREATE INDEX IF NOT EXISTS idx_ab_events_subscriber_send ON mailing_ab_events(subscriber_id, test_id)
+    WHERE event_type = 'send';
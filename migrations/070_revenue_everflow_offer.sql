@@ -0,0 +1,11 @@
+-- 070: Everflow offer on revenue attributions
+-- Segment conditions take offer_id as the Everflow offer id (the same id
+-- mailing_offer_encodings and mailing_campaigns.everflow_offer_id use).
+-- mailing_revenue_attributions.offer_id references mailing_offers and is not
+-- set for Everflow conversions, so the Everflow offer is stored alongside it.
+
+ALTER TABLE mailing_revenue_attributions
+    ADD COLUMN IF NOT EXISTS everflow_offer_id VARCHAR(20);
+
+CREATE INDEX IF NOT EXISTS idx_attributions_everflow_offer ON mailing_revenue_attributions(subscriber_id, everflow_offer_id)
+    WHERE everflow_offer_id IS NOT NULL;
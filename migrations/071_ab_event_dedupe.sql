@@ -0,0 +1,8 @@
+-- 071: A/B event dedupe
+-- Sends are recorded once per test and subscriber, and opens and clicks
+-- once per test, subscriber and event type, so the lookup behind each
+-- insert is indexed. Bandit tests (thompson/ucb) assign variants at send
+-- time; the scheduler leaves variant_name NULL on their queue rows.
+
+CREATE INDEX IF NOT EXISTS idx_ab_events_test_subscriber_type
+    ON mailing_ab_events(test_id, subscriber_id, event_type);
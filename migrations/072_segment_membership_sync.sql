@@ -0,0 +1,9 @@
+-- 072: Segment membership seeding
+-- memberships_synced_at records when a realtime segment's
+-- mailing_segment_memberships rows were last recomputed in full. Segments
+-- with no value (every existing segment, and segments just created or
+-- edited) are seeded by the segment refresh worker without emitting
+-- entered/exited events, so current members do not re-enter journeys and
+-- flows. Incremental updates skip a segment until it is seeded.
+
+ALTER TABLE mailing_segments ADD COLUMN IF NOT EXISTS memberships_synced_at TIMESTAMP WITH TIME ZONE;
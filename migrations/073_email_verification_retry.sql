@@ -0,0 +1,8 @@
+-- 073: Email verification retries
+-- Addresses whose verification is deferred (e.g. the recipient's MX
+-- greylisted the SMTP probe) are requeued: verification_retry_at holds them
+-- back until the provider may be asked again, and verification_deferrals
+-- counts the deferrals so the verifier can give up and score them unknown.
+
+ALTER TABLE mailing_subscribers ADD COLUMN IF NOT EXISTS verification_retry_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE mailing_subscribers ADD COLUMN IF NOT EXISTS verification_deferrals INTEGER NOT NULL DEFAULT 0;